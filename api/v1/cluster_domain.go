@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// DefaultClusterDomain is the DNS domain of clusters that do not configure
+// their own.
+const DefaultClusterDomain = "cluster.local"
+
+// NormalizeClusterDomain returns the given DNS domain of a cluster without a
+// trailing dot. An empty domain returns the built-in default.
+func NormalizeClusterDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return DefaultClusterDomain, nil
+	}
+	if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 {
+		return "", field.Invalid(field.NewPath("clusterDomain"), domain, strings.Join(errs, ", "))
+	}
+	return domain, nil
+}
+
+// ClusterDomainFromResolvConf returns the cluster domain from the search
+// domains the kubelet writes to the resolv.conf of pods, which include
+// svc.<cluster domain>. An empty string is returned if it is not found, such
+// as for pods that do not use the cluster DNS.
+func ClusterDomainFromResolvConf(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "search" {
+			continue
+		}
+		for _, search := range fields[1:] {
+			if domain, ok := strings.CutPrefix(strings.TrimSuffix(search, "."), "svc."); ok && domain != "" {
+				return domain
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNormalizeClusterDomain(t *testing.T) {
+	if _, err := NormalizeClusterDomain("Not_A_Domain"); err == nil {
+		t.Fatalf("expected an invalid domain to be rejected")
+	}
+	if got, err := NormalizeClusterDomain(""); err != nil || got != DefaultClusterDomain {
+		t.Fatalf("expected an empty domain to normalize to %q, got %q (%v)", DefaultClusterDomain, got, err)
+	}
+	domain, err := NormalizeClusterDomain("k8s.example.")
+	if err != nil {
+		t.Fatalf("normalize cluster domain: %v", err)
+	}
+	if domain != "k8s.example" {
+		t.Fatalf("expected the trailing dot to be trimmed, got %q", domain)
+	}
+	group := &NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"}}
+	peering := &MeshPeering{ObjectMeta: metav1.ObjectMeta{Name: "peering", Namespace: "default"}}
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	if got, want := MeshNodeClusterFQDN(mesh, group, 0, domain), "mesh-group-0.mesh-group.default.svc.k8s.example"; got != want {
+		t.Errorf("expected node FQDN %s, got %s", want, got)
+	}
+	if got, want := MeshPeeringBridgeServiceFQDN(peering, domain), "peering-bridge.default.svc.k8s.example"; got != want {
+		t.Errorf("expected bridge FQDN %s, got %s", want, got)
+	}
+}
+
+func TestClusterDomainFromResolvConf(t *testing.T) {
+	tc := []struct {
+		name       string
+		resolvConf string
+		domain     string
+	}{
+		{
+			name:       "cluster dns",
+			resolvConf: "search webmesh.svc.k8s.example svc.k8s.example k8s.example\nnameserver 10.96.0.10\noptions ndots:5\n",
+			domain:     "k8s.example",
+		},
+		{
+			name:       "default domain",
+			resolvConf: "nameserver 10.96.0.10\nsearch default.svc.cluster.local svc.cluster.local. cluster.local\n",
+			domain:     "cluster.local",
+		},
+		{
+			name:       "host dns",
+			resolvConf: "search example.com\nnameserver 1.1.1.1\n",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClusterDomainFromResolvConf([]byte(tt.resolvConf)); got != tt.domain {
+				t.Errorf("expected domain %q, got %q", tt.domain, got)
+			}
+		})
+	}
+}
@@ -16,23 +16,71 @@ limitations under the License.
 
 package v1
 
+import "time"
+
 const (
 	// DefaultNodeImage is the default image to use for nodes.
 	DefaultNodeImage = "ghcr.io/webmeshproj/node:latest"
 	// DefaultNodeProxyImage is the default image to use for node proxies.
 	DefaultNodeProxyImage = "ghcr.io/webmeshproj/node-proxy:latest"
+	// DefaultMetricsProxyImage is the default image to use for the sidecar
+	// serving node metrics over TLS.
+	DefaultMetricsProxyImage = "gcr.io/kubebuilder/kube-rbac-proxy:v0.13.1"
+	// DefaultMetricsUpstreamAddress is the loopback address nodes serve metrics
+	// on when they are proxied over TLS.
+	DefaultMetricsUpstreamAddress = "127.0.0.1:8079"
+	// DefaultMeshDomain is the default domain to use for the mesh.
+	DefaultMeshDomain = "webmesh.internal"
 	// DefaultRaftPort is the default port to use for Raft.
 	DefaultRaftPort = 9443
 	// DefaultGRPCPort is the default port to use for gRPC.
 	DefaultGRPCPort = 8443
 	// DefaultWireGuardPort is the default port to use for WireGuard.
 	DefaultWireGuardPort = 51820
+	// DefaultMeshDNSPort is the default port to use for MeshDNS.
+	DefaultMeshDNSPort = 5353
 	// DefaultStorageSize is the default storage size to use for nodes.
 	DefaultStorageSize = "1Gi"
 	// DefaultDataDirectory is the default data directory to use for nodes.
 	DefaultDataDirectory = "/data"
+	// DefaultConfigDirectory is the directory the node config is mounted at.
+	DefaultConfigDirectory = "/etc/webmesh"
+	// ConfigFileName is the name of the node config file, and the key it is
+	// stored under in ConfigMaps and Secrets.
+	ConfigFileName = "config.yaml"
+	// DefaultConfigPath is the path of the node config file.
+	DefaultConfigPath = DefaultConfigDirectory + "/" + ConfigFileName
+	// ReplicaConfigPath is the path of the node config file of groups
+	// rendering a config per replica. It is expanded from the POD_NAME
+	// environment variable by the kubelet.
+	ReplicaConfigPath = DefaultConfigDirectory + "/$(POD_NAME).yaml"
 	// DefaultTLSDirectory is the default TLS directory to use for nodes.
-	DefaultTLSDirectory = "/etc/webmesh/tls"
+	DefaultTLSDirectory = DefaultConfigDirectory + "/tls"
+	// OperatorDefaultsConfigMapName is the name of the ConfigMap the operator
+	// publishes its effective defaults in, in its own namespace.
+	OperatorDefaultsConfigMapName = "webmesh-operator-defaults"
+	// OperatorConfigName is the name of the WebmeshOperatorConfig read in
+	// each namespace. Configs with other names are ignored.
+	OperatorConfigName = "default"
+	// MinAccessRequestDuration is the shortest access that may be requested.
+	// It is the shortest duration cert-manager issues certificates for.
+	MinAccessRequestDuration = time.Hour
+	// DefaultMaxAccessRequestDuration is the default longest access that may
+	// be requested.
+	DefaultMaxAccessRequestDuration = 24 * time.Hour
+	// DefaultCIDRUsageInterval is the default interval between reports of
+	// the mesh IPv4 addresses allocated to each node group.
+	DefaultCIDRUsageInterval = 5 * time.Minute
+	// MinCIDRUsageInterval is the shortest interval between reports of the
+	// allocated mesh IPv4 addresses.
+	MinCIDRUsageInterval = time.Minute
+	// DefaultCIDRUsageThreshold is the default utilization of the mesh IPv4
+	// CIDR, in percent, at which the mesh is reported to be running out of
+	// addresses.
+	DefaultCIDRUsageThreshold = 80
+	// DefaultDockerBridgeCIDR is the default address and prefix length of
+	// the docker0 bridge of instances running nodes.
+	DefaultDockerBridgeCIDR = "192.168.254.1/24"
 	// FieldOwner is the field owner to use for all resources.
 	FieldOwner = "webmesh-operator"
 	// MeshNameLabel is the label to use for the Mesh name.
@@ -43,9 +91,25 @@ const (
 	NodeGroupNameLabel = "webmesh.io/nodegroup-name"
 	// NodeGroupNamespaceLabel is the label to use for the NodeGroup namespace.
 	NodeGroupNamespaceLabel = "webmesh.io/nodegroup-namespace"
+	// MeshPeeringNameLabel is the label to use for the MeshPeering name.
+	MeshPeeringNameLabel = "webmesh.io/meshpeering-name"
+	// MeshPeeringNamespaceLabel is the label to use for the MeshPeering namespace.
+	MeshPeeringNamespaceLabel = "webmesh.io/meshpeering-namespace"
+	// MeshAccessRequestNameLabel is the label to use for the MeshAccessRequest name.
+	MeshAccessRequestNameLabel = "webmesh.io/meshaccessrequest-name"
+	// MeshAccessRequestNamespaceLabel is the label to use for the MeshAccessRequest namespace.
+	MeshAccessRequestNamespaceLabel = "webmesh.io/meshaccessrequest-namespace"
 	// ConfigChecksumAnnotation is the annotation to use for configmap checksums.
 	ConfigChecksumAnnotation = "webmesh.io/config-checksum"
-	// SpecChecksumAnnotation is the annotation to use for spec checksums.
+	// ClientCertificateNotAfterAnnotation is placed on the generated config
+	// Secrets of a Mesh with the expiry of the client certificate embedded
+	// in the config, in RFC 3339 format.
+	ClientCertificateNotAfterAnnotation = "webmesh.io/client-cert-not-after"
+	// MeshGenerationAnnotation is placed on the generated config Secrets of a
+	// Mesh with the generation of the Mesh they were last rendered from.
+	MeshGenerationAnnotation = "webmesh.io/mesh-generation"
+	// SpecChecksumAnnotation is the annotation to use for spec checksums. It holds
+	// the checksum of the object last applied by the operator.
 	SpecChecksumAnnotation = "webmesh.io/spec-checksum"
 	// BootstrapNodeGroupAnnotation is the annotation to use for bootstrap node groups.
 	// This should only be set by the controller for bootstrap node groups. It is also
@@ -54,6 +118,109 @@ const (
 	// ZoneAwarenessLabel is a label placed on NodeGroups to override the default
 	// zone awareness behavior.
 	ZoneAwarenessLabel = "webmesh.io/zone-awareness"
+	// ScheduleOverrideAnnotation is placed on NodeGroups to override their
+	// schedule. The value is either "Running" or "Suspended".
+	ScheduleOverrideAnnotation = "webmesh.io/schedule-override"
+	// SkipPreflightAnnotation is placed on NodeGroups with the value "true" to
+	// apply their objects without first checking that all of them would be
+	// admitted.
+	SkipPreflightAnnotation = "webmesh.io/skip-preflight"
+	// AcceptConfigChecksumAnnotation is placed on NodeGroups with the value
+	// "true" to accept the config checksums of their deployed nodes for the
+	// current config without replacing the nodes, such as when an operator
+	// upgrade changes how configs are rendered. It is removed once accepted.
+	AcceptConfigChecksumAnnotation = "webmesh.io/accept-config-checksum"
+	// MaintenanceAnnotation is placed on cluster NodeGroups with a comma
+	// separated list of the ordinals of the replicas to cordon from the mesh,
+	// such as "2,4". Cordoned nodes are restored once they are removed from
+	// the list.
+	MaintenanceAnnotation = "webmesh.io/maintenance"
+	// PreRotateCertificatesAnnotation is placed on NodeGroups before a planned
+	// upgrade to renew the node certificates expiring within a threshold
+	// before the nodes are replaced. The value is the threshold as a duration,
+	// such as "720h", or "true" for DefaultPreRotationThreshold. The changes
+	// to the group are applied once the certificates are renewed, and the
+	// annotation is removed.
+	PreRotateCertificatesAnnotation = "webmesh.io/pre-rotate-certs"
+	// DefaultPreRotationThreshold is the remaining lifetime below which node
+	// certificates are renewed when PreRotateCertificatesAnnotation is "true".
+	DefaultPreRotationThreshold = 30 * 24 * time.Hour
+	// MeshProfileAnnotation is placed on Meshes by the defaulting webhook when
+	// their profile is expanded. It holds the fields of the spec set by the
+	// profile.
+	MeshProfileAnnotation = "webmesh.io/profile-expansion"
+	// AccessRequestApprovedAnnotation is placed on MeshAccessRequests with the
+	// value "true" by an approver when the Mesh requires requests to be approved.
+	// Only users allowed to approve meshaccessrequests in the namespace of the
+	// Mesh may set it.
+	AccessRequestApprovedAnnotation = "webmesh.io/approved"
+	// NodeInServiceCondition is the pod condition of the readiness gate added
+	// to node pods of groups with a maintenance readiness gate. It is false
+	// while the node is cordoned.
+	NodeInServiceCondition = "webmesh.io/in-service"
+	// NodeTLSInjectionLabel is placed with the value "true" on node pods of
+	// groups with more than one replica. The pod webhook projects the
+	// certificate secret of the pod into its TLS volume when the pod is
+	// created, so each pod only mounts its own private key. Pods of single
+	// replica groups project their secret directly and do not need the webhook.
+	NodeTLSInjectionLabel = "webmesh.io/node-tls-injection"
 	// BootstrapNodeGroupLabel is the same value as BootstrapNodeGroupAnnotation.
 	BootstrapNodeGroupLabel = BootstrapNodeGroupAnnotation
 )
+
+// Paths written to instances by their cloud config, outside of
+// DefaultConfigDirectory. The cloudconfig package writes these paths, and
+// NodeGroups may not write files of their own to them.
+const (
+	// InstanceStateDirectory holds the state of the node and of the cloud
+	// config on instances.
+	InstanceStateDirectory = "/var/lib/webmesh"
+	// InstanceDataDirectory is the data directory of the node on instances.
+	InstanceDataDirectory = InstanceStateDirectory + "/data"
+	// InstanceSystemdUnitDirectory is the directory of the systemd units
+	// written to instances.
+	InstanceSystemdUnitDirectory = "/etc/systemd/system/"
+	// InstanceNodeUnitName is the name of the systemd unit running the node.
+	InstanceNodeUnitName = "node.service"
+	// InstanceDockerConfigPath is the path of the docker daemon config.
+	InstanceDockerConfigPath = "/etc/docker/daemon.json"
+	// InstanceHostsPath is the hosts file host aliases are appended to.
+	InstanceHostsPath = "/etc/hosts"
+	// InstanceEnvPath is the env file holding the secret options of the node.
+	InstanceEnvPath = "/etc/webmesh-node.env"
+	// InstanceCommandEnvPath is the env file holding the output of the
+	// commands of environment variables read when an instance boots.
+	InstanceCommandEnvPath = "/etc/webmesh-instance.env"
+	// InstanceCommandEnvScriptPath is the script writing InstanceCommandEnvPath
+	// on container linux instances.
+	InstanceCommandEnvScriptPath = "/etc/webmesh-instance-env.sh"
+	// InstanceCommandEnvUnitName is the systemd unit running
+	// InstanceCommandEnvScriptPath.
+	InstanceCommandEnvUnitName = "webmesh-instance-env.service"
+	// InstanceRunCmdScriptPath is the script running the extra commands of
+	// container linux instances.
+	InstanceRunCmdScriptPath = "/etc/webmesh-runcmd.sh"
+	// InstanceRunCmdUnitName is the systemd unit running
+	// InstanceRunCmdScriptPath.
+	InstanceRunCmdUnitName = "webmesh-runcmd.service"
+	// InstanceRunCmdStampPath is written once the extra commands of a
+	// container linux instance have run.
+	InstanceRunCmdStampPath = InstanceStateDirectory + "/runcmd.done"
+	// InstanceGatewaySysctlPath enables forwarding on default gateways.
+	InstanceGatewaySysctlPath = "/etc/sysctl.d/99-webmesh-gateway.conf"
+	// InstanceForwardingSysctlPath enables forwarding on container linux
+	// instances.
+	InstanceForwardingSysctlPath = "/etc/sysctl.d/99-webmesh-forwarding.conf"
+	// InstanceSecretsChecksumPath holds the checksum of the TLS material
+	// written to an instance.
+	InstanceSecretsChecksumPath = InstanceStateDirectory + "/secrets-checksum"
+	// InstanceSecretsRefreshScriptPath is the script rewriting renewed TLS
+	// material.
+	InstanceSecretsRefreshScriptPath = "/etc/webmesh-secrets-refresh.sh"
+	// InstanceSecretsRefreshUnitName is the name of the systemd service and
+	// timer running InstanceSecretsRefreshScriptPath, without a suffix.
+	InstanceSecretsRefreshUnitName = "webmesh-secrets-refresh"
+	// InstanceSecretsFetchScriptPath is the script reading the TLS material
+	// from a secrets manager.
+	InstanceSecretsFetchScriptPath = "/etc/webmesh-secrets-fetch.sh"
+)
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Images are the default images used for objects that do not set their own.
+type Images struct {
+	// Node is the image used for nodes, including bridge nodes of peerings.
+	// +optional
+	Node string `json:"node,omitempty"`
+	// MetricsProxy is the image of the sidecar serving node metrics over TLS.
+	// +optional
+	MetricsProxy string `json:"metricsProxy,omitempty"`
+}
+
+// Validate validates the image references.
+func (i Images) Validate(path *field.Path) error {
+	if err := ValidateImage(path.Child("node"), i.Node); err != nil {
+		return err
+	}
+	return ValidateImage(path.Child("metricsProxy"), i.MetricsProxy)
+}
+
+// Default sets any unset images to the built-in defaults.
+func (i *Images) Default() {
+	if i.Node == "" {
+		i.Node = DefaultNodeImage
+	}
+	if i.MetricsProxy == "" {
+		i.MetricsProxy = DefaultMetricsProxyImage
+	}
+}
+
+// DefaultImages returns the built-in images used for objects that do not set
+// their own. Images configured for the operator are passed on separately.
+func DefaultImages() Images {
+	var images Images
+	images.Default()
+	return images
+}
+
+// ImagesFunc returns the default images in effect when an object is admitted.
+type ImagesFunc func(ctx context.Context) (Images, error)
+
+var (
+	// imageReferenceRegexp matches an image reference of the form
+	// [registry[:port]/]path[:tag][@algorithm:digest].
+	imageReferenceRegexp = regexp.MustCompile(`^` +
+		`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[\w][\w.-]{0,127})?` +
+		`(?:@([a-z0-9]+):([a-f0-9]+))?$`)
+	// digestLengths are the lengths of the hex encoded digests of the
+	// supported digest algorithms.
+	digestLengths = map[string]int{
+		"sha256": 64,
+		"sha512": 128,
+	}
+)
+
+// ValidateImage validates an image reference. Images may be referenced by tag,
+// by digest, or both.
+func ValidateImage(path *field.Path, image string) error {
+	match := imageReferenceRegexp.FindStringSubmatch(image)
+	if match == nil {
+		return field.Invalid(path, image, "must be a valid image reference")
+	}
+	if algorithm, digest := match[1], match[2]; algorithm != "" {
+		length, ok := digestLengths[algorithm]
+		if !ok {
+			return field.Invalid(path, image, fmt.Sprintf("unsupported digest algorithm %q", algorithm))
+		}
+		if len(digest) != length {
+			return field.Invalid(path, image, fmt.Sprintf("%s digest must be %d hex characters", algorithm, length))
+		}
+	}
+	return nil
+}
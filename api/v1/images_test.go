@@ -0,0 +1,127 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidateImage(t *testing.T) {
+	digest := strings.Repeat("a", 64)
+	tc := []struct {
+		image string
+		valid bool
+	}{
+		{image: DefaultNodeImage, valid: true},
+		{image: DefaultMetricsProxyImage, valid: true},
+		{image: "node", valid: true},
+		{image: "registry.example.com:5000/webmesh/node:v0.6.4", valid: true},
+		{image: "registry.example.com/webmesh/node@sha256:" + digest, valid: true},
+		{image: "registry.example.com/webmesh/node:v0.6.4@sha256:" + digest, valid: true},
+		{image: "node@sha512:" + strings.Repeat("b", 128), valid: true},
+		{image: "", valid: false},
+		{image: "Registry.example.com/Webmesh/Node", valid: false},
+		{image: "node:", valid: false},
+		{image: "node@sha256:" + digest[:63], valid: false},
+		{image: "node@md5:" + strings.Repeat("c", 32), valid: false},
+		{image: "node@sha256:" + strings.ToUpper(digest), valid: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.image, func(t *testing.T) {
+			err := ValidateImage(field.NewPath("spec", "image"), tt.image)
+			if tt.valid && err != nil {
+				t.Errorf("expected %q to be valid, got %v", tt.image, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected %q to be invalid", tt.image)
+			}
+		})
+	}
+}
+
+func TestDefaultImages(t *testing.T) {
+	if got := DefaultImages(); got.Node != DefaultNodeImage || got.MetricsProxy != DefaultMetricsProxyImage {
+		t.Fatalf("unexpected default images: %+v", got)
+	}
+	mirror := "registry.example.com/webmesh/node:v0.6.4"
+	images := Images{Node: mirror}
+	images.Default()
+	if images.Node != mirror || images.MetricsProxy != DefaultMetricsProxyImage {
+		t.Fatalf("expected only unset images to be defaulted, got %+v", images)
+	}
+
+	var spec NodeGroupSpec
+	spec.DefaultImage(images.Node)
+	spec.Default()
+	if spec.Image != mirror {
+		t.Errorf("expected node groups to default to %q, got %q", mirror, spec.Image)
+	}
+	spec = NodeGroupSpec{}
+	spec.Default()
+	if spec.Image != DefaultNodeImage {
+		t.Errorf("expected node groups to default to %q, got %q", DefaultNodeImage, spec.Image)
+	}
+
+	var mesh Mesh
+	mesh.DefaultImage(mirror)
+	mesh.DefaultImage(DefaultNodeImage)
+	if mesh.Spec.Image != mirror {
+		t.Errorf("expected set images to be kept, got %q", mesh.Spec.Image)
+	}
+}
+
+func TestDefaulterImages(t *testing.T) {
+	mirror := "registry.example.com/webmesh/node:v0.6.4"
+	images := func(context.Context) (Images, error) {
+		return Images{Node: mirror, MetricsProxy: DefaultMetricsProxyImage}, nil
+	}
+	ctx := context.Background()
+
+	mesh := &Mesh{}
+	if err := (&meshDefaulter{images: images}).Default(ctx, mesh); err != nil {
+		t.Fatalf("default mesh: %v", err)
+	}
+	if mesh.Spec.Image != mirror {
+		t.Errorf("expected meshes to default to %q, got %q", mirror, mesh.Spec.Image)
+	}
+	group := &NodeGroup{Spec: NodeGroupSpec{Image: "node:custom"}}
+	if err := (&nodeGroupDefaulter{images: images}).Default(ctx, group); err != nil {
+		t.Fatalf("default node group: %v", err)
+	}
+	if group.Spec.Image != "node:custom" {
+		t.Errorf("expected the image of the group to be kept, got %q", group.Spec.Image)
+	}
+
+	// Without a source of images the built-in defaults are used
+	group = &NodeGroup{}
+	if err := (&nodeGroupDefaulter{}).Default(ctx, group); err != nil {
+		t.Fatalf("default node group: %v", err)
+	}
+	if group.Spec.Image != DefaultNodeImage {
+		t.Errorf("expected node groups to default to %q, got %q", DefaultNodeImage, group.Spec.Image)
+	}
+
+	failing := func(context.Context) (Images, error) { return Images{}, errors.New("cache not synced") }
+	if err := (&meshDefaulter{images: failing}).Default(ctx, &Mesh{}); err == nil {
+		t.Error("expected a failure to resolve the images to be returned")
+	}
+}
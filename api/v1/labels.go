@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	// LegacyLabelPrefix is the prefix of the label keys the operator selects
+	// its objects by unless group label keys are enabled.
+	LegacyLabelPrefix = "webmesh.io/"
+	// GroupLabelPrefix is the prefix of the label keys under the API group.
+	GroupLabelPrefix = "mesh.webmesh.io/"
+)
+
+// migratedLabels are the legacy keys of the labels the operator selects its
+// objects by. Other labels and annotations keep their keys.
+var migratedLabels = map[string]struct{}{
+	MeshNameLabel:                   {},
+	MeshNamespaceLabel:              {},
+	NodeGroupNameLabel:              {},
+	NodeGroupNamespaceLabel:         {},
+	MeshPeeringNameLabel:            {},
+	MeshPeeringNamespaceLabel:       {},
+	MeshAccessRequestNameLabel:      {},
+	MeshAccessRequestNamespaceLabel: {},
+	BootstrapNodeGroupLabel:         {},
+}
+
+var (
+	groupLabelKeys   bool
+	groupLabelKeysMu sync.RWMutex
+)
+
+// GroupLabelKeys returns true if objects are selected by label keys under the
+// API group instead of the legacy keys.
+func GroupLabelKeys() bool {
+	groupLabelKeysMu.RLock()
+	defer groupLabelKeysMu.RUnlock()
+	return groupLabelKeys
+}
+
+// SetGroupLabelKeys sets whether objects are selected by label keys under the
+// API group. Objects keep their legacy labels either way, so that objects of
+// previous versions can be migrated. It is meant to be called once at startup.
+func SetGroupLabelKeys(enabled bool) {
+	groupLabelKeysMu.Lock()
+	defer groupLabelKeysMu.Unlock()
+	groupLabelKeys = enabled
+}
+
+// LabelKey returns the key objects are selected by for the given legacy label
+// key.
+func LabelKey(key string) string {
+	if _, ok := migratedLabels[key]; !ok || !GroupLabelKeys() {
+		return key
+	}
+	return GroupLabelPrefix + strings.TrimPrefix(key, LegacyLabelPrefix)
+}
+
+// LegacyLabelKey returns the legacy key of the given label key.
+func LegacyLabelKey(key string) string {
+	name, ok := strings.CutPrefix(key, GroupLabelPrefix)
+	if !ok {
+		return key
+	}
+	if _, ok := migratedLabels[LegacyLabelPrefix+name]; !ok {
+		return key
+	}
+	return LegacyLabelPrefix + name
+}
+
+// LabelValue returns the value of the given legacy label key from the labels,
+// falling back to the legacy key for objects that were not migrated yet.
+func LabelValue(labels map[string]string, key string) (string, bool) {
+	if value, ok := labels[LabelKey(key)]; ok {
+		return value, true
+	}
+	value, ok := labels[key]
+	return value, ok
+}
+
+// SelectorLabels returns the labels objects matched by the given selector are
+// given. These are the selector and its legacy keys.
+func SelectorLabels(selector map[string]string) map[string]string {
+	labels := make(map[string]string, 2*len(selector))
+	for k, v := range selector {
+		labels[k] = v
+		labels[LegacyLabelKey(k)] = v
+	}
+	return labels
+}
+
+// LegacySelector returns the given selector with legacy label keys. It
+// matches objects labeled by previous versions of the operator.
+func LegacySelector(selector map[string]string) map[string]string {
+	legacy := make(map[string]string, len(selector))
+	for k, v := range selector {
+		legacy[LegacyLabelKey(k)] = v
+	}
+	return legacy
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGroupLabelKeys(t *testing.T) {
+	defer SetGroupLabelKeys(false)
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &NodeGroup{ObjectMeta: metav1.ObjectMeta{
+		Name:      "group",
+		Namespace: "default",
+		Labels:    map[string]string{ZoneAwarenessLabel: "edge"},
+	}}
+	legacy := map[string]string{
+		MeshNameLabel:           "mesh",
+		MeshNamespaceLabel:      "default",
+		NodeGroupNameLabel:      "group",
+		NodeGroupNamespaceLabel: "default",
+	}
+	if got := NodeGroupSelector(mesh, group); !reflect.DeepEqual(got, legacy) {
+		t.Fatalf("expected the legacy selector by default, got %v", got)
+	}
+
+	SetGroupLabelKeys(true)
+	selector := NodeGroupSelector(mesh, group)
+	want := map[string]string{
+		"mesh.webmesh.io/mesh-name":           "mesh",
+		"mesh.webmesh.io/mesh-namespace":      "default",
+		"mesh.webmesh.io/nodegroup-name":      "group",
+		"mesh.webmesh.io/nodegroup-namespace": "default",
+	}
+	if !reflect.DeepEqual(selector, want) {
+		t.Errorf("expected group label keys, got %v", selector)
+	}
+	if got := LegacySelector(selector); !reflect.DeepEqual(got, legacy) {
+		t.Errorf("expected the legacy selector, got %v", got)
+	}
+	// Objects carry both keys, other labels keep theirs
+	labels := NodeGroupLabels(mesh, group)
+	for k, v := range legacy {
+		if labels[k] != v || labels[LabelKey(k)] != v {
+			t.Errorf("expected %s under both keys, got %v", k, labels)
+		}
+	}
+	if labels[ZoneAwarenessLabel] != "edge" || LabelKey(ZoneAwarenessLabel) != ZoneAwarenessLabel {
+		t.Errorf("expected the zone awareness label to keep its key, got %v", labels)
+	}
+	if name, ok := LabelValue(legacy, NodeGroupNameLabel); !ok || name != "group" {
+		t.Errorf("expected the value of the legacy key for unmigrated objects, got %q", name)
+	}
+	if _, ok := LabelValue(map[string]string{}, NodeGroupNameLabel); ok {
+		t.Errorf("expected no value for unlabeled objects")
+	}
+}
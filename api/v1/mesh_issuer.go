@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultIssuerGroup is the API group of issuers referenced without one.
+const DefaultIssuerGroup = "cert-manager.io"
+
+// IssuerState is the observed state of the issuer referenced by a mesh.
+type IssuerState struct {
+	// Found is true if the issuer exists.
+	Found bool
+	// Ready is true if the issuer's Ready condition is true.
+	Ready bool
+	// Reason is the reason of the issuer's Ready condition.
+	Reason string
+	// Message is the message of the issuer's Ready condition.
+	Message string
+	// OtherNamespaces are the namespaces holding an issuer of the same kind
+	// and name, when a namespace-scoped issuer is not found in the namespace
+	// of the mesh.
+	OtherNamespaces []string
+}
+
+// LookupIssuer returns the state of the issuer referenced by the mesh. Issuers
+// of any API group are supported, as long as they report a Ready condition in
+// their status like the cert-manager issuers.
+func (c *Mesh) LookupIssuer(ctx context.Context, cli client.Client) (*IssuerState, error) {
+	ref := c.IssuerReference()
+	group := ref.Group
+	if group == "" {
+		group = DefaultIssuerGroup
+	}
+	mapping, err := cli.RESTMapper().RESTMapping(schema.GroupKind{Group: group, Kind: ref.Kind})
+	if err != nil {
+		return nil, fmt.Errorf("find issuer kind %s.%s: %w", ref.Kind, group, err)
+	}
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	var issuer unstructured.Unstructured
+	issuer.SetGroupVersionKind(mapping.GroupVersionKind)
+	key := client.ObjectKey{Name: ref.Name}
+	if namespaced {
+		key.Namespace = c.GetNamespace()
+	}
+	err = cli.Get(ctx, key, &issuer)
+	if apierrors.IsNotFound(err) {
+		var state IssuerState
+		if namespaced {
+			state.OtherNamespaces, err = issuerNamespaces(ctx, cli, mapping.GroupVersionKind, ref.Name)
+			if err != nil && !apierrors.IsForbidden(err) {
+				return nil, err
+			}
+		}
+		return &state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get issuer %s: %w", ref.Name, err)
+	}
+	state := IssuerState{Found: true, Reason: "NoReadyCondition", Message: "The issuer has not reported a Ready condition"}
+	conditions, _, _ := unstructured.NestedSlice(issuer.Object, "status", "conditions")
+	for _, item := range conditions {
+		condition, ok := item.(map[string]any)
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		state.Ready = condition["status"] == "True"
+		state.Reason, _ = condition["reason"].(string)
+		state.Message, _ = condition["message"].(string)
+	}
+	return &state, nil
+}
+
+// issuerNamespaces returns the namespaces holding an issuer of the given kind
+// and name.
+func issuerNamespaces(ctx context.Context, cli client.Client, gvk schema.GroupVersionKind, name string) ([]string, error) {
+	var issuers unstructured.UnstructuredList
+	issuers.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := cli.List(ctx, &issuers); err != nil {
+		return nil, fmt.Errorf("list issuers: %w", err)
+	}
+	var namespaces []string
+	for _, issuer := range issuers.Items {
+		if issuer.GetName() == name {
+			namespaces = append(namespaces, issuer.GetNamespace())
+		}
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateIssuer(t *testing.T) {
+	issuerGV := schema.GroupVersion{Group: DefaultIssuerGroup, Version: "v1"}
+	externalGV := schema.GroupVersion{Group: "example.com", Version: "v1alpha1"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{issuerGV, externalGV})
+	mapper.Add(issuerGV.WithKind("Issuer"), meta.RESTScopeNamespace)
+	mapper.Add(issuerGV.WithKind("ClusterIssuer"), meta.RESTScopeRoot)
+	mapper.Add(externalGV.WithKind("ExternalIssuer"), meta.RESTScopeNamespace)
+
+	newIssuer := func(gvk schema.GroupVersionKind, namespace, name string, ready bool) client.Object {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		status := "False"
+		if ready {
+			status = "True"
+		}
+		_ = unstructured.SetNestedSlice(obj.Object, []any{map[string]any{
+			"type":    "Ready",
+			"status":  status,
+			"reason":  "Checked",
+			"message": "checked",
+		}}, "status", "conditions")
+		return obj
+	}
+
+	tc := []struct {
+		name     string
+		ref      cmmeta.ObjectReference
+		objs     []client.Object
+		err      bool
+		warnings int
+	}{
+		{
+			name: "ready issuer",
+			ref:  cmmeta.ObjectReference{Kind: "Issuer", Name: "ca"},
+			objs: []client.Object{newIssuer(issuerGV.WithKind("Issuer"), "default", "ca", true)},
+		},
+		{
+			name: "ready cluster issuer",
+			ref:  cmmeta.ObjectReference{Kind: "ClusterIssuer", Name: "ca"},
+			objs: []client.Object{newIssuer(issuerGV.WithKind("ClusterIssuer"), "", "ca", true)},
+		},
+		{
+			name:     "issuer not ready",
+			ref:      cmmeta.ObjectReference{Kind: "Issuer", Name: "ca"},
+			objs:     []client.Object{newIssuer(issuerGV.WithKind("Issuer"), "default", "ca", false)},
+			warnings: 1,
+		},
+		{
+			name:     "issuer not found",
+			ref:      cmmeta.ObjectReference{Kind: "Issuer", Name: "ca"},
+			warnings: 1,
+		},
+		{
+			name: "issuer in another namespace",
+			ref:  cmmeta.ObjectReference{Kind: "Issuer", Name: "ca"},
+			objs: []client.Object{newIssuer(issuerGV.WithKind("Issuer"), "other", "ca", true)},
+			err:  true,
+		},
+		{
+			name: "unsupported kind",
+			ref:  cmmeta.ObjectReference{Kind: "Certificate", Name: "ca"},
+			err:  true,
+		},
+		{
+			name: "ready external issuer",
+			ref:  cmmeta.ObjectReference{Group: "example.com", Kind: "ExternalIssuer", Name: "ca"},
+			objs: []client.Object{newIssuer(externalGV.WithKind("ExternalIssuer"), "default", "ca", true)},
+		},
+		{
+			name:     "external issuer kind not installed",
+			ref:      cmmeta.ObjectReference{Group: "example.org", Kind: "ExternalIssuer", Name: "ca"},
+			warnings: 1,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := fake.NewClientBuilder().
+				WithScheme(runtime.NewScheme()).
+				WithRESTMapper(mapper).
+				WithObjects(tt.objs...).
+				Build()
+			mesh := &Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+				Spec:       MeshSpec{Issuer: IssuerConfig{IssuerRef: tt.ref}},
+			}
+			v := &meshValidator{Client: cli}
+			warnings, err := v.validateIssuer(context.Background(), mesh)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("expected %d warnings, got %v", tt.warnings, warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MeshProfile is a preset that the defaulting webhook expands into a fully
+// populated mesh spec.
+// +kubebuilder:validation:Enum:=demo;ha;edge
+type MeshProfile string
+
+const (
+	// MeshProfileDemo runs a single bootstrap node that keeps the mesh
+	// state in memory.
+	MeshProfileDemo MeshProfile = "demo"
+	// MeshProfileHA runs three persistent bootstrap nodes exposed through
+	// a load balancer.
+	MeshProfileHA MeshProfile = "ha"
+	// MeshProfileEdge runs a single persistent bootstrap node on the host
+	// network, serving its ports directly on the host.
+	MeshProfileEdge MeshProfile = "edge"
+)
+
+// meshProfiles are the expansions of each profile. An expansion only sets the
+// fields of the spec that are not already set.
+var meshProfiles = map[MeshProfile]func(spec *MeshSpec){
+	MeshProfileDemo: expandDemoProfile,
+	MeshProfileHA:   expandHAProfile,
+	MeshProfileEdge: expandEdgeProfile,
+}
+
+// supportedMeshProfiles returns the names of the supported mesh profiles.
+func supportedMeshProfiles() []string {
+	return []string{string(MeshProfileDemo), string(MeshProfileHA), string(MeshProfileEdge)}
+}
+
+// persistentBootstrap returns true if the bootstrap nodes of the mesh keep
+// the mesh state on persistent volumes.
+func (s *MeshSpec) persistentBootstrap() bool {
+	return s.Profile != MeshProfileDemo
+}
+
+// expandProfile expands the profile of the mesh into its spec and records the
+// fields the profile sets in the MeshProfileAnnotation. Profiles are only
+// expanded once, so the spec may be customized after creation.
+func (r *Mesh) expandProfile() {
+	expand, ok := meshProfiles[r.Spec.Profile]
+	if !ok {
+		return
+	}
+	if _, ok := r.GetAnnotations()[MeshProfileAnnotation]; ok {
+		return
+	}
+	expansion := MeshSpec{Profile: r.Spec.Profile}
+	expand(&expansion)
+	data, err := json.Marshal(&expansion)
+	if err != nil {
+		meshlog.Error(err, "unable to marshal profile expansion", "name", r.Name)
+		return
+	}
+	expand(&r.Spec)
+	annotations := r.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[MeshProfileAnnotation] = string(data)
+	r.SetAnnotations(annotations)
+}
+
+// expandProfileIssuer creates an issuer for the mesh unless it references an
+// existing one.
+func expandProfileIssuer(spec *MeshSpec) {
+	if spec.Issuer.IssuerRef.Name != "" {
+		return
+	}
+	spec.Issuer.Create = true
+	if spec.Issuer.Kind == "" {
+		spec.Issuer.Kind = "Issuer"
+	}
+}
+
+// expandProfileBootstrap sets the replicas of the bootstrap group and ensures
+// it runs in the cluster.
+func expandProfileBootstrap(spec *MeshSpec, replicas int32) {
+	if spec.Bootstrap.Replicas == nil {
+		spec.Bootstrap.Replicas = new(int32)
+		*spec.Bootstrap.Replicas = replicas
+	}
+	if spec.Bootstrap.Cluster == nil {
+		spec.Bootstrap.Cluster = &NodeGroupClusterConfig{}
+	}
+}
+
+func expandDemoProfile(spec *MeshSpec) {
+	expandProfileIssuer(spec)
+	expandProfileBootstrap(spec, 1)
+}
+
+func expandHAProfile(spec *MeshSpec) {
+	expandProfileIssuer(spec)
+	expandProfileBootstrap(spec, 3)
+	cluster := spec.Bootstrap.Cluster
+	if cluster.ResourcePreset == "" {
+		cluster.ResourcePreset = ResourcePresetMedium
+	}
+	if cluster.Service == nil {
+		cluster.Service = &NodeGroupLBConfig{Type: corev1.ServiceTypeLoadBalancer}
+	}
+}
+
+func expandEdgeProfile(spec *MeshSpec) {
+	expandProfileIssuer(spec)
+	expandProfileBootstrap(spec, 1)
+	spec.Bootstrap.Cluster.HostNetwork = true
+}
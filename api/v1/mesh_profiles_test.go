@@ -0,0 +1,139 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMeshProfiles(t *testing.T) {
+	tc := []struct {
+		profile     MeshProfile
+		replicas    int32
+		persistent  bool
+		exposed     bool
+		hostNetwork bool
+	}{
+		{profile: MeshProfileDemo, replicas: 1},
+		{profile: MeshProfileHA, replicas: 3, persistent: true, exposed: true},
+		{profile: MeshProfileEdge, replicas: 1, persistent: true, hostNetwork: true},
+	}
+	for _, tt := range tc {
+		t.Run(string(tt.profile), func(t *testing.T) {
+			mesh := &Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+				Spec:       MeshSpec{Profile: tt.profile},
+			}
+			mesh.Default()
+
+			var expansion MeshSpec
+			if err := json.Unmarshal([]byte(mesh.GetAnnotations()[MeshProfileAnnotation]), &expansion); err != nil {
+				t.Fatalf("expected the expansion in the annotation: %v", err)
+			}
+			if expansion.Profile != tt.profile {
+				t.Errorf("expected the expansion of profile %q, got %q", tt.profile, expansion.Profile)
+			}
+			bootstrap := mesh.Spec.Bootstrap
+			if bootstrap.Replicas == nil || *bootstrap.Replicas != tt.replicas {
+				t.Errorf("expected %d bootstrap replicas, got %v", tt.replicas, bootstrap.Replicas)
+			}
+			if persistent := bootstrap.Cluster.PVCSpec != nil; persistent != tt.persistent {
+				t.Errorf("expected persistent bootstrap group to be %v", tt.persistent)
+			}
+			if exposed := bootstrap.Cluster.Service != nil; exposed != tt.exposed {
+				t.Errorf("expected exposed bootstrap group to be %v", tt.exposed)
+			}
+			if bootstrap.Cluster.HostNetwork != tt.hostNetwork {
+				t.Errorf("expected host network to be %v", tt.hostNetwork)
+			}
+
+			// The expanded spec is admitted and renders valid bootstrap groups
+			scheme := runtime.NewScheme()
+			if err := AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			v := &meshValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+			warnings, err := v.ValidateCreate(context.Background(), mesh)
+			if err != nil {
+				t.Fatalf("expected the expanded spec to validate: %v", err)
+			}
+			if len(warnings) != 0 {
+				t.Errorf("expected no warnings, got %v", warnings)
+			}
+			groups := mesh.BootstrapGroups()
+			if tt.exposed && len(groups) != 2 || !tt.exposed && len(groups) != 1 {
+				t.Fatalf("unexpected bootstrap groups %v", groups)
+			}
+			for _, group := range groups {
+				if err := group.Spec.Validate(); err != nil {
+					t.Errorf("invalid bootstrap group %s: %v", group.GetName(), err)
+				}
+				if _, ok := group.GetAnnotations()[MeshProfileAnnotation]; ok {
+					t.Errorf("expected no profile annotation on bootstrap group %s", group.GetName())
+				}
+				if persistent := group.Spec.Cluster.PVCSpec != nil; persistent != tt.persistent {
+					t.Errorf("expected persistent bootstrap group %s to be %v", group.GetName(), tt.persistent)
+				}
+			}
+		})
+	}
+}
+
+func TestMeshProfileCustomization(t *testing.T) {
+	mesh := &Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: MeshSpec{
+			Profile: MeshProfileHA,
+			Bootstrap: NodeGroupSpec{
+				Replicas: new(int32),
+				Cluster:  &NodeGroupClusterConfig{ResourcePreset: ResourcePresetLarge},
+			},
+		},
+	}
+	*mesh.Spec.Bootstrap.Replicas = 5
+	mesh.Default()
+	if *mesh.Spec.Bootstrap.Replicas != 5 {
+		t.Errorf("expected explicit replicas to be kept, got %d", *mesh.Spec.Bootstrap.Replicas)
+	}
+	if mesh.Spec.Bootstrap.Cluster.ResourcePreset != ResourcePresetLarge {
+		t.Errorf("expected explicit resource preset to be kept, got %q", mesh.Spec.Bootstrap.Cluster.ResourcePreset)
+	}
+	if mesh.Spec.Bootstrap.Cluster.Service == nil {
+		t.Error("expected the profile to expose the bootstrap group")
+	}
+
+	// The profile is not expanded again once the expanded fields are removed
+	mesh.Spec.Bootstrap.Cluster.Service = nil
+	mesh.Default()
+	if mesh.Spec.Bootstrap.Cluster.Service != nil {
+		t.Error("expected the profile to be expanded only once")
+	}
+
+	// The profile cannot be changed
+	updated := mesh.DeepCopy()
+	updated.Spec.Profile = MeshProfileEdge
+	v := &meshValidator{Client: fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build()}
+	if _, err := v.ValidateUpdate(context.Background(), mesh, updated); err == nil {
+		t.Error("expected changing the profile to be rejected")
+	}
+}
@@ -17,16 +17,33 @@ limitations under the License.
 package v1
 
 import (
+	"strings"
+	"time"
+
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 // MeshSpec defines the desired state of Mesh
 type MeshSpec struct {
+	// Profile is a preset that is expanded into the spec when the mesh is
+	// created. The fields set by the profile are recorded in the
+	// webmesh.io/profile-expansion annotation and may be customized
+	// afterwards. Fields set explicitly are left untouched. The demo profile
+	// runs a single bootstrap node that keeps the mesh state in memory. The
+	// ha profile runs three persistent bootstrap nodes exposed through a
+	// load balancer. The edge profile runs a single persistent bootstrap node
+	// on the host network. All profiles create an issuer unless
+	// issuer.issuerRef is set. The profile cannot be changed after creation.
+	// +optional
+	Profile MeshProfile `json:"profile,omitempty"`
+
 	// Image is the default image to use for configurations if not
-	// specified otherwise.
-	// +kubebuilder:default:="ghcr.io/webmeshproj/node:latest"
+	// specified otherwise. Defaults to the operator's default node image.
+	// Images may be referenced by tag, digest, or both.
 	// +optional
 	Image string `json:"image,omitempty"`
 
@@ -43,12 +60,24 @@ type MeshSpec struct {
 	// +optional
 	Bootstrap NodeGroupSpec `json:"bootstrap,omitempty"`
 
+	// BootstrapLB configures the load balancer node group created when the
+	// bootstrap group is exposed. Fields left unset are copied from the
+	// bootstrap group.
+	// +optional
+	BootstrapLB *BootstrapLBGroupSpec `json:"bootstrapLB,omitempty"`
+
 	// IPv4 is the IPv4 CIDR to use for the mesh. This cannot be
 	// changed after creation.
 	// +kubebuilder:default:="172.16.0.0/12"
 	// +optional
 	IPv4 string `json:"ipv4,omitempty"`
 
+	// Domain is the domain to use for MeshDNS names in the mesh. This
+	// cannot be changed after creation.
+	// +kubebuilder:default:="webmesh.internal"
+	// +optional
+	Domain string `json:"domain,omitempty"`
+
 	// DefaultNetworkPolicy is the default network policy to use for
 	// the mesh. This can only be changed after creation via the API.
 	// +kubebuilder:default:="deny"
@@ -59,6 +88,264 @@ type MeshSpec struct {
 	// Issuer is the configuration for issuing TLS certificates.
 	// +optional
 	Issuer IssuerConfig `json:"issuer,omitempty"`
+
+	// MaxDefaultGateways is the maximum number of node groups that may
+	// advertise a default route to the mesh.
+	// +kubebuilder:default:=1
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	MaxDefaultGateways int32 `json:"maxDefaultGateways,omitempty"`
+
+	// ServiceIPFamilies is the default IP family configuration for
+	// services created for node groups. If unset the families are
+	// detected from the cluster.
+	// +optional
+	ServiceIPFamilies ServiceIPFamilies `json:"serviceIPFamilies,omitempty"`
+
+	// AdminConfig is the configuration for storing the generated admin
+	// and manager configs.
+	// +optional
+	AdminConfig AdminConfigSpec `json:"adminConfig,omitempty"`
+
+	// AccessProfiles are additional scoped configs to generate for the
+	// mesh. Each profile is issued its own client certificate and is bound
+	// to a role in the mesh. The configs are written to the admin config
+	// store.
+	// +optional
+	AccessProfiles []AccessProfile `json:"accessProfiles,omitempty"`
+
+	// AccessRequests configures how MeshAccessRequests for the mesh are
+	// fulfilled. Requests are rejected while it is unset.
+	// +optional
+	AccessRequests *AccessRequestConfig `json:"accessRequests,omitempty"`
+
+	// Security is the default TLS verification configuration for nodes in
+	// the mesh and the generated configs. It can be overridden per group.
+	// +optional
+	Security SecurityConfig `json:"security,omitempty"`
+
+	// PropagateMetadata declares the labels and annotations of the mesh and
+	// its node groups that are copied onto the objects created for them, in
+	// addition to those under the operator's prefixes and the
+	// app.kubernetes.io/name and app.kubernetes.io/part-of labels.
+	// Annotations written by deployment tooling, such as the last applied
+	// configuration of kubectl, are never copied.
+	// +optional
+	PropagateMetadata *MetadataPropagation `json:"propagateMetadata,omitempty"`
+
+	// CIDRUsage enables periodic reports of the mesh IPv4 addresses
+	// allocated to each node group in status.meshCIDRUsage. The nodes are
+	// listed through the admin API of the bootstrap group, which can be
+	// costly on very large meshes, so reports are disabled while it is
+	// unset.
+	// +optional
+	CIDRUsage *CIDRUsageConfig `json:"cidrUsage,omitempty"`
+}
+
+// SecurityConfig defines how TLS peers are verified.
+type SecurityConfig struct {
+	// VerifyChainOnly is true if only the certificate chain should be
+	// verified and not the names of the peer. Defaults to true when the
+	// issuer is created by the operator and false otherwise.
+	// +optional
+	VerifyChainOnly *bool `json:"verifyChainOnly,omitempty"`
+
+	// RequireClientCert is true if nodes should require clients to present
+	// a certificate. Defaults to true.
+	// +optional
+	RequireClientCert *bool `json:"requireClientCert,omitempty"`
+}
+
+// Merge returns the given SecurityConfig merged over this SecurityConfig. The
+// given SecurityConfig takes precedence and neither is modified.
+func (c *SecurityConfig) Merge(in *SecurityConfig) *SecurityConfig {
+	if in == nil {
+		return c.DeepCopy()
+	}
+	if c == nil {
+		return in.DeepCopy()
+	}
+	out, in := c.DeepCopy(), in.DeepCopy()
+	if in.VerifyChainOnly != nil {
+		out.VerifyChainOnly = in.VerifyChainOnly
+	}
+	if in.RequireClientCert != nil {
+		out.RequireClientCert = in.RequireClientCert
+	}
+	return out
+}
+
+// AccessProfileRole is the role in the mesh granted to an access profile.
+// +kubebuilder:validation:Enum:=ReadOnly;Manager
+type AccessProfileRole string
+
+const (
+	// AccessProfileRoleReadOnly grants read access to all mesh resources.
+	AccessProfileRoleReadOnly AccessProfileRole = "ReadOnly"
+	// AccessProfileRoleManager grants read access to all mesh resources and
+	// allows managing network ACLs, routes, edges, and groups.
+	AccessProfileRoleManager AccessProfileRole = "Manager"
+)
+
+// AccessProfile declares a scoped config to generate for a Mesh.
+type AccessProfile struct {
+	// Name is the name of the profile. It must be a valid DNS label.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Role is the role granted to the profile.
+	// +kubebuilder:default:="ReadOnly"
+	// +optional
+	Role AccessProfileRole `json:"role,omitempty"`
+}
+
+// AccessRequestConfig defines which MeshAccessRequests a Mesh fulfills.
+type AccessRequestConfig struct {
+	// AllowedRoles are the roles that may be requested.
+	// +kubebuilder:validation:MinItems:=1
+	AllowedRoles []AccessProfileRole `json:"allowedRoles"`
+
+	// RequireApproval is true if requests must be approved by annotating
+	// them before they are fulfilled. Otherwise requests are approved
+	// automatically.
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// MaxDuration is the longest access that may be requested. Defaults
+	// to 24h.
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+}
+
+// Allows returns true if the given role may be requested.
+func (c *AccessRequestConfig) Allows(role AccessProfileRole) bool {
+	if c == nil {
+		return false
+	}
+	for _, allowed := range c.AllowedRoles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxAccessDuration returns the longest access that may be requested.
+func (c *AccessRequestConfig) MaxAccessDuration() time.Duration {
+	if c == nil || c.MaxDuration == nil {
+		return DefaultMaxAccessRequestDuration
+	}
+	return c.MaxDuration.Duration
+}
+
+// CIDRUsageConfig configures the reports of allocated mesh IPv4 addresses.
+type CIDRUsageConfig struct {
+	// Interval is how often the nodes of the mesh are listed. Defaults to
+	// 5m and must be at least 1m.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Threshold is the utilization of the mesh IPv4 CIDR, in percent, at
+	// which the mesh is reported to be running out of addresses. Defaults
+	// to 80.
+	// +kubebuilder:validation:Minimum:=1
+	// +kubebuilder:validation:Maximum:=100
+	// +optional
+	Threshold *int32 `json:"threshold,omitempty"`
+}
+
+// ReportInterval returns how often the allocated addresses are reported.
+func (c *CIDRUsageConfig) ReportInterval() time.Duration {
+	if c == nil || c.Interval == nil {
+		return DefaultCIDRUsageInterval
+	}
+	return c.Interval.Duration
+}
+
+// UtilizationThreshold returns the utilization in percent at which the mesh
+// is reported to be running out of addresses.
+func (c *CIDRUsageConfig) UtilizationThreshold() int32 {
+	if c == nil || c.Threshold == nil {
+		return DefaultCIDRUsageThreshold
+	}
+	return *c.Threshold
+}
+
+// AdminConfigStoreType is a backend for storing generated configs.
+// +kubebuilder:validation:Enum:=KubernetesSecret;Vault
+type AdminConfigStoreType string
+
+const (
+	// AdminConfigStoreKubernetesSecret stores configs in Secrets in the
+	// namespace of the Mesh.
+	AdminConfigStoreKubernetesSecret AdminConfigStoreType = "KubernetesSecret"
+	// AdminConfigStoreVault stores configs in a Vault KV v2 secrets engine.
+	AdminConfigStoreVault AdminConfigStoreType = "Vault"
+)
+
+// AdminConfigSpec defines where generated admin and manager configs are stored.
+type AdminConfigSpec struct {
+	// Store is the backend to store configs in.
+	// +kubebuilder:default:="KubernetesSecret"
+	// +optional
+	Store AdminConfigStoreType `json:"store,omitempty"`
+
+	// Vault is the configuration for the Vault store.
+	// +optional
+	Vault *VaultStoreConfig `json:"vault,omitempty"`
+}
+
+// VaultStoreConfig is the configuration for storing configs in Vault. The
+// operator authenticates with the Kubernetes auth method using its service
+// account token.
+type VaultStoreConfig struct {
+	// Address is the address of the Vault server.
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// Role is the Kubernetes auth role to log in with.
+	// +kubebuilder:validation:Required
+	Role string `json:"role"`
+
+	// AuthMount is the mount path of the Kubernetes auth method.
+	// +kubebuilder:default:="kubernetes"
+	// +optional
+	AuthMount string `json:"authMount,omitempty"`
+
+	// Mount is the mount path of the KV v2 secrets engine.
+	// +kubebuilder:default:="secret"
+	// +optional
+	Mount string `json:"mount,omitempty"`
+
+	// Path is the path under the mount to write configs to. Defaults to
+	// webmesh/<namespace>/<mesh-name>.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// CABundle is a reference to a PEM encoded CA bundle to verify the
+	// Vault server with.
+	// +optional
+	CABundle *corev1.SecretKeySelector `json:"caBundle,omitempty"`
+}
+
+// Validate validates the admin config spec.
+func (c *AdminConfigSpec) Validate(path *field.Path) error {
+	switch c.Store {
+	case "", AdminConfigStoreKubernetesSecret:
+	case AdminConfigStoreVault:
+		if c.Vault == nil {
+			return field.Invalid(path.Child("vault"), c.Vault, "vault is required for the Vault store")
+		}
+		if c.Vault.Address == "" {
+			return field.Invalid(path.Child("vault", "address"), c.Vault.Address, "address is required")
+		}
+		if c.Vault.Role == "" {
+			return field.Invalid(path.Child("vault", "role"), c.Vault.Role, "role is required")
+		}
+	default:
+		return field.Invalid(path.Child("store"), c.Store, "unsupported store")
+	}
+	return nil
 }
 
 type NetworkPolicyType string
@@ -84,24 +371,25 @@ type IssuerConfig struct {
 	IssuerRef cmmeta.ObjectReference `json:"issuerRef,omitempty"`
 }
 
-// BootstrapGroup returns a NodeGroup for the bootstrap group.
+// BootstrapGroups returns the NodeGroups for the bootstrap group and, if it is
+// exposed, its load balancer group. The groups are fully defaulted, so they are
+// valid whether or not the mutating webhook is installed.
 func (c *Mesh) BootstrapGroups() []*NodeGroup {
 	if c == nil {
 		return nil
 	}
-	labels := c.GetLabels()
-	if labels == nil {
-		labels = map[string]string{}
-	}
-	for k, v := range MeshBootstrapGroupSelector(c) {
+	labels := c.Spec.PropagateMetadata.PropagatedLabels(c.GetLabels())
+	for k, v := range SelectorLabels(MeshBootstrapGroupSelector(c)) {
 		labels[k] = v
 	}
-	annotations := c.GetAnnotations()
-	if annotations == nil {
-		annotations = map[string]string{}
-	}
+	annotations := MeshAnnotations(c)
+	delete(annotations, MeshProfileAnnotation)
 	annotations[BootstrapNodeGroupAnnotation] = "true"
 	spec := c.Spec.Bootstrap.DeepCopy()
+	if spec.Image == "" {
+		spec.Image = c.Spec.Image
+	}
+	defaultBootstrapSpec(spec, c.Spec.persistentBootstrap())
 	if spec.Config == nil {
 		spec.Config = &NodeGroupConfig{}
 	}
@@ -112,6 +400,10 @@ func (c *Mesh) BootstrapGroups() []*NodeGroup {
 	spec.Config.Services.EnableAdminAPI = true
 	spec.Config.Services.EnableMeshAPI = true
 	spec.Config.Services.EnableLeaderProxy = true
+	spec.Default()
+	// We expose via a separate node group.
+	service := spec.Cluster.Service
+	spec.Cluster.Service = nil
 	bootstrapGroup := NodeGroup{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: GroupVersion.String(),
@@ -126,10 +418,6 @@ func (c *Mesh) BootstrapGroups() []*NodeGroup {
 		},
 		Spec: *spec,
 	}
-	if bootstrapGroup.Spec.Cluster.Service != nil {
-		// Force this to be nil, we expose via a separate node group.
-		bootstrapGroup.Spec.Cluster.Service = nil
-	}
 	bootstrapGroup.Spec.Mesh = corev1.ObjectReference{
 		APIVersion: c.APIVersion,
 		Kind:       c.Kind,
@@ -138,7 +426,7 @@ func (c *Mesh) BootstrapGroups() []*NodeGroup {
 	}
 	groups := []*NodeGroup{&bootstrapGroup}
 	// Create an LB group if we are exposing the bootstrap group.
-	if c.Spec.Bootstrap.Cluster.Service != nil {
+	if service != nil {
 		lbGroup := bootstrapGroup.DeepCopy()
 		lbGroup.SetName(MeshBootstrapLBGroupName(c))
 		// This is not a bootstrap group, it joins the initial group
@@ -150,13 +438,51 @@ func (c *Mesh) BootstrapGroups() []*NodeGroup {
 		lbGroup.Labels[ZoneAwarenessLabel] = bootstrapGroup.GetName()
 		// We only run a single replica of the load balancer group
 		lbGroup.Spec.Replicas = nil
+		// Voters are only authorized by the bootstrap group
+		lbGroup.Spec.ExtraVoters = nil
 		lbGroup.Spec.Config.Voter = true
-		lbGroup.Spec.Cluster.Service = c.Spec.Bootstrap.Cluster.Service
+		// Anything set explicitly for the load balancer group takes precedence
+		c.Spec.BootstrapLB.applyTo(lbGroup)
+		// The load balancer group is only an entrypoint, leave egress to
+		// the bootstrap group.
+		if cfg, err := lbGroup.MergedConfig(c); err == nil && cfg.AdvertisesDefaultGateway() {
+			lbGroup.Spec.Config.DefaultGateway = DefaultGatewayAccept
+		}
+		lbGroup.Spec.Cluster.Service = service
+		lbGroup.Spec.Default()
 		groups = append(groups, lbGroup)
 	}
 	return groups
 }
 
+// VerifyChainOnly returns true if only the certificate chain should be verified
+// for nodes with the given config. A nil config returns the mesh default.
+func (c *Mesh) VerifyChainOnly(groupcfg *NodeGroupConfig) bool {
+	security := c.security(groupcfg)
+	if security.VerifyChainOnly != nil {
+		return *security.VerifyChainOnly
+	}
+	return c.Spec.Issuer.Create
+}
+
+// RequireClientCert returns true if nodes with the given config should require
+// client certificates. A nil config returns the mesh default.
+func (c *Mesh) RequireClientCert(groupcfg *NodeGroupConfig) bool {
+	security := c.security(groupcfg)
+	if security.RequireClientCert != nil {
+		return *security.RequireClientCert
+	}
+	return true
+}
+
+func (c *Mesh) security(groupcfg *NodeGroupConfig) *SecurityConfig {
+	security := c.Spec.Security.DeepCopy()
+	if groupcfg != nil {
+		security = security.Merge(groupcfg.Security)
+	}
+	return security
+}
+
 // IssuerReference returns the issuer reference for the mesh.
 func (c *Mesh) IssuerReference() cmmeta.ObjectReference {
 	if c == nil {
@@ -173,10 +499,258 @@ func (c *Mesh) IssuerReference() cmmeta.ObjectReference {
 
 // MeshStatus defines the observed state of Mesh
 type MeshStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Domain is the domain the mesh was bootstrapped with.
+	// +optional
+	Domain string `json:"domain,omitempty"`
+
+	// Conditions are the current conditions of the mesh.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// MeshCIDRUsage is the last report of the mesh IPv4 addresses
+	// allocated to each node group. It is only set while spec.cidrUsage
+	// is.
+	// +optional
+	MeshCIDRUsage *MeshCIDRUsage `json:"meshCIDRUsage,omitempty"`
+
+	// AccessProfiles are the names of the access profiles certificates and
+	// configs were issued for. Those of profiles removed from the spec are
+	// deleted.
+	// +optional
+	AccessProfiles []string `json:"accessProfiles,omitempty"`
+
+	// DiscoveryRecords are the discovery records published for the mesh.
+	// They are deleted from the zone they were published in when the DNS
+	// configuration is removed or changed, or the mesh is deleted.
+	// +optional
+	DiscoveryRecords *MeshDiscoveryRecords `json:"discoveryRecords,omitempty"`
+
+	ReconcileStatus `json:",inline"`
+}
+
+// MeshDiscoveryRecords are the discovery records published for a mesh.
+type MeshDiscoveryRecords struct {
+	// Config is the DNS configuration the records were published with.
+	Config NodeGroupLBDNSConfig `json:"config"`
+
+	// Records are the published record sets.
+	// +optional
+	Records []MeshDiscoveryRecord `json:"records,omitempty"`
+}
+
+// MeshDiscoveryRecord is a published discovery record set.
+type MeshDiscoveryRecord struct {
+	// Name is the fully qualified name of the record set.
+	Name string `json:"name"`
+
+	// Type is the type of the record set.
+	Type string `json:"type"`
+}
+
+// MeshCIDRUsage reports the allocated addresses of the mesh IPv4 CIDR.
+type MeshCIDRUsage struct {
+	// CIDR is the IPv4 CIDR of the mesh.
+	CIDR string `json:"cidr"`
+
+	// Capacity is the number of addresses in the CIDR.
+	Capacity int64 `json:"capacity"`
+
+	// Allocated is the number of addresses leased to nodes.
+	Allocated int64 `json:"allocated"`
+
+	// Remaining is the number of addresses left to lease.
+	Remaining int64 `json:"remaining"`
+
+	// Utilization is the percentage of the CIDR leased to nodes.
+	Utilization int32 `json:"utilization"`
+
+	// Groups are the addresses leased to the nodes of each node group of
+	// the mesh.
+	// +optional
+	Groups []NodeGroupCIDRUsage `json:"groups,omitempty"`
+
+	// Unassigned is the number of addresses leased to nodes that belong to
+	// none of the node groups, such as nodes joined outside the operator.
+	// +optional
+	Unassigned int64 `json:"unassigned,omitempty"`
+
+	// LastReportTime is when the nodes of the mesh were last listed.
+	LastReportTime metav1.Time `json:"lastReportTime"`
+}
+
+// NodeGroupCIDRUsage reports the addresses leased to the nodes of a group.
+type NodeGroupCIDRUsage struct {
+	// Name is the name of the node group.
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the node group.
+	Namespace string `json:"namespace"`
+
+	// Allocated is the number of addresses leased to its nodes.
+	Allocated int64 `json:"allocated"`
+}
+
+// ReconcileStatus records the outcome of the last reconcile of an object.
+type ReconcileStatus struct {
+	// LastReconcileTime is when the object was last reconciled.
+	// +optional
+	LastReconcileTime *metav1.Time `json:"lastReconcileTime,omitempty"`
+
+	// LastSuccessfulReconcileTime is when the object was last reconciled
+	// without an error.
+	// +optional
+	LastSuccessfulReconcileTime *metav1.Time `json:"lastSuccessfulReconcileTime,omitempty"`
+
+	// LastError is the error of the last reconcile, truncated. It is
+	// cleared once a reconcile succeeds.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+// BootstrapLBGroupSpec defines the load balancer node group of a Mesh. Fields
+// that are set replace those copied from the bootstrap group.
+type BootstrapLBGroupSpec struct {
+	// Image is the image to use for the load balancer nodes.
+	// Images may be referenced by tag, digest, or both.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Voter is whether the load balancer nodes request to be raft voters.
+	// Defaults to true.
+	// +optional
+	Voter *bool `json:"voter,omitempty"`
+
+	// ZoneAwarenessID is the zone awareness ID of the load balancer nodes.
+	// Defaults to the name of the bootstrap group.
+	// +optional
+	ZoneAwarenessID string `json:"zoneAwarenessID,omitempty"`
+
+	// ImagePullPolicy is the image pull policy to use for the load
+	// balancer nodes.
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// ImagePullSecrets is the list of image pull secrets to use for the
+	// load balancer nodes.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// PodAnnotations is the annotations to use for the load balancer pods.
+	// +optional
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// NodeSelector is the node selector to use for the load balancer pods.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Affinity is the affinity to use for the load balancer pods.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Tolerations is the tolerations to use for the load balancer pods.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// TopologySpreadConstraints is the topology spread constraints to use
+	// for the load balancer pods.
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// Resources is the resource requirements for the load balancer nodes.
+	// When either Resources or ResourcePreset is set, both replace the
+	// resources of the bootstrap group.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ResourcePreset is a preset of resource requirements for the load
+	// balancer nodes.
+	// +kubebuilder:validation:Enum:=small;medium;large
+	// +optional
+	ResourcePreset ResourcePreset `json:"resourcePreset,omitempty"`
+}
+
+// Validate validates the load balancer group spec.
+func (s *BootstrapLBGroupSpec) Validate(path *field.Path) error {
+	if s == nil {
+		return nil
+	}
+	if s.Image != "" {
+		if err := ValidateImage(path.Child("image"), s.Image); err != nil {
+			return err
+		}
+	}
+	if s.ZoneAwarenessID != "" {
+		if errs := validation.IsValidLabelValue(s.ZoneAwarenessID); len(errs) > 0 {
+			return field.Invalid(path.Child("zoneAwarenessID"), s.ZoneAwarenessID, strings.Join(errs, ", "))
+		}
+	}
+	return nil
 }
 
+// applyTo sets the fields of the given load balancer group that are set in
+// the spec.
+func (s *BootstrapLBGroupSpec) applyTo(group *NodeGroup) {
+	if s == nil {
+		return
+	}
+	spec := s.DeepCopy()
+	if spec.Image != "" {
+		group.Spec.Image = spec.Image
+	}
+	if spec.Voter != nil {
+		group.Spec.Config.Voter = *spec.Voter
+	}
+	if spec.ZoneAwarenessID != "" {
+		group.Labels[ZoneAwarenessLabel] = spec.ZoneAwarenessID
+	}
+	cluster := group.Spec.Cluster
+	if spec.ImagePullPolicy != "" {
+		cluster.ImagePullPolicy = spec.ImagePullPolicy
+	}
+	if spec.ImagePullSecrets != nil {
+		cluster.ImagePullSecrets = spec.ImagePullSecrets
+	}
+	if spec.PodAnnotations != nil {
+		cluster.PodAnnotations = spec.PodAnnotations
+	}
+	if spec.NodeSelector != nil {
+		cluster.NodeSelector = spec.NodeSelector
+	}
+	if spec.Affinity != nil {
+		cluster.Affinity = spec.Affinity
+	}
+	if spec.Tolerations != nil {
+		cluster.Tolerations = spec.Tolerations
+	}
+	if spec.TopologySpreadConstraints != nil {
+		cluster.TopologySpreadConstraints = spec.TopologySpreadConstraints
+	}
+	if spec.Resources != nil || spec.ResourcePreset != "" {
+		cluster.Resources = corev1.ResourceRequirements{}
+		if spec.Resources != nil {
+			cluster.Resources = *spec.Resources
+		}
+		cluster.ResourcePreset = spec.ResourcePreset
+	}
+}
+
+const (
+	// RestrictedCondition is the condition type set on meshes and node groups
+	// when the operator is not permitted to manage some of their resources.
+	RestrictedCondition = "Restricted"
+	// SimulatedCondition is the condition type set on meshes, node groups and
+	// peerings while the operator runs in dry-run mode and only simulates the
+	// changes to their resources.
+	SimulatedCondition = "Simulated"
+	// IssuerReadyCondition is the condition type set on meshes reflecting
+	// the Ready condition of the issuer of their certificates.
+	IssuerReadyCondition = "IssuerReady"
+	// CIDRExhaustionCondition is the condition type set on meshes whose
+	// IPv4 CIDR is leased beyond the utilization threshold of
+	// spec.cidrUsage.
+	CIDRExhaustionCondition = "CIDRExhaustion"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
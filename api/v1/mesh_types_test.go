@@ -0,0 +1,266 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBootstrapGroupsWithoutWebhooks(t *testing.T) {
+	// The mesh is not defaulted, as if it was created without the webhooks
+	mesh := &Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: MeshSpec{
+			Bootstrap: NodeGroupSpec{
+				Cluster: &NodeGroupClusterConfig{
+					Service: &NodeGroupLBConfig{},
+				},
+			},
+		},
+	}
+	groups := mesh.BootstrapGroups()
+	if len(groups) != 2 {
+		t.Fatalf("expected a bootstrap and LB group, got %d groups", len(groups))
+	}
+	for _, group := range groups {
+		spec := group.Spec
+		if spec.Image != DefaultNodeImage {
+			t.Errorf("%s: expected default image, got %q", group.GetName(), spec.Image)
+		}
+		if spec.Replicas == nil || spec.Profile == "" {
+			t.Errorf("%s: expected replicas and profile to be defaulted", group.GetName())
+		}
+		if spec.Config == nil || spec.Config.LogLevel == "" {
+			t.Errorf("%s: expected config to be defaulted", group.GetName())
+		}
+		if spec.Cluster == nil || spec.Cluster.ConfigStorage == "" || spec.Cluster.ImagePullPolicy == "" {
+			t.Fatalf("%s: expected cluster config to be defaulted", group.GetName())
+		}
+		if spec.Cluster.PVCSpec == nil {
+			t.Errorf("%s: expected bootstrap storage to be defaulted", group.GetName())
+		}
+	}
+	if groups[0].Spec.Cluster.Service != nil {
+		t.Errorf("expected the bootstrap group not to be exposed")
+	}
+	if lb := groups[1].Spec.Cluster.Service; lb == nil || lb.GRPCPort == 0 {
+		t.Errorf("expected the LB group service to be defaulted, got %+v", lb)
+	}
+	if mesh.Spec.Bootstrap.Replicas != nil || mesh.Spec.Bootstrap.Cluster.PVCSpec != nil {
+		t.Errorf("expected the mesh spec not to be modified")
+	}
+}
+
+func TestBootstrapGroupsLBSpec(t *testing.T) {
+	newMesh := func(lb *BootstrapLBGroupSpec) *Mesh {
+		return &Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+			Spec: MeshSpec{
+				Bootstrap: NodeGroupSpec{
+					Image: "example.com/webmesh-node:v1",
+					Cluster: &NodeGroupClusterConfig{
+						NodeSelector: map[string]string{"pool": "storage"},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+						},
+						Service: &NodeGroupLBConfig{},
+					},
+				},
+				BootstrapLB: lb,
+			},
+		}
+	}
+
+	// Without a spec the LB group copies the bootstrap group
+	groups := newMesh(nil).BootstrapGroups()
+	lb := groups[1]
+	if lb.Spec.Image != "example.com/webmesh-node:v1" || lb.Spec.Cluster.NodeSelector["pool"] != "storage" {
+		t.Fatalf("expected the LB group to copy the bootstrap group, got %+v", lb.Spec)
+	}
+	if !lb.Spec.Config.Voter || lb.Labels[ZoneAwarenessLabel] != groups[0].GetName() {
+		t.Fatalf("expected the LB group to vote in the bootstrap zone")
+	}
+
+	mesh := newMesh(&BootstrapLBGroupSpec{
+		Image:           "example.com/webmesh-node:v1-slim",
+		Voter:           new(bool),
+		ZoneAwarenessID: "edge",
+		NodeSelector:    map[string]string{"pool": "edge"},
+		ResourcePreset:  ResourcePresetSmall,
+	})
+	groups = mesh.BootstrapGroups()
+	bootstrap, lb := groups[0], groups[1]
+	if lb.Spec.Image != "example.com/webmesh-node:v1-slim" {
+		t.Errorf("expected the LB image to be set, got %q", lb.Spec.Image)
+	}
+	if lb.Spec.Config.Voter {
+		t.Errorf("expected the LB group not to vote")
+	}
+	if lb.Labels[ZoneAwarenessLabel] != "edge" {
+		t.Errorf("expected the LB zone awareness ID to be set, got %q", lb.Labels[ZoneAwarenessLabel])
+	}
+	if lb.Spec.Cluster.NodeSelector["pool"] != "edge" {
+		t.Errorf("expected the LB node selector to be set, got %v", lb.Spec.Cluster.NodeSelector)
+	}
+	if len(lb.Spec.Cluster.Resources.Requests) != 0 || lb.Spec.Cluster.ResourcePreset != ResourcePresetSmall {
+		t.Errorf("expected the LB resources to be replaced, got %+v", lb.Spec.Cluster.Resources)
+	}
+	if lb.Spec.Cluster.Service == nil || lb.Spec.Replicas == nil || *lb.Spec.Replicas != 1 {
+		t.Errorf("expected the LB group to expose a single replica")
+	}
+	if bootstrap.Spec.Image != "example.com/webmesh-node:v1" || bootstrap.Spec.Cluster.NodeSelector["pool"] != "storage" ||
+		bootstrap.Spec.Cluster.Resources.Requests == nil {
+		t.Errorf("expected the bootstrap group not to be modified, got %+v", bootstrap.Spec)
+	}
+
+	mesh.Spec.BootstrapLB.ZoneAwarenessID = "not a label value"
+	if err := mesh.validateBootstrapGroups(); err == nil {
+		t.Errorf("expected an invalid zone awareness ID to be rejected")
+	}
+}
+
+func TestSecurityConfig(t *testing.T) {
+	yes, no := true, false
+	tc := []struct {
+		name          string
+		createIssuer  bool
+		mesh          SecurityConfig
+		configGroup   *SecurityConfig
+		group         *SecurityConfig
+		verifyChain   bool
+		requireClient bool
+	}{
+		{
+			name:          "CreatedIssuer",
+			createIssuer:  true,
+			verifyChain:   true,
+			requireClient: true,
+		},
+		{
+			name:          "ExistingIssuer",
+			verifyChain:   false,
+			requireClient: true,
+		},
+		{
+			name:          "MeshOverridesDefaults",
+			createIssuer:  true,
+			mesh:          SecurityConfig{VerifyChainOnly: &no, RequireClientCert: &no},
+			verifyChain:   false,
+			requireClient: false,
+		},
+		{
+			name:          "GroupOverridesMesh",
+			mesh:          SecurityConfig{VerifyChainOnly: &no, RequireClientCert: &no},
+			group:         &SecurityConfig{VerifyChainOnly: &yes},
+			verifyChain:   true,
+			requireClient: false,
+		},
+		{
+			name:          "ConfigGroupOverridesMesh",
+			mesh:          SecurityConfig{RequireClientCert: &yes},
+			configGroup:   &SecurityConfig{VerifyChainOnly: &yes, RequireClientCert: &no},
+			verifyChain:   true,
+			requireClient: false,
+		},
+		{
+			name:          "GroupOverridesConfigGroup",
+			createIssuer:  true,
+			configGroup:   &SecurityConfig{VerifyChainOnly: &no, RequireClientCert: &no},
+			group:         &SecurityConfig{RequireClientCert: &yes},
+			verifyChain:   false,
+			requireClient: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := &Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+				Spec: MeshSpec{
+					Issuer:   IssuerConfig{Create: tt.createIssuer},
+					Security: tt.mesh,
+				},
+			}
+			group := &NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec:       NodeGroupSpec{Config: &NodeGroupConfig{Security: tt.group}},
+			}
+			if tt.configGroup != nil {
+				mesh.Spec.ConfigGroups = map[string]NodeGroupConfig{"secure": {Security: tt.configGroup}}
+				group.Spec.ConfigGroup = "secure"
+			}
+			before := mesh.DeepCopy()
+			groupcfg, err := group.MergedConfig(mesh)
+			if err != nil {
+				t.Fatalf("merge config: %v", err)
+			}
+			if got := mesh.VerifyChainOnly(groupcfg); got != tt.verifyChain {
+				t.Errorf("expected verify chain only to be %v, got %v", tt.verifyChain, got)
+			}
+			if got := mesh.RequireClientCert(groupcfg); got != tt.requireClient {
+				t.Errorf("expected require client cert to be %v, got %v", tt.requireClient, got)
+			}
+			// Without a group the mesh defaults apply
+			wantVerify, wantRequire := tt.createIssuer, true
+			if tt.mesh.VerifyChainOnly != nil {
+				wantVerify = *tt.mesh.VerifyChainOnly
+			}
+			if tt.mesh.RequireClientCert != nil {
+				wantRequire = *tt.mesh.RequireClientCert
+			}
+			if got := mesh.VerifyChainOnly(nil); got != wantVerify {
+				t.Errorf("expected the mesh to verify chain only %v, got %v", wantVerify, got)
+			}
+			if got := mesh.RequireClientCert(nil); got != wantRequire {
+				t.Errorf("expected the mesh to require client certs %v, got %v", wantRequire, got)
+			}
+			if !reflect.DeepEqual(mesh, before) {
+				t.Error("expected the mesh not to be modified")
+			}
+		})
+	}
+}
+
+func TestSecurityConfigMerge(t *testing.T) {
+	yes, no := true, false
+	base := &SecurityConfig{VerifyChainOnly: &yes, RequireClientCert: &yes}
+	override := &SecurityConfig{RequireClientCert: &no}
+	merged := base.Merge(override)
+	if merged.VerifyChainOnly == nil || !*merged.VerifyChainOnly {
+		t.Errorf("expected verify chain only to be kept, got %v", merged.VerifyChainOnly)
+	}
+	if merged.RequireClientCert == nil || *merged.RequireClientCert {
+		t.Errorf("expected require client cert to be overridden, got %v", merged.RequireClientCert)
+	}
+	// Neither side is modified or shared with the result
+	*merged.VerifyChainOnly = false
+	*merged.RequireClientCert = true
+	if !*base.VerifyChainOnly || !*base.RequireClientCert || *override.RequireClientCert || override.VerifyChainOnly != nil {
+		t.Error("expected the merged configs not to be modified")
+	}
+	var unset *SecurityConfig
+	if got := unset.Merge(override); !reflect.DeepEqual(got, override) || got == override {
+		t.Errorf("expected a copy of the override, got %+v", got)
+	}
+	if got := base.Merge(nil); !reflect.DeepEqual(got, base) || got == base {
+		t.Errorf("expected a copy of the base, got %+v", got)
+	}
+}
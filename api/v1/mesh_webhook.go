@@ -18,11 +18,14 @@ package v1
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -34,46 +37,84 @@ import (
 // log is for logging in this package.
 var meshlog = logf.Log.WithName("mesh-resource")
 
-func (r *Mesh) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// SetupWebhookWithManager registers the webhooks of the type. Unset images are
+// defaulted to the ones returned by images.
+func (r *Mesh) SetupWebhookWithManager(mgr ctrl.Manager, images ImagesFunc) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithDefaulter(&meshDefaulter{images: images}).
 		WithValidator(&meshValidator{Client: mgr.GetClient()}).
 		Complete()
 }
 
 //+kubebuilder:webhook:path=/mutate-mesh-webmesh-io-v1-mesh,mutating=true,failurePolicy=fail,sideEffects=None,groups=mesh.webmesh.io,resources=meshes,verbs=create;update,versions=v1,name=mmesh.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Defaulter = &Mesh{}
+var _ webhook.CustomDefaulter = &meshDefaulter{}
 
-// Default implements webhook.Defaulter so a webhook will be registered for the type
+// meshDefaulter defaults Meshes with the images in effect when they are
+// admitted.
+type meshDefaulter struct {
+	images ImagesFunc
+}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *meshDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	mesh := obj.(*Mesh)
+	if d.images != nil {
+		images, err := d.images(ctx)
+		if err != nil {
+			return fmt.Errorf("resolve default images: %w", err)
+		}
+		mesh.DefaultImage(images.Node)
+	}
+	mesh.Default()
+	return nil
+}
+
+// DefaultImage sets the node image of the mesh if it is unset. It is called
+// before Default to use an image other than the built-in default.
+func (r *Mesh) DefaultImage(image string) {
+	if r.Spec.Image == "" {
+		r.Spec.Image = image
+	}
+}
+
+// Default sets the defaults of the mesh. Unset images are given the built-in
+// defaults.
 func (r *Mesh) Default() {
 	meshlog.Info("defaulting", "name", r.Name)
 
-	// Ensure a default config for the bootstrap node group
-	if r.Spec.Bootstrap.Config == nil && r.Spec.Bootstrap.ConfigGroup == "" {
-		var nodegroupConfig NodeGroupConfig
-		nodegroupConfig.Default()
-		r.Spec.Bootstrap.Config = &nodegroupConfig
-	} else if r.Spec.Bootstrap.Config != nil {
-		r.Spec.Bootstrap.Config.Default()
+	r.expandProfile()
+	if r.Spec.Domain == "" {
+		r.Spec.Domain = DefaultMeshDomain
 	}
-	// TODO: Handle non-cluster bootstrap groups
-	if r.Spec.Bootstrap.Cluster == nil {
-		r.Spec.Bootstrap.Cluster = &NodeGroupClusterConfig{}
+	r.DefaultImage(DefaultNodeImage)
+	if r.Spec.MaxDefaultGateways == 0 {
+		r.Spec.MaxDefaultGateways = 1
 	}
-	r.Spec.Bootstrap.Cluster.Default()
-	if r.Spec.Bootstrap.Cluster.PVCSpec == nil {
-		// Require persistence for the bootstrap node group
-		r.Spec.Bootstrap.Cluster.PVCSpec = &corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-			Resources: corev1.ResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse(DefaultStorageSize),
-				},
-			},
+	if r.Spec.AdminConfig.Store == "" {
+		r.Spec.AdminConfig.Store = AdminConfigStoreKubernetesSecret
+	}
+	if vault := r.Spec.AdminConfig.Vault; vault != nil {
+		if vault.AuthMount == "" {
+			vault.AuthMount = "kubernetes"
+		}
+		if vault.Mount == "" {
+			vault.Mount = "secret"
+		}
+		if vault.Path == "" {
+			vault.Path = fmt.Sprintf("webmesh/%s/%s", r.GetNamespace(), r.GetName())
+		}
+	}
+
+	for i := range r.Spec.AccessProfiles {
+		if r.Spec.AccessProfiles[i].Role == "" {
+			r.Spec.AccessProfiles[i].Role = AccessProfileRoleReadOnly
 		}
 	}
 
+	defaultBootstrapSpec(&r.Spec.Bootstrap, r.Spec.persistentBootstrap())
+
 	// Set the issuer name if we are creating it
 	if r.Spec.Issuer.Create {
 		r.Spec.Issuer.IssuerRef = cmmeta.ObjectReference{
@@ -97,12 +138,68 @@ func (r *meshValidator) ValidateCreate(ctx context.Context, obj runtime.Object)
 	warnings := make(admission.Warnings, 0)
 	meshlog.Info("validating create", "name", o.Name)
 
+	if o.Spec.Profile != "" {
+		if _, ok := meshProfiles[o.Spec.Profile]; !ok {
+			return nil, field.NotSupported(
+				field.NewPath("spec", "profile"),
+				o.Spec.Profile,
+				supportedMeshProfiles())
+		}
+	}
+
 	if o.Spec.Bootstrap.GoogleCloud != nil {
 		return nil, field.Invalid(
 			field.NewPath("spec", "bootstrap", "googleCloud"),
 			o.Spec.Bootstrap.GoogleCloud,
 			"non-cluster bootstrap groups are not supported")
 	}
+	if o.Spec.Bootstrap.AWS != nil {
+		return nil, field.Invalid(
+			field.NewPath("spec", "bootstrap", "aws"),
+			o.Spec.Bootstrap.AWS,
+			"non-cluster bootstrap groups are not supported")
+	}
+	if o.Spec.Bootstrap.Azure != nil {
+		return nil, field.Invalid(
+			field.NewPath("spec", "bootstrap", "azure"),
+			o.Spec.Bootstrap.Azure,
+			"non-cluster bootstrap groups are not supported")
+	}
+	if o.Spec.Bootstrap.DigitalOcean != nil {
+		return nil, field.Invalid(
+			field.NewPath("spec", "bootstrap", "digitalOcean"),
+			o.Spec.Bootstrap.DigitalOcean,
+			"non-cluster bootstrap groups are not supported")
+	}
+	if o.Spec.Bootstrap.Hetzner != nil {
+		return nil, field.Invalid(
+			field.NewPath("spec", "bootstrap", "hetzner"),
+			o.Spec.Bootstrap.Hetzner,
+			"non-cluster bootstrap groups are not supported")
+	}
+	if o.Spec.Bootstrap.BareMetal != nil {
+		return nil, field.Invalid(
+			field.NewPath("spec", "bootstrap", "bareMetal"),
+			o.Spec.Bootstrap.BareMetal,
+			"non-cluster bootstrap groups are not supported")
+	}
+
+	// Validate the mesh domain
+	if o.Spec.Domain != "" {
+		if errs := validation.IsDNS1123Subdomain(o.Spec.Domain); len(errs) > 0 {
+			return nil, field.Invalid(
+				field.NewPath("spec", "domain"),
+				o.Spec.Domain,
+				strings.Join(errs, ", "))
+		}
+	}
+
+	if o.Spec.Bootstrap.Profile != "" && o.Spec.Bootstrap.Profile != NodeGroupProfileFull {
+		return nil, field.Invalid(
+			field.NewPath("spec", "bootstrap", "profile"),
+			o.Spec.Bootstrap.Profile,
+			"bootstrap groups must use the full profile")
+	}
 
 	// Validate bootstrap node group
 	if o.Spec.Bootstrap.ConfigGroup != "" {
@@ -114,6 +211,38 @@ func (r *meshValidator) ValidateCreate(ctx context.Context, obj runtime.Object)
 		}
 	}
 
+	if err := o.Spec.AdminConfig.Validate(field.NewPath("spec", "adminConfig")); err != nil {
+		return nil, err
+	}
+	if err := o.Spec.PropagateMetadata.Validate(field.NewPath("spec", "propagateMetadata")); err != nil {
+		return nil, err
+	}
+	if err := o.Spec.validateImages(); err != nil {
+		return nil, err
+	}
+	if err := o.Spec.validateConfigGroups(); err != nil {
+		return nil, err
+	}
+	if err := r.validateDefaultGateways(ctx, o); err != nil {
+		return nil, err
+	}
+	if err := o.Spec.validateAccessProfiles(); err != nil {
+		return nil, err
+	}
+	if err := o.Spec.validateAccessRequests(); err != nil {
+		return nil, err
+	}
+	if err := o.Spec.validateCIDRUsage(); err != nil {
+		return nil, err
+	}
+	if err := o.validateBootstrapGroups(); err != nil {
+		return nil, err
+	}
+	if err := o.validateBootstrapPorts(); err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, o.bootstrapLBWarnings()...)
+
 	// Validate Issuer configurations
 	if o.Spec.Issuer.IssuerRef.Name == "" {
 		if !o.Spec.Issuer.Create {
@@ -129,6 +258,13 @@ func (r *meshValidator) ValidateCreate(ctx context.Context, obj runtime.Object)
 				o.Spec.Issuer.IssuerRef.Kind,
 				"kind must not be empty if issuerRef.name is not empty")
 		}
+		if !o.Spec.Issuer.Create {
+			issuerWarnings, err := r.validateIssuer(ctx, o)
+			if err != nil {
+				return nil, err
+			}
+			warnings = append(warnings, issuerWarnings...)
+		}
 	}
 
 	return warnings, nil
@@ -145,6 +281,48 @@ func (r *meshValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runti
 			new.Spec.IPv4,
 			"ipv4 is immutable")
 	}
+	if old.Spec.Domain != new.Spec.Domain {
+		return nil, field.Invalid(
+			field.NewPath("spec", "domain"),
+			new.Spec.Domain,
+			"domain is immutable")
+	}
+	if old.Spec.Profile != new.Spec.Profile {
+		return nil, field.Invalid(
+			field.NewPath("spec", "profile"),
+			new.Spec.Profile,
+			"profile is immutable")
+	}
+	if err := new.Spec.AdminConfig.Validate(field.NewPath("spec", "adminConfig")); err != nil {
+		return nil, err
+	}
+	if err := new.Spec.PropagateMetadata.Validate(field.NewPath("spec", "propagateMetadata")); err != nil {
+		return nil, err
+	}
+	if err := new.Spec.validateImages(); err != nil {
+		return nil, err
+	}
+	if err := new.Spec.validateConfigGroups(); err != nil {
+		return nil, err
+	}
+	if err := r.validateDefaultGateways(ctx, new); err != nil {
+		return nil, err
+	}
+	if err := new.Spec.validateAccessProfiles(); err != nil {
+		return nil, err
+	}
+	if err := new.Spec.validateAccessRequests(); err != nil {
+		return nil, err
+	}
+	if err := new.Spec.validateCIDRUsage(); err != nil {
+		return nil, err
+	}
+	if err := new.validateBootstrapGroups(); err != nil {
+		return nil, err
+	}
+	if err := new.validateBootstrapPorts(); err != nil {
+		return nil, err
+	}
 	if old.Spec.Bootstrap.Cluster != nil {
 		if old.Spec.Bootstrap.Replicas != new.Spec.Bootstrap.Replicas {
 			return nil, field.Invalid(
@@ -164,12 +342,250 @@ func (r *meshValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runti
 				"changing to a persistent bootstrap node group is not supported")
 		}
 	}
+	warnings := new.bootstrapLBWarnings()
+	if !new.Spec.Issuer.Create && old.Spec.Issuer.IssuerRef != new.Spec.Issuer.IssuerRef {
+		issuerWarnings, err := r.validateIssuer(ctx, new)
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, issuerWarnings...)
+	}
+	return warnings, nil
+}
+
+// validateIssuer checks that the existing issuer referenced by the mesh can
+// issue its certificates. Certificates cannot reference a namespace-scoped
+// issuer in another namespace, so the mesh is rejected when the issuer only
+// exists elsewhere. An issuer that is missing or not ready only raises a
+// warning, since it may be created or become ready later.
+func (r *meshValidator) validateIssuer(ctx context.Context, mesh *Mesh) (admission.Warnings, error) {
+	path := field.NewPath("spec", "issuer", "issuerRef")
+	ref := mesh.Spec.Issuer.IssuerRef
+	if (ref.Group == "" || ref.Group == DefaultIssuerGroup) && ref.Kind != "Issuer" && ref.Kind != "ClusterIssuer" {
+		return nil, field.NotSupported(path.Child("kind"), ref.Kind, []string{"Issuer", "ClusterIssuer"})
+	}
+	state, err := mesh.LookupIssuer(ctx, r.Client)
+	if err != nil {
+		return admission.Warnings{fmt.Sprintf("%s: unable to verify the issuer: %v", path, err)}, nil
+	}
+	switch {
+	case !state.Found && len(state.OtherNamespaces) > 0:
+		return nil, field.Invalid(path.Child("name"), ref.Name, fmt.Sprintf(
+			"%s %s only exists in namespace(s) %s, certificates can only use issuers in namespace %s or cluster-scoped issuers",
+			ref.Kind, ref.Name, strings.Join(state.OtherNamespaces, ", "), mesh.GetNamespace()))
+	case !state.Found:
+		return admission.Warnings{fmt.Sprintf("%s: %s %s not found, certificates will not be issued until it is created", path, ref.Kind, ref.Name)}, nil
+	case !state.Ready:
+		return admission.Warnings{fmt.Sprintf("%s: %s %s is not ready: %s", path, ref.Kind, ref.Name, state.Message)}, nil
+	}
 	return nil, nil
 }
 
+// validateImages validates the image references of the mesh and its bootstrap group.
+func (s *MeshSpec) validateImages() error {
+	if s.Image != "" {
+		if err := ValidateImage(field.NewPath("spec", "image"), s.Image); err != nil {
+			return err
+		}
+	}
+	if s.Bootstrap.Image != "" {
+		return ValidateImage(field.NewPath("spec", "bootstrap", "image"), s.Bootstrap.Image)
+	}
+	return nil
+}
+
+// validateBootstrapGroups validates the load balancer group spec of the mesh,
+// and every group built from the bootstrap specs the same way as any other
+// node group, so that the mesh is not admitted with groups that would be
+// rejected once created.
+func (r *Mesh) validateBootstrapGroups() error {
+	if err := r.Spec.BootstrapLB.Validate(field.NewPath("spec", "bootstrapLB")); err != nil {
+		return err
+	}
+	for _, group := range r.BootstrapGroups() {
+		if err := group.Spec.Validate(); err != nil {
+			if group.GetName() == MeshBootstrapLBGroupName(r) {
+				return fmt.Errorf("invalid load balancer node group %s: %w", group.GetName(), err)
+			}
+			return fmt.Errorf("invalid bootstrap node group %s: %w", group.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// validateBootstrapPorts rejects bootstrap groups whose ports overlap.
+func (r *Mesh) validateBootstrapPorts() error {
+	for _, group := range r.BootstrapGroups() {
+		path := field.NewPath("spec", "bootstrap")
+		if group.GetName() == MeshBootstrapLBGroupName(r) {
+			path = field.NewPath("spec", "bootstrapLB")
+		}
+		cfg, err := group.MergedConfig(r)
+		if err != nil {
+			// Reported by the validation of the config groups
+			continue
+		}
+		if err := group.Spec.validatePorts(path, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapLBWarnings warns when a load balancer group spec is set that is
+// not used.
+func (r *Mesh) bootstrapLBWarnings() admission.Warnings {
+	if r.Spec.BootstrapLB == nil {
+		return nil
+	}
+	if r.Spec.Bootstrap.Cluster == nil || r.Spec.Bootstrap.Cluster.Service == nil {
+		return admission.Warnings{"spec.bootstrapLB has no effect unless spec.bootstrap.cluster.service is set"}
+	}
+	return nil
+}
+
+// validateConfigGroups validates the config groups and the bootstrap group config.
+func (s *MeshSpec) validateConfigGroups() error {
+	for name, cfg := range s.ConfigGroups {
+		if err := cfg.Validate(field.NewPath("spec", "configGroups").Key(name)); err != nil {
+			return err
+		}
+	}
+	return s.Bootstrap.Config.Validate(field.NewPath("spec", "bootstrap", "config"))
+}
+
+// validateDefaultGateways ensures that the config groups and the bootstrap
+// config of the mesh do not make more node groups advertise a default route
+// than spec.maxDefaultGateways allows. Existing node groups are merged with
+// the config groups of the mesh being admitted.
+func (r *meshValidator) validateDefaultGateways(ctx context.Context, mesh *Mesh) error {
+	var gateways []string
+	bootstraps := make(map[string]struct{})
+	for _, group := range mesh.BootstrapGroups() {
+		bootstraps[group.GetName()] = struct{}{}
+		if cfg, err := group.MergedConfig(mesh); err == nil && cfg.AdvertisesDefaultGateway() {
+			gateways = append(gateways, group.GetName())
+		}
+	}
+	var groups NodeGroupList
+	if err := r.List(ctx, &groups); err != nil {
+		return fmt.Errorf("list node groups: %w", err)
+	}
+	key := client.ObjectKeyFromObject(mesh)
+	for _, group := range groups.Items {
+		if group.GetDeletionTimestamp() != nil || group.MeshKey() != key {
+			continue
+		}
+		if _, ok := bootstraps[group.GetName()]; ok && group.GetNamespace() == mesh.GetNamespace() {
+			// Counted from the spec of the mesh
+			continue
+		}
+		if cfg, err := group.MergedConfig(mesh); err == nil && cfg.AdvertisesDefaultGateway() {
+			gateways = append(gateways, group.GetName())
+		}
+	}
+	limit := int(mesh.Spec.MaxDefaultGateways)
+	if limit == 0 {
+		limit = 1
+	}
+	if len(gateways) > limit {
+		return field.Invalid(field.NewPath("spec", "maxDefaultGateways"), limit, fmt.Sprintf(
+			"node groups %s would advertise a default gateway, set config.defaultGateway to accept or ignore in spec.configGroups or spec.bootstrap.config",
+			strings.Join(gateways, ", ")))
+	}
+	return nil
+}
+
+// validateAccessProfiles ensures access profiles have valid and unique names.
+func (s *MeshSpec) validateAccessProfiles() error {
+	seen := make(map[string]struct{}, len(s.AccessProfiles))
+	for i, profile := range s.AccessProfiles {
+		path := field.NewPath("spec", "accessProfiles").Index(i).Child("name")
+		if errs := validation.IsDNS1123Label(profile.Name); len(errs) > 0 {
+			return field.Invalid(path, profile.Name, strings.Join(errs, ", "))
+		}
+		if _, ok := seen[profile.Name]; ok {
+			return field.Duplicate(path, profile.Name)
+		}
+		seen[profile.Name] = struct{}{}
+	}
+	return nil
+}
+
+// validateAccessRequests ensures the access request config allows at least one
+// role and a duration that can be requested.
+func (s *MeshSpec) validateAccessRequests() error {
+	if s.AccessRequests == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "accessRequests")
+	if len(s.AccessRequests.AllowedRoles) == 0 {
+		return field.Required(path.Child("allowedRoles"), "at least one role must be allowed")
+	}
+	for i, role := range s.AccessRequests.AllowedRoles {
+		if role != AccessProfileRoleReadOnly && role != AccessProfileRoleManager {
+			return field.NotSupported(path.Child("allowedRoles").Index(i), role,
+				[]string{string(AccessProfileRoleReadOnly), string(AccessProfileRoleManager)})
+		}
+	}
+	if max := s.AccessRequests.MaxDuration; max != nil && max.Duration < MinAccessRequestDuration {
+		return field.Invalid(path.Child("maxDuration"), max.Duration.String(),
+			fmt.Sprintf("maxDuration must be at least %s", MinAccessRequestDuration))
+	}
+	return nil
+}
+
+// validateCIDRUsage ensures the nodes of the mesh are not listed more often
+// than the minimum report interval.
+func (s *MeshSpec) validateCIDRUsage() error {
+	if s.CIDRUsage == nil || s.CIDRUsage.Interval == nil {
+		return nil
+	}
+	if interval := s.CIDRUsage.Interval.Duration; interval < MinCIDRUsageInterval {
+		return field.Invalid(field.NewPath("spec", "cidrUsage", "interval"), interval.String(),
+			fmt.Sprintf("interval must be at least %s", MinCIDRUsageInterval))
+	}
+	return nil
+}
+
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (r *meshValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
 	o := obj.(*Mesh)
 	meshlog.Info("validating delete", "name", o.Name)
 	return nil, nil
 }
+
+// defaultBootstrapSpec sets the defaults of the bootstrap group spec of a Mesh.
+// Persistent bootstrap groups are given a PVC spec if they have none.
+func defaultBootstrapSpec(spec *NodeGroupSpec, persistent bool) {
+	// Ensure a default config for the bootstrap node group
+	if spec.Config == nil && spec.ConfigGroup == "" {
+		var nodegroupConfig NodeGroupConfig
+		nodegroupConfig.Default()
+		spec.Config = &nodegroupConfig
+	} else if spec.Config != nil {
+		spec.Config.Default()
+	}
+	// TODO: Handle non-cluster bootstrap groups
+	if spec.Cluster == nil {
+		spec.Cluster = &NodeGroupClusterConfig{}
+	}
+	spec.defaultWireGuardPort()
+	spec.Cluster.Default()
+	if spec.Cluster.ResourcePreset == "" {
+		// Bootstrap nodes hold the mesh storage, don't let them run as
+		// BestEffort pods.
+		spec.Cluster.ResourcePreset = ResourcePresetSmall
+	}
+	if persistent && spec.Cluster.PVCSpec == nil {
+		// Require persistence for the bootstrap node group
+		spec.Cluster.PVCSpec = &corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse(DefaultStorageSize),
+				},
+			},
+		}
+	}
+}
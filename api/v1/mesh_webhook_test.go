@@ -0,0 +1,229 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateDefaultGateways(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	// Two groups of the mesh use the egress config group
+	newGroup := func(name string) *NodeGroup {
+		return &NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: NodeGroupSpec{
+				Mesh:        corev1.ObjectReference{Name: "mesh"},
+				ConfigGroup: "egress",
+			},
+		}
+	}
+	other := newGroup("other-mesh")
+	other.Spec.Mesh.Name = "other"
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newGroup("egress-a"), newGroup("egress-b"), other).Build()
+	v := &meshValidator{Client: cli}
+
+	for _, tt := range []struct {
+		name      string
+		egress    DefaultGatewayMode
+		bootstrap DefaultGatewayMode
+		limit     int32
+		err       bool
+	}{
+		{name: "no gateways", egress: DefaultGatewayAccept},
+		{name: "bootstrap gateway", egress: DefaultGatewayIgnore, bootstrap: DefaultGatewayAdvertise},
+		{name: "config group over the limit", egress: DefaultGatewayAdvertise, err: true},
+		{name: "config group within the limit", egress: DefaultGatewayAdvertise, limit: 2},
+		{name: "config group and bootstrap over the limit", egress: DefaultGatewayAdvertise, bootstrap: DefaultGatewayAdvertise, limit: 2, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := &Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+				Spec: MeshSpec{
+					ConfigGroups:       map[string]NodeGroupConfig{"egress": {DefaultGateway: tt.egress}},
+					Bootstrap:          NodeGroupSpec{Config: &NodeGroupConfig{DefaultGateway: tt.bootstrap}},
+					MaxDefaultGateways: tt.limit,
+				},
+			}
+			err := v.validateDefaultGateways(context.Background(), mesh)
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// Modes are validated in config groups and the bootstrap config
+	for _, spec := range []MeshSpec{
+		{ConfigGroups: map[string]NodeGroupConfig{"egress": {DefaultGateway: "always"}}},
+		{Bootstrap: NodeGroupSpec{Config: &NodeGroupConfig{DefaultGateway: "always"}}},
+	} {
+		if err := spec.validateConfigGroups(); err == nil {
+			t.Errorf("expected an unsupported mode to be rejected in %+v", spec)
+		}
+	}
+}
+
+func TestValidateDomain(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	v := &meshValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	newMesh := func(domain string) *Mesh {
+		mesh := &Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+			Spec: MeshSpec{
+				Domain: domain,
+				Issuer: IssuerConfig{Create: true, Kind: "Issuer"},
+			},
+		}
+		mesh.Default()
+		return mesh
+	}
+
+	if got := newMesh("").Spec.Domain; got != DefaultMeshDomain {
+		t.Errorf("expected the domain to default to %q, got %q", DefaultMeshDomain, got)
+	}
+	for _, tt := range []struct {
+		name   string
+		domain string
+		err    bool
+	}{
+		{name: "default", domain: "webmesh.internal"},
+		{name: "subdomain", domain: "mesh.example.com"},
+		{name: "single label", domain: "internal"},
+		{name: "uppercase", domain: "Mesh.Internal", err: true},
+		{name: "underscore", domain: "mesh_a.internal", err: true},
+		{name: "leading hyphen", domain: "-mesh.internal", err: true},
+		{name: "trailing dot", domain: "mesh.internal.", err: true},
+		{name: "too long", domain: strings.Repeat("a.", 127) + "internal", err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), newMesh(tt.domain))
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "spec.domain") {
+				t.Errorf("expected the error to point at spec.domain, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Immutable", func(t *testing.T) {
+		old := newMesh("webmesh.internal")
+		if _, err := v.ValidateUpdate(context.Background(), old, old.DeepCopy()); err != nil {
+			t.Fatalf("expected an unchanged domain to be accepted, got %v", err)
+		}
+		_, err := v.ValidateUpdate(context.Background(), old, newMesh("mesh.example.com"))
+		if err == nil || !strings.Contains(err.Error(), "domain is immutable") {
+			t.Fatalf("expected a changed domain to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestValidateBootstrapKubeconfigReplicas(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	v := &meshValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	newMesh := func(replicas int32, kubeconfig bool) *Mesh {
+		mesh := &Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+			Spec: MeshSpec{
+				Issuer: IssuerConfig{Create: true, Kind: "Issuer"},
+				Bootstrap: NodeGroupSpec{
+					Replicas: &replicas,
+					Cluster:  &NodeGroupClusterConfig{},
+				},
+			},
+		}
+		if kubeconfig {
+			mesh.Spec.Bootstrap.Cluster.Kubeconfig = &corev1.SecretKeySelector{Key: "kubeconfig"}
+		}
+		mesh.Default()
+		return mesh
+	}
+
+	if _, err := v.ValidateCreate(context.Background(), newMesh(1, true)); err != nil {
+		t.Fatalf("expected a single bootstrap replica in a remote cluster to be accepted, got %v", err)
+	}
+	// The bootstrap group would be rejected once created
+	_, err := v.ValidateCreate(context.Background(), newMesh(3, true))
+	if err == nil || !strings.Contains(err.Error(), "invalid bootstrap node group") {
+		t.Fatalf("expected bootstrap replicas in a remote cluster to be rejected, got %v", err)
+	}
+	_, err = v.ValidateUpdate(context.Background(), newMesh(3, false), newMesh(3, true))
+	if err == nil || !strings.Contains(err.Error(), "invalid bootstrap node group") {
+		t.Fatalf("expected an update moving bootstrap replicas to a remote cluster to be rejected, got %v", err)
+	}
+}
+
+func TestValidateBootstrapPortsOnUpdate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	v := &meshValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	newMesh := func(metricsAddress string) *Mesh {
+		mesh := &Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+			Spec: MeshSpec{
+				Issuer: IssuerConfig{Create: true, Kind: "Issuer"},
+				ConfigGroups: map[string]NodeGroupConfig{
+					"metrics": {Services: &NodeServicesConfig{Metrics: &NodeMetricsConfig{ListenAddress: metricsAddress}}},
+				},
+				Bootstrap: NodeGroupSpec{ConfigGroup: "metrics"},
+			},
+		}
+		mesh.Default()
+		return mesh
+	}
+
+	old := newMesh(":8080")
+	if _, err := v.ValidateUpdate(context.Background(), old, newMesh(":8081")); err != nil {
+		t.Fatalf("expected distinct ports to be accepted, got %v", err)
+	}
+	// The config group moves the metrics of the bootstrap nodes onto the gRPC port
+	_, err := v.ValidateUpdate(context.Background(), old, newMesh(":8443"))
+	if err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("expected overlapping bootstrap ports to be rejected, got %v", err)
+	}
+}
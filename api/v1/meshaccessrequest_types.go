@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// MeshAccessRequestSpec defines the desired state of MeshAccessRequest
+type MeshAccessRequestSpec struct {
+	// Mesh is a reference to the Mesh to request access to. The namespace
+	// defaults to the namespace of the request.
+	// +kubebuilder:validation:Required
+	Mesh corev1.ObjectReference `json:"mesh"`
+
+	// Role is the role in the mesh requested. It must be allowed by the
+	// accessRequests of the Mesh.
+	// +kubebuilder:default:="ReadOnly"
+	// +optional
+	Role AccessProfileRole `json:"role,omitempty"`
+
+	// Duration is how long access is granted for once the request is
+	// approved. It must be at least an hour and no longer than the
+	// maxDuration of the Mesh.
+	// +kubebuilder:default:="1h"
+	// +optional
+	Duration metav1.Duration `json:"duration,omitempty"`
+}
+
+// Validate validates the MeshAccessRequestSpec.
+func (s *MeshAccessRequestSpec) Validate() error {
+	if s.Mesh.Name == "" {
+		return field.Required(field.NewPath("spec", "mesh", "name"), "mesh name is required")
+	}
+	if s.Role != AccessProfileRoleReadOnly && s.Role != AccessProfileRoleManager {
+		return field.NotSupported(field.NewPath("spec", "role"), s.Role,
+			[]string{string(AccessProfileRoleReadOnly), string(AccessProfileRoleManager)})
+	}
+	if s.Duration.Duration < MinAccessRequestDuration {
+		return field.Invalid(field.NewPath("spec", "duration"), s.Duration.String(),
+			"duration must be at least "+MinAccessRequestDuration.String())
+	}
+	return nil
+}
+
+// MeshAccessRequestPhase is the phase of a MeshAccessRequest.
+type MeshAccessRequestPhase string
+
+const (
+	// MeshAccessRequestPending is the phase of requests waiting to be
+	// approved or fulfilled.
+	MeshAccessRequestPending MeshAccessRequestPhase = "Pending"
+	// MeshAccessRequestIssued is the phase of requests whose config has been
+	// written.
+	MeshAccessRequestIssued MeshAccessRequestPhase = "Issued"
+	// MeshAccessRequestExpired is the phase of requests whose access has
+	// expired and been revoked.
+	MeshAccessRequestExpired MeshAccessRequestPhase = "Expired"
+)
+
+// MeshAccessRequestStatus defines the observed state of MeshAccessRequest
+type MeshAccessRequestStatus struct {
+	// Phase is the phase of the request.
+	// +optional
+	Phase MeshAccessRequestPhase `json:"phase,omitempty"`
+
+	// ExpiresAt is when access expires. It is set when the request is
+	// approved.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// SecretName is the name of the Secret in the namespace of the request
+	// holding the wmctl config under config.yaml.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Conditions are the current conditions of the request.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// MeshAccessRequestApproved is the condition type set when the request
+	// has been approved.
+	MeshAccessRequestApproved = "Approved"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Mesh",type=string,JSONPath=`.spec.mesh.name`
+//+kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.spec.role`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Expires",type=string,JSONPath=`.status.expiresAt`
+
+// MeshAccessRequest is the Schema for the meshaccessrequests API
+type MeshAccessRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MeshAccessRequestSpec   `json:"spec,omitempty"`
+	Status MeshAccessRequestStatus `json:"status,omitempty"`
+}
+
+// MeshKey returns the key of the Mesh access is requested to.
+func (r *MeshAccessRequest) MeshKey() types.NamespacedName {
+	key := types.NamespacedName{
+		Name:      r.Spec.Mesh.Name,
+		Namespace: r.Spec.Mesh.Namespace,
+	}
+	if key.Namespace == "" {
+		key.Namespace = r.GetNamespace()
+	}
+	return key
+}
+
+// IsApproved returns true if the request has been annotated as approved.
+func (r *MeshAccessRequest) IsApproved() bool {
+	return r.GetAnnotations()[AccessRequestApprovedAnnotation] == "true"
+}
+
+// IsExpired returns true if access granted by the request has expired at
+// the given time.
+func (r *MeshAccessRequest) IsExpired(now time.Time) bool {
+	return r.Status.ExpiresAt != nil && !now.Before(r.Status.ExpiresAt.Time)
+}
+
+//+kubebuilder:object:root=true
+
+// MeshAccessRequestList contains a list of MeshAccessRequest
+type MeshAccessRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MeshAccessRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MeshAccessRequest{}, &MeshAccessRequestList{})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var meshaccessrequestlog = logf.Log.WithName("meshaccessrequest-resource")
+
+func (r *MeshAccessRequest) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&meshAccessRequestValidator{
+			Client: mgr.GetClient(),
+		}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-mesh-webmesh-io-v1-meshaccessrequest,mutating=false,failurePolicy=fail,sideEffects=None,groups=mesh.webmesh.io,resources=meshaccessrequests,verbs=create;update,versions=v1,name=vmeshaccessrequest.kb.io,admissionReviewVersions=v1
+
+var _ webhook.CustomValidator = &meshAccessRequestValidator{}
+
+type meshAccessRequestValidator struct {
+	client.Client
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *meshAccessRequestValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	o := obj.(*MeshAccessRequest)
+	meshaccessrequestlog.Info("validating create", "name", o.Name)
+	if err := o.Spec.Validate(); err != nil {
+		return nil, err
+	}
+	if err := r.validateMesh(ctx, o); err != nil {
+		return nil, err
+	}
+	if o.IsApproved() {
+		return nil, r.validateApprover(ctx, o)
+	}
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *meshAccessRequestValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	o := oldObj.(*MeshAccessRequest)
+	n := newObj.(*MeshAccessRequest)
+	meshaccessrequestlog.Info("validating update", "name", o.Name)
+	if !equality.Semantic.DeepEqual(o.Spec, n.Spec) {
+		return nil, field.Invalid(field.NewPath("spec"), n.Spec, "spec is immutable")
+	}
+	approval := field.NewPath("metadata", "annotations").Key(AccessRequestApprovedAnnotation)
+	switch {
+	case o.IsApproved() && !n.IsApproved():
+		return nil, field.Forbidden(approval, "approval cannot be withdrawn, delete the request to revoke access")
+	case !o.IsApproved() && n.IsApproved():
+		return nil, r.validateApprover(ctx, n)
+	}
+	return nil, nil
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *meshAccessRequestValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	o := obj.(*MeshAccessRequest)
+	meshaccessrequestlog.Info("validating delete", "name", o.Name)
+	return nil, nil
+}
+
+// validateMesh ensures the referenced Mesh accepts access requests for the
+// requested role and duration.
+func (r *meshAccessRequestValidator) validateMesh(ctx context.Context, req *MeshAccessRequest) error {
+	path := field.NewPath("spec")
+	var mesh Mesh
+	if err := r.Get(ctx, req.MeshKey(), &mesh); err != nil {
+		if apierrors.IsNotFound(err) {
+			return field.NotFound(path.Child("mesh", "name"), req.Spec.Mesh.Name)
+		}
+		return fmt.Errorf("get mesh: %w", err)
+	}
+	config := mesh.Spec.AccessRequests
+	if config == nil {
+		return field.Forbidden(path.Child("mesh"), fmt.Sprintf("mesh %s does not accept access requests", mesh.GetName()))
+	}
+	if !config.Allows(req.Spec.Role) {
+		allowed := make([]string, len(config.AllowedRoles))
+		for i, role := range config.AllowedRoles {
+			allowed[i] = string(role)
+		}
+		return field.NotSupported(path.Child("role"), req.Spec.Role, allowed)
+	}
+	if max := config.MaxAccessDuration(); req.Spec.Duration.Duration > max {
+		return field.Invalid(path.Child("duration"), req.Spec.Duration.String(),
+			fmt.Sprintf("mesh %s allows access for at most %s", mesh.GetName(), max))
+	}
+	return nil
+}
+
+// validateApprover ensures the user approving the request may approve access
+// requests in the namespace of the Mesh.
+func (r *meshAccessRequestValidator) validateApprover(ctx context.Context, req *MeshAccessRequest) error {
+	path := field.NewPath("metadata", "annotations").Key(AccessRequestApprovedAnnotation)
+	admissionReq, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("get admission request: %w", err)
+	}
+	user := admissionReq.UserInfo
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: req.MeshKey().Namespace,
+				Verb:      "approve",
+				Group:     GroupVersion.Group,
+				Resource:  "meshaccessrequests",
+			},
+		},
+	}
+	if err := r.Create(ctx, review); err != nil {
+		return fmt.Errorf("review approver access: %w", err)
+	}
+	if !review.Status.Allowed {
+		return field.Forbidden(path, fmt.Sprintf("user %q may not approve access requests in namespace %s",
+			user.Username, req.MeshKey().Namespace))
+	}
+	return nil
+}
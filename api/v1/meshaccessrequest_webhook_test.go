@@ -0,0 +1,191 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestMeshAccessRequestValidator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	newMesh := func(config *AccessRequestConfig) *Mesh {
+		return &Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "platform"},
+			Spec:       MeshSpec{AccessRequests: config},
+		}
+	}
+	tc := []struct {
+		name     string
+		mesh     *Mesh
+		role     AccessProfileRole
+		duration time.Duration
+		approved bool
+		approver bool
+		err      bool
+	}{
+		{
+			name:     "allowed role",
+			mesh:     newMesh(&AccessRequestConfig{AllowedRoles: []AccessProfileRole{AccessProfileRoleReadOnly}}),
+			role:     AccessProfileRoleReadOnly,
+			duration: time.Hour,
+		},
+		{
+			name:     "role not allowed",
+			mesh:     newMesh(&AccessRequestConfig{AllowedRoles: []AccessProfileRole{AccessProfileRoleReadOnly}}),
+			role:     AccessProfileRoleManager,
+			duration: time.Hour,
+			err:      true,
+		},
+		{
+			name:     "access requests disabled",
+			mesh:     newMesh(nil),
+			role:     AccessProfileRoleReadOnly,
+			duration: time.Hour,
+			err:      true,
+		},
+		{
+			name:     "mesh not found",
+			role:     AccessProfileRoleReadOnly,
+			duration: time.Hour,
+			err:      true,
+		},
+		{
+			name:     "duration too short",
+			mesh:     newMesh(&AccessRequestConfig{AllowedRoles: []AccessProfileRole{AccessProfileRoleReadOnly}}),
+			role:     AccessProfileRoleReadOnly,
+			duration: time.Minute,
+			err:      true,
+		},
+		{
+			name:     "duration longer than allowed",
+			mesh:     newMesh(&AccessRequestConfig{AllowedRoles: []AccessProfileRole{AccessProfileRoleReadOnly}}),
+			role:     AccessProfileRoleReadOnly,
+			duration: 48 * time.Hour,
+			err:      true,
+		},
+		{
+			name:     "approved by an approver",
+			mesh:     newMesh(&AccessRequestConfig{AllowedRoles: []AccessProfileRole{AccessProfileRoleReadOnly}}),
+			role:     AccessProfileRoleReadOnly,
+			duration: time.Hour,
+			approved: true,
+			approver: true,
+		},
+		{
+			name:     "approved by the requester",
+			mesh:     newMesh(&AccessRequestConfig{AllowedRoles: []AccessProfileRole{AccessProfileRoleReadOnly}}),
+			role:     AccessProfileRoleReadOnly,
+			duration: time.Hour,
+			approved: true,
+			err:      true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Create: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+						if review, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+							attrs := review.Spec.ResourceAttributes
+							review.Status.Allowed = tt.approver && review.Spec.User == "approver" &&
+								attrs.Verb == "approve" && attrs.Namespace == "platform"
+							return nil
+						}
+						return cli.Create(ctx, obj, opts...)
+					},
+				})
+			if tt.mesh != nil {
+				builder = builder.WithObjects(tt.mesh)
+			}
+			user := "requester"
+			if tt.approver {
+				user = "approver"
+			}
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					UserInfo: authenticationv1.UserInfo{Username: user},
+				},
+			})
+			req := &MeshAccessRequest{
+				ObjectMeta: metav1.ObjectMeta{Name: "request", Namespace: "team"},
+				Spec: MeshAccessRequestSpec{
+					Mesh:     corev1.ObjectReference{Name: "mesh", Namespace: "platform"},
+					Role:     tt.role,
+					Duration: metav1.Duration{Duration: tt.duration},
+				},
+			}
+			if tt.approved {
+				req.Annotations = map[string]string{AccessRequestApprovedAnnotation: "true"}
+			}
+			v := &meshAccessRequestValidator{Client: builder.Build()}
+			_, err := v.ValidateCreate(ctx, req)
+			if tt.err && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.err && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMeshAccessRequestApprovalWithdrawn(t *testing.T) {
+	old := &MeshAccessRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "request",
+			Namespace:   "team",
+			Annotations: map[string]string{AccessRequestApprovedAnnotation: "true"},
+		},
+		Spec: MeshAccessRequestSpec{
+			Mesh:     corev1.ObjectReference{Name: "mesh"},
+			Role:     AccessProfileRoleReadOnly,
+			Duration: metav1.Duration{Duration: time.Hour},
+		},
+	}
+	new := old.DeepCopy()
+	new.Annotations = nil
+	v := &meshAccessRequestValidator{}
+	if _, err := v.ValidateUpdate(context.Background(), old, new); err == nil {
+		t.Fatal("expected an error withdrawing approval")
+	}
+	new = old.DeepCopy()
+	new.Spec.Role = AccessProfileRoleManager
+	if _, err := v.ValidateUpdate(context.Background(), old, new); err == nil {
+		t.Fatal("expected an error changing the spec")
+	}
+}
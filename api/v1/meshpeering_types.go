@@ -0,0 +1,184 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// MeshPeeringSpec defines the desired state of MeshPeering
+type MeshPeeringSpec struct {
+	// Image is the image to use for the bridge node. Defaults to the
+	// image of the local Mesh. Images may be referenced by tag, digest,
+	// or both.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Mesh is a reference to the local Mesh to peer. It must be in the
+	// same namespace as the MeshPeering.
+	// +kubebuilder:validation:Required
+	Mesh corev1.LocalObjectReference `json:"mesh"`
+
+	// Remote is the mesh to peer the local Mesh with.
+	// +kubebuilder:validation:Required
+	Remote MeshPeeringRemote `json:"remote"`
+
+	// ServiceType is the type of the service exposing the bridge node. When
+	// LoadBalancer, the gRPC and WireGuard ports of every bridged mesh are
+	// exposed through a load balancer and its address is advertised as the
+	// WireGuard endpoint of the bridge node.
+	// +kubebuilder:validation:Enum:=ClusterIP;LoadBalancer
+	// +kubebuilder:default:="ClusterIP"
+	// +optional
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// Endpoint is the host name or IP address, without a port, the bridge
+	// node advertises as its WireGuard endpoint. Defaults to the address of
+	// the load balancer when ServiceType is LoadBalancer, otherwise to the
+	// cluster DNS name of the bridge pod, which only nodes running in this
+	// cluster can reach. Peerings with an external mesh must set it or use
+	// a LoadBalancer.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// LogLevel is the log level to use for the bridge node.
+	// +kubebuilder:validation:Enum:=debug;info;warn;error
+	// +kubebuilder:default:="info"
+	// +optional
+	LogLevel string `json:"logLevel,omitempty"`
+}
+
+// Validate validates the MeshPeeringSpec.
+func (s *MeshPeeringSpec) Validate() error {
+	path := field.NewPath("spec", "remote")
+	if s.Mesh.Name == "" {
+		return field.Invalid(field.NewPath("spec", "mesh", "name"), s.Mesh.Name, "mesh name is required")
+	}
+	if s.Image != "" {
+		if err := ValidateImage(field.NewPath("spec", "image"), s.Image); err != nil {
+			return err
+		}
+	}
+	if (s.Remote.Mesh == nil) == (s.Remote.External == nil) {
+		return field.Invalid(path, s.Remote, "exactly one of mesh or external must be set")
+	}
+	if s.Remote.Mesh != nil && s.Remote.Mesh.Name == s.Mesh.Name {
+		return field.Invalid(path.Child("mesh", "name"), s.Remote.Mesh.Name, "a mesh cannot be peered with itself")
+	}
+	if s.Endpoint != "" && net.ParseIP(s.Endpoint) == nil {
+		if errs := validation.IsDNS1123Subdomain(s.Endpoint); len(errs) > 0 {
+			return field.Invalid(field.NewPath("spec", "endpoint"), s.Endpoint, strings.Join(errs, ", "))
+		}
+	}
+	if s.Remote.External != nil {
+		if s.Endpoint == "" && s.ServiceType != corev1.ServiceTypeLoadBalancer {
+			return field.Invalid(field.NewPath("spec", "endpoint"), s.Endpoint,
+				"an endpoint or a LoadBalancer service is required for the external mesh to reach the bridge node")
+		}
+		if s.Remote.External.JoinServer == "" {
+			return field.Invalid(path.Child("external", "joinServer"), s.Remote.External.JoinServer, "joinServer is required")
+		}
+		if s.Remote.External.Credentials.Name == "" {
+			return field.Invalid(path.Child("external", "credentials", "name"), s.Remote.External.Credentials.Name, "credentials are required")
+		}
+	}
+	return nil
+}
+
+// MeshPeeringRemote is the remote side of a MeshPeering. Exactly one of
+// Mesh or External must be set.
+type MeshPeeringRemote struct {
+	// Mesh is a reference to another Mesh managed by this operator. It
+	// must be in the same namespace as the MeshPeering.
+	// +optional
+	Mesh *corev1.LocalObjectReference `json:"mesh,omitempty"`
+
+	// External is the configuration for joining a mesh that is not
+	// managed by this operator.
+	// +optional
+	External *MeshPeeringExternal `json:"external,omitempty"`
+}
+
+// MeshPeeringExternal is the configuration for joining an external mesh.
+type MeshPeeringExternal struct {
+	// JoinServer is the address of a node in the external mesh to join.
+	// +kubebuilder:validation:Required
+	JoinServer string `json:"joinServer"`
+
+	// Credentials is a reference to a TLS secret containing a tls.crt,
+	// tls.key, and ca.crt for joining the external mesh. The certificate
+	// must be issued for the bridge node's name.
+	// +kubebuilder:validation:Required
+	Credentials corev1.LocalObjectReference `json:"credentials"`
+
+	// VerifyChainOnly is true if only the certificate chain of the
+	// external mesh's nodes should be verified.
+	// +optional
+	VerifyChainOnly bool `json:"verifyChainOnly,omitempty"`
+}
+
+// MeshPeeringStatus defines the observed state of MeshPeering
+type MeshPeeringStatus struct {
+	// Conditions are the current conditions of the peering.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// MeshPeeringBridgeUp is the condition type set when the bridge node
+	// running the current configuration has joined every bridged mesh.
+	MeshPeeringBridgeUp = "BridgeUp"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Mesh",type=string,JSONPath=`.spec.mesh.name`
+//+kubebuilder:printcolumn:name="Bridge",type=string,JSONPath=`.status.conditions[?(@.type=="BridgeUp")].status`
+
+// MeshPeering is the Schema for the meshpeerings API
+type MeshPeering struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MeshPeeringSpec   `json:"spec,omitempty"`
+	Status MeshPeeringStatus `json:"status,omitempty"`
+}
+
+// IsExternal returns true if the remote side of the peering is not
+// managed by this operator.
+func (p *MeshPeering) IsExternal() bool {
+	return p.Spec.Remote.External != nil
+}
+
+//+kubebuilder:object:root=true
+
+// MeshPeeringList contains a list of MeshPeering
+type MeshPeeringList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MeshPeering `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MeshPeering{}, &MeshPeeringList{})
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMeshPeeringExternalEndpoint(t *testing.T) {
+	external := MeshPeeringRemote{External: &MeshPeeringExternal{
+		JoinServer:  "198.51.100.20:8443",
+		Credentials: corev1.LocalObjectReference{Name: "external-tls"},
+	}}
+	tc := []struct {
+		name        string
+		serviceType corev1.ServiceType
+		endpoint    string
+		valid       bool
+	}{
+		{name: "cluster address only"},
+		{name: "load balancer", serviceType: corev1.ServiceTypeLoadBalancer, valid: true},
+		{name: "explicit host name", endpoint: "bridge.example.com", valid: true},
+		{name: "explicit address", endpoint: "2001:db8::10", valid: true},
+		{name: "endpoint with port", endpoint: "bridge.example.com:51820"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := MeshPeeringSpec{
+				Mesh:        corev1.LocalObjectReference{Name: "local"},
+				Remote:      external,
+				ServiceType: tt.serviceType,
+				Endpoint:    tt.endpoint,
+			}
+			if err := spec.Validate(); (err == nil) != tt.valid {
+				t.Errorf("expected valid to be %v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var meshpeeringlog = logf.Log.WithName("meshpeering-resource")
+
+func (r *MeshPeering) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&meshPeeringValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-mesh-webmesh-io-v1-meshpeering,mutating=false,failurePolicy=fail,sideEffects=None,groups=mesh.webmesh.io,resources=meshpeerings,verbs=create;update,versions=v1,name=vmeshpeering.kb.io,admissionReviewVersions=v1
+
+var _ webhook.CustomValidator = &meshPeeringValidator{}
+
+type meshPeeringValidator struct{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *meshPeeringValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	o := obj.(*MeshPeering)
+	meshpeeringlog.Info("validating create", "name", o.Name)
+	return nil, o.Spec.Validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *meshPeeringValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	o := oldObj.(*MeshPeering)
+	n := newObj.(*MeshPeering)
+	meshpeeringlog.Info("validating update", "name", o.Name)
+	if o.Spec.Mesh.Name != n.Spec.Mesh.Name {
+		return nil, field.Invalid(
+			field.NewPath("spec", "mesh", "name"),
+			n.Spec.Mesh.Name,
+			"mesh is immutable")
+	}
+	return nil, n.Spec.Validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *meshPeeringValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	o := obj.(*MeshPeering)
+	meshpeeringlog.Info("validating delete", "name", o.Name)
+	return nil, nil
+}
@@ -71,6 +71,24 @@ func MeshManagerConfigName(mesh *Mesh) string {
 	return fmt.Sprintf("%s-manager-config", mesh.GetName())
 }
 
+// MeshManagerCertName returns the name of the certificate of the manager
+// config for the given Mesh. It is also the user the config authenticates as.
+func MeshManagerCertName(mesh *Mesh) string {
+	return fmt.Sprintf("%s-manager", mesh.GetName())
+}
+
+// MeshAccessProfileCertName returns the name of the certificate for the given
+// Mesh access profile. It is also the user the profile authenticates as.
+func MeshAccessProfileCertName(mesh *Mesh, profile *AccessProfile) string {
+	return fmt.Sprintf("%s-access-%s", mesh.GetName(), profile.Name)
+}
+
+// MeshAccessProfileConfigName returns the name of the config for the given Mesh
+// access profile.
+func MeshAccessProfileConfigName(mesh *Mesh, profile *AccessProfile) string {
+	return fmt.Sprintf("%s-access-%s-config", mesh.GetName(), profile.Name)
+}
+
 // MeshAdminHostname returns the hostname for the given Mesh admin.
 func MeshAdminHostname(mesh *Mesh) string {
 	return fmt.Sprintf("%s-admin", mesh.GetName())
@@ -104,8 +122,9 @@ func MeshNodeHostname(mesh *Mesh, group *NodeGroup, index int) string {
 	return MeshNodeGroupPodName(mesh, group, index)
 }
 
-// MeshNodeDNSNames returns the DNS names for the given Mesh node.
-func MeshNodeDNSNames(mesh *Mesh, group *NodeGroup, index int) []string {
+// MeshNodeDNSNames returns the DNS names for the given Mesh node in a cluster
+// with the given DNS domain.
+func MeshNodeDNSNames(mesh *Mesh, group *NodeGroup, index int, clusterDomain string) []string {
 	svcName := MeshNodeGroupHeadlessServiceName(mesh, group)
 	podName := MeshNodeGroupPodName(mesh, group, index)
 	return []string{
@@ -113,32 +132,37 @@ func MeshNodeDNSNames(mesh *Mesh, group *NodeGroup, index int) []string {
 		svcName,
 		fmt.Sprintf("%s.%s", svcName, group.GetNamespace()),
 		fmt.Sprintf("%s.%s.svc", svcName, group.GetNamespace()),
-		MeshNodeGroupHeadlessServiceFQDN(mesh, group),
+		MeshNodeGroupHeadlessServiceFQDN(mesh, group, clusterDomain),
 		// Pod Names
 		fmt.Sprintf("%s.%s", podName, svcName),
 		fmt.Sprintf("%s.%s.%s", podName, svcName, group.GetNamespace()),
 		fmt.Sprintf("%s.%s.%s.svc", podName, svcName, group.GetNamespace()),
-		MeshNodeClusterFQDN(mesh, group, index),
+		MeshNodeClusterFQDN(mesh, group, index, clusterDomain),
 	}
 }
 
 // MeshNodeGroupHeadlessServiceFQDN returns the cluster FQDN for the given Mesh node group's
-// headless service.
-func MeshNodeGroupHeadlessServiceFQDN(mesh *Mesh, group *NodeGroup) string {
-	return fmt.Sprintf("%s.%s.svc.cluster.local",
+// headless service in a cluster with the given DNS domain.
+func MeshNodeGroupHeadlessServiceFQDN(mesh *Mesh, group *NodeGroup, clusterDomain string) string {
+	return fmt.Sprintf("%s.%s.svc.%s",
 		MeshNodeGroupHeadlessServiceName(mesh, group),
-		group.GetNamespace())
+		group.GetNamespace(),
+		clusterDomain)
 }
 
-// MeshNodeClusterFQDN returns the cluster FQDN for the given Mesh node.
-func MeshNodeClusterFQDN(mesh *Mesh, group *NodeGroup, index int) string {
+// MeshNodeClusterFQDN returns the cluster FQDN for the given Mesh node in a
+// cluster with the given DNS domain.
+func MeshNodeClusterFQDN(mesh *Mesh, group *NodeGroup, index int, clusterDomain string) string {
 	return fmt.Sprintf("%s.%s",
 		MeshNodeGroupPodName(mesh, group, index),
-		MeshNodeGroupHeadlessServiceFQDN(mesh, group))
+		MeshNodeGroupHeadlessServiceFQDN(mesh, group, clusterDomain))
 }
 
 // MeshNodeGroupStatefulSetName returns the name of the StatefulSet for the given Mesh node group.
 func MeshNodeGroupStatefulSetName(mesh *Mesh, group *NodeGroup) string {
+	if adopt := group.adoptExisting(); adopt != nil {
+		return adopt.StatefulSetName
+	}
 	if strings.HasPrefix(group.GetName(), mesh.GetName()) {
 		return group.GetName()
 	}
@@ -155,8 +179,17 @@ func MeshNodeGroupLBName(mesh *Mesh, group *NodeGroup) string {
 	return fmt.Sprintf("%s-public", MeshNodeGroupStatefulSetName(mesh, group))
 }
 
+// MeshNodeGroupReplicaLBName returns the name of the LB Service of a single
+// replica of the given Mesh node group.
+func MeshNodeGroupReplicaLBName(mesh *Mesh, group *NodeGroup, index int) string {
+	return fmt.Sprintf("%s-%d", MeshNodeGroupLBName(mesh, group), index)
+}
+
 // MeshNodeGroupConfigMapName returns the name of the ConfigMap for the given Mesh node group.
 func MeshNodeGroupConfigMapName(mesh *Mesh, group *NodeGroup) string {
+	if adopt := group.adoptExisting(); adopt != nil {
+		return adopt.ConfigMapName
+	}
 	return MeshNodeGroupStatefulSetName(mesh, group)
 }
 
@@ -167,29 +200,32 @@ func MeshNodeGroupHeadlessServiceName(mesh *Mesh, group *NodeGroup) string {
 
 // MeshLabels returns the labels for the given Mesh.
 func MeshLabels(mesh *Mesh) map[string]string {
-	labels := mesh.GetLabels()
-	if labels == nil {
-		labels = map[string]string{}
-	}
-	for k, v := range MeshSelector(mesh) {
+	labels := mesh.Spec.PropagateMetadata.PropagatedLabels(mesh.GetLabels())
+	for k, v := range SelectorLabels(MeshSelector(mesh)) {
 		labels[k] = v
 	}
 	return labels
 }
 
+// MeshAnnotations returns the annotations of the given Mesh copied onto the
+// objects created for it.
+func MeshAnnotations(mesh *Mesh) map[string]string {
+	return mesh.Spec.PropagateMetadata.PropagatedAnnotations(mesh.GetAnnotations())
+}
+
 // MeshSelector returns the selector for the given Mesh.
 func MeshSelector(mesh *Mesh) map[string]string {
 	return map[string]string{
-		MeshNameLabel:      mesh.GetName(),
-		MeshNamespaceLabel: mesh.GetNamespace(),
+		LabelKey(MeshNameLabel):      mesh.GetName(),
+		LabelKey(MeshNamespaceLabel): mesh.GetNamespace(),
 	}
 }
 
 // NodeGroupLabels returns the labels for the given Mesh node group.
 func NodeGroupLabels(mesh *Mesh, group *NodeGroup) map[string]string {
 	labels := MeshLabels(mesh)
-	groupLabels := group.GetLabels()
-	for k, v := range NodeGroupSelector(mesh, group) {
+	groupLabels := mesh.Spec.PropagateMetadata.PropagatedLabels(group.GetLabels())
+	for k, v := range SelectorLabels(NodeGroupSelector(mesh, group)) {
 		labels[k] = v
 	}
 	for k, v := range groupLabels {
@@ -198,19 +234,111 @@ func NodeGroupLabels(mesh *Mesh, group *NodeGroup) map[string]string {
 	return labels
 }
 
+// NodeGroupAnnotations returns the annotations of the given Mesh node group
+// copied onto the objects created for it.
+func NodeGroupAnnotations(mesh *Mesh, group *NodeGroup) map[string]string {
+	return mesh.Spec.PropagateMetadata.PropagatedAnnotations(group.GetAnnotations())
+}
+
 // NodeGroupSelector returns the selector for the given Mesh node group.
 func NodeGroupSelector(mesh *Mesh, group *NodeGroup) map[string]string {
 	labels := MeshSelector(mesh)
-	labels[NodeGroupNameLabel] = group.GetName()
-	labels[NodeGroupNamespaceLabel] = group.GetNamespace()
+	labels[LabelKey(NodeGroupNameLabel)] = group.GetName()
+	labels[LabelKey(NodeGroupNamespaceLabel)] = group.GetNamespace()
 	return labels
 }
 
 // MeshBootstrapGroupSelector returns the selector for a Mesh's bootstrap node group.
 func MeshBootstrapGroupSelector(mesh *Mesh) map[string]string {
 	return map[string]string{
-		MeshNameLabel:           mesh.GetName(),
-		MeshNamespaceLabel:      mesh.GetNamespace(),
-		BootstrapNodeGroupLabel: "true",
+		LabelKey(MeshNameLabel):           mesh.GetName(),
+		LabelKey(MeshNamespaceLabel):      mesh.GetNamespace(),
+		LabelKey(BootstrapNodeGroupLabel): "true",
 	}
 }
+
+// MeshPeeringBridgeName returns the name of the bridge node for the given MeshPeering.
+func MeshPeeringBridgeName(peering *MeshPeering) string {
+	return fmt.Sprintf("%s-bridge", peering.GetName())
+}
+
+// MeshPeeringBridgePodName returns the name of the bridge node Pod for the given MeshPeering.
+func MeshPeeringBridgePodName(peering *MeshPeering) string {
+	return fmt.Sprintf("%s-0", MeshPeeringBridgeName(peering))
+}
+
+// MeshPeeringBridgeServiceFQDN returns the cluster FQDN for the given MeshPeering's
+// bridge headless service in a cluster with the given DNS domain.
+func MeshPeeringBridgeServiceFQDN(peering *MeshPeering, clusterDomain string) string {
+	return fmt.Sprintf("%s.%s.svc.%s",
+		MeshPeeringBridgeName(peering),
+		peering.GetNamespace(),
+		clusterDomain)
+}
+
+// MeshPeeringBridgeLBName returns the name of the LB Service exposing the
+// bridge node of the given MeshPeering.
+func MeshPeeringBridgeLBName(peering *MeshPeering) string {
+	return fmt.Sprintf("%s-public", MeshPeeringBridgeName(peering))
+}
+
+// MeshPeeringCertName returns the name of the certificate issued to the bridge node
+// of the given MeshPeering by the given Mesh.
+func MeshPeeringCertName(peering *MeshPeering, mesh *Mesh) string {
+	return fmt.Sprintf("%s-%s", MeshPeeringBridgeName(peering), mesh.GetName())
+}
+
+// MeshPeeringLabels returns the labels for the given MeshPeering.
+func MeshPeeringLabels(peering *MeshPeering) map[string]string {
+	// Peerings span meshes, only the default keys are copied
+	var policy *MetadataPropagation
+	labels := policy.PropagatedLabels(peering.GetLabels())
+	for k, v := range SelectorLabels(MeshPeeringSelector(peering)) {
+		labels[k] = v
+	}
+	return labels
+}
+
+// MeshPeeringSelector returns the selector for the given MeshPeering's bridge node.
+func MeshPeeringSelector(peering *MeshPeering) map[string]string {
+	return map[string]string{
+		LabelKey(MeshPeeringNameLabel):      peering.GetName(),
+		LabelKey(MeshPeeringNamespaceLabel): peering.GetNamespace(),
+	}
+}
+
+// MeshPeeringBridgeDNSNames returns the DNS names for the given MeshPeering's bridge node
+// in a cluster with the given DNS domain.
+func MeshPeeringBridgeDNSNames(peering *MeshPeering, clusterDomain string) []string {
+	svcName := MeshPeeringBridgeName(peering)
+	podName := MeshPeeringBridgePodName(peering)
+	return []string{
+		svcName,
+		fmt.Sprintf("%s.%s", svcName, peering.GetNamespace()),
+		fmt.Sprintf("%s.%s.svc", svcName, peering.GetNamespace()),
+		MeshPeeringBridgeServiceFQDN(peering, clusterDomain),
+		fmt.Sprintf("%s.%s", podName, MeshPeeringBridgeServiceFQDN(peering, clusterDomain)),
+	}
+}
+
+// MeshAccessRequestCertName returns the name of the certificate issued by the
+// given Mesh for the given MeshAccessRequest. It is also the user the request
+// authenticates as. The certificate lives in the namespace of the Mesh.
+func MeshAccessRequestCertName(mesh *Mesh, req *MeshAccessRequest) string {
+	return fmt.Sprintf("%s-request-%s-%s", mesh.GetName(), req.GetNamespace(), req.GetName())
+}
+
+// MeshAccessRequestConfigName returns the name of the Secret holding the config
+// for the given MeshAccessRequest in its namespace.
+func MeshAccessRequestConfigName(req *MeshAccessRequest) string {
+	return fmt.Sprintf("%s-config", req.GetName())
+}
+
+// MeshAccessRequestLabels returns the labels for the resources of the given
+// MeshAccessRequest.
+func MeshAccessRequestLabels(req *MeshAccessRequest) map[string]string {
+	return SelectorLabels(map[string]string{
+		LabelKey(MeshAccessRequestNameLabel):      req.GetName(),
+		LabelKey(MeshAccessRequestNamespaceLabel): req.GetNamespace(),
+	})
+}
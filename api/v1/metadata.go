@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// MetadataPropagation declares additional labels and annotations to copy onto
+// the objects created for a mesh and its node groups.
+type MetadataPropagation struct {
+	// Labels are the keys of the labels to copy. A key ending in "*" matches
+	// every key starting with what precedes it, such as "example.com/*", and
+	// "*" alone matches every key.
+	// +optional
+	Labels []string `json:"labels,omitempty"`
+
+	// Annotations are the keys of the annotations to copy, matched the same
+	// way as Labels.
+	// +optional
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+var (
+	// propagatedKeys are the keys copied regardless of the policy.
+	propagatedKeys = []string{
+		LegacyLabelPrefix + "*",
+		GroupLabelPrefix + "*",
+		"app.kubernetes.io/name",
+		"app.kubernetes.io/part-of",
+	}
+	// toolingKeys are the keys written by deployment tooling to track the
+	// objects it manages. They are never copied, as the tooling would
+	// consider the created objects its own, and some of them hold entire
+	// manifests that could exceed the size limit of the annotations.
+	toolingKeys = []string{
+		"kubectl.kubernetes.io/*",
+		"kustomize.toolkit.fluxcd.io/*",
+		"helm.toolkit.fluxcd.io/*",
+		"argocd.argoproj.io/*",
+		"meta.helm.sh/*",
+	}
+)
+
+// Validate validates the metadata propagation policy.
+func (p *MetadataPropagation) Validate(path *field.Path) error {
+	if p == nil {
+		return nil
+	}
+	if err := validatePropagatedKeys(path.Child("labels"), p.Labels); err != nil {
+		return err
+	}
+	return validatePropagatedKeys(path.Child("annotations"), p.Annotations)
+}
+
+// validatePropagatedKeys validates the keys of a metadata propagation policy.
+func validatePropagatedKeys(path *field.Path, keys []string) error {
+	for i, key := range keys {
+		name, wildcard := strings.CutSuffix(key, "*")
+		if wildcard {
+			// Complete the prefix to a name that can be validated
+			name += "x"
+		}
+		if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+			return field.Invalid(path.Index(i), key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// PropagatedLabels returns the given labels that are copied onto the objects
+// created for their owner.
+func (p *MetadataPropagation) PropagatedLabels(labels map[string]string) map[string]string {
+	var keys []string
+	if p != nil {
+		keys = p.Labels
+	}
+	return propagate(labels, keys)
+}
+
+// PropagatedAnnotations returns the given annotations that are copied onto the
+// objects created for their owner.
+func (p *MetadataPropagation) PropagatedAnnotations(annotations map[string]string) map[string]string {
+	var keys []string
+	if p != nil {
+		keys = p.Annotations
+	}
+	return propagate(annotations, keys)
+}
+
+// propagate returns a copy of the metadata with the keys that are propagated
+// by default or match one of the given keys.
+func propagate(metadata map[string]string, keys []string) map[string]string {
+	out := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if matchesKey(k, toolingKeys) {
+			continue
+		}
+		if matchesKey(k, propagatedKeys) || matchesKey(k, keys) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// matchesKey returns true if the key matches one of the given keys.
+func matchesKey(key string, keys []string) bool {
+	for _, k := range keys {
+		if prefix, ok := strings.CutSuffix(k, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+			continue
+		}
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestMetadataPropagation(t *testing.T) {
+	lastApplied := "kubectl.kubernetes.io/last-applied-configuration"
+	annotations := map[string]string{
+		lastApplied:                               `{"apiVersion":"mesh.webmesh.io/v1"}`,
+		"kustomize.toolkit.fluxcd.io/name":        "infra",
+		"argocd.argoproj.io/sync-wave":            "1",
+		BootstrapNodeGroupAnnotation:              "true",
+		"example.com/owner":                       "team",
+		"example.com/cost-center":                 "1234",
+		"prometheus.io/scrape":                    "true",
+		"mesh.webmesh.io/skip-preflight":          "true",
+		"kubectl.kubernetes.io/default-container": "node",
+	}
+	tc := []struct {
+		name   string
+		policy *MetadataPropagation
+		want   map[string]string
+	}{
+		{
+			name: "default",
+			want: map[string]string{
+				BootstrapNodeGroupAnnotation:     "true",
+				"mesh.webmesh.io/skip-preflight": "true",
+			},
+		},
+		{
+			name:   "declared keys",
+			policy: &MetadataPropagation{Annotations: []string{"example.com/*", "prometheus.io/scrape"}},
+			want: map[string]string{
+				BootstrapNodeGroupAnnotation:     "true",
+				"mesh.webmesh.io/skip-preflight": "true",
+				"example.com/owner":              "team",
+				"example.com/cost-center":        "1234",
+				"prometheus.io/scrape":           "true",
+			},
+		},
+		{
+			name:   "tooling keys cannot be declared",
+			policy: &MetadataPropagation{Annotations: []string{lastApplied, "kubectl.kubernetes.io/*"}},
+			want: map[string]string{
+				BootstrapNodeGroupAnnotation:     "true",
+				"mesh.webmesh.io/skip-preflight": "true",
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.PropagatedAnnotations(annotations)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("objects", func(t *testing.T) {
+		mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{
+			Name:        "mesh",
+			Namespace:   "default",
+			Labels:      map[string]string{"app.kubernetes.io/part-of": "network", "app.kubernetes.io/instance": "infra"},
+			Annotations: map[string]string{lastApplied: "{}"},
+		}}
+		if _, ok := MeshAnnotations(mesh)[lastApplied]; ok {
+			t.Errorf("expected the last applied configuration not to be copied")
+		}
+		labels := MeshLabels(mesh)
+		if labels["app.kubernetes.io/part-of"] != "network" {
+			t.Errorf("expected the part-of label to be copied, got %v", labels)
+		}
+		if _, ok := labels["app.kubernetes.io/instance"]; ok {
+			t.Errorf("expected the instance label not to be copied, got %v", labels)
+		}
+		for _, group := range mesh.BootstrapGroups() {
+			if _, ok := group.GetAnnotations()[lastApplied]; ok {
+				t.Errorf("expected the last applied configuration not to be copied onto %s", group.GetName())
+			}
+		}
+		if _, ok := mesh.GetLabels()[MeshNameLabel]; ok {
+			t.Errorf("expected the labels of the mesh to be left unchanged")
+		}
+	})
+}
+
+func TestMetadataPropagationValidate(t *testing.T) {
+	path := field.NewPath("spec", "propagateMetadata")
+	valid := &MetadataPropagation{
+		Labels:      []string{"team", "example.com/*", "*"},
+		Annotations: []string{"prometheus.io/scrape"},
+	}
+	if err := valid.Validate(path); err != nil {
+		t.Errorf("expected the policy to be valid, got %v", err)
+	}
+	for _, key := range []string{"", "example.com/team/owner", "-team"} {
+		invalid := &MetadataPropagation{Annotations: []string{key}}
+		if err := invalid.Validate(path); err == nil {
+			t.Errorf("expected %q to be rejected", key)
+		}
+	}
+}
@@ -16,6 +16,18 @@ limitations under the License.
 
 package v1
 
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
 // NodeGroupConfig defines the desired Webmesh configurations for a group of nodes.
 type NodeGroupConfig struct {
 	// LogLevel is the log level to use for the node containers in this
@@ -36,12 +48,45 @@ type NodeGroupConfig struct {
 	// Services is the configuration for services enabled for this group.
 	// +optional
 	Services *NodeServicesConfig `json:"services,omitempty"`
+
+	// DefaultGateway is the default gateway behavior for this group. When
+	// set to advertise, the nodes in this group will advertise a default
+	// route to the mesh and masquerade traffic egressing through them.
+	// The number of groups that may advertise a default route is limited
+	// by the Mesh.
+	// +kubebuilder:validation:Enum:=advertise;accept;ignore
+	// +optional
+	DefaultGateway DefaultGatewayMode `json:"defaultGateway,omitempty"`
+
+	// Certificate is the configuration for the certificates issued to the
+	// nodes in this group.
+	// +optional
+	Certificate *NodeCertificateConfig `json:"certificate,omitempty"`
+
+	// Security overrides the TLS verification configuration of the Mesh
+	// for this group.
+	// +optional
+	Security *SecurityConfig `json:"security,omitempty"`
 }
 
-// Merge merges the given NodeGroupConfig into this NodeGroupConfig. The
-// given NodeGroupConfig takes precedence. The merged NodeGroupConfig is
-// returned for convenience. If both are nil, a default NodeGroupConfig is
-// returned.
+// DefaultGatewayMode is the default gateway behavior for a group of nodes.
+type DefaultGatewayMode string
+
+const (
+	// DefaultGatewayAdvertise advertises a default route to the mesh.
+	DefaultGatewayAdvertise DefaultGatewayMode = "advertise"
+	// DefaultGatewayAccept accepts default routes advertised by other nodes.
+	// This is the behavior when no mode is set.
+	DefaultGatewayAccept DefaultGatewayMode = "accept"
+	// DefaultGatewayIgnore neither advertises nor relies on a default route.
+	// Nodes currently install all routes advertised by their peers, so this
+	// renders the same configuration as DefaultGatewayAccept.
+	DefaultGatewayIgnore DefaultGatewayMode = "ignore"
+)
+
+// Merge returns the given NodeGroupConfig merged over this NodeGroupConfig. The
+// given NodeGroupConfig takes precedence and neither is modified. If both are
+// nil, a default NodeGroupConfig is returned.
 func (c *NodeGroupConfig) Merge(in *NodeGroupConfig) *NodeGroupConfig {
 	if in == nil && c == nil {
 		var empty NodeGroupConfig
@@ -49,27 +94,94 @@ func (c *NodeGroupConfig) Merge(in *NodeGroupConfig) *NodeGroupConfig {
 		return &empty
 	}
 	if in == nil {
-		return c
+		return c.DeepCopy()
 	}
 	if c == nil {
-		return in
+		return in.DeepCopy()
 	}
+	out, in := c.DeepCopy(), in.DeepCopy()
 	if in.LogLevel != "" {
-		c.LogLevel = in.LogLevel
+		out.LogLevel = in.LogLevel
 	}
 	if in.NoIPv6 {
-		c.NoIPv6 = true
+		out.NoIPv6 = true
 	}
 	if in.Voter {
-		c.Voter = true
+		out.Voter = true
 	}
 	if in.Services != nil {
-		if c.Services == nil {
-			c.Services = &NodeServicesConfig{}
+		if out.Services == nil {
+			out.Services = &NodeServicesConfig{}
+		}
+		out.Services = out.Services.Merge(in.Services)
+	}
+	if in.DefaultGateway != "" {
+		out.DefaultGateway = in.DefaultGateway
+	}
+	if in.Certificate != nil {
+		out.Certificate = out.Certificate.Merge(in.Certificate)
+	}
+	if in.Security != nil {
+		out.Security = out.Security.Merge(in.Security)
+	}
+	return out
+}
+
+// Validate validates the NodeGroupConfig.
+func (c *NodeGroupConfig) Validate(path *field.Path) error {
+	if c == nil {
+		return nil
+	}
+	switch c.DefaultGateway {
+	case "", DefaultGatewayAdvertise, DefaultGatewayAccept, DefaultGatewayIgnore:
+	default:
+		return field.NotSupported(path.Child("defaultGateway"), c.DefaultGateway, []string{
+			string(DefaultGatewayAdvertise), string(DefaultGatewayAccept), string(DefaultGatewayIgnore),
+		})
+	}
+	if c.Certificate != nil {
+		if err := c.Certificate.Validate(path.Child("certificate")); err != nil {
+			return err
+		}
+	}
+	if c.Services != nil && c.Services.WebRTC != nil && c.Services.WebRTC.STUNServersSecret != nil {
+		ref := c.Services.WebRTC.STUNServersSecret
+		rpath := path.Child("services", "webRTC", "stunServersSecret")
+		if ref.Name == "" {
+			return field.Invalid(rpath.Child("name"), ref.Name, "secret name is required")
+		}
+		if ref.Key == "" {
+			return field.Invalid(rpath.Child("key"), ref.Key, "key is required")
 		}
-		c.Services = c.Services.Merge(in.Services)
 	}
-	return c
+	return nil
+}
+
+// SecretKeyRefs returns the secret keys the group reads options from.
+func (c *NodeGroupConfig) SecretKeyRefs() []*corev1.SecretKeySelector {
+	var refs []*corev1.SecretKeySelector
+	if c == nil || c.Services == nil {
+		return refs
+	}
+	if c.Services.WebRTC != nil && c.Services.WebRTC.STUNServersSecret != nil {
+		refs = append(refs, c.Services.WebRTC.STUNServersSecret)
+	}
+	return refs
+}
+
+// AdvertisesDefaultGateway returns true if the group advertises a default
+// route to the mesh.
+func (c *NodeGroupConfig) AdvertisesDefaultGateway() bool {
+	return c != nil && c.DefaultGateway == DefaultGatewayAdvertise
+}
+
+// MetricsTLS returns the TLS configuration for the group's metrics, or nil
+// if metrics are disabled or served in plaintext.
+func (c *NodeGroupConfig) MetricsTLS() *NodeMetricsTLSConfig {
+	if c == nil || c.Services == nil || c.Services.Metrics == nil {
+		return nil
+	}
+	return c.Services.Metrics.TLS
 }
 
 // Default sets default values for any unset fields.
@@ -118,10 +230,9 @@ type NodeServicesConfig struct {
 	EnableAdminAPI bool `json:"enableAdminAPI,omitempty"`
 }
 
-// Merge merges the given NodeServicesConfig into this NodeServicesConfig. The
-// given NodeServicesConfig takes precedence. The merged NodeServicesConfig is
-// returned for convenience. If both are nil, a default NodeServicesConfig is
-// returned.
+// Merge returns the given NodeServicesConfig merged over this
+// NodeServicesConfig. The given NodeServicesConfig takes precedence and neither
+// is modified. If both are nil, a default NodeServicesConfig is returned.
 func (c *NodeServicesConfig) Merge(in *NodeServicesConfig) *NodeServicesConfig {
 	if in == nil && c == nil {
 		var empty NodeServicesConfig
@@ -129,33 +240,34 @@ func (c *NodeServicesConfig) Merge(in *NodeServicesConfig) *NodeServicesConfig {
 		return &empty
 	}
 	if in == nil {
-		return c
+		return c.DeepCopy()
 	}
 	if c == nil {
-		return in
+		return in.DeepCopy()
 	}
+	out, in := c.DeepCopy(), in.DeepCopy()
 	if in.Metrics != nil {
-		c.Metrics = c.Metrics.Merge(in.Metrics)
+		out.Metrics = out.Metrics.Merge(in.Metrics)
 	}
 	if in.WebRTC != nil {
-		c.WebRTC = c.WebRTC.Merge(in.WebRTC)
+		out.WebRTC = out.WebRTC.Merge(in.WebRTC)
 	}
 	if in.MeshDNS != nil {
-		c.MeshDNS = c.MeshDNS.Merge(in.MeshDNS)
+		out.MeshDNS = out.MeshDNS.Merge(in.MeshDNS)
 	}
 	if in.EnableLeaderProxy {
-		c.EnableLeaderProxy = true
+		out.EnableLeaderProxy = true
 	}
 	if in.EnableMeshAPI {
-		c.EnableMeshAPI = true
+		out.EnableMeshAPI = true
 	}
 	if in.EnablePeerDiscoveryAPI {
-		c.EnablePeerDiscoveryAPI = true
+		out.EnablePeerDiscoveryAPI = true
 	}
 	if in.EnableAdminAPI {
-		c.EnableAdminAPI = true
+		out.EnableAdminAPI = true
 	}
-	return c
+	return out
 }
 
 // Default sets default values for any unset fields.
@@ -183,12 +295,49 @@ type NodeMetricsConfig struct {
 	// +kubebuilder:default:="/metrics"
 	// +optional
 	Path string `json:"path,omitempty"`
+
+	// TLS serves metrics over TLS. The node only serves metrics in plaintext,
+	// so for groups running in a cluster they are bound to the loopback interface
+	// and served on ListenAddress by a proxy sidecar. It is ignored for groups
+	// running outside of a cluster.
+	// +optional
+	TLS *NodeMetricsTLSConfig `json:"tls,omitempty"`
 }
 
-// Merge merges the given NodeMetricsConfig into this NodeMetricsConfig. The
-// given NodeMetricsConfig takes precedence. The merged NodeMetricsConfig is
-// returned for convenience. If both are nil, a default NodeMetricsConfig is
-// returned.
+// NodeMetricsTLSConfig defines how metrics are served over TLS.
+type NodeMetricsTLSConfig struct {
+	// CertSecret is the name of a kubernetes.io/tls secret in the group's
+	// namespace holding a dedicated certificate for serving metrics. If
+	// omitted, the certificate of each node is used.
+	// +optional
+	CertSecret string `json:"certSecret,omitempty"`
+
+	// ClientAuth is how clients scraping metrics are authenticated. Bearer
+	// tokens and client certificate identities are authorized with
+	// SubjectAccessReviews for GET on the metrics path, which requires the
+	// service account of the group's pods to be bound to system:auth-delegator.
+	// +kubebuilder:default:="None"
+	// +optional
+	ClientAuth MetricsClientAuth `json:"clientAuth,omitempty"`
+}
+
+// MetricsClientAuth is how clients scraping metrics are authenticated.
+// +kubebuilder:validation:Enum:=None;BearerToken;MTLS
+type MetricsClientAuth string
+
+const (
+	// MetricsClientAuthNone does not authenticate clients.
+	MetricsClientAuthNone MetricsClientAuth = "None"
+	// MetricsClientAuthBearerToken requires clients to present a bearer token.
+	MetricsClientAuthBearerToken MetricsClientAuth = "BearerToken"
+	// MetricsClientAuthMTLS requires clients to present a certificate signed by
+	// the mesh CA.
+	MetricsClientAuthMTLS MetricsClientAuth = "MTLS"
+)
+
+// Merge returns the given NodeMetricsConfig merged over this NodeMetricsConfig.
+// The given NodeMetricsConfig takes precedence and neither is modified. If both
+// are nil, a default NodeMetricsConfig is returned.
 func (c *NodeMetricsConfig) Merge(in *NodeMetricsConfig) *NodeMetricsConfig {
 	if in == nil && c == nil {
 		var empty NodeMetricsConfig
@@ -196,18 +345,22 @@ func (c *NodeMetricsConfig) Merge(in *NodeMetricsConfig) *NodeMetricsConfig {
 		return &empty
 	}
 	if in == nil {
-		return c
+		return c.DeepCopy()
 	}
 	if c == nil {
-		return in
+		return in.DeepCopy()
 	}
+	out, in := c.DeepCopy(), in.DeepCopy()
 	if in.ListenAddress != "" {
-		c.ListenAddress = in.ListenAddress
+		out.ListenAddress = in.ListenAddress
 	}
 	if in.Path != "" {
-		c.Path = in.Path
+		out.Path = in.Path
+	}
+	if in.TLS != nil {
+		out.TLS = in.TLS
 	}
-	return c
+	return out
 }
 
 // Default sets default values for any unset fields.
@@ -218,6 +371,9 @@ func (c *NodeMetricsConfig) Default() {
 	if c.Path == "" {
 		c.Path = "/metrics"
 	}
+	if c.TLS != nil && c.TLS.ClientAuth == "" {
+		c.TLS.ClientAuth = MetricsClientAuthNone
+	}
 }
 
 // NodeWebRTCConfig defines the desired WebRTC configurations for a group of nodes.
@@ -226,12 +382,19 @@ type NodeWebRTCConfig struct {
 	// +kubebuilder:default:={"stun:stun.l.google.com:19302"}
 	// +optional
 	STUNServers []string `json:"stunServers,omitempty"`
+
+	// STUNServersSecret selects a key of a secret in the namespace of the
+	// node group holding a comma-separated list of STUN/TURN servers. Use
+	// it for TURN servers that require credentials. It takes precedence
+	// over STUNServers and is passed to nodes through their environment,
+	// so it is never written to the node config.
+	// +optional
+	STUNServersSecret *corev1.SecretKeySelector `json:"stunServersSecret,omitempty"`
 }
 
-// Merge merges the given NodeWebRTCConfig into this NodeWebRTCConfig. The
-// given NodeWebRTCConfig takes precedence. The merged NodeWebRTCConfig is
-// returned for convenience. If both are nil, a default NodeWebRTCConfig is
-// returned.
+// Merge returns the given NodeWebRTCConfig merged over this NodeWebRTCConfig.
+// The given NodeWebRTCConfig takes precedence and neither is modified. If both
+// are nil, a default NodeWebRTCConfig is returned.
 func (c *NodeWebRTCConfig) Merge(in *NodeWebRTCConfig) *NodeWebRTCConfig {
 	if in == nil && c == nil {
 		var empty NodeWebRTCConfig
@@ -239,15 +402,19 @@ func (c *NodeWebRTCConfig) Merge(in *NodeWebRTCConfig) *NodeWebRTCConfig {
 		return &empty
 	}
 	if in == nil {
-		return c
+		return c.DeepCopy()
 	}
 	if c == nil {
-		return in
+		return in.DeepCopy()
 	}
+	out, in := c.DeepCopy(), in.DeepCopy()
 	if len(in.STUNServers) > 0 {
-		c.STUNServers = in.STUNServers
+		out.STUNServers = in.STUNServers
+	}
+	if in.STUNServersSecret != nil {
+		out.STUNServersSecret = in.STUNServersSecret
 	}
-	return c
+	return out
 }
 
 // Default sets default values for any unset fields.
@@ -270,10 +437,9 @@ type NodeMeshDNSConfig struct {
 	ListenTCP string `json:"listenTCP,omitempty"`
 }
 
-// Merge merges the given NodeMeshDNSConfig into this NodeMeshDNSConfig. The
-// given NodeMeshDNSConfig takes precedence. The merged NodeMeshDNSConfig is
-// returned for convenience. If both are nil, a default NodeMeshDNSConfig is
-// returned.
+// Merge returns the given NodeMeshDNSConfig merged over this NodeMeshDNSConfig.
+// The given NodeMeshDNSConfig takes precedence and neither is modified. If both
+// are nil, a default NodeMeshDNSConfig is returned.
 func (c *NodeMeshDNSConfig) Merge(in *NodeMeshDNSConfig) *NodeMeshDNSConfig {
 	if in == nil && c == nil {
 		var empty NodeMeshDNSConfig
@@ -281,18 +447,19 @@ func (c *NodeMeshDNSConfig) Merge(in *NodeMeshDNSConfig) *NodeMeshDNSConfig {
 		return &empty
 	}
 	if in == nil {
-		return c
+		return c.DeepCopy()
 	}
 	if c == nil {
-		return in
+		return in.DeepCopy()
 	}
+	out, in := c.DeepCopy(), in.DeepCopy()
 	if in.ListenUDP != "" {
-		c.ListenUDP = in.ListenUDP
+		out.ListenUDP = in.ListenUDP
 	}
 	if in.ListenTCP != "" {
-		c.ListenTCP = in.ListenTCP
+		out.ListenTCP = in.ListenTCP
 	}
-	return c
+	return out
 }
 
 // Default sets default values for any unset fields.
@@ -304,3 +471,109 @@ func (c *NodeMeshDNSConfig) Default() {
 		c.ListenTCP = ":5353"
 	}
 }
+
+// NodeCertificateConfig defines additional subject alternative names for the
+// certificates issued to a group of nodes. Each entry is a template that is
+// rendered for every replica, with {{ .Ordinal }} set to the replica's index.
+type NodeCertificateConfig struct {
+	// ExtraDNSNames are additional DNS names to include in each node's
+	// certificate.
+	// +optional
+	ExtraDNSNames []string `json:"extraDNSNames,omitempty"`
+
+	// ExtraIPAddresses are additional IP addresses to include in each node's
+	// certificate.
+	// +optional
+	ExtraIPAddresses []string `json:"extraIPAddresses,omitempty"`
+}
+
+// Merge returns the given NodeCertificateConfig merged over this
+// NodeCertificateConfig. The given NodeCertificateConfig takes precedence and
+// neither is modified.
+func (c *NodeCertificateConfig) Merge(in *NodeCertificateConfig) *NodeCertificateConfig {
+	if in == nil {
+		return c.DeepCopy()
+	}
+	if c == nil {
+		return in.DeepCopy()
+	}
+	out, in := c.DeepCopy(), in.DeepCopy()
+	if len(in.ExtraDNSNames) > 0 {
+		out.ExtraDNSNames = in.ExtraDNSNames
+	}
+	if len(in.ExtraIPAddresses) > 0 {
+		out.ExtraIPAddresses = in.ExtraIPAddresses
+	}
+	return out
+}
+
+// DNSNames returns the extra DNS names for the replica with the given ordinal.
+func (c *NodeCertificateConfig) DNSNames(ordinal int) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return renderSANs(c.ExtraDNSNames, ordinal)
+}
+
+// IPAddresses returns the extra IP addresses for the replica with the given ordinal.
+func (c *NodeCertificateConfig) IPAddresses(ordinal int) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return renderSANs(c.ExtraIPAddresses, ordinal)
+}
+
+// Validate validates the NodeCertificateConfig. Templates are rendered for the
+// first replica and the results are checked.
+func (c *NodeCertificateConfig) Validate(path *field.Path) error {
+	for i, name := range c.ExtraDNSNames {
+		rendered, err := renderSAN(name, 0)
+		if err != nil {
+			return field.Invalid(path.Child("extraDNSNames").Index(i), name, err.Error())
+		}
+		errs := validation.IsDNS1123Subdomain(rendered)
+		if strings.HasPrefix(rendered, "*.") {
+			errs = validation.IsWildcardDNS1123Subdomain(rendered)
+		}
+		if len(errs) > 0 {
+			return field.Invalid(path.Child("extraDNSNames").Index(i), name, strings.Join(errs, ", "))
+		}
+	}
+	for i, addr := range c.ExtraIPAddresses {
+		rendered, err := renderSAN(addr, 0)
+		if err != nil {
+			return field.Invalid(path.Child("extraIPAddresses").Index(i), addr, err.Error())
+		}
+		if net.ParseIP(rendered) == nil {
+			return field.Invalid(path.Child("extraIPAddresses").Index(i), addr, "must be a valid IP address")
+		}
+	}
+	return nil
+}
+
+func renderSANs(templates []string, ordinal int) ([]string, error) {
+	out := make([]string, 0, len(templates))
+	for _, tmpl := range templates {
+		rendered, err := renderSAN(tmpl, ordinal)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rendered)
+	}
+	return out, nil
+}
+
+func renderSAN(tmpl string, ordinal int) (string, error) {
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl, nil
+	}
+	t, err := template.New("san").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, struct{ Ordinal int }{ordinal}); err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergedConfigSharedConfigGroup(t *testing.T) {
+	mesh := &Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: MeshSpec{
+			ConfigGroups: map[string]NodeGroupConfig{
+				"shared": {
+					LogLevel: "info",
+					Services: &NodeServicesConfig{
+						Metrics: &NodeMetricsConfig{ListenAddress: ":8080", Path: "/metrics"},
+					},
+					Certificate: &NodeCertificateConfig{ExtraDNSNames: []string{"shared.example.com"}},
+				},
+			},
+		},
+	}
+	shared := mesh.Spec.ConfigGroups["shared"]
+	configGroup := shared.DeepCopy()
+	first := &NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default"},
+		Spec: NodeGroupSpec{
+			ConfigGroup: "shared",
+			Config: &NodeGroupConfig{
+				LogLevel: "debug",
+				Services: &NodeServicesConfig{
+					Metrics:        &NodeMetricsConfig{ListenAddress: ":9090"},
+					WebRTC:         &NodeWebRTCConfig{STUNServers: []string{"stun:example.com:3478"}},
+					EnableAdminAPI: true,
+				},
+				Certificate: &NodeCertificateConfig{ExtraIPAddresses: []string{"10.0.0.1"}},
+			},
+		},
+	}
+	second := &NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "default"},
+		Spec:       NodeGroupSpec{ConfigGroup: "shared"},
+	}
+	firstSpec := first.Spec.Config.DeepCopy()
+
+	firstcfg, err := first.MergedConfig(mesh)
+	if err != nil {
+		t.Fatalf("merge first group: %v", err)
+	}
+	if firstcfg.LogLevel != "debug" || firstcfg.Services.Metrics.ListenAddress != ":9090" ||
+		firstcfg.Services.Metrics.Path != "/metrics" || !firstcfg.Services.EnableAdminAPI {
+		t.Errorf("unexpected config for first group: %+v", firstcfg)
+	}
+	// Changes to a merged config must not leak into its sources
+	firstcfg.Services.Metrics.Path = "/changed"
+	firstcfg.Services.WebRTC.STUNServers[0] = "stun:changed.example.com:3478"
+	firstcfg.Certificate.ExtraDNSNames[0] = "changed.example.com"
+
+	secondcfg, err := second.MergedConfig(mesh)
+	if err != nil {
+		t.Fatalf("merge second group: %v", err)
+	}
+	if !reflect.DeepEqual(secondcfg, configGroup) {
+		t.Errorf("expected second group to match the config group, got %+v", secondcfg)
+	}
+	if got := mesh.Spec.ConfigGroups["shared"]; !reflect.DeepEqual(&got, configGroup) {
+		t.Errorf("config group was modified: %+v", got)
+	}
+	if !reflect.DeepEqual(first.Spec.Config, firstSpec) {
+		t.Errorf("group config was modified: %+v", first.Spec.Config)
+	}
+}
+
+func TestMergeDoesNotModifyArguments(t *testing.T) {
+	base := &NodeGroupConfig{
+		Services: &NodeServicesConfig{EnableMeshAPI: true},
+		Security: &SecurityConfig{},
+	}
+	override := &NodeGroupConfig{
+		Voter:    true,
+		Services: &NodeServicesConfig{MeshDNS: &NodeMeshDNSConfig{ListenUDP: ":53"}},
+		Security: &SecurityConfig{RequireClientCert: new(bool)},
+	}
+	baseCopy, overrideCopy := base.DeepCopy(), override.DeepCopy()
+
+	merged := base.Merge(override)
+	if !merged.Voter || !merged.Services.EnableMeshAPI || merged.Services.MeshDNS.ListenUDP != ":53" ||
+		merged.Security.RequireClientCert == nil {
+		t.Errorf("unexpected merged config: %+v", merged)
+	}
+	merged.Services.MeshDNS.ListenUDP = ":5353"
+	*merged.Security.RequireClientCert = true
+	if !reflect.DeepEqual(base, baseCopy) {
+		t.Errorf("receiver was modified: %+v", base)
+	}
+	if !reflect.DeepEqual(override, overrideCopy) {
+		t.Errorf("argument was modified: %+v", override)
+	}
+	if base.Merge(nil) == base || (*NodeGroupConfig)(nil).Merge(override) == override {
+		t.Errorf("expected merge with nil to return a copy")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// nodePort is a range of ports used by the nodes of a group.
+type nodePort struct {
+	// name is the field the port is set with, or a description of the port
+	// for fixed ports.
+	name string
+	// port is the first port of the range.
+	port int32
+	// count is the number of ports in the range.
+	count int32
+	// protocol is the protocol the port is used with.
+	protocol corev1.Protocol
+	// service groups the ports of a single service. A service may listen
+	// on the same port with different protocols.
+	service string
+}
+
+// last returns the last port of the range.
+func (p nodePort) last() int32 {
+	return p.port + p.count - 1
+}
+
+// overlaps returns true if the port ranges overlap.
+func (p nodePort) overlaps(other nodePort) bool {
+	if p.service != "" && p.service == other.service && p.protocol != other.protocol {
+		return false
+	}
+	return p.port <= other.last() && other.port <= p.last()
+}
+
+// String returns a description of the port range.
+func (p nodePort) String() string {
+	if p.count == 1 {
+		return fmt.Sprintf("%s (%d)", p.name, p.port)
+	}
+	return fmt.Sprintf("%s (%d-%d)", p.name, p.port, p.last())
+}
+
+// wireGuardPorts returns the WireGuard ports of the group. A group exposed
+// through a service reserves one port per replica starting at its WireGuard
+// port.
+func (n *NodeGroupSpec) wireGuardPorts(path *field.Path) nodePort {
+	if n.Cluster == nil || n.Cluster.Service == nil {
+		return nodePort{name: "WireGuard port", port: DefaultWireGuardPort, count: 1, protocol: corev1.ProtocolUDP}
+	}
+	port := n.Cluster.Service.WireGuardPort
+	if port == 0 {
+		port = DefaultWireGuardPort
+	}
+	count := int32(1)
+	if n.Replicas != nil && *n.Replicas > 1 {
+		count = *n.Replicas
+	}
+	return nodePort{
+		name:     path.Child("cluster", "service", "wireGuardPort").String(),
+		port:     port,
+		count:    count,
+		protocol: corev1.ProtocolUDP,
+	}
+}
+
+// ports returns the ports used by the nodes of the group other than
+// WireGuard. The config is the group's config merged with its config group,
+// it may be nil.
+func (n *NodeGroupSpec) ports(path *field.Path, cfg *NodeGroupConfig) []nodePort {
+	grpc := nodePort{name: "gRPC port", port: DefaultGRPCPort, count: 1, protocol: corev1.ProtocolTCP}
+	if n.Cluster != nil && n.Cluster.Service != nil && n.Cluster.Service.GRPCPort != 0 {
+		grpc.name = path.Child("cluster", "service", "grpcPort").String()
+		grpc.port = n.Cluster.Service.GRPCPort
+	}
+	ports := []nodePort{
+		grpc,
+		{name: "Raft port", port: DefaultRaftPort, count: 1, protocol: corev1.ProtocolTCP},
+	}
+	configPath := path.Child("config", "services")
+	if cfg != nil && cfg.Services != nil && cfg.Services.Metrics != nil {
+		if port, ok := listenPort(cfg.Services.Metrics.ListenAddress); ok {
+			ports = append(ports, nodePort{
+				name:     configPath.Child("metrics", "listenAddress").String(),
+				port:     port,
+				count:    1,
+				protocol: corev1.ProtocolTCP,
+			})
+		}
+	}
+	var meshDNS *NodeMeshDNSConfig
+	if cfg != nil && cfg.Services != nil {
+		meshDNS = cfg.Services.MeshDNS
+	}
+	if meshDNS != nil || n.Profile == NodeGroupProfileDNS {
+		udp, tcp := nodePort{
+			name:     configPath.Child("meshDNS", "listenUDP").String(),
+			port:     DefaultMeshDNSPort,
+			count:    1,
+			protocol: corev1.ProtocolUDP,
+			service:  "meshDNS",
+		}, nodePort{
+			name:     configPath.Child("meshDNS", "listenTCP").String(),
+			port:     DefaultMeshDNSPort,
+			count:    1,
+			protocol: corev1.ProtocolTCP,
+			service:  "meshDNS",
+		}
+		if meshDNS != nil {
+			if port, ok := listenPort(meshDNS.ListenUDP); ok {
+				udp.port = port
+			}
+			if port, ok := listenPort(meshDNS.ListenTCP); ok {
+				tcp.port = port
+			}
+		}
+		ports = append(ports, udp, tcp)
+	}
+	return ports
+}
+
+// validatePorts rejects ports of the group that overlap.
+func (n *NodeGroupSpec) validatePorts(path *field.Path, cfg *NodeGroupConfig) error {
+	wireguard := n.wireGuardPorts(path)
+	if wireguard.last() > 65535 {
+		return field.Invalid(path.Child("cluster", "service", "wireGuardPort"), wireguard.port,
+			fmt.Sprintf("the WireGuard ports of %d replicas end past 65535", wireguard.count))
+	}
+	ports := append([]nodePort{wireguard}, n.ports(path, cfg)...)
+	for i, port := range ports {
+		for _, other := range ports[i+1:] {
+			if port.overlaps(other) {
+				return field.Invalid(path, port.port,
+					fmt.Sprintf("%s overlaps with %s", port, other))
+			}
+		}
+	}
+	return nil
+}
+
+// defaultWireGuardPort sets the WireGuard port of a group exposed through a
+// service that does not set one. The default port is moved past the other
+// ports of the group when the ports of its replicas would overlap them.
+func (n *NodeGroupSpec) defaultWireGuardPort() {
+	if n.Cluster == nil || n.Cluster.Service == nil || n.Cluster.Service.WireGuardPort != 0 {
+		return
+	}
+	others := n.ports(field.NewPath("spec"), n.Config)
+	wireguard := n.wireGuardPorts(field.NewPath("spec"))
+	for moved := true; moved; {
+		moved = false
+		for _, other := range others {
+			if wireguard.overlaps(other) {
+				wireguard.port = other.last() + 1
+				moved = true
+			}
+		}
+	}
+	if wireguard.last() > 65535 {
+		// Leave it to validation to reject
+		wireguard.port = DefaultWireGuardPort
+	}
+	n.Cluster.Service.WireGuardPort = wireguard.port
+}
+
+// listenPort returns the port of a listen address.
+func listenPort(addr string) (int32, bool) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, false
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return 0, false
+	}
+	return int32(p), true
+}
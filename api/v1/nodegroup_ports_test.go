@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidatePorts(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	service := func(grpc, wireguard int32) *NodeGroupClusterConfig {
+		return &NodeGroupClusterConfig{Service: &NodeGroupLBConfig{GRPCPort: grpc, WireGuardPort: wireguard}}
+	}
+	tc := []struct {
+		name string
+		spec NodeGroupSpec
+		cfg  *NodeGroupConfig
+		err  string
+	}{
+		{
+			name: "defaults",
+			spec: NodeGroupSpec{Cluster: service(8443, 51820)},
+		},
+		{
+			name: "wireguard on the grpc port",
+			spec: NodeGroupSpec{Cluster: service(8443, 8443)},
+			err:  "spec.cluster.service.wireGuardPort (8443) overlaps with spec.cluster.service.grpcPort (8443)",
+		},
+		{
+			name: "wireguard on the raft port",
+			spec: NodeGroupSpec{Cluster: service(8443, 9443)},
+			err:  "overlaps with Raft port (9443)",
+		},
+		{
+			name: "grpc on the raft port",
+			spec: NodeGroupSpec{Cluster: service(9443, 51820)},
+			err:  "spec.cluster.service.grpcPort (9443) overlaps with Raft port (9443)",
+		},
+		{
+			name: "wireguard range over the grpc port",
+			spec: NodeGroupSpec{Replicas: replicas(3), Cluster: service(8443, 8441)},
+			err:  "spec.cluster.service.wireGuardPort (8441-8443) overlaps",
+		},
+		{
+			name: "wireguard range past the last port",
+			spec: NodeGroupSpec{Replicas: replicas(3), Cluster: service(8443, 65534)},
+			err:  "end past 65535",
+		},
+		{
+			name: "metrics on the grpc port",
+			spec: NodeGroupSpec{Cluster: &NodeGroupClusterConfig{}},
+			cfg:  &NodeGroupConfig{Services: &NodeServicesConfig{Metrics: &NodeMetricsConfig{ListenAddress: ":8443"}}},
+			err:  "gRPC port (8443) overlaps with spec.config.services.metrics.listenAddress (8443)",
+		},
+		{
+			name: "meshdns on one port for both protocols",
+			spec: NodeGroupSpec{Cluster: &NodeGroupClusterConfig{}},
+			cfg:  &NodeGroupConfig{Services: &NodeServicesConfig{MeshDNS: &NodeMeshDNSConfig{ListenUDP: ":53", ListenTCP: ":53"}}},
+		},
+		{
+			name: "meshdns on the metrics port",
+			spec: NodeGroupSpec{Cluster: &NodeGroupClusterConfig{}},
+			cfg: &NodeGroupConfig{Services: &NodeServicesConfig{
+				Metrics: &NodeMetricsConfig{ListenAddress: ":8080"},
+				MeshDNS: &NodeMeshDNSConfig{ListenUDP: ":5353", ListenTCP: ":8080"},
+			}},
+			err: "spec.config.services.metrics.listenAddress (8080) overlaps with spec.config.services.meshDNS.listenTCP (8080)",
+		},
+		{
+			name: "dns profile on the wireguard port",
+			spec: NodeGroupSpec{Profile: NodeGroupProfileDNS, Cluster: &NodeGroupClusterConfig{}},
+			cfg:  &NodeGroupConfig{Services: &NodeServicesConfig{MeshDNS: &NodeMeshDNSConfig{ListenUDP: ":51820"}}},
+			err:  "WireGuard port (51820) overlaps with spec.config.services.meshDNS.listenUDP (51820)",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.validatePorts(field.NewPath("spec"), tt.cfg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error to contain %q, got %q", tt.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestDefaultWireGuardPort(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	tc := []struct {
+		name string
+		spec NodeGroupSpec
+		want int32
+	}{
+		{
+			name: "default",
+			spec: NodeGroupSpec{Cluster: &NodeGroupClusterConfig{Service: &NodeGroupLBConfig{}}},
+			want: DefaultWireGuardPort,
+		},
+		{
+			name: "explicit port is kept",
+			spec: NodeGroupSpec{Cluster: &NodeGroupClusterConfig{Service: &NodeGroupLBConfig{WireGuardPort: 51000}}},
+			want: 51000,
+		},
+		{
+			name: "moved past the grpc port",
+			spec: NodeGroupSpec{
+				Replicas: replicas(4),
+				Cluster:  &NodeGroupClusterConfig{Service: &NodeGroupLBConfig{GRPCPort: 51822}},
+			},
+			want: 51823,
+		},
+		{
+			name: "moved past the metrics port",
+			spec: NodeGroupSpec{
+				Cluster: &NodeGroupClusterConfig{Service: &NodeGroupLBConfig{}},
+				Config:  &NodeGroupConfig{Services: &NodeServicesConfig{Metrics: &NodeMetricsConfig{ListenAddress: ":51820"}}},
+			},
+			want: 51821,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Default()
+			if got := tt.spec.Cluster.Service.WireGuardPort; got != tt.want {
+				t.Errorf("expected WireGuard port %d, got %d", tt.want, got)
+			}
+			if err := tt.spec.validatePorts(field.NewPath("spec"), tt.spec.Config); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
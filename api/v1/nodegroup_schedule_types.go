@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// NodeGroupSchedule is a daily window during which the instances in a node
+// group are running. Outside of the window the instances are stopped. A window
+// whose stop time is before its start time runs past midnight.
+type NodeGroupSchedule struct {
+	// Start is the time of day, in 24-hour HH:MM format, when instances are started.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Stop is the time of day, in 24-hour HH:MM format, when instances are stopped.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Stop string `json:"stop"`
+
+	// Days are the days of the week on which the window starts. If empty
+	// the window starts every day.
+	// +optional
+	Days []ScheduleDay `json:"days,omitempty"`
+
+	// TimeZone is the IANA name of the time zone the window is in.
+	// +kubebuilder:default:="UTC"
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ScheduleDay is a day of the week.
+// +kubebuilder:validation:Enum=Mon;Tue;Wed;Thu;Fri;Sat;Sun
+type ScheduleDay string
+
+var scheduleDays = map[ScheduleDay]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// ScheduleState is the state of a node group with a schedule.
+type ScheduleState string
+
+const (
+	// ScheduleStateRunning means the instances in the group are running.
+	ScheduleStateRunning ScheduleState = "Running"
+	// ScheduleStateSuspended means the instances in the group are stopped.
+	ScheduleStateSuspended ScheduleState = "Suspended"
+)
+
+// NodeGroupScheduleStatus is the observed state of a node group schedule.
+type NodeGroupScheduleStatus struct {
+	// State is the current state of the group.
+	State ScheduleState `json:"state,omitempty"`
+
+	// NextTransition is when the group will next change state. It is
+	// unset while the schedule is overridden.
+	// +optional
+	NextTransition *metav1.Time `json:"nextTransition,omitempty"`
+
+	// Overridden is true if the state is set by the schedule override
+	// annotation rather than the schedule.
+	// +optional
+	Overridden bool `json:"overridden,omitempty"`
+}
+
+func (s *NodeGroupSchedule) Validate(path *field.Path) error {
+	if _, err := time.Parse("15:04", s.Start); err != nil {
+		return field.Invalid(path.Child("start"), s.Start, "must be in HH:MM format")
+	}
+	if _, err := time.Parse("15:04", s.Stop); err != nil {
+		return field.Invalid(path.Child("stop"), s.Stop, "must be in HH:MM format")
+	}
+	if s.Start == s.Stop {
+		return field.Invalid(path.Child("stop"), s.Stop, "must differ from start")
+	}
+	for i, day := range s.Days {
+		if _, ok := scheduleDays[day]; !ok {
+			return field.Invalid(path.Child("days").Index(i), day, "unknown day of the week")
+		}
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return field.Invalid(path.Child("timeZone"), s.TimeZone, err.Error())
+	}
+	return nil
+}
+
+// Evaluate returns whether the window is active at the given time and
+// when it next starts or stops.
+func (s *NodeGroupSchedule) Evaluate(now time.Time) (active bool, next time.Time, err error) {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("load time zone: %w", err)
+	}
+	start, err := time.Parse("15:04", s.Start)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("parse start: %w", err)
+	}
+	stop, err := time.Parse("15:04", s.Stop)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("parse stop: %w", err)
+	}
+	now = now.In(loc)
+	// Check the window starting yesterday, which may still be active,
+	// through to the first window starting after a full week.
+	for offset := -1; offset <= 8; offset++ {
+		day := time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, loc)
+		if !s.runsOn(day.Weekday()) {
+			continue
+		}
+		windowStart := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		windowStop := time.Date(day.Year(), day.Month(), day.Day(), stop.Hour(), stop.Minute(), 0, 0, loc)
+		if !windowStop.After(windowStart) {
+			windowStop = windowStop.AddDate(0, 0, 1)
+		}
+		if !now.Before(windowStart) && now.Before(windowStop) {
+			return true, windowStop, nil
+		}
+		if windowStart.After(now) {
+			return false, windowStart, nil
+		}
+	}
+	return false, time.Time{}, fmt.Errorf("no window found in the next week")
+}
+
+func (s *NodeGroupSchedule) runsOn(weekday time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, day := range s.Days {
+		if scheduleDays[day] == weekday {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+	"time"
+
+	// The time zones of the tests are embedded in case the system has none
+	_ "time/tzdata"
+)
+
+func TestNodeGroupScheduleEvaluate(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		out, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	tc := []struct {
+		name     string
+		schedule NodeGroupSchedule
+		now      string
+		active   bool
+		next     string
+		err      bool
+	}{
+		{
+			name:     "before a daily window",
+			schedule: NodeGroupSchedule{Start: "08:00", Stop: "18:00", TimeZone: "UTC"},
+			now:      "2023-06-14T07:59:00Z",
+			next:     "2023-06-14T08:00:00Z",
+		},
+		{
+			name:     "at the start of a daily window",
+			schedule: NodeGroupSchedule{Start: "08:00", Stop: "18:00", TimeZone: "UTC"},
+			now:      "2023-06-14T08:00:00Z",
+			active:   true,
+			next:     "2023-06-14T18:00:00Z",
+		},
+		{
+			name:     "at the stop of a daily window",
+			schedule: NodeGroupSchedule{Start: "08:00", Stop: "18:00", TimeZone: "UTC"},
+			now:      "2023-06-14T18:00:00Z",
+			next:     "2023-06-15T08:00:00Z",
+		},
+		{
+			name:     "window past midnight started yesterday",
+			schedule: NodeGroupSchedule{Start: "22:00", Stop: "06:00", TimeZone: "UTC"},
+			now:      "2023-06-14T03:00:00Z",
+			active:   true,
+			next:     "2023-06-14T06:00:00Z",
+		},
+		{
+			name:     "window past midnight only starts on its days",
+			schedule: NodeGroupSchedule{Start: "22:00", Stop: "06:00", Days: []ScheduleDay{"Fri"}, TimeZone: "UTC"},
+			// Saturday morning is in the window of Friday
+			now:    "2023-06-17T05:00:00Z",
+			active: true,
+			next:   "2023-06-17T06:00:00Z",
+		},
+		{
+			name:     "weekdays skip the weekend",
+			schedule: NodeGroupSchedule{Start: "08:00", Stop: "18:00", Days: []ScheduleDay{"Mon", "Tue", "Wed", "Thu", "Fri"}, TimeZone: "UTC"},
+			// Friday evening
+			now:  "2023-06-16T19:00:00Z",
+			next: "2023-06-19T08:00:00Z",
+		},
+		{
+			name:     "window in a time zone",
+			schedule: NodeGroupSchedule{Start: "09:00", Stop: "17:00", TimeZone: "Europe/Berlin"},
+			now:      "2023-06-14T06:30:00Z",
+			next:     "2023-06-14T07:00:00Z",
+		},
+		{
+			name:     "spring forward shortens the window",
+			schedule: NodeGroupSchedule{Start: "01:00", Stop: "09:00", TimeZone: "America/New_York"},
+			// 23:00 EST the night before the change
+			now:  "2023-03-12T04:00:00Z",
+			next: "2023-03-12T06:00:00Z",
+		},
+		{
+			name:     "spring forward stops in daylight time",
+			schedule: NodeGroupSchedule{Start: "01:00", Stop: "09:00", TimeZone: "America/New_York"},
+			// 01:30 EST, the window stops at 09:00 EDT
+			now:    "2023-03-12T06:30:00Z",
+			active: true,
+			next:   "2023-03-12T13:00:00Z",
+		},
+		{
+			name:     "fall back lengthens a window past midnight",
+			schedule: NodeGroupSchedule{Start: "22:00", Stop: "06:00", TimeZone: "America/New_York"},
+			// 05:00 EST, the window started at 22:00 EDT
+			now:    "2023-11-05T10:00:00Z",
+			active: true,
+			next:   "2023-11-05T11:00:00Z",
+		},
+		{
+			name:     "fall back starts the next window in standard time",
+			schedule: NodeGroupSchedule{Start: "22:00", Stop: "06:00", TimeZone: "America/New_York"},
+			now:      "2023-11-05T11:00:00Z",
+			next:     "2023-11-06T03:00:00Z",
+		},
+		{
+			name:     "unknown time zone",
+			schedule: NodeGroupSchedule{Start: "08:00", Stop: "18:00", TimeZone: "Mars/Olympus_Mons"},
+			now:      "2023-06-14T08:00:00Z",
+			err:      true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			active, next, err := tt.schedule.Evaluate(at(tt.now))
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active != tt.active {
+				t.Errorf("got active %v, want %v", active, tt.active)
+			}
+			if want := at(tt.next); !next.Equal(want) {
+				t.Errorf("got next transition %s, want %s", next.UTC().Format(time.RFC3339), tt.next)
+			}
+		})
+	}
+}
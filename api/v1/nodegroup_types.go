@@ -17,15 +17,31 @@ limitations under the License.
 package v1
 
 import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 // NodeGroupSpec is the specification for a group of nodes.
 type NodeGroupSpec struct {
-	// Image is the image to use for the node.
-	// +kubebuilder:default:="ghcr.io/webmeshproj/node:latest"
+	// Image is the image to use for the node. Defaults to the operator's
+	// default node image. Images may be referenced by tag, digest, or both.
 	// +optional
 	Image string `json:"image,omitempty"`
 
@@ -34,6 +50,15 @@ type NodeGroupSpec struct {
 	// +optional
 	Replicas *int32 `json:"replicas,omitempty"`
 
+	// Profile is the role the nodes in this group play in the mesh. Full
+	// nodes take part in routing and may run any service. DNS nodes only
+	// serve MeshDNS as observers and are not reachable over WireGuard. Relay
+	// nodes route traffic as observers.
+	// +kubebuilder:default:="full"
+	// +kubebuilder:validation:Enum:=full;dns;relay
+	// +optional
+	Profile NodeGroupProfile `json:"profile,omitempty"`
+
 	// Mesh is a reference to the Mesh this group belongs to.
 	// +optional
 	Mesh corev1.ObjectReference `json:"mesh,omitempty"`
@@ -58,9 +83,53 @@ type NodeGroupSpec struct {
 	// Google Cloud.
 	// +optional
 	GoogleCloud *NodeGroupGoogleCloudConfig `json:"googleCloud,omitempty"`
+
+	// AWS is the configuration for a group of nodes running on AWS EC2
+	// instances.
+	// +optional
+	AWS *NodeGroupAWSConfig `json:"aws,omitempty"`
+
+	// Azure is the configuration for a group of nodes running on Azure
+	// virtual machines.
+	// +optional
+	Azure *NodeGroupAzureConfig `json:"azure,omitempty"`
+
+	// DigitalOcean is the configuration for a group of nodes running on
+	// DigitalOcean droplets.
+	// +optional
+	DigitalOcean *NodeGroupDigitalOceanConfig `json:"digitalOcean,omitempty"`
+
+	// Hetzner is the configuration for a group of nodes running on Hetzner
+	// Cloud servers.
+	// +optional
+	Hetzner *NodeGroupHetznerConfig `json:"hetzner,omitempty"`
+
+	// BareMetal is the configuration for a group of nodes running on
+	// existing machines that are provisioned over SSH. One node runs on each
+	// host and Replicas is ignored.
+	// +optional
+	BareMetal *NodeGroupBareMetalConfig `json:"bareMetal,omitempty"`
+
+	// ExtraVoters are the IDs of additional nodes that should be authorized
+	// as voters when the mesh is bootstrapped. This is only valid for the
+	// bootstrap configuration of a Mesh.
+	// +optional
+	ExtraVoters []string `json:"extraVoters,omitempty"`
+}
+
+// DefaultImage sets the node image of the group if it is unset. It is called
+// before Default to use an image other than the built-in default.
+func (n *NodeGroupSpec) DefaultImage(image string) {
+	if n.Image == "" {
+		n.Image = image
+	}
 }
 
 func (n *NodeGroupSpec) Default() {
+	n.DefaultImage(DefaultNodeImage)
+	if n.Profile == "" {
+		n.Profile = NodeGroupProfileFull
+	}
 	if n.Replicas == nil {
 		n.Replicas = new(int32)
 		*n.Replicas = 1
@@ -72,20 +141,107 @@ func (n *NodeGroupSpec) Default() {
 		n.Config.Default()
 	}
 
-	if n.Cluster == nil {
-		if n.GoogleCloud == nil {
-			n.Cluster = &NodeGroupClusterConfig{}
-			n.Cluster.Default()
-		}
+	if n.Cluster == nil && n.GoogleCloud == nil && n.AWS == nil && n.Azure == nil && n.DigitalOcean == nil && n.Hetzner == nil && n.BareMetal == nil {
+		n.Cluster = &NodeGroupClusterConfig{}
+	}
+	if n.Cluster != nil {
+		n.defaultWireGuardPort()
+		n.Cluster.Default()
+	}
+	if n.GoogleCloud != nil {
+		n.GoogleCloud.Default()
+	}
+	if n.AWS != nil {
+		n.AWS.Default()
+	}
+	if n.Azure != nil {
+		n.Azure.Default()
+	}
+	if n.DigitalOcean != nil {
+		n.DigitalOcean.Default()
+	}
+	if n.Hetzner != nil {
+		n.Hetzner.Default()
+	}
+	if n.BareMetal != nil {
+		n.BareMetal.Default()
+	}
+}
+
+// Providers returns the names of the populated deployment fields of the
+// spec. A valid spec has at most one.
+func (n *NodeGroupSpec) Providers() []string {
+	var providers []string
+	if n.Cluster != nil {
+		providers = append(providers, "cluster")
+	}
+	if n.GoogleCloud != nil {
+		providers = append(providers, "googleCloud")
+	}
+	if n.AWS != nil {
+		providers = append(providers, "aws")
+	}
+	if n.Azure != nil {
+		providers = append(providers, "azure")
+	}
+	if n.DigitalOcean != nil {
+		providers = append(providers, "digitalOcean")
+	}
+	if n.Hetzner != nil {
+		providers = append(providers, "hetzner")
+	}
+	if n.BareMetal != nil {
+		providers = append(providers, "bareMetal")
 	}
+	return providers
 }
 
 // Validate validates the NodeGroupSpec.
 func (n *NodeGroupSpec) Validate() error {
-	if n.Cluster != nil {
-		if n.Cluster.Service != nil && *n.Replicas > 1 {
+	if err := n.validateProfile(); err != nil {
+		return err
+	}
+	if providers := n.Providers(); len(providers) > 1 {
+		return field.Invalid(field.NewPath("spec"), strings.Join(providers, ", "),
+			"only one of cluster, googleCloud, aws, azure, digitalOcean, hetzner and bareMetal may be set")
+	}
+	if n.Image != "" {
+		if err := ValidateImage(field.NewPath("spec", "image"), n.Image); err != nil {
+			return err
+		}
+	}
+	if err := n.Config.Validate(field.NewPath("spec", "config")); err != nil {
+		return err
+	}
+	if err := n.validatePorts(field.NewPath("spec"), n.Config); err != nil {
+		return err
+	}
+	if n.Cluster != nil && n.Cluster.Service != nil {
+		if n.Replicas != nil && *n.Replicas > 1 && !n.Cluster.Service.PerReplica {
 			return field.Invalid(field.NewPath("spec").Child("replicas"), n.Replicas,
-				"cannot be greater than 1 when exposing the node group")
+				"cannot be greater than 1 when exposing the node group without perReplica")
+		}
+		if err := n.Cluster.Service.Validate(field.NewPath("spec", "cluster", "service")); err != nil {
+			return err
+		}
+	}
+	if n.Cluster != nil && n.Cluster.Kubeconfig != nil && n.Replicas != nil && *n.Replicas > 1 {
+		return field.Invalid(field.NewPath("spec").Child("replicas"), n.Replicas,
+			"cannot be greater than 1 for a group in the cluster of a kubeconfig, where the pod webhook cannot give each pod only its own certificate secret")
+	}
+	if n.Cluster != nil && n.Cluster.AdoptExisting != nil {
+		path := field.NewPath("spec", "cluster")
+		if err := n.Cluster.AdoptExisting.Validate(path.Child("adoptExisting")); err != nil {
+			return err
+		}
+		if n.Cluster.ConfigStorage == ConfigStorageSecret {
+			return field.Invalid(path.Child("configStorage"), n.Cluster.ConfigStorage,
+				"must be ConfigMap when adopting an existing ConfigMap")
+		}
+	}
+	if n.Cluster != nil && n.Cluster.DedicatedNodes != nil {
+		if err := n.Cluster.DedicatedNodes.Validate(field.NewPath("spec", "cluster", "dedicatedNodes")); err != nil {
+			return err
 		}
 	}
 	if n.GoogleCloud != nil {
@@ -93,6 +249,86 @@ func (n *NodeGroupSpec) Validate() error {
 			return err
 		}
 	}
+	if n.AWS != nil {
+		if err := n.AWS.Validate(field.NewPath("spec").Child("aws")); err != nil {
+			return err
+		}
+	}
+	if n.Azure != nil {
+		if err := n.Azure.Validate(field.NewPath("spec").Child("azure")); err != nil {
+			return err
+		}
+	}
+	if n.DigitalOcean != nil {
+		if err := n.DigitalOcean.Validate(field.NewPath("spec").Child("digitalOcean")); err != nil {
+			return err
+		}
+	}
+	if n.Hetzner != nil {
+		if err := n.Hetzner.Validate(field.NewPath("spec").Child("hetzner")); err != nil {
+			return err
+		}
+	}
+	if n.BareMetal != nil {
+		if err := n.BareMetal.Validate(field.NewPath("spec").Child("bareMetal")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NodeGroupProfile is the role a group of nodes plays in the mesh.
+type NodeGroupProfile string
+
+// IsCloudConfigManagedPath returns true if the cloud config of instances
+// writes the file at the given path itself, or owns its directory.
+func IsCloudConfigManagedPath(p string) bool {
+	if _, ok := cloudConfigManagedPaths[p]; ok {
+		return true
+	}
+	for _, dir := range cloudConfigManagedDirectories {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// NodeGroupProfileFull is a node that takes part in routing and may run
+	// any service.
+	NodeGroupProfileFull NodeGroupProfile = "full"
+	// NodeGroupProfileDNS is an observer that only serves MeshDNS.
+	NodeGroupProfileDNS NodeGroupProfile = "dns"
+	// NodeGroupProfileRelay is an observer that routes traffic.
+	NodeGroupProfileRelay NodeGroupProfile = "relay"
+)
+
+// IsObserver returns true if nodes with the profile join as observers.
+func (p NodeGroupProfile) IsObserver() bool {
+	return p == NodeGroupProfileDNS || p == NodeGroupProfileRelay
+}
+
+// validateProfile rejects configurations that contradict the group's profile.
+func (n *NodeGroupSpec) validateProfile() error {
+	path := field.NewPath("spec")
+	if n.Profile.IsObserver() && n.Config != nil {
+		if n.Config.Voter {
+			return field.Invalid(path.Child("config", "voter"), n.Config.Voter,
+				fmt.Sprintf("%s groups cannot be voters", n.Profile))
+		}
+	}
+	if n.Profile != NodeGroupProfileDNS {
+		return nil
+	}
+	if n.Config != nil && n.Config.AdvertisesDefaultGateway() {
+		return field.Invalid(path.Child("config", "defaultGateway"), n.Config.DefaultGateway,
+			"dns groups cannot advertise a default gateway")
+	}
+	if n.Cluster != nil && n.Cluster.Service != nil {
+		return field.Invalid(path.Child("cluster", "service"), n.Cluster.Service,
+			"dns groups cannot be exposed")
+	}
 	return nil
 }
 
@@ -134,6 +370,15 @@ type NodeGroupClusterConfig struct {
 	// +optional
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
 
+	// DedicatedNodes schedules the nodes of this group onto a pool of
+	// cluster nodes reserved for them. The pods tolerate the pool's taint and
+	// select its label in addition to the Tolerations and NodeSelector above.
+	// Pods of bootstrap groups are also marked as not safe to evict for the
+	// cluster autoscaler, so that nodes holding the mesh state are not
+	// consolidated away.
+	// +optional
+	DedicatedNodes *NodeGroupDedicatedNodesConfig `json:"dedicatedNodes,omitempty"`
+
 	// PreemptionPolicy is the preemption policy to use for the node
 	// containers in this group.
 	// +optional
@@ -170,10 +415,19 @@ type NodeGroupClusterConfig struct {
 	InitContainers []corev1.Container `json:"initContainers,omitempty"`
 
 	// Resources is the resource requirements for the node containers in
-	// this group.
+	// this group. Any requests or limits set here take precedence over
+	// those of the ResourcePreset.
 	// +optional
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
 
+	// ResourcePreset is a preset of resource requirements for the node
+	// containers in this group. Memory is limited to twice the preset's
+	// request and CPU is not limited. A memory request in Resources above
+	// the preset's limit raises the limit to match.
+	// +kubebuilder:validation:Enum:=small;medium;large
+	// +optional
+	ResourcePreset ResourcePreset `json:"resourcePreset,omitempty"`
+
 	// Service is the configuration for exposing this group of nodes.
 	// +optional
 	Service *NodeGroupLBConfig `json:"service,omitempty"`
@@ -182,10 +436,202 @@ type NodeGroupClusterConfig struct {
 	// +optional
 	PVCSpec *corev1.PersistentVolumeClaimSpec `json:"pvcSpec,omitempty"`
 
+	// RetainDataOnDelete keeps the PVCs of the group when it is deleted,
+	// preserving the mesh state of its nodes.
+	// +optional
+	RetainDataOnDelete bool `json:"retainDataOnDelete,omitempty"`
+
 	// Kubeconfig is a reference to a secret containing a kubeconfig to use
 	// for this group. If not specified, the current kubeconfig will be used.
+	// The pod webhook of the operator does not run in the cluster of the
+	// kubeconfig, so groups there are limited to a single replica.
 	// +optional
 	Kubeconfig *corev1.SecretKeySelector `json:"kubeconfig,omitempty"`
+
+	// ConfigStorage is the type of object the rendered node config is
+	// stored in.
+	// +kubebuilder:default:="ConfigMap"
+	// +optional
+	ConfigStorage ConfigStorageType `json:"configStorage,omitempty"`
+
+	// AdoptExisting names the StatefulSet and ConfigMap of an existing
+	// webmesh deployment that the group takes over instead of creating its
+	// own. Their names are used for every object of the group, and the pods
+	// are replaced one at a time while keeping their volume claims. This
+	// field is immutable.
+	// +optional
+	AdoptExisting *NodeGroupAdoptConfig `json:"adoptExisting,omitempty"`
+
+	// SelectorChangePolicy is what to do when the StatefulSet of the group
+	// selects its pods differently than the operator would, such as after
+	// the mesh was renamed. The selector of a StatefulSet cannot be changed.
+	// With Fail nothing is applied and the SelectorImmutable condition is
+	// set until the StatefulSet is removed. With Recreate the pods are
+	// relabeled and the StatefulSet is deleted while keeping them, so the
+	// new StatefulSet adopts the running nodes. Adopted StatefulSets keep
+	// their selector.
+	// +kubebuilder:default:="Fail"
+	// +optional
+	SelectorChangePolicy SelectorChangePolicy `json:"selectorChangePolicy,omitempty"`
+
+	// MaintenanceReadinessGate adds a readiness gate to the node pods that
+	// is turned off while the node is cordoned through the maintenance
+	// annotation, taking it out of the endpoints of the group's services.
+	// +optional
+	MaintenanceReadinessGate bool `json:"maintenanceReadinessGate,omitempty"`
+
+	// WireGuardMode is how the nodes bring up their WireGuard interface.
+	// The kernel mode requires the WireGuard kernel module and runs the
+	// nodes privileged so they can load it. The userspace mode runs the
+	// nodes without the SYS_MODULE capability and uses the slower userspace
+	// implementation over a TUN device. The auto mode runs the nodes the
+	// same way, but the nodes first try to create a kernel WireGuard
+	// interface and only fall back to userspace when that fails.
+	// +kubebuilder:default:="kernel"
+	// +optional
+	WireGuardMode WireGuardMode `json:"wireguardMode,omitempty"`
+}
+
+// NodeGroupDedicatedNodesConfig describes a pool of cluster nodes reserved for
+// a node group.
+type NodeGroupDedicatedNodesConfig struct {
+	// TaintKey is the key of the taint on the nodes of the pool. Taints with
+	// this key are tolerated regardless of their value and effect.
+	TaintKey string `json:"taintKey"`
+
+	// Label is the label of the nodes of the pool in the form key=value.
+	// +optional
+	Label string `json:"label,omitempty"`
+}
+
+// Validate validates the dedicated nodes config.
+func (c *NodeGroupDedicatedNodesConfig) Validate(path *field.Path) error {
+	if c.TaintKey == "" {
+		return field.Required(path.Child("taintKey"), "taint key is required")
+	}
+	if errs := validation.IsQualifiedName(c.TaintKey); len(errs) > 0 {
+		return field.Invalid(path.Child("taintKey"), c.TaintKey, strings.Join(errs, ", "))
+	}
+	if c.Label == "" {
+		return nil
+	}
+	key, value, ok := strings.Cut(c.Label, "=")
+	if !ok {
+		return field.Invalid(path.Child("label"), c.Label, "must be in the form key=value")
+	}
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return field.Invalid(path.Child("label"), c.Label, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return field.Invalid(path.Child("label"), c.Label, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// NodeSelector returns the node selector for the pool, or nil if it has no
+// label.
+func (c *NodeGroupDedicatedNodesConfig) NodeSelector() map[string]string {
+	key, value, ok := strings.Cut(c.Label, "=")
+	if !ok {
+		return nil
+	}
+	return map[string]string{key: value}
+}
+
+// NodeGroupAdoptConfig references the objects of an existing webmesh
+// deployment in the namespace of the group.
+type NodeGroupAdoptConfig struct {
+	// StatefulSetName is the name of the StatefulSet to adopt. It must select
+	// its pods with matchLabels only and be governed by a headless service of
+	// the same name. Its selector, volume claim templates and pod management
+	// policy are kept. Containers and volumes of its pod template that the
+	// operator does not render are kept as well.
+	StatefulSetName string `json:"statefulSetName"`
+
+	// ConfigMapName is the name of the ConfigMap to adopt. The rendered node
+	// config is written to it.
+	ConfigMapName string `json:"configMapName"`
+}
+
+// Validate validates the adoption config.
+func (c *NodeGroupAdoptConfig) Validate(path *field.Path) error {
+	if errs := validation.IsDNS1123Subdomain(c.StatefulSetName); len(errs) > 0 {
+		return field.Invalid(path.Child("statefulSetName"), c.StatefulSetName, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(c.ConfigMapName); len(errs) > 0 {
+		return field.Invalid(path.Child("configMapName"), c.ConfigMapName, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// WireGuardMode is how nodes bring up their WireGuard interface.
+// +kubebuilder:validation:Enum:=kernel;userspace;auto
+type WireGuardMode string
+
+const (
+	// WireGuardModeKernel uses the WireGuard kernel module.
+	WireGuardModeKernel WireGuardMode = "kernel"
+	// WireGuardModeUserspace uses the userspace WireGuard implementation.
+	WireGuardModeUserspace WireGuardMode = "userspace"
+	// WireGuardModeAuto uses the kernel module when it is available and
+	// falls back to userspace otherwise.
+	WireGuardModeAuto WireGuardMode = "auto"
+)
+
+// Unprivileged returns true if nodes run without the capability to load
+// kernel modules.
+func (m WireGuardMode) Unprivileged() bool {
+	return m == WireGuardModeUserspace || m == WireGuardModeAuto
+}
+
+// SelectorChangePolicy is what to do with a StatefulSet whose selector no
+// longer matches its node group.
+// +kubebuilder:validation:Enum:=Fail;Recreate
+type SelectorChangePolicy string
+
+const (
+	// SelectorChangeFail leaves the StatefulSet alone and reports the
+	// mismatch in the SelectorImmutable condition.
+	SelectorChangeFail SelectorChangePolicy = "Fail"
+	// SelectorChangeRecreate deletes the StatefulSet with its pods orphaned
+	// and creates it again with the new selector.
+	SelectorChangeRecreate SelectorChangePolicy = "Recreate"
+)
+
+// ConfigStorageType is the type of object a rendered node config is stored in.
+// +kubebuilder:validation:Enum:=ConfigMap;Secret
+type ConfigStorageType string
+
+const (
+	// ConfigStorageConfigMap stores the node config in a ConfigMap.
+	ConfigStorageConfigMap ConfigStorageType = "ConfigMap"
+	// ConfigStorageSecret stores the node config in a Secret.
+	ConfigStorageSecret ConfigStorageType = "Secret"
+)
+
+// ResourceRequirements returns the resource requirements for the node
+// containers in this group. Explicit Resources are layered over the
+// ResourcePreset, and a preset limit below an explicit request is raised to
+// match it, since the pods would be rejected otherwise.
+func (c *NodeGroupClusterConfig) ResourceRequirements() corev1.ResourceRequirements {
+	out := c.ResourcePreset.ResourceRequirements()
+	for name, quantity := range c.Resources.Requests {
+		if out.Requests == nil {
+			out.Requests = corev1.ResourceList{}
+		}
+		out.Requests[name] = quantity
+		if limit, ok := out.Limits[name]; ok && limit.Cmp(quantity) < 0 {
+			out.Limits[name] = quantity
+		}
+	}
+	for name, quantity := range c.Resources.Limits {
+		if out.Limits == nil {
+			out.Limits = corev1.ResourceList{}
+		}
+		out.Limits[name] = quantity
+	}
+	out.Claims = c.Resources.Claims
+	return out
 }
 
 // Default sets default values for the configuration.
@@ -193,11 +639,63 @@ func (c *NodeGroupClusterConfig) Default() {
 	if c.ImagePullPolicy == "" {
 		c.ImagePullPolicy = corev1.PullIfNotPresent
 	}
+	if c.ConfigStorage == "" {
+		c.ConfigStorage = ConfigStorageConfigMap
+	}
+	if c.WireGuardMode == "" {
+		c.WireGuardMode = WireGuardModeKernel
+	}
+	if c.SelectorChangePolicy == "" {
+		c.SelectorChangePolicy = SelectorChangeFail
+	}
 	if c.Service != nil {
 		c.Service.Default()
 	}
 }
 
+// ResourcePreset is a named set of resource requirements for node containers.
+//
+//	| Preset | CPU Request | Memory Request | Memory Limit |
+//	|--------|-------------|----------------|--------------|
+//	| small  | 100m        | 128Mi          | 256Mi        |
+//	| medium | 250m        | 256Mi          | 512Mi        |
+//	| large  | 500m        | 512Mi          | 1Gi          |
+type ResourcePreset string
+
+const (
+	// ResourcePresetSmall is suitable for small meshes and non-voting nodes.
+	ResourcePresetSmall ResourcePreset = "small"
+	// ResourcePresetMedium is suitable for bootstrap nodes of medium sized meshes.
+	ResourcePresetMedium ResourcePreset = "medium"
+	// ResourcePresetLarge is suitable for bootstrap nodes of large meshes.
+	ResourcePresetLarge ResourcePreset = "large"
+)
+
+// ResourceRequirements returns the resource requirements for the preset.
+// CPU is not limited to avoid throttling raft and WireGuard traffic.
+func (p ResourcePreset) ResourceRequirements() corev1.ResourceRequirements {
+	var cpu, memory, memoryLimit string
+	switch p {
+	case ResourcePresetSmall:
+		cpu, memory, memoryLimit = "100m", "128Mi", "256Mi"
+	case ResourcePresetMedium:
+		cpu, memory, memoryLimit = "250m", "256Mi", "512Mi"
+	case ResourcePresetLarge:
+		cpu, memory, memoryLimit = "500m", "512Mi", "1Gi"
+	default:
+		return corev1.ResourceRequirements{}
+	}
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse(memoryLimit),
+		},
+	}
+}
+
 // NodeGroupLBConfig defines the configurations for exposing a group of nodes.
 type NodeGroupLBConfig struct {
 	// Type is the type of service to expose.
@@ -225,6 +723,60 @@ type NodeGroupLBConfig struct {
 	// If left unset it will be generated from the service IP.
 	// +optional
 	ExternalURL string `json:"externalURL,omitempty"`
+
+	// PerReplica creates one LoadBalancer service per replica instead of
+	// a single service for the group. Each node broadcasts the addresses
+	// of its own service as its primary endpoint. Groups exposed with a
+	// single service are limited to one replica, so this is required to
+	// expose more.
+	// +optional
+	PerReplica bool `json:"perReplica,omitempty"`
+
+	// ServiceIPFamilies configures the IP families of the service. If unset
+	// the mesh default is used.
+	ServiceIPFamilies `json:",inline"`
+
+	// DNS configures publishing discovery records for the service to
+	// an external DNS provider.
+	// +optional
+	DNS *NodeGroupLBDNSConfig `json:"dns,omitempty"`
+
+	// ExtraDNSNames are additional DNS names clients use to reach the
+	// service. They are included in the certificates of the group's nodes
+	// along with the external addresses of the service.
+	// +optional
+	ExtraDNSNames []string `json:"extraDNSNames,omitempty"`
+
+	// ExtraIPAddresses are additional IP addresses clients use to reach
+	// the service. They are included in the certificates of the group's
+	// nodes along with the external addresses of the service.
+	// +optional
+	ExtraIPAddresses []string `json:"extraIPAddresses,omitempty"`
+}
+
+func (c *NodeGroupLBConfig) Validate(path *field.Path) error {
+	for i, name := range c.ExtraDNSNames {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return field.Invalid(path.Child("extraDNSNames").Index(i), name, strings.Join(errs, ", "))
+		}
+	}
+	for i, addr := range c.ExtraIPAddresses {
+		if net.ParseIP(addr) == nil {
+			return field.Invalid(path.Child("extraIPAddresses").Index(i), addr, "must be a valid IP address")
+		}
+	}
+	if c.PerReplica {
+		if c.Type != corev1.ServiceTypeLoadBalancer {
+			return field.Invalid(path.Child("type"), c.Type, "must be LoadBalancer when perReplica is set")
+		}
+		if c.ExternalURL != "" {
+			return field.Invalid(path.Child("externalURL"), c.ExternalURL, "cannot be set when perReplica is set")
+		}
+	}
+	if c.DNS != nil {
+		return c.DNS.Validate(path.Child("dns"))
+	}
+	return nil
 }
 
 func (c *NodeGroupLBConfig) Default() {
@@ -237,6 +789,75 @@ func (c *NodeGroupLBConfig) Default() {
 	if c.WireGuardPort == 0 {
 		c.WireGuardPort = 51820
 	}
+	if c.DNS != nil && c.DNS.Provider == "" {
+		c.DNS.Provider = DNSProviderGoogle
+	}
+}
+
+// ServiceIPFamilies configures the IP families of a service. If neither
+// field is set the families are detected from the cluster.
+type ServiceIPFamilies struct {
+	// IPFamilyPolicy is the IP family policy of the service.
+	// +optional
+	IPFamilyPolicy *corev1.IPFamilyPolicy `json:"ipFamilyPolicy,omitempty"`
+
+	// IPFamilies are the IP families of the service.
+	// +kubebuilder:validation:MaxItems=2
+	// +optional
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+}
+
+// IsSet returns true if either field is set.
+func (s *ServiceIPFamilies) IsSet() bool {
+	return s != nil && (s.IPFamilyPolicy != nil || len(s.IPFamilies) > 0)
+}
+
+// DNSProvider is an external DNS provider discovery records can be
+// published to.
+// +kubebuilder:validation:Enum=google
+type DNSProvider string
+
+const (
+	// DNSProviderGoogle publishes records to Google Cloud DNS.
+	DNSProviderGoogle DNSProvider = "google"
+)
+
+// NodeGroupLBDNSConfig configures the discovery records published for an
+// exposed node group. The following records are maintained in the zone, so
+// that meshes can share it:
+//
+//	<mesh-name>.<mesh-namespace>.<zone-domain>                A/AAAA  the load balancer addresses
+//	_webmesh._tcp.<mesh-name>.<mesh-namespace>.<zone-domain>  SRV     the gRPC port on the above host
+//	_webmesh._tcp.<mesh-name>.<mesh-namespace>.<zone-domain>  TXT     the SHA-256 fingerprint of the mesh CA
+type NodeGroupLBDNSConfig struct {
+	// Provider is the DNS provider hosting the zone.
+	// +kubebuilder:default:="google"
+	// +optional
+	Provider DNSProvider `json:"provider,omitempty"`
+
+	// Zone is the name of the zone to publish records in. For Google
+	// Cloud DNS this is the name of the managed zone.
+	// +kubebuilder:validation:Required
+	Zone string `json:"zone"`
+
+	// ProjectID is the ID of the Google Cloud project hosting the zone.
+	// +optional
+	ProjectID string `json:"projectID,omitempty"`
+
+	// CredentialsSecret is the credentials to use for the provider API.
+	// If omitted, workload identity will be used.
+	// +optional
+	CredentialsSecret *corev1.SecretKeySelector `json:"credentialsSecret,omitempty"`
+}
+
+func (c *NodeGroupLBDNSConfig) Validate(path *field.Path) error {
+	if c.Zone == "" {
+		return field.Invalid(path.Child("zone"), c.Zone, "zone is required")
+	}
+	if (c.Provider == "" || c.Provider == DNSProviderGoogle) && c.ProjectID == "" {
+		return field.Invalid(path.Child("projectID"), c.ProjectID, "projectID is required for google")
+	}
+	return nil
 }
 
 // NodeGroupGoogleCloudConfig defines the desired configurations for a node group
@@ -262,36 +883,1744 @@ type NodeGroupGoogleCloudConfig struct {
 	// +kubebuilder:validation:Required
 	MachineType string `json:"machineType"`
 
+	// NameTemplate is a template for the names of instances, rendered for
+	// every replica with {{ .Mesh }}, {{ .Group }} and {{ .Index }}. Names
+	// must be valid RFC 1035 labels of at most 60 characters, leaving room
+	// for the suffixes of the static addresses of instances, and differ for
+	// every index. Boot disks and static addresses are named after their
+	// instances. It is immutable. Defaults to the group's name followed by
+	// the index.
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// Image is the self-link or URL of the image to boot instances from.
+	// The image must run cloud-init, or Ignition for the container linux
+	// OS families. It cannot be set together with imageFamily.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ImageFamily is the family of the image to boot instances from, the
+	// latest image of the family is used. Defaults to ubuntu-2204-lts,
+	// flatcar-stable or fedora-coreos-stable depending on osFamily.
+	// +optional
+	ImageFamily string `json:"imageFamily,omitempty"`
+
+	// ImageProject is the project hosting the image family. Defaults to
+	// ubuntu-os-cloud, kinvolk-public or fedora-coreos-cloud depending on
+	// osFamily.
+	// +optional
+	ImageProject string `json:"imageProject,omitempty"`
+
+	// OSFamily is the family of the operating system of the image. Flatcar
+	// and Fedora CoreOS instances are configured with Ignition and cannot
+	// install extraPackages. Defaults to ubuntu.
+	// +optional
+	OSFamily OSFamily `json:"osFamily,omitempty"`
+
+	// DiskSizeGB is the size of the boot disk of instances in GB. Defaults
+	// to the size of the image.
+	// +kubebuilder:validation:Minimum:=10
+	// +optional
+	DiskSizeGB *int64 `json:"diskSizeGB,omitempty"`
+
+	// DiskType is the type of the boot disk of instances. Defaults to the
+	// default disk type of the zone.
+	// +kubebuilder:validation:Enum:=pd-standard;pd-balanced;pd-ssd
+	// +optional
+	DiskType string `json:"diskType,omitempty"`
+
+	// ProvisioningModel is the provisioning model of instances. Spot
+	// instances are cheaper but may be preempted at any time, the operator
+	// restarts or recreates them. Defaults to STANDARD.
+	// +kubebuilder:validation:Enum:=STANDARD;SPOT
+	// +optional
+	ProvisioningModel string `json:"provisioningModel,omitempty"`
+
+	// AutomaticRestart is whether instances terminated by Google Cloud are
+	// restarted automatically. It cannot be enabled for spot instances.
+	// Defaults to true for standard instances.
+	// +optional
+	AutomaticRestart *bool `json:"automaticRestart,omitempty"`
+
+	// OnHostMaintenance is what happens to instances during maintenance of
+	// their host. Spot instances can only be terminated. Defaults to MIGRATE
+	// for standard instances.
+	// +kubebuilder:validation:Enum:=MIGRATE;TERMINATE
+	// +optional
+	OnHostMaintenance string `json:"onHostMaintenance,omitempty"`
+
+	// ServiceAccountEmail is the email of the service account attached to
+	// instances. Instances have no service account by default.
+	// +optional
+	ServiceAccountEmail string `json:"serviceAccountEmail,omitempty"`
+
+	// Scopes are the OAuth scopes granted to the service account of
+	// instances. Defaults to the cloud-platform scope when a service
+	// account is set.
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+
 	// Tags is a list of instance tags to which this router applies.
 	// +optional
 	Tags []string `json:"tags,omitempty"`
 
+	// EnableIPv6 is whether instances are given an external IPv6 address.
+	// Defaults to whether the subnetwork has external IPv6 addresses.
+	// +optional
+	EnableIPv6 *bool `json:"enableIPv6,omitempty"`
+
+	// EnableExternalIPv4 is whether instances are given an external IPv4
+	// address. Instances without one are only reachable from within the
+	// network or over IPv6, and reach the internet through Cloud NAT.
+	// Defaults to true.
+	// +optional
+	EnableExternalIPv4 *bool `json:"enableExternalIPv4,omitempty"`
+
+	// StackType is the IP stack of the network interface of instances.
+	// IPv4 only instances are given no IPv6 addresses and do not use IPv6
+	// within the mesh, IPv6 only instances are given no IPv4 addresses.
+	// Defaults to the stack type of the subnetwork.
+	// +kubebuilder:validation:Enum:=IPV4_ONLY;IPV4_IPV6;IPV6_ONLY
+	// +optional
+	StackType string `json:"stackType,omitempty"`
+
+	// InternalOnly is whether instances are only reached on their internal
+	// addresses, such as over Cloud VPN or Interconnect. Instances are given
+	// no external addresses and advertise their internal IPv4 address as
+	// their primary endpoint unless primaryEndpoint is set.
+	// +optional
+	InternalOnly bool `json:"internalOnly,omitempty"`
+
+	// NetworkTier is the network tier of the external addresses of the
+	// instances. Defaults to the tier of the project. External IPv6
+	// addresses are only available in the premium tier.
+	// +kubebuilder:validation:Enum:=PREMIUM;STANDARD
+	// +optional
+	NetworkTier string `json:"networkTier,omitempty"`
+
+	// StaticIPs is whether the operator reserves static external addresses
+	// for each replica, including IPv6 addresses when instances have them.
+	// They are kept when instances are replaced, so endpoints do not change,
+	// and released once their replicas are removed or static addresses are
+	// disabled.
+	// +optional
+	StaticIPs bool `json:"staticIPs,omitempty"`
+
+	// Addresses are the names of existing regional external IPv4 addresses
+	// assigned to the replicas in order. They are never released by the
+	// operator. Replicas beyond them get reserved addresses if staticIPs is
+	// set.
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+
+	// DetectEndpoints is whether instances detect their own endpoints to
+	// advertise to the mesh. Multi-NIC instances may detect addresses on
+	// internal networks that are unreachable from other meshes. Defaults
+	// to true.
+	// +optional
+	DetectEndpoints *bool `json:"detectEndpoints,omitempty"`
+
+	// AllowRemoteDetection is whether instances may query remote services
+	// to detect their public address. Defaults to whether instances have
+	// an external IPv4 address.
+	// +optional
+	AllowRemoteDetection *bool `json:"allowRemoteDetection,omitempty"`
+
+	// PrimaryEndpoint is the address instances advertise as their primary
+	// endpoint. It is a template rendered for every replica, with
+	// {{ .Ordinal }} set to the replica's index. Defaults to the static
+	// external address of the replica, if it has one.
+	// +optional
+	PrimaryEndpoint string `json:"primaryEndpoint,omitempty"`
+
 	// Credentials is the credentials to use for the Google Cloud API.
 	// If omitted, workload identity will be used.
 	// +optional
 	Credentials *corev1.SecretKeySelector `json:"credentials,omitempty"`
-}
+
+	// ExternalAccount is a workload identity federation credential
+	// configuration, of type external_account, used for the Google Cloud
+	// API instead of credentials. Files it reads a subject token from must
+	// be mounted into the operator.
+	// +optional
+	ExternalAccount *GoogleCloudExternalAccount `json:"externalAccount,omitempty"`
+
+	// ImpersonateServiceAccount is the email of a service account
+	// impersonated for the Google Cloud API. The credentials, external
+	// account or workload identity of the operator must be granted the
+	// Service Account Token Creator role on it.
+	// +optional
+	ImpersonateServiceAccount string `json:"impersonateServiceAccount,omitempty"`
+
+	// Schedule is a window during which instances are running. Instances
+	// are stopped outside of the window, preserving their disks. The
+	// schedule can be overridden with the ScheduleOverrideAnnotation.
+	// +optional
+	Schedule *NodeGroupSchedule `json:"schedule,omitempty"`
+
+	// FileSecrets are files written to each instance from keys of secrets
+	// in the namespace of the node group. Instances are recreated when the
+	// content of a file changes.
+	// +optional
+	FileSecrets []FileSecret `json:"fileSecrets,omitempty"`
+
+	// HostAliases map the cluster names of node groups exposed through a
+	// load balancer to its external address in /etc/hosts of the instances.
+	// Instances can then reach those groups by the names in their
+	// certificates. Instances are recreated when the addresses change.
+	// +optional
+	HostAliases []GoogleCloudHostAlias `json:"hostAliases,omitempty"`
+
+	// DockerBridgeCIDR is the address and prefix length of the docker0
+	// bridge of the instances, such as 192.168.254.1/24. It must not
+	// overlap the IPv4 CIDR of the mesh or the networks the instances
+	// reach. Defaults to 192.168.254.1/24. Instances are recreated when it
+	// changes.
+	// +optional
+	DockerBridgeCIDR string `json:"dockerBridgeCIDR,omitempty"`
+
+	// ExtraFiles are files written to each instance after those of the
+	// operator. They cannot replace the files the operator writes.
+	// Instances are recreated when they change.
+	// +optional
+	ExtraFiles []CloudConfigFile `json:"extraFiles,omitempty"`
+
+	// ExtraPackages are packages installed on each instance in addition to
+	// those the node needs. Instances are recreated when they change.
+	// +optional
+	ExtraPackages []string `json:"extraPackages,omitempty"`
+
+	// ExtraRunCmd are commands run on the first boot of each instance,
+	// after the node is started. Instances are recreated when they change.
+	// +optional
+	ExtraRunCmd []string `json:"extraRunCmd,omitempty"`
+
+	// MaxUnavailable is the maximum number of replicas that may be
+	// unavailable while instances are replaced after their config changed.
+	// An instance is available once it is running and, when it has an
+	// external address, its gRPC port accepts connections. Defaults to 1.
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	MaxUnavailable *int32 `json:"maxUnavailable,omitempty"`
+
+	// UseManagedInstanceGroup runs the instances in a stateful zonal managed
+	// instance group named after the group instead of creating them one by
+	// one. The machine settings are rendered into an instance template, and
+	// the group is rolled over to a new template when they change. Every
+	// replica keeps its own node certificate and config: they are part of
+	// the user-data of a per-instance config, which also fixes the name of
+	// the replica's instance. The group recreates instances that are
+	// deleted or fail, and instances are replaced when their user-data
+	// changes. It cannot be combined with a schedule or static addresses,
+	// and it is immutable.
+	// +optional
+	UseManagedInstanceGroup bool `json:"useManagedInstanceGroup,omitempty"`
+
+	// CertificateRenewal is how renewed node certificates reach running
+	// instances. Recreate replaces the instances like any other change to
+	// their config. InPlace publishes the certificates in the metadata of
+	// the instances, where they are picked up within a minute and the node
+	// is restarted. Changing it recreates the instances once. It cannot be
+	// combined with managed instance groups. Defaults to Recreate.
+	// +kubebuilder:validation:Enum:=Recreate;InPlace
+	// +optional
+	CertificateRenewal GoogleCloudCertificateRenewal `json:"certificateRenewal,omitempty"`
+
+	// SecretDelivery is how the TLS material of the nodes reaches their
+	// instances. Metadata embeds it in the user-data of the instances,
+	// where it can be read by anyone allowed to get them. SecretManager
+	// stores it in Google Secret Manager in the project of the group, from
+	// where instances fetch it at boot with their service account, which
+	// needs the roles/secretmanager.secretAccessor role. Only the versions
+	// of the secrets are part of the user-data. Superseded versions are
+	// destroyed once every instance was replaced, and the secrets are
+	// deleted with the group. It cannot be combined with in place
+	// certificate renewal. Defaults to Metadata.
+	// +kubebuilder:validation:Enum:=Metadata;SecretManager
+	// +optional
+	SecretDelivery GoogleCloudSecretDelivery `json:"secretDelivery,omitempty"`
+
+	// SSH configures users created on the instances for logging in over
+	// SSH. It is optional on Google Cloud, where OS Login can grant access
+	// instead. Instances are recreated when the users or their keys
+	// change.
+	// +optional
+	SSH *CloudConfigSSH `json:"ssh,omitempty"`
+}
+
+// GoogleCloudCertificateRenewal is how renewed node certificates reach running
+// Google Cloud instances.
+type GoogleCloudCertificateRenewal string
+
+const (
+	// GoogleCloudCertificateRenewalRecreate recreates instances when their
+	// certificates are renewed.
+	GoogleCloudCertificateRenewalRecreate GoogleCloudCertificateRenewal = "Recreate"
+	// GoogleCloudCertificateRenewalInPlace writes renewed certificates to
+	// running instances and restarts their node.
+	GoogleCloudCertificateRenewalInPlace GoogleCloudCertificateRenewal = "InPlace"
+)
+
+// GoogleCloudSecretDelivery is how the TLS material of nodes reaches their
+// Google Cloud instances.
+type GoogleCloudSecretDelivery string
+
+const (
+	// GoogleCloudSecretDeliveryMetadata embeds the TLS material in the
+	// user-data of instances.
+	GoogleCloudSecretDeliveryMetadata GoogleCloudSecretDelivery = "Metadata"
+	// GoogleCloudSecretDeliverySecretManager stores the TLS material in
+	// Secret Manager, from where instances fetch it at boot.
+	GoogleCloudSecretDeliverySecretManager GoogleCloudSecretDelivery = "SecretManager"
+)
+
+// GoogleCloudHostAlias maps the cluster names of the nodes of an exposed node
+// group to the external address of its load balancer.
+type GoogleCloudHostAlias struct {
+	// NodeGroup is the name of a node group of the same mesh and namespace
+	// that is exposed through a load balancer.
+	// +kubebuilder:validation:Required
+	NodeGroup string `json:"nodeGroup"`
+}
+
+// FileSecret is a file written to an instance from a key of a secret.
+type FileSecret struct {
+	// SecretRef is a reference to the secret holding the file.
+	// +kubebuilder:validation:Required
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// Key is the key of the secret holding the content of the file.
+	// +kubebuilder:validation:Required
+	Key string `json:"key"`
+
+	// Path is the absolute path of the file on the instance.
+	// +kubebuilder:validation:Required
+	Path string `json:"path"`
+
+	// Mode is the octal permissions of the file.
+	// +kubebuilder:default:="0600"
+	// +kubebuilder:validation:Pattern:=`^0?[0-7]{3}$`
+	// +optional
+	Mode string `json:"mode,omitempty"`
+}
+
+// CloudConfigFile is a file written to an instance by its cloud config.
+type CloudConfigFile struct {
+	// Path is the absolute path of the file on the instance.
+	// +kubebuilder:validation:Required
+	Path string `json:"path"`
+
+	// Content is the content of the file.
+	// +optional
+	Content string `json:"content,omitempty"`
+
+	// Permissions are the octal permissions of the file.
+	// +kubebuilder:default:="0644"
+	// +kubebuilder:validation:Pattern:=`^0?[0-7]{3}$`
+	// +optional
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// CloudConfigSSH configures the users created on instances for SSH access.
+type CloudConfigSSH struct {
+	// Users are the users created on each instance.
+	// +kubebuilder:validation:MinItems:=1
+	Users []CloudConfigSSHUser `json:"users"`
+}
+
+// CloudConfigSSHUser is a user created on instances for SSH access.
+type CloudConfigSSHUser struct {
+	// Name is the name of the user.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// AuthorizedKeys are the SSH public keys allowed to log in as the user.
+	// +optional
+	AuthorizedKeys []string `json:"authorizedKeys,omitempty"`
+
+	// AuthorizedKeysSecretRef is a key of a secret in the namespace of the
+	// node group holding more authorized keys, one per line.
+	// +optional
+	AuthorizedKeysSecretRef *corev1.SecretKeySelector `json:"authorizedKeysSecretRef,omitempty"`
+
+	// Sudo grants the user sudo without a password.
+	// +optional
+	Sudo bool `json:"sudo,omitempty"`
+}
+
+// OSFamily is the family of the operating system of cloud instances. It
+// selects the format of their user data.
+// +kubebuilder:validation:Enum:=ubuntu;flatcar;fcos
+type OSFamily string
+
+const (
+	// OSFamilyUbuntu boots instances with a cloud-config installing docker
+	// from its apt repository.
+	OSFamilyUbuntu OSFamily = "ubuntu"
+	// OSFamilyFlatcar boots Flatcar Container Linux instances with an
+	// Ignition config.
+	OSFamilyFlatcar OSFamily = "flatcar"
+	// OSFamilyFedoraCoreOS boots Fedora CoreOS instances with an Ignition
+	// config.
+	OSFamilyFedoraCoreOS OSFamily = "fcos"
+)
+
+// ContainerLinux returns true if instances of the family are configured with
+// Ignition and ship with docker instead of installing packages.
+func (f OSFamily) ContainerLinux() bool {
+	return f == OSFamilyFlatcar || f == OSFamilyFedoraCoreOS
+}
+
+// sshUserNamePattern matches the names of users that can be created on
+// instances.
+var sshUserNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// Validate validates the SSH users.
+func (c *CloudConfigSSH) Validate(path *field.Path) error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Users) == 0 {
+		return field.Required(path.Child("users"), "at least one user is required")
+	}
+	names := make(map[string]struct{}, len(c.Users))
+	for i, user := range c.Users {
+		upath := path.Child("users").Index(i)
+		if !sshUserNamePattern.MatchString(user.Name) || user.Name == "root" {
+			return field.Invalid(upath.Child("name"), user.Name, "must be a valid non-root user name")
+		}
+		if _, ok := names[user.Name]; ok {
+			return field.Duplicate(upath.Child("name"), user.Name)
+		}
+		names[user.Name] = struct{}{}
+		if len(user.AuthorizedKeys) == 0 && user.AuthorizedKeysSecretRef == nil {
+			return field.Required(upath.Child("authorizedKeys"), "authorizedKeys or authorizedKeysSecretRef is required")
+		}
+		for j, key := range user.AuthorizedKeys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+				return field.Invalid(upath.Child("authorizedKeys").Index(j), key, err.Error())
+			}
+		}
+		if ref := user.AuthorizedKeysSecretRef; ref != nil && (ref.Name == "" || ref.Key == "") {
+			return field.Required(upath.Child("authorizedKeysSecretRef"), "name and key are required")
+		}
+	}
+	return nil
+}
+
+// SSHUsers returns the SSH users created on the instances of the group, or
+// nil if its cloud provider has none.
+func (s *NodeGroupSpec) SSHUsers() *CloudConfigSSH {
+	switch {
+	case s.GoogleCloud != nil:
+		return s.GoogleCloud.SSH
+	case s.AWS != nil:
+		return s.AWS.SSH
+	case s.Azure != nil:
+		return s.Azure.SSH
+	case s.DigitalOcean != nil:
+		return s.DigitalOcean.SSH
+	case s.Hetzner != nil:
+		return s.Hetzner.SSH
+	}
+	return nil
+}
+
+// OSFamily returns the operating system family of the instances of the group.
+func (s *NodeGroupSpec) OSFamily() OSFamily {
+	var family OSFamily
+	switch {
+	case s.GoogleCloud != nil:
+		family = s.GoogleCloud.OSFamily
+	case s.AWS != nil:
+		family = s.AWS.OSFamily
+	case s.Azure != nil:
+		family = s.Azure.OSFamily
+	case s.DigitalOcean != nil:
+		family = s.DigitalOcean.OSFamily
+	case s.Hetzner != nil:
+		family = s.Hetzner.OSFamily
+	}
+	if family == "" {
+		return OSFamilyUbuntu
+	}
+	return family
+}
+
+// cloudConfigManagedPaths are the files the cloud config of instances writes
+// outside of its managed directories.
+var cloudConfigManagedPaths = map[string]struct{}{
+	InstanceDockerConfigPath:                                  {},
+	InstanceHostsPath:                                         {},
+	InstanceEnvPath:                                           {},
+	InstanceCommandEnvPath:                                    {},
+	InstanceCommandEnvScriptPath:                              {},
+	InstanceRunCmdScriptPath:                                  {},
+	InstanceGatewaySysctlPath:                                 {},
+	InstanceForwardingSysctlPath:                              {},
+	InstanceSecretsRefreshScriptPath:                          {},
+	InstanceSecretsFetchScriptPath:                            {},
+	InstanceSystemdUnitDirectory + InstanceNodeUnitName:       {},
+	InstanceSystemdUnitDirectory + InstanceCommandEnvUnitName: {},
+	InstanceSystemdUnitDirectory + InstanceRunCmdUnitName:     {},
+	InstanceSystemdUnitDirectory + InstanceSecretsRefreshUnitName + ".service": {},
+	InstanceSystemdUnitDirectory + InstanceSecretsRefreshUnitName + ".timer":   {},
+}
+
+// cloudConfigManagedDirectories are the directories the cloud config of
+// instances owns entirely.
+var cloudConfigManagedDirectories = []string{
+	DefaultConfigDirectory,
+	InstanceStateDirectory,
+	InstanceSystemdUnitDirectory + InstanceNodeUnitName + ".d",
+}
+
+const (
+	// DefaultGoogleCloudImageFamily is the image family instances boot from
+	// by default.
+	DefaultGoogleCloudImageFamily = "ubuntu-2204-lts"
+	// DefaultGoogleCloudImageProject is the project hosting the default
+	// image family.
+	DefaultGoogleCloudImageProject = "ubuntu-os-cloud"
+	// DefaultGoogleCloudFlatcarImageFamily is the image family Flatcar
+	// instances boot from by default.
+	DefaultGoogleCloudFlatcarImageFamily = "flatcar-stable"
+	// DefaultGoogleCloudFlatcarImageProject is the project hosting the
+	// default Flatcar image family.
+	DefaultGoogleCloudFlatcarImageProject = "kinvolk-public"
+	// DefaultGoogleCloudFCOSImageFamily is the image family Fedora CoreOS
+	// instances boot from by default.
+	DefaultGoogleCloudFCOSImageFamily = "fedora-coreos-stable"
+	// DefaultGoogleCloudFCOSImageProject is the project hosting the default
+	// Fedora CoreOS image family.
+	DefaultGoogleCloudFCOSImageProject = "fedora-coreos-cloud"
+	// DefaultGoogleCloudScope is the OAuth scope granted to the service
+	// account of instances by default.
+	DefaultGoogleCloudScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+const (
+	// GoogleCloudDiskTypeStandard is the standard persistent disk type.
+	GoogleCloudDiskTypeStandard = "pd-standard"
+	// GoogleCloudDiskTypeBalanced is the balanced persistent disk type.
+	GoogleCloudDiskTypeBalanced = "pd-balanced"
+	// GoogleCloudDiskTypeSSD is the SSD persistent disk type.
+	GoogleCloudDiskTypeSSD = "pd-ssd"
+)
+
+const (
+	// GoogleCloudProvisioningModelStandard is the standard provisioning model.
+	GoogleCloudProvisioningModelStandard = "STANDARD"
+	// GoogleCloudProvisioningModelSpot is the spot provisioning model.
+	GoogleCloudProvisioningModelSpot = "SPOT"
+	// GoogleCloudOnHostMaintenanceMigrate live migrates instances during
+	// host maintenance.
+	GoogleCloudOnHostMaintenanceMigrate = "MIGRATE"
+	// GoogleCloudOnHostMaintenanceTerminate terminates instances during host
+	// maintenance.
+	GoogleCloudOnHostMaintenanceTerminate = "TERMINATE"
+)
+
+const (
+	// GoogleCloudStackTypeIPv4Only is the IPv4 only stack type.
+	GoogleCloudStackTypeIPv4Only = "IPV4_ONLY"
+	// GoogleCloudStackTypeDualStack is the dual-stack stack type.
+	GoogleCloudStackTypeDualStack = "IPV4_IPV6"
+	// GoogleCloudStackTypeIPv6Only is the IPv6 only stack type.
+	GoogleCloudStackTypeIPv6Only = "IPV6_ONLY"
+)
+
+const (
+	// GoogleCloudNetworkTierPremium is the premium network tier.
+	GoogleCloudNetworkTierPremium = "PREMIUM"
+	// GoogleCloudNetworkTierStandard is the standard network tier.
+	GoogleCloudNetworkTierStandard = "STANDARD"
+)
+
+// BootImageFamily returns the image family and its project instances boot
+// from when no image is set.
+func (c *NodeGroupGoogleCloudConfig) BootImageFamily() (family, project string) {
+	family, project = c.ImageFamily, c.ImageProject
+	defaultFamily, defaultProject := DefaultGoogleCloudImageFamily, DefaultGoogleCloudImageProject
+	switch c.OSFamily {
+	case OSFamilyFlatcar:
+		defaultFamily, defaultProject = DefaultGoogleCloudFlatcarImageFamily, DefaultGoogleCloudFlatcarImageProject
+	case OSFamilyFedoraCoreOS:
+		defaultFamily, defaultProject = DefaultGoogleCloudFCOSImageFamily, DefaultGoogleCloudFCOSImageProject
+	}
+	if family == "" {
+		family = defaultFamily
+	}
+	if project == "" {
+		project = defaultProject
+	}
+	return family, project
+}
+
+// RenewsCertificatesInPlace returns true if renewed certificates are written to
+// running instances instead of recreating them.
+func (c *NodeGroupGoogleCloudConfig) RenewsCertificatesInPlace() bool {
+	return c.CertificateRenewal == GoogleCloudCertificateRenewalInPlace
+}
+
+// UsesSecretManager returns true if the TLS material of the nodes is stored in
+// Secret Manager instead of the user-data of their instances.
+func (c *NodeGroupGoogleCloudConfig) UsesSecretManager() bool {
+	return c.SecretDelivery == GoogleCloudSecretDeliverySecretManager
+}
+
+// Spot returns true if instances are spot instances.
+func (c *NodeGroupGoogleCloudConfig) Spot() bool {
+	return c.ProvisioningModel == GoogleCloudProvisioningModelSpot
+}
+
+// ServiceAccountScopes returns the OAuth scopes granted to the service account
+// of instances.
+func (c *NodeGroupGoogleCloudConfig) ServiceAccountScopes() []string {
+	if len(c.Scopes) == 0 {
+		return []string{DefaultGoogleCloudScope}
+	}
+	return c.Scopes
+}
+
+// ExternalIPv4 returns true if instances are given an external IPv4 address.
+func (c *NodeGroupGoogleCloudConfig) ExternalIPv4() bool {
+	if c.InternalOnly || c.StackType == GoogleCloudStackTypeIPv6Only {
+		return false
+	}
+	return c.EnableExternalIPv4 == nil || *c.EnableExternalIPv4
+}
+
+// DetectsEndpoints returns true if instances detect their own endpoints.
+func (c *NodeGroupGoogleCloudConfig) DetectsEndpoints() bool {
+	return c.DetectEndpoints == nil || *c.DetectEndpoints
+}
+
+// AllowsRemoteDetection returns true if instances may detect their public
+// address through remote services. Without an external address, remote
+// detection would only find the address of the Cloud NAT gateway.
+func (c *NodeGroupGoogleCloudConfig) AllowsRemoteDetection() bool {
+	if !c.DetectsEndpoints() {
+		return false
+	}
+	if c.AllowRemoteDetection != nil {
+		return *c.AllowRemoteDetection
+	}
+	return c.ExternalIPv4()
+}
+
+// MaxUnavailableReplicas returns the maximum number of replicas that may be
+// unavailable while instances are replaced.
+func (c *NodeGroupGoogleCloudConfig) MaxUnavailableReplicas() int {
+	if c.MaxUnavailable == nil {
+		return 1
+	}
+	return int(*c.MaxUnavailable)
+}
+
+// PrimaryEndpointFor returns the primary endpoint for the replica with the
+// given ordinal.
+func (c *NodeGroupGoogleCloudConfig) PrimaryEndpointFor(ordinal int) (string, error) {
+	return renderSAN(c.PrimaryEndpoint, ordinal)
+}
+
+// GoogleCloudExternalAccount selects a key of a ConfigMap or Secret in the
+// namespace of the node group holding an external account credential
+// configuration. Exactly one of them must be set.
+type GoogleCloudExternalAccount struct {
+	// ConfigMapKeyRef selects a key of a ConfigMap.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// SecretKeyRef selects a key of a Secret.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// Validate validates the GoogleCloudExternalAccount.
+func (c *GoogleCloudExternalAccount) Validate(path *field.Path) error {
+	if (c.ConfigMapKeyRef == nil) == (c.SecretKeyRef == nil) {
+		return field.Invalid(path, c, "exactly one of configMapKeyRef and secretKeyRef must be set")
+	}
+	if c.ConfigMapKeyRef != nil && (c.ConfigMapKeyRef.Name == "" || c.ConfigMapKeyRef.Key == "") {
+		return field.Invalid(path.Child("configMapKeyRef"), c.ConfigMapKeyRef, "name and key are required")
+	}
+	if c.SecretKeyRef != nil && (c.SecretKeyRef.Name == "" || c.SecretKeyRef.Key == "") {
+		return field.Invalid(path.Child("secretKeyRef"), c.SecretKeyRef, "name and key are required")
+	}
+	return nil
+}
+
+// maxGoogleCloudInstanceName is the maximum length of the name of an instance.
+// Static addresses are named after their instance with a three character
+// suffix and their names are limited to 63 characters as well.
+const maxGoogleCloudInstanceName = 60
+
+// GoogleCloudInstanceName returns the name of the instance of the replica with
+// the given ordinal.
+func (n *NodeGroup) GoogleCloudInstanceName(ordinal int) (string, error) {
+	if n.Spec.GoogleCloud == nil || n.Spec.GoogleCloud.NameTemplate == "" {
+		return fmt.Sprintf("%s-%d", n.GetName(), ordinal), nil
+	}
+	t, err := template.New("name").Option("missingkey=error").Parse(n.Spec.GoogleCloud.NameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, struct {
+		Mesh  string
+		Group string
+		Index int
+	}{n.MeshKey().Name, n.GetName(), ordinal})
+	if err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// validateGoogleCloudNameTemplate ensures the name template of the group
+// renders a valid and distinct name for every replica. At least two replicas
+// are rendered so templates ignoring the index are rejected.
+func validateGoogleCloudNameTemplate(group *NodeGroup) error {
+	if group.Spec.GoogleCloud == nil || group.Spec.GoogleCloud.NameTemplate == "" {
+		return nil
+	}
+	path := field.NewPath("spec", "googleCloud", "nameTemplate")
+	tmpl := group.Spec.GoogleCloud.NameTemplate
+	replicas := int(group.Replicas())
+	if replicas < 2 {
+		replicas = 2
+	}
+	seen := make(map[string]int, replicas)
+	for i := 0; i < replicas; i++ {
+		name, err := group.GoogleCloudInstanceName(i)
+		if err != nil {
+			return field.Invalid(path, tmpl, err.Error())
+		}
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return field.Invalid(path, tmpl, fmt.Sprintf("rendered %q for index %d: %s", name, i, strings.Join(errs, ", ")))
+		}
+		if len(name) > maxGoogleCloudInstanceName {
+			return field.Invalid(path, tmpl, fmt.Sprintf("rendered %q for index %d, names must be no more than %d characters", name, i, maxGoogleCloudInstanceName))
+		}
+		if other, ok := seen[name]; ok {
+			return field.Invalid(path, tmpl, fmt.Sprintf("rendered %q for both index %d and %d", name, other, i))
+		}
+		seen[name] = i
+	}
+	return nil
+}
+
+// Default sets default values for any unset fields.
+func (c *NodeGroupGoogleCloudConfig) Default() {
+	if c.Schedule != nil && c.Schedule.TimeZone == "" {
+		c.Schedule.TimeZone = "UTC"
+	}
+	for i := range c.FileSecrets {
+		if c.FileSecrets[i].Mode == "" {
+			c.FileSecrets[i].Mode = "0600"
+		}
+	}
+}
+
+// DockerBridgeNetwork returns the network of the docker0 bridge of the
+// instances.
+func (c *NodeGroupGoogleCloudConfig) DockerBridgeNetwork() (*net.IPNet, error) {
+	cidr := c.DockerBridgeCIDR
+	if cidr == "" {
+		cidr = DefaultDockerBridgeCIDR
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
 
 func (c *NodeGroupGoogleCloudConfig) Validate(path *field.Path) error {
+	if err := c.SSH.Validate(path.Child("ssh")); err != nil {
+		return err
+	}
 	if c.ProjectID == "" {
 		return field.Invalid(path.Child("projectID"), c.ProjectID, "projectID is required")
 	}
-	if c.Subnetwork == "" {
-		return field.Invalid(path.Child("subnetwork"), c.Subnetwork, "subnetwork is required")
+	if c.Subnetwork == "" {
+		return field.Invalid(path.Child("subnetwork"), c.Subnetwork, "subnetwork is required")
+	}
+	if c.Zone == "" {
+		return field.Invalid(path.Child("zone"), c.Zone, "zone is required")
+	}
+	if c.MachineType == "" {
+		return field.Invalid(path.Child("machineType"), c.MachineType, "machineType is required")
+	}
+	if c.ExternalAccount != nil {
+		if c.Credentials != nil {
+			return field.Invalid(path.Child("externalAccount"), c.ExternalAccount, "externalAccount cannot be set together with credentials")
+		}
+		if err := c.ExternalAccount.Validate(path.Child("externalAccount")); err != nil {
+			return err
+		}
+	}
+	if c.ImpersonateServiceAccount != "" && !strings.Contains(c.ImpersonateServiceAccount, "@") {
+		return field.Invalid(path.Child("impersonateServiceAccount"), c.ImpersonateServiceAccount, "must be the email of a service account")
+	}
+	if c.Image != "" && c.ImageFamily != "" {
+		return field.Invalid(path.Child("image"), c.Image, "image cannot be set together with imageFamily")
+	}
+	if c.DockerBridgeCIDR != "" {
+		ip, network, err := net.ParseCIDR(c.DockerBridgeCIDR)
+		switch {
+		case err != nil:
+			return field.Invalid(path.Child("dockerBridgeCIDR"), c.DockerBridgeCIDR, err.Error())
+		case ip.To4() == nil:
+			return field.Invalid(path.Child("dockerBridgeCIDR"), c.DockerBridgeCIDR, "must be an IPv4 address and prefix length")
+		case ip.Equal(network.IP):
+			return field.Invalid(path.Child("dockerBridgeCIDR"), c.DockerBridgeCIDR, "must be the address of the bridge, not the network address")
+		}
+	}
+	if c.DiskSizeGB != nil && *c.DiskSizeGB < 10 {
+		return field.Invalid(path.Child("diskSizeGB"), *c.DiskSizeGB, "diskSizeGB must be at least 10")
+	}
+	switch c.DiskType {
+	case "", GoogleCloudDiskTypeStandard, GoogleCloudDiskTypeBalanced, GoogleCloudDiskTypeSSD:
+	default:
+		return field.NotSupported(path.Child("diskType"), c.DiskType, []string{
+			GoogleCloudDiskTypeStandard, GoogleCloudDiskTypeBalanced, GoogleCloudDiskTypeSSD,
+		})
+	}
+	switch c.StackType {
+	case "", GoogleCloudStackTypeDualStack:
+	case GoogleCloudStackTypeIPv4Only:
+		if c.EnableIPv6 != nil && *c.EnableIPv6 {
+			return field.Invalid(path.Child("enableIPv6"), *c.EnableIPv6, "IPv4 only instances have no IPv6 addresses")
+		}
+	case GoogleCloudStackTypeIPv6Only:
+		if c.EnableExternalIPv4 != nil && *c.EnableExternalIPv4 {
+			return field.Invalid(path.Child("enableExternalIPv4"), *c.EnableExternalIPv4, "IPv6 only instances have no IPv4 addresses")
+		}
+		if c.InternalOnly {
+			// The internal address advertised by internal only instances
+			// is their IPv4 address
+			return field.Invalid(path.Child("internalOnly"), c.InternalOnly, "internal only instances require an IPv4 address")
+		}
+	default:
+		return field.NotSupported(path.Child("stackType"), c.StackType, []string{
+			GoogleCloudStackTypeIPv4Only, GoogleCloudStackTypeDualStack, GoogleCloudStackTypeIPv6Only,
+		})
+	}
+	if c.InternalOnly {
+		switch {
+		case c.EnableExternalIPv4 != nil && *c.EnableExternalIPv4:
+			return field.Invalid(path.Child("enableExternalIPv4"), *c.EnableExternalIPv4, "internal only instances have no external addresses")
+		case c.EnableIPv6 != nil && *c.EnableIPv6:
+			return field.Invalid(path.Child("enableIPv6"), *c.EnableIPv6, "internal only instances have no external addresses")
+		case c.StaticIPs:
+			return field.Invalid(path.Child("staticIPs"), c.StaticIPs, "internal only instances have no external addresses")
+		case c.NetworkTier != "":
+			return field.Invalid(path.Child("networkTier"), c.NetworkTier, "internal only instances have no external addresses")
+		case c.AllowRemoteDetection != nil && *c.AllowRemoteDetection:
+			// Remote services would only see the address of the Cloud NAT gateway
+			return field.Invalid(path.Child("allowRemoteDetection"), *c.AllowRemoteDetection, "internal only instances cannot detect a public address")
+		}
+	}
+	if len(c.Addresses) > 0 && !c.ExternalIPv4() {
+		return field.Invalid(path.Child("addresses"), c.Addresses, "addresses require an external IPv4 address")
+	}
+	if c.UseManagedInstanceGroup {
+		switch {
+		case c.Schedule != nil:
+			return field.Invalid(path.Child("schedule"), c.Schedule, "managed instance groups cannot be scheduled")
+		case c.StaticIPs:
+			return field.Invalid(path.Child("staticIPs"), c.StaticIPs, "managed instance groups cannot reserve static addresses")
+		case len(c.Addresses) > 0:
+			return field.Invalid(path.Child("addresses"), c.Addresses, "managed instance groups cannot use static addresses")
+		case c.RenewsCertificatesInPlace():
+			return field.Invalid(path.Child("certificateRenewal"), c.CertificateRenewal, "managed instance groups replace instances to renew certificates")
+		}
+	}
+	if c.Spot() && c.AutomaticRestart != nil && *c.AutomaticRestart {
+		return field.Invalid(path.Child("automaticRestart"), *c.AutomaticRestart, "spot instances cannot be restarted automatically")
+	}
+	if c.Spot() && c.OnHostMaintenance == GoogleCloudOnHostMaintenanceMigrate {
+		return field.Invalid(path.Child("onHostMaintenance"), c.OnHostMaintenance, "spot instances cannot be migrated")
+	}
+	if c.ServiceAccountEmail == "" && len(c.Scopes) > 0 {
+		return field.Invalid(path.Child("scopes"), c.Scopes, "scopes require serviceAccountEmail")
+	}
+	if c.UsesSecretManager() {
+		switch {
+		case c.ServiceAccountEmail == "":
+			return field.Invalid(path.Child("secretDelivery"), c.SecretDelivery, "instances need a service account to fetch secrets")
+		case !slices.Contains(c.ServiceAccountScopes(), DefaultGoogleCloudScope):
+			// Secret Manager accepts no narrower scope
+			return field.Invalid(path.Child("scopes"), c.Scopes, "fetching secrets requires the cloud-platform scope")
+		case c.RenewsCertificatesInPlace():
+			return field.Invalid(path.Child("certificateRenewal"), c.CertificateRenewal, "in place renewal publishes certificates in instance metadata")
+		}
+	}
+	if c.NetworkTier == GoogleCloudNetworkTierStandard && c.EnableIPv6 != nil && *c.EnableIPv6 {
+		return field.Invalid(path.Child("enableIPv6"), *c.EnableIPv6, "external IPv6 addresses require the premium network tier")
+	}
+	if !c.DetectsEndpoints() && c.AllowRemoteDetection != nil && *c.AllowRemoteDetection {
+		return field.Invalid(path.Child("allowRemoteDetection"), *c.AllowRemoteDetection, "remote detection requires detectEndpoints")
+	}
+	if c.MaxUnavailable != nil && *c.MaxUnavailable < 1 {
+		return field.Invalid(path.Child("maxUnavailable"), *c.MaxUnavailable, "maxUnavailable must be at least 1")
+	}
+	if c.PrimaryEndpoint != "" {
+		rendered, err := c.PrimaryEndpointFor(0)
+		if err != nil {
+			return field.Invalid(path.Child("primaryEndpoint"), c.PrimaryEndpoint, err.Error())
+		}
+		if net.ParseIP(rendered) == nil {
+			if errs := validation.IsDNS1123Subdomain(rendered); len(errs) > 0 {
+				return field.Invalid(path.Child("primaryEndpoint"), c.PrimaryEndpoint, "must be a valid IP address or DNS name")
+			}
+		}
+	}
+	if c.Schedule != nil {
+		if err := c.Schedule.Validate(path.Child("schedule")); err != nil {
+			return err
+		}
+	}
+	paths := make(map[string]struct{}, len(c.FileSecrets))
+	for i, file := range c.FileSecrets {
+		fpath := path.Child("fileSecrets").Index(i)
+		if file.SecretRef.Name == "" {
+			return field.Invalid(fpath.Child("secretRef", "name"), file.SecretRef.Name, "secret name is required")
+		}
+		if file.Key == "" {
+			return field.Invalid(fpath.Child("key"), file.Key, "key is required")
+		}
+		if !filepath.IsAbs(file.Path) || filepath.Clean(file.Path) != file.Path {
+			return field.Invalid(fpath.Child("path"), file.Path, "must be a clean absolute path")
+		}
+		if IsCloudConfigManagedPath(file.Path) {
+			return field.Invalid(fpath.Child("path"), file.Path, "is written by the operator")
+		}
+		if _, ok := paths[file.Path]; ok {
+			return field.Invalid(fpath.Child("path"), file.Path, "duplicate path")
+		}
+		paths[file.Path] = struct{}{}
+		if file.Mode != "" {
+			if _, err := strconv.ParseUint(file.Mode, 8, 32); err != nil || len(file.Mode) > 4 {
+				return field.Invalid(fpath.Child("mode"), file.Mode, "must be octal permissions")
+			}
+		}
+	}
+	for i, file := range c.ExtraFiles {
+		fpath := path.Child("extraFiles").Index(i)
+		if !filepath.IsAbs(file.Path) || filepath.Clean(file.Path) != file.Path {
+			return field.Invalid(fpath.Child("path"), file.Path, "must be a clean absolute path")
+		}
+		if IsCloudConfigManagedPath(file.Path) {
+			return field.Invalid(fpath.Child("path"), file.Path, "is written by the operator")
+		}
+		if _, ok := paths[file.Path]; ok {
+			return field.Invalid(fpath.Child("path"), file.Path, "duplicate path")
+		}
+		paths[file.Path] = struct{}{}
+		if file.Permissions != "" {
+			if _, err := strconv.ParseUint(file.Permissions, 8, 32); err != nil || len(file.Permissions) > 4 {
+				return field.Invalid(fpath.Child("permissions"), file.Permissions, "must be octal permissions")
+			}
+		}
+	}
+	if len(c.ExtraPackages) > 0 && c.OSFamily.ContainerLinux() {
+		return field.Invalid(path.Child("extraPackages"), c.ExtraPackages, fmt.Sprintf("packages cannot be installed on %s instances", c.OSFamily))
+	}
+	for i, pkg := range c.ExtraPackages {
+		if pkg == "" || strings.ContainsAny(pkg, " \t\n") {
+			return field.Invalid(path.Child("extraPackages").Index(i), pkg, "must be a package name")
+		}
+	}
+	for i, cmd := range c.ExtraRunCmd {
+		if strings.TrimSpace(cmd) == "" {
+			return field.Invalid(path.Child("extraRunCmd").Index(i), cmd, "command is required")
+		}
+	}
+	aliases := make(map[string]struct{}, len(c.HostAliases))
+	for i, alias := range c.HostAliases {
+		apath := path.Child("hostAliases").Index(i).Child("nodeGroup")
+		if errs := validation.IsDNS1123Subdomain(alias.NodeGroup); len(errs) > 0 {
+			return field.Invalid(apath, alias.NodeGroup, strings.Join(errs, ", "))
+		}
+		if _, ok := aliases[alias.NodeGroup]; ok {
+			return field.Duplicate(apath, alias.NodeGroup)
+		}
+		aliases[alias.NodeGroup] = struct{}{}
+	}
+	return nil
+}
+
+// NodeGroupAWSConfig defines the desired configurations for a node group
+// running on AWS EC2 instances.
+type NodeGroupAWSConfig struct {
+	// Region is the region to launch the instances in.
+	// +kubebuilder:validation:Required
+	Region string `json:"region"`
+
+	// SubnetID is the ID of the subnet to launch the instances in.
+	// +kubebuilder:validation:Required
+	SubnetID string `json:"subnetID"`
+
+	// AssociatePublicIPAddress is whether instances are given a public IPv4
+	// address. Instances without one are only reachable from within the VPC
+	// and reach the internet through a NAT gateway. Instances are recreated
+	// when it changes. Defaults to true.
+	// +optional
+	AssociatePublicIPAddress *bool `json:"associatePublicIPAddress,omitempty"`
+
+	// InstanceType is the instance type of the instances.
+	// +kubebuilder:validation:Required
+	InstanceType string `json:"instanceType"`
+
+	// AMI is the ID of the image to launch the instances from. Defaults to
+	// the latest Ubuntu 22.04 image published by Canonical for the
+	// architecture. It is required for the container linux OS families.
+	// +optional
+	AMI string `json:"ami,omitempty"`
+
+	// OSFamily is the family of the operating system of the AMI. Flatcar
+	// and Fedora CoreOS instances are configured with Ignition. Defaults
+	// to ubuntu.
+	// +optional
+	OSFamily OSFamily `json:"osFamily,omitempty"`
+
+	// Architecture is the architecture of the default image. It must match
+	// the instance type.
+	// +kubebuilder:default:="x86_64"
+	// +kubebuilder:validation:Enum:=x86_64;arm64
+	// +optional
+	Architecture string `json:"architecture,omitempty"`
+
+	// SecurityGroupIDs are the IDs of the security groups of the instances.
+	// Defaults to the default security group of the VPC.
+	// +optional
+	SecurityGroupIDs []string `json:"securityGroupIDs,omitempty"`
+
+	// Tags are additional tags of the instances. The Name tag and tags
+	// starting with webmesh.io/ are set by the operator.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Credentials is a reference to a key of a secret holding the
+	// credentials to use for the EC2 API. The key holds a JSON object with
+	// an AccessKeyId, a SecretAccessKey and an optional SessionToken. If
+	// omitted, IAM roles for service accounts will be used.
+	// +optional
+	Credentials *corev1.SecretKeySelector `json:"credentials,omitempty"`
+
+	// SSH configures users created on the instances for logging in over
+	// SSH. Instances are recreated when the users or their keys change.
+	// +optional
+	SSH *CloudConfigSSH `json:"ssh,omitempty"`
+}
+
+const (
+	// AWSArchitectureX86 is the 64-bit x86 architecture.
+	AWSArchitectureX86 = "x86_64"
+	// AWSArchitectureARM is the 64-bit ARM architecture.
+	AWSArchitectureARM = "arm64"
+)
+
+// Default sets default values for any unset fields.
+func (c *NodeGroupAWSConfig) Default() {
+	if c.Architecture == "" {
+		c.Architecture = AWSArchitectureX86
+	}
+}
+
+// PublicIPAddress returns true if instances are given a public IPv4 address.
+func (c *NodeGroupAWSConfig) PublicIPAddress() bool {
+	return c.AssociatePublicIPAddress == nil || *c.AssociatePublicIPAddress
+}
+
+func (c *NodeGroupAWSConfig) Validate(path *field.Path) error {
+	if err := c.SSH.Validate(path.Child("ssh")); err != nil {
+		return err
+	}
+	if c.Region == "" {
+		return field.Invalid(path.Child("region"), c.Region, "region is required")
+	}
+	if !strings.HasPrefix(c.SubnetID, "subnet-") {
+		return field.Invalid(path.Child("subnetID"), c.SubnetID, "must be a subnet ID")
+	}
+	if c.InstanceType == "" {
+		return field.Invalid(path.Child("instanceType"), c.InstanceType, "instanceType is required")
+	}
+	if c.AMI != "" && !strings.HasPrefix(c.AMI, "ami-") {
+		return field.Invalid(path.Child("ami"), c.AMI, "must be an image ID")
+	}
+	if c.AMI == "" && c.OSFamily.ContainerLinux() {
+		return field.Required(path.Child("ami"), fmt.Sprintf("an image is required for %s instances", c.OSFamily))
+	}
+	if c.Architecture != "" && c.Architecture != AWSArchitectureX86 && c.Architecture != AWSArchitectureARM {
+		return field.NotSupported(path.Child("architecture"), c.Architecture, []string{AWSArchitectureX86, AWSArchitectureARM})
+	}
+	for i, id := range c.SecurityGroupIDs {
+		if !strings.HasPrefix(id, "sg-") {
+			return field.Invalid(path.Child("securityGroupIDs").Index(i), id, "must be a security group ID")
+		}
+	}
+	for key := range c.Tags {
+		if key == "Name" || strings.HasPrefix(key, "webmesh.io/") || strings.HasPrefix(key, "aws:") {
+			return field.Invalid(path.Child("tags").Key(key), c.Tags[key], "tag is reserved")
+		}
+	}
+	return nil
+}
+
+// NodeGroupAzureConfig defines the desired configurations for a node group
+// running on Azure virtual machines.
+type NodeGroupAzureConfig struct {
+	// SubscriptionID is the ID of the subscription to create the virtual
+	// machines in.
+	// +kubebuilder:validation:Required
+	SubscriptionID string `json:"subscriptionID"`
+
+	// ResourceGroup is the resource group to create the virtual machines in.
+	// +kubebuilder:validation:Required
+	ResourceGroup string `json:"resourceGroup"`
+
+	// Location is the region to create the virtual machines in.
+	// +kubebuilder:validation:Required
+	Location string `json:"location"`
+
+	// VirtualNetwork is the name of the virtual network of the subnet.
+	// +kubebuilder:validation:Required
+	VirtualNetwork string `json:"virtualNetwork"`
+
+	// Subnet is the name of the subnet to place the network interfaces of
+	// the virtual machines in.
+	// +kubebuilder:validation:Required
+	Subnet string `json:"subnet"`
+
+	// EnablePublicIPAddress is whether the virtual machines are given a
+	// static public IPv4 address. Virtual machines without one are only
+	// reachable from within the virtual network and reach the internet
+	// through the outbound access of the subnet. Virtual machines are
+	// recreated when it changes. Defaults to true.
+	// +optional
+	EnablePublicIPAddress *bool `json:"enablePublicIPAddress,omitempty"`
+
+	// VirtualNetworkResourceGroup is the resource group of the virtual
+	// network. Defaults to the resource group of the virtual machines.
+	// +optional
+	VirtualNetworkResourceGroup string `json:"virtualNetworkResourceGroup,omitempty"`
+
+	// VMSize is the size of the virtual machines.
+	// +kubebuilder:validation:Required
+	VMSize string `json:"vmSize"`
+
+	// Image is the image to create the virtual machines from. Defaults to
+	// the latest Ubuntu 22.04 image published by Canonical. It is required
+	// for the container linux OS families.
+	// +optional
+	Image *AzureImageReference `json:"image,omitempty"`
+
+	// OSFamily is the family of the operating system of the image. Flatcar
+	// and Fedora CoreOS virtual machines are configured with Ignition.
+	// Defaults to ubuntu.
+	// +optional
+	OSFamily OSFamily `json:"osFamily,omitempty"`
+
+	// Tags are additional tags of the virtual machines. Tags starting with
+	// webmesh.io- are set by the operator.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ManagedIdentityClientID is the client ID of the user-assigned managed
+	// identity of the operator to use for the Azure APIs. It is only used
+	// without credentials and when workload identity is not configured for
+	// the operator. Defaults to the system-assigned identity.
+	// +optional
+	ManagedIdentityClientID string `json:"managedIdentityClientID,omitempty"`
+
+	// Credentials is a reference to a key of a secret holding the
+	// credentials of a service principal to use for the Azure APIs. The key
+	// holds the JSON object printed by 'az ad sp create-for-rbac', with an
+	// appId, a password and a tenant. If omitted, the workload or managed
+	// identity of the operator will be used.
+	// +optional
+	Credentials *corev1.SecretKeySelector `json:"credentials,omitempty"`
+
+	// SSH configures users created on the instances for logging in over
+	// SSH. Instances are recreated when the users or their keys change.
+	// +optional
+	SSH *CloudConfigSSH `json:"ssh,omitempty"`
+}
+
+// AzureImageReference is a reference to a marketplace image.
+type AzureImageReference struct {
+	// Publisher is the publisher of the image.
+	// +kubebuilder:validation:Required
+	Publisher string `json:"publisher"`
+
+	// Offer is the offer of the image.
+	// +kubebuilder:validation:Required
+	Offer string `json:"offer"`
+
+	// SKU is the SKU of the image.
+	// +kubebuilder:validation:Required
+	SKU string `json:"sku"`
+
+	// Version is the version of the image.
+	// +kubebuilder:default:="latest"
+	// +optional
+	Version string `json:"version,omitempty"`
+}
+
+// DefaultAzureImage is the image Azure virtual machines are created from by
+// default.
+var DefaultAzureImage = AzureImageReference{
+	Publisher: "Canonical",
+	Offer:     "0001-com-ubuntu-server-jammy",
+	SKU:       "22_04-lts-gen2",
+	Version:   "latest",
+}
+
+// Default sets default values for any unset fields.
+func (c *NodeGroupAzureConfig) Default() {
+	if c.VirtualNetworkResourceGroup == "" {
+		c.VirtualNetworkResourceGroup = c.ResourceGroup
+	}
+	if c.Image == nil {
+		image := DefaultAzureImage
+		c.Image = &image
+	} else if c.Image.Version == "" {
+		c.Image.Version = "latest"
 	}
-	if c.Zone == "" {
-		return field.Invalid(path.Child("zone"), c.Zone, "zone is required")
+}
+
+// SubnetID returns the resource ID of the subnet of the virtual machines.
+func (c *NodeGroupAzureConfig) SubnetID() string {
+	resourceGroup := c.VirtualNetworkResourceGroup
+	if resourceGroup == "" {
+		resourceGroup = c.ResourceGroup
 	}
-	if c.MachineType == "" {
-		return field.Invalid(path.Child("machineType"), c.MachineType, "machineType is required")
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s",
+		c.SubscriptionID, resourceGroup, c.VirtualNetwork, c.Subnet)
+}
+
+// PublicIPAddress returns true if the virtual machines are given a public
+// IPv4 address.
+func (c *NodeGroupAzureConfig) PublicIPAddress() bool {
+	return c.EnablePublicIPAddress == nil || *c.EnablePublicIPAddress
+}
+
+func (c *NodeGroupAzureConfig) Validate(path *field.Path) error {
+	if err := c.SSH.Validate(path.Child("ssh")); err != nil {
+		return err
+	}
+	required := []struct {
+		name, value string
+	}{
+		{"subscriptionID", c.SubscriptionID},
+		{"resourceGroup", c.ResourceGroup},
+		{"location", c.Location},
+		{"virtualNetwork", c.VirtualNetwork},
+		{"subnet", c.Subnet},
+		{"vmSize", c.VMSize},
+	}
+	for _, f := range required {
+		if f.value == "" {
+			return field.Required(path.Child(f.name), f.name+" is required")
+		}
+	}
+	if c.Image != nil && (c.Image.Publisher == "" || c.Image.Offer == "" || c.Image.SKU == "") {
+		return field.Invalid(path.Child("image"), c.Image, "publisher, offer and sku are required")
+	}
+	if c.OSFamily.ContainerLinux() && (c.Image == nil || (c.Image.Publisher == DefaultAzureImage.Publisher && c.Image.Offer == DefaultAzureImage.Offer)) {
+		return field.Required(path.Child("image"), fmt.Sprintf("an image is required for %s virtual machines", c.OSFamily))
+	}
+	for key := range c.Tags {
+		if strings.HasPrefix(key, "webmesh.io-") {
+			return field.Invalid(path.Child("tags").Key(key), c.Tags[key], "tag is reserved")
+		}
+	}
+	return nil
+}
+
+// NodeGroupDigitalOceanConfig defines the desired configurations for a node
+// group running on DigitalOcean droplets.
+type NodeGroupDigitalOceanConfig struct {
+	// Region is the slug of the region to create the droplets in.
+	// +kubebuilder:validation:Required
+	Region string `json:"region"`
+
+	// Size is the slug of the size of the droplets.
+	// +kubebuilder:default:="s-1vcpu-1gb"
+	// +optional
+	Size string `json:"size,omitempty"`
+
+	// Image is the slug of the image to create the droplets from.
+	// +kubebuilder:default:="ubuntu-22-04-x64"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// OSFamily is the family of the operating system of the image. Flatcar
+	// and Fedora CoreOS droplets are configured with Ignition and need a
+	// custom image. Defaults to ubuntu.
+	// +optional
+	OSFamily OSFamily `json:"osFamily,omitempty"`
+
+	// VPCUUID is the UUID of the VPC to create the droplets in. Defaults to
+	// the default VPC of the region.
+	// +optional
+	VPCUUID string `json:"vpcUUID,omitempty"`
+
+	// Tags are additional tags of the droplets. Tags starting with webmesh
+	// are set by the operator.
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+
+	// Token is a reference to a key of a secret holding the API token to
+	// use for the DigitalOcean API.
+	// +kubebuilder:validation:Required
+	Token *corev1.SecretKeySelector `json:"token"`
+
+	// SSH configures users created on the instances for logging in over
+	// SSH. Instances are recreated when the users or their keys change.
+	// +optional
+	SSH *CloudConfigSSH `json:"ssh,omitempty"`
+}
+
+const (
+	// DefaultDigitalOceanSize is the size of DigitalOcean droplets by default.
+	DefaultDigitalOceanSize = "s-1vcpu-1gb"
+	// DefaultDigitalOceanImage is the image DigitalOcean droplets are created
+	// from by default.
+	DefaultDigitalOceanImage = "ubuntu-22-04-x64"
+)
+
+// digitalOceanTagRegex matches the tags accepted by the DigitalOcean API.
+var digitalOceanTagRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-:]{1,255}$`)
+
+// Default sets default values for any unset fields.
+func (c *NodeGroupDigitalOceanConfig) Default() {
+	if c.Size == "" {
+		c.Size = DefaultDigitalOceanSize
+	}
+	if c.Image == "" {
+		c.Image = DefaultDigitalOceanImage
+	}
+}
+
+func (c *NodeGroupDigitalOceanConfig) Validate(path *field.Path) error {
+	if err := c.SSH.Validate(path.Child("ssh")); err != nil {
+		return err
+	}
+	if c.Region == "" {
+		return field.Required(path.Child("region"), "region is required")
+	}
+	if c.Token == nil || c.Token.Name == "" || c.Token.Key == "" {
+		return field.Required(path.Child("token"), "a secret key holding the API token is required")
+	}
+	if c.OSFamily.ContainerLinux() && (c.Image == "" || c.Image == DefaultDigitalOceanImage) {
+		return field.Required(path.Child("image"), fmt.Sprintf("a custom image is required for %s droplets", c.OSFamily))
+	}
+	for i, tag := range c.Tags {
+		if !digitalOceanTagRegex.MatchString(tag) {
+			return field.Invalid(path.Child("tags").Index(i), tag, "must consist of letters, numbers, colons, dashes and underscores")
+		}
+		if strings.HasPrefix(tag, "webmesh") {
+			return field.Invalid(path.Child("tags").Index(i), tag, "tag is reserved")
+		}
+	}
+	return nil
+}
+
+// NodeGroupHetznerConfig defines the desired configurations for a node group
+// running on Hetzner Cloud servers.
+type NodeGroupHetznerConfig struct {
+	// Location is the name of the location to create the servers in.
+	// +kubebuilder:validation:Required
+	Location string `json:"location"`
+
+	// ServerType is the name of the type of the servers.
+	// +kubebuilder:default:="cx22"
+	// +optional
+	ServerType string `json:"serverType,omitempty"`
+
+	// Image is the name of the image to create the servers from.
+	// +kubebuilder:default:="ubuntu-22.04"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// OSFamily is the family of the operating system of the image. Flatcar
+	// and Fedora CoreOS servers are configured with Ignition and need a
+	// snapshot of an installed system. Defaults to ubuntu.
+	// +optional
+	OSFamily OSFamily `json:"osFamily,omitempty"`
+
+	// NetworkID is the ID of a private network to attach the servers to.
+	// +optional
+	NetworkID int64 `json:"networkID,omitempty"`
+
+	// EnablePublicIPv4 is whether the servers are given a public IPv4
+	// address. Servers without one are reachable over IPv6 and from within
+	// the private network. Servers are recreated when it changes. Defaults
+	// to true.
+	// +optional
+	EnablePublicIPv4 *bool `json:"enablePublicIPv4,omitempty"`
+
+	// SSHKeys are the names of SSH keys of the project to authorize for the
+	// root user of the servers. Without any, Hetzner emails a root password
+	// for each server created.
+	// +optional
+	SSHKeys []string `json:"sshKeys,omitempty"`
+
+	// Token is a reference to a key of a secret holding the API token to
+	// use for the Hetzner Cloud API.
+	// +kubebuilder:validation:Required
+	Token *corev1.SecretKeySelector `json:"token"`
+
+	// SSH configures users created on the instances for logging in over
+	// SSH. Instances are recreated when the users or their keys change.
+	// +optional
+	SSH *CloudConfigSSH `json:"ssh,omitempty"`
+}
+
+const (
+	// DefaultHetznerServerType is the type of Hetzner Cloud servers by
+	// default.
+	DefaultHetznerServerType = "cx22"
+	// DefaultHetznerImage is the image Hetzner Cloud servers are created from
+	// by default.
+	DefaultHetznerImage = "ubuntu-22.04"
+)
+
+// Default sets default values for any unset fields.
+func (c *NodeGroupHetznerConfig) Default() {
+	if c.ServerType == "" {
+		c.ServerType = DefaultHetznerServerType
+	}
+	if c.Image == "" {
+		c.Image = DefaultHetznerImage
+	}
+}
+
+// PublicIPv4 returns true if the servers are given a public IPv4 address.
+func (c *NodeGroupHetznerConfig) PublicIPv4() bool {
+	return c.EnablePublicIPv4 == nil || *c.EnablePublicIPv4
+}
+
+func (c *NodeGroupHetznerConfig) Validate(path *field.Path) error {
+	if err := c.SSH.Validate(path.Child("ssh")); err != nil {
+		return err
+	}
+	if c.Location == "" {
+		return field.Required(path.Child("location"), "location is required")
+	}
+	if c.Token == nil || c.Token.Name == "" || c.Token.Key == "" {
+		return field.Required(path.Child("token"), "a secret key holding the API token is required")
+	}
+	if c.NetworkID < 0 {
+		return field.Invalid(path.Child("networkID"), c.NetworkID, "must be a positive ID")
+	}
+	if c.OSFamily.ContainerLinux() && (c.Image == "" || c.Image == DefaultHetznerImage) {
+		return field.Required(path.Child("image"), fmt.Sprintf("a snapshot is required for %s servers", c.OSFamily))
+	}
+	return nil
+}
+
+// NodeGroupBareMetalConfig defines the desired configurations for a node group
+// running on existing machines. The operator connects to each host over SSH,
+// writes the node config and TLS material, and runs the node as a systemd unit
+// in a docker container. Docker must already be installed on the hosts.
+type NodeGroupBareMetalConfig struct {
+	// Hosts are the addresses of the machines, optionally with the port of
+	// their SSH server. The node on the host at each index uses the
+	// certificate of the node with that index, so hosts may only be
+	// appended or removed from the end of the list.
+	// +kubebuilder:validation:MinItems:=1
+	Hosts []string `json:"hosts"`
+
+	// User is the user to connect as. Users other than root must be able to
+	// run commands with sudo without a password.
+	// +kubebuilder:default:="root"
+	// +optional
+	User string `json:"user,omitempty"`
+
+	// CredentialsSecret is the name of a secret of type kubernetes.io/ssh-auth
+	// holding the private key to connect with. The hosts are verified against
+	// the known_hosts key of the secret.
+	// +kubebuilder:validation:Required
+	CredentialsSecret string `json:"credentialsSecret"`
+
+	// InsecureSkipHostKeyVerification connects to the hosts without verifying
+	// their host keys when the credentials secret has no known_hosts key.
+	// +optional
+	InsecureSkipHostKeyVerification bool `json:"insecureSkipHostKeyVerification,omitempty"`
+}
+
+const (
+	// DefaultBareMetalUser is the user bare metal hosts are connected to as
+	// by default.
+	DefaultBareMetalUser = "root"
+	// DefaultBareMetalSSHPort is the port of the SSH server of bare metal
+	// hosts that do not set one.
+	DefaultBareMetalSSHPort = 22
+)
+
+// Default sets default values for any unset fields.
+func (c *NodeGroupBareMetalConfig) Default() {
+	if c.User == "" {
+		c.User = DefaultBareMetalUser
+	}
+}
+
+func (c *NodeGroupBareMetalConfig) Validate(path *field.Path) error {
+	if len(c.Hosts) == 0 {
+		return field.Required(path.Child("hosts"), "at least one host is required")
+	}
+	seen := make(map[string]struct{}, len(c.Hosts))
+	for i, host := range c.Hosts {
+		addr := BareMetalHostAddress(host)
+		hostname, _, err := net.SplitHostPort(addr)
+		if err != nil || hostname == "" {
+			return field.Invalid(path.Child("hosts").Index(i), host, "must be a host optionally followed by a port")
+		}
+		if _, ok := seen[addr]; ok {
+			return field.Duplicate(path.Child("hosts").Index(i), host)
+		}
+		seen[addr] = struct{}{}
+	}
+	if c.CredentialsSecret == "" {
+		return field.Required(path.Child("credentialsSecret"), "a secret holding the SSH private key is required")
 	}
 	return nil
 }
 
+// BareMetalHostAddress returns the address of the SSH server of a bare metal
+// host, adding the default port if it has none.
+func BareMetalHostAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(DefaultBareMetalSSHPort))
+}
+
 // NodeGroupStatus defines the observed state of NodeGroup
 type NodeGroupStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// DefaultGateway is true if the group is currently advertising a
+	// default route to the mesh.
+	// +optional
+	DefaultGateway bool `json:"defaultGateway,omitempty"`
+
+	// Schedule is the state of the group's schedule, if it has one.
+	// +optional
+	Schedule *NodeGroupScheduleStatus `json:"schedule,omitempty"`
+
+	// Certificates is the observed state of the certificates issued to
+	// each node in the group.
+	// +optional
+	Certificates []NodeCertificateStatus `json:"certificates,omitempty"`
+
+	// Conditions are the current conditions of the node group.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	ReconcileStatus `json:",inline"`
+
+	// EffectiveConfig is the group's Config merged with the config group
+	// it references, as last rendered for its nodes.
+	// +optional
+	EffectiveConfig *NodeGroupConfig `json:"effectiveConfig,omitempty"`
+
+	// LoadBalancerAddresses are the external addresses of the group's
+	// service, included in the certificates of its nodes.
+	// +optional
+	LoadBalancerAddresses []string `json:"loadBalancerAddresses,omitempty"`
+
+	// ReplicaAddresses are the external addresses of the service of each
+	// replica when the group is exposed per replica.
+	// +optional
+	ReplicaAddresses []NodeReplicaAddresses `json:"replicaAddresses,omitempty"`
+
+	// Hosts is the observed state of the hosts of a bare metal group.
+	// +optional
+	Hosts []NodeGroupHostStatus `json:"hosts,omitempty"`
+
+	// AcceptedConfigChecksums maps the config checksums of the group to the
+	// checksums its nodes were deployed with when they were accepted
+	// through the accept-config-checksum annotation.
+	// +optional
+	AcceptedConfigChecksums map[string]string `json:"acceptedConfigChecksums,omitempty"`
+
+	// ReissuedCertificateNames maps the node certificates that were reissued
+	// with new DNS names to those names. Rollouts of the group are held while
+	// a certificate lacks any of its node's names, and the recorded names are
+	// part of the node config checksum, so nodes restart once the certificate
+	// is reissued.
+	// +optional
+	ReissuedCertificateNames map[string][]string `json:"reissuedCertificateNames,omitempty"`
+
+	// Instances are the names of the cloud instances of the group last seen
+	// to exist. Instances that disappear, such as preempted spot instances,
+	// are recreated with an event.
+	// +optional
+	Instances []string `json:"instances,omitempty"`
+
+	// InstanceDetails are the addresses and state of the cloud instance of
+	// each replica, as last seen by the operator.
+	// +optional
+	InstanceDetails []NodeGroupInstanceStatus `json:"instanceDetails,omitempty"`
+
+	// ReservedAddresses are the names of the static addresses the operator
+	// reserved for the group. They are released once no longer used.
+	// +optional
+	ReservedAddresses []string `json:"reservedAddresses,omitempty"`
+
+	// Maintenance lists the replicas that are cordoned from the mesh through
+	// the maintenance annotation.
+	// +optional
+	Maintenance []NodeMaintenanceStatus `json:"maintenance,omitempty"`
+
+	// JoinServer is the address the nodes of the group were last configured
+	// to join the mesh through. Unless probes are disabled in the operator
+	// config, it answered a TLS handshake when it was chosen.
+	// +optional
+	JoinServer string `json:"joinServer,omitempty"`
+
+	// PreflightGeneration is the generation of the group whose objects last
+	// passed the pre-flight checks. The checks only run again once the
+	// generation or PreflightChecksum changes.
+	// +optional
+	PreflightGeneration int64 `json:"preflightGeneration,omitempty"`
+
+	// PreflightChecksum is the checksum of the objects rendered for the group
+	// when they last passed the pre-flight checks. It changes with the mesh
+	// and the operator settings, not only the group.
+	// +optional
+	PreflightChecksum string `json:"preflightChecksum,omitempty"`
+}
+
+const (
+	// NodesFailedCondition is the condition type set on node groups when
+	// node containers exited with an error and have not been running since.
+	NodesFailedCondition = "NodesFailed"
+	// PreflightFailedCondition is the condition type set on node groups when
+	// their objects would not be admitted by the cluster, such as when they
+	// exceed the resource quotas of the namespace.
+	PreflightFailedCondition = "PreflightFailed"
+	// CapacityPendingCondition is the condition type set on node groups when
+	// pods of the group could not be scheduled for longer than a grace
+	// period, such as while the cluster autoscaler adds nodes.
+	CapacityPendingCondition = "CapacityPending"
+	// SelectorImmutableCondition is the condition type set on node groups
+	// when their StatefulSet selects its pods differently than the operator
+	// would and cannot be updated.
+	SelectorImmutableCondition = "SelectorImmutable"
+	// CertificatesPreRotatingCondition is the condition type set on node
+	// groups while their node certificates are renewed before a planned
+	// upgrade is applied.
+	CertificatesPreRotatingCondition = "CertificatesPreRotating"
+	// PodSecurityRestrictedCondition is the condition type set on cluster
+	// node groups when the pod security level enforced on their namespace
+	// does not admit their pods.
+	PodSecurityRestrictedCondition = "PSARestricted"
+	// NodeTLSInjectionFailedCondition is the condition type set on cluster
+	// node groups when node pods were created without their certificate
+	// secret, because the node pod webhook is not installed or was not
+	// called.
+	NodeTLSInjectionFailedCondition = "NodeTLSInjectionFailed"
+)
+
+// NodeGroupHostStatus is the observed state of a bare metal host.
+type NodeGroupHostStatus struct {
+	// Address is the address of the host.
+	Address string `json:"address"`
+
+	// ConfigChecksum is the checksum of the config the host was last
+	// provisioned with.
+	// +optional
+	ConfigChecksum string `json:"configChecksum,omitempty"`
+
+	// LastError is the error of the last attempt to provision the host, if
+	// it failed.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// Failures is the number of consecutive failed attempts to provision
+	// the host. Failed hosts are retried with an exponential backoff.
+	// +optional
+	Failures int32 `json:"failures,omitempty"`
+
+	// LastAttemptTime is the time the host was last provisioned.
+	// +optional
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+}
+
+// NodeMaintenanceStatus is the state of a replica cordoned from the mesh.
+type NodeMaintenanceStatus struct {
+	// Ordinal is the ordinal of the replica.
+	Ordinal int32 `json:"ordinal"`
+
+	// NodeID is the ID of the replica's node in the mesh.
+	NodeID string `json:"nodeID"`
+
+	// Since is the time the replica was cordoned.
+	Since metav1.Time `json:"since"`
+}
+
+// NodeCertificateStatus is the observed state of a node's certificate.
+type NodeCertificateStatus struct {
+	// Name is the name of the certificate.
+	Name string `json:"name"`
+
+	// NotAfter is the time at which the certificate expires.
+	NotAfter metav1.Time `json:"notAfter"`
+
+	// DaysRemaining is the number of whole days until the certificate expires.
+	DaysRemaining int32 `json:"daysRemaining"`
+}
+
+// NodeGroupInstanceStatus is the observed state of the cloud instance of a
+// replica.
+type NodeGroupInstanceStatus struct {
+	// Ordinal is the ordinal of the replica.
+	Ordinal int32 `json:"ordinal"`
+
+	// Name is the name of the instance.
+	Name string `json:"name"`
+
+	// Zone is the zone of the instance.
+	// +optional
+	Zone string `json:"zone,omitempty"`
+
+	// Status is the status of the instance reported by the cloud provider,
+	// such as RUNNING or TERMINATED.
+	// +optional
+	Status string `json:"status,omitempty"`
+
+	// InternalIPv4 is the internal IPv4 address of the instance.
+	// +optional
+	InternalIPv4 string `json:"internalIPv4,omitempty"`
+
+	// InternalIPv6 is the internal IPv6 address of the instance.
+	// +optional
+	InternalIPv6 string `json:"internalIPv6,omitempty"`
+
+	// ExternalIPv4 is the external IPv4 address of the instance.
+	// +optional
+	ExternalIPv4 string `json:"externalIPv4,omitempty"`
+
+	// ExternalIPv6 is the external IPv6 address of the instance.
+	// +optional
+	ExternalIPv6 string `json:"externalIPv6,omitempty"`
+}
+
+// NodeReplicaAddresses are the external addresses of the service of a
+// single replica.
+type NodeReplicaAddresses struct {
+	// Ordinal is the ordinal of the replica.
+	Ordinal int32 `json:"ordinal"`
+
+	// Addresses are the external addresses of the replica's service.
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Ready is true once the replica's service has been assigned addresses.
+	Ready bool `json:"ready"`
 }
 
 //+kubebuilder:object:root=true
@@ -306,6 +2635,159 @@ type NodeGroup struct {
 	Status NodeGroupStatus `json:"status,omitempty"`
 }
 
+// Replicas returns the number of replicas for the group. Groups that
+// have not been defaulted yet are treated as having a single replica.
+func (n *NodeGroup) Replicas() int32 {
+	if n.Spec.BareMetal != nil {
+		return int32(len(n.Spec.BareMetal.Hosts))
+	}
+	if n.Spec.Replicas == nil {
+		return 1
+	}
+	return *n.Spec.Replicas
+}
+
+// MaintenanceOrdinals returns the sorted ordinals of the replicas listed in
+// the maintenance annotation of the group.
+func (n *NodeGroup) MaintenanceOrdinals() ([]int32, error) {
+	value := strings.TrimSpace(n.GetAnnotations()[MaintenanceAnnotation])
+	if value == "" {
+		return nil, nil
+	}
+	seen := make(map[int32]struct{})
+	var ordinals []int32
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		ordinal, err := strconv.ParseInt(field, 10, 32)
+		if err != nil || ordinal < 0 {
+			return nil, fmt.Errorf("invalid replica ordinal %q", field)
+		}
+		if _, ok := seen[int32(ordinal)]; ok {
+			continue
+		}
+		seen[int32(ordinal)] = struct{}{}
+		ordinals = append(ordinals, int32(ordinal))
+	}
+	sort.Slice(ordinals, func(i, j int) bool { return ordinals[i] < ordinals[j] })
+	return ordinals, nil
+}
+
+// PreRotationThreshold returns the remaining lifetime below which the node
+// certificates of the group are renewed before its changes are applied, and
+// whether the group has the pre-rotation annotation.
+func (n *NodeGroup) PreRotationThreshold() (time.Duration, bool, error) {
+	value, ok := n.GetAnnotations()[PreRotateCertificatesAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	value = strings.TrimSpace(value)
+	if value == "true" {
+		return DefaultPreRotationThreshold, true, nil
+	}
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold <= 0 {
+		return 0, true, fmt.Errorf(`must be "true" or a positive duration`)
+	}
+	return threshold, true, nil
+}
+
+// adoptExisting returns the existing objects the group adopts, or nil if it
+// creates its own.
+func (n *NodeGroup) adoptExisting() *NodeGroupAdoptConfig {
+	if n.Spec.Cluster == nil {
+		return nil
+	}
+	return n.Spec.Cluster.AdoptExisting
+}
+
+// ExposedPerReplica returns true if each replica of the group is exposed
+// through its own LoadBalancer service.
+func (n *NodeGroup) ExposedPerReplica() bool {
+	return n.Spec.Cluster != nil && n.Spec.Cluster.Service != nil && n.Spec.Cluster.Service.PerReplica
+}
+
+// LoadBalancerSANs returns the subject alternative names clients reaching the
+// group through its service may verify: the external addresses of the service
+// recorded in the status and the extra names of the service spec.
+func (n *NodeGroup) LoadBalancerSANs() (dnsNames, ipAddresses []string) {
+	if n.Spec.Cluster == nil || n.Spec.Cluster.Service == nil {
+		return nil, nil
+	}
+	svc := n.Spec.Cluster.Service
+	seen := make(map[string]struct{})
+	add := func(san string) {
+		if _, ok := seen[san]; ok {
+			return
+		}
+		seen[san] = struct{}{}
+		if net.ParseIP(san) != nil {
+			ipAddresses = append(ipAddresses, san)
+		} else {
+			dnsNames = append(dnsNames, san)
+		}
+	}
+	for _, addr := range n.Status.LoadBalancerAddresses {
+		add(addr)
+	}
+	for _, name := range svc.ExtraDNSNames {
+		add(name)
+	}
+	for _, addr := range svc.ExtraIPAddresses {
+		add(addr)
+	}
+	return dnsNames, ipAddresses
+}
+
+// MeshKey returns the key of the Mesh this group belongs to. The group's
+// namespace is used if the reference does not specify one.
+func (n *NodeGroup) MeshKey() types.NamespacedName {
+	key := types.NamespacedName{
+		Name:      n.Spec.Mesh.Name,
+		Namespace: n.Spec.Mesh.Namespace,
+	}
+	if key.Namespace == "" {
+		key.Namespace = n.GetNamespace()
+	}
+	return key
+}
+
+// MergedConfig returns the configuration for the group with the config
+// group it references from the given Mesh merged in. The group's own
+// Config takes precedence. Neither the group nor the mesh are modified.
+func (n *NodeGroup) MergedConfig(mesh *Mesh) (*NodeGroupConfig, error) {
+	if n.Spec.ConfigGroup == "" {
+		return n.Spec.Config.Merge(nil), nil
+	}
+	configGroup, ok := mesh.Spec.ConfigGroups[n.Spec.ConfigGroup]
+	if !ok {
+		return nil, fmt.Errorf("config group %s not found", n.Spec.ConfigGroup)
+	}
+	return configGroup.Merge(n.Spec.Config), nil
+}
+
+// RaftMember returns true if the nodes of the group take part in Raft, either
+// as voters or as observers. Only members listen on the Raft port, other nodes
+// reach the mesh state through the gRPC API. Groups whose config cannot be
+// merged are assumed to be members.
+func (n *NodeGroup) RaftMember(mesh *Mesh) bool {
+	if n.GetAnnotations()[BootstrapNodeGroupAnnotation] == "true" {
+		return true
+	}
+	switch n.Spec.Profile {
+	case NodeGroupProfileDNS, NodeGroupProfileRelay:
+		// Nodes of these profiles request to be observers
+		return true
+	}
+	cfg, err := n.MergedConfig(mesh)
+	if err != nil {
+		return true
+	}
+	return cfg.Voter
+}
+
 //+kubebuilder:object:root=true
 
 // NodeGroupList contains a list of NodeGroup
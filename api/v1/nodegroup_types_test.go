@@ -0,0 +1,1010 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeGroupProviders(t *testing.T) {
+	aws := func() *NodeGroupAWSConfig {
+		return &NodeGroupAWSConfig{
+			Region:       "us-east-1",
+			SubnetID:     "subnet-0123",
+			InstanceType: "t3.small",
+		}
+	}
+	azure := func() *NodeGroupAzureConfig {
+		return &NodeGroupAzureConfig{
+			SubscriptionID: "00000000-0000-0000-0000-000000000000",
+			ResourceGroup:  "webmesh",
+			Location:       "eastus",
+			VirtualNetwork: "vnet",
+			Subnet:         "default",
+			VMSize:         "Standard_B1s",
+		}
+	}
+	google := func() *NodeGroupGoogleCloudConfig {
+		return &NodeGroupGoogleCloudConfig{
+			ProjectID:   "project",
+			Subnetwork:  "default",
+			Zone:        "us-central1-a",
+			MachineType: "e2-small",
+		}
+	}
+	tc := []struct {
+		name string
+		spec NodeGroupSpec
+		err  bool
+	}{
+		{
+			name: "aws",
+			spec: NodeGroupSpec{AWS: aws()},
+		},
+		{
+			name: "google cloud with an image",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.Image = "projects/golden/global/images/router-v2"
+				return c
+			}()},
+		},
+		{
+			name: "google cloud with an image family",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ImageFamily = "router"
+				c.ImageProject = "golden"
+				return c
+			}()},
+		},
+		{
+			name: "google cloud with an image and image family",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.Image = "projects/golden/global/images/router-v2"
+				c.ImageFamily = "router"
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud with a boot disk",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				size := int64(50)
+				c.DiskSizeGB = &size
+				c.DiskType = GoogleCloudDiskTypeSSD
+				return c
+			}()},
+		},
+		{
+			name: "google cloud with a small boot disk",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				size := int64(5)
+				c.DiskSizeGB = &size
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud with an unknown disk type",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.DiskType = "hyperdisk-extreme"
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud with scopes and no service account",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.Scopes = []string{"https://www.googleapis.com/auth/devstorage.read_only"}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud ipv4 only instances with ipv6",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.StackType = GoogleCloudStackTypeIPv4Only
+				ipv6 := true
+				c.EnableIPv6 = &ipv6
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud ipv6 only internal only instances",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.StackType = GoogleCloudStackTypeIPv6Only
+				c.InternalOnly = true
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud internal only instances",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.InternalOnly = true
+				return c
+			}()},
+		},
+		{
+			name: "google cloud internal only instances with static addresses",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.InternalOnly = true
+				c.StaticIPs = true
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud internal only instances with remote detection",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.InternalOnly = true
+				remote := true
+				c.AllowRemoteDetection = &remote
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud spot instances",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ProvisioningModel = GoogleCloudProvisioningModelSpot
+				return c
+			}()},
+		},
+		{
+			name: "google cloud spot instances restarted automatically",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ProvisioningModel = GoogleCloudProvisioningModelSpot
+				restart := true
+				c.AutomaticRestart = &restart
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud spot instances migrated on maintenance",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ProvisioningModel = GoogleCloudProvisioningModelSpot
+				c.OnHostMaintenance = GoogleCloudOnHostMaintenanceMigrate
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud addresses without an external address",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.Addresses = []string{"router-0"}
+				external := false
+				c.EnableExternalIPv4 = &external
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud external account impersonating a service account",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ExternalAccount = &GoogleCloudExternalAccount{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "federation"},
+						Key:                  "credentials.json",
+					},
+				}
+				c.ImpersonateServiceAccount = "router@demo.iam.gserviceaccount.com"
+				return c
+			}()},
+		},
+		{
+			name: "google cloud external account and credentials",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.Credentials = &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "gcp"},
+					Key:                  "key.json",
+				}
+				c.ExternalAccount = &GoogleCloudExternalAccount{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "federation"},
+						Key:                  "credentials.json",
+					},
+				}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud external account without a source",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ExternalAccount = &GoogleCloudExternalAccount{}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud impersonating an invalid service account",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ImpersonateServiceAccount = "router"
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud extra cloud config",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ExtraFiles = []CloudConfigFile{{Path: "/etc/sysctl.d/99-custom.conf", Content: "vm.swappiness=10"}}
+				c.ExtraPackages = []string{"htop"}
+				c.ExtraRunCmd = []string{"sysctl --system"}
+				return c
+			}()},
+		},
+		{
+			name: "google cloud extra file replacing the node config",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ExtraFiles = []CloudConfigFile{{Path: "/etc/webmesh/config.yaml"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud extra file replacing the docker config",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ExtraFiles = []CloudConfigFile{{Path: "/etc/docker/daemon.json"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud file secret",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/custom", Mode: "0640"}}
+				return c
+			}()},
+		},
+		{
+			name: "google cloud file secret with a relative path",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "etc/custom"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud file secret replacing the node config",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/webmesh/config.yaml"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud file secret replacing the node unit",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/systemd/system/node.service"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud file secret replacing the node environment",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/webmesh-node.env"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud file secret replacing the secrets refresh script",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/webmesh-secrets-refresh.sh"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud duplicate file secrets",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{
+					{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "a", Path: "/etc/custom"},
+					{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "b", Path: "/etc/custom"},
+				}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud file secret with an invalid mode",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/custom", Mode: "0999"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud extra file duplicating a file secret",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.FileSecrets = []FileSecret{{SecretRef: corev1.LocalObjectReference{Name: "s"}, Key: "k", Path: "/etc/custom"}}
+				c.ExtraFiles = []CloudConfigFile{{Path: "/etc/custom"}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud flatcar",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.OSFamily = OSFamilyFlatcar
+				c.ExtraRunCmd = []string{"sysctl --system"}
+				return c
+			}()},
+		},
+		{
+			name: "google cloud fedora coreos with extra packages",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.OSFamily = OSFamilyFedoraCoreOS
+				c.ExtraPackages = []string{"htop"}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud invalid extra package",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.ExtraPackages = []string{"htop; rm -rf /"}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud ssh users",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SSH = &CloudConfigSSH{Users: []CloudConfigSSHUser{{Name: "ops", AuthorizedKeys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILz/FQBSqHkyp1KY8p67LdmSOp2wvmuyyj8x5b8IsBTx"}, Sudo: true}}}
+				return c
+			}()},
+		},
+		{
+			name: "google cloud ssh root user",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SSH = &CloudConfigSSH{Users: []CloudConfigSSHUser{{Name: "root", AuthorizedKeys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILz/FQBSqHkyp1KY8p67LdmSOp2wvmuyyj8x5b8IsBTx"}}}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud ssh user without keys",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SSH = &CloudConfigSSH{Users: []CloudConfigSSHUser{{Name: "ops"}}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud ssh user with an invalid key",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SSH = &CloudConfigSSH{Users: []CloudConfigSSHUser{{Name: "ops", AuthorizedKeys: []string{"ssh-rsa nope"}}}}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud docker bridge",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.DockerBridgeCIDR = "10.200.0.1/24"
+				return c
+			}()},
+		},
+		{
+			name: "google cloud docker bridge network address",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.DockerBridgeCIDR = "10.200.0.0/24"
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud IPv6 docker bridge",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.DockerBridgeCIDR = "fd00::1/64"
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud managed instance group",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.UseManagedInstanceGroup = true
+				return c
+			}()},
+		},
+		{
+			name: "google cloud managed instance group with static addresses",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.UseManagedInstanceGroup = true
+				c.StaticIPs = true
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud in place certificate renewal",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.CertificateRenewal = GoogleCloudCertificateRenewalInPlace
+				return c
+			}()},
+		},
+		{
+			name: "google cloud managed instance group with in place certificate renewal",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.UseManagedInstanceGroup = true
+				c.CertificateRenewal = GoogleCloudCertificateRenewalInPlace
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud secret manager delivery",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SecretDelivery = GoogleCloudSecretDeliverySecretManager
+				c.ServiceAccountEmail = "node@project.iam.gserviceaccount.com"
+				return c
+			}()},
+		},
+		{
+			name: "google cloud secret manager delivery without service account",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SecretDelivery = GoogleCloudSecretDeliverySecretManager
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud secret manager delivery with narrow scopes",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SecretDelivery = GoogleCloudSecretDeliverySecretManager
+				c.ServiceAccountEmail = "node@project.iam.gserviceaccount.com"
+				c.Scopes = []string{"https://www.googleapis.com/auth/logging.write"}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "google cloud secret manager delivery with in place certificate renewal",
+			spec: NodeGroupSpec{GoogleCloud: func() *NodeGroupGoogleCloudConfig {
+				c := google()
+				c.SecretDelivery = GoogleCloudSecretDeliverySecretManager
+				c.ServiceAccountEmail = "node@project.iam.gserviceaccount.com"
+				c.CertificateRenewal = GoogleCloudCertificateRenewalInPlace
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "aws and cluster",
+			spec: NodeGroupSpec{AWS: aws(), Cluster: &NodeGroupClusterConfig{}},
+			err:  true,
+		},
+		{
+			name: "aws and google cloud",
+			spec: NodeGroupSpec{AWS: aws(), GoogleCloud: google()},
+			err:  true,
+		},
+		{
+			name: "aws with a reserved tag",
+			spec: NodeGroupSpec{AWS: func() *NodeGroupAWSConfig {
+				c := aws()
+				c.Tags = map[string]string{"Name": "router"}
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "aws with an invalid subnet",
+			spec: NodeGroupSpec{AWS: func() *NodeGroupAWSConfig {
+				c := aws()
+				c.SubnetID = "default"
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "azure",
+			spec: NodeGroupSpec{Azure: azure()},
+		},
+		{
+			name: "azure and aws",
+			spec: NodeGroupSpec{Azure: azure(), AWS: aws()},
+			err:  true,
+		},
+		{
+			name: "azure without a vm size",
+			spec: NodeGroupSpec{Azure: func() *NodeGroupAzureConfig {
+				c := azure()
+				c.VMSize = ""
+				return c
+			}()},
+			err: true,
+		},
+		{
+			name: "digitalocean",
+			spec: NodeGroupSpec{DigitalOcean: &NodeGroupDigitalOceanConfig{
+				Region: "nyc3",
+				Token:  &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "do"}, Key: "token"},
+			}},
+		},
+		{
+			name: "digitalocean without a token",
+			spec: NodeGroupSpec{DigitalOcean: &NodeGroupDigitalOceanConfig{Region: "nyc3"}},
+			err:  true,
+		},
+		{
+			name: "digitalocean without a region",
+			spec: NodeGroupSpec{DigitalOcean: &NodeGroupDigitalOceanConfig{
+				Token: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "do"}, Key: "token"},
+			}},
+			err: true,
+		},
+		{
+			name: "digitalocean with an invalid tag",
+			spec: NodeGroupSpec{DigitalOcean: &NodeGroupDigitalOceanConfig{
+				Region: "nyc3",
+				Tags:   []string{"team/edge"},
+				Token:  &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "do"}, Key: "token"},
+			}},
+			err: true,
+		},
+		{
+			name: "hetzner",
+			spec: NodeGroupSpec{Hetzner: &NodeGroupHetznerConfig{
+				Location: "fsn1",
+				Token:    &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hcloud"}, Key: "token"},
+			}},
+		},
+		{
+			name: "hetzner flatcar from the default image",
+			spec: NodeGroupSpec{Hetzner: &NodeGroupHetznerConfig{
+				Location: "fsn1",
+				OSFamily: OSFamilyFlatcar,
+				Token:    &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hcloud"}, Key: "token"},
+			}},
+			err: true,
+		},
+		{
+			name: "hetzner flatcar from a snapshot",
+			spec: NodeGroupSpec{Hetzner: &NodeGroupHetznerConfig{
+				Location: "fsn1",
+				Image:    "flatcar-stable",
+				OSFamily: OSFamilyFlatcar,
+				Token:    &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hcloud"}, Key: "token"},
+			}},
+		},
+		{
+			name: "hetzner and digitalocean",
+			spec: NodeGroupSpec{
+				Hetzner: &NodeGroupHetznerConfig{
+					Location: "fsn1",
+					Token:    &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hcloud"}, Key: "token"},
+				},
+				DigitalOcean: &NodeGroupDigitalOceanConfig{
+					Region: "nyc3",
+					Token:  &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "do"}, Key: "token"},
+				},
+			},
+			err: true,
+		},
+		{
+			name: "hetzner without a location",
+			spec: NodeGroupSpec{Hetzner: &NodeGroupHetznerConfig{
+				Token: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hcloud"}, Key: "token"},
+			}},
+			err: true,
+		},
+		{
+			name: "hetzner without a token",
+			spec: NodeGroupSpec{Hetzner: &NodeGroupHetznerConfig{Location: "fsn1"}},
+			err:  true,
+		},
+		{
+			name: "bare metal",
+			spec: NodeGroupSpec{BareMetal: &NodeGroupBareMetalConfig{
+				Hosts:             []string{"10.0.0.1", "10.0.0.2:2222", "[2001:db8::1]:22"},
+				CredentialsSecret: "ssh",
+			}},
+		},
+		{
+			name: "bare metal with a duplicate host",
+			spec: NodeGroupSpec{BareMetal: &NodeGroupBareMetalConfig{
+				Hosts:             []string{"10.0.0.1", "10.0.0.1:22"},
+				CredentialsSecret: "ssh",
+			}},
+			err: true,
+		},
+		{
+			name: "bare metal without hosts",
+			spec: NodeGroupSpec{BareMetal: &NodeGroupBareMetalConfig{CredentialsSecret: "ssh"}},
+			err:  true,
+		},
+		{
+			name: "bare metal without a credentials secret",
+			spec: NodeGroupSpec{BareMetal: &NodeGroupBareMetalConfig{Hosts: []string{"10.0.0.1"}}},
+			err:  true,
+		},
+		{
+			name: "azure with a reserved tag",
+			spec: NodeGroupSpec{Azure: func() *NodeGroupAzureConfig {
+				c := azure()
+				c.Tags = map[string]string{"webmesh.io-mesh-name": "mesh"}
+				return c
+			}()},
+			err: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Default()
+			err := tt.spec.Validate()
+			if tt.err && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.err && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+	spec := NodeGroupSpec{AWS: aws()}
+	spec.Default()
+	if spec.Cluster != nil {
+		t.Errorf("expected no cluster config to be defaulted for aws groups")
+	}
+	if spec.AWS.Architecture != AWSArchitectureX86 {
+		t.Errorf("expected the architecture to default to %s, got %q", AWSArchitectureX86, spec.AWS.Architecture)
+	}
+	azureSpec := NodeGroupSpec{Azure: azure()}
+	azureSpec.Default()
+	if azureSpec.Cluster != nil {
+		t.Errorf("expected no cluster config to be defaulted for azure groups")
+	}
+	want := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/webmesh/providers/Microsoft.Network/virtualNetworks/vnet/subnets/default"
+	if got := azureSpec.Azure.SubnetID(); got != want {
+		t.Errorf("expected subnet ID %s, got %s", want, got)
+	}
+	one := int32(1)
+	bareMetal := NodeGroup{Spec: NodeGroupSpec{
+		Replicas: &one,
+		BareMetal: &NodeGroupBareMetalConfig{
+			Hosts:             []string{"10.0.0.1", "10.0.0.2"},
+			CredentialsSecret: "ssh",
+		},
+	}}
+	bareMetal.Spec.Default()
+	if bareMetal.Spec.BareMetal.User != DefaultBareMetalUser {
+		t.Errorf("expected the user to default to %s, got %q", DefaultBareMetalUser, bareMetal.Spec.BareMetal.User)
+	}
+	if got := bareMetal.Replicas(); got != 2 {
+		t.Errorf("expected a replica for each host, got %d", got)
+	}
+}
+
+func TestNodeGroupDedicatedNodes(t *testing.T) {
+	tc := []struct {
+		name   string
+		config NodeGroupDedicatedNodesConfig
+		err    bool
+	}{
+		{
+			name:   "taint and label",
+			config: NodeGroupDedicatedNodesConfig{TaintKey: "webmesh.io/dedicated", Label: "webmesh.io/pool=storage"},
+		},
+		{
+			name:   "taint only",
+			config: NodeGroupDedicatedNodesConfig{TaintKey: "dedicated"},
+		},
+		{
+			name:   "no taint key",
+			config: NodeGroupDedicatedNodesConfig{Label: "pool=storage"},
+			err:    true,
+		},
+		{
+			name:   "invalid taint key",
+			config: NodeGroupDedicatedNodesConfig{TaintKey: "webmesh.io/dedicated/pool"},
+			err:    true,
+		},
+		{
+			name:   "label without a value",
+			config: NodeGroupDedicatedNodesConfig{TaintKey: "dedicated", Label: "pool"},
+			err:    true,
+		},
+		{
+			name:   "invalid label value",
+			config: NodeGroupDedicatedNodesConfig{TaintKey: "dedicated", Label: "pool=storage nodes"},
+			err:    true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := NodeGroupSpec{Cluster: &NodeGroupClusterConfig{DedicatedNodes: &tt.config}}
+			spec.Default()
+			err := spec.Validate()
+			if tt.err && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.err && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+	config := NodeGroupDedicatedNodesConfig{TaintKey: "dedicated", Label: "webmesh.io/pool=storage"}
+	if got := config.NodeSelector(); len(got) != 1 || got["webmesh.io/pool"] != "storage" {
+		t.Errorf("expected node selector for the label, got %v", got)
+	}
+}
+
+func TestNodeGroupResourceRequirements(t *testing.T) {
+	quantity := func(s string) resource.Quantity { return resource.MustParse(s) }
+	tc := []struct {
+		name          string
+		config        NodeGroupClusterConfig
+		wantRequested string
+		wantLimit     string
+	}{
+		{
+			name:          "preset",
+			config:        NodeGroupClusterConfig{ResourcePreset: ResourcePresetSmall},
+			wantRequested: "128Mi",
+			wantLimit:     "256Mi",
+		},
+		{
+			name: "request below preset limit",
+			config: NodeGroupClusterConfig{
+				ResourcePreset: ResourcePresetSmall,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: quantity("192Mi")},
+				},
+			},
+			wantRequested: "192Mi",
+			wantLimit:     "256Mi",
+		},
+		{
+			name: "request above preset limit",
+			config: NodeGroupClusterConfig{
+				ResourcePreset: ResourcePresetSmall,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: quantity("1Gi")},
+				},
+			},
+			wantRequested: "1Gi",
+			wantLimit:     "1Gi",
+		},
+		{
+			name: "explicit limit",
+			config: NodeGroupClusterConfig{
+				ResourcePreset: ResourcePresetSmall,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: quantity("1Gi")},
+					Limits:   corev1.ResourceList{corev1.ResourceMemory: quantity("2Gi")},
+				},
+			},
+			wantRequested: "1Gi",
+			wantLimit:     "2Gi",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.ResourceRequirements()
+			if request := got.Requests[corev1.ResourceMemory]; request.Cmp(quantity(tt.wantRequested)) != 0 {
+				t.Errorf("expected memory request %s, got %s", tt.wantRequested, request.String())
+			}
+			if limit := got.Limits[corev1.ResourceMemory]; limit.Cmp(quantity(tt.wantLimit)) != 0 {
+				t.Errorf("expected memory limit %s, got %s", tt.wantLimit, limit.String())
+			}
+			if _, ok := got.Limits[corev1.ResourceCPU]; ok {
+				t.Error("expected CPU to be left unlimited")
+			}
+		})
+	}
+
+	// The preset itself is left untouched
+	config := NodeGroupClusterConfig{
+		ResourcePreset: ResourcePresetSmall,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: quantity("1Gi")},
+		},
+	}
+	config.ResourceRequirements()
+	if limit := ResourcePresetSmall.ResourceRequirements().Limits[corev1.ResourceMemory]; limit.Cmp(quantity("256Mi")) != 0 {
+		t.Errorf("expected the preset limit to be unchanged, got %s", limit.String())
+	}
+}
+
+func TestNodeGroupPreRotationThreshold(t *testing.T) {
+	tc := []struct {
+		name  string
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{name: "no annotation"},
+		{name: "default threshold", value: "true", want: DefaultPreRotationThreshold},
+		{name: "duration", value: "720h", want: 720 * time.Hour},
+		{name: "negative duration", value: "-1h", err: true},
+		{name: "not a duration", value: "yes", err: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &NodeGroup{}
+			if tt.value != "" {
+				group.SetAnnotations(map[string]string{PreRotateCertificatesAnnotation: tt.value})
+			}
+			err := validatePreRotation(group)
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err {
+				return
+			}
+			got, ok, _ := group.PreRotationThreshold()
+			if ok != (tt.value != "") || got != tt.want {
+				t.Errorf("expected threshold %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNodeGroupMaintenanceOrdinals(t *testing.T) {
+	tc := []struct {
+		name    string
+		value   string
+		cluster bool
+		want    []int32
+		err     bool
+	}{
+		{name: "no annotation", cluster: true},
+		{name: "empty", value: " ", cluster: true},
+		{name: "single", value: "2", cluster: true, want: []int32{2}},
+		{name: "sorted and deduplicated", value: "4, 2,4,", cluster: true, want: []int32{2, 4}},
+		{name: "not a number", value: "2,a", cluster: true, err: true},
+		{name: "negative", value: "-1", cluster: true, err: true},
+		{name: "not a cluster group", value: "0", err: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &NodeGroup{}
+			if tt.cluster {
+				group.Spec.Cluster = &NodeGroupClusterConfig{}
+			}
+			if tt.value != "" {
+				group.SetAnnotations(map[string]string{MaintenanceAnnotation: tt.value})
+			}
+			err := validateMaintenance(group)
+			if tt.err && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.err && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err {
+				return
+			}
+			got, err := group.MaintenanceOrdinals()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected ordinals %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNodeGroupGoogleCloudInstanceName(t *testing.T) {
+	tc := []struct {
+		name     string
+		template string
+		replicas int32
+		want     string
+		err      bool
+	}{
+		{name: "default", want: "edge-1"},
+		{name: "template", template: "prd-cc42-{{ .Mesh }}-{{ .Group }}-{{ .Index }}", want: "prd-cc42-corp-edge-1"},
+		{name: "index ignored", template: "prd-{{ .Group }}", err: true},
+		{name: "same name for some replicas", template: `{{ .Group }}-{{ if eq .Index 0 }}a{{ else }}b{{ end }}`, replicas: 3, err: true},
+		{name: "invalid label", template: "{{ .Index }}-{{ .Group }}", err: true},
+		{name: "uppercase", template: "PRD-{{ .Group }}-{{ .Index }}", err: true},
+		{name: "too long for the last replica", template: strings.Repeat("a", 58) + "-{{ .Index }}", replicas: 11, err: true},
+		{name: "unknown variable", template: "{{ .Zone }}-{{ .Index }}", err: true},
+		{name: "invalid template", template: "{{ .Group", err: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+				Spec: NodeGroupSpec{
+					Mesh:        corev1.ObjectReference{Name: "corp"},
+					GoogleCloud: &NodeGroupGoogleCloudConfig{NameTemplate: tt.template},
+				},
+			}
+			if tt.replicas > 0 {
+				group.Spec.Replicas = &tt.replicas
+			}
+			err := validateGoogleCloudNameTemplate(group)
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err {
+				return
+			}
+			got, err := group.GoogleCloudInstanceName(1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected name %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
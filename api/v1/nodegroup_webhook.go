@@ -18,8 +18,15 @@ package v1
 
 import (
 	"context"
+	"fmt"
+	"net"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -30,9 +37,12 @@ import (
 // log is for logging in this package.
 var nodegrouplog = logf.Log.WithName("nodegroup-resource")
 
-func (r *NodeGroup) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// SetupWebhookWithManager registers the webhooks of the type. Unset images are
+// defaulted to the ones returned by images.
+func (r *NodeGroup) SetupWebhookWithManager(mgr ctrl.Manager, images ImagesFunc) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithDefaulter(&nodeGroupDefaulter{images: images}).
 		WithValidator(&nodeGroupValidator{
 			Client: mgr.GetClient(),
 		}).
@@ -41,9 +51,30 @@ func (r *NodeGroup) SetupWebhookWithManager(mgr ctrl.Manager) error {
 
 //+kubebuilder:webhook:path=/mutate-mesh-webmesh-io-v1-nodegroup,mutating=true,failurePolicy=fail,sideEffects=None,groups=mesh.webmesh.io,resources=nodegroups,verbs=create;update,versions=v1,name=mnodegroup.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Defaulter = &NodeGroup{}
+var _ webhook.CustomDefaulter = &nodeGroupDefaulter{}
 
-// Default implements webhook.Defaulter so a webhook will be registered for the type
+// nodeGroupDefaulter defaults NodeGroups with the images in effect when they
+// are admitted.
+type nodeGroupDefaulter struct {
+	images ImagesFunc
+}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *nodeGroupDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	group := obj.(*NodeGroup)
+	if d.images != nil {
+		images, err := d.images(ctx)
+		if err != nil {
+			return fmt.Errorf("resolve default images: %w", err)
+		}
+		group.Spec.DefaultImage(images.Node)
+	}
+	group.Default()
+	return nil
+}
+
+// Default sets the defaults of the group. An unset image is given the
+// built-in default.
 func (r *NodeGroup) Default() {
 	nodegrouplog.Info("defaulting", "name", r.Name)
 	r.Spec.Default()
@@ -64,7 +95,25 @@ func (r *nodeGroupValidator) ValidateCreate(ctx context.Context, obj runtime.Obj
 	if err := o.Spec.Validate(); err != nil {
 		return nil, err
 	}
-	return nil, nil
+	if err := validateExtraVoters(o); err != nil {
+		return nil, err
+	}
+	if err := validateMaintenance(o); err != nil {
+		return nil, err
+	}
+	if err := validatePreRotation(o); err != nil {
+		return nil, err
+	}
+	if err := validateGoogleCloudNameTemplate(o); err != nil {
+		return nil, err
+	}
+	warnings, err := r.validateAdoptExisting(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, wireGuardModeWarnings(o)...)
+	configWarnings, err := r.validateMergedConfig(ctx, o)
+	return append(warnings, configWarnings...), err
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
@@ -75,9 +124,311 @@ func (r *nodeGroupValidator) ValidateUpdate(ctx context.Context, oldObj, newObj
 	if err := n.Spec.Validate(); err != nil {
 		return nil, err
 	}
+	if err := validateExtraVoters(n); err != nil {
+		return nil, err
+	}
+	if err := validateMaintenance(n); err != nil {
+		return nil, err
+	}
+	if err := validatePreRotation(n); err != nil {
+		return nil, err
+	}
+	if err := validateGoogleCloudNameTemplate(n); err != nil {
+		return nil, err
+	}
+	if o.Spec.GoogleCloud != nil && n.Spec.GoogleCloud != nil && o.Spec.GoogleCloud.NameTemplate != n.Spec.GoogleCloud.NameTemplate {
+		return nil, field.Invalid(
+			field.NewPath("spec", "googleCloud", "nameTemplate"),
+			n.Spec.GoogleCloud.NameTemplate,
+			"nameTemplate is immutable")
+	}
+	if o.Spec.GoogleCloud != nil && n.Spec.GoogleCloud != nil && o.Spec.GoogleCloud.UseManagedInstanceGroup != n.Spec.GoogleCloud.UseManagedInstanceGroup {
+		return nil, field.Invalid(
+			field.NewPath("spec", "googleCloud", "useManagedInstanceGroup"),
+			n.Spec.GoogleCloud.UseManagedInstanceGroup,
+			"useManagedInstanceGroup is immutable")
+	}
+	if err := validateBareMetalHosts(o, n); err != nil {
+		return nil, err
+	}
+	var oldAdopt, newAdopt NodeGroupAdoptConfig
+	if adopt := o.adoptExisting(); adopt != nil {
+		oldAdopt = *adopt
+	}
+	if adopt := n.adoptExisting(); adopt != nil {
+		newAdopt = *adopt
+	}
+	if oldAdopt != newAdopt {
+		return nil, field.Invalid(
+			field.NewPath("spec", "cluster", "adoptExisting"),
+			n.adoptExisting(),
+			"adoptExisting is immutable")
+	}
+	warnings, err := r.validateAdoptExisting(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, wireGuardModeWarnings(n)...)
+	configWarnings, err := r.validateMergedConfig(ctx, n)
+	return append(warnings, configWarnings...), err
+}
+
+// validateExtraVoters ensures that only bootstrap groups authorize extra voters.
+func validateExtraVoters(group *NodeGroup) error {
+	if len(group.Spec.ExtraVoters) == 0 {
+		return nil
+	}
+	if val, ok := group.GetAnnotations()[BootstrapNodeGroupAnnotation]; !ok || val != "true" {
+		return field.Invalid(
+			field.NewPath("spec", "extraVoters"),
+			group.Spec.ExtraVoters,
+			"extraVoters may only be set on the bootstrap configuration of a Mesh")
+	}
+	return nil
+}
+
+// validateBareMetalHosts ensures that the hosts of a bare metal group keep
+// their positions. The node on each host is identified by its index, removing
+// or reordering hosts before the end of the list would move the identities of
+// the later nodes onto other machines.
+func validateBareMetalHosts(old, new *NodeGroup) error {
+	if old.Spec.BareMetal == nil || new.Spec.BareMetal == nil {
+		return nil
+	}
+	oldHosts, newHosts := old.Spec.BareMetal.Hosts, new.Spec.BareMetal.Hosts
+	for i := 0; i < len(oldHosts) && i < len(newHosts); i++ {
+		if oldHosts[i] != newHosts[i] {
+			return field.Invalid(
+				field.NewPath("spec", "bareMetal", "hosts").Index(i),
+				newHosts[i],
+				fmt.Sprintf("the node at this index runs on %s, hosts may only be appended or removed from the end", oldHosts[i]))
+		}
+	}
+	return nil
+}
+
+// validateMaintenance ensures that the maintenance annotation lists replica
+// ordinals and is only placed on groups running in a cluster.
+func validateMaintenance(group *NodeGroup) error {
+	value, ok := group.GetAnnotations()[MaintenanceAnnotation]
+	if !ok {
+		return nil
+	}
+	path := field.NewPath("metadata", "annotations").Key(MaintenanceAnnotation)
+	if group.Spec.Cluster == nil {
+		return field.Invalid(path, value, "maintenance may only be set on node groups running in a cluster")
+	}
+	if _, err := group.MaintenanceOrdinals(); err != nil {
+		return field.Invalid(path, value, err.Error())
+	}
+	return nil
+}
+
+// validatePreRotation ensures that the pre-rotation annotation holds a
+// threshold.
+func validatePreRotation(group *NodeGroup) error {
+	if _, _, err := group.PreRotationThreshold(); err != nil {
+		path := field.NewPath("metadata", "annotations").Key(PreRotateCertificatesAnnotation)
+		return field.Invalid(path, group.GetAnnotations()[PreRotateCertificatesAnnotation], err.Error())
+	}
+	return nil
+}
+
+// wireGuardModeWarnings warns about the throughput of userspace WireGuard.
+func wireGuardModeWarnings(group *NodeGroup) admission.Warnings {
+	if group.Spec.Cluster == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "cluster", "wireguardMode")
+	switch group.Spec.Cluster.WireGuardMode {
+	case WireGuardModeUserspace:
+		return admission.Warnings{fmt.Sprintf("%s: userspace WireGuard has considerably lower throughput and higher CPU usage than the kernel module", path)}
+	case WireGuardModeAuto:
+		return admission.Warnings{fmt.Sprintf("%s: nodes fall back to userspace WireGuard, with considerably lower throughput and higher CPU usage, on cluster nodes without the kernel module", path)}
+	}
+	return nil
+}
+
+// validateAdoptExisting checks that the objects adopted by the group exist and
+// that the StatefulSet can be taken over. The fields of a StatefulSet that
+// decide which pods and claims it owns are immutable, so they must be
+// compatible with the group. Objects in the cluster of a kubeconfig cannot be
+// checked and only raise a warning.
+func (r *nodeGroupValidator) validateAdoptExisting(ctx context.Context, group *NodeGroup) (admission.Warnings, error) {
+	adopt := group.adoptExisting()
+	if adopt == nil {
+		return nil, nil
+	}
+	path := field.NewPath("spec", "cluster", "adoptExisting")
+	if group.Spec.Cluster.Kubeconfig != nil {
+		return admission.Warnings{fmt.Sprintf("%s: objects in the cluster of the kubeconfig cannot be checked before they are adopted", path)}, nil
+	}
+	var sts appsv1.StatefulSet
+	err := r.Get(ctx, client.ObjectKey{Name: adopt.StatefulSetName, Namespace: group.GetNamespace()}, &sts)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, field.NotFound(path.Child("statefulSetName"), adopt.StatefulSetName)
+		}
+		return nil, fmt.Errorf("get statefulset: %w", err)
+	}
+	if owner := metav1.GetControllerOf(&sts); owner != nil && (owner.Kind != "NodeGroup" || owner.Name != group.GetName()) {
+		return nil, field.Invalid(path.Child("statefulSetName"), adopt.StatefulSetName,
+			fmt.Sprintf("is controlled by %s %s", owner.Kind, owner.Name))
+	}
+	selector := sts.Spec.Selector
+	if selector == nil || len(selector.MatchLabels) == 0 || len(selector.MatchExpressions) > 0 {
+		return nil, field.Invalid(path.Child("statefulSetName"), adopt.StatefulSetName,
+			"must select its pods with matchLabels only")
+	}
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: group.MeshKey().Name, Namespace: group.MeshKey().Namespace}}
+	labels := NodeGroupLabels(mesh, group)
+	for key, value := range selector.MatchLabels {
+		if current, ok := labels[key]; ok && current != value {
+			return nil, field.Invalid(path.Child("statefulSetName"), adopt.StatefulSetName,
+				fmt.Sprintf("selects pods by label %s=%s, which conflicts with the label %s=%s of the node group", key, value, key, current))
+		}
+	}
+	if sts.Spec.ServiceName != adopt.StatefulSetName {
+		return nil, field.Invalid(path.Child("statefulSetName"), adopt.StatefulSetName,
+			fmt.Sprintf("must be governed by a headless service of the same name, not %q", sts.Spec.ServiceName))
+	}
+	switch {
+	case group.Spec.Cluster.PVCSpec == nil && len(sts.Spec.VolumeClaimTemplates) > 0:
+		return nil, field.Required(field.NewPath("spec", "cluster", "pvcSpec"),
+			"the adopted StatefulSet has volume claim templates")
+	case group.Spec.Cluster.PVCSpec != nil && len(sts.Spec.VolumeClaimTemplates) != 1:
+		return nil, field.Invalid(field.NewPath("spec", "cluster", "pvcSpec"), group.Spec.Cluster.PVCSpec,
+			fmt.Sprintf("the adopted StatefulSet must have exactly one volume claim template, it has %d", len(sts.Spec.VolumeClaimTemplates)))
+	}
+	var cm corev1.ConfigMap
+	err = r.Get(ctx, client.ObjectKey{Name: adopt.ConfigMapName, Namespace: group.GetNamespace()}, &cm)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, field.NotFound(path.Child("configMapName"), adopt.ConfigMapName)
+		}
+		return nil, fmt.Errorf("get configmap: %w", err)
+	}
 	return nil, nil
 }
 
+// validateMergedConfig validates the group's config merged with its config
+// group in the mesh.
+func (r *nodeGroupValidator) validateMergedConfig(ctx context.Context, group *NodeGroup) (admission.Warnings, error) {
+	var mesh Mesh
+	if err := r.Get(ctx, group.MeshKey(), &mesh); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Warnings{"mesh not found, unable to validate merged config"}, nil
+		}
+		return nil, fmt.Errorf("get mesh: %w", err)
+	}
+	cfg, err := group.MergedConfig(&mesh)
+	if err != nil {
+		return nil, field.Invalid(field.NewPath("spec", "configGroup"), group.Spec.ConfigGroup, err.Error())
+	}
+	if err := group.Spec.validatePorts(field.NewPath("spec"), cfg); err != nil {
+		return nil, err
+	}
+	if err := r.validateDefaultGateway(ctx, &mesh, group, cfg); err != nil {
+		return nil, err
+	}
+	warnings, err := validateDockerBridge(&mesh, group)
+	if err != nil {
+		return nil, err
+	}
+	return append(warnings, metricsWarnings(group, cfg)...), nil
+}
+
+// validateDockerBridge ensures the docker0 bridge of Google Cloud instances
+// does not overlap the IPv4 CIDR of the mesh. Groups using the default bridge
+// are only warned, as they were accepted before the bridge was configurable.
+func validateDockerBridge(mesh *Mesh, group *NodeGroup) (admission.Warnings, error) {
+	if group.Spec.GoogleCloud == nil {
+		return nil, nil
+	}
+	_, meshNet, err := net.ParseCIDR(mesh.Spec.IPv4)
+	if err != nil {
+		return nil, nil
+	}
+	bridge, err := group.Spec.GoogleCloud.DockerBridgeNetwork()
+	if err != nil || !(bridge.Contains(meshNet.IP) || meshNet.Contains(bridge.IP)) {
+		return nil, nil
+	}
+	path := field.NewPath("spec", "googleCloud", "dockerBridgeCIDR")
+	if group.Spec.GoogleCloud.DockerBridgeCIDR == "" {
+		return admission.Warnings{fmt.Sprintf("%s: the default docker bridge %s overlaps the mesh IPv4 CIDR %s, set a bridge outside of it",
+			path, DefaultDockerBridgeCIDR, mesh.Spec.IPv4)}, nil
+	}
+	return nil, field.Invalid(path, group.Spec.GoogleCloud.DockerBridgeCIDR,
+		fmt.Sprintf("overlaps the mesh IPv4 CIDR %s", mesh.Spec.IPv4))
+}
+
+// metricsWarnings warns when metrics are served in plaintext and without
+// authentication on an address reachable from outside of the cluster network.
+func metricsWarnings(group *NodeGroup, cfg *NodeGroupConfig) admission.Warnings {
+	if cfg.Services == nil || cfg.Services.Metrics == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "config", "services", "metrics", "tls")
+	switch {
+	case group.Spec.GoogleCloud != nil && cfg.MetricsTLS() != nil:
+		return admission.Warnings{fmt.Sprintf("%s: TLS is not supported for groups running on Google Cloud, metrics are served in plaintext", path)}
+	case group.Spec.AWS != nil && cfg.MetricsTLS() != nil:
+		return admission.Warnings{fmt.Sprintf("%s: TLS is not supported for groups running on AWS, metrics are served in plaintext", path)}
+	case group.Spec.Azure != nil && cfg.MetricsTLS() != nil:
+		return admission.Warnings{fmt.Sprintf("%s: TLS is not supported for groups running on Azure, metrics are served in plaintext", path)}
+	case group.Spec.DigitalOcean != nil && cfg.MetricsTLS() != nil:
+		return admission.Warnings{fmt.Sprintf("%s: TLS is not supported for groups running on DigitalOcean, metrics are served in plaintext", path)}
+	case group.Spec.Hetzner != nil && cfg.MetricsTLS() != nil:
+		return admission.Warnings{fmt.Sprintf("%s: TLS is not supported for groups running on Hetzner, metrics are served in plaintext", path)}
+	case group.Spec.BareMetal != nil && cfg.MetricsTLS() != nil:
+		return admission.Warnings{fmt.Sprintf("%s: TLS is not supported for groups running on bare metal hosts, metrics are served in plaintext", path)}
+	case cfg.MetricsTLS() != nil:
+		return nil
+	case group.Spec.GoogleCloud != nil || group.Spec.AWS != nil || group.Spec.Azure != nil || group.Spec.DigitalOcean != nil || group.Spec.Hetzner != nil:
+		return admission.Warnings{fmt.Sprintf("%s: metrics are served in plaintext without authentication on the instances' network", path)}
+	case group.Spec.BareMetal != nil:
+		return admission.Warnings{fmt.Sprintf("%s: metrics are served in plaintext without authentication on the hosts' network", path)}
+	case group.Spec.Cluster != nil && group.Spec.Cluster.HostNetwork:
+		return admission.Warnings{fmt.Sprintf("%s: metrics are served in plaintext without authentication on the host network", path)}
+	}
+	return nil
+}
+
+// validateDefaultGateway ensures that no more than the allowed number of
+// groups in a mesh advertise a default route.
+func (r *nodeGroupValidator) validateDefaultGateway(ctx context.Context, mesh *Mesh, group *NodeGroup, cfg *NodeGroupConfig) error {
+	if !cfg.AdvertisesDefaultGateway() {
+		return nil
+	}
+	var groups NodeGroupList
+	if err := r.List(ctx, &groups); err != nil {
+		return fmt.Errorf("list node groups: %w", err)
+	}
+	gateways := 1
+	for _, g := range groups.Items {
+		if g.GetName() == group.GetName() && g.GetNamespace() == group.GetNamespace() {
+			continue
+		}
+		if g.GetDeletionTimestamp() != nil || g.MeshKey() != group.MeshKey() {
+			continue
+		}
+		if cfg, err := g.MergedConfig(mesh); err == nil && cfg.AdvertisesDefaultGateway() {
+			gateways++
+		}
+	}
+	limit := int(mesh.Spec.MaxDefaultGateways)
+	if limit == 0 {
+		limit = 1
+	}
+	if gateways > limit {
+		return field.Invalid(
+			field.NewPath("spec", "config", "defaultGateway"),
+			cfg.DefaultGateway,
+			fmt.Sprintf("mesh %s allows at most %d node groups to advertise a default gateway", mesh.GetName(), limit))
+	}
+	return nil
+}
+
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (r *nodeGroupValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
 	o := obj.(*NodeGroup)
@@ -0,0 +1,398 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateAdoptExisting(t *testing.T) {
+	newStatefulSet := func(mutate func(*appsv1.StatefulSet)) *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "webmesh", Namespace: "default"},
+			Spec: appsv1.StatefulSetSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "webmesh"},
+				},
+				ServiceName: "webmesh",
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+					{ObjectMeta: metav1.ObjectMeta{Name: "raft"}},
+				},
+			},
+		}
+		if mutate != nil {
+			mutate(sts)
+		}
+		return sts
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "webmesh-config", Namespace: "default"},
+	}
+
+	tc := []struct {
+		name       string
+		objs       []client.Object
+		noPVC      bool
+		kubeconfig bool
+		err        bool
+		warnings   int
+	}{
+		{
+			name: "compatible statefulset",
+			objs: []client.Object{newStatefulSet(nil), configMap},
+		},
+		{
+			name: "statefulset not found",
+			objs: []client.Object{configMap},
+			err:  true,
+		},
+		{
+			name: "configmap not found",
+			objs: []client.Object{newStatefulSet(nil)},
+			err:  true,
+		},
+		{
+			name: "selector with expressions",
+			objs: []client.Object{newStatefulSet(func(sts *appsv1.StatefulSet) {
+				sts.Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpExists},
+				}
+			}), configMap},
+			err: true,
+		},
+		{
+			name: "selector conflicting with group labels",
+			objs: []client.Object{newStatefulSet(func(sts *appsv1.StatefulSet) {
+				sts.Spec.Selector.MatchLabels[NodeGroupNameLabel] = "other"
+			}), configMap},
+			err: true,
+		},
+		{
+			name: "different service name",
+			objs: []client.Object{newStatefulSet(func(sts *appsv1.StatefulSet) {
+				sts.Spec.ServiceName = "webmesh-headless"
+			}), configMap},
+			err: true,
+		},
+		{
+			name: "controlled by another object",
+			objs: []client.Object{newStatefulSet(func(sts *appsv1.StatefulSet) {
+				sts.OwnerReferences = []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       "other",
+					UID:        "uid",
+					Controller: func() *bool { b := true; return &b }(),
+				}}
+			}), configMap},
+			err: true,
+		},
+		{
+			name:  "volume claim templates without a pvc spec",
+			objs:  []client.Object{newStatefulSet(nil), configMap},
+			noPVC: true,
+			err:   true,
+		},
+		{
+			name: "pvc spec without volume claim templates",
+			objs: []client.Object{newStatefulSet(func(sts *appsv1.StatefulSet) {
+				sts.Spec.VolumeClaimTemplates = nil
+			}), configMap},
+			err: true,
+		},
+		{
+			name:       "statefulset in another cluster",
+			kubeconfig: true,
+			warnings:   1,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := fake.NewClientBuilder().
+				WithScheme(clientgoscheme.Scheme).
+				WithObjects(tt.objs...).
+				Build()
+			group := &NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: NodeGroupSpec{
+					Mesh: corev1.ObjectReference{Name: "mesh"},
+					Cluster: &NodeGroupClusterConfig{
+						PVCSpec: &corev1.PersistentVolumeClaimSpec{},
+						AdoptExisting: &NodeGroupAdoptConfig{
+							StatefulSetName: "webmesh",
+							ConfigMapName:   "webmesh-config",
+						},
+					},
+				},
+			}
+			if tt.noPVC {
+				group.Spec.Cluster.PVCSpec = nil
+			}
+			if tt.kubeconfig {
+				group.Spec.Cluster.Kubeconfig = &corev1.SecretKeySelector{Key: "kubeconfig"}
+			}
+			v := &nodeGroupValidator{Client: cli}
+			warnings, err := v.validateAdoptExisting(context.Background(), group)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("expected %d warnings, got %v", tt.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestValidateExposedReplicas(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	v := &nodeGroupValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(mesh).Build()}
+	replicas := int32(3)
+	for _, tt := range []struct {
+		name       string
+		perReplica bool
+		err        bool
+	}{
+		{name: "shared service", err: true},
+		{name: "service per replica", perReplica: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: NodeGroupSpec{
+					Mesh:     corev1.ObjectReference{Name: mesh.Name},
+					Replicas: &replicas,
+					Cluster: &NodeGroupClusterConfig{
+						Service: &NodeGroupLBConfig{
+							Type:       corev1.ServiceTypeLoadBalancer,
+							PerReplica: tt.perReplica,
+						},
+					},
+				},
+			}
+			group.Default()
+			_, err := v.ValidateCreate(context.Background(), group)
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if _, err := v.ValidateUpdate(context.Background(), group.DeepCopy(), group); tt.err != (err != nil) {
+				t.Fatalf("expected update error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateKubeconfigReplicas(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	v := &nodeGroupValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(mesh).Build()}
+	for _, tt := range []struct {
+		name       string
+		replicas   int32
+		kubeconfig bool
+		err        bool
+	}{
+		{name: "local cluster", replicas: 3},
+		{name: "single replica in a remote cluster", replicas: 1, kubeconfig: true},
+		// Every pod would be given the certificate secrets of all replicas
+		{name: "replicas in a remote cluster", replicas: 3, kubeconfig: true, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			replicas := tt.replicas
+			group := &NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: NodeGroupSpec{
+					Mesh:     corev1.ObjectReference{Name: mesh.Name},
+					Replicas: &replicas,
+					Cluster:  &NodeGroupClusterConfig{},
+				},
+			}
+			if tt.kubeconfig {
+				group.Spec.Cluster.Kubeconfig = &corev1.SecretKeySelector{Key: "kubeconfig"}
+			}
+			group.Default()
+			_, err := v.ValidateCreate(context.Background(), group)
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if _, err := v.ValidateUpdate(context.Background(), group.DeepCopy(), group); tt.err != (err != nil) {
+				t.Fatalf("expected update error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateBareMetalHosts(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	v := &nodeGroupValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(mesh).Build()}
+	newGroup := func(hosts ...string) *NodeGroup {
+		group := &NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+			Spec: NodeGroupSpec{
+				Mesh: corev1.ObjectReference{Name: mesh.Name},
+				BareMetal: &NodeGroupBareMetalConfig{
+					Hosts:             hosts,
+					CredentialsSecret: "ssh",
+				},
+			},
+		}
+		group.Default()
+		return group
+	}
+	old := newGroup("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	for _, tt := range []struct {
+		name  string
+		hosts []string
+		err   bool
+	}{
+		{name: "unchanged", hosts: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{name: "appended", hosts: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+		{name: "removed from the end", hosts: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "removed from the middle", hosts: []string{"10.0.0.1", "10.0.0.3"}, err: true},
+		{name: "reordered", hosts: []string{"10.0.0.2", "10.0.0.1", "10.0.0.3"}, err: true},
+		{name: "replaced", hosts: []string{"10.0.0.1", "10.0.0.5", "10.0.0.3"}, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateUpdate(context.Background(), old, newGroup(tt.hosts...))
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "spec.bareMetal.hosts[") {
+				t.Errorf("expected the error to point at the moved host, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateDefaultGateway(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	newGroup := func(name string, mode DefaultGatewayMode) *NodeGroup {
+		return &NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: NodeGroupSpec{
+				Mesh:   corev1.ObjectReference{Name: mesh.Name},
+				Config: &NodeGroupConfig{DefaultGateway: mode},
+			},
+		}
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newGroup("gateway", DefaultGatewayAdvertise)).Build()
+	v := &nodeGroupValidator{Client: cli}
+	for _, tt := range []struct {
+		mode  DefaultGatewayMode
+		limit int32
+		err   bool
+	}{
+		{mode: DefaultGatewayAccept},
+		{mode: DefaultGatewayIgnore},
+		{mode: DefaultGatewayAdvertise, err: true},
+		{mode: DefaultGatewayAdvertise, limit: 2},
+	} {
+		mesh.Spec.MaxDefaultGateways = tt.limit
+		group := newGroup("group", tt.mode)
+		err := v.validateDefaultGateway(context.Background(), mesh, group, group.Spec.Config)
+		if tt.err != (err != nil) {
+			t.Errorf("%s with a limit of %d: expected error %v, got %v", tt.mode, tt.limit, tt.err, err)
+		}
+	}
+	// Updating the gateway itself does not count it twice
+	group := newGroup("gateway", DefaultGatewayAdvertise)
+	mesh.Spec.MaxDefaultGateways = 1
+	if err := v.validateDefaultGateway(context.Background(), mesh, group, group.Spec.Config); err != nil {
+		t.Errorf("expected the existing gateway to be valid, got %v", err)
+	}
+}
+
+func TestWireGuardModeWarnings(t *testing.T) {
+	for mode, warns := range map[WireGuardMode]bool{
+		WireGuardModeKernel:    false,
+		WireGuardModeUserspace: true,
+		WireGuardModeAuto:      true,
+	} {
+		group := &NodeGroup{Spec: NodeGroupSpec{Cluster: &NodeGroupClusterConfig{WireGuardMode: mode}}}
+		if got := len(wireGuardModeWarnings(group)) > 0; got != warns {
+			t.Errorf("%s: expected warning %v, got %v", mode, warns, got)
+		}
+	}
+}
+
+func TestValidateDockerBridge(t *testing.T) {
+	mesh := &Mesh{Spec: MeshSpec{IPv4: "192.168.0.0/16"}}
+	group := &NodeGroup{Spec: NodeGroupSpec{GoogleCloud: &NodeGroupGoogleCloudConfig{}}}
+	// The default bridge is kept for groups created before it was
+	// configurable
+	warnings, err := validateDockerBridge(mesh, group)
+	if err != nil || len(warnings) != 1 {
+		t.Errorf("expected a warning for the default bridge, got %v, %v", warnings, err)
+	}
+	group.Spec.GoogleCloud.DockerBridgeCIDR = "192.168.10.1/24"
+	if _, err := validateDockerBridge(mesh, group); err == nil {
+		t.Error("expected an error for a bridge inside the mesh CIDR")
+	}
+	group.Spec.GoogleCloud.DockerBridgeCIDR = "10.200.0.1/24"
+	warnings, err = validateDockerBridge(mesh, group)
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("expected a bridge outside the mesh CIDR to be valid, got %v, %v", warnings, err)
+	}
+	mesh.Spec.IPv4 = "172.16.0.0/12"
+	group.Spec.GoogleCloud.DockerBridgeCIDR = ""
+	warnings, err = validateDockerBridge(mesh, group)
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("expected the default bridge to be valid, got %v, %v", warnings, err)
+	}
+}
@@ -99,10 +99,10 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&Mesh{}).SetupWebhookWithManager(mgr)
+	err = (&Mesh{}).SetupWebhookWithManager(mgr, nil)
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&NodeGroup{}).SetupWebhookWithManager(mgr)
+	err = (&NodeGroup{}).SetupWebhookWithManager(mgr, nil)
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:webhook
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// WebmeshOperatorConfigSpec defines operator-level defaults. The config in the
+// namespace of the operator applies to every namespace and overrides the flags
+// of the operator. A config in any other namespace overrides it for the objects
+// in that namespace, except for the settings marked as operator-wide, which are
+// only read from the operator's namespace.
+type WebmeshOperatorConfigSpec struct {
+	// Images are the default images used for objects that do not set their
+	// own. Operator-wide.
+	// +optional
+	Images *Images `json:"images,omitempty"`
+
+	// ClusterDomain is the DNS domain of the cluster. Operator-wide.
+	// +optional
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+
+	// ProviderConcurrency bounds the concurrent operations against each
+	// cloud provider, keyed by provider name. Zero is unbounded.
+	// Operator-wide.
+	// +optional
+	ProviderConcurrency map[string]int32 `json:"providerConcurrency,omitempty"`
+
+	// MaxRetryBackoff caps how long failed hosts of bare metal groups are
+	// left alone before they are retried.
+	// +optional
+	MaxRetryBackoff *metav1.Duration `json:"maxRetryBackoff,omitempty"`
+
+	// LoadBalancerClass is the class of the LoadBalancer services created
+	// for node groups, selecting the load balancer implementation. The
+	// class of a service cannot change, so it only applies to services
+	// created after it is set.
+	// +optional
+	LoadBalancerClass string `json:"loadBalancerClass,omitempty"`
+
+	// SkipJoinServerProbe disables the TLS probe of join servers before
+	// they are rendered into node configs, for clusters where the operator
+	// cannot reach the mesh.
+	// +optional
+	SkipJoinServerProbe *bool `json:"skipJoinServerProbe,omitempty"`
+}
+
+// Validate validates the WebmeshOperatorConfigSpec.
+func (s *WebmeshOperatorConfigSpec) Validate() error {
+	path := field.NewPath("spec")
+	if s.Images != nil {
+		if s.Images.Node != "" {
+			if err := ValidateImage(path.Child("images", "node"), s.Images.Node); err != nil {
+				return err
+			}
+		}
+		if s.Images.MetricsProxy != "" {
+			if err := ValidateImage(path.Child("images", "metricsProxy"), s.Images.MetricsProxy); err != nil {
+				return err
+			}
+		}
+	}
+	if s.ClusterDomain != "" {
+		domain := strings.TrimSuffix(s.ClusterDomain, ".")
+		if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 {
+			return field.Invalid(path.Child("clusterDomain"), s.ClusterDomain, strings.Join(errs, ", "))
+		}
+	}
+	for provider, limit := range s.ProviderConcurrency {
+		if provider == "" {
+			return field.Invalid(path.Child("providerConcurrency"), provider, "provider names must not be empty")
+		}
+		if limit < 0 {
+			return field.Invalid(path.Child("providerConcurrency").Key(provider), limit, "must not be negative")
+		}
+	}
+	if s.MaxRetryBackoff != nil && s.MaxRetryBackoff.Duration <= 0 {
+		return field.Invalid(path.Child("maxRetryBackoff"), s.MaxRetryBackoff.Duration.String(), "must be positive")
+	}
+	if s.LoadBalancerClass != "" {
+		if errs := validation.IsQualifiedName(s.LoadBalancerClass); len(errs) > 0 {
+			return field.Invalid(path.Child("loadBalancerClass"), s.LoadBalancerClass, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=wmconfig
+//+kubebuilder:validation:XValidation:rule="self.metadata.name == 'default'",message="the operator only reads configs named default"
+
+// WebmeshOperatorConfig is the Schema for the webmeshoperatorconfigs API
+type WebmeshOperatorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WebmeshOperatorConfigSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WebmeshOperatorConfigList contains a list of WebmeshOperatorConfig
+type WebmeshOperatorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebmeshOperatorConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebmeshOperatorConfig{}, &WebmeshOperatorConfigList{})
+}
@@ -23,153 +23,93 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IssuerConfig) DeepCopyInto(out *IssuerConfig) {
+func (in *AccessProfile) DeepCopyInto(out *AccessProfile) {
 	*out = *in
-	out.IssuerRef = in.IssuerRef
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerConfig.
-func (in *IssuerConfig) DeepCopy() *IssuerConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessProfile.
+func (in *AccessProfile) DeepCopy() *AccessProfile {
 	if in == nil {
 		return nil
 	}
-	out := new(IssuerConfig)
+	out := new(AccessProfile)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Mesh) DeepCopyInto(out *Mesh) {
+func (in *AccessRequestConfig) DeepCopyInto(out *AccessRequestConfig) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Mesh.
-func (in *Mesh) DeepCopy() *Mesh {
-	if in == nil {
-		return nil
-	}
-	out := new(Mesh)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *Mesh) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+	if in.AllowedRoles != nil {
+		in, out := &in.AllowedRoles, &out.AllowedRoles
+		*out = make([]AccessProfileRole, len(*in))
+		copy(*out, *in)
 	}
-	return nil
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *MeshList) DeepCopyInto(out *MeshList) {
-	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]Mesh, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.MaxDuration != nil {
+		in, out := &in.MaxDuration, &out.MaxDuration
+		*out = new(metav1.Duration)
+		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshList.
-func (in *MeshList) DeepCopy() *MeshList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessRequestConfig.
+func (in *AccessRequestConfig) DeepCopy() *AccessRequestConfig {
 	if in == nil {
 		return nil
 	}
-	out := new(MeshList)
+	out := new(AccessRequestConfig)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *MeshList) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
-}
-
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *MeshSpec) DeepCopyInto(out *MeshSpec) {
+func (in *AdminConfigSpec) DeepCopyInto(out *AdminConfigSpec) {
 	*out = *in
-	if in.ConfigGroups != nil {
-		in, out := &in.ConfigGroups, &out.ConfigGroups
-		*out = make(map[string]NodeGroupConfig, len(*in))
-		for key, val := range *in {
-			(*out)[key] = *val.DeepCopy()
-		}
+	if in.Vault != nil {
+		in, out := &in.Vault, &out.Vault
+		*out = new(VaultStoreConfig)
+		(*in).DeepCopyInto(*out)
 	}
-	in.Bootstrap.DeepCopyInto(&out.Bootstrap)
-	out.Issuer = in.Issuer
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshSpec.
-func (in *MeshSpec) DeepCopy() *MeshSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminConfigSpec.
+func (in *AdminConfigSpec) DeepCopy() *AdminConfigSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(MeshSpec)
+	out := new(AdminConfigSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *MeshStatus) DeepCopyInto(out *MeshStatus) {
+func (in *AzureImageReference) DeepCopyInto(out *AzureImageReference) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshStatus.
-func (in *MeshStatus) DeepCopy() *MeshStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureImageReference.
+func (in *AzureImageReference) DeepCopy() *AzureImageReference {
 	if in == nil {
 		return nil
 	}
-	out := new(MeshStatus)
+	out := new(AzureImageReference)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroup) DeepCopyInto(out *NodeGroup) {
+func (in *BootstrapLBGroupSpec) DeepCopyInto(out *BootstrapLBGroupSpec) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroup.
-func (in *NodeGroup) DeepCopy() *NodeGroup {
-	if in == nil {
-		return nil
-	}
-	out := new(NodeGroup)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *NodeGroup) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+	if in.Voter != nil {
+		in, out := &in.Voter, &out.Voter
+		*out = new(bool)
+		**out = **in
 	}
-	return nil
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupClusterConfig) DeepCopyInto(out *NodeGroupClusterConfig) {
-	*out = *in
 	if in.ImagePullSecrets != nil {
 		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
 		*out = make([]corev1.LocalObjectReference, len(*in))
@@ -201,11 +141,6 @@ func (in *NodeGroupClusterConfig) DeepCopyInto(out *NodeGroupClusterConfig) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	if in.PreemptionPolicy != nil {
-		in, out := &in.PreemptionPolicy, &out.PreemptionPolicy
-		*out = new(corev1.PreemptionPolicy)
-		**out = **in
-	}
 	if in.TopologySpreadConstraints != nil {
 		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
 		*out = make([]corev1.TopologySpreadConstraint, len(*in))
@@ -213,242 +148,1606 @@ func (in *NodeGroupClusterConfig) DeepCopyInto(out *NodeGroupClusterConfig) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	if in.ResourceClaims != nil {
-		in, out := &in.ResourceClaims, &out.ResourceClaims
-		*out = make([]corev1.PodResourceClaim, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	if in.AdditionalVolumes != nil {
-		in, out := &in.AdditionalVolumes, &out.AdditionalVolumes
-		*out = make([]corev1.Volume, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	if in.AdditionalVolumeMounts != nil {
-		in, out := &in.AdditionalVolumeMounts, &out.AdditionalVolumeMounts
-		*out = make([]corev1.VolumeMount, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	if in.AdditionalContainers != nil {
-		in, out := &in.AdditionalContainers, &out.AdditionalContainers
-		*out = make([]corev1.Container, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	if in.InitContainers != nil {
-		in, out := &in.InitContainers, &out.InitContainers
-		*out = make([]corev1.Container, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	in.Resources.DeepCopyInto(&out.Resources)
-	if in.Service != nil {
-		in, out := &in.Service, &out.Service
-		*out = new(NodeGroupLBConfig)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.PVCSpec != nil {
-		in, out := &in.PVCSpec, &out.PVCSpec
-		*out = new(corev1.PersistentVolumeClaimSpec)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.Kubeconfig != nil {
-		in, out := &in.Kubeconfig, &out.Kubeconfig
-		*out = new(corev1.SecretKeySelector)
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
 		(*in).DeepCopyInto(*out)
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupClusterConfig.
-func (in *NodeGroupClusterConfig) DeepCopy() *NodeGroupClusterConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootstrapLBGroupSpec.
+func (in *BootstrapLBGroupSpec) DeepCopy() *BootstrapLBGroupSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupClusterConfig)
+	out := new(BootstrapLBGroupSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupConfig) DeepCopyInto(out *NodeGroupConfig) {
+func (in *CIDRUsageConfig) DeepCopyInto(out *CIDRUsageConfig) {
 	*out = *in
-	if in.Services != nil {
-		in, out := &in.Services, &out.Services
-		*out = new(NodeServicesConfig)
-		(*in).DeepCopyInto(*out)
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Threshold != nil {
+		in, out := &in.Threshold, &out.Threshold
+		*out = new(int32)
+		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupConfig.
-func (in *NodeGroupConfig) DeepCopy() *NodeGroupConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CIDRUsageConfig.
+func (in *CIDRUsageConfig) DeepCopy() *CIDRUsageConfig {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupConfig)
+	out := new(CIDRUsageConfig)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupGoogleCloudConfig) DeepCopyInto(out *NodeGroupGoogleCloudConfig) {
+func (in *CloudConfigFile) DeepCopyInto(out *CloudConfigFile) {
 	*out = *in
-	if in.Tags != nil {
-		in, out := &in.Tags, &out.Tags
-		*out = make([]string, len(*in))
-		copy(*out, *in)
-	}
-	if in.Credentials != nil {
-		in, out := &in.Credentials, &out.Credentials
-		*out = new(corev1.SecretKeySelector)
-		(*in).DeepCopyInto(*out)
-	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupGoogleCloudConfig.
-func (in *NodeGroupGoogleCloudConfig) DeepCopy() *NodeGroupGoogleCloudConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudConfigFile.
+func (in *CloudConfigFile) DeepCopy() *CloudConfigFile {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupGoogleCloudConfig)
+	out := new(CloudConfigFile)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupLBConfig) DeepCopyInto(out *NodeGroupLBConfig) {
+func (in *CloudConfigSSH) DeepCopyInto(out *CloudConfigSSH) {
 	*out = *in
-	if in.Annotations != nil {
-		in, out := &in.Annotations, &out.Annotations
-		*out = make(map[string]string, len(*in))
-		for key, val := range *in {
-			(*out)[key] = val
+	if in.Users != nil {
+		in, out := &in.Users, &out.Users
+		*out = make([]CloudConfigSSHUser, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupLBConfig.
-func (in *NodeGroupLBConfig) DeepCopy() *NodeGroupLBConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudConfigSSH.
+func (in *CloudConfigSSH) DeepCopy() *CloudConfigSSH {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupLBConfig)
+	out := new(CloudConfigSSH)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupList) DeepCopyInto(out *NodeGroupList) {
+func (in *CloudConfigSSHUser) DeepCopyInto(out *CloudConfigSSHUser) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]NodeGroup, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.AuthorizedKeys != nil {
+		in, out := &in.AuthorizedKeys, &out.AuthorizedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AuthorizedKeysSecretRef != nil {
+		in, out := &in.AuthorizedKeysSecretRef, &out.AuthorizedKeysSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupList.
-func (in *NodeGroupList) DeepCopy() *NodeGroupList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudConfigSSHUser.
+func (in *CloudConfigSSHUser) DeepCopy() *CloudConfigSSHUser {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupList)
+	out := new(CloudConfigSSHUser)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *NodeGroupList) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FileSecret) DeepCopyInto(out *FileSecret) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FileSecret.
+func (in *FileSecret) DeepCopy() *FileSecret {
+	if in == nil {
+		return nil
 	}
-	return nil
+	out := new(FileSecret)
+	in.DeepCopyInto(out)
+	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupSpec) DeepCopyInto(out *NodeGroupSpec) {
+func (in *GoogleCloudExternalAccount) DeepCopyInto(out *GoogleCloudExternalAccount) {
 	*out = *in
-	if in.Replicas != nil {
-		in, out := &in.Replicas, &out.Replicas
-		*out = new(int32)
-		**out = **in
-	}
-	out.Mesh = in.Mesh
-	if in.Config != nil {
-		in, out := &in.Config, &out.Config
-		*out = new(NodeGroupConfig)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.Cluster != nil {
-		in, out := &in.Cluster, &out.Cluster
-		*out = new(NodeGroupClusterConfig)
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(corev1.ConfigMapKeySelector)
 		(*in).DeepCopyInto(*out)
 	}
-	if in.GoogleCloud != nil {
-		in, out := &in.GoogleCloud, &out.GoogleCloud
-		*out = new(NodeGroupGoogleCloudConfig)
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(corev1.SecretKeySelector)
 		(*in).DeepCopyInto(*out)
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupSpec.
-func (in *NodeGroupSpec) DeepCopy() *NodeGroupSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GoogleCloudExternalAccount.
+func (in *GoogleCloudExternalAccount) DeepCopy() *GoogleCloudExternalAccount {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupSpec)
+	out := new(GoogleCloudExternalAccount)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeGroupStatus) DeepCopyInto(out *NodeGroupStatus) {
+func (in *GoogleCloudHostAlias) DeepCopyInto(out *GoogleCloudHostAlias) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupStatus.
-func (in *NodeGroupStatus) DeepCopy() *NodeGroupStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GoogleCloudHostAlias.
+func (in *GoogleCloudHostAlias) DeepCopy() *GoogleCloudHostAlias {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeGroupStatus)
+	out := new(GoogleCloudHostAlias)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeMeshDNSConfig) DeepCopyInto(out *NodeMeshDNSConfig) {
+func (in *Images) DeepCopyInto(out *Images) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMeshDNSConfig.
-func (in *NodeMeshDNSConfig) DeepCopy() *NodeMeshDNSConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Images.
+func (in *Images) DeepCopy() *Images {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeMeshDNSConfig)
+	out := new(Images)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeMetricsConfig) DeepCopyInto(out *NodeMetricsConfig) {
+func (in *IssuerConfig) DeepCopyInto(out *IssuerConfig) {
 	*out = *in
+	out.IssuerRef = in.IssuerRef
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMetricsConfig.
-func (in *NodeMetricsConfig) DeepCopy() *NodeMetricsConfig {
-	if in == nil {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerConfig.
+func (in *IssuerConfig) DeepCopy() *IssuerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IssuerState) DeepCopyInto(out *IssuerState) {
+	*out = *in
+	if in.OtherNamespaces != nil {
+		in, out := &in.OtherNamespaces, &out.OtherNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerState.
+func (in *IssuerState) DeepCopy() *IssuerState {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuerState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Mesh) DeepCopyInto(out *Mesh) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Mesh.
+func (in *Mesh) DeepCopy() *Mesh {
+	if in == nil {
+		return nil
+	}
+	out := new(Mesh)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Mesh) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshAccessRequest) DeepCopyInto(out *MeshAccessRequest) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshAccessRequest.
+func (in *MeshAccessRequest) DeepCopy() *MeshAccessRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshAccessRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MeshAccessRequest) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshAccessRequestList) DeepCopyInto(out *MeshAccessRequestList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MeshAccessRequest, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshAccessRequestList.
+func (in *MeshAccessRequestList) DeepCopy() *MeshAccessRequestList {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshAccessRequestList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MeshAccessRequestList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshAccessRequestSpec) DeepCopyInto(out *MeshAccessRequestSpec) {
+	*out = *in
+	out.Mesh = in.Mesh
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshAccessRequestSpec.
+func (in *MeshAccessRequestSpec) DeepCopy() *MeshAccessRequestSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshAccessRequestSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshAccessRequestStatus) DeepCopyInto(out *MeshAccessRequestStatus) {
+	*out = *in
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshAccessRequestStatus.
+func (in *MeshAccessRequestStatus) DeepCopy() *MeshAccessRequestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshAccessRequestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshCIDRUsage) DeepCopyInto(out *MeshCIDRUsage) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]NodeGroupCIDRUsage, len(*in))
+		copy(*out, *in)
+	}
+	in.LastReportTime.DeepCopyInto(&out.LastReportTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshCIDRUsage.
+func (in *MeshCIDRUsage) DeepCopy() *MeshCIDRUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshCIDRUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshDiscoveryRecord) DeepCopyInto(out *MeshDiscoveryRecord) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshDiscoveryRecord.
+func (in *MeshDiscoveryRecord) DeepCopy() *MeshDiscoveryRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshDiscoveryRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshDiscoveryRecords) DeepCopyInto(out *MeshDiscoveryRecords) {
+	*out = *in
+	in.Config.DeepCopyInto(&out.Config)
+	if in.Records != nil {
+		in, out := &in.Records, &out.Records
+		*out = make([]MeshDiscoveryRecord, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshDiscoveryRecords.
+func (in *MeshDiscoveryRecords) DeepCopy() *MeshDiscoveryRecords {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshDiscoveryRecords)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshList) DeepCopyInto(out *MeshList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Mesh, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshList.
+func (in *MeshList) DeepCopy() *MeshList {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MeshList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshPeering) DeepCopyInto(out *MeshPeering) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshPeering.
+func (in *MeshPeering) DeepCopy() *MeshPeering {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshPeering)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MeshPeering) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshPeeringExternal) DeepCopyInto(out *MeshPeeringExternal) {
+	*out = *in
+	out.Credentials = in.Credentials
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshPeeringExternal.
+func (in *MeshPeeringExternal) DeepCopy() *MeshPeeringExternal {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshPeeringExternal)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshPeeringList) DeepCopyInto(out *MeshPeeringList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MeshPeering, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshPeeringList.
+func (in *MeshPeeringList) DeepCopy() *MeshPeeringList {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshPeeringList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MeshPeeringList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshPeeringRemote) DeepCopyInto(out *MeshPeeringRemote) {
+	*out = *in
+	if in.Mesh != nil {
+		in, out := &in.Mesh, &out.Mesh
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.External != nil {
+		in, out := &in.External, &out.External
+		*out = new(MeshPeeringExternal)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshPeeringRemote.
+func (in *MeshPeeringRemote) DeepCopy() *MeshPeeringRemote {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshPeeringRemote)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshPeeringSpec) DeepCopyInto(out *MeshPeeringSpec) {
+	*out = *in
+	out.Mesh = in.Mesh
+	in.Remote.DeepCopyInto(&out.Remote)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshPeeringSpec.
+func (in *MeshPeeringSpec) DeepCopy() *MeshPeeringSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshPeeringSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshPeeringStatus) DeepCopyInto(out *MeshPeeringStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshPeeringStatus.
+func (in *MeshPeeringStatus) DeepCopy() *MeshPeeringStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshPeeringStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshSpec) DeepCopyInto(out *MeshSpec) {
+	*out = *in
+	if in.ConfigGroups != nil {
+		in, out := &in.ConfigGroups, &out.ConfigGroups
+		*out = make(map[string]NodeGroupConfig, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	in.Bootstrap.DeepCopyInto(&out.Bootstrap)
+	if in.BootstrapLB != nil {
+		in, out := &in.BootstrapLB, &out.BootstrapLB
+		*out = new(BootstrapLBGroupSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Issuer = in.Issuer
+	in.ServiceIPFamilies.DeepCopyInto(&out.ServiceIPFamilies)
+	in.AdminConfig.DeepCopyInto(&out.AdminConfig)
+	if in.AccessProfiles != nil {
+		in, out := &in.AccessProfiles, &out.AccessProfiles
+		*out = make([]AccessProfile, len(*in))
+		copy(*out, *in)
+	}
+	if in.AccessRequests != nil {
+		in, out := &in.AccessRequests, &out.AccessRequests
+		*out = new(AccessRequestConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Security.DeepCopyInto(&out.Security)
+	if in.PropagateMetadata != nil {
+		in, out := &in.PropagateMetadata, &out.PropagateMetadata
+		*out = new(MetadataPropagation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CIDRUsage != nil {
+		in, out := &in.CIDRUsage, &out.CIDRUsage
+		*out = new(CIDRUsageConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshSpec.
+func (in *MeshSpec) DeepCopy() *MeshSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshStatus) DeepCopyInto(out *MeshStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MeshCIDRUsage != nil {
+		in, out := &in.MeshCIDRUsage, &out.MeshCIDRUsage
+		*out = new(MeshCIDRUsage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AccessProfiles != nil {
+		in, out := &in.AccessProfiles, &out.AccessProfiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DiscoveryRecords != nil {
+		in, out := &in.DiscoveryRecords, &out.DiscoveryRecords
+		*out = new(MeshDiscoveryRecords)
+		(*in).DeepCopyInto(*out)
+	}
+	in.ReconcileStatus.DeepCopyInto(&out.ReconcileStatus)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshStatus.
+func (in *MeshStatus) DeepCopy() *MeshStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetadataPropagation) DeepCopyInto(out *MetadataPropagation) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetadataPropagation.
+func (in *MetadataPropagation) DeepCopy() *MetadataPropagation {
+	if in == nil {
+		return nil
+	}
+	out := new(MetadataPropagation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeCertificateConfig) DeepCopyInto(out *NodeCertificateConfig) {
+	*out = *in
+	if in.ExtraDNSNames != nil {
+		in, out := &in.ExtraDNSNames, &out.ExtraDNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraIPAddresses != nil {
+		in, out := &in.ExtraIPAddresses, &out.ExtraIPAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeCertificateConfig.
+func (in *NodeCertificateConfig) DeepCopy() *NodeCertificateConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeCertificateConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeCertificateStatus) DeepCopyInto(out *NodeCertificateStatus) {
+	*out = *in
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeCertificateStatus.
+func (in *NodeCertificateStatus) DeepCopy() *NodeCertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeCertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroup) DeepCopyInto(out *NodeGroup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroup.
+func (in *NodeGroup) DeepCopy() *NodeGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodeGroup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupAWSConfig) DeepCopyInto(out *NodeGroupAWSConfig) {
+	*out = *in
+	if in.AssociatePublicIPAddress != nil {
+		in, out := &in.AssociatePublicIPAddress, &out.AssociatePublicIPAddress
+		*out = new(bool)
+		**out = **in
+	}
+	if in.SecurityGroupIDs != nil {
+		in, out := &in.SecurityGroupIDs, &out.SecurityGroupIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = new(CloudConfigSSH)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupAWSConfig.
+func (in *NodeGroupAWSConfig) DeepCopy() *NodeGroupAWSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupAWSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupAdoptConfig) DeepCopyInto(out *NodeGroupAdoptConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupAdoptConfig.
+func (in *NodeGroupAdoptConfig) DeepCopy() *NodeGroupAdoptConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupAdoptConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupAzureConfig) DeepCopyInto(out *NodeGroupAzureConfig) {
+	*out = *in
+	if in.EnablePublicIPAddress != nil {
+		in, out := &in.EnablePublicIPAddress, &out.EnablePublicIPAddress
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(AzureImageReference)
+		**out = **in
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = new(CloudConfigSSH)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupAzureConfig.
+func (in *NodeGroupAzureConfig) DeepCopy() *NodeGroupAzureConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupAzureConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupBareMetalConfig) DeepCopyInto(out *NodeGroupBareMetalConfig) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupBareMetalConfig.
+func (in *NodeGroupBareMetalConfig) DeepCopy() *NodeGroupBareMetalConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupBareMetalConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupCIDRUsage) DeepCopyInto(out *NodeGroupCIDRUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupCIDRUsage.
+func (in *NodeGroupCIDRUsage) DeepCopy() *NodeGroupCIDRUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupCIDRUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupClusterConfig) DeepCopyInto(out *NodeGroupClusterConfig) {
+	*out = *in
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodAnnotations != nil {
+		in, out := &in.PodAnnotations, &out.PodAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DedicatedNodes != nil {
+		in, out := &in.DedicatedNodes, &out.DedicatedNodes
+		*out = new(NodeGroupDedicatedNodesConfig)
+		**out = **in
+	}
+	if in.PreemptionPolicy != nil {
+		in, out := &in.PreemptionPolicy, &out.PreemptionPolicy
+		*out = new(corev1.PreemptionPolicy)
+		**out = **in
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]corev1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ResourceClaims != nil {
+		in, out := &in.ResourceClaims, &out.ResourceClaims
+		*out = make([]corev1.PodResourceClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AdditionalVolumes != nil {
+		in, out := &in.AdditionalVolumes, &out.AdditionalVolumes
+		*out = make([]corev1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AdditionalVolumeMounts != nil {
+		in, out := &in.AdditionalVolumeMounts, &out.AdditionalVolumeMounts
+		*out = make([]corev1.VolumeMount, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AdditionalContainers != nil {
+		in, out := &in.AdditionalContainers, &out.AdditionalContainers
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InitContainers != nil {
+		in, out := &in.InitContainers, &out.InitContainers
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(NodeGroupLBConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PVCSpec != nil {
+		in, out := &in.PVCSpec, &out.PVCSpec
+		*out = new(corev1.PersistentVolumeClaimSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Kubeconfig != nil {
+		in, out := &in.Kubeconfig, &out.Kubeconfig
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdoptExisting != nil {
+		in, out := &in.AdoptExisting, &out.AdoptExisting
+		*out = new(NodeGroupAdoptConfig)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupClusterConfig.
+func (in *NodeGroupClusterConfig) DeepCopy() *NodeGroupClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupConfig) DeepCopyInto(out *NodeGroupConfig) {
+	*out = *in
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = new(NodeServicesConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(NodeCertificateConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Security != nil {
+		in, out := &in.Security, &out.Security
+		*out = new(SecurityConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupConfig.
+func (in *NodeGroupConfig) DeepCopy() *NodeGroupConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupDedicatedNodesConfig) DeepCopyInto(out *NodeGroupDedicatedNodesConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupDedicatedNodesConfig.
+func (in *NodeGroupDedicatedNodesConfig) DeepCopy() *NodeGroupDedicatedNodesConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupDedicatedNodesConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupDigitalOceanConfig) DeepCopyInto(out *NodeGroupDigitalOceanConfig) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Token != nil {
+		in, out := &in.Token, &out.Token
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = new(CloudConfigSSH)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupDigitalOceanConfig.
+func (in *NodeGroupDigitalOceanConfig) DeepCopy() *NodeGroupDigitalOceanConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupDigitalOceanConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupGoogleCloudConfig) DeepCopyInto(out *NodeGroupGoogleCloudConfig) {
+	*out = *in
+	if in.DiskSizeGB != nil {
+		in, out := &in.DiskSizeGB, &out.DiskSizeGB
+		*out = new(int64)
+		**out = **in
+	}
+	if in.AutomaticRestart != nil {
+		in, out := &in.AutomaticRestart, &out.AutomaticRestart
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Scopes != nil {
+		in, out := &in.Scopes, &out.Scopes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.EnableIPv6 != nil {
+		in, out := &in.EnableIPv6, &out.EnableIPv6
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableExternalIPv4 != nil {
+		in, out := &in.EnableExternalIPv4, &out.EnableExternalIPv4
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DetectEndpoints != nil {
+		in, out := &in.DetectEndpoints, &out.DetectEndpoints
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AllowRemoteDetection != nil {
+		in, out := &in.AllowRemoteDetection, &out.AllowRemoteDetection
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExternalAccount != nil {
+		in, out := &in.ExternalAccount, &out.ExternalAccount
+		*out = new(GoogleCloudExternalAccount)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(NodeGroupSchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FileSecrets != nil {
+		in, out := &in.FileSecrets, &out.FileSecrets
+		*out = make([]FileSecret, len(*in))
+		copy(*out, *in)
+	}
+	if in.HostAliases != nil {
+		in, out := &in.HostAliases, &out.HostAliases
+		*out = make([]GoogleCloudHostAlias, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraFiles != nil {
+		in, out := &in.ExtraFiles, &out.ExtraFiles
+		*out = make([]CloudConfigFile, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraPackages != nil {
+		in, out := &in.ExtraPackages, &out.ExtraPackages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraRunCmd != nil {
+		in, out := &in.ExtraRunCmd, &out.ExtraRunCmd
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = new(CloudConfigSSH)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupGoogleCloudConfig.
+func (in *NodeGroupGoogleCloudConfig) DeepCopy() *NodeGroupGoogleCloudConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupGoogleCloudConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupHetznerConfig) DeepCopyInto(out *NodeGroupHetznerConfig) {
+	*out = *in
+	if in.EnablePublicIPv4 != nil {
+		in, out := &in.EnablePublicIPv4, &out.EnablePublicIPv4
+		*out = new(bool)
+		**out = **in
+	}
+	if in.SSHKeys != nil {
+		in, out := &in.SSHKeys, &out.SSHKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Token != nil {
+		in, out := &in.Token, &out.Token
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = new(CloudConfigSSH)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupHetznerConfig.
+func (in *NodeGroupHetznerConfig) DeepCopy() *NodeGroupHetznerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupHetznerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupHostStatus) DeepCopyInto(out *NodeGroupHostStatus) {
+	*out = *in
+	if in.LastAttemptTime != nil {
+		in, out := &in.LastAttemptTime, &out.LastAttemptTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupHostStatus.
+func (in *NodeGroupHostStatus) DeepCopy() *NodeGroupHostStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupHostStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupInstanceStatus) DeepCopyInto(out *NodeGroupInstanceStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupInstanceStatus.
+func (in *NodeGroupInstanceStatus) DeepCopy() *NodeGroupInstanceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupInstanceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupLBConfig) DeepCopyInto(out *NodeGroupLBConfig) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.ServiceIPFamilies.DeepCopyInto(&out.ServiceIPFamilies)
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(NodeGroupLBDNSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExtraDNSNames != nil {
+		in, out := &in.ExtraDNSNames, &out.ExtraDNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraIPAddresses != nil {
+		in, out := &in.ExtraIPAddresses, &out.ExtraIPAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupLBConfig.
+func (in *NodeGroupLBConfig) DeepCopy() *NodeGroupLBConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupLBConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupLBDNSConfig) DeepCopyInto(out *NodeGroupLBDNSConfig) {
+	*out = *in
+	if in.CredentialsSecret != nil {
+		in, out := &in.CredentialsSecret, &out.CredentialsSecret
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupLBDNSConfig.
+func (in *NodeGroupLBDNSConfig) DeepCopy() *NodeGroupLBDNSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupLBDNSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupList) DeepCopyInto(out *NodeGroupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NodeGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupList.
+func (in *NodeGroupList) DeepCopy() *NodeGroupList {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodeGroupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupSchedule) DeepCopyInto(out *NodeGroupSchedule) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]ScheduleDay, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupSchedule.
+func (in *NodeGroupSchedule) DeepCopy() *NodeGroupSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupScheduleStatus) DeepCopyInto(out *NodeGroupScheduleStatus) {
+	*out = *in
+	if in.NextTransition != nil {
+		in, out := &in.NextTransition, &out.NextTransition
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupScheduleStatus.
+func (in *NodeGroupScheduleStatus) DeepCopy() *NodeGroupScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupSpec) DeepCopyInto(out *NodeGroupSpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	out.Mesh = in.Mesh
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(NodeGroupConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Cluster != nil {
+		in, out := &in.Cluster, &out.Cluster
+		*out = new(NodeGroupClusterConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GoogleCloud != nil {
+		in, out := &in.GoogleCloud, &out.GoogleCloud
+		*out = new(NodeGroupGoogleCloudConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(NodeGroupAWSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Azure != nil {
+		in, out := &in.Azure, &out.Azure
+		*out = new(NodeGroupAzureConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DigitalOcean != nil {
+		in, out := &in.DigitalOcean, &out.DigitalOcean
+		*out = new(NodeGroupDigitalOceanConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Hetzner != nil {
+		in, out := &in.Hetzner, &out.Hetzner
+		*out = new(NodeGroupHetznerConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BareMetal != nil {
+		in, out := &in.BareMetal, &out.BareMetal
+		*out = new(NodeGroupBareMetalConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExtraVoters != nil {
+		in, out := &in.ExtraVoters, &out.ExtraVoters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupSpec.
+func (in *NodeGroupSpec) DeepCopy() *NodeGroupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeGroupStatus) DeepCopyInto(out *NodeGroupStatus) {
+	*out = *in
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(NodeGroupScheduleStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Certificates != nil {
+		in, out := &in.Certificates, &out.Certificates
+		*out = make([]NodeCertificateStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.ReconcileStatus.DeepCopyInto(&out.ReconcileStatus)
+	if in.EffectiveConfig != nil {
+		in, out := &in.EffectiveConfig, &out.EffectiveConfig
+		*out = new(NodeGroupConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LoadBalancerAddresses != nil {
+		in, out := &in.LoadBalancerAddresses, &out.LoadBalancerAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReplicaAddresses != nil {
+		in, out := &in.ReplicaAddresses, &out.ReplicaAddresses
+		*out = make([]NodeReplicaAddresses, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]NodeGroupHostStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AcceptedConfigChecksums != nil {
+		in, out := &in.AcceptedConfigChecksums, &out.AcceptedConfigChecksums
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ReissuedCertificateNames != nil {
+		in, out := &in.ReissuedCertificateNames, &out.ReissuedCertificateNames
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.Instances != nil {
+		in, out := &in.Instances, &out.Instances
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.InstanceDetails != nil {
+		in, out := &in.InstanceDetails, &out.InstanceDetails
+		*out = make([]NodeGroupInstanceStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReservedAddresses != nil {
+		in, out := &in.ReservedAddresses, &out.ReservedAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Maintenance != nil {
+		in, out := &in.Maintenance, &out.Maintenance
+		*out = make([]NodeMaintenanceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeGroupStatus.
+func (in *NodeGroupStatus) DeepCopy() *NodeGroupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeGroupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeMaintenanceStatus) DeepCopyInto(out *NodeMaintenanceStatus) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMaintenanceStatus.
+func (in *NodeMaintenanceStatus) DeepCopy() *NodeMaintenanceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeMaintenanceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeMeshDNSConfig) DeepCopyInto(out *NodeMeshDNSConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMeshDNSConfig.
+func (in *NodeMeshDNSConfig) DeepCopy() *NodeMeshDNSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeMeshDNSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeMetricsConfig) DeepCopyInto(out *NodeMetricsConfig) {
+	*out = *in
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(NodeMetricsTLSConfig)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMetricsConfig.
+func (in *NodeMetricsConfig) DeepCopy() *NodeMetricsConfig {
+	if in == nil {
 		return nil
 	}
 	out := new(NodeMetricsConfig)
@@ -456,13 +1755,48 @@ func (in *NodeMetricsConfig) DeepCopy() *NodeMetricsConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeMetricsTLSConfig) DeepCopyInto(out *NodeMetricsTLSConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMetricsTLSConfig.
+func (in *NodeMetricsTLSConfig) DeepCopy() *NodeMetricsTLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeMetricsTLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeReplicaAddresses) DeepCopyInto(out *NodeReplicaAddresses) {
+	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeReplicaAddresses.
+func (in *NodeReplicaAddresses) DeepCopy() *NodeReplicaAddresses {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeReplicaAddresses)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeServicesConfig) DeepCopyInto(out *NodeServicesConfig) {
 	*out = *in
 	if in.Metrics != nil {
 		in, out := &in.Metrics, &out.Metrics
 		*out = new(NodeMetricsConfig)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.WebRTC != nil {
 		in, out := &in.WebRTC, &out.WebRTC
@@ -494,6 +1828,11 @@ func (in *NodeWebRTCConfig) DeepCopyInto(out *NodeWebRTCConfig) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.STUNServersSecret != nil {
+		in, out := &in.STUNServersSecret, &out.STUNServersSecret
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeWebRTCConfig.
@@ -505,3 +1844,191 @@ func (in *NodeWebRTCConfig) DeepCopy() *NodeWebRTCConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReconcileStatus) DeepCopyInto(out *ReconcileStatus) {
+	*out = *in
+	if in.LastReconcileTime != nil {
+		in, out := &in.LastReconcileTime, &out.LastReconcileTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulReconcileTime != nil {
+		in, out := &in.LastSuccessfulReconcileTime, &out.LastSuccessfulReconcileTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReconcileStatus.
+func (in *ReconcileStatus) DeepCopy() *ReconcileStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReconcileStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecurityConfig) DeepCopyInto(out *SecurityConfig) {
+	*out = *in
+	if in.VerifyChainOnly != nil {
+		in, out := &in.VerifyChainOnly, &out.VerifyChainOnly
+		*out = new(bool)
+		**out = **in
+	}
+	if in.RequireClientCert != nil {
+		in, out := &in.RequireClientCert, &out.RequireClientCert
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecurityConfig.
+func (in *SecurityConfig) DeepCopy() *SecurityConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SecurityConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceIPFamilies) DeepCopyInto(out *ServiceIPFamilies) {
+	*out = *in
+	if in.IPFamilyPolicy != nil {
+		in, out := &in.IPFamilyPolicy, &out.IPFamilyPolicy
+		*out = new(corev1.IPFamilyPolicy)
+		**out = **in
+	}
+	if in.IPFamilies != nil {
+		in, out := &in.IPFamilies, &out.IPFamilies
+		*out = make([]corev1.IPFamily, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceIPFamilies.
+func (in *ServiceIPFamilies) DeepCopy() *ServiceIPFamilies {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceIPFamilies)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultStoreConfig) DeepCopyInto(out *VaultStoreConfig) {
+	*out = *in
+	if in.CABundle != nil {
+		in, out := &in.CABundle, &out.CABundle
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultStoreConfig.
+func (in *VaultStoreConfig) DeepCopy() *VaultStoreConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultStoreConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebmeshOperatorConfig) DeepCopyInto(out *WebmeshOperatorConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebmeshOperatorConfig.
+func (in *WebmeshOperatorConfig) DeepCopy() *WebmeshOperatorConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WebmeshOperatorConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WebmeshOperatorConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebmeshOperatorConfigList) DeepCopyInto(out *WebmeshOperatorConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WebmeshOperatorConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebmeshOperatorConfigList.
+func (in *WebmeshOperatorConfigList) DeepCopy() *WebmeshOperatorConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(WebmeshOperatorConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WebmeshOperatorConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebmeshOperatorConfigSpec) DeepCopyInto(out *WebmeshOperatorConfigSpec) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = new(Images)
+		**out = **in
+	}
+	if in.ProviderConcurrency != nil {
+		in, out := &in.ProviderConcurrency, &out.ProviderConcurrency
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.MaxRetryBackoff != nil {
+		in, out := &in.MaxRetryBackoff, &out.MaxRetryBackoff
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.SkipJoinServerProbe != nil {
+		in, out := &in.SkipJoinServerProbe, &out.SkipJoinServerProbe
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebmeshOperatorConfigSpec.
+func (in *WebmeshOperatorConfigSpec) DeepCopy() *WebmeshOperatorConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebmeshOperatorConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
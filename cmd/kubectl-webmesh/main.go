@@ -0,0 +1,397 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-webmesh is a kubectl plugin for inspecting meshes managed by the
+// operator.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/configstore"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(meshv1.AddToScheme(scheme))
+	utilruntime.Must(certv1.AddToScheme(scheme))
+}
+
+const usage = `Usage: kubectl webmesh <command> [flags]
+
+Commands:
+  status MESH       Show the readiness, addresses, and certificates of a mesh
+  get-config MESH   Print the generated wmctl config for a mesh
+  render -f FILE    Render the manifests for a mesh offline
+  rbac              Print the aggregated ClusterRoles for tenants of the operator
+
+Run 'kubectl webmesh <command> -h' for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "status":
+		err = runStatus(ctx, os.Args[2:], os.Stdout)
+	case "get-config":
+		err = runGetConfig(ctx, os.Args[2:], os.Stdout)
+	case "render":
+		err = runRender(os.Args[2:], os.Stdout)
+	case "rbac":
+		err = runRBAC(os.Args[2:], os.Stdout)
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// clusterFlags are the flags shared by commands that talk to a cluster.
+type clusterFlags struct {
+	kubeconfig string
+	namespace  string
+}
+
+func (c *clusterFlags) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&c.namespace, "n", "", "Namespace of the mesh. Defaults to the namespace of the current context.")
+}
+
+func (c *clusterFlags) client() (client.Client, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.kubeconfig
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+	cfg, err := loader.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("load kubeconfig: %w", err)
+	}
+	namespace := c.namespace
+	if namespace == "" {
+		namespace, _, err = loader.Namespace()
+		if err != nil {
+			return nil, "", fmt.Errorf("get namespace: %w", err)
+		}
+	}
+	cli, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", fmt.Errorf("create client: %w", err)
+	}
+	return cli, namespace, nil
+}
+
+func newMeshFlagSet(name string) (*clusterFlags, *flag.FlagSet) {
+	var flags clusterFlags
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.bind(fs)
+	return &flags, fs
+}
+
+func getMesh(ctx context.Context, cli client.Client, namespace string, fs *flag.FlagSet) (*meshv1.Mesh, error) {
+	if fs.NArg() != 1 {
+		return nil, errors.New("exactly one mesh name is required")
+	}
+	var mesh meshv1.Mesh
+	if err := cli.Get(ctx, client.ObjectKey{Name: fs.Arg(0), Namespace: namespace}, &mesh); err != nil {
+		return nil, fmt.Errorf("get mesh: %w", err)
+	}
+	mesh.Default()
+	return &mesh, nil
+}
+
+func runStatus(ctx context.Context, args []string, out io.Writer) error {
+	flags, fs := newMeshFlagSet("status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cli, namespace, err := flags.client()
+	if err != nil {
+		return err
+	}
+	mesh, err := getMesh(ctx, cli, namespace, fs)
+	if err != nil {
+		return err
+	}
+	status, err := inspect.GetMeshStatus(ctx, cli, mesh)
+	if err != nil {
+		return err
+	}
+	return printStatus(out, status, time.Now())
+}
+
+func printStatus(out io.Writer, status *inspect.MeshStatus, now time.Time) error {
+	fmt.Fprintf(out, "Mesh:        %s/%s\n", status.Mesh.GetNamespace(), status.Mesh.GetName())
+	fmt.Fprintf(out, "Domain:      %s\n", status.Mesh.Spec.Domain)
+	fmt.Fprintf(out, "Admin cert:  %s\n\n", formatCert(status.AdminCertificate, now))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tREADY\tLB ADDRESSES\tCERTIFICATES")
+	for _, group := range status.Groups {
+		ready := "-"
+		if group.ReadyReplicas != nil {
+			ready = fmt.Sprintf("%d/%d", *group.ReadyReplicas, group.Replicas)
+		}
+		if group.Group.Status.Schedule != nil && group.Group.Status.Schedule.State == meshv1.ScheduleStateSuspended {
+			ready = string(meshv1.ScheduleStateSuspended)
+		}
+		addrs := "-"
+		if len(group.LBAddresses) > 0 {
+			addrs = strings.Join(group.LBAddresses, ",")
+		}
+		certs := make([]string, 0, len(group.Certificates))
+		for _, cert := range group.Certificates {
+			certs = append(certs, formatCert(cert, now))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", group.Group.GetName(), ready, addrs, strings.Join(certs, ", "))
+	}
+	return w.Flush()
+}
+
+func formatCert(cert inspect.CertificateStatus, now time.Time) string {
+	switch {
+	case !cert.Ready && cert.NotAfter == nil:
+		return "not issued"
+	case cert.NotAfter == nil:
+		return "ready"
+	case !cert.Ready:
+		return fmt.Sprintf("not ready (expires %s)", cert.NotAfter.Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("expires in %s", cert.NotAfter.Sub(now).Round(time.Hour))
+	}
+}
+
+func runGetConfig(ctx context.Context, args []string, out io.Writer) error {
+	flags, fs := newMeshFlagSet("get-config")
+	manager := fs.Bool("manager", false, "Print the in-cluster manager config instead of the admin config.")
+	vaultToken := fs.String("vault-token", os.Getenv("VAULT_TOKEN"), "Vault token to read the config with, for meshes storing their configs in Vault. Defaults to $VAULT_TOKEN.")
+	vaultRole := fs.String("vault-role", "", "Role of the Vault Kubernetes auth method to log in with instead of a Vault token. It must be bound to the service account given by -service-account, not to the operator.")
+	serviceAccount := fs.String("service-account", "", "Namespace and name of your own service account to log in to -vault-role with.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cli, namespace, err := flags.client()
+	if err != nil {
+		return err
+	}
+	mesh, err := getMesh(ctx, cli, namespace, fs)
+	if err != nil {
+		return err
+	}
+	login, err := vaultLogin(cli, mesh, *vaultToken, *vaultRole, *serviceAccount)
+	if err != nil {
+		return err
+	}
+	store, err := configstore.NewWithVaultLogin(ctx, cli, mesh, login)
+	if err != nil {
+		return err
+	}
+	name := meshv1.MeshAdminConfigName(mesh)
+	if *manager {
+		name = meshv1.MeshManagerConfigName(mesh)
+	}
+	data, err := store.Get(ctx, name)
+	if err != nil {
+		if errors.Is(err, configstore.ErrNotFound) {
+			return fmt.Errorf("config %s has not been generated yet", name)
+		}
+		return err
+	}
+	_, err = out.Write(data["config.yaml"])
+	return err
+}
+
+// vaultLogin returns the credentials to read the configs of meshes stored in
+// Vault with. They are always the caller's own: either a Vault token, or a
+// token of the caller's service account for a role other than the one of the
+// operator.
+func vaultLogin(cli client.Client, mesh *meshv1.Mesh, token, role, serviceAccount string) (configstore.VaultLogin, error) {
+	if mesh.Spec.AdminConfig.Store != meshv1.AdminConfigStoreVault || mesh.Spec.AdminConfig.Vault == nil {
+		return configstore.VaultLogin{}, nil
+	}
+	if token != "" {
+		return configstore.VaultLogin{Token: token}, nil
+	}
+	if role == "" || serviceAccount == "" {
+		return configstore.VaultLogin{}, fmt.Errorf("mesh %s stores its configs in Vault, set -vault-token, or -vault-role and -service-account", mesh.GetName())
+	}
+	if role == mesh.Spec.AdminConfig.Vault.Role {
+		return configstore.VaultLogin{}, fmt.Errorf("vault role %q is the role of the operator, use a role bound to your own service account", role)
+	}
+	return configstore.VaultLogin{
+		Role:                role,
+		ServiceAccountToken: requestServiceAccountToken(cli, serviceAccount),
+	}, nil
+}
+
+// requestServiceAccountToken returns a source of short-lived tokens for the
+// service account with the given namespace and name, requested with the
+// credentials of the kubeconfig.
+func requestServiceAccountToken(cli client.Client, serviceAccount string) configstore.ServiceAccountToken {
+	return func(ctx context.Context) (string, error) {
+		namespace, name, ok := strings.Cut(serviceAccount, "/")
+		if !ok || namespace == "" || name == "" {
+			return "", fmt.Errorf("service account %q is not of the form NAMESPACE/NAME", serviceAccount)
+		}
+		sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		req := &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: resources.Pointer(int64(600))},
+		}
+		if err := cli.SubResource("token").Create(ctx, sa, req); err != nil {
+			return "", fmt.Errorf("request token for service account %s: %w", serviceAccount, err)
+		}
+		return req.Status.Token, nil
+	}
+}
+
+// stringSlice is a flag that can be given multiple times.
+type stringSlice []string
+
+func (s *stringSlice) String() string     { return strings.Join(*s, ",") }
+func (s *stringSlice) Set(v string) error { *s = append(*s, v); return nil }
+
+func runRender(args []string, out io.Writer) error {
+	var files stringSlice
+	var opts inspect.RenderOptions
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.Var(&files, "f", "File containing a Mesh and optionally NodeGroups. May be given multiple times.")
+	namespace := fs.String("n", "default", "Namespace for objects that do not set one.")
+	fs.StringVar(&opts.JoinServer, "join-server", "", "Join server for non-bootstrap groups. Defaults to the bootstrap group's service.")
+	fs.StringVar(&opts.ClusterDomain, "cluster-domain", meshv1.DefaultClusterDomain, "DNS domain of the cluster the manifests are rendered for.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := meshv1.NormalizeClusterDomain(opts.ClusterDomain); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("at least one file is required")
+	}
+	var meshes []*meshv1.Mesh
+	var groups []*meshv1.NodeGroup
+	for _, file := range files {
+		objs, err := decodeFile(file)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(*namespace)
+			}
+			switch o := obj.(type) {
+			case *meshv1.Mesh:
+				meshes = append(meshes, o)
+			case *meshv1.NodeGroup:
+				groups = append(groups, o)
+			default:
+				return fmt.Errorf("%s: unsupported object %s", file, obj.GetObjectKind().GroupVersionKind().Kind)
+			}
+		}
+	}
+	if len(meshes) != 1 {
+		return fmt.Errorf("expected exactly one Mesh, got %d", len(meshes))
+	}
+	objs, err := inspect.Render(meshes[0], groups, opts)
+	if err != nil {
+		return err
+	}
+	return printObjects(out, objs)
+}
+
+func runRBAC(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rbac", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return printObjects(out, resources.RenderAggregatedClusterRoles())
+}
+
+func printObjects(out io.Writer, objs []client.Object) error {
+	for _, obj := range objs {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("marshal %s: %w", obj.GetName(), err)
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+	return nil
+}
+
+func decodeFile(path string) ([]client.Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	var objs []client.Object
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		if len(strings.TrimSpace(string(doc))) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+		cobj, ok := obj.(client.Object)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported object", path)
+		}
+		objs = append(objs, cobj)
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package baremetal provisions Webmesh nodes on existing machines over SSH.
+package baremetal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+)
+
+// DialTimeout is how long connecting to a host may take.
+const DialTimeout = 15 * time.Second
+
+// nodeUnit is the name of the systemd unit running the node.
+const nodeUnit = "node"
+
+// Credentials are the credentials hosts are connected with.
+type Credentials struct {
+	// User is the user to connect as. Commands of users other than root
+	// are run with sudo.
+	User string
+	// PrivateKey is the PEM encoded private key to authenticate with.
+	PrivateKey []byte
+	// KnownHosts are the known_hosts entries hosts are verified against.
+	KnownHosts []byte
+	// InsecureSkipHostKeyVerification skips verifying the host keys when
+	// there are no known hosts.
+	InsecureSkipHostKeyVerification bool
+}
+
+// ClientConfig returns the SSH client config for the credentials.
+func (c *Credentials) ClientConfig() (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey(c.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	config := &ssh.ClientConfig{
+		User:    c.User,
+		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		Timeout: DialTimeout,
+	}
+	switch {
+	case len(c.KnownHosts) > 0:
+		config.HostKeyCallback, err = knownHostsCallback(c.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("parse known hosts: %w", err)
+		}
+	case c.InsecureSkipHostKeyVerification:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("known hosts are required to verify the hosts")
+	}
+	return config, nil
+}
+
+// knownHostsCallback returns a host key callback for the given known_hosts
+// entries. The knownhosts package only reads files, so the entries are
+// matched against each host here. Plain and hashed host names are supported,
+// wildcards and certificate authorities are not.
+func knownHostsCallback(data []byte) (ssh.HostKeyCallback, error) {
+	type entry struct {
+		revoked bool
+		hosts   []string
+		key     ssh.PublicKey
+	}
+	var entries []entry
+	rest := data
+	for len(bytes.TrimSpace(rest)) > 0 {
+		marker, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rest = next
+		if marker == "cert-authority" {
+			continue
+		}
+		entries = append(entries, entry{revoked: marker == "revoked", hosts: hosts, key: key})
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		addrs := []string{knownhosts.Normalize(hostname)}
+		if remote != nil {
+			addrs = append(addrs, knownhosts.Normalize(remote.String()))
+		}
+		known := false
+		for _, e := range entries {
+			matches := bytes.Equal(e.key.Marshal(), key.Marshal())
+			if e.revoked && matches {
+				return fmt.Errorf("host key of %s is revoked", hostname)
+			}
+			if e.revoked || !matchesHost(e.hosts, addrs) {
+				continue
+			}
+			known = true
+			if matches {
+				return nil
+			}
+		}
+		if known {
+			return fmt.Errorf("host key of %s does not match its known hosts entry", hostname)
+		}
+		return fmt.Errorf("host %s is not in the known hosts", hostname)
+	}, nil
+}
+
+// matchesHost returns true if any of the known_hosts patterns names one of the
+// normalized addresses.
+func matchesHost(patterns, addrs []string) bool {
+	for _, pattern := range patterns {
+		for _, addr := range addrs {
+			if strings.HasPrefix(pattern, "|1|") {
+				if matchesHashedHost(pattern, addr) {
+					return true
+				}
+				continue
+			}
+			if knownhosts.Normalize(pattern) == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesHashedHost returns true if the hashed known_hosts pattern, of the
+// form |1|salt|hash, names the address.
+func matchesHashedHost(pattern, addr string) bool {
+	parts := strings.Split(strings.TrimPrefix(pattern, "|1|"), "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(addr))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// Host is a connection to a host.
+type Host struct {
+	client *ssh.Client
+	sudo   bool
+}
+
+// Dial connects to the host at the given address.
+func Dial(ctx context.Context, addr string, config *ssh.ClientConfig) (*Host, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s: %w", addr, err)
+	}
+	return &Host{
+		client: ssh.NewClient(c, chans, reqs),
+		sudo:   config.User != "root",
+	}, nil
+}
+
+// Close closes the connection to the host.
+func (h *Host) Close() error {
+	return h.client.Close()
+}
+
+// Run runs a shell command on the host with the given input. The output of
+// failed commands is included in their error.
+func (h *Host) Run(ctx context.Context, cmd string, stdin []byte) error {
+	session, err := h.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("open session: %w", err)
+	}
+	defer session.Close()
+	if stdin != nil {
+		session.Stdin = bytes.NewReader(stdin)
+	}
+	if h.sudo {
+		cmd = "sudo -n sh -c " + quote(cmd)
+	}
+	type result struct {
+		output []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := session.CombinedOutput(cmd)
+		done <- result{output, err}
+	}()
+	select {
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			if out := strings.TrimSpace(string(res.output)); out != "" {
+				return fmt.Errorf("%w: %s", res.err, out)
+			}
+			return res.err
+		}
+		return nil
+	}
+}
+
+// WriteFile replaces the file at the given path with the content. The file is
+// written next to it first, so it is never left half written.
+func (h *Host) WriteFile(ctx context.Context, file cloudconfig.File) error {
+	if file.Append {
+		return fmt.Errorf("write %s: appending to files is not supported", file.Path)
+	}
+	tmp := file.Path + ".tmp"
+	cmd := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %s %s && mv %s %s",
+		quote(path.Dir(file.Path)), quote(tmp), quote(file.Permissions), quote(tmp), quote(tmp), quote(file.Path))
+	if err := h.Run(ctx, cmd, file.Content); err != nil {
+		return fmt.Errorf("write %s: %w", file.Path, err)
+	}
+	return nil
+}
+
+// Provision writes the files of the cloud config to the host and restarts
+// the node unit.
+func Provision(ctx context.Context, h *Host, conf *cloudconfig.Config) error {
+	for _, file := range conf.Files() {
+		if err := h.WriteFile(ctx, file); err != nil {
+			return err
+		}
+	}
+	cmd := strings.Join([]string{
+		"mkdir -p /var/lib/webmesh/data",
+		"systemctl daemon-reload",
+		"systemctl enable " + nodeUnit,
+		"systemctl restart " + nodeUnit,
+	}, " && ")
+	if err := h.Run(ctx, cmd, nil); err != nil {
+		return fmt.Errorf("restart node: %w", err)
+	}
+	return nil
+}
+
+// Deprovision stops and disables the node unit on the host.
+func Deprovision(ctx context.Context, h *Host) error {
+	if err := h.Run(ctx, "systemctl disable --now "+nodeUnit, nil); err != nil {
+		return fmt.Errorf("stop node: %w", err)
+	}
+	return nil
+}
+
+// quote quotes a string for the shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baremetal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+// execRequest is a command run on the test server.
+type execRequest struct {
+	Command string
+	Stdin   string
+}
+
+// testServer is an SSH server recording the commands run on it.
+type testServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+
+	mu       sync.Mutex
+	commands []execRequest
+	fail     string
+}
+
+func (s *testServer) executed() []execRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]execRequest(nil), s.commands...)
+}
+
+func newTestServer(t *testing.T, clientKey ssh.PublicKey) *testServer {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &testServer{addr: ln.Addr().String(), hostKey: hostSigner.PublicKey()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn, config)
+		}
+	}()
+	return srv
+}
+
+func (s *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(ch, chReqs)
+	}
+}
+
+func (s *testServer) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+		stdin, _ := io.ReadAll(ch)
+		s.mu.Lock()
+		s.commands = append(s.commands, execRequest{Command: payload.Command, Stdin: string(stdin)})
+		fail := s.fail != "" && strings.Contains(payload.Command, s.fail)
+		s.mu.Unlock()
+		status := make([]byte, 4)
+		if fail {
+			_, _ = ch.Stderr().Write([]byte("unit failed"))
+			binary.BigEndian.PutUint32(status, 1)
+		}
+		_, _ = ch.SendRequest("exit-status", false, status)
+		return
+	}
+}
+
+func newTestCredentials(t *testing.T, user string) (*Credentials, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Credentials{
+		User:       user,
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}, signer.PublicKey()
+}
+
+func dialTestServer(t *testing.T, srv *testServer, creds *Credentials) *Host {
+	t.Helper()
+	config, err := creds.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := Dial(context.Background(), srv.addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { host.Close() })
+	return host
+}
+
+func TestProvision(t *testing.T) {
+	creds, clientKey := newTestCredentials(t, "root")
+	srv := newTestServer(t, clientKey)
+	creds.KnownHosts = []byte(fmt.Sprintf("%s %s", srv.addr, ssh.MarshalAuthorizedKey(srv.hostKey)))
+	host := dialTestServer(t, srv, creds)
+
+	conf, err := cloudconfig.New(cloudconfig.Options{
+		Image:        "ghcr.io/webmeshproj/node:latest",
+		Config:       testNodeConfig(t),
+		TLSCert:      []byte("cert"),
+		TLSKey:       []byte("key"),
+		CA:           []byte("ca"),
+		KeepFirewall: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Provision(context.Background(), host, conf); err != nil {
+		t.Fatal(err)
+	}
+	commands := srv.executed()
+	files := conf.Files()
+	if len(commands) != len(files)+1 {
+		t.Fatalf("expected %d commands, got %d", len(files)+1, len(commands))
+	}
+	for i, file := range files {
+		if !strings.Contains(commands[i].Command, quote(file.Path)) {
+			t.Errorf("expected command %d to write %s, got %q", i, file.Path, commands[i].Command)
+		}
+		if commands[i].Stdin != string(file.Content) {
+			t.Errorf("expected %s to be written with its content", file.Path)
+		}
+		if strings.HasPrefix(commands[i].Command, "sudo") {
+			t.Errorf("expected commands of root to be run without sudo, got %q", commands[i].Command)
+		}
+	}
+	last := commands[len(commands)-1].Command
+	if !strings.Contains(last, "systemctl restart node") {
+		t.Errorf("expected the node to be restarted, got %q", last)
+	}
+}
+
+func TestRunSudo(t *testing.T) {
+	creds, clientKey := newTestCredentials(t, "webmesh")
+	srv := newTestServer(t, clientKey)
+	creds.InsecureSkipHostKeyVerification = true
+	host := dialTestServer(t, srv, creds)
+
+	if err := Deprovision(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+	commands := srv.executed()
+	if len(commands) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(commands))
+	}
+	want := "sudo -n sh -c 'systemctl disable --now node'"
+	if commands[0].Command != want {
+		t.Errorf("expected %q, got %q", want, commands[0].Command)
+	}
+
+	srv.mu.Lock()
+	srv.fail = "systemctl"
+	srv.mu.Unlock()
+	err := Deprovision(context.Background(), host)
+	if err == nil || !strings.Contains(err.Error(), "unit failed") {
+		t.Errorf("expected the output of the failed command in the error, got %v", err)
+	}
+}
+
+func TestKnownHosts(t *testing.T) {
+	creds, clientKey := newTestCredentials(t, "root")
+	srv := newTestServer(t, clientKey)
+	other := newTestServer(t, clientKey)
+
+	tc := []struct {
+		name       string
+		knownHosts string
+		insecure   bool
+		wantErr    bool
+	}{
+		{
+			name:       "matching entry",
+			knownHosts: fmt.Sprintf("%s %s", srv.addr, ssh.MarshalAuthorizedKey(srv.hostKey)),
+		},
+		{
+			name:       "hashed entry",
+			knownHosts: fmt.Sprintf("%s %s", hashHost(t, srv.addr), ssh.MarshalAuthorizedKey(srv.hostKey)),
+		},
+		{
+			name:       "mismatched key",
+			knownHosts: fmt.Sprintf("%s %s", srv.addr, ssh.MarshalAuthorizedKey(other.hostKey)),
+			wantErr:    true,
+		},
+		{
+			name:       "unknown host",
+			knownHosts: fmt.Sprintf("%s %s", other.addr, ssh.MarshalAuthorizedKey(other.hostKey)),
+			wantErr:    true,
+		},
+		{
+			name:       "revoked key",
+			knownHosts: fmt.Sprintf("@revoked * %s", ssh.MarshalAuthorizedKey(srv.hostKey)),
+			insecure:   true,
+			wantErr:    true,
+		},
+		{
+			name:     "insecure skip verification",
+			insecure: true,
+		},
+		{
+			name:    "no known hosts",
+			wantErr: true,
+		},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			creds := *creds
+			creds.KnownHosts = []byte(c.knownHosts)
+			creds.InsecureSkipHostKeyVerification = c.insecure
+			config, err := creds.ClientConfig()
+			if err == nil {
+				var host *Host
+				host, err = Dial(context.Background(), srv.addr, config)
+				if err == nil {
+					host.Close()
+				}
+			}
+			if c.wantErr && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !c.wantErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteFileAppend(t *testing.T) {
+	creds, clientKey := newTestCredentials(t, "root")
+	srv := newTestServer(t, clientKey)
+	creds.InsecureSkipHostKeyVerification = true
+	host := dialTestServer(t, srv, creds)
+
+	err := host.WriteFile(context.Background(), cloudconfig.File{Path: "/etc/hosts", Append: true})
+	if err == nil {
+		t.Fatal("expected appending to be rejected")
+	}
+	if len(srv.executed()) != 0 {
+		t.Error("expected no commands to be run")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tc := map[string]string{
+		"/etc/webmesh":    `'/etc/webmesh'`,
+		"it's":            `'it'\''s'`,
+		"$(rm -rf /)":     `'$(rm -rf /)'`,
+		"with space here": `'with space here'`,
+	}
+	for in, want := range tc {
+		if got := quote(in); got != want {
+			t.Errorf("quote(%q): expected %s, got %s", in, want, got)
+		}
+	}
+}
+
+func testNodeConfig(t *testing.T) *nodeconfig.Config {
+	t.Helper()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "hosts", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			BareMetal: &meshv1.NodeGroupBareMetalConfig{Hosts: []string{"10.0.0.1"}},
+		},
+	}
+	conf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// hashHost returns the hashed known_hosts pattern of the address.
+func hashHost(t *testing.T, addr string) string {
+	t.Helper()
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(knownhosts.Normalize(addr)))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
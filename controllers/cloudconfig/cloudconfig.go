@@ -15,14 +15,21 @@ limitations under the License.
 */
 
 // Package cloudconfig contains Webmesh node cloud config rendering.
-// Returned cloud-configs are intended for use with ubuntu images.
+// Returned cloud-configs are intended for use with ubuntu images, configs for
+// the container linux OS families are rendered as Ignition instead.
 package cloudconfig
 
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -32,13 +39,26 @@ import (
 
 // Config represents a rendered cloud config.
 type Config struct {
-	// Raw is the raw cloud config.
+	// Raw is the raw cloud config or Ignition config.
 	raw []byte
+	// checksum is the checksum of the cloud config with the node config
+	// replaced by its own checksum.
+	checksum string
+	// secretsChecksum is the checksum of the TLS material.
+	secretsChecksum string
+	// files are the files written to the instance.
+	files []File
 }
 
-// Checksum returns the checksum of the config.
+// Checksum returns the checksum of the config. It covers the TLS material
+// unless the config refreshes it in place.
 func (c *Config) Checksum() string {
-	return fmt.Sprintf("%x", sha256.Sum256(c.raw))
+	return c.checksum
+}
+
+// SecretsChecksum returns the checksum of the TLS material of the config.
+func (c *Config) SecretsChecksum() string {
+	return c.secretsChecksum
 }
 
 // Raw returns the raw config.
@@ -46,6 +66,12 @@ func (c *Config) Raw() []byte {
 	return c.raw
 }
 
+// Files returns the files the config writes to the instance, including the
+// node config, its TLS material and the systemd unit of the node.
+func (c *Config) Files() []File {
+	return c.files
+}
+
 // Options are options for generating a cloud config.
 type Options struct {
 	// Image is the image to run.
@@ -58,45 +84,156 @@ type Options struct {
 	TLSKey []byte
 	// CA is the CA.
 	CA []byte
+	// DefaultGateway is true if the node is a default gateway for the mesh.
+	// Forwarding and masquerade rules for traffic leaving the mesh are
+	// installed on the instance.
+	DefaultGateway bool
+	// Files are additional files to write to the instance.
+	Files []File
+	// Env are environment variables passed to the node container. They hold
+	// the values of the node config's secret options.
+	Env map[string]string
+	// CommandEnv are environment variables passed to the node container that
+	// are set to the output of shell commands run when the instance is first
+	// booted, such as its addresses read from a metadata server.
+	CommandEnv map[string]string
+	// HostAliases are entries added to the hosts file of the instance.
+	HostAliases []HostAlias
+	// KeepFirewall keeps the firewall rules of the instance when the node
+	// starts. They are flushed by default, as instances run nothing but the
+	// node.
+	KeepFirewall bool
+	// DockerBridgeCIDR is the address and prefix length of the docker0
+	// bridge of the instance. Defaults to meshv1.DefaultDockerBridgeCIDR.
+	DockerBridgeCIDR string
+	// ExtraFiles are files written after those of the operator.
+	ExtraFiles []File
+	// ExtraPackages are packages installed after those of the operator.
+	ExtraPackages []string
+	// ExtraRunCmd are commands run after the node is started.
+	ExtraRunCmd []string
+	// Users are users created on the instance for SSH access, in addition
+	// to the default user of the image.
+	Users []User
+	// SecretsRefresh rewrites the TLS material of the running instance
+	// when it is renewed. The TLS material is then left out of the checksum
+	// of the config.
+	SecretsRefresh *SecretsRefresh
+	// SecretsFetch reads the TLS material from a secrets manager before
+	// the node is started, instead of writing it to the instance. TLSCert,
+	// TLSKey and CA are then ignored. It cannot be combined with
+	// SecretsRefresh.
+	SecretsFetch *SecretsFetch
+	// OSFamily is the operating system family of the instance. Container
+	// linux families are configured with Ignition, in which case
+	// ExtraPackages are ignored. Defaults to ubuntu.
+	OSFamily meshv1.OSFamily
+}
+
+// SecretsRefresh polls for renewed TLS material on an instance. When the
+// checksum printed by ChecksumCommand changes, the files are rewritten with
+// the output of their commands and the node is restarted.
+type SecretsRefresh struct {
+	// ChecksumCommand prints the checksum of the current TLS material.
+	ChecksumCommand string
+	// CertCommand prints the current TLS certificate.
+	CertCommand string
+	// KeyCommand prints the current TLS key.
+	KeyCommand string
+	// CACommand prints the current CA.
+	CACommand string
+	// Interval is how often the checksum is polled. Defaults to
+	// DefaultSecretsRefreshInterval.
+	Interval time.Duration
+}
+
+// DefaultSecretsRefreshInterval is how often instances poll for renewed TLS
+// material by default.
+const DefaultSecretsRefreshInterval = time.Minute
+
+// SecretsFetch reads the TLS material of the node on an instance. Each command
+// prints the content of its file. The commands are part of the checksum of the
+// config, so they should read pinned versions of the secrets for a new version
+// to recreate the instance.
+type SecretsFetch struct {
+	// CertCommand prints the TLS certificate.
+	CertCommand string
+	// KeyCommand prints the TLS key.
+	KeyCommand string
+	// CACommand prints the CA.
+	CACommand string
+}
+
+// User is a user created on an instance for SSH access.
+type User struct {
+	// Name is the name of the user.
+	Name string
+	// AuthorizedKeys are the SSH public keys allowed to log in as the user.
+	AuthorizedKeys []string
+	// Sudo grants the user sudo without a password.
+	Sudo bool
+}
+
+// HostAlias maps hostnames to an IP address in the hosts file of an instance.
+type HostAlias struct {
+	// IP is the IP address of the hostnames.
+	IP string
+	// Hostnames are the hostnames resolving to the IP address.
+	Hostnames []string
+}
+
+// File is an additional file written to an instance.
+type File struct {
+	// Path is the absolute path of the file.
+	Path string
+	// Permissions are the octal permissions of the file.
+	Permissions string
+	// Content is the content of the file.
+	Content []byte
+	// Append is true if the content is appended to the file instead of
+	// replacing it.
+	Append bool
 }
 
 // New returns a new cloud config.
 func New(opts Options) (*Config, error) {
+	if opts.SecretsFetch != nil && opts.SecretsRefresh != nil {
+		return nil, errors.New("fetched secrets cannot be refreshed")
+	}
 	out := cloudConfig{
 		WriteFiles: []writeFile{
 			{
-				Path:        "/etc/docker/daemon.json",
+				Path:        meshv1.InstanceDockerConfigPath,
 				Permissions: "0644",
 				Owner:       "root",
-				// TODO: Ensure this is compatible with the mesh network and VPC
-				Content: `{"bip": "192.168.254.1/24"}`,
+				Content:     dockerDaemonConfig(&opts),
 			},
 			{
-				Path:        "/etc/systemd/system/node.service",
+				Path:        nodeUnitPath,
 				Permissions: "0644",
 				Owner:       "root",
 				Content:     nodeContainerUnit(&opts),
 			},
 			{
-				Path:        "/etc/webmesh/config.yaml",
+				Path:        meshv1.DefaultConfigPath,
 				Permissions: "0644",
 				Owner:       "root",
 				Content:     string(opts.Config.Raw()),
 			},
 			{
-				Path:        fmt.Sprintf("%s/tls.crt", meshv1.DefaultTLSDirectory),
+				Path:        tlsCertPath,
 				Permissions: "0644",
 				Owner:       "root",
 				Content:     string(opts.TLSCert),
 			},
 			{
-				Path:        fmt.Sprintf("%s/tls.key", meshv1.DefaultTLSDirectory),
+				Path:        tlsKeyPath,
 				Permissions: "0644",
 				Owner:       "root",
 				Content:     string(opts.TLSKey),
 			},
 			{
-				Path:        fmt.Sprintf("%s/ca.crt", meshv1.DefaultTLSDirectory),
+				Path:        caPath,
 				Permissions: "0644",
 				Owner:       "root",
 				Content:     string(opts.CA),
@@ -120,57 +257,495 @@ func New(opts Options) (*Config, error) {
 			`echo "deb [arch=$(dpkg --print-architecture) signed-by=/etc/apt/keyrings/docker.gpg] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable" | tee /etc/apt/sources.list.d/docker.list > /dev/null`,
 			"apt-get update",
 			"apt-get install -y docker-ce docker-ce-cli containerd.io",
-			"mkdir -p /var/lib/webmesh/data",
+			"mkdir -p " + nodeDataDirectory,
 			"systemctl daemon-reload",
 			"systemctl enable docker",
 			"systemctl start docker",
 			"systemctl start node",
 		},
 	}
-	var buf bytes.Buffer
-	enc := yaml.NewEncoder(&buf)
-	enc.SetIndent(2)
-	err := enc.Encode(out)
+	if opts.SecretsFetch != nil {
+		// The TLS material never leaves the secrets manager in the
+		// config, the node unit fetches it before it starts
+		out.WriteFiles = slices.DeleteFunc(out.WriteFiles, func(file writeFile) bool {
+			_, ok := secretFiles[file.Path]
+			return ok
+		})
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        secretsFetchScriptPath,
+			Permissions: "0755",
+			Owner:       "root",
+			Content:     secretsFetchScript(opts.SecretsFetch),
+		})
+	}
+	if opts.DefaultGateway {
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        meshv1.InstanceGatewaySysctlPath,
+			Permissions: "0644",
+			Owner:       "root",
+			Content:     "net.ipv4.conf.all.forwarding=1\nnet.ipv6.conf.all.forwarding=1\n",
+		}, writeFile{
+			Path:        gatewayScriptPath,
+			Permissions: "0755",
+			Owner:       "root",
+			Content:     gatewayScript(&opts),
+		})
+	}
+	if len(opts.Env) > 0 {
+		// The env file is kept out of the config directory, which is
+		// mounted into the node container.
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        envFilePath,
+			Permissions: "0600",
+			Owner:       "root",
+			Content:     envFile(opts.Env),
+		})
+	}
+	if len(opts.CommandEnv) > 0 {
+		// Read before the node is started by the last command
+		start := out.RunCmd[len(out.RunCmd)-1]
+		out.RunCmd = append(append(out.RunCmd[:len(out.RunCmd)-1], commandEnvCommands(opts.CommandEnv)...), start)
+	}
+	if opts.SecretsRefresh != nil {
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        secretsChecksumPath,
+			Permissions: "0644",
+			Owner:       "root",
+			Content:     secretsChecksum(&opts) + "\n",
+		}, writeFile{
+			Path:        secretsRefreshScriptPath,
+			Permissions: "0755",
+			Owner:       "root",
+			Content:     secretsRefreshScript(opts.SecretsRefresh),
+		}, writeFile{
+			Path:        systemdUnitDirectory + secretsRefreshUnitName + ".service",
+			Permissions: "0644",
+			Owner:       "root",
+			Content:     secretsRefreshService,
+		}, writeFile{
+			Path:        systemdUnitDirectory + secretsRefreshUnitName + ".timer",
+			Permissions: "0644",
+			Owner:       "root",
+			Content:     secretsRefreshTimer(opts.SecretsRefresh),
+		})
+		start := out.RunCmd[len(out.RunCmd)-1]
+		out.RunCmd = append(out.RunCmd[:len(out.RunCmd)-1], "systemctl enable --now "+secretsRefreshUnitName+".timer", start)
+	}
+	if len(opts.HostAliases) > 0 {
+		// The node container shares the hosts file of the instance
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        meshv1.InstanceHostsPath,
+			Permissions: "0644",
+			Owner:       "root",
+			Append:      true,
+			Content:     hostsFile(opts.HostAliases),
+		})
+	}
+	for _, file := range opts.Files {
+		// Files may hold binary data, so they are always encoded
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        file.Path,
+			Permissions: file.Permissions,
+			Owner:       "root",
+			Encoding:    "b64",
+			Append:      file.Append,
+			Content:     base64.StdEncoding.EncodeToString(file.Content),
+		})
+	}
+	for _, file := range opts.ExtraFiles {
+		out.WriteFiles = append(out.WriteFiles, writeFile{
+			Path:        file.Path,
+			Permissions: file.Permissions,
+			Owner:       "root",
+			Append:      file.Append,
+			Content:     string(file.Content),
+		})
+	}
+	if len(opts.Users) > 0 {
+		// Listing users replaces the default user of the image unless
+		// it is listed first
+		out.Users = append(out.Users, "default")
+		for _, user := range opts.Users {
+			out.Users = append(out.Users, cloudUser(user))
+		}
+	}
+	out.Packages = append(out.Packages, opts.ExtraPackages...)
+	out.RunCmd = append(out.RunCmd, opts.ExtraRunCmd...)
+	files := make([]File, 0, len(out.WriteFiles))
+	for _, file := range out.WriteFiles {
+		content := []byte(file.Content)
+		if file.Encoding == "b64" {
+			content, _ = base64.StdEncoding.DecodeString(file.Content)
+		}
+		files = append(files, File{
+			Path:        file.Path,
+			Permissions: file.Permissions,
+			Content:     content,
+			Append:      file.Append,
+		})
+	}
+	encode := encodeCloudConfig
+	if opts.OSFamily.ContainerLinux() {
+		encode = func(out *cloudConfig) ([]byte, error) {
+			return encodeIgnition(&opts, out.WriteFiles)
+		}
+	}
+	raw, err := encode(&out)
+	if err != nil {
+		return nil, err
+	}
+	// The node config is checksummed in its canonical form, so changes to
+	// how it is marshalled do not recreate the instances.
+	out.WriteFiles[nodeConfigFile].Content = opts.Config.Checksum()
+	if opts.SecretsRefresh != nil {
+		// Renewed TLS material is written to the running instance
+		for i, file := range out.WriteFiles {
+			if _, ok := secretFiles[file.Path]; ok {
+				out.WriteFiles[i].Content = ""
+			}
+		}
+	}
+	canonical, err := encode(&out)
 	if err != nil {
 		return nil, err
 	}
 	return &Config{
-		raw: append([]byte("#cloud-config\n\n"), buf.Bytes()...),
+		raw:             raw,
+		checksum:        fmt.Sprintf("%x", sha256.Sum256(canonical)),
+		secretsChecksum: secretsChecksum(&opts),
+		files:           files,
 	}, nil
 }
 
+// nodeConfigFile is the index of the node config in the written files.
+const nodeConfigFile = 2
+
+// systemdUnitDirectory is the directory of the systemd units written to
+// instances.
+const systemdUnitDirectory = meshv1.InstanceSystemdUnitDirectory
+
+// nodeUnitPath is the path of the systemd unit running the node.
+const nodeUnitPath = systemdUnitDirectory + meshv1.InstanceNodeUnitName
+
+var (
+	tlsCertPath = fmt.Sprintf("%s/tls.crt", meshv1.DefaultTLSDirectory)
+	tlsKeyPath  = fmt.Sprintf("%s/tls.key", meshv1.DefaultTLSDirectory)
+	caPath      = fmt.Sprintf("%s/ca.crt", meshv1.DefaultTLSDirectory)
+)
+
+const (
+	secretsChecksumPath      = meshv1.InstanceSecretsChecksumPath
+	secretsRefreshScriptPath = meshv1.InstanceSecretsRefreshScriptPath
+	secretsRefreshUnitName   = meshv1.InstanceSecretsRefreshUnitName
+	secretsFetchScriptPath   = meshv1.InstanceSecretsFetchScriptPath
+)
+
+// secretFiles are the files holding the TLS material of the node, or derived
+// from it.
+var secretFiles = map[string]struct{}{
+	tlsCertPath:         {},
+	tlsKeyPath:          {},
+	caPath:              {},
+	secretsChecksumPath: {},
+}
+
+// secretsChecksum returns the checksum of the TLS material of the node.
+func secretsChecksum(opts *Options) string {
+	h := sha256.New()
+	for _, data := range [][]byte{opts.TLSCert, opts.TLSKey, opts.CA} {
+		fmt.Fprintf(h, "%d:", len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func encodeCloudConfig(out *cloudConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n\n"), buf.Bytes()...), nil
+}
+
 type cloudConfig struct {
 	WriteFiles []writeFile `yaml:"write_files"`
 	Packages   []string    `yaml:"packages"`
 	RunCmd     []string    `yaml:"runcmd"`
+	Users      []any       `yaml:"users,omitempty"`
+}
+
+type user struct {
+	Name              string   `yaml:"name"`
+	Shell             string   `yaml:"shell"`
+	LockPasswd        bool     `yaml:"lock_passwd"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+}
+
+// cloudUser returns the cloud-init entry of a user. Users log in with their
+// keys only.
+func cloudUser(u User) user {
+	out := user{
+		Name:              u.Name,
+		Shell:             "/bin/bash",
+		LockPasswd:        true,
+		SSHAuthorizedKeys: u.AuthorizedKeys,
+	}
+	if u.Sudo {
+		out.Sudo = "ALL=(ALL) NOPASSWD:ALL"
+	}
+	return out
 }
 
 type writeFile struct {
 	Path        string `yaml:"path"`
 	Permissions string `yaml:"permissions"`
 	Owner       string `yaml:"owner"`
+	Encoding    string `yaml:"encoding,omitempty"`
+	Append      bool   `yaml:"append,omitempty"`
 	Content     string `yaml:"content"`
 }
 
+// dockerDaemonConfig returns the config of the docker daemon running the node.
+func dockerDaemonConfig(opts *Options) string {
+	bip := opts.DockerBridgeCIDR
+	if bip == "" {
+		bip = meshv1.DefaultDockerBridgeCIDR
+	}
+	return fmt.Sprintf(`{"bip": %q}`, bip)
+}
+
 func nodeContainerUnit(opts *Options) string {
 	var buf bytes.Buffer
 	_ = nodeContainerUnitTemplate.Execute(&buf, struct {
-		Image   string
-		DataDir string
+		Image         string
+		HostDataDir   string
+		DataDir       string
+		ConfigDir     string
+		ConfigPath    string
+		GatewayScript string
+		EnvFile       string
+		CommandEnv    string
+		SecretsFetch  string
+		FlushRuleset  bool
 	}{
-		Image:   opts.Image,
-		DataDir: opts.Config.Options.Raft.DataDir,
+		Image:       opts.Image,
+		HostDataDir: nodeDataDirectory,
+		DataDir:     opts.Config.Options.Raft.DataDir,
+		ConfigDir:   meshv1.DefaultConfigDirectory,
+		ConfigPath:  meshv1.DefaultConfigPath,
+		GatewayScript: func() string {
+			if opts.DefaultGateway {
+				return gatewayScriptPath
+			}
+			return ""
+		}(),
+		EnvFile: func() string {
+			if len(opts.Env) > 0 {
+				return envFilePath
+			}
+			return ""
+		}(),
+		CommandEnv: func() string {
+			if len(opts.CommandEnv) > 0 {
+				return commandEnvFilePath
+			}
+			return ""
+		}(),
+		SecretsFetch: func() string {
+			if opts.SecretsFetch != nil {
+				return secretsFetchScriptPath
+			}
+			return ""
+		}(),
+		FlushRuleset: !opts.KeepFirewall,
 	})
 	return buf.String()
 }
 
+const envFilePath = meshv1.InstanceEnvPath
+
+func envFile(env map[string]string) string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=%s\n", name, env[name])
+	}
+	return buf.String()
+}
+
+const commandEnvFilePath = meshv1.InstanceCommandEnvPath
+
+// commandEnvCommands returns the commands writing the output of the commands of
+// the given environment variables to the env file read by the node container.
+func commandEnvCommands(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cmds := make([]string, 0, len(names))
+	for _, name := range names {
+		cmds = append(cmds, fmt.Sprintf(`echo "%s=$(%s)" >> %s`, name, env[name], commandEnvFilePath))
+	}
+	return cmds
+}
+
+func hostsFile(aliases []HostAlias) string {
+	var buf strings.Builder
+	buf.WriteString("# Added by the webmesh operator\n")
+	for _, alias := range aliases {
+		fmt.Fprintf(&buf, "%s %s\n", alias.IP, strings.Join(alias.Hostnames, " "))
+	}
+	return buf.String()
+}
+
+const gatewayScriptPath = meshv1.DefaultConfigDirectory + "/gateway.sh"
+
+func gatewayScript(opts *Options) string {
+	var buf bytes.Buffer
+	_ = gatewayScriptTemplate.Execute(&buf, struct {
+		Interface string
+		IPv6      bool
+	}{
+		Interface: opts.Config.Options.WireGuard.InterfaceName,
+		IPv6:      !opts.Config.Options.Global.DisableIPv6,
+	})
+	return buf.String()
+}
+
+// The node unit flushes the ruleset before starting, so the rules are
+// (re)applied after every start.
+var gatewayScriptTemplate = template.Must(template.New("gateway").Parse(`#!/bin/sh
+ensure() {
+  cmd=$1; shift
+  $cmd -C "$@" 2>/dev/null || $cmd -A "$@"
+}
+ensure iptables FORWARD -i {{ .Interface }} -j ACCEPT
+ensure iptables FORWARD -o {{ .Interface }} -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+ensure "iptables -t nat" POSTROUTING ! -o {{ .Interface }} -j MASQUERADE
+{{- if .IPv6 }}
+ensure ip6tables FORWARD -i {{ .Interface }} -j ACCEPT
+ensure ip6tables FORWARD -o {{ .Interface }} -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+ensure "ip6tables -t nat" POSTROUTING ! -o {{ .Interface }} -j MASQUERADE
+{{- end }}
+`))
+
+// secretsRefreshScript returns the script rewriting the TLS material of the
+// node when its checksum changes. The files are replaced together once all of
+// them are read.
+func secretsRefreshScript(refresh *SecretsRefresh) string {
+	var buf bytes.Buffer
+	_ = secretsRefreshTemplate.Execute(&buf, struct {
+		*SecretsRefresh
+		Stamp string
+		Files []struct{ Path, Command string }
+	}{
+		SecretsRefresh: refresh,
+		Stamp:          secretsChecksumPath,
+		Files: []struct{ Path, Command string }{
+			{tlsCertPath, refresh.CertCommand},
+			{tlsKeyPath, refresh.KeyCommand},
+			{caPath, refresh.CACommand},
+		},
+	})
+	return buf.String()
+}
+
+var secretsRefreshTemplate = template.Must(template.New("secretsrefresh").Parse(`#!/bin/sh
+set -e
+checksum=$({{ .ChecksumCommand }})
+if [ -z "$checksum" ] || [ "$checksum" = "$(cat {{ .Stamp }} 2>/dev/null)" ]; then
+  exit 0
+fi
+{{- range .Files }}
+{{ .Command }} > {{ .Path }}.new
+{{- end }}
+{{- range .Files }}
+mv {{ .Path }}.new {{ .Path }}
+{{- end }}
+systemctl restart node
+echo "$checksum" > {{ .Stamp }}
+`))
+
+// secretsFetchScript returns the script writing the TLS material of the node
+// from the output of the fetch commands. The files are only replaced once all
+// of them are read, and an empty output fails the node unit, which is then
+// restarted.
+func secretsFetchScript(fetch *SecretsFetch) string {
+	var buf bytes.Buffer
+	_ = secretsFetchTemplate.Execute(&buf, struct {
+		Dir   string
+		Files []struct{ Path, Command string }
+	}{
+		Dir: meshv1.DefaultTLSDirectory,
+		Files: []struct{ Path, Command string }{
+			{tlsCertPath, fetch.CertCommand},
+			{tlsKeyPath, fetch.KeyCommand},
+			{caPath, fetch.CACommand},
+		},
+	})
+	return buf.String()
+}
+
+var secretsFetchTemplate = template.Must(template.New("secretsfetch").Parse(`#!/bin/sh
+set -e
+umask 077
+mkdir -p {{ .Dir }}
+{{- range .Files }}
+{{ .Command }} > {{ .Path }}.new
+test -s {{ .Path }}.new
+{{- end }}
+{{- range .Files }}
+mv {{ .Path }}.new {{ .Path }}
+{{- end }}
+`))
+
+const secretsRefreshService = `[Unit]
+Description=Refresh the TLS material of the node
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh ` + secretsRefreshScriptPath + `
+`
+
+func secretsRefreshTimer(refresh *SecretsRefresh) string {
+	interval := refresh.Interval
+	if interval <= 0 {
+		interval = DefaultSecretsRefreshInterval
+	}
+	return fmt.Sprintf(`[Unit]
+Description=Poll for renewed TLS material of the node
+
+[Timer]
+OnBootSec=%[1]ds
+OnUnitActiveSec=%[1]ds
+
+[Install]
+WantedBy=timers.target
+`, int(interval.Seconds()))
+}
+
 var nodeContainerUnitTemplate = template.Must(template.New("nodecontainer").Parse(`[Unit]
 Description=node
 After=docker.service
 Wants=docker.service
 
 [Service]
+{{- if .FlushRuleset }}
 ExecStartPre=-/usr/sbin/nft flush ruleset
+{{- end }}
+{{- if .SecretsFetch }}
+ExecStartPre=/bin/sh {{ .SecretsFetch }}
+{{- end }}
 ExecStart=/usr/bin/docker run --rm \
   --pull always \
   --name node \
@@ -181,9 +756,18 @@ ExecStart=/usr/bin/docker run --rm \
   --cap-add SYS_MODULE \
   -v /lib/modules:/lib/modules \
   -v /dev/net/tun:/dev/net/tun \
-  -v /etc/webmesh:/etc/webmesh \
-  -v /var/lib/webmesh/data:{{ .DataDir }} \
-  {{ .Image }} --config /etc/webmesh/config.yaml
+  -v {{ .ConfigDir }}:{{ .ConfigDir }} \
+  -v {{ .HostDataDir }}:{{ .DataDir }} \
+{{- if .EnvFile }}
+  --env-file {{ .EnvFile }} \
+{{- end }}
+{{- if .CommandEnv }}
+  --env-file {{ .CommandEnv }} \
+{{- end }}
+  {{ .Image }} --config {{ .ConfigPath }}
+{{- if .GatewayScript }}
+ExecStartPost=-/bin/sh {{ .GatewayScript }}
+{{- end }}
 ExecStop=/usr/bin/docker kill node
 Restart=always
 
@@ -0,0 +1,177 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+// TestManagedPaths fails when the configs write a path that NodeGroups are
+// still allowed to write files of their own to.
+func TestManagedPaths(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"}}
+	nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userFile := File{Path: "/opt/user.conf", Content: []byte("user")}
+	base := Options{
+		Image:          "ghcr.io/webmeshproj/node:latest",
+		Config:         nodeconf,
+		DefaultGateway: true,
+		Files:          []File{userFile},
+		ExtraFiles:     []File{userFile},
+		Env:            map[string]string{"SECRET": "value"},
+		CommandEnv:     map[string]string{"ADDRESS": "hostname -I"},
+		HostAliases:    []HostAlias{{IP: "203.0.113.1", Hostnames: []string{"join"}}},
+		ExtraRunCmd:    []string{"true"},
+		Users:          []User{{Name: "ops", Sudo: true}},
+	}
+	refresh, fetch := base, base
+	refresh.SecretsRefresh = &SecretsRefresh{ChecksumCommand: "true", CertCommand: "true", KeyCommand: "true", CACommand: "true"}
+	fetch.SecretsFetch = &SecretsFetch{CertCommand: "true", KeyCommand: "true", CACommand: "true"}
+
+	for _, opts := range []Options{refresh, fetch} {
+		for _, family := range []meshv1.OSFamily{meshv1.OSFamilyUbuntu, meshv1.OSFamilyFlatcar} {
+			opts.OSFamily = family
+			conf, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			if family.ContainerLinux() {
+				var out ignitionConfig
+				if err := json.Unmarshal(conf.Raw(), &out); err != nil {
+					t.Fatal(err)
+				}
+				for _, dir := range out.Storage.Directories {
+					paths = append(paths, dir.Path)
+				}
+				for _, file := range out.Storage.Files {
+					paths = append(paths, file.Path)
+				}
+				for _, unit := range out.Systemd.Units {
+					if unit.Contents != "" {
+						paths = append(paths, systemdUnitDirectory+unit.Name)
+					}
+					for _, dropin := range unit.Dropins {
+						paths = append(paths, systemdUnitDirectory+unit.Name+".d/"+dropin.Name)
+					}
+				}
+			} else {
+				var out cloudConfig
+				if err := yaml.Unmarshal(bytes.TrimPrefix(conf.Raw(), []byte("#cloud-config")), &out); err != nil {
+					t.Fatal(err)
+				}
+				for _, file := range out.WriteFiles {
+					paths = append(paths, file.Path)
+				}
+				for _, cmd := range out.RunCmd {
+					if _, target, ok := strings.Cut(cmd, " >> "); ok {
+						paths = append(paths, target)
+					}
+				}
+			}
+			for _, p := range paths {
+				if p == userFile.Path {
+					continue
+				}
+				if !meshv1.IsCloudConfigManagedPath(p) {
+					t.Errorf("%s: %s is written by the config but not a managed path", family, p)
+				}
+			}
+		}
+	}
+}
+
+func TestGatewayScript(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	for _, tt := range []struct {
+		name    string
+		gateway bool
+		noIPv6  bool
+	}{
+		{name: "not a gateway"},
+		{name: "gateway", gateway: true},
+		{name: "gateway without IPv6", gateway: true, noIPv6: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec:       meshv1.NodeGroupSpec{Config: &meshv1.NodeGroupConfig{NoIPv6: tt.noIPv6}},
+			}
+			nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf, err := New(Options{
+				Image:          "ghcr.io/webmeshproj/node:latest",
+				Config:         nodeconf,
+				DefaultGateway: tt.gateway,
+				OSFamily:       meshv1.OSFamilyUbuntu,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out cloudConfig
+			if err := yaml.Unmarshal(bytes.TrimPrefix(conf.Raw(), []byte("#cloud-config")), &out); err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{}
+			for _, file := range out.WriteFiles {
+				files[file.Path] = file.Content
+			}
+			script, ok := files[gatewayScriptPath]
+			if ok != tt.gateway {
+				t.Fatalf("expected the gateway script to be written %v, got %v", tt.gateway, ok)
+			}
+			if _, ok := files[meshv1.InstanceGatewaySysctlPath]; ok != tt.gateway {
+				t.Errorf("expected forwarding to be enabled %v, got %v", tt.gateway, ok)
+			}
+			// The rules are applied again after every start of the node
+			unit := files[meshv1.InstanceSystemdUnitDirectory+meshv1.InstanceNodeUnitName]
+			if strings.Contains(unit, "ExecStartPost=-/bin/sh "+gatewayScriptPath) != tt.gateway {
+				t.Errorf("expected the node unit to run the gateway script %v, got:\n%s", tt.gateway, unit)
+			}
+			if !tt.gateway {
+				return
+			}
+			iface := nodeconf.Options.WireGuard.InterfaceName
+			for _, rule := range []string{
+				"ensure iptables FORWARD -i " + iface + " -j ACCEPT",
+				`ensure "iptables -t nat" POSTROUTING ! -o ` + iface + " -j MASQUERADE",
+			} {
+				if !strings.Contains(script, rule) {
+					t.Errorf("expected rule %q in the script:\n%s", rule, script)
+				}
+			}
+			if strings.Contains(script, "ip6tables") == tt.noIPv6 {
+				t.Errorf("expected IPv6 rules %v in the script:\n%s", !tt.noIPv6, script)
+			}
+		})
+	}
+}
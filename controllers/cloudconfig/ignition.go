@@ -0,0 +1,247 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// ignitionVersion is the version of the Ignition spec of rendered configs.
+// It is supported by both Flatcar and Fedora CoreOS.
+const ignitionVersion = "3.3.0"
+
+const (
+	forwardingSysctlPath   = meshv1.InstanceForwardingSysctlPath
+	commandEnvScriptPath   = meshv1.InstanceCommandEnvScriptPath
+	extraRunCmdScriptPath  = meshv1.InstanceRunCmdScriptPath
+	extraRunCmdStampPath   = meshv1.InstanceRunCmdStampPath
+	commandEnvUnitName     = meshv1.InstanceCommandEnvUnitName
+	extraRunCmdUnitName    = meshv1.InstanceRunCmdUnitName
+	nodeDataDirectory      = meshv1.InstanceDataDirectory
+	defaultFilePermissions = "0644"
+)
+
+type ignitionConfig struct {
+	Ignition ignitionMeta    `json:"ignition"`
+	Passwd   *ignitionPasswd `json:"passwd,omitempty"`
+	Storage  ignitionStorage `json:"storage"`
+	Systemd  ignitionSystemd `json:"systemd"`
+}
+
+type ignitionMeta struct {
+	Version string `json:"version"`
+}
+
+type ignitionPasswd struct {
+	Users []ignitionUser `json:"users"`
+}
+
+type ignitionUser struct {
+	Name              string   `json:"name"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+type ignitionStorage struct {
+	Directories []ignitionDirectory `json:"directories,omitempty"`
+	Files       []ignitionFile      `json:"files,omitempty"`
+}
+
+type ignitionDirectory struct {
+	Path string `json:"path"`
+	Mode int    `json:"mode"`
+}
+
+type ignitionFile struct {
+	Path      string             `json:"path"`
+	Mode      int                `json:"mode"`
+	Overwrite *bool              `json:"overwrite,omitempty"`
+	Contents  *ignitionResource  `json:"contents,omitempty"`
+	Append    []ignitionResource `json:"append,omitempty"`
+}
+
+type ignitionResource struct {
+	Source string `json:"source"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units"`
+}
+
+type ignitionUnit struct {
+	Name     string           `json:"name"`
+	Enabled  *bool            `json:"enabled,omitempty"`
+	Contents string           `json:"contents,omitempty"`
+	Dropins  []ignitionDropin `json:"dropins,omitempty"`
+}
+
+type ignitionDropin struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+// encodeIgnition renders the files of a cloud config as an Ignition config
+// for container linux instances. Docker ships with the images, so nothing is
+// installed. Unit files become systemd units, enabled if they can be, so the
+// node is started without the commands of the cloud config. Commands are run
+// by oneshot units.
+func encodeIgnition(opts *Options, files []writeFile) ([]byte, error) {
+	enabled := true
+	out := ignitionConfig{
+		Ignition: ignitionMeta{Version: ignitionVersion},
+		Storage: ignitionStorage{
+			Directories: []ignitionDirectory{{Path: nodeDataDirectory, Mode: 0o755}},
+		},
+	}
+	var units []ignitionUnit
+	for _, file := range files {
+		content := []byte(file.Content)
+		if file.Encoding == "b64" {
+			var err error
+			content, err = base64.StdEncoding.DecodeString(file.Content)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s: %w", file.Path, err)
+			}
+		}
+		if dir, name := path.Split(file.Path); dir == systemdUnitDirectory {
+			unit := ignitionUnit{Name: name, Contents: string(content)}
+			if strings.Contains(unit.Contents, "[Install]") {
+				unit.Enabled = &enabled
+			}
+			units = append(units, unit)
+			continue
+		}
+		f, err := newIgnitionFile(file.Path, file.Permissions, content, file.Append)
+		if err != nil {
+			return nil, err
+		}
+		out.Storage.Files = append(out.Storage.Files, f)
+	}
+	// Set with sysctl by the commands of cloud configs
+	forwarding, _ := newIgnitionFile(forwardingSysctlPath, defaultFilePermissions,
+		[]byte("net.ipv4.conf.all.forwarding=1\nnet.ipv6.conf.all.forwarding=1\n"), false)
+	out.Storage.Files = append(out.Storage.Files, forwarding)
+	out.Systemd.Units = append(out.Systemd.Units, ignitionUnit{Name: "docker.service", Enabled: &enabled})
+	if len(opts.CommandEnv) > 0 {
+		// The env file is appended to, so it is only written once
+		script, _ := newIgnitionFile(commandEnvScriptPath, "0755", shellScript(commandEnvCommands(opts.CommandEnv)), false)
+		out.Storage.Files = append(out.Storage.Files, script)
+		out.Systemd.Units = append(out.Systemd.Units, ignitionUnit{
+			Name:    commandEnvUnitName,
+			Enabled: &enabled,
+			Contents: oneshotUnit("webmesh instance environment", "network-online.target",
+				commandEnvFilePath, commandEnvScriptPath),
+		})
+		for i := range units {
+			if units[i].Name == path.Base(nodeUnitPath) {
+				units[i].Dropins = append(units[i].Dropins, ignitionDropin{
+					Name:     "10-instance-env.conf",
+					Contents: fmt.Sprintf("[Unit]\nRequires=%[1]s\nAfter=%[1]s\n", commandEnvUnitName),
+				})
+			}
+		}
+	}
+	out.Systemd.Units = append(out.Systemd.Units, units...)
+	if len(opts.ExtraRunCmd) > 0 {
+		cmds := append(append([]string{}, opts.ExtraRunCmd...), "touch "+extraRunCmdStampPath)
+		script, _ := newIgnitionFile(extraRunCmdScriptPath, "0755", shellScript(cmds), false)
+		out.Storage.Files = append(out.Storage.Files, script)
+		out.Systemd.Units = append(out.Systemd.Units, ignitionUnit{
+			Name:     extraRunCmdUnitName,
+			Enabled:  &enabled,
+			Contents: oneshotUnit("webmesh extra commands", "node.service", extraRunCmdStampPath, extraRunCmdScriptPath),
+		})
+	}
+	if len(opts.Users) > 0 {
+		out.Passwd = &ignitionPasswd{}
+		for _, user := range opts.Users {
+			out.Passwd.Users = append(out.Passwd.Users, ignitionUserFor(opts.OSFamily, user))
+		}
+	}
+	return json.Marshal(&out)
+}
+
+// newIgnitionFile returns the Ignition entry of a file with the given octal
+// permissions.
+func newIgnitionFile(path, permissions string, content []byte, appendContent bool) (ignitionFile, error) {
+	if permissions == "" {
+		permissions = defaultFilePermissions
+	}
+	mode, err := strconv.ParseUint(permissions, 8, 32)
+	if err != nil {
+		return ignitionFile{}, fmt.Errorf("parse permissions of %s: %w", path, err)
+	}
+	source := ignitionResource{Source: "data:;base64," + base64.StdEncoding.EncodeToString(content)}
+	file := ignitionFile{Path: path, Mode: int(mode)}
+	if appendContent {
+		file.Append = []ignitionResource{source}
+		return file, nil
+	}
+	overwrite := true
+	file.Overwrite = &overwrite
+	file.Contents = &source
+	return file, nil
+}
+
+// ignitionUserFor returns the Ignition entry of a user. Users in the admin
+// group of the family may use sudo without a password.
+func ignitionUserFor(family meshv1.OSFamily, u User) ignitionUser {
+	out := ignitionUser{
+		Name:              u.Name,
+		SSHAuthorizedKeys: u.AuthorizedKeys,
+	}
+	if u.Sudo {
+		switch family {
+		case meshv1.OSFamilyFedoraCoreOS:
+			out.Groups = []string{"wheel"}
+		default:
+			out.Groups = []string{"sudo"}
+		}
+	}
+	return out
+}
+
+// shellScript returns a script running the given commands in order.
+func shellScript(cmds []string) []byte {
+	return []byte("#!/bin/sh\nset -e\n" + strings.Join(cmds, "\n") + "\n")
+}
+
+// oneshotUnit returns a unit running the script once after the given unit,
+// skipped once the given path exists.
+func oneshotUnit(description, after, unless, script string) string {
+	return fmt.Sprintf(`[Unit]
+Description=%s
+Wants=%[2]s
+After=%[2]s
+ConditionPathExists=!%s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh %s
+
+[Install]
+WantedBy=multi-user.target
+`, description, after, unless, script)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+func TestCustomClusterDomain(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	objs, err := inspect.Render(mesh, nil, inspect.RenderOptions{ClusterDomain: "k8s.example."})
+	if err != nil {
+		t.Fatalf("render mesh: %v", err)
+	}
+	bootstrap := mesh.BootstrapGroups()[0]
+	fqdn := meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, bootstrap, "k8s.example")
+	if !strings.HasSuffix(fqdn, ".default.svc.k8s.example") {
+		t.Fatalf("expected the headless service in the cluster domain, got %s", fqdn)
+	}
+
+	// Certificates and node configs only use names in the cluster domain
+	var certs []*certv1.Certificate
+	var config string
+	for _, obj := range objs {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatalf("marshal %T: %v", obj, err)
+		}
+		if strings.Contains(string(data), "cluster.local") {
+			t.Errorf("%T %s refers to the default cluster domain", obj, obj.GetName())
+		}
+		switch o := obj.(type) {
+		case *certv1.Certificate:
+			if o.Spec.SecretName != "" && strings.HasPrefix(o.GetName(), meshv1.MeshNodeGroupStatefulSetName(mesh, bootstrap)) {
+				certs = append(certs, o)
+			}
+		case *corev1.ConfigMap:
+			if o.GetName() == meshv1.MeshNodeGroupConfigMapName(mesh, bootstrap) {
+				config = o.Data[meshv1.ConfigFileName]
+			}
+		}
+	}
+	if !strings.Contains(config, "."+fqdn+":") {
+		t.Errorf("expected the node config to use the cluster domain, got:\n%s", config)
+	}
+	if len(certs) != int(bootstrap.Replicas()) {
+		t.Fatalf("expected a certificate for each bootstrap node, got %d", len(certs))
+	}
+
+	// Configs for wmctl verify the node names once the certificates are issued
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	var secrets []client.Object
+	for _, cert := range certs {
+		secrets = append(secrets, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: cert.Spec.SecretName, Namespace: "default"},
+			Data:       map[string][]byte{corev1.TLSCertKey: issueTestCertificate(t, cert.Spec.DNSNames)},
+		})
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secrets...).Build()
+	verifyChainOnly, pending, err := ctlVerifyChainOnly(context.Background(), cli, mesh, bootstrap, fqdn)
+	if err != nil {
+		t.Fatalf("check node certificates: %v", err)
+	}
+	if verifyChainOnly || pending {
+		t.Errorf("expected the certificates to cover %s", fqdn)
+	}
+}
+
+func TestClusterDomainFromSettings(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	mesh.Default()
+	bootstrap := mesh.BootstrapGroups()[0]
+	r := &NodeGroupReconciler{}
+	// Reconciles only see the domain of their own snapshot, so a change to
+	// the operator config does not affect reconciles already running
+	for _, domain := range []string{"k8s.example", "cluster.example"} {
+		ctx := operatorconfig.WithSettings(context.Background(), &operatorconfig.Settings{ClusterDomain: domain})
+		conf, err := r.buildClusterNodeConfig(ctx, mesh, bootstrap, nil, nil)
+		if err != nil {
+			t.Fatalf("build node config: %v", err)
+		}
+		fqdn := meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, bootstrap, domain)
+		if raw := string(conf.Raw()); !strings.Contains(raw, "."+fqdn+":") {
+			t.Errorf("expected the node config to use %s, got:\n%s", fqdn, raw)
+		}
+	}
+}
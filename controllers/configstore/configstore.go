@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configstore contains backends for storing generated mesh configs.
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// ErrNotFound is returned when a config does not exist in a store.
+var ErrNotFound = errors.New("config not found")
+
+// Store is a backend for storing generated configs for a mesh.
+type Store interface {
+	// Put writes the config with the given name.
+	Put(ctx context.Context, name string, data map[string][]byte) error
+	// Get reads the config with the given name.
+	Get(ctx context.Context, name string) (map[string][]byte, error)
+	// Delete removes the config with the given name. It is not an error
+	// if the config does not exist.
+	Delete(ctx context.Context, name string) error
+}
+
+// AnnotatedStore is implemented by stores that keep annotations with the
+// configs they write.
+type AnnotatedStore interface {
+	Store
+	// PutAnnotated writes the config with the given name and annotations.
+	PutAnnotated(ctx context.Context, name string, data map[string][]byte, annotations map[string]string) error
+}
+
+// New returns the store configured for the given mesh. Vault is logged in to
+// with the service account token of the operator and the role of the mesh.
+func New(ctx context.Context, cli client.Client, mesh *meshv1.Mesh) (Store, error) {
+	return NewWithVaultLogin(ctx, cli, mesh, VaultLogin{ServiceAccountToken: InClusterServiceAccountToken})
+}
+
+// NewWithVaultLogin returns the store configured for the given mesh. Vault is
+// logged in to with the given credentials.
+func NewWithVaultLogin(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, login VaultLogin) (Store, error) {
+	switch mesh.Spec.AdminConfig.Store {
+	case "", meshv1.AdminConfigStoreKubernetesSecret:
+		return NewKubernetesSecretStore(cli, mesh), nil
+	case meshv1.AdminConfigStoreVault:
+		if mesh.Spec.AdminConfig.Vault == nil {
+			return nil, fmt.Errorf("vault store requires vault configuration")
+		}
+		store, err := NewVaultStore(ctx, cli, mesh, login)
+		if err != nil {
+			return nil, err
+		}
+		if resources.IsDryRun(ctx) {
+			// Secrets are written with a server-side dry run, vault
+			// has no equivalent
+			return &dryRunStore{Store: store}, nil
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unsupported config store: %s", mesh.Spec.AdminConfig.Store)
+	}
+}
+
+// dryRunStore reads from a store but only logs the writes it would make.
+type dryRunStore struct {
+	Store
+}
+
+// Put implements Store.
+func (s *dryRunStore) Put(ctx context.Context, name string, data map[string][]byte) error {
+	log.FromContext(ctx).Info("Dry run, not going to write config", "name", name)
+	return nil
+}
+
+// Delete implements Store.
+func (s *dryRunStore) Delete(ctx context.Context, name string) error {
+	log.FromContext(ctx).Info("Dry run, not going to delete config", "name", name)
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configstore
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// KubernetesSecretStore stores configs in Secrets owned by the Mesh.
+type KubernetesSecretStore struct {
+	cli  client.Client
+	mesh *meshv1.Mesh
+}
+
+// NewKubernetesSecretStore returns a store that writes configs to Secrets
+// in the namespace of the given mesh.
+func NewKubernetesSecretStore(cli client.Client, mesh *meshv1.Mesh) *KubernetesSecretStore {
+	return &KubernetesSecretStore{cli: cli, mesh: mesh}
+}
+
+// Put implements Store.
+func (s *KubernetesSecretStore) Put(ctx context.Context, name string, data map[string][]byte) error {
+	return s.PutAnnotated(ctx, name, data, nil)
+}
+
+// PutAnnotated implements AnnotatedStore. The annotations are set in addition
+// to those propagated from the mesh.
+func (s *KubernetesSecretStore) PutAnnotated(ctx context.Context, name string, data map[string][]byte, annotations map[string]string) error {
+	merged := make(map[string]string, len(annotations))
+	for k, v := range meshv1.MeshAnnotations(s.mesh) {
+		merged[k] = v
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	return resources.Apply(ctx, s.cli, []client.Object{&corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       s.mesh.GetNamespace(),
+			Labels:          meshv1.MeshLabels(s.mesh),
+			Annotations:     merged,
+			OwnerReferences: meshv1.OwnerReferences(s.mesh),
+		},
+		Data: data,
+	}})
+}
+
+// Get implements Store.
+func (s *KubernetesSecretStore) Get(ctx context.Context, name string) (map[string][]byte, error) {
+	var secret corev1.Secret
+	err := s.cli.Get(ctx, client.ObjectKey{
+		Name:      name,
+		Namespace: s.mesh.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// Delete implements Store.
+func (s *KubernetesSecretStore) Delete(ctx context.Context, name string) error {
+	return client.IgnoreNotFound(s.cli.Delete(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.mesh.GetNamespace(),
+		},
+	}))
+}
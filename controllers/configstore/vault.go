@@ -0,0 +1,312 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// serviceAccountTokenPath is the path to the token used to authenticate
+// with the Vault Kubernetes auth method.
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// ServiceAccountToken returns the service account token to log in to Vault
+// with.
+type ServiceAccountToken func(ctx context.Context) (string, error)
+
+// InClusterServiceAccountToken reads the service account token mounted into
+// the pod of the operator.
+func InClusterServiceAccountToken(ctx context.Context) (string, error) {
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return "", fmt.Errorf("read service account token: %w", err)
+	}
+	return strings.TrimSpace(string(jwt)), nil
+}
+
+// VaultLogin are the credentials a VaultStore logs in with.
+type VaultLogin struct {
+	// Token is a Vault token used as is, instead of logging in.
+	Token string
+	// Role is the role of the Kubernetes auth method to log in with.
+	// Defaults to the role of the mesh, which is bound to the operator.
+	Role string
+	// ServiceAccountToken returns the service account token to log in to
+	// the role with.
+	ServiceAccountToken ServiceAccountToken
+}
+
+// role returns the role to log in with for the given config.
+func (l VaultLogin) role(cfg *meshv1.VaultStoreConfig) string {
+	if l.Role != "" {
+		return l.Role
+	}
+	return cfg.Role
+}
+
+// vaultTokenRenewFraction is the fraction of the lease of a Vault token after
+// which the operator logs in again.
+const vaultTokenRenewFraction = 0.8
+
+// vaultTokens caches the tokens the operator logged in to Vault with, so
+// reconciles reuse them until they are about to expire.
+var vaultTokens = &vaultTokenCache{tokens: make(map[string]vaultToken)}
+
+// vaultTokenCache is a cache of Vault tokens keyed by the server, auth mount
+// and role they were issued for.
+type vaultTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]vaultToken
+}
+
+// vaultToken is a cached Vault token. A zero expiry never expires.
+type vaultToken struct {
+	token   string
+	expires time.Time
+}
+
+func vaultTokenKey(cfg *meshv1.VaultStoreConfig, role string) string {
+	return strings.Join([]string{strings.TrimSuffix(cfg.Address, "/"), cfg.AuthMount, role}, "|")
+}
+
+// get returns the cached token for the key, if it has not expired.
+func (c *vaultTokenCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.tokens[key]
+	if !ok || (!cached.expires.IsZero() && !time.Now().Before(cached.expires)) {
+		return "", false
+	}
+	return cached.token, true
+}
+
+// put caches the token for the key with the given lease.
+func (c *vaultTokenCache) put(key, token string, lease time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached := vaultToken{token: token}
+	if lease > 0 {
+		cached.expires = time.Now().Add(time.Duration(float64(lease) * vaultTokenRenewFraction))
+	}
+	c.tokens[key] = cached
+}
+
+// forget drops the token for the key if it is still the cached one.
+func (c *vaultTokenCache) forget(key, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens[key].token == token {
+		delete(c.tokens, key)
+	}
+}
+
+// VaultStore stores configs in a Vault KV v2 secrets engine.
+type VaultStore struct {
+	cfg   *meshv1.VaultStoreConfig
+	http  *http.Client
+	token string
+	// cacheKey is the key of the token in vaultTokens, empty for tokens
+	// given by the caller.
+	cacheKey string
+}
+
+// NewVaultStore returns a store that writes configs to Vault. Unless given a
+// Vault token, it logs in with the service account token and role of the
+// login, and the Vault token is reused by later stores for the same server
+// and role until its lease nearly ran out.
+func NewVaultStore(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, login VaultLogin) (*VaultStore, error) {
+	cfg := mesh.Spec.AdminConfig.Vault
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CABundle != nil {
+		var secret corev1.Secret
+		err := cli.Get(ctx, client.ObjectKey{
+			Name:      cfg.CABundle.Name,
+			Namespace: mesh.GetNamespace(),
+		}, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("get vault CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(secret.Data[cfg.CABundle.Key]) {
+			return nil, fmt.Errorf("no certificates found in key %s of secret %s", cfg.CABundle.Key, cfg.CABundle.Name)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	s := &VaultStore{
+		cfg:  cfg,
+		http: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}
+	if login.Token != "" {
+		s.token = login.Token
+		return s, nil
+	}
+	if login.ServiceAccountToken == nil {
+		return nil, fmt.Errorf("vault login requires a token or a service account token")
+	}
+	role := login.role(cfg)
+	s.cacheKey = vaultTokenKey(cfg, role)
+	if token, ok := vaultTokens.get(s.cacheKey); ok {
+		s.token = token
+		return s, nil
+	}
+	jwt, err := login.ServiceAccountToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	err = s.do(ctx, http.MethodPost, fmt.Sprintf("auth/%s/login", cfg.AuthMount), map[string]any{
+		"role": role,
+		"jwt":  jwt,
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("vault login: %w", err)
+	}
+	s.token = resp.Auth.ClientToken
+	vaultTokens.put(s.cacheKey, s.token, time.Duration(resp.Auth.LeaseDuration)*time.Second)
+	return s, nil
+}
+
+// Put implements Store. A new version is only written if the config has
+// changed.
+func (s *VaultStore) Put(ctx context.Context, name string, data map[string][]byte) error {
+	existing, err := s.Get(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil && equal(existing, data) {
+		return nil
+	}
+	values := make(map[string]string, len(data))
+	for k, v := range data {
+		values[k] = string(v)
+	}
+	err = s.do(ctx, http.MethodPost, s.path("data", name), map[string]any{"data": values}, nil)
+	if err != nil {
+		return fmt.Errorf("write %s to vault: %w", name, err)
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *VaultStore) Get(ctx context.Context, name string) (map[string][]byte, error) {
+	var resp struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodGet, s.path("data", name), nil, &resp); err != nil {
+		return nil, fmt.Errorf("read %s from vault: %w", name, err)
+	}
+	data := make(map[string][]byte, len(resp.Data.Data))
+	for k, v := range resp.Data.Data {
+		data[k] = []byte(v)
+	}
+	return data, nil
+}
+
+// Delete implements Store. All versions of the config are removed.
+func (s *VaultStore) Delete(ctx context.Context, name string) error {
+	err := s.do(ctx, http.MethodDelete, s.path("metadata", name), nil, nil)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("delete %s from vault: %w", name, err)
+	}
+	return nil
+}
+
+func equal(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *VaultStore) path(kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", s.cfg.Mount, kind, strings.Trim(s.cfg.Path, "/"), name)
+}
+
+func (s *VaultStore) do(ctx context.Context, method, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(s.cfg.Address, "/"), path)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if s.token != "" {
+		req.Header.Set("X-Vault-Token", s.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode == http.StatusForbidden && s.cacheKey != "" {
+		// The token may have been revoked, log in again on the next
+		// reconcile
+		vaultTokens.forget(s.cacheKey, s.token)
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeVault is a Vault server with the Kubernetes auth method and a KV v2
+// secrets engine.
+type fakeVault struct {
+	mu       sync.Mutex
+	jwt      string
+	role     string
+	logins   int
+	writes   int
+	revoked  map[string]bool
+	secrets  map[string]map[string]string
+	requests []string
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.requests = append(v.requests, r.Method+" "+r.URL.Path)
+	if r.URL.Path == "/v1/auth/kubernetes/login" {
+		var body struct{ Role, JWT string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Role != v.role || body.JWT != v.jwt {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		v.logins++
+		token := "token-" + strings.Repeat("x", v.logins)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{"client_token": token, "lease_duration": 3600},
+		})
+		return
+	}
+	token := r.Header.Get("X-Vault-Token")
+	if !strings.HasPrefix(token, "token-") || v.revoked[token] {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/")
+	if ok {
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := v.secrets[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": data}})
+		case http.MethodPost:
+			var body struct{ Data map[string]string }
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			v.writes++
+			v.secrets[name] = body.Data
+		}
+		return
+	}
+	if name, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/metadata/"); ok && r.Method == http.MethodDelete {
+		if _, ok := v.secrets[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(v.secrets, name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func TestVaultStore(t *testing.T) {
+	ctx := context.Background()
+	vault := &fakeVault{jwt: "sa-token", role: "operator", revoked: map[string]bool{}, secrets: map[string]map[string]string{}}
+	server := httptest.NewTLSServer(vault)
+	defer server.Close()
+
+	// The server is verified against the CA bundle of the mesh
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ca := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-ca", Namespace: "default"},
+		Data: map[string][]byte{"ca.crt": pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: server.Certificate().Raw,
+		})},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ca).Build()
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{AdminConfig: meshv1.AdminConfigSpec{
+			Store: meshv1.AdminConfigStoreVault,
+			Vault: &meshv1.VaultStoreConfig{
+				Address:   server.URL + "/",
+				Role:      "operator",
+				AuthMount: "kubernetes",
+				Mount:     "secret",
+				Path:      "/webmesh/configs/",
+				CABundle:  &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "vault-ca"}, Key: "ca.crt"},
+			},
+		}},
+	}
+	vaultTokens = &vaultTokenCache{tokens: make(map[string]vaultToken)}
+	login := VaultLogin{ServiceAccountToken: func(context.Context) (string, error) { return "sa-token", nil }}
+	newStore := func() *VaultStore {
+		t.Helper()
+		store, err := NewVaultStore(ctx, cli, mesh, login)
+		if err != nil {
+			t.Fatalf("new vault store: %v", err)
+		}
+		return store
+	}
+
+	store := newStore()
+	if _, err := store.Get(ctx, "mesh-admin-config"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a missing config to be not found, got %v", err)
+	}
+	config := map[string][]byte{"config.yaml": []byte("admin")}
+	if err := store.Put(ctx, "mesh-admin-config", config); err != nil {
+		t.Fatal(err)
+	}
+	if got := vault.secrets["webmesh/configs/mesh-admin-config"]["config.yaml"]; got != "admin" {
+		t.Errorf("expected the config under the KV v2 data path, got %v", vault.secrets)
+	}
+	got, err := store.Get(ctx, "mesh-admin-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(got, config) {
+		t.Errorf("got config %v, want %v", got, config)
+	}
+
+	// An unchanged config is not written again
+	if err := store.Put(ctx, "mesh-admin-config", config); err != nil {
+		t.Fatal(err)
+	}
+	if vault.writes != 1 {
+		t.Errorf("expected a single version to be written, got %d", vault.writes)
+	}
+
+	// Later stores reuse the token until it is revoked
+	store = newStore()
+	if vault.logins != 1 {
+		t.Errorf("expected the token to be reused, got %d logins", vault.logins)
+	}
+	vault.revoked[store.token] = true
+	if _, err := store.Get(ctx, "mesh-admin-config"); err == nil {
+		t.Fatal("expected a revoked token to be rejected")
+	}
+	store = newStore()
+	if vault.logins != 2 {
+		t.Errorf("expected to log in again after the token was rejected, got %d logins", vault.logins)
+	}
+
+	// Deleting removes the metadata of all versions, and is not an error
+	// for missing configs
+	for i := 0; i < 2; i++ {
+		if err := store.Delete(ctx, "mesh-admin-config"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(vault.secrets) != 0 {
+		t.Errorf("expected the config to be deleted, got %v", vault.secrets)
+	}
+	want := "DELETE /v1/secret/metadata/webmesh/configs/mesh-admin-config"
+	if last := vault.requests[len(vault.requests)-1]; last != want {
+		t.Errorf("got request %q, want %q", last, want)
+	}
+
+	// A token given by the caller is used as is, and a login with another
+	// role is rejected
+	logins := vault.logins
+	if _, err := NewVaultStore(ctx, cli, mesh, VaultLogin{Token: "token-caller"}); err != nil {
+		t.Fatal(err)
+	}
+	if vault.logins != logins {
+		t.Error("expected no login with a token given by the caller")
+	}
+	login.Role = "user"
+	if _, err := NewVaultStore(ctx, cli, mesh, login); err == nil {
+		t.Error("expected a login with a role not bound to the token to fail")
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// PublishDefaults writes the given effective default images of the operator to
+// a ConfigMap in its namespace, so users can discover which images are used for
+// objects that do not set their own. The ConfigMap is written directly instead
+// of through the cache, as the operator's namespace may not be watched.
+func PublishDefaults(ctx context.Context, cli client.Client, namespace string, images meshv1.Images) error {
+	cm := resources.NewOperatorDefaultsConfigMap(namespace, images)
+	log.FromContext(ctx).Info("Publishing operator defaults", "configmap", client.ObjectKeyFromObject(cm))
+	if err := cli.Patch(ctx, cm, client.Apply, client.ForceOwnership, client.FieldOwner(meshv1.FieldOwner)); err != nil {
+		return fmt.Errorf("apply operator defaults: %w", err)
+	}
+	return nil
+}
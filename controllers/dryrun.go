@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// NewDryRunClient returns a client that makes every write with a server-side
+// dry run. Status writes are still persisted, so the operator can report on
+// its own objects that their changes were only simulated.
+func NewDryRunClient(cli client.Client) client.Client {
+	return &dryRunClient{Client: cli}
+}
+
+type dryRunClient struct {
+	client.Client
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.Client.Create(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.Client.Update(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...)
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.Client.Delete(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return c.Client.DeleteAllOf(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+// simulate returns true if changes are only simulated in the given context,
+// after logging the action that is skipped. Calls to cloud providers and the
+// mesh that would change anything are guarded by it.
+func simulate(ctx context.Context, action string, keysAndValues ...any) bool {
+	if !resources.IsDryRun(ctx) {
+		return false
+	}
+	log.FromContext(ctx).Info("Dry run, not going to "+action, keysAndValues...)
+	return true
+}
+
+// reconcileSimulated sets the Simulated condition of the object while the
+// operator runs in dry-run mode, and removes it once it no longer does.
+func reconcileSimulated(ctx context.Context, cli client.Client, obj client.Object, conditions *[]metav1.Condition, dryRun bool) error {
+	current := meta.FindStatusCondition(*conditions, meshv1.SimulatedCondition)
+	switch {
+	case !dryRun && current == nil:
+		return nil
+	case !dryRun:
+		meta.RemoveStatusCondition(conditions, meshv1.SimulatedCondition)
+	case current != nil && current.Status == metav1.ConditionTrue && current.ObservedGeneration == obj.GetGeneration():
+		return nil
+	default:
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               meshv1.SimulatedCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: obj.GetGeneration(),
+			Reason:             "DryRun",
+			Message:            "The operator is running in dry-run mode, changes to the resources of this object are simulated and not made",
+		})
+	}
+	if err := cli.Status().Update(ctx, obj); err != nil {
+		return fmt.Errorf("update simulated condition: %w", err)
+	}
+	return nil
+}
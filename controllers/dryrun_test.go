@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+func TestDryRunClientMakesNoWrites(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"},
+		Data:       map[string]string{"key": "value"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", Generation: 1},
+	}
+	base := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(existing, group).
+		WithStatusSubresource(group).
+		Build()
+	cli := NewDryRunClient(base)
+	ctx := resources.WithDryRun(context.Background())
+
+	err := cli.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default"}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	updated := existing.DeepCopy()
+	updated.Data["key"] = "updated"
+	if err := cli.Update(ctx, updated); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	applied := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "applied", Namespace: "default"},
+	}
+	if err := resources.Apply(ctx, base, []client.Object{applied}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if err := cli.Delete(ctx, existing.DeepCopy()); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	var configMaps corev1.ConfigMapList
+	if err := base.List(context.Background(), &configMaps); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(configMaps.Items) != 1 || configMaps.Items[0].Name != "existing" || configMaps.Items[0].Data["key"] != "value" {
+		t.Fatalf("expected only the unchanged existing config map, got %v", configMaps.Items)
+	}
+
+	// Status is still written to report the objects as simulated
+	if err := reconcileSimulated(ctx, cli, group, &group.Status.Conditions, true); err != nil {
+		t.Fatalf("set simulated condition: %v", err)
+	}
+	var got meshv1.NodeGroup
+	if err := base.Get(context.Background(), client.ObjectKeyFromObject(group), &got); err != nil {
+		t.Fatalf("get node group: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, meshv1.SimulatedCondition) {
+		t.Fatalf("expected the simulated condition to be recorded, got %v", got.Status.Conditions)
+	}
+	if err := reconcileSimulated(context.Background(), base, &got, &got.Status.Conditions, false); err != nil {
+		t.Fatalf("remove simulated condition: %v", err)
+	}
+	if err := base.Get(context.Background(), client.ObjectKeyFromObject(group), &got); err != nil {
+		t.Fatalf("get node group: %v", err)
+	}
+	if meta.FindStatusCondition(got.Status.Conditions, meshv1.SimulatedCondition) != nil {
+		t.Fatalf("expected the simulated condition to be removed, got %v", got.Status.Conditions)
+	}
+}
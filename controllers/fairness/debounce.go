@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairness
+
+import (
+	"sync"
+	"time"
+)
+
+// Debounce coalesces changes made to an object in quick succession, such as
+// several manifests applied one after the other, so they are rolled out
+// together. Each new generation of an object restarts its window, and the
+// object is reconciled once it was left unchanged for the whole window.
+// Objects are not delayed the first time they are seen, nor while their
+// current generation has already been let through. A nil Debounce does not
+// delay reconciles.
+type Debounce struct {
+	window  time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	objects map[string]debounced
+}
+
+// debounced is the last generation seen of an object.
+type debounced struct {
+	generation int64
+	since      time.Time
+	released   bool
+}
+
+// NewDebounce returns a debounce coalescing changes within the given window.
+func NewDebounce(window time.Duration) *Debounce {
+	return &Debounce{window: window, now: time.Now, objects: make(map[string]debounced)}
+}
+
+// Delay returns how long to wait before reconciling the given generation of
+// the object with the given key.
+func (d *Debounce) Delay(key string, generation int64) time.Duration {
+	if d == nil || d.window <= 0 {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	obj, ok := d.objects[key]
+	switch {
+	case !ok:
+		// Changes made before the object was first seen are unknown
+		d.objects[key] = debounced{generation: generation, since: now, released: true}
+		return 0
+	case obj.generation != generation:
+		obj = debounced{generation: generation, since: now}
+	case obj.released:
+		return 0
+	}
+	elapsed := now.Sub(obj.since)
+	obj.released = elapsed >= d.window
+	d.objects[key] = obj
+	if obj.released {
+		return 0
+	}
+	return d.window - elapsed
+}
+
+// Forget stops tracking the object with the given key, once it was deleted.
+func (d *Debounce) Forget(key string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.objects, key)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairness
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounceDelay(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := NewDebounce(2 * time.Second)
+	d.now = func() time.Time { return now }
+	key := "NodeGroup/default/group"
+
+	if delay := d.Delay(key, 1); delay != 0 {
+		t.Fatalf("expected no delay the first time an object is seen, got %s", delay)
+	}
+	if delay := d.Delay(key, 1); delay != 0 {
+		t.Fatalf("expected no delay for a generation already let through, got %s", delay)
+	}
+
+	// A change is held for the window
+	if delay := d.Delay(key, 2); delay != 2*time.Second {
+		t.Fatalf("expected a change to be delayed for the window, got %s", delay)
+	}
+	// Another change within the window restarts it
+	now = now.Add(time.Second)
+	if delay := d.Delay(key, 3); delay != 2*time.Second {
+		t.Fatalf("expected a further change to restart the window, got %s", delay)
+	}
+	now = now.Add(time.Second)
+	if delay := d.Delay(key, 3); delay != time.Second {
+		t.Fatalf("expected the rest of the window, got %s", delay)
+	}
+	now = now.Add(time.Second)
+	if delay := d.Delay(key, 3); delay != 0 {
+		t.Fatalf("expected no delay once the window passed, got %s", delay)
+	}
+	// Retries of the same generation are not held again
+	now = now.Add(time.Minute)
+	if delay := d.Delay(key, 3); delay != 0 {
+		t.Fatalf("expected no delay for a generation already let through, got %s", delay)
+	}
+
+	d.Forget(key)
+	if delay := d.Delay(key, 4); delay != 0 {
+		t.Fatalf("expected no delay for a forgotten object, got %s", delay)
+	}
+
+	var disabled *Debounce
+	if delay := disabled.Delay(key, 5); delay != 0 {
+		t.Fatalf("expected a nil debounce not to delay, got %s", delay)
+	}
+}
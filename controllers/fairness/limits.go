@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairness
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ProviderGoogleCloud is the provider name for calls to Google Cloud APIs.
+	ProviderGoogleCloud = "google"
+	// ProviderAWS is the provider name for calls to AWS APIs.
+	ProviderAWS = "aws"
+	// ProviderAzure is the provider name for calls to Azure APIs.
+	ProviderAzure = "azure"
+	// ProviderDigitalOcean is the provider name for calls to DigitalOcean APIs.
+	ProviderDigitalOcean = "digitalocean"
+	// ProviderHetzner is the provider name for calls to Hetzner Cloud APIs.
+	ProviderHetzner = "hetzner"
+	// ProviderBareMetal is the provider name for SSH sessions to bare metal
+	// hosts.
+	ProviderBareMetal = "baremetal"
+)
+
+// Limiter bounds the number of concurrent operations against each cloud
+// provider. It is shared by all controllers, so the limits hold across them.
+// A nil Limiter and providers without a limit are unbounded.
+type Limiter struct {
+	slots map[string]chan struct{}
+	mu    sync.RWMutex
+}
+
+// NewLimiter returns a limiter with the given maximum concurrency per provider.
+func NewLimiter(limits map[string]int) *Limiter {
+	l := &Limiter{}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits replaces the maximum concurrency per provider. Operations holding
+// a slot keep it, a provider whose limit changed starts with all of its new
+// slots free.
+func (l *Limiter) SetLimits(limits map[string]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots := make(map[string]chan struct{}, len(limits))
+	for provider, n := range limits {
+		if n <= 0 {
+			continue
+		}
+		if current, ok := l.slots[provider]; ok && cap(current) == n {
+			slots[provider] = current
+			continue
+		}
+		slots[provider] = make(chan struct{}, n)
+	}
+	l.slots = slots
+}
+
+// Acquire waits for a free slot for the given provider. The returned function
+// releases the slot.
+func (l *Limiter) Acquire(ctx context.Context, provider string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.RLock()
+	slots, ok := l.slots[provider]
+	l.mu.RUnlock()
+	if !ok {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for %s concurrency slot: %w", provider, ctx.Err())
+	}
+}
+
+// ParseLimits parses a comma-separated list of provider=limit pairs.
+func ParseLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	if s == "" {
+		return limits, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		provider, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid provider limit %q, expected provider=limit", pair)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit for provider %s: %q", provider, value)
+		}
+		limits[provider] = n
+	}
+	return limits, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fairness spreads reconciles across operator replicas, over time, and
+// between the cloud providers they call.
+package fairness
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	shardInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webmesh_shard_info",
+		Help: "The shard reconciled by this operator replica and the total number of shards.",
+	}, []string{"shard", "shards"})
+	shardMeshes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webmesh_shard_meshes",
+		Help: "The number of meshes assigned to the shard of this operator replica.",
+	}, []string{"shard"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(shardInfo, shardMeshes)
+}
+
+// Shard is the subset of meshes reconciled by an operator replica. Meshes are
+// assigned to shards by rendezvous hashing of their namespace and name, so only
+// the meshes of a removed or added shard move when the number of shards changes.
+// The node groups and peerings of a mesh belong to the shard of the mesh. A nil
+// Shard owns every mesh.
+type Shard struct {
+	index, count int
+
+	mu     sync.Mutex
+	meshes map[types.NamespacedName]struct{}
+}
+
+// NewShard returns the shard with the given index out of count shards.
+func NewShard(index, count int) (*Shard, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("shard count must be at least 1, got %d", count)
+	}
+	if index < 0 || index >= count {
+		return nil, fmt.Errorf("shard index must be in [0, %d), got %d", count, index)
+	}
+	shardInfo.WithLabelValues(strconv.Itoa(index), strconv.Itoa(count)).Set(1)
+	return &Shard{
+		index:  index,
+		count:  count,
+		meshes: make(map[types.NamespacedName]struct{}),
+	}, nil
+}
+
+// Owns returns true if the mesh with the given key is assigned to the shard.
+func (s *Shard) Owns(mesh types.NamespacedName) bool {
+	if s == nil || s.count == 1 {
+		return true
+	}
+	return ShardOf(mesh, s.count) == s.index
+}
+
+// Track records that the mesh with the given key exists or was deleted for the
+// shard assignment metric.
+func (s *Shard) Track(mesh types.NamespacedName, exists bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if exists {
+		s.meshes[mesh] = struct{}{}
+	} else {
+		delete(s.meshes, mesh)
+	}
+	shardMeshes.WithLabelValues(strconv.Itoa(s.index)).Set(float64(len(s.meshes)))
+}
+
+// LeaseName returns the name of the leader election lease for the shard. Each
+// shard elects its own leader among the replicas assigned to it.
+func (s *Shard) LeaseName(base string) string {
+	if s == nil || s.count == 1 {
+		return base
+	}
+	return fmt.Sprintf("%s-shard-%d", base, s.index)
+}
+
+// ShardOf returns the shard the mesh with the given key is assigned to out of
+// count shards.
+func ShardOf(mesh types.NamespacedName, count int) int {
+	var shard int
+	var highest uint64
+	for i := 0; i < count; i++ {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(mesh.String()))
+		_, _ = h.Write([]byte{'/', byte(i), byte(i >> 8)})
+		if score := h.Sum64(); i == 0 || score > highest {
+			shard, highest = i, score
+		}
+	}
+	return shard
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairness
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestShardOf(t *testing.T) {
+	const meshes = 1000
+	keys := make([]types.NamespacedName, meshes)
+	for i := range keys {
+		keys[i] = types.NamespacedName{Namespace: fmt.Sprintf("team-%d", i%37), Name: fmt.Sprintf("mesh-%d", i)}
+	}
+
+	// Meshes are spread roughly evenly across shards
+	counts := make([]int, 4)
+	for _, key := range keys {
+		counts[ShardOf(key, len(counts))]++
+	}
+	for shard, count := range counts {
+		if count < meshes/len(counts)*3/4 || count > meshes/len(counts)*5/4 {
+			t.Errorf("shard %d was assigned %d of %d meshes", shard, count, meshes)
+		}
+	}
+
+	// Adding a shard only moves meshes to the new shard
+	for _, key := range keys {
+		before, after := ShardOf(key, 4), ShardOf(key, 5)
+		if before != after && after != 4 {
+			t.Errorf("mesh %s moved from shard %d to existing shard %d", key, before, after)
+		}
+	}
+}
+
+func TestShardOwns(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "mesh"}
+	var owners int
+	for i := 0; i < 3; i++ {
+		shard, err := NewShard(i, 3)
+		if err != nil {
+			t.Fatalf("new shard: %v", err)
+		}
+		if shard.Owns(key) {
+			owners++
+		}
+	}
+	if owners != 1 {
+		t.Errorf("expected exactly one shard to own the mesh, got %d", owners)
+	}
+	var unsharded *Shard
+	if !unsharded.Owns(key) {
+		t.Errorf("expected a nil shard to own every mesh")
+	}
+	if _, err := NewShard(3, 3); err == nil {
+		t.Errorf("expected an error for an out of range shard index")
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairness
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Warmup staggers the first reconciles after an operator replica becomes the
+// leader, so restarting does not re-reconcile every object at once. Each object
+// is given a fixed slot within the warmup period, and reconciles before its slot
+// are delayed until it. A nil Warmup does not delay reconciles.
+type Warmup struct {
+	duration time.Duration
+	once     sync.Once
+	start    time.Time
+	now      func() time.Time
+}
+
+// NewWarmup returns a warmup spreading reconciles over the given duration.
+func NewWarmup(duration time.Duration) *Warmup {
+	return &Warmup{duration: duration, now: time.Now}
+}
+
+// Delay returns how long to wait before reconciling the object with the given
+// key. The warmup period starts with the first call, which happens once the
+// replica has been elected leader.
+func (w *Warmup) Delay(key string) time.Duration {
+	if w == nil || w.duration <= 0 {
+		return 0
+	}
+	w.once.Do(func() { w.start = w.now() })
+	elapsed := w.now().Sub(w.start)
+	if elapsed >= w.duration {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	slot := time.Duration(h.Sum64() % uint64(w.duration))
+	if slot <= elapsed {
+		return 0
+	}
+	return slot - elapsed
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairness
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmupDelay(t *testing.T) {
+	now := time.Unix(0, 0)
+	w := NewWarmup(time.Minute)
+	w.now = func() time.Time { return now }
+
+	keys := []string{"Mesh/default/a", "Mesh/default/b", "NodeGroup/default/c", "NodeGroup/team/d"}
+	delays := make(map[string]time.Duration, len(keys))
+	for _, key := range keys {
+		delay := w.Delay(key)
+		if delay < 0 || delay >= time.Minute {
+			t.Fatalf("delay for %s out of range: %s", key, delay)
+		}
+		delays[key] = delay
+	}
+
+	// Once its slot has passed, an object is reconciled immediately
+	for _, key := range keys {
+		now = time.Unix(0, 0).Add(delays[key])
+		if delay := w.Delay(key); delay != 0 {
+			t.Errorf("expected no delay for %s at its slot, got %s", key, delay)
+		}
+	}
+
+	// Nothing is delayed after the warmup
+	now = time.Unix(0, 0).Add(time.Minute)
+	for _, key := range keys {
+		if delay := w.Delay(key); delay != 0 {
+			t.Errorf("expected no delay for %s after warmup, got %s", key, delay)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ErrLBNotReady is returned when a load balancer has not been assigned
+// an address yet.
+var ErrLBNotReady = errors.New("load balancer not ready")
+
+// LBExternalIPs returns the externally reachable addresses of a node group
+// load balancer service.
+func LBExternalIPs(lbService *corev1.Service) ([]string, error) {
+	var externalIPs []string
+	switch lbService.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		if len(lbService.Status.LoadBalancer.Ingress) == 0 {
+			return nil, ErrLBNotReady
+		}
+		for _, ingress := range lbService.Status.LoadBalancer.Ingress {
+			if ingress.IP == "" {
+				return nil, ErrLBNotReady
+			}
+			externalIPs = append(externalIPs, ingress.IP)
+		}
+		for _, ip := range lbService.Spec.ClusterIPs {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				return nil, fmt.Errorf("parse cluster IP: %w", err)
+			}
+			if !addr.IsPrivate() {
+				externalIPs = append(externalIPs, addr.String())
+			}
+		}
+	case corev1.ServiceTypeNodePort:
+		return nil, fmt.Errorf("node port not supported")
+	case corev1.ServiceTypeClusterIP:
+		for _, ip := range lbService.Spec.ClusterIPs {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				return nil, fmt.Errorf("parse cluster IP: %w", err)
+			}
+			if !addr.IsPrivate() {
+				externalIPs = append(externalIPs, addr.String())
+			}
+		}
+		clusterIP, err := netip.ParseAddr(lbService.Spec.ClusterIP)
+		if err != nil {
+			return nil, fmt.Errorf("parse cluster IP: %w", err)
+		}
+		if !clusterIP.IsPrivate() {
+			externalIPs = append(externalIPs, clusterIP.String())
+		}
+	default:
+		return nil, fmt.Errorf("service has unknown type: %s", lbService.Spec.Type)
+	}
+	if len(externalIPs) == 0 {
+		return nil, ErrLBNotReady
+	}
+	return externalIPs, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ContainerTermination is the termination of a container that is not
+// currently running because it exited with an error.
+type ContainerTermination struct {
+	// Pod is the name of the pod.
+	Pod string
+	// ExitCode is the exit code of the container.
+	ExitCode int32
+	// Reason is the reason reported for the termination.
+	Reason string
+	// Message is the termination message of the container. With the
+	// FallbackToLogsOnError policy it holds the last lines of its logs.
+	Message string
+	// FinishedAt is the time the container exited.
+	FinishedAt metav1.Time
+}
+
+// FailedContainers returns the terminations of the named container in each of
+// the given pods where it exited with an error and has not been running since,
+// such as while it is in a crash loop. They are sorted by pod name.
+func FailedContainers(pods []corev1.Pod, container string) []ContainerTermination {
+	var terminations []ContainerTermination
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != container || status.State.Running != nil {
+				continue
+			}
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+			terminations = append(terminations, ContainerTermination{
+				Pod:        pod.GetName(),
+				ExitCode:   terminated.ExitCode,
+				Reason:     terminated.Reason,
+				Message:    terminated.Message,
+				FinishedAt: terminated.FinishedAt,
+			})
+		}
+	}
+	sort.Slice(terminations, func(i, j int) bool {
+		return terminations[i].Pod < terminations[j].Pod
+	})
+	return terminations
+}
+
+// SchedulingFailure is a pod that the scheduler could not place on a node.
+type SchedulingFailure struct {
+	// Pod is the name of the pod.
+	Pod string
+	// Message is the message of the scheduler, such as the resources or
+	// taints that ruled out each node.
+	Message string
+	// Since is the time the pod was last found to be unschedulable.
+	Since metav1.Time
+}
+
+// UnschedulablePods returns the scheduling failures of the given pods that
+// are still pending because no node could fit them. They are sorted by pod
+// name.
+func UnschedulablePods(pods []corev1.Pod) []SchedulingFailure {
+	var failures []SchedulingFailure
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodPending || pod.GetDeletionTimestamp() != nil {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type != corev1.PodScheduled ||
+				condition.Status != corev1.ConditionFalse ||
+				condition.Reason != corev1.PodReasonUnschedulable {
+				continue
+			}
+			failures = append(failures, SchedulingFailure{
+				Pod:     pod.GetName(),
+				Message: condition.Message,
+				Since:   condition.LastTransitionTime,
+			})
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Pod < failures[j].Pod
+	})
+	return failures
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFailedContainers(t *testing.T) {
+	pod := func(name string, status corev1.ContainerStatus) corev1.Pod {
+		status.Name = "node"
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					status,
+					// Other containers are ignored
+					{
+						Name: "sidecar",
+						State: corev1.ContainerState{
+							Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+						},
+					},
+				},
+			},
+		}
+	}
+	pods := []corev1.Pod{
+		// Crash looping after a config error
+		pod("node-1", corev1.ContainerStatus{
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+			},
+			LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{
+					ExitCode: 1,
+					Reason:   "Error",
+					Message:  "load tls ca file: no such file or directory",
+				},
+			},
+		}),
+		// Exited and not yet restarted
+		pod("node-0", corev1.ContainerStatus{
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 2, Reason: "Error"},
+			},
+		}),
+		// Recovered after a previous failure
+		pod("node-2", corev1.ContainerStatus{
+			State: corev1.ContainerState{
+				Running: &corev1.ContainerStateRunning{},
+			},
+			LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+			},
+		}),
+		// Exited cleanly
+		pod("node-3", corev1.ContainerStatus{
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"},
+			},
+		}),
+	}
+	got := FailedContainers(pods, "node")
+	if len(got) != 2 {
+		t.Fatalf("expected 2 failed containers, got %+v", got)
+	}
+	if got[0].Pod != "node-0" || got[0].ExitCode != 2 {
+		t.Errorf("unexpected termination for node-0: %+v", got[0])
+	}
+	if got[1].Pod != "node-1" || got[1].Message != "load tls ca file: no such file or directory" {
+		t.Errorf("unexpected termination for node-1: %+v", got[1])
+	}
+}
+
+func TestUnschedulablePods(t *testing.T) {
+	since := metav1.Now()
+	pod := func(name string, phase corev1.PodPhase, conditions ...corev1.PodCondition) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.PodStatus{Phase: phase, Conditions: conditions},
+		}
+	}
+	pods := []corev1.Pod{
+		// Waiting for the autoscaler to add a node
+		pod("node-2", corev1.PodPending, corev1.PodCondition{
+			Type:               corev1.PodScheduled,
+			Status:             corev1.ConditionFalse,
+			Reason:             corev1.PodReasonUnschedulable,
+			Message:            "0/3 nodes are available: 3 Insufficient memory.",
+			LastTransitionTime: since,
+		}),
+		pod("node-1", corev1.PodPending, corev1.PodCondition{
+			Type:               corev1.PodScheduled,
+			Status:             corev1.ConditionFalse,
+			Reason:             corev1.PodReasonUnschedulable,
+			Message:            "0/3 nodes are available: 3 node(s) had untolerated taint.",
+			LastTransitionTime: since,
+		}),
+		// Scheduled and pulling its image
+		pod("node-0", corev1.PodPending, corev1.PodCondition{
+			Type:   corev1.PodScheduled,
+			Status: corev1.ConditionTrue,
+		}),
+		// Not yet seen by the scheduler
+		pod("node-3", corev1.PodPending),
+		// Running
+		pod("node-4", corev1.PodRunning, corev1.PodCondition{
+			Type:   corev1.PodScheduled,
+			Status: corev1.ConditionTrue,
+		}),
+	}
+	got := UnschedulablePods(pods)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 unschedulable pods, got %+v", got)
+	}
+	if got[0].Pod != "node-1" || got[0].Message != "0/3 nodes are available: 3 node(s) had untolerated taint." {
+		t.Errorf("unexpected scheduling failure for node-1: %+v", got[0])
+	}
+	if got[1].Pod != "node-2" || !got[1].Since.Equal(&since) {
+		t.Errorf("unexpected scheduling failure for node-2: %+v", got[1])
+	}
+}
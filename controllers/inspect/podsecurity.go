@@ -0,0 +1,161 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodSecurityEnforceLabel is the label of a namespace holding the level of the
+// Pod Security Standards enforced on its pods by the PodSecurity admission
+// controller.
+const PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+// The levels of the Pod Security Standards.
+const (
+	PodSecurityPrivileged = "privileged"
+	PodSecurityBaseline   = "baseline"
+	PodSecurityRestricted = "restricted"
+)
+
+// podSecurityLevels are the levels of the Pod Security Standards, from the
+// least to the most restrictive.
+var podSecurityLevels = []string{PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted}
+
+// baselineCapabilities are the capabilities containers may add under the
+// baseline level.
+var baselineCapabilities = []corev1.Capability{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+	"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// PodSecurityAdmits returns true if a namespace enforcing the given level
+// admits pods requiring the other. Namespaces enforcing no level admit every
+// pod, and unknown levels are treated as restricted like the admission
+// controller does.
+func PodSecurityAdmits(enforced, required string) bool {
+	if enforced == "" {
+		return true
+	}
+	e := slices.Index(podSecurityLevels, enforced)
+	if e < 0 {
+		e = len(podSecurityLevels) - 1
+	}
+	return e <= slices.Index(podSecurityLevels, required)
+}
+
+// PodSecurityLevel returns the most restrictive level of the Pod Security
+// Standards admitting pods with the given spec, along with the reasons they
+// are not admitted by the next level. Only the checks that can fail for the
+// pods of the operator are made.
+func PodSecurityLevel(spec *corev1.PodSpec) (string, []string) {
+	if reasons := baselineViolations(spec); len(reasons) > 0 {
+		return PodSecurityPrivileged, reasons
+	}
+	if reasons := restrictedViolations(spec); len(reasons) > 0 {
+		return PodSecurityBaseline, reasons
+	}
+	return PodSecurityRestricted, nil
+}
+
+// baselineViolations returns the reasons pods with the given spec are not
+// admitted under the baseline level.
+func baselineViolations(spec *corev1.PodSpec) []string {
+	var reasons []string
+	if spec.HostNetwork {
+		reasons = append(reasons, "it uses the host network")
+	}
+	if spec.HostPID || spec.HostIPC {
+		reasons = append(reasons, "it shares the host process namespaces")
+	}
+	for _, vol := range spec.Volumes {
+		if vol.HostPath != nil {
+			reasons = append(reasons, fmt.Sprintf("volume %q is a host path", vol.Name))
+		}
+	}
+	forEachContainer(spec, func(c *corev1.Container) {
+		sc := c.SecurityContext
+		if sc != nil && sc.Privileged != nil && *sc.Privileged {
+			reasons = append(reasons, fmt.Sprintf("container %q is privileged", c.Name))
+		}
+		if sc != nil && sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				if !slices.Contains(baselineCapabilities, capability) {
+					reasons = append(reasons, fmt.Sprintf("container %q adds the %s capability", c.Name, capability))
+				}
+			}
+		}
+		for _, port := range c.Ports {
+			if port.HostPort != 0 {
+				reasons = append(reasons, fmt.Sprintf("container %q uses host port %d", c.Name, port.HostPort))
+			}
+		}
+	})
+	return reasons
+}
+
+// restrictedViolations returns the reasons pods with the given spec are not
+// admitted under the restricted level.
+func restrictedViolations(spec *corev1.PodSpec) []string {
+	var reasons []string
+	podNonRoot := spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil && *spec.SecurityContext.RunAsNonRoot
+	podSeccomp := spec.SecurityContext != nil && spec.SecurityContext.SeccompProfile != nil
+	forEachContainer(spec, func(c *corev1.Container) {
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			reasons = append(reasons, fmt.Sprintf("container %q allows privilege escalation", c.Name))
+		}
+		nonRoot := podNonRoot
+		if sc.RunAsNonRoot != nil {
+			nonRoot = *sc.RunAsNonRoot
+		}
+		if !nonRoot || (sc.RunAsUser != nil && *sc.RunAsUser == 0) {
+			reasons = append(reasons, fmt.Sprintf("container %q may run as root", c.Name))
+		}
+		if sc.SeccompProfile == nil && !podSeccomp || sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+			reasons = append(reasons, fmt.Sprintf("container %q has no seccomp profile", c.Name))
+		}
+		if sc.Capabilities == nil || !slices.Contains(sc.Capabilities.Drop, "ALL") {
+			reasons = append(reasons, fmt.Sprintf("container %q does not drop all capabilities", c.Name))
+		}
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				if capability != "NET_BIND_SERVICE" {
+					reasons = append(reasons, fmt.Sprintf("container %q adds the %s capability", c.Name, capability))
+				}
+			}
+		}
+	})
+	return reasons
+}
+
+// forEachContainer calls the given function with every container of the given
+// spec, init containers first.
+func forEachContainer(spec *corev1.PodSpec, fn func(*corev1.Container)) {
+	for i := range spec.InitContainers {
+		fn(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		fn(&spec.Containers[i])
+	}
+}
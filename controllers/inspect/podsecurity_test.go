@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodSecurityLevel(t *testing.T) {
+	yes, no, root := true, false, int64(0)
+	restricted := func() *corev1.SecurityContext {
+		return &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &no,
+			RunAsNonRoot:             &yes,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		}
+	}
+	tc := []struct {
+		name    string
+		spec    corev1.PodSpec
+		want    string
+		reasons int
+	}{
+		{
+			name: "restricted",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "node", SecurityContext: restricted()}}},
+			want: PodSecurityRestricted,
+		},
+		{
+			name: "no security context",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "node"}}},
+			want: PodSecurityBaseline,
+			// Escalation, root, seccomp and capabilities
+			reasons: 4,
+		},
+		{
+			name: "root",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "node", SecurityContext: func() *corev1.SecurityContext {
+				sc := restricted()
+				sc.RunAsUser = &root
+				return sc
+			}()}}},
+			want:    PodSecurityBaseline,
+			reasons: 1,
+		},
+		{
+			name: "net admin",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "node", SecurityContext: func() *corev1.SecurityContext {
+				sc := restricted()
+				sc.Capabilities.Add = []corev1.Capability{"NET_ADMIN"}
+				return sc
+			}()}}},
+			want:    PodSecurityPrivileged,
+			reasons: 1,
+		},
+		{
+			name: "privileged init container and host path",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", SecurityContext: &corev1.SecurityContext{Privileged: &yes}}},
+				Containers:     []corev1.Container{{Name: "node", SecurityContext: restricted()}},
+				Volumes:        []corev1.Volume{{Name: "tun", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/dev/net/tun"}}}},
+			},
+			want:    PodSecurityPrivileged,
+			reasons: 2,
+		},
+		{
+			name:    "host network",
+			spec:    corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "node", SecurityContext: restricted()}}},
+			want:    PodSecurityPrivileged,
+			reasons: 1,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reasons := PodSecurityLevel(&tt.spec)
+			if got != tt.want || len(reasons) != tt.reasons {
+				t.Errorf("expected %s with %d reasons, got %s with %v", tt.want, tt.reasons, got, reasons)
+			}
+		})
+	}
+}
+
+func TestPodSecurityAdmits(t *testing.T) {
+	tc := []struct {
+		enforced, required string
+		want               bool
+	}{
+		{"", PodSecurityPrivileged, true},
+		{PodSecurityPrivileged, PodSecurityPrivileged, true},
+		{PodSecurityBaseline, PodSecurityPrivileged, false},
+		{PodSecurityBaseline, PodSecurityRestricted, true},
+		{PodSecurityRestricted, PodSecurityBaseline, false},
+		{"bogus", PodSecurityBaseline, false},
+		{"bogus", PodSecurityRestricted, true},
+	}
+	for _, tt := range tc {
+		if got := PodSecurityAdmits(tt.enforced, tt.required); got != tt.want {
+			t.Errorf("expected %q admitting %q to be %v", tt.enforced, tt.required, tt.want)
+		}
+	}
+}
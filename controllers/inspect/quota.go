@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// QuotaUsage returns the resources the given pods and persistent volume claims
+// count against the resource quotas of their namespace once created.
+func QuotaUsage(pods []corev1.Pod, claims []corev1.PersistentVolumeClaim) corev1.ResourceList {
+	usage := make(corev1.ResourceList)
+	for _, pod := range pods {
+		addQuantity(usage, corev1.ResourcePods, *resource.NewQuantity(1, resource.DecimalSI))
+		addQuantity(usage, "count/pods", *resource.NewQuantity(1, resource.DecimalSI))
+		requests := podResources(&pod, func(c *corev1.Container) corev1.ResourceList { return c.Resources.Requests })
+		for name, quantity := range requests {
+			switch name {
+			case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+				addQuantity(usage, name, quantity)
+				addQuantity(usage, corev1.ResourceName("requests."+name), quantity)
+			}
+		}
+		limits := podResources(&pod, func(c *corev1.Container) corev1.ResourceList { return c.Resources.Limits })
+		for name, quantity := range limits {
+			switch name {
+			case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+				addQuantity(usage, corev1.ResourceName("limits."+name), quantity)
+			}
+		}
+	}
+	for _, claim := range claims {
+		storage := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		addQuantity(usage, corev1.ResourcePersistentVolumeClaims, *resource.NewQuantity(1, resource.DecimalSI))
+		addQuantity(usage, "count/persistentvolumeclaims", *resource.NewQuantity(1, resource.DecimalSI))
+		addQuantity(usage, corev1.ResourceRequestsStorage, storage)
+		if class := claim.Spec.StorageClassName; class != nil && *class != "" {
+			prefix := *class + ".storageclass.storage.k8s.io/"
+			addQuantity(usage, corev1.ResourceName(prefix+string(corev1.ResourcePersistentVolumeClaims)), *resource.NewQuantity(1, resource.DecimalSI))
+			addQuantity(usage, corev1.ResourceName(prefix+string(corev1.ResourceRequestsStorage)), storage)
+		}
+	}
+	return usage
+}
+
+// ExceededQuotas returns a description of each resource of the given quotas
+// that would be exceeded by adding the given usage to what they have already
+// used. Quotas with scopes only count some pods and are not checked.
+func ExceededQuotas(quotas []corev1.ResourceQuota, usage corev1.ResourceList) []string {
+	var exceeded []string
+	for _, quota := range quotas {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+		hard := quota.Status.Hard
+		if hard == nil {
+			hard = quota.Spec.Hard
+		}
+		names := make([]string, 0, len(hard))
+		for name := range hard {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			requested, ok := usage[corev1.ResourceName(name)]
+			if !ok {
+				continue
+			}
+			limit := hard[corev1.ResourceName(name)]
+			total := quota.Status.Used[corev1.ResourceName(name)].DeepCopy()
+			total.Add(requested)
+			if total.Cmp(limit) > 0 {
+				exceeded = append(exceeded, fmt.Sprintf("quota %s: %s would be %s, limited to %s",
+					quota.GetName(), name, total.String(), limit.String()))
+			}
+		}
+	}
+	return exceeded
+}
+
+// podResources returns the resources of a pod as the scheduler and quotas
+// count them: the sum over its containers, or the largest of its init
+// containers if that is greater.
+func podResources(pod *corev1.Pod, get func(*corev1.Container) corev1.ResourceList) corev1.ResourceList {
+	out := make(corev1.ResourceList)
+	for i := range pod.Spec.Containers {
+		for name, quantity := range get(&pod.Spec.Containers[i]) {
+			addQuantity(out, name, quantity)
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		for name, quantity := range get(&pod.Spec.InitContainers[i]) {
+			if current, ok := out[name]; !ok || quantity.Cmp(current) > 0 {
+				out[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	return out
+}
+
+func addQuantity(list corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) {
+	current := list[name]
+	current.Add(quantity)
+	list[name] = current
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestQuotaUsage(t *testing.T) {
+	class := "fast"
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+				},
+			}},
+			Containers: []corev1.Container{
+				{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("100m"),
+							corev1.ResourceMemory: resource.MustParse("64Mi"),
+						},
+						Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+					},
+				},
+				{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+					},
+				},
+			},
+		},
+	}
+	claim := corev1.PersistentVolumeClaim{
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &class,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}
+	usage := QuotaUsage([]corev1.Pod{pod, pod}, []corev1.PersistentVolumeClaim{claim, claim})
+	expected := map[corev1.ResourceName]string{
+		"pods":                         "2",
+		"count/pods":                   "2",
+		"cpu":                          "1",
+		"requests.cpu":                 "1",
+		"memory":                       "128Mi",
+		"requests.memory":              "128Mi",
+		"limits.memory":                "256Mi",
+		"persistentvolumeclaims":       "2",
+		"count/persistentvolumeclaims": "2",
+		"requests.storage":             "2Gi",
+		"fast.storageclass.storage.k8s.io/persistentvolumeclaims": "2",
+		"fast.storageclass.storage.k8s.io/requests.storage":       "2Gi",
+	}
+	got := make(map[corev1.ResourceName]string, len(usage))
+	for name, quantity := range usage {
+		got[name] = quantity.String()
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected usage %v, got %v", expected, got)
+	}
+}
+
+func TestExceededQuotas(t *testing.T) {
+	quota := func(name string, hard, used corev1.ResourceList) corev1.ResourceQuota {
+		return corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+			Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+		}
+	}
+	scoped := quota("scoped", corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")}, nil)
+	scoped.Spec.Scopes = []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}
+	quotas := []corev1.ResourceQuota{
+		quota("compute",
+			corev1.ResourceList{
+				corev1.ResourcePods:        resource.MustParse("10"),
+				corev1.ResourceRequestsCPU: resource.MustParse("2"),
+			},
+			corev1.ResourceList{
+				corev1.ResourcePods:        resource.MustParse("3"),
+				corev1.ResourceRequestsCPU: resource.MustParse("1500m"),
+			},
+		),
+		quota("storage",
+			corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("4")},
+			corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("2")},
+		),
+		// Quotas without any usage yet and scoped quotas
+		quota("new", corev1.ResourceList{corev1.ResourceRequestsMemory: resource.MustParse("1Gi")}, nil),
+		scoped,
+	}
+	usage := corev1.ResourceList{
+		corev1.ResourcePods:                   resource.MustParse("5"),
+		corev1.ResourceRequestsCPU:            resource.MustParse("1"),
+		corev1.ResourceRequestsMemory:         resource.MustParse("512Mi"),
+		corev1.ResourcePersistentVolumeClaims: resource.MustParse("2"),
+	}
+	got := ExceededQuotas(quotas, usage)
+	expected := []string{"quota compute: requests.cpu would be 2500m, limited to 2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	usage[corev1.ResourceRequestsMemory] = resource.MustParse("2Gi")
+	if got := ExceededQuotas(quotas, usage); len(got) != 2 {
+		t.Fatalf("expected quotas without usage to be checked, got %v", got)
+	}
+}
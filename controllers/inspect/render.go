@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// RenderOptions are options for rendering manifests offline.
+type RenderOptions struct {
+	// JoinServer is the join server used by non-bootstrap groups. It
+	// defaults to the bootstrap group's headless service.
+	JoinServer string
+	// ServiceIPFamilies are used for services when neither the group nor
+	// the mesh configure them. They default to PreferDualStack.
+	ServiceIPFamilies *meshv1.ServiceIPFamilies
+	// ClusterDomain is the DNS domain of the cluster. It defaults to
+	// cluster.local.
+	ClusterDomain string
+}
+
+// Render returns the resources the operator would create for the given
+// mesh and node groups without contacting a cluster. Only cluster node
+// groups are supported. Load balancer addresses are unknown offline, so
+// only statically configured external URLs are used. The mesh and groups
+// are defaulted in place.
+func Render(mesh *meshv1.Mesh, groups []*meshv1.NodeGroup, opts RenderOptions) ([]client.Object, error) {
+	clusterDomain, err := meshv1.NormalizeClusterDomain(opts.ClusterDomain)
+	if err != nil {
+		return nil, err
+	}
+	images := meshv1.DefaultImages()
+	mesh.Default()
+	out := resources.RenderMesh(mesh)
+	bootstraps := mesh.BootstrapGroups()
+	all := append(bootstraps, groups...)
+	if opts.JoinServer == "" {
+		opts.JoinServer = fmt.Sprintf("%s:%d", meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, bootstraps[0], clusterDomain), meshv1.DefaultGRPCPort)
+	}
+	families := opts.ServiceIPFamilies
+	if families == nil {
+		families = &meshv1.ServiceIPFamilies{
+			IPFamilyPolicy: func() *corev1.IPFamilyPolicy {
+				policy := corev1.IPFamilyPolicyPreferDualStack
+				return &policy
+			}(),
+		}
+	}
+	if mesh.Spec.ServiceIPFamilies.IsSet() {
+		families = &mesh.Spec.ServiceIPFamilies
+	}
+	for _, group := range all {
+		group.Spec.Default()
+		if group.Spec.Cluster == nil {
+			return nil, fmt.Errorf("node group %s: only cluster node groups can be rendered", group.GetName())
+		}
+		groupFamilies := *families
+		var externalURLs []string
+		out = append(out, resources.RenderNodeCertificates(mesh, group, clusterDomain)...)
+		if svc := group.Spec.Cluster.Service; svc != nil {
+			if svc.ServiceIPFamilies.IsSet() {
+				groupFamilies = svc.ServiceIPFamilies
+			}
+			for _, lb := range resources.NewNodeGroupLBServices(mesh, group, groupFamilies) {
+				out = append(out, lb)
+			}
+			if _, err := netip.ParseAddr(svc.ExternalURL); err == nil {
+				externalURLs = append(externalURLs, svc.ExternalURL)
+			}
+		}
+		var replicaURLs [][]string
+		if group.ExposedPerReplica() {
+			// The addresses of the services are not known yet, the
+			// nodes only broadcast their internal endpoints
+			replicaURLs = make([][]string, group.Replicas())
+		}
+		conf, err := nodeconfig.NewForCluster(nodeconfig.ClusterOptions{
+			Mesh:                mesh,
+			Group:               group,
+			ExternalURLs:        externalURLs,
+			ReplicaExternalURLs: replicaURLs,
+			JoinServer:          opts.JoinServer,
+			ClusterDomain:       clusterDomain,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("node group %s: %w", group.GetName(), err)
+		}
+		out = append(out, resources.RenderClusterNodeGroup(mesh, group, conf, groupFamilies, images.MetricsProxy)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inspect contains readers for the observed state of meshes.
+package inspect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// MeshStatus is a summary of the observed state of a mesh.
+type MeshStatus struct {
+	// Mesh is the mesh.
+	Mesh *meshv1.Mesh
+	// AdminCertificate is the status of the admin certificate.
+	AdminCertificate CertificateStatus
+	// Groups are the node groups in the mesh, sorted by namespace and name.
+	Groups []NodeGroupStatus
+}
+
+// NodeGroupStatus is a summary of the observed state of a node group.
+type NodeGroupStatus struct {
+	// Group is the node group.
+	Group *meshv1.NodeGroup
+	// Replicas is the desired number of replicas.
+	Replicas int32
+	// ReadyReplicas is the number of ready replicas. It is nil for groups
+	// not running in the cluster.
+	ReadyReplicas *int32
+	// LBAddresses are the external addresses of the group's load balancer.
+	LBAddresses []string
+	// Certificates are the statuses of the node certificates.
+	Certificates []CertificateStatus
+}
+
+// CertificateStatus is the observed state of a certificate.
+type CertificateStatus struct {
+	// Name is the name of the certificate.
+	Name string
+	// Ready is true if the certificate has been issued.
+	Ready bool
+	// NotAfter is when the certificate expires, if it has been issued.
+	NotAfter *time.Time
+}
+
+// GetMeshStatus returns the observed state of the given mesh and the node
+// groups in its namespace that belong to it.
+func GetMeshStatus(ctx context.Context, cli client.Client, mesh *meshv1.Mesh) (*MeshStatus, error) {
+	admin, err := GetCertificateStatus(ctx, cli, mesh.GetNamespace(), meshv1.MeshAdminCertName(mesh))
+	if err != nil {
+		return nil, err
+	}
+	status := &MeshStatus{Mesh: mesh, AdminCertificate: admin}
+	var groups meshv1.NodeGroupList
+	if err := cli.List(ctx, &groups, client.InNamespace(mesh.GetNamespace())); err != nil {
+		return nil, fmt.Errorf("list node groups: %w", err)
+	}
+	for i := range groups.Items {
+		group := &groups.Items[i]
+		if group.MeshKey() != client.ObjectKeyFromObject(mesh) {
+			continue
+		}
+		groupStatus, err := GetNodeGroupStatus(ctx, cli, mesh, group)
+		if err != nil {
+			return nil, err
+		}
+		status.Groups = append(status.Groups, *groupStatus)
+	}
+	sort.Slice(status.Groups, func(i, j int) bool {
+		a, b := status.Groups[i].Group, status.Groups[j].Group
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return status, nil
+}
+
+// GetNodeGroupStatus returns the observed state of the given node group.
+func GetNodeGroupStatus(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (*NodeGroupStatus, error) {
+	status := &NodeGroupStatus{
+		Group:    group,
+		Replicas: group.Replicas(),
+	}
+	if group.Spec.Cluster != nil {
+		var sts appsv1.StatefulSet
+		err := cli.Get(ctx, client.ObjectKey{
+			Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, group),
+			Namespace: group.GetNamespace(),
+		}, &sts)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("get statefulset: %w", err)
+		}
+		ready := sts.Status.ReadyReplicas
+		status.ReadyReplicas = &ready
+		if group.Spec.Cluster.Service != nil {
+			for _, lb := range resources.NewNodeGroupLBServices(mesh, group, meshv1.ServiceIPFamilies{}) {
+				var svc corev1.Service
+				err := cli.Get(ctx, client.ObjectKeyFromObject(lb), &svc)
+				if client.IgnoreNotFound(err) != nil {
+					return nil, fmt.Errorf("get load balancer service: %w", err)
+				}
+				if err == nil {
+					addrs, err := LBExternalIPs(&svc)
+					if err != nil && !errors.Is(err, ErrLBNotReady) {
+						return nil, err
+					}
+					status.LBAddresses = append(status.LBAddresses, addrs...)
+				}
+			}
+		}
+	}
+	for i := 0; i < int(group.Replicas()); i++ {
+		cert, err := GetCertificateStatus(ctx, cli, group.GetNamespace(), meshv1.MeshNodeCertName(mesh, group, i))
+		if err != nil {
+			return nil, err
+		}
+		status.Certificates = append(status.Certificates, cert)
+	}
+	return status, nil
+}
+
+// GetCertificateStatus returns the observed state of the named certificate.
+// A certificate that does not exist is reported as not ready.
+func GetCertificateStatus(ctx context.Context, cli client.Client, namespace, name string) (CertificateStatus, error) {
+	status := CertificateStatus{Name: name}
+	var cert certv1.Certificate
+	err := cli.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, &cert)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return status, nil
+		}
+		return status, fmt.Errorf("get certificate %s: %w", name, err)
+	}
+	for _, cond := range cert.Status.Conditions {
+		if cond.Type == certv1.CertificateConditionReady {
+			status.Ready = cond.Status == cmmeta.ConditionTrue
+		}
+	}
+	if cert.Status.NotAfter != nil {
+		notAfter := cert.Status.NotAfter.Time
+		status.NotAfter = &notAfter
+	}
+	return status, nil
+}
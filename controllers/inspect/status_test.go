@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+var _ = Describe("GetMeshStatus", func() {
+	ctx := context.Background()
+	notAfter := metav1.NewTime(time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second))
+
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "status-mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{
+			Issuer: meshv1.IssuerConfig{Create: true},
+		},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "status-group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:     corev1.ObjectReference{Name: "status-mesh"},
+			Replicas: func() *int32 { r := int32(1); return &r }(),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{Type: corev1.ServiceTypeLoadBalancer},
+			},
+		},
+	}
+
+	BeforeEach(func() {
+		By("creating the mesh and node group fixtures")
+		Expect(k8sClient.Create(ctx, mesh.DeepCopy())).To(Succeed())
+		Expect(k8sClient.Create(ctx, group.DeepCopy())).To(Succeed())
+		mesh.Default()
+		group.Spec.Default()
+
+		for _, obj := range resources.RenderNodeCertificates(mesh, group, meshv1.DefaultClusterDomain) {
+			cert := obj.(*certv1.Certificate)
+			cert.OwnerReferences = nil
+			Expect(k8sClient.Create(ctx, cert)).To(Succeed())
+			cert.Status = certv1.CertificateStatus{
+				Conditions: []certv1.CertificateCondition{{
+					Type:   certv1.CertificateConditionReady,
+					Status: cmmeta.ConditionTrue,
+				}},
+				NotAfter: &notAfter,
+			}
+			Expect(k8sClient.Status().Update(ctx, cert)).To(Succeed())
+		}
+
+		conf, err := nodeconfig.NewForCluster(nodeconfig.ClusterOptions{
+			Mesh:       mesh,
+			Group:      group,
+			JoinServer: "bootstrap:8443",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sts := resources.NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, conf.Checksum())
+		sts.OwnerReferences = nil
+		Expect(k8sClient.Create(ctx, sts)).To(Succeed())
+		sts.Status.Replicas = 1
+		sts.Status.ReadyReplicas = 1
+		Expect(k8sClient.Status().Update(ctx, sts)).To(Succeed())
+
+		svc := resources.NewNodeGroupLBService(mesh, group, meshv1.ServiceIPFamilies{})
+		svc.OwnerReferences = nil
+		Expect(k8sClient.Create(ctx, svc)).To(Succeed())
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+		Expect(k8sClient.Status().Update(ctx, svc)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		for _, obj := range []client.Object{
+			&meshv1.Mesh{ObjectMeta: mesh.ObjectMeta},
+			&meshv1.NodeGroup{ObjectMeta: group.ObjectMeta},
+			resources.NewNodeCertificate(mesh, group, 0, meshv1.DefaultClusterDomain),
+			resources.NewNodeGroupLBService(mesh, group, meshv1.ServiceIPFamilies{}),
+		} {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+		}
+		sts := resources.NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "")
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, sts))).To(Succeed())
+	})
+
+	It("reports readiness, addresses, and certificate expiry", func() {
+		status, err := GetMeshStatus(ctx, k8sClient, mesh)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("reporting the admin certificate as not issued")
+		Expect(status.AdminCertificate.Ready).To(BeFalse())
+		Expect(status.AdminCertificate.NotAfter).To(BeNil())
+
+		By("reporting the node group")
+		Expect(status.Groups).To(HaveLen(1))
+		groupStatus := status.Groups[0]
+		Expect(groupStatus.Group.GetName()).To(Equal(group.GetName()))
+		Expect(groupStatus.Replicas).To(Equal(int32(1)))
+		Expect(groupStatus.ReadyReplicas).NotTo(BeNil())
+		Expect(*groupStatus.ReadyReplicas).To(Equal(int32(1)))
+		Expect(groupStatus.LBAddresses).To(ConsistOf("203.0.113.10"))
+		Expect(groupStatus.Certificates).To(HaveLen(1))
+		Expect(groupStatus.Certificates[0].Ready).To(BeTrue())
+		Expect(groupStatus.Certificates[0].NotAfter).NotTo(BeNil())
+		Expect(groupStatus.Certificates[0].NotAfter.Equal(notAfter.Time)).To(BeTrue())
+	})
+})
+
+func TestGetMeshStatusListsMeshNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "team"}}
+	local := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "team"},
+		Spec:       meshv1.NodeGroupSpec{Mesh: corev1.ObjectReference{Name: "mesh"}},
+	}
+	other := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "other"},
+		Spec:       meshv1.NodeGroupSpec{Mesh: corev1.ObjectReference{Name: "mesh", Namespace: "team"}},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(mesh, local, other).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, cli client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				// Callers may only be allowed to list in the namespace
+				// of the mesh
+				var listOpts client.ListOptions
+				listOpts.ApplyOptions(opts)
+				if listOpts.Namespace != mesh.GetNamespace() {
+					t.Errorf("expected a list in namespace %s, got %q", mesh.GetNamespace(), listOpts.Namespace)
+				}
+				return cli.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	status, err := GetMeshStatus(context.Background(), cli, mesh)
+	if err != nil {
+		t.Fatalf("get mesh status: %v", err)
+	}
+	if len(status.Groups) != 1 || status.Groups[0].Group.GetName() != "local" {
+		t.Errorf("expected only the group in the namespace of the mesh, got %d groups", len(status.Groups))
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// labelMigrationRetryInterval is how often an object is requeued while a
+// StatefulSet is recreated with a migrated selector.
+const labelMigrationRetryInterval = 5 * time.Second
+
+// migrateLabels adds the keys of the given selector to the objects of the lists
+// that are only labeled with its legacy keys. Objects labeled by previous
+// versions of the operator are then matched by the selector. It is a no-op
+// unless group label keys are enabled.
+func migrateLabels(ctx context.Context, cli client.Client, namespace string, selector map[string]string, lists ...client.ObjectList) error {
+	if !meshv1.GroupLabelKeys() {
+		return nil
+	}
+	for _, list := range lists {
+		err := cli.List(ctx, list,
+			client.InNamespace(namespace),
+			client.MatchingLabels(meshv1.LegacySelector(selector)))
+		if err != nil {
+			return fmt.Errorf("list objects with legacy labels: %w", err)
+		}
+		objs, err := meta.ExtractList(list)
+		if err != nil {
+			return fmt.Errorf("extract objects with legacy labels: %w", err)
+		}
+		for _, o := range objs {
+			obj, ok := o.(client.Object)
+			if !ok {
+				continue
+			}
+			labels := obj.GetLabels()
+			migrated := true
+			for k, v := range selector {
+				migrated = migrated && labels[k] == v
+			}
+			if migrated {
+				continue
+			}
+			log.FromContext(ctx).Info("Migrating legacy labels",
+				"kind", fmt.Sprintf("%T", obj), "name", obj.GetName())
+			patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+			for k, v := range selector {
+				labels[k] = v
+			}
+			obj.SetLabels(labels)
+			if err := cli.Patch(ctx, obj, patch); err != nil {
+				return fmt.Errorf("migrate labels of %s: %w", obj.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateStatefulSetSelector deletes the StatefulSet with the given key if it
+// still selects its pods by the legacy keys of the selector. Selectors are
+// immutable, so the StatefulSet has to be created again with the selector.
+// With orphan set its pods are kept and adopted by the new StatefulSet, which
+// requires them to carry the keys of the selector already. Otherwise they are
+// replaced. It returns true while the StatefulSet is being deleted.
+func migrateStatefulSetSelector(ctx context.Context, cli client.Client, key client.ObjectKey, selector map[string]string, orphan bool) (bool, error) {
+	if !meshv1.GroupLabelKeys() {
+		return false, nil
+	}
+	var sts appsv1.StatefulSet
+	if err := cli.Get(ctx, key, &sts); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get statefulset: %w", err)
+	}
+	if sts.GetDeletionTimestamp() != nil {
+		return true, nil
+	}
+	// StatefulSets selecting pods any other way are left alone
+	if sts.Spec.Selector == nil || !equality.Semantic.DeepEqual(sts.Spec.Selector.MatchLabels, meshv1.LegacySelector(selector)) {
+		return false, nil
+	}
+	propagation := metav1.DeletePropagationBackground
+	if orphan {
+		propagation = metav1.DeletePropagationOrphan
+	}
+	log.FromContext(ctx).Info("Recreating statefulset to migrate its selector",
+		"statefulset", sts.GetName(), "propagation", propagation)
+	// The StatefulSet is never deleted in a dry run, don't wait for it
+	if simulate(ctx, "recreate statefulset", "name", sts.GetName()) {
+		return false, nil
+	}
+	if err := cli.Delete(ctx, &sts, client.PropagationPolicy(propagation)); client.IgnoreNotFound(err) != nil {
+		return false, fmt.Errorf("delete statefulset: %w", err)
+	}
+	return true, nil
+}
+
+// migrateNodeGroupLabels migrates the objects of a cluster node group labeled
+// with legacy keys. Its pods are relabeled before its StatefulSet is orphaned
+// and created again, so that the nodes keep running with their data. Groups
+// adopting an existing StatefulSet keep its selector. It returns true while the
+// StatefulSet is being recreated.
+func migrateNodeGroupLabels(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (bool, error) {
+	selector := meshv1.NodeGroupSelector(mesh, group)
+	err := migrateLabels(ctx, cli, group.GetNamespace(), selector,
+		&corev1.PodList{}, &corev1.PersistentVolumeClaimList{}, &corev1.ConfigMapList{})
+	if err != nil {
+		return false, err
+	}
+	if group.Spec.Cluster.AdoptExisting != nil {
+		return false, nil
+	}
+	return migrateStatefulSetSelector(ctx, cli, client.ObjectKey{
+		Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, group),
+		Namespace: group.GetNamespace(),
+	}, selector, true)
+}
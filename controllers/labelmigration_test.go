@@ -0,0 +1,193 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// loadFixtures decodes the objects of a multi-document YAML file in testdata.
+func loadFixtures(t *testing.T, scheme *runtime.Scheme, name string) []client.Object {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	var objs []client.Object
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			t.Fatalf("decode fixture: %v", err)
+		}
+		objs = append(objs, obj.(client.Object))
+	}
+	return objs
+}
+
+func TestMigrateLegacyLabels(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Image:   meshv1.DefaultNodeImage,
+			Cluster: &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	peering := &meshv1.MeshPeering{ObjectMeta: metav1.ObjectMeta{Name: "peering", Namespace: "default"}}
+	stsKey := client.ObjectKey{Name: "mesh-group", Namespace: "default"}
+	bridgeKey := client.ObjectKey{Name: "peering-bridge", Namespace: "default"}
+	exists := func(t *testing.T, cli client.Client, key client.ObjectKey) bool {
+		t.Helper()
+		err := cli.Get(ctx, key, &appsv1.StatefulSet{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(loadFixtures(t, scheme, "legacy-labels.yaml")...).Build()
+		recreating, err := migrateNodeGroupLabels(ctx, cli, mesh, group)
+		if err != nil || recreating {
+			t.Fatalf("expected no migration, got %v, %v", recreating, err)
+		}
+		if !exists(t, cli, stsKey) {
+			t.Fatalf("expected the statefulset to be kept")
+		}
+		var pod corev1.Pod
+		if err := cli.Get(ctx, client.ObjectKey{Name: "mesh-group-0", Namespace: "default"}, &pod); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := pod.Labels[meshv1.GroupLabelPrefix+"nodegroup-name"]; ok {
+			t.Errorf("expected the pod not to be relabeled")
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		meshv1.SetGroupLabelKeys(true)
+		defer meshv1.SetGroupLabelKeys(false)
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(loadFixtures(t, scheme, "legacy-labels.yaml")...).Build()
+
+		recreating, err := migrateNodeGroupLabels(ctx, cli, mesh, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !recreating || exists(t, cli, stsKey) {
+			t.Fatalf("expected the statefulset with the legacy selector to be deleted")
+		}
+		// The orphaned pods, their claims, and the config are matched by the
+		// selector of the recreated StatefulSet.
+		sts := resources.NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+		selector := labels.SelectorFromSet(sts.Spec.Selector.MatchLabels)
+		for key := range sts.Spec.Selector.MatchLabels {
+			if key == meshv1.LegacyLabelKey(key) {
+				t.Errorf("expected the selector to use group label keys, got %s", key)
+			}
+		}
+		for _, list := range []client.ObjectList{&corev1.PodList{}, &corev1.PersistentVolumeClaimList{}, &corev1.ConfigMapList{}} {
+			if err := cli.List(ctx, list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+				t.Fatal(err)
+			}
+			var count int
+			switch list := list.(type) {
+			case *corev1.PodList:
+				count = len(list.Items)
+			case *corev1.PersistentVolumeClaimList:
+				count = len(list.Items)
+			case *corev1.ConfigMapList:
+				count = len(list.Items)
+			}
+			if count != 1 {
+				t.Errorf("expected %T to match one migrated object, got %d", list, count)
+			}
+		}
+		// Migrated objects keep their legacy labels
+		var pod corev1.Pod
+		if err := cli.Get(ctx, client.ObjectKey{Name: "mesh-group-0", Namespace: "default"}, &pod); err != nil {
+			t.Fatal(err)
+		}
+		if pod.Labels[meshv1.NodeGroupNameLabel] != "group" {
+			t.Errorf("expected the pod to keep its legacy labels, got %v", pod.Labels)
+		}
+		if name, _ := meshv1.LabelValue(pod.Labels, meshv1.NodeGroupNameLabel); name != "group" {
+			t.Errorf("expected the group name from the migrated label, got %q", name)
+		}
+
+		// Once recreated there is nothing left to migrate
+		if err := cli.Create(ctx, sts); err != nil {
+			t.Fatal(err)
+		}
+		recreating, err = migrateNodeGroupLabels(ctx, cli, mesh, group)
+		if err != nil || recreating {
+			t.Fatalf("expected the migration to be complete, got %v, %v", recreating, err)
+		}
+		if !exists(t, cli, stsKey) {
+			t.Fatalf("expected the recreated statefulset to be kept")
+		}
+
+		// The bridge of a peering is replaced with its StatefulSet
+		recreating, err = migrateStatefulSetSelector(ctx, cli, bridgeKey, meshv1.MeshPeeringSelector(peering), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !recreating || exists(t, cli, bridgeKey) {
+			t.Fatalf("expected the bridge statefulset with the legacy selector to be deleted")
+		}
+	})
+}
@@ -19,36 +19,67 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	ctlconfig "github.com/webmeshproj/webmesh/pkg/cmd/ctlcmd/config"
+	"google.golang.org/api/option"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/configstore"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
 	"github.com/webmeshproj/operator/controllers/resources"
 )
 
 // MeshReconciler reconciles a Mesh object
 type MeshReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Namespaced is true when the operator is restricted to a set of
+	// namespaces. Cluster-scoped resources are neither watched nor created.
+	Namespaced bool
+	// ApplyBudget is the maximum number of objects patched in a single
+	// reconcile. Zero is unlimited.
+	ApplyBudget int
+	// Shard is the subset of meshes reconciled by this replica.
+	Shard *fairness.Shard
+	// Warmup staggers the first reconciles after becoming leader.
+	Warmup *fairness.Warmup
+	// Limiter bounds concurrent calls to cloud providers.
+	Limiter *fairness.Limiter
+	// DryRun simulates changes instead of making them. Writes are made with
+	// a server-side dry run, except for the status of the reconciled objects.
+	DryRun bool
+	// Settings resolves the operator settings of each reconcile.
+	Settings *operatorconfig.Store
+
+	// dnsOptions are extra client options for the DNS API, used by tests.
+	dnsOptions []option.ClientOption
 }
 
-// TODO: Lookup referenced groups and delete them too
-// const meshesForegroundDeletion = "meshes.mesh.webmesh.io"
+// meshesForegroundDeletion is the finalizer of meshes with resources outside
+// of the cluster, see needsFinalizer.
+const meshesForegroundDeletion = "meshes.mesh.webmesh.io"
 
 //+kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cert-manager.io,resources=clusterissuers;issuers;certificates,verbs=get;list;watch;create;update;patch;delete
@@ -56,71 +87,213 @@ type MeshReconciler struct {
 //+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshes/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *MeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
+	if !r.Shard.Owns(req.NamespacedName) {
+		return ctrl.Result{}, nil
+	}
+	if delay := r.Warmup.Delay("Mesh/" + req.String()); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
 	var mesh meshv1.Mesh
 	if err := r.Get(ctx, req.NamespacedName, &mesh); err != nil {
 		if client.IgnoreNotFound(err) != nil {
 			log.Error(err, "unable to fetch Mesh")
+		} else {
+			r.Shard.Track(req.NamespacedName, false)
+			resources.ForgetOwned("Mesh", req.NamespacedName)
 		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	r.Shard.Track(req.NamespacedName, true)
 
 	log.Info("Reconciling Mesh")
-	toApply := make([]client.Object, 0)
 
-	// Create an issuer if requested
-	if mesh.Spec.Issuer.Create {
-		toApply = append(toApply,
-			resources.NewMeshSelfSigner(&mesh),
-			resources.NewMeshCACertificate(&mesh),
-			resources.NewMeshIssuer(&mesh),
-		)
+	if r.DryRun {
+		ctx = resources.WithDryRun(ctx)
+	}
+	settings, err := r.Settings.Snapshot(ctx, req.Namespace)
+	if err != nil {
+		log.Error(err, "unable to resolve operator settings")
+		return ctrl.Result{}, err
+	}
+	ctx = operatorconfig.WithSettings(ctx, settings)
+	if err := reconcileSimulated(ctx, r.Client, &mesh, &mesh.Status.Conditions, r.DryRun); err != nil {
+		log.Error(err, "unable to update Mesh status")
+		return ctrl.Result{}, err
 	}
 
-	// Create the admin certificate
-	toApply = append(toApply, resources.NewMeshAdminCertificate(&mesh))
+	// Default the spec in case the object was created without going
+	// through the webhooks.
+	mesh.DefaultImage(settings.Images.Node)
+	mesh.Default()
 
-	// Create the bootstrap group
-	bootstraps := mesh.BootstrapGroups()
-	for _, group := range bootstraps {
-		toApply = append(toApply, group)
+	if mesh.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, r.reconcileDelete(ctx, &mesh)
 	}
 
-	// Apply the resources
+	res, err := r.reconcileMesh(resources.WithApplyBudget(ctx, r.ApplyBudget), &mesh)
+	res, err = requeueOnApplyBudget(ctx, res, err)
+	res, err = recordReconcile(ctx, r.Client, &mesh, &mesh.Status.ReconcileStatus, res, err)
+	return reconcileRestricted(ctx, r.Client, &mesh, &mesh.Status.Conditions, res, err)
+}
+
+// reconcileMesh creates the resources of a mesh and writes its generated configs.
+// If the operator is forbidden from managing resources outside of the mesh's
+// namespace, the rest of the mesh is still reconciled before the forbidden error
+// is returned.
+func (r *MeshReconciler) reconcileMesh(ctx context.Context, mesh *meshv1.Mesh) (res ctrl.Result, err error) {
+	log := log.FromContext(ctx)
+
+	// Set finalizers if there are external resources to clean up
+	if needsFinalizer(mesh) && !controllerutil.ContainsFinalizer(mesh, meshesForegroundDeletion) {
+		log.Info("Adding finalizer to mesh")
+		controllerutil.AddFinalizer(mesh, meshesForegroundDeletion)
+		if err := r.Update(ctx, mesh); err != nil {
+			log.Error(err, "unable to add finalizer to mesh")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Bootstrap groups that are no longer applied are still looked up by label
+	err = migrateLabels(ctx, r.Client, mesh.GetNamespace(), meshv1.MeshBootstrapGroupSelector(mesh), &meshv1.NodeGroupList{})
+	if err != nil {
+		log.Error(err, "unable to migrate legacy labels")
+		return ctrl.Result{}, err
+	}
+
+	// Create the issuer, admin certificate, and bootstrap groups
+	bootstraps := mesh.BootstrapGroups()
+	toApply, outside := partitionNamespace(resources.RenderMesh(mesh), mesh.GetNamespace())
+	restricted := applyOutsideNamespace(ctx, r.Client, r.Namespaced, outside)
+	if restricted != nil && !apierrors.IsForbidden(restricted) {
+		log.Error(restricted, "unable to apply resources")
+		return ctrl.Result{}, restricted
+	}
+	defer func() {
+		if err == nil {
+			err = restricted
+		}
+	}()
 	if err := resources.Apply(ctx, r.Client, toApply); err != nil {
 		log.Error(err, "unable to apply resources")
 		return ctrl.Result{}, err
 	}
+	staleRes, err := r.reconcileStaleBootstrapGroups(ctx, mesh, bootstraps)
+	if err != nil {
+		log.Error(err, "unable to delete removed bootstrap groups")
+		return ctrl.Result{}, err
+	}
+
+	// Record the domain the mesh was bootstrapped with
+	if mesh.Status.Domain != mesh.Spec.Domain {
+		mesh.Status.Domain = mesh.Spec.Domain
+		if err := r.Status().Update(ctx, mesh); err != nil {
+			log.Error(err, "unable to update mesh status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Delete discovery records that are no longer published
+	if getMeshDNSConfig(mesh) == nil && mesh.Status.DiscoveryRecords != nil {
+		log.Info("Deleting discovery records no longer published")
+		if err := r.deleteDiscoveryRecords(ctx, mesh); err != nil {
+			log.Error(err, "unable to delete discovery records")
+			return ctrl.Result{}, err
+		}
+	}
+
+	issuerReady, err := r.reconcileIssuerReady(ctx, mesh)
+	if err != nil {
+		log.Error(err, "unable to check issuer")
+		return ctrl.Result{}, err
+	}
 
 	// Get the admin certificate
 	var cert corev1.Secret
-	err := r.Get(ctx, client.ObjectKey{
-		Name:      meshv1.MeshAdminCertName(&mesh),
+	err = r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshAdminCertName(mesh),
 		Namespace: mesh.GetNamespace(),
 	}, &cert)
+	if apierrors.IsNotFound(err) && !issuerReady {
+		log.Info("issuer not ready, waiting to issue admin certificate")
+		return r.issuerNotReadyResult(mesh), nil
+	}
 	if err != nil {
 		log.Error(err, "unable to fetch admin certificate secret")
 		return ctrl.Result{}, err
 	}
 	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
 		if data, ok := cert.Data[key]; !ok || len(data) == 0 {
+			if !issuerReady {
+				log.Info("issuer not ready, waiting to issue admin certificate")
+				return r.issuerNotReadyResult(mesh), nil
+			}
 			log.Info("admin certificate secret missing data, requeueing")
 			return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 3}, nil
 		}
 	}
 
-	// Write the manager config
-	err = r.writeManagerConfig(ctx, &mesh, bootstraps[0], &cert)
+	// Get the store for generated configs
+	store, err := configstore.New(ctx, r.Client, mesh)
+	if err != nil {
+		log.Error(err, "unable to create config store")
+		return ctrl.Result{}, err
+	}
+	if mesh.Spec.AdminConfig.Store != meshv1.AdminConfigStoreKubernetesSecret {
+		// Remove any configs left over from before the store was changed
+		secrets := configstore.NewKubernetesSecretStore(r.Client, mesh)
+		for _, name := range generatedConfigNames(mesh) {
+			if err := secrets.Delete(ctx, name); err != nil {
+				log.Error(err, "unable to delete config secret", "name", name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// Write the manager config with its own certificate, bound to the
+	// Manager role with the access profiles
+	var managerCert corev1.Secret
+	err = r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshManagerCertName(mesh),
+		Namespace: mesh.GetNamespace(),
+	}, &managerCert)
+	if client.IgnoreNotFound(err) != nil {
+		log.Error(err, "unable to fetch manager certificate secret")
+		return ctrl.Result{}, err
+	}
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
+		if data, ok := managerCert.Data[key]; !ok || len(data) == 0 {
+			log.Info("manager certificate secret missing data, requeueing")
+			return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 3}, nil
+		}
+	}
+	err = r.writeManagerConfig(ctx, store, mesh, bootstraps[0], &managerCert)
 	if err != nil {
 		log.Error(err, "unable to write manager config")
 		return ctrl.Result{}, err
 	}
 
+	// Write the access profile configs and bind their roles
+	profileRes, err := r.reconcileAccessProfiles(ctx, store, mesh, bootstraps[0], &cert)
+	if err != nil {
+		log.Error(err, "unable to reconcile access profiles")
+		return ctrl.Result{}, err
+	}
+
+	// Report the addresses leased to each node group
+	usageRes, err := r.reconcileCIDRUsage(ctx, mesh)
+	if err != nil {
+		log.Error(err, "unable to report CIDR usage")
+		return ctrl.Result{}, err
+	}
+
 	// Find the public bootstrap group, if any
 	var publicBootstrap *meshv1.NodeGroup
 	for _, group := range bootstraps {
@@ -130,23 +303,38 @@ func (r *MeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		}
 	}
 
+	if profileRes.IsZero() {
+		profileRes = staleRes
+	}
+	if profileRes.IsZero() {
+		profileRes = usageRes
+	}
 	if publicBootstrap == nil {
 		// We are done here, we can't generate an admin config
 		// without an exposed service
-		return ctrl.Result{}, nil
+		return profileRes, nil
 	}
 
-	return r.writeAdminConfig(ctx, &mesh, publicBootstrap, &cert)
+	res, err = r.writeAdminConfig(ctx, store, mesh, publicBootstrap, &cert)
+	if err == nil && res.IsZero() {
+		res = profileRes
+	}
+	return res, err
 }
 
-func (r *MeshReconciler) writeManagerConfig(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, cert *corev1.Secret) error {
+func (r *MeshReconciler) writeManagerConfig(ctx context.Context, store configstore.Store, mesh *meshv1.Mesh, group *meshv1.NodeGroup, cert *corev1.Secret) error {
+	serverName := meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group, operatorconfig.FromContext(ctx).ClusterDomain)
+	verifyChainOnly, _, err := ctlVerifyChainOnly(ctx, r.Client, mesh, group, serverName)
+	if err != nil {
+		return err
+	}
 	config := ctlconfig.New()
 	config.Clusters = []ctlconfig.Cluster{
 		{
 			Name: mesh.GetName(),
 			Cluster: ctlconfig.ClusterConfig{
-				Server:                   fmt.Sprintf("%s:%d", meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group), mesh.Spec.Bootstrap.Cluster.Service.GRPCPort),
-				TLSVerifyChainOnly:       true,
+				Server:                   fmt.Sprintf("%s:%d", serverName, mesh.Spec.Bootstrap.Cluster.Service.GRPCPort),
+				TLSVerifyChainOnly:       verifyChainOnly,
 				CertificateAuthorityData: base64.StdEncoding.EncodeToString(cert.Data[cmmeta.TLSCAKey]),
 			},
 		},
@@ -171,29 +359,14 @@ func (r *MeshReconciler) writeManagerConfig(ctx context.Context, mesh *meshv1.Me
 	}
 	config.CurrentContext = mesh.GetName()
 	var buf bytes.Buffer
-	err := config.Marshal(&buf)
+	err = config.Marshal(&buf)
 	if err != nil {
 		return fmt.Errorf("marshal config: %w", err)
 	}
-	return resources.Apply(ctx, r.Client, []client.Object{&corev1.Secret{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: corev1.SchemeGroupVersion.String(),
-			Kind:       "Secret",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            meshv1.MeshManagerConfigName(mesh),
-			Namespace:       mesh.GetNamespace(),
-			Labels:          meshv1.MeshLabels(mesh),
-			Annotations:     mesh.GetAnnotations(),
-			OwnerReferences: meshv1.OwnerReferences(mesh),
-		},
-		Data: map[string][]byte{
-			"config.yaml": buf.Bytes(),
-		},
-	}})
+	return r.putGeneratedConfig(ctx, store, mesh, meshv1.MeshManagerConfigName(mesh), buf.Bytes(), cert)
 }
 
-func (r *MeshReconciler) writeAdminConfig(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, cert *corev1.Secret) (ctrl.Result, error) {
+func (r *MeshReconciler) writeAdminConfig(ctx context.Context, store configstore.Store, mesh *meshv1.Mesh, group *meshv1.NodeGroup, cert *corev1.Secret) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	// Get the LB service
 	externalIPs, err := getLBExternalIPs(ctx, r.Client, mesh, group)
@@ -206,6 +379,14 @@ func (r *MeshReconciler) writeAdminConfig(ctx context.Context, mesh *meshv1.Mesh
 		return ctrl.Result{}, err
 	}
 
+	// Verify the external address once the nodes' certificates
+	// have been reissued with it
+	verifyChainOnly, pending, err := ctlVerifyChainOnly(ctx, r.Client, mesh, group, externalIPs[0])
+	if err != nil {
+		log.Error(err, "unable to check node certificates")
+		return ctrl.Result{}, err
+	}
+
 	// Create a config for the admin
 	config := ctlconfig.New()
 	config.Clusters = []ctlconfig.Cluster{
@@ -213,7 +394,7 @@ func (r *MeshReconciler) writeAdminConfig(ctx context.Context, mesh *meshv1.Mesh
 			Name: mesh.GetName(),
 			Cluster: ctlconfig.ClusterConfig{
 				Server:                   fmt.Sprintf("%s:%d", externalIPs[0], mesh.Spec.Bootstrap.Cluster.Service.GRPCPort),
-				TLSVerifyChainOnly:       true,
+				TLSVerifyChainOnly:       verifyChainOnly,
 				CertificateAuthorityData: base64.StdEncoding.EncodeToString(cert.Data[cmmeta.TLSCAKey]),
 			},
 		},
@@ -245,38 +426,115 @@ func (r *MeshReconciler) writeAdminConfig(ctx context.Context, mesh *meshv1.Mesh
 		return ctrl.Result{}, err
 	}
 
-	// Create a secret for the admin config
-	adminConfigSecret := corev1.Secret{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: corev1.SchemeGroupVersion.String(),
-			Kind:       "Secret",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            meshv1.MeshAdminConfigName(mesh),
-			Namespace:       mesh.GetNamespace(),
-			Labels:          meshv1.MeshLabels(mesh),
-			Annotations:     mesh.GetAnnotations(),
-			OwnerReferences: meshv1.OwnerReferences(mesh),
-		},
-		Data: map[string][]byte{
-			"config.yaml": buf.Bytes(),
-		},
-	}
-	if err := resources.Apply(ctx, r.Client, []client.Object{&adminConfigSecret}); err != nil {
-		log.Error(err, "unable to apply admin config secret")
+	// Store the admin config
+	err = r.putGeneratedConfig(ctx, store, mesh, meshv1.MeshAdminConfigName(mesh), buf.Bytes(), cert)
+	if err != nil {
+		log.Error(err, "unable to store admin config")
 		return ctrl.Result{}, err
 	}
+
+	// Publish discovery records if requested
+	if getMeshDNSConfig(mesh) != nil {
+		if err := r.publishDiscoveryRecords(ctx, mesh, externalIPs, cert); err != nil {
+			log.Error(err, "unable to publish discovery records")
+			return ctrl.Result{}, err
+		}
+	}
+	if pending {
+		log.Info("Node certificates not yet issued for load balancer, requeueing")
+		return ctrl.Result{RequeueAfter: certificateRetryInterval}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
+// putGeneratedConfig writes a config embedding the given client certificate
+// to the store. Stores keeping annotations are given the checksum of the
+// config, the expiry of the certificate and the generation of the mesh, so
+// tools syncing the configs elsewhere can tell when they change. An event is
+// emitted when the content of the config changes.
+func (r *MeshReconciler) putGeneratedConfig(ctx context.Context, store configstore.Store, mesh *meshv1.Mesh, name string, config []byte, cert *corev1.Secret) error {
+	previous, err := store.Get(ctx, name)
+	if err != nil && !errors.Is(err, configstore.ErrNotFound) {
+		return fmt.Errorf("read config %s: %w", name, err)
+	}
+	checksum := fmt.Sprintf("%x", sha256.Sum256(config))
+	data := map[string][]byte{"config.yaml": config}
+	if annotated, ok := store.(configstore.AnnotatedStore); ok {
+		notAfter, err := certificateNotAfter(cert.Data[corev1.TLSCertKey])
+		if err != nil {
+			return fmt.Errorf("parse client certificate: %w", err)
+		}
+		err = annotated.PutAnnotated(ctx, name, data, map[string]string{
+			meshv1.ConfigChecksumAnnotation:            checksum,
+			meshv1.ClientCertificateNotAfterAnnotation: notAfter.UTC().Format(time.RFC3339),
+			meshv1.MeshGenerationAnnotation:            strconv.FormatInt(mesh.GetGeneration(), 10),
+		})
+		if err != nil {
+			return err
+		}
+	} else if err := store.Put(ctx, name, data); err != nil {
+		return err
+	}
+	if !bytes.Equal(previous["config.yaml"], config) {
+		r.Recorder.Eventf(mesh, corev1.EventTypeNormal, "AdminConfigUpdated", "Config %s updated with checksum %s", name, checksum)
+	}
+	return nil
+}
+
+func (r *MeshReconciler) reconcileDelete(ctx context.Context, mesh *meshv1.Mesh) error {
+	if !controllerutil.ContainsFinalizer(mesh, meshesForegroundDeletion) {
+		return nil
+	}
+	log := log.FromContext(ctx)
+	log.Info("Deleting Mesh discovery records")
+	if err := r.deleteDiscoveryRecords(ctx, mesh); err != nil {
+		return err
+	}
+	if mesh.Spec.AdminConfig.Store != meshv1.AdminConfigStoreKubernetesSecret {
+		// Secrets are garbage collected, other stores need cleaning up
+		log.Info("Deleting Mesh configs from store", "store", mesh.Spec.AdminConfig.Store)
+		store, err := configstore.New(ctx, r.Client, mesh)
+		if err != nil {
+			return fmt.Errorf("create config store: %w", err)
+		}
+		for _, name := range generatedConfigNames(mesh) {
+			if err := store.Delete(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	// Remove the finalizer
+	controllerutil.RemoveFinalizer(mesh, meshesForegroundDeletion)
+	if err := r.Update(ctx, mesh); err != nil {
+		return fmt.Errorf("failed to remove finalizer from mesh: %w", err)
+	}
+	return nil
+}
+
+// needsFinalizer returns true if the mesh has resources outside of the
+// cluster that need to be removed when it is deleted.
+func needsFinalizer(mesh *meshv1.Mesh) bool {
+	return getMeshDNSConfig(mesh) != nil ||
+		mesh.Status.DiscoveryRecords != nil ||
+		mesh.Spec.AdminConfig.Store != meshv1.AdminConfigStoreKubernetesSecret
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *MeshReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if r.DryRun {
+		r.Client = NewDryRunClient(r.Client)
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&meshv1.Mesh{}).
 		Owns(&meshv1.NodeGroup{}).
-		Owns(&corev1.Secret{}).
-		Owns(&certv1.ClusterIssuer{}).
+		Owns(&corev1.Secret{})
+	if !r.Namespaced {
+		b = b.Owns(&certv1.ClusterIssuer{}).
+			Watches(&certv1.ClusterIssuer{}, handler.EnqueueRequestsFromMapFunc(r.issuerToMeshes))
+	}
+	return b.
 		Owns(&certv1.Issuer{}).
+		Watches(&certv1.Issuer{}, handler.EnqueueRequestsFromMapFunc(r.issuerToMeshes)).
 		Owns(&certv1.Certificate{}).
 		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
 			refs := o.GetOwnerReferences()
@@ -297,5 +555,6 @@ func (r *MeshReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			}
 			return nil
 		})).
+		Watches(&meshv1.WebmeshOperatorConfig{}, handler.EnqueueRequestsFromMapFunc(operatorConfigToObjects(r.Client, r.Settings, &meshv1.MeshList{}))).
 		Complete(r)
 }
@@ -0,0 +1,372 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	v1 "github.com/webmeshproj/api/v1"
+	ctlconfig "github.com/webmeshproj/webmesh/pkg/cmd/ctlcmd/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/configstore"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// accessProfileBindingPrefix is the prefix of the role bindings the operator
+// manages for access profiles.
+const accessProfileBindingPrefix = "operator-access-"
+
+// managerBindingName is the role binding granting the user of the manager
+// config the Manager role.
+const managerBindingName = "operator-manager-config"
+
+// certificateRetryInterval is how often a mesh is requeued while the node
+// certificates of its public bootstrap group are reissued for the load balancer.
+const certificateRetryInterval = 30 * time.Second
+
+// accessProfileRoles are the mesh roles granted to each access profile role.
+var accessProfileRoles = map[meshv1.AccessProfileRole]*v1.Role{
+	meshv1.AccessProfileRoleReadOnly: {
+		Name: "operator-readonly",
+		Rules: []*v1.Rule{{
+			Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ALL},
+			Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_GET},
+		}},
+	},
+	meshv1.AccessProfileRoleManager: {
+		Name: "operator-manager",
+		Rules: []*v1.Rule{
+			{
+				Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ALL},
+				Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_GET},
+			},
+			{
+				Resources: []v1.RuleResource{
+					v1.RuleResource_RESOURCE_NETWORK_ACLS,
+					v1.RuleResource_RESOURCE_ROUTES,
+					v1.RuleResource_RESOURCE_EDGES,
+					v1.RuleResource_RESOURCE_GROUPS,
+				},
+				Verbs: []v1.RuleVerb{v1.RuleVerb_VERB_ALL},
+			},
+		},
+	},
+}
+
+// reconcileAccessProfiles writes a config for each access profile of the mesh
+// and binds the users of the profiles and of the manager config to their roles
+// through the admin API of the bootstrap group. The certificates, secrets and
+// configs of profiles removed from the mesh are deleted along with their
+// bindings.
+func (r *MeshReconciler) reconcileAccessProfiles(ctx context.Context, store configstore.Store, mesh *meshv1.Mesh, group *meshv1.NodeGroup, admin *corev1.Secret) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if err := r.deleteStaleAccessProfiles(ctx, store, mesh); err != nil {
+		return ctrl.Result{}, err
+	}
+	serverName := meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group, operatorconfig.FromContext(ctx).ClusterDomain)
+	server := fmt.Sprintf("%s:%d", serverName, meshv1.DefaultGRPCPort)
+	verifyChainOnly, _, err := ctlVerifyChainOnly(ctx, r.Client, mesh, group, serverName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range mesh.Spec.AccessProfiles {
+		profile := &mesh.Spec.AccessProfiles[i]
+		var cert corev1.Secret
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      meshv1.MeshAccessProfileCertName(mesh, profile),
+			Namespace: mesh.GetNamespace(),
+		}, &cert)
+		if client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("get access profile certificate secret: %w", err)
+		}
+		for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
+			if data, ok := cert.Data[key]; !ok || len(data) == 0 {
+				log.Info("access profile certificate secret missing data, requeueing", "profile", profile.Name)
+				return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 3}, nil
+			}
+		}
+		config, err := marshalCtlConfig(mesh, server, verifyChainOnly, meshv1.MeshAccessProfileCertName(mesh, profile), &cert)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("marshal access profile config: %w", err)
+		}
+		err = store.Put(ctx, meshv1.MeshAccessProfileConfigName(mesh, profile), map[string][]byte{
+			"config.yaml": config,
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("store access profile config: %w", err)
+		}
+	}
+
+	// Configure the roles and bindings in the mesh
+	conn, err := dialAdminAPI(ctx, server, serverName, admin)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("dial admin API: %w", err)
+	}
+	defer conn.Close()
+	return reconcileRoleBindings(ctx, v1.NewAdminClient(conn), mesh)
+}
+
+// reconcileRoleBindings puts the roles and bindings of the manager config and
+// of the access profiles of the mesh, and deletes the bindings of profiles
+// that were removed. It runs for every mesh, so bindings are removed along
+// with the last profile.
+func reconcileRoleBindings(ctx context.Context, cli v1.AdminClient, mesh *meshv1.Mesh) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	bindings, err := cli.ListRoleBindings(ctx, &emptypb.Empty{})
+	if err != nil {
+		log.Info("admin API not ready, requeueing", "error", err.Error())
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 10}, nil
+	}
+	type grant struct {
+		binding string
+		role    meshv1.AccessProfileRole
+		user    string
+	}
+	grants := []grant{{managerBindingName, meshv1.AccessProfileRoleManager, meshv1.MeshManagerCertName(mesh)}}
+	for i := range mesh.Spec.AccessProfiles {
+		profile := &mesh.Spec.AccessProfiles[i]
+		grants = append(grants, grant{accessProfileBindingPrefix + profile.Name, profile.Role, meshv1.MeshAccessProfileCertName(mesh, profile)})
+	}
+	wanted := make(map[string]struct{}, len(grants))
+	for _, grant := range grants {
+		role, ok := accessProfileRoles[grant.role]
+		if !ok {
+			return ctrl.Result{}, fmt.Errorf("unknown access profile role %q", grant.role)
+		}
+		binding := &v1.RoleBinding{
+			Name: grant.binding,
+			Role: role.Name,
+			Subjects: []*v1.Subject{{
+				Name: grant.user,
+				Type: v1.SubjectType_SUBJECT_USER,
+			}},
+		}
+		wanted[binding.Name] = struct{}{}
+		if simulate(ctx, "put role and binding", "role", role.Name, "binding", binding.Name) {
+			continue
+		}
+		if _, err := cli.PutRole(ctx, role); err != nil {
+			return ctrl.Result{}, fmt.Errorf("put role %s: %w", role.Name, err)
+		}
+		if _, err := cli.PutRoleBinding(ctx, binding); err != nil {
+			return ctrl.Result{}, fmt.Errorf("put role binding %s: %w", binding.Name, err)
+		}
+	}
+	for _, binding := range bindings.GetItems() {
+		if !strings.HasPrefix(binding.GetName(), accessProfileBindingPrefix) {
+			continue
+		}
+		if _, ok := wanted[binding.GetName()]; ok {
+			continue
+		}
+		log.Info("Deleting role binding for removed access profile", "binding", binding.GetName())
+		if simulate(ctx, "delete role binding", "binding", binding.GetName()) {
+			continue
+		}
+		if _, err := cli.DeleteRoleBinding(ctx, binding); err != nil {
+			return ctrl.Result{}, fmt.Errorf("delete role binding %s: %w", binding.GetName(), err)
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// deleteStaleAccessProfiles deletes the certificates, secrets and configs of
+// the access profiles recorded in the status of the mesh that are no longer in
+// its spec, then records the current profiles.
+func (r *MeshReconciler) deleteStaleAccessProfiles(ctx context.Context, store configstore.Store, mesh *meshv1.Mesh) error {
+	log := log.FromContext(ctx)
+	current := make([]string, 0, len(mesh.Spec.AccessProfiles))
+	for _, profile := range mesh.Spec.AccessProfiles {
+		current = append(current, profile.Name)
+	}
+	for _, name := range mesh.Status.AccessProfiles {
+		if slices.Contains(current, name) {
+			continue
+		}
+		profile := &meshv1.AccessProfile{Name: name}
+		log.Info("Deleting removed access profile", "profile", name)
+		meta := metav1.ObjectMeta{Name: meshv1.MeshAccessProfileCertName(mesh, profile), Namespace: mesh.GetNamespace()}
+		if err := r.Delete(ctx, &certv1.Certificate{ObjectMeta: meta}); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("delete certificate of access profile %s: %w", name, err)
+		}
+		// cert-manager leaves the secret behind
+		if err := r.Delete(ctx, &corev1.Secret{ObjectMeta: meta}); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("delete certificate secret of access profile %s: %w", name, err)
+		}
+		if err := store.Delete(ctx, meshv1.MeshAccessProfileConfigName(mesh, profile)); err != nil {
+			return fmt.Errorf("delete config of access profile %s: %w", name, err)
+		}
+	}
+	if slices.Equal(current, mesh.Status.AccessProfiles) {
+		return nil
+	}
+	mesh.Status.AccessProfiles = current
+	if err := r.Status().Update(ctx, mesh); err != nil {
+		return fmt.Errorf("record access profiles: %w", err)
+	}
+	return nil
+}
+
+// dialAdminAPI dials the admin API at the given address using the admin
+// certificate.
+func dialAdminAPI(ctx context.Context, addr, serverName string, admin *corev1.Secret) (*grpc.ClientConn, error) {
+	keyPair, err := tls.X509KeyPair(admin.Data[corev1.TLSCertKey], admin.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("load admin key pair: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(admin.Data[cmmeta.TLSCAKey]) {
+		return nil, fmt.Errorf("no CA certificates found in admin certificate secret")
+	}
+	return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		RootCAs:      roots,
+		ServerName:   serverName,
+	})))
+}
+
+// dialManagerConfig dials the mesh with the manager config read back from the
+// config store of the mesh. It has the access of the Manager role, which is
+// enough for reconcilers that only manage network ACLs or read the mesh.
+func dialManagerConfig(ctx context.Context, cli client.Client, mesh *meshv1.Mesh) (*grpc.ClientConn, error) {
+	store, err := configstore.New(ctx, cli, mesh)
+	if err != nil {
+		return nil, fmt.Errorf("create config store: %w", err)
+	}
+	config, err := readManagerConfig(ctx, store, mesh)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := config.GetDialOptions()
+	if err != nil {
+		return nil, fmt.Errorf("load manager config credentials: %w", err)
+	}
+	return grpc.DialContext(ctx, config.GetCurrentCluster().Server, opts...)
+}
+
+// readManagerConfig reads the manager config of the mesh from the store.
+func readManagerConfig(ctx context.Context, store configstore.Store, mesh *meshv1.Mesh) (*ctlconfig.Config, error) {
+	name := meshv1.MeshManagerConfigName(mesh)
+	data, err := store.Get(ctx, name)
+	if err != nil {
+		if errors.Is(err, configstore.ErrNotFound) {
+			return nil, fmt.Errorf("manager config %s has not been written yet: %w", name, err)
+		}
+		return nil, fmt.Errorf("read manager config: %w", err)
+	}
+	config := ctlconfig.New()
+	if err := config.Unmarshal(bytes.NewReader(data["config.yaml"])); err != nil {
+		return nil, fmt.Errorf("unmarshal manager config: %w", err)
+	}
+	return config, nil
+}
+
+// ctlVerifyChainOnly returns true if configs connecting to the group at the
+// given host should only verify the certificate chain of the nodes. Unless the
+// mesh sets it explicitly, the host is verified once the certificates of every
+// node in the group are valid for it. Until then pending is true.
+func ctlVerifyChainOnly(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, host string) (verifyChainOnly, pending bool, err error) {
+	if mesh.Spec.Security.VerifyChainOnly != nil {
+		return *mesh.Spec.Security.VerifyChainOnly, false, nil
+	}
+	covered, err := certificatesCover(ctx, cli, mesh, group, host)
+	if err != nil {
+		return false, false, fmt.Errorf("check node certificates: %w", err)
+	}
+	if covered {
+		return false, false, nil
+	}
+	return mesh.VerifyChainOnly(nil), true, nil
+}
+
+// marshalCtlConfig returns a config with a single context for the given server
+// of the mesh that authenticates with the given certificate.
+func marshalCtlConfig(mesh *meshv1.Mesh, server string, verifyChainOnly bool, user string, cert *corev1.Secret) ([]byte, error) {
+	name := mesh.GetName()
+	config := ctlconfig.New()
+	config.Clusters = []ctlconfig.Cluster{
+		{
+			Name: name,
+			Cluster: ctlconfig.ClusterConfig{
+				Server:                   server,
+				TLSVerifyChainOnly:       verifyChainOnly,
+				CertificateAuthorityData: base64.StdEncoding.EncodeToString(cert.Data[cmmeta.TLSCAKey]),
+			},
+		},
+	}
+	config.Users = []ctlconfig.User{
+		{
+			Name: user,
+			User: ctlconfig.UserConfig{
+				ClientCertificateData: base64.StdEncoding.EncodeToString(cert.Data[corev1.TLSCertKey]),
+				ClientKeyData:         base64.StdEncoding.EncodeToString(cert.Data[corev1.TLSPrivateKeyKey]),
+			},
+		},
+	}
+	config.Contexts = []ctlconfig.Context{
+		{
+			Name: name,
+			Context: ctlconfig.ContextConfig{
+				Cluster: name,
+				User:    user,
+			},
+		},
+	}
+	config.CurrentContext = name
+	var buf bytes.Buffer
+	if err := config.Marshal(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// generatedConfigNames returns the names of all configs the mesh controller
+// writes to the config store, including those of profiles that were issued
+// configs but are no longer in the spec.
+func generatedConfigNames(mesh *meshv1.Mesh) []string {
+	names := []string{meshv1.MeshManagerConfigName(mesh), meshv1.MeshAdminConfigName(mesh)}
+	for i := range mesh.Spec.AccessProfiles {
+		names = append(names, meshv1.MeshAccessProfileConfigName(mesh, &mesh.Spec.AccessProfiles[i]))
+	}
+	for _, name := range mesh.Status.AccessProfiles {
+		name = meshv1.MeshAccessProfileConfigName(mesh, &meshv1.AccessProfile{Name: name})
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	v1 "github.com/webmeshproj/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeAdmin is an admin API keeping role bindings in memory.
+type fakeAdmin struct {
+	v1.AdminClient
+	bindings map[string]*v1.RoleBinding
+}
+
+func (a *fakeAdmin) ListRoleBindings(context.Context, *emptypb.Empty, ...grpc.CallOption) (*v1.RoleBindings, error) {
+	out := &v1.RoleBindings{}
+	for _, binding := range a.bindings {
+		out.Items = append(out.Items, binding)
+	}
+	return out, nil
+}
+
+func (a *fakeAdmin) PutRole(context.Context, *v1.Role, ...grpc.CallOption) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (a *fakeAdmin) PutRoleBinding(_ context.Context, binding *v1.RoleBinding, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	a.bindings[binding.GetName()] = binding
+	return &emptypb.Empty{}, nil
+}
+
+func (a *fakeAdmin) DeleteRoleBinding(_ context.Context, binding *v1.RoleBinding, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	delete(a.bindings, binding.GetName())
+	return &emptypb.Empty{}, nil
+}
+
+func (a *fakeAdmin) names() []string {
+	var out []string
+	for name := range a.bindings {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestReconcileRoleBindings(t *testing.T) {
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{AccessProfiles: []meshv1.AccessProfile{
+			{Name: "ops", Role: meshv1.AccessProfileRoleManager},
+			{Name: "viewer", Role: meshv1.AccessProfileRoleReadOnly},
+		}},
+	}
+	admin := &fakeAdmin{bindings: map[string]*v1.RoleBinding{
+		// Not managed by the operator
+		"admin": {Name: "admin"},
+	}}
+	if _, err := reconcileRoleBindings(ctx, admin, mesh); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"admin", "operator-access-ops", "operator-access-viewer", managerBindingName}
+	if got := admin.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got bindings %v, want %v", got, want)
+	}
+	if got := admin.bindings[managerBindingName]; got.GetRole() != "operator-manager" || got.GetSubjects()[0].GetName() != meshv1.MeshManagerCertName(mesh) {
+		t.Errorf("expected the manager config to be bound to the manager role, got %v", got)
+	}
+	if got := admin.bindings["operator-access-viewer"]; got.GetRole() != "operator-readonly" || got.GetSubjects()[0].GetName() != "mesh-access-viewer" {
+		t.Errorf("expected the profile to be bound to its role, got %v", got)
+	}
+
+	// Removing the last profile removes its binding too
+	mesh.Spec.AccessProfiles = nil
+	if _, err := reconcileRoleBindings(ctx, admin, mesh); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"admin", managerBindingName}
+	if got := admin.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got bindings %v, want %v", got, want)
+	}
+}
+
+func TestDeleteStaleAccessProfiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, certv1.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec:       meshv1.MeshSpec{AccessProfiles: []meshv1.AccessProfile{{Name: "kept"}}},
+		Status:     meshv1.MeshStatus{AccessProfiles: []string{"kept", "removed"}},
+	}
+	var objects []client.Object
+	store := &memoryStore{data: map[string]map[string][]byte{}, annotations: map[string]map[string]string{}}
+	for _, name := range []string{"kept", "removed"} {
+		profile := &meshv1.AccessProfile{Name: name}
+		meta := metav1.ObjectMeta{Name: meshv1.MeshAccessProfileCertName(mesh, profile), Namespace: "default"}
+		objects = append(objects, &certv1.Certificate{ObjectMeta: meta}, &corev1.Secret{ObjectMeta: meta})
+		store.data[meshv1.MeshAccessProfileConfigName(mesh, profile)] = map[string][]byte{"config.yaml": nil}
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(append(objects, mesh)...).
+		WithStatusSubresource(mesh).
+		Build()
+	r := &MeshReconciler{Client: cli}
+
+	// The removed profile was only recorded in the status, so every
+	// generated config is cleaned up when the mesh is deleted
+	if names := generatedConfigNames(mesh); len(names) != 4 {
+		t.Errorf("expected the configs of removed profiles to be generated names, got %v", names)
+	}
+	if err := r.deleteStaleAccessProfiles(ctx, store, mesh); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		removed := obj.GetName() == "mesh-access-removed"
+		if removed && !apierrors.IsNotFound(err) {
+			t.Errorf("expected %T %s to be deleted, got %v", obj, obj.GetName(), err)
+		}
+		if !removed && err != nil {
+			t.Errorf("expected %T %s to be kept, got %v", obj, obj.GetName(), err)
+		}
+	}
+	if _, ok := store.data["mesh-access-removed-config"]; ok {
+		t.Error("expected the config of the removed profile to be deleted")
+	}
+	if _, ok := store.data["mesh-access-kept-config"]; !ok {
+		t.Error("expected the config of the kept profile to be kept")
+	}
+	var got meshv1.Mesh
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(mesh), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kept"}; !reflect.DeepEqual(got.Status.AccessProfiles, want) {
+		t.Errorf("got recorded profiles %v, want %v", got.Status.AccessProfiles, want)
+	}
+
+	// Nothing is left to delete once the last profile is removed
+	mesh.Spec.AccessProfiles = nil
+	if err := r.deleteStaleAccessProfiles(ctx, store, mesh); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.data) != 0 {
+		t.Errorf("expected no configs left, got %v", store.data)
+	}
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(mesh), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.AccessProfiles) != 0 {
+		t.Errorf("expected no recorded profiles, got %v", got.Status.AccessProfiles)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// staleBootstrapRetryInterval is how often a mesh is requeued while a
+// bootstrap group that is no longer desired is being deleted.
+const staleBootstrapRetryInterval = 10 * time.Second
+
+// reconcileStaleBootstrapGroups deletes the load balancer group of the mesh
+// once its bootstrap group is no longer exposed. The admin API offers no way
+// to remove a raft member, so the group is deleted in the foreground: its
+// StatefulSet and pods are removed before the group itself, and each node
+// leaves the raft cluster as its pod shuts down. The mesh is requeued until
+// the group is gone.
+func (r *MeshReconciler) reconcileStaleBootstrapGroups(ctx context.Context, mesh *meshv1.Mesh, bootstraps []*meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	stale, err := staleBootstrapGroups(ctx, r.Client, mesh, bootstraps)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(stale) == 0 {
+		return ctrl.Result{}, nil
+	}
+	for _, group := range stale {
+		if group.GetDeletionTimestamp() != nil {
+			log.Info("Waiting for removed bootstrap group to leave the mesh", "group", group.GetName())
+			continue
+		}
+		log.Info("Deleting bootstrap group that is no longer exposed", "group", group.GetName())
+		err := r.Delete(ctx, group, client.PropagationPolicy(metav1.DeletePropagationForeground))
+		if client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("delete bootstrap group %s: %w", group.GetName(), err)
+		}
+	}
+	return ctrl.Result{RequeueAfter: staleBootstrapRetryInterval}, nil
+}
+
+// staleBootstrapGroups returns the load balancer groups previously created for
+// the mesh that are not among its desired bootstrap groups. Only groups
+// controlled by the mesh are considered.
+func staleBootstrapGroups(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, bootstraps []*meshv1.NodeGroup) ([]*meshv1.NodeGroup, error) {
+	desired := make(map[string]struct{}, len(bootstraps))
+	for _, group := range bootstraps {
+		desired[group.GetName()] = struct{}{}
+	}
+	var groups meshv1.NodeGroupList
+	err := cli.List(ctx, &groups,
+		client.InNamespace(mesh.GetNamespace()),
+		client.MatchingLabels(meshv1.MeshBootstrapGroupSelector(mesh)))
+	if err != nil {
+		return nil, fmt.Errorf("list bootstrap node groups: %w", err)
+	}
+	var stale []*meshv1.NodeGroup
+	for i := range groups.Items {
+		group := &groups.Items[i]
+		if group.GetName() != meshv1.MeshBootstrapLBGroupName(mesh) {
+			continue
+		}
+		if _, ok := desired[group.GetName()]; ok {
+			continue
+		}
+		if owner := metav1.GetControllerOf(group); owner == nil || owner.UID != mesh.GetUID() {
+			continue
+		}
+		stale = append(stale, group)
+	}
+	return stale, nil
+}
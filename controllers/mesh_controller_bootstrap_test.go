@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestReconcileStaleBootstrapGroups(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{
+		TypeMeta:   metav1.TypeMeta{APIVersion: meshv1.GroupVersion.String(), Kind: "Mesh"},
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default", UID: "mesh-uid"},
+	}
+	// Only the load balancer group is collected, not other groups
+	// carrying the bootstrap labels
+	foreign := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "mesh-bootstrap-extra",
+			Namespace:       "default",
+			Labels:          meshv1.MeshBootstrapGroupSelector(mesh),
+			OwnerReferences: meshv1.OwnerReferences(mesh),
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(foreign).Build()
+	r := &MeshReconciler{Client: cli}
+
+	// ensure creates the desired bootstrap groups the way the mesh
+	// controller applies them and reconciles the stale ones.
+	ensure := func(t *testing.T, exposed bool) {
+		t.Helper()
+		mesh.Spec.Bootstrap.Cluster = &meshv1.NodeGroupClusterConfig{}
+		if exposed {
+			mesh.Spec.Bootstrap.Cluster.Service = &meshv1.NodeGroupLBConfig{Type: corev1.ServiceTypeLoadBalancer}
+		}
+		bootstraps := mesh.BootstrapGroups()
+		for _, group := range bootstraps {
+			err := cli.Create(ctx, group.DeepCopy())
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				t.Fatal(err)
+			}
+		}
+		if _, err := r.reconcileStaleBootstrapGroups(ctx, mesh, bootstraps); err != nil {
+			t.Fatalf("reconcile stale bootstrap groups: %v", err)
+		}
+	}
+	exists := func(t *testing.T, name string) bool {
+		t.Helper()
+		var group meshv1.NodeGroup
+		err := cli.Get(ctx, client.ObjectKey{Name: name, Namespace: "default"}, &group)
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	for i, exposed := range []bool{true, false, true, false} {
+		ensure(t, exposed)
+		if !exists(t, meshv1.MeshBootstrapGroupName(mesh)) {
+			t.Fatalf("step %d: expected the bootstrap group to be kept", i)
+		}
+		if got := exists(t, meshv1.MeshBootstrapLBGroupName(mesh)); got != exposed {
+			t.Fatalf("step %d: expected the load balancer group to exist %v, got %v", i, exposed, got)
+		}
+	}
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(foreign), &meshv1.NodeGroup{}); err != nil {
+		t.Fatalf("expected other bootstrap groups to be kept: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/configstore"
+)
+
+// cidrUsageRetryInterval is how often a mesh is requeued while the nodes of
+// the mesh cannot be listed for its CIDR usage report.
+const cidrUsageRetryInterval = 30 * time.Second
+
+// reconcileCIDRUsage reports the mesh IPv4 addresses leased to each node group
+// of the mesh once every report interval. The nodes are listed with the
+// manager config of the mesh. The report is removed when it is disabled.
+func (r *MeshReconciler) reconcileCIDRUsage(ctx context.Context, mesh *meshv1.Mesh) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	config := mesh.Spec.CIDRUsage
+	if config == nil {
+		if mesh.Status.MeshCIDRUsage == nil && meta.FindStatusCondition(mesh.Status.Conditions, meshv1.CIDRExhaustionCondition) == nil {
+			return ctrl.Result{}, nil
+		}
+		mesh.Status.MeshCIDRUsage = nil
+		meta.RemoveStatusCondition(&mesh.Status.Conditions, meshv1.CIDRExhaustionCondition)
+		if err := r.Status().Update(ctx, mesh); err != nil {
+			return ctrl.Result{}, fmt.Errorf("remove CIDR usage report: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+	if last := mesh.Status.MeshCIDRUsage; last != nil && last.CIDR == mesh.Spec.IPv4 {
+		if wait := time.Until(last.LastReportTime.Add(config.ReportInterval())); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	// List the nodes and the groups they belong to
+	conn, err := dialManagerConfig(ctx, r.Client, mesh)
+	if errors.Is(err, configstore.ErrNotFound) {
+		log.Info("Manager config not written yet for CIDR usage, requeueing")
+		return ctrl.Result{RequeueAfter: cidrUsageRetryInterval}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("dial mesh: %w", err)
+	}
+	defer conn.Close()
+	nodes, err := v1.NewMeshClient(conn).ListNodes(ctx, &emptypb.Empty{})
+	if err != nil {
+		log.Info("Unable to list mesh nodes for CIDR usage, requeueing", "error", err.Error())
+		return ctrl.Result{RequeueAfter: cidrUsageRetryInterval}, nil
+	}
+	var groups meshv1.NodeGroupList
+	if err := r.List(ctx, &groups); err != nil {
+		return ctrl.Result{}, fmt.Errorf("list node groups: %w", err)
+	}
+	var members []meshv1.NodeGroup
+	for _, g := range groups.Items {
+		if g.MeshKey().Name == mesh.GetName() && g.MeshKey().Namespace == mesh.GetNamespace() {
+			members = append(members, g)
+		}
+	}
+	usage, err := meshCIDRUsage(mesh, members, nodes.GetNodes())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	usage.LastReportTime = metav1.Now()
+
+	// Raise the condition once the threshold is crossed
+	threshold := config.UtilizationThreshold()
+	condition := metav1.Condition{
+		Type:               meshv1.CIDRExhaustionCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: mesh.GetGeneration(),
+		Reason:             "BelowThreshold",
+		Message: fmt.Sprintf("%d of %d addresses in %s are leased (%d%%), below the threshold of %d%%",
+			usage.Allocated, usage.Capacity, usage.CIDR, usage.Utilization, threshold),
+	}
+	if usage.Utilization >= threshold {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ThresholdExceeded"
+		condition.Message = fmt.Sprintf("%d of %d addresses in %s are leased (%d%%), at or above the threshold of %d%%",
+			usage.Allocated, usage.Capacity, usage.CIDR, usage.Utilization, threshold)
+		if !meta.IsStatusConditionTrue(mesh.Status.Conditions, meshv1.CIDRExhaustionCondition) {
+			r.Recorder.Eventf(mesh, corev1.EventTypeWarning, "CIDRExhaustion", "%s", condition.Message)
+		}
+	}
+	meta.SetStatusCondition(&mesh.Status.Conditions, condition)
+	mesh.Status.MeshCIDRUsage = usage
+	if err := r.Status().Update(ctx, mesh); err != nil {
+		return ctrl.Result{}, fmt.Errorf("update CIDR usage report: %w", err)
+	}
+	return ctrl.Result{RequeueAfter: config.ReportInterval()}, nil
+}
+
+// meshCIDRUsage counts the addresses of the mesh IPv4 CIDR leased to the given
+// nodes. Nodes are attributed to the group whose replica names their ID
+// matches, taking the longest match when the names of groups overlap.
+func meshCIDRUsage(mesh *meshv1.Mesh, groups []meshv1.NodeGroup, nodes []*v1.MeshNode) (*meshv1.MeshCIDRUsage, error) {
+	cidr, err := netip.ParsePrefix(mesh.Spec.IPv4)
+	if err != nil {
+		return nil, fmt.Errorf("parse mesh IPv4 CIDR: %w", err)
+	}
+	if !cidr.Addr().Is4() {
+		return nil, fmt.Errorf("mesh CIDR %s is not an IPv4 CIDR", mesh.Spec.IPv4)
+	}
+	cidr = cidr.Masked()
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].GetNamespace() != groups[j].GetNamespace() {
+			return groups[i].GetNamespace() < groups[j].GetNamespace()
+		}
+		return groups[i].GetName() < groups[j].GetName()
+	})
+	usage := &meshv1.MeshCIDRUsage{
+		CIDR:     mesh.Spec.IPv4,
+		Capacity: int64(1) << (32 - cidr.Bits()),
+		Groups:   make([]meshv1.NodeGroupCIDRUsage, len(groups)),
+	}
+	prefixes := make([]string, len(groups))
+	for i := range groups {
+		prefixes[i] = meshv1.MeshNodeGroupStatefulSetName(mesh, &groups[i]) + "-"
+		usage.Groups[i] = meshv1.NodeGroupCIDRUsage{
+			Name:      groups[i].GetName(),
+			Namespace: groups[i].GetNamespace(),
+		}
+	}
+	for _, node := range nodes {
+		addr, ok := parseMeshIPv4(node.GetPrivateIpv4())
+		if !ok || !cidr.Contains(addr) {
+			continue
+		}
+		usage.Allocated++
+		match := -1
+		for i, prefix := range prefixes {
+			ordinal, ok := strings.CutPrefix(node.GetId(), prefix)
+			if !ok || ordinal == "" || strings.Trim(ordinal, "0123456789") != "" {
+				continue
+			}
+			if match < 0 || len(prefix) > len(prefixes[match]) {
+				match = i
+			}
+		}
+		if match < 0 {
+			usage.Unassigned++
+			continue
+		}
+		usage.Groups[match].Allocated++
+	}
+	if len(usage.Groups) == 0 {
+		usage.Groups = nil
+	}
+	usage.Remaining = usage.Capacity - usage.Allocated
+	if usage.Remaining < 0 {
+		usage.Remaining = 0
+	}
+	usage.Utilization = int32(usage.Allocated * 100 / usage.Capacity)
+	return usage, nil
+}
+
+// parseMeshIPv4 parses the private IPv4 address of a mesh node, which is
+// reported as a single address prefix.
+func parseMeshIPv4(s string) (netip.Addr, bool) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Addr(), prefix.Addr().Is4()
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr, err == nil && addr.Is4()
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestMeshCIDRUsage(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec:       meshv1.MeshSpec{IPv4: "172.16.0.0/28"},
+	}
+	group := func(name string) meshv1.NodeGroup {
+		return meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+	groups := []meshv1.NodeGroup{group("vms"), group("mesh-bootstrap"), group("vms-east")}
+	node := func(id, ipv4 string) *v1.MeshNode {
+		return &v1.MeshNode{Id: id, PrivateIpv4: ipv4}
+	}
+	nodes := []*v1.MeshNode{
+		node("mesh-bootstrap-0", "172.16.0.1/32"),
+		node("mesh-bootstrap-1", "172.16.0.2/32"),
+		node("mesh-vms-0", "172.16.0.3/32"),
+		// The longest group name wins
+		node("mesh-vms-east-0", "172.16.0.4/32"),
+		node("mesh-vms-east-1", "172.16.0.5"),
+		// Joined outside the operator
+		node("laptop", "172.16.0.6/32"),
+		node("mesh-vms-extra", "172.16.0.7/32"),
+		// Not leased from the mesh CIDR
+		node("mesh-vms-1", ""),
+		node("mesh-vms-2", "10.0.0.1/32"),
+	}
+	usage, err := meshCIDRUsage(mesh, groups, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &meshv1.MeshCIDRUsage{
+		CIDR:        "172.16.0.0/28",
+		Capacity:    16,
+		Allocated:   7,
+		Remaining:   9,
+		Utilization: 43,
+		Groups: []meshv1.NodeGroupCIDRUsage{
+			{Name: "mesh-bootstrap", Namespace: "default", Allocated: 2},
+			{Name: "vms", Namespace: "default", Allocated: 1},
+			{Name: "vms-east", Namespace: "default", Allocated: 2},
+		},
+		Unassigned: 2,
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("got usage %+v, want %+v", usage, want)
+	}
+
+	mesh.Spec.IPv4 = "fd00::/64"
+	if _, err := meshCIDRUsage(mesh, groups, nodes); err == nil {
+		t.Error("expected an error for a non-IPv4 CIDR")
+	}
+}
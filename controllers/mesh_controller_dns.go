@@ -0,0 +1,297 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	dns "google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// discoveryRecordTTL is the TTL used for published discovery records.
+const discoveryRecordTTL = 300
+
+// getMeshDNSConfig returns the discovery record configuration for the mesh,
+// or nil if records should not be published.
+func getMeshDNSConfig(mesh *meshv1.Mesh) *meshv1.NodeGroupLBDNSConfig {
+	if mesh.Spec.Bootstrap.Cluster == nil || mesh.Spec.Bootstrap.Cluster.Service == nil {
+		return nil
+	}
+	return mesh.Spec.Bootstrap.Cluster.Service.DNS
+}
+
+// publishDiscoveryRecords ensures the discovery records for the mesh point
+// at the given external IPs, and records them in the status of the mesh.
+// Records published in another zone are deleted first.
+func (r *MeshReconciler) publishDiscoveryRecords(ctx context.Context, mesh *meshv1.Mesh, externalIPs []string, ca *corev1.Secret) error {
+	cfg := getMeshDNSConfig(mesh)
+	if recorded := mesh.Status.DiscoveryRecords; recorded != nil && !sameDNSZone(&recorded.Config, cfg) {
+		log.FromContext(ctx).Info("DNS zone changed, deleting discovery records from the previous zone", "zone", recorded.Config.Zone)
+		if err := r.deleteDiscoveryRecords(ctx, mesh); err != nil {
+			return err
+		}
+	}
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderGoogleCloud)
+	if err != nil {
+		return err
+	}
+	defer release()
+	svc, err := r.getDNSService(ctx, mesh, cfg)
+	if err != nil {
+		return err
+	}
+	zone, err := svc.ManagedZones.Get(cfg.ProjectID, cfg.Zone).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("get managed zone: %w", err)
+	}
+	fingerprint, err := caFingerprint(ca.Data[cmmeta.TLSCAKey])
+	if err != nil {
+		return err
+	}
+	records := discoveryRecords(mesh, zone.DnsName, externalIPs, mesh.Spec.Bootstrap.Cluster.Service.GRPCPort, fingerprint)
+	for _, record := range records {
+		existing, err := svc.ResourceRecordSets.Get(cfg.ProjectID, cfg.Zone, record.Name, record.Type).Context(ctx).Do()
+		if err != nil {
+			if !isGoogleNotFound(err) {
+				return fmt.Errorf("get %s record %s: %w", record.Type, record.Name, err)
+			}
+			log.FromContext(ctx).Info("Creating discovery record", "name", record.Name, "type", record.Type)
+			if simulate(ctx, "create discovery record", "name", record.Name, "type", record.Type) {
+				continue
+			}
+			_, err = svc.ResourceRecordSets.Create(cfg.ProjectID, cfg.Zone, record).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("create %s record %s: %w", record.Type, record.Name, err)
+			}
+			continue
+		}
+		if existing.Ttl == record.Ttl && slices.Equal(existing.Rrdatas, record.Rrdatas) {
+			continue
+		}
+		log.FromContext(ctx).Info("Updating discovery record", "name", record.Name, "type", record.Type)
+		if simulate(ctx, "update discovery record", "name", record.Name, "type", record.Type) {
+			continue
+		}
+		_, err = svc.ResourceRecordSets.Patch(cfg.ProjectID, cfg.Zone, record.Name, record.Type, record).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("update %s record %s: %w", record.Type, record.Name, err)
+		}
+	}
+	// Remove an address family that is no longer served by the load balancer
+	for _, typ := range []string{"A", "AAAA"} {
+		if slices.ContainsFunc(records, func(r *dns.ResourceRecordSet) bool { return r.Type == typ }) {
+			continue
+		}
+		if err := deleteDiscoveryRecord(ctx, svc, cfg, discoveryHostName(mesh, zone.DnsName), typ); err != nil {
+			return err
+		}
+	}
+	if resources.IsDryRun(ctx) {
+		return nil
+	}
+	published := &meshv1.MeshDiscoveryRecords{Config: *cfg.DeepCopy()}
+	for _, record := range records {
+		published.Records = append(published.Records, meshv1.MeshDiscoveryRecord{Name: record.Name, Type: record.Type})
+	}
+	return r.setDiscoveryRecords(ctx, mesh, published)
+}
+
+// deleteDiscoveryRecords removes the discovery records recorded in the status
+// of the mesh from the zone they were published in, so they are found after
+// the DNS configuration is removed or changed. Meshes that published records
+// before they were recorded fall back to the names in the configured zone.
+func (r *MeshReconciler) deleteDiscoveryRecords(ctx context.Context, mesh *meshv1.Mesh) error {
+	cfg := getMeshDNSConfig(mesh)
+	var records []meshv1.MeshDiscoveryRecord
+	if recorded := mesh.Status.DiscoveryRecords; recorded != nil {
+		cfg, records = &recorded.Config, recorded.Records
+	}
+	if cfg == nil {
+		return nil
+	}
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderGoogleCloud)
+	if err != nil {
+		return err
+	}
+	defer release()
+	svc, err := r.getDNSService(ctx, mesh, cfg)
+	if err != nil {
+		return err
+	}
+	if records == nil {
+		zone, err := svc.ManagedZones.Get(cfg.ProjectID, cfg.Zone).Context(ctx).Do()
+		if err != nil && !isGoogleNotFound(err) {
+			return fmt.Errorf("get managed zone: %w", err)
+		}
+		if err == nil {
+			host := discoveryHostName(mesh, zone.DnsName)
+			srv := discoveryServiceName(mesh, zone.DnsName)
+			records = []meshv1.MeshDiscoveryRecord{{Name: host, Type: "A"}, {Name: host, Type: "AAAA"}, {Name: srv, Type: "SRV"}, {Name: srv, Type: "TXT"}}
+		}
+	}
+	for _, record := range records {
+		if err := deleteDiscoveryRecord(ctx, svc, cfg, record.Name, record.Type); err != nil {
+			return err
+		}
+	}
+	if resources.IsDryRun(ctx) {
+		return nil
+	}
+	return r.setDiscoveryRecords(ctx, mesh, nil)
+}
+
+// setDiscoveryRecords records the published discovery records in the status
+// of the mesh.
+func (r *MeshReconciler) setDiscoveryRecords(ctx context.Context, mesh *meshv1.Mesh, records *meshv1.MeshDiscoveryRecords) error {
+	if equality.Semantic.DeepEqual(mesh.Status.DiscoveryRecords, records) {
+		return nil
+	}
+	mesh.Status.DiscoveryRecords = records
+	if err := r.Status().Update(ctx, mesh); err != nil {
+		return fmt.Errorf("update discovery records status: %w", err)
+	}
+	return nil
+}
+
+// sameDNSZone returns true if both configurations publish to the same zone.
+func sameDNSZone(a, b *meshv1.NodeGroupLBDNSConfig) bool {
+	provider := func(c *meshv1.NodeGroupLBDNSConfig) meshv1.DNSProvider {
+		if c.Provider == "" {
+			return meshv1.DNSProviderGoogle
+		}
+		return c.Provider
+	}
+	return provider(a) == provider(b) && a.ProjectID == b.ProjectID && a.Zone == b.Zone
+}
+
+func deleteDiscoveryRecord(ctx context.Context, svc *dns.Service, cfg *meshv1.NodeGroupLBDNSConfig, name, typ string) error {
+	if simulate(ctx, "delete discovery record", "name", name, "type", typ) {
+		return nil
+	}
+	_, err := svc.ResourceRecordSets.Delete(cfg.ProjectID, cfg.Zone, name, typ).Context(ctx).Do()
+	if err != nil && !isGoogleNotFound(err) {
+		return fmt.Errorf("delete %s record %s: %w", typ, name, err)
+	}
+	if err == nil {
+		log.FromContext(ctx).Info("Deleted discovery record", "name", name, "type", typ)
+	}
+	return nil
+}
+
+func (r *MeshReconciler) getDNSService(ctx context.Context, mesh *meshv1.Mesh, cfg *meshv1.NodeGroupLBDNSConfig) (*dns.Service, error) {
+	switch cfg.Provider {
+	case meshv1.DNSProviderGoogle, "":
+	default:
+		return nil, fmt.Errorf("unsupported dns provider: %s", cfg.Provider)
+	}
+	opts, err := getGoogleClientOptions(ctx, r.Client, mesh.GetNamespace(), cfg.CredentialsSecret)
+	if err != nil {
+		return nil, fmt.Errorf("get google client options: %w", err)
+	}
+	svc, err := dns.NewService(ctx, append(opts, r.dnsOptions...)...)
+	if err != nil {
+		return nil, fmt.Errorf("create dns client: %w", err)
+	}
+	return svc, nil
+}
+
+// discoveryRecords builds the record sets for the mesh in the zone with the
+// given domain. The domain is expected to be fully qualified.
+func discoveryRecords(mesh *meshv1.Mesh, domain string, externalIPs []string, grpcPort int32, fingerprint string) []*dns.ResourceRecordSet {
+	host := discoveryHostName(mesh, domain)
+	srv := discoveryServiceName(mesh, domain)
+	var v4, v6 []string
+	for _, ip := range externalIPs {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		if addr.Is4() {
+			v4 = append(v4, addr.String())
+		} else {
+			v6 = append(v6, addr.String())
+		}
+	}
+	var records []*dns.ResourceRecordSet
+	if len(v4) > 0 {
+		records = append(records, &dns.ResourceRecordSet{Name: host, Type: "A", Ttl: discoveryRecordTTL, Rrdatas: v4})
+	}
+	if len(v6) > 0 {
+		records = append(records, &dns.ResourceRecordSet{Name: host, Type: "AAAA", Ttl: discoveryRecordTTL, Rrdatas: v6})
+	}
+	records = append(records,
+		&dns.ResourceRecordSet{
+			Name:    srv,
+			Type:    "SRV",
+			Ttl:     discoveryRecordTTL,
+			Rrdatas: []string{fmt.Sprintf("0 0 %d %s", grpcPort, host)},
+		},
+		&dns.ResourceRecordSet{
+			Name:    srv,
+			Type:    "TXT",
+			Ttl:     discoveryRecordTTL,
+			Rrdatas: []string{fmt.Sprintf(`"ca-sha256=%s"`, fingerprint)},
+		},
+	)
+	return records
+}
+
+// discoveryHostName returns the name of the address records of the mesh. Zones
+// may be shared by meshes across namespaces, so it includes both the name and
+// the namespace of the mesh.
+func discoveryHostName(mesh *meshv1.Mesh, domain string) string {
+	return fmt.Sprintf("%s.%s.%s", mesh.GetName(), mesh.GetNamespace(), domain)
+}
+
+// discoveryServiceName returns the name of the SRV and TXT records of the
+// mesh.
+func discoveryServiceName(mesh *meshv1.Mesh, domain string) string {
+	return "_webmesh._tcp." + discoveryHostName(mesh, domain)
+}
+
+// caFingerprint returns the hex encoded SHA-256 fingerprint of the first
+// certificate in the given PEM data.
+func caFingerprint(data []byte) (string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("decode CA certificate: no PEM data found")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func isGoogleNotFound(err error) bool {
+	gerr := &googleapi.Error{}
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
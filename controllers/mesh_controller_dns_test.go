@@ -0,0 +1,275 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	dns "google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestDiscoveryRecords(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	records := discoveryRecords(mesh, "example.com.", []string{"203.0.113.1", "2001:db8::1", "not-an-ip"}, 8443, "abcd")
+	got := make([]string, 0, len(records))
+	for _, record := range records {
+		got = append(got, fmt.Sprintf("%s %s %v", record.Name, record.Type, record.Rrdatas))
+	}
+	want := []string{
+		"mesh.default.example.com. A [203.0.113.1]",
+		"mesh.default.example.com. AAAA [2001:db8::1]",
+		"_webmesh._tcp.mesh.default.example.com. SRV [0 0 8443 mesh.default.example.com.]",
+		`_webmesh._tcp.mesh.default.example.com. TXT ["ca-sha256=abcd"]`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected records %v, got %v", want, got)
+	}
+
+	// Load balancers serving a single address family publish no records for
+	// the other
+	records = discoveryRecords(mesh, "example.com.", []string{"203.0.113.1"}, 8443, "abcd")
+	if len(records) != 3 || records[0].Type != "A" {
+		t.Errorf("expected only IPv4 address records, got %v", records)
+	}
+
+	// Meshes sharing a zone never share records
+	names := map[string]struct{}{}
+	for _, m := range []*meshv1.Mesh{
+		mesh,
+		{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "other"}},
+	} {
+		for _, record := range discoveryRecords(m, "example.com.", []string{"203.0.113.1"}, 8443, "abcd") {
+			names[record.Name+" "+record.Type] = struct{}{}
+		}
+	}
+	if len(names) != 9 {
+		t.Errorf("expected distinct records for every mesh, got %v", names)
+	}
+}
+
+func TestCAFingerprint(t *testing.T) {
+	ca := newTestCA(t)
+	data := ca.issue(t)[cmmeta.TLSCAKey]
+	fingerprint, err := caFingerprint(data)
+	if err != nil {
+		t.Fatalf("fingerprint CA: %v", err)
+	}
+	sum := sha256.Sum256(ca.cert.Raw)
+	if fingerprint != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the fingerprint of the CA certificate, got %q", fingerprint)
+	}
+	if _, err := caFingerprint([]byte("not pem")); err == nil {
+		t.Error("expected an error for data without a certificate")
+	}
+}
+
+// fakeCloudDNS is a Cloud DNS API serving managed zones of a single project.
+type fakeCloudDNS struct {
+	mu sync.Mutex
+	// zones are the DNS names of the managed zones by name.
+	zones map[string]string
+	// records are the record sets of each zone by name and type.
+	records map[string]map[string]*dns.ResourceRecordSet
+}
+
+func (f *fakeCloudDNS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path, ok := strings.CutPrefix(req.URL.Path, "/dns/v1/projects/project/managedZones/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	parts := strings.Split(path, "/")
+	domain, ok := f.zones[parts[0]]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	records := f.records[parts[0]]
+	switch {
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(&dns.ManagedZone{Name: parts[0], DnsName: domain})
+	case len(parts) == 2 && req.Method == http.MethodPost:
+		var record dns.ResourceRecordSet
+		if err := json.NewDecoder(req.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		records[record.Name+" "+record.Type] = &record
+		_ = json.NewEncoder(w).Encode(&record)
+	case len(parts) == 4:
+		key := parts[2] + " " + parts[3]
+		record, ok := records[key]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(record)
+		case http.MethodPatch:
+			if err := json.NewDecoder(req.Body).Decode(record); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(record)
+		case http.MethodDelete:
+			delete(records, key)
+			_ = json.NewEncoder(w).Encode(&dns.ResourceRecordSetsDeleteResponse{})
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeCloudDNS) names(zone string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for key := range f.records[zone] {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDiscoveryRecordsLifecycle(t *testing.T) {
+	ctx := context.Background()
+	fakeDNS := &fakeCloudDNS{
+		zones: map[string]string{"zone-a": "a.example.com.", "zone-b": "b.example.com."},
+		records: map[string]map[string]*dns.ResourceRecordSet{
+			"zone-a": {},
+			"zone-b": {},
+		},
+	}
+	srv := httptest.NewServer(fakeDNS)
+	defer srv.Close()
+
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{Bootstrap: meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{
+			Service: &meshv1.NodeGroupLBConfig{
+				GRPCPort: 8443,
+				DNS:      &meshv1.NodeGroupLBDNSConfig{Zone: "zone-a", ProjectID: "project"},
+			},
+		}}},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(mesh).WithStatusSubresource(mesh).Build()
+	r := &MeshReconciler{Client: cli, dnsOptions: []option.ClientOption{
+		option.WithEndpoint(srv.URL + "/"),
+		option.WithHTTPClient(srv.Client()),
+	}}
+	ca := &corev1.Secret{Data: newTestCA(t).issue(t)}
+	statusRecords := func() []string {
+		t.Helper()
+		var got meshv1.Mesh
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(mesh), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Status.DiscoveryRecords == nil {
+			return nil
+		}
+		out := []string{got.Status.DiscoveryRecords.Config.Zone}
+		for _, record := range got.Status.DiscoveryRecords.Records {
+			out = append(out, record.Name+" "+record.Type)
+		}
+		return out
+	}
+
+	if err := r.publishDiscoveryRecords(ctx, mesh, []string{"203.0.113.1"}, ca); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"_webmesh._tcp.mesh.default.a.example.com. SRV",
+		"_webmesh._tcp.mesh.default.a.example.com. TXT",
+		"mesh.default.a.example.com. A",
+	}
+	if got := fakeDNS.names("zone-a"); !slices.Equal(got, want) {
+		t.Errorf("got records %v, want %v", got, want)
+	}
+	if got := statusRecords(); len(got) != 4 || got[0] != "zone-a" {
+		t.Errorf("expected the published records in the status, got %v", got)
+	}
+	if !needsFinalizer(mesh) {
+		t.Error("expected a mesh with published records to need a finalizer")
+	}
+
+	// Moving to another zone deletes the records from the previous one
+	mesh.Spec.Bootstrap.Cluster.Service.DNS.Zone = "zone-b"
+	if err := cli.Update(ctx, mesh); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.publishDiscoveryRecords(ctx, mesh, []string{"203.0.113.1"}, ca); err != nil {
+		t.Fatal(err)
+	}
+	if got := fakeDNS.names("zone-a"); len(got) != 0 {
+		t.Errorf("expected the records of the previous zone to be deleted, got %v", got)
+	}
+	if got := fakeDNS.names("zone-b"); len(got) != 3 {
+		t.Errorf("expected the records in the new zone, got %v", got)
+	}
+	if got := statusRecords(); len(got) != 4 || got[0] != "zone-b" {
+		t.Errorf("expected the records of the new zone in the status, got %v", got)
+	}
+
+	// Records are still deleted after the DNS configuration is removed
+	mesh.Spec.Bootstrap.Cluster.Service.DNS = nil
+	if err := cli.Update(ctx, mesh); err != nil {
+		t.Fatal(err)
+	}
+	if !needsFinalizer(mesh) {
+		t.Error("expected a mesh with recorded records to still need a finalizer")
+	}
+	if err := r.deleteDiscoveryRecords(ctx, mesh); err != nil {
+		t.Fatal(err)
+	}
+	if got := fakeDNS.names("zone-b"); len(got) != 0 {
+		t.Errorf("expected the recorded records to be deleted, got %v", got)
+	}
+	if got := statusRecords(); got != nil {
+		t.Errorf("expected no records in the status, got %v", got)
+	}
+}
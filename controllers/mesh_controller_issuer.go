@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// issuerNotReadyResult returns the result of a reconcile that waits for the
+// issuer of the mesh to become ready. Issuers the controller watches requeue
+// the mesh when they change, others are checked again with the exponential
+// backoff of the controller.
+func (r *MeshReconciler) issuerNotReadyResult(mesh *meshv1.Mesh) ctrl.Result {
+	if r.watchesIssuer(mesh.IssuerReference()) {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{Requeue: true}
+}
+
+// watchesIssuer returns true if the controller watches issuers of the kind of
+// the given reference.
+func (r *MeshReconciler) watchesIssuer(ref cmmeta.ObjectReference) bool {
+	if ref.Group != "" && ref.Group != meshv1.DefaultIssuerGroup {
+		return false
+	}
+	switch ref.Kind {
+	case "", certv1.IssuerKind:
+		return true
+	case certv1.ClusterIssuerKind:
+		return !r.Namespaced
+	}
+	return false
+}
+
+// issuerToMeshes maps a cert-manager issuer to the meshes referencing it.
+func (r *MeshReconciler) issuerToMeshes(ctx context.Context, obj client.Object) []reconcile.Request {
+	kind := certv1.IssuerKind
+	var opts []client.ListOption
+	if obj.GetNamespace() == "" {
+		kind = certv1.ClusterIssuerKind
+	} else {
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	}
+	var meshes meshv1.MeshList
+	if err := r.List(ctx, &meshes, opts...); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list meshes")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, mesh := range meshes.Items {
+		ref := mesh.IssuerReference()
+		if ref.Name != obj.GetName() || (ref.Group != "" && ref.Group != meshv1.DefaultIssuerGroup) {
+			continue
+		}
+		if ref.Kind == kind || (ref.Kind == "" && kind == certv1.IssuerKind) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&mesh)})
+		}
+	}
+	return requests
+}
+
+// reconcileIssuerReady records the state of the mesh's issuer in its
+// IssuerReady condition and returns true if the issuer is ready. Certificates
+// of the mesh are not issued until then.
+func (r *MeshReconciler) reconcileIssuerReady(ctx context.Context, mesh *meshv1.Mesh) (bool, error) {
+	ref := mesh.IssuerReference()
+	condition := metav1.Condition{
+		Type:               meshv1.IssuerReadyCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: mesh.GetGeneration(),
+	}
+	state, err := mesh.LookupIssuer(ctx, r.Client)
+	switch {
+	case err != nil:
+		condition.Reason = "LookupFailed"
+		condition.Message = err.Error()
+	case !state.Found && len(state.OtherNamespaces) > 0:
+		condition.Reason = "IssuerInOtherNamespace"
+		condition.Message = fmt.Sprintf("%s %s only exists in namespace(s) %s, use a cluster-scoped issuer or one in namespace %s",
+			ref.Kind, ref.Name, strings.Join(state.OtherNamespaces, ", "), mesh.GetNamespace())
+	case !state.Found:
+		condition.Reason = "IssuerNotFound"
+		condition.Message = fmt.Sprintf("%s %s not found", ref.Kind, ref.Name)
+	default:
+		condition.Reason = state.Reason
+		condition.Message = state.Message
+		if state.Ready {
+			condition.Status = metav1.ConditionTrue
+		}
+		if condition.Reason == "" {
+			condition.Reason = "IssuerNotReady"
+			if state.Ready {
+				condition.Reason = "IssuerReady"
+			}
+		}
+	}
+	current := meta.FindStatusCondition(mesh.Status.Conditions, condition.Type)
+	if current == nil || current.Status != condition.Status || current.Reason != condition.Reason ||
+		current.Message != condition.Message || current.ObservedGeneration != condition.ObservedGeneration {
+		meta.SetStatusCondition(&mesh.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, mesh); err != nil {
+			return false, fmt.Errorf("update issuer ready condition: %w", err)
+		}
+	}
+	return condition.Status == metav1.ConditionTrue, nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestIssuerToMeshes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, meshv1.AddToScheme, certv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	newMesh := func(name, namespace string, ref cmmeta.ObjectReference) *meshv1.Mesh {
+		return &meshv1.Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       meshv1.MeshSpec{Issuer: meshv1.IssuerConfig{IssuerRef: ref}},
+		}
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			newMesh("issuer", "default", cmmeta.ObjectReference{Kind: certv1.IssuerKind, Name: "ca"}),
+			newMesh("cluster-issuer", "default", cmmeta.ObjectReference{Kind: certv1.ClusterIssuerKind, Name: "ca"}),
+			newMesh("other-issuer", "default", cmmeta.ObjectReference{Kind: certv1.IssuerKind, Name: "other"}),
+			newMesh("external-issuer", "default", cmmeta.ObjectReference{Group: "example.com", Kind: certv1.IssuerKind, Name: "ca"}),
+			newMesh("other-namespace", "other", cmmeta.ObjectReference{Kind: certv1.IssuerKind, Name: "ca"}),
+		).
+		Build()
+	r := &MeshReconciler{Client: cli}
+
+	names := func(issuer client.Object) []string {
+		var out []string
+		for _, req := range r.issuerToMeshes(context.Background(), issuer) {
+			out = append(out, req.Namespace+"/"+req.Name)
+		}
+		slices.Sort(out)
+		return out
+	}
+	issuer := &certv1.Issuer{ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"}}
+	if got := names(issuer); !slices.Equal(got, []string{"default/issuer"}) {
+		t.Errorf("expected only the mesh referencing the issuer in its namespace, got %v", got)
+	}
+	clusterIssuer := &certv1.ClusterIssuer{ObjectMeta: metav1.ObjectMeta{Name: "ca"}}
+	if got := names(clusterIssuer); !slices.Equal(got, []string{"default/cluster-issuer"}) {
+		t.Errorf("expected only the mesh referencing the cluster issuer, got %v", got)
+	}
+
+	// Meshes wait on watched issuers without requeueing, and back off on
+	// issuers the controller cannot watch
+	for _, tt := range []struct {
+		ref        cmmeta.ObjectReference
+		namespaced bool
+		requeue    bool
+	}{
+		{ref: cmmeta.ObjectReference{Kind: certv1.IssuerKind, Name: "ca"}},
+		{ref: cmmeta.ObjectReference{Kind: certv1.ClusterIssuerKind, Name: "ca"}},
+		{ref: cmmeta.ObjectReference{Kind: certv1.ClusterIssuerKind, Name: "ca"}, namespaced: true, requeue: true},
+		{ref: cmmeta.ObjectReference{Group: "example.com", Kind: certv1.IssuerKind, Name: "ca"}, requeue: true},
+	} {
+		r := &MeshReconciler{Namespaced: tt.namespaced}
+		res := r.issuerNotReadyResult(newMesh("mesh", "default", tt.ref))
+		if res.Requeue != tt.requeue || res.RequeueAfter != 0 {
+			t.Errorf("%+v: expected requeue %v without a fixed interval, got %+v", tt.ref, tt.requeue, res)
+		}
+	}
+}
+
+func TestReconcileIssuerReady(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("build scheme: %v", err)
+	}
+	issuerGV := schema.GroupVersion{Group: meshv1.DefaultIssuerGroup, Version: "v1"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{issuerGV})
+	mapper.Add(issuerGV.WithKind(certv1.IssuerKind), meta.RESTScopeNamespace)
+	newIssuer := func(namespace string, ready bool) client.Object {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(issuerGV.WithKind(certv1.IssuerKind))
+		obj.SetNamespace(namespace)
+		obj.SetName("ca")
+		status := "False"
+		if ready {
+			status = "True"
+		}
+		_ = unstructured.SetNestedSlice(obj.Object, []any{map[string]any{
+			"type":    "Ready",
+			"status":  status,
+			"reason":  "Checked",
+			"message": "checked",
+		}}, "status", "conditions")
+		return obj
+	}
+
+	tc := []struct {
+		name   string
+		objs   []client.Object
+		ready  bool
+		reason string
+	}{
+		{
+			name:   "issuer not found",
+			reason: "IssuerNotFound",
+		},
+		{
+			name:   "issuer in another namespace",
+			objs:   []client.Object{newIssuer("other", true)},
+			reason: "IssuerInOtherNamespace",
+		},
+		{
+			name:   "issuer not ready",
+			objs:   []client.Object{newIssuer("default", false)},
+			reason: "Checked",
+		},
+		{
+			name:   "issuer ready",
+			objs:   []client.Object{newIssuer("default", true)},
+			ready:  true,
+			reason: "Checked",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := &meshv1.Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default", Generation: 2},
+				Spec: meshv1.MeshSpec{Issuer: meshv1.IssuerConfig{
+					IssuerRef: cmmeta.ObjectReference{Kind: certv1.IssuerKind, Name: "ca"},
+				}},
+			}
+			var updates int
+			cli := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithRESTMapper(mapper).
+				WithObjects(append(tt.objs, mesh)...).
+				WithStatusSubresource(mesh).
+				WithInterceptorFuncs(interceptor.Funcs{
+					SubResourceUpdate: func(ctx context.Context, cli client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+						updates++
+						return cli.SubResource(subResourceName).Update(ctx, obj, opts...)
+					},
+				}).
+				Build()
+			r := &MeshReconciler{Client: cli}
+
+			ready, err := r.reconcileIssuerReady(context.Background(), mesh)
+			if err != nil {
+				t.Fatalf("reconcile issuer ready: %v", err)
+			}
+			if ready != tt.ready {
+				t.Errorf("expected ready %v, got %v", tt.ready, ready)
+			}
+			var got meshv1.Mesh
+			if err := cli.Get(context.Background(), client.ObjectKeyFromObject(mesh), &got); err != nil {
+				t.Fatalf("get mesh: %v", err)
+			}
+			condition := meta.FindStatusCondition(got.Status.Conditions, meshv1.IssuerReadyCondition)
+			if condition == nil {
+				t.Fatal("expected the IssuerReady condition to be recorded")
+			}
+			if (condition.Status == metav1.ConditionTrue) != tt.ready || condition.Reason != tt.reason || condition.ObservedGeneration != 2 {
+				t.Errorf("unexpected condition %+v", condition)
+			}
+
+			// An unchanged condition is not written again
+			if _, err := r.reconcileIssuerReady(context.Background(), &got); err != nil {
+				t.Fatalf("reconcile issuer ready: %v", err)
+			}
+			if updates != 1 {
+				t.Errorf("expected a single status update, got %d", updates)
+			}
+		})
+	}
+}
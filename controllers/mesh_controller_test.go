@@ -0,0 +1,265 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/configstore"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+// memoryStore is an in-memory config store keeping annotations.
+type memoryStore struct {
+	data        map[string]map[string][]byte
+	annotations map[string]map[string]string
+}
+
+func (s *memoryStore) Put(ctx context.Context, name string, data map[string][]byte) error {
+	return s.PutAnnotated(ctx, name, data, nil)
+}
+
+func (s *memoryStore) PutAnnotated(_ context.Context, name string, data map[string][]byte, annotations map[string]string) error {
+	s.data[name] = data
+	s.annotations[name] = annotations
+	return nil
+}
+
+func (s *memoryStore) Get(_ context.Context, name string) (map[string][]byte, error) {
+	data, ok := s.data[name]
+	if !ok {
+		return nil, configstore.ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, name string) error {
+	delete(s.data, name)
+	delete(s.annotations, name)
+	return nil
+}
+
+// plainStore hides the annotations of a store, like stores outside of the
+// cluster.
+type plainStore struct {
+	configstore.Store
+}
+
+func TestWriteManagerConfigAnnotations(t *testing.T) {
+	verifyChainOnly := true
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default", Generation: 3},
+		Spec: meshv1.MeshSpec{
+			Bootstrap: meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{GRPCPort: meshv1.DefaultGRPCPort},
+			}},
+			Security: meshv1.SecurityConfig{VerifyChainOnly: &verifyChainOnly},
+		},
+	}
+	group := mesh.BootstrapGroups()[0]
+	ca := newTestCA(t)
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	cert := &corev1.Secret{Data: ca.issueUntil(t, expiry)}
+	recorder := record.NewFakeRecorder(10)
+	r := &MeshReconciler{Recorder: recorder}
+	store := &memoryStore{data: map[string]map[string][]byte{}, annotations: map[string]map[string]string{}}
+	name := meshv1.MeshManagerConfigName(mesh)
+
+	events := func() []string {
+		var out []string
+		for {
+			select {
+			case event := <-recorder.Events:
+				out = append(out, event)
+			default:
+				return out
+			}
+		}
+	}
+	write := func() map[string]string {
+		t.Helper()
+		if err := r.writeManagerConfig(context.Background(), store, mesh, group, cert); err != nil {
+			t.Fatalf("write manager config: %v", err)
+		}
+		return store.annotations[name]
+	}
+
+	first := write()
+	if first[meshv1.ConfigChecksumAnnotation] == "" {
+		t.Error("expected a checksum of the config")
+	}
+	if got := first[meshv1.ClientCertificateNotAfterAnnotation]; got != expiry.UTC().Format(time.RFC3339) {
+		t.Errorf("expected the expiry of the client certificate, got %q", got)
+	}
+	if got := first[meshv1.MeshGenerationAnnotation]; got != "3" {
+		t.Errorf("expected the generation of the mesh, got %q", got)
+	}
+	if got := events(); len(got) != 1 || !strings.Contains(got[0], "Normal AdminConfigUpdated") || !strings.Contains(got[0], first[meshv1.ConfigChecksumAnnotation]) {
+		t.Errorf("expected an event with the checksum of the new config, got %v", got)
+	}
+
+	// Rendering the same config again changes nothing
+	if again := write(); again[meshv1.ConfigChecksumAnnotation] != first[meshv1.ConfigChecksumAnnotation] {
+		t.Error("expected the checksum to be stable")
+	}
+	if got := events(); len(got) != 0 {
+		t.Errorf("expected no event for an unchanged config, got %v", got)
+	}
+
+	// A change to the mesh is recorded without an event if the config is
+	// the same
+	mesh.Generation = 4
+	if got := write()[meshv1.MeshGenerationAnnotation]; got != "4" {
+		t.Errorf("expected the new generation of the mesh, got %q", got)
+	}
+	if got := events(); len(got) != 0 {
+		t.Errorf("expected no event for an unchanged config, got %v", got)
+	}
+
+	// A rotated certificate changes the config
+	rotated := expiry.Add(24 * time.Hour)
+	cert = &corev1.Secret{Data: ca.issueUntil(t, rotated)}
+	second := write()
+	if second[meshv1.ConfigChecksumAnnotation] == first[meshv1.ConfigChecksumAnnotation] {
+		t.Error("expected the rotated certificate to change the checksum")
+	}
+	if got := second[meshv1.ClientCertificateNotAfterAnnotation]; got != rotated.UTC().Format(time.RFC3339) {
+		t.Errorf("expected the expiry of the rotated certificate, got %q", got)
+	}
+	if got := events(); len(got) != 1 || !strings.Contains(got[0], second[meshv1.ConfigChecksumAnnotation]) {
+		t.Errorf("expected an event for the rotated certificate, got %v", got)
+	}
+
+	// Stores without annotations still get the config and the event
+	cert = &corev1.Secret{Data: ca.issueUntil(t, rotated.Add(time.Hour))}
+	if err := r.writeManagerConfig(context.Background(), plainStore{store}, mesh, group, cert); err != nil {
+		t.Fatalf("write manager config: %v", err)
+	}
+	if store.annotations[name] != nil {
+		t.Errorf("expected no annotations, got %v", store.annotations[name])
+	}
+	if got := events(); len(got) != 1 {
+		t.Errorf("expected an event for the rotated certificate, got %v", got)
+	}
+}
+
+func TestReadManagerConfig(t *testing.T) {
+	ctx := context.Background()
+	verifyChainOnly := true
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{
+			Bootstrap: meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{GRPCPort: meshv1.DefaultGRPCPort},
+			}},
+			Security: meshv1.SecurityConfig{VerifyChainOnly: &verifyChainOnly},
+		},
+	}
+	group := mesh.BootstrapGroups()[0]
+	store := &memoryStore{data: map[string]map[string][]byte{}, annotations: map[string]map[string]string{}}
+	if _, err := readManagerConfig(ctx, store, mesh); !errors.Is(err, configstore.ErrNotFound) {
+		t.Fatalf("expected a missing config to be not found, got %v", err)
+	}
+
+	// The credentials written for the manager are read back as is
+	cert := &corev1.Secret{Data: newTestCA(t).issue(t)}
+	r := &MeshReconciler{Recorder: record.NewFakeRecorder(10)}
+	if err := r.writeManagerConfig(ctx, store, mesh, group, cert); err != nil {
+		t.Fatal(err)
+	}
+	config, err := readManagerConfig(ctx, store, mesh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.GetCurrentCluster().Server, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group, meshv1.DefaultClusterDomain)+":8443"; got != want {
+		t.Errorf("got server %q, want %q", got, want)
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlsConfig.Certificates) != 1 {
+		t.Fatalf("expected the client certificate of the manager, got %d certificates", len(tlsConfig.Certificates))
+	}
+	block, _ := pem.Decode(cert.Data[corev1.TLSCertKey])
+	if !bytes.Equal(tlsConfig.Certificates[0].Certificate[0], block.Bytes) {
+		t.Error("expected the client certificate written for the manager")
+	}
+}
+
+func TestMeshStatusDomain(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, certv1.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{
+			Domain: "mesh.example.com",
+			Issuer: meshv1.IssuerConfig{Create: true, Kind: "Issuer"},
+		},
+	}
+	mesh.Default()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(mesh).
+		WithStatusSubresource(&meshv1.Mesh{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: applyAsUpdate}).
+		Build()
+	r := &MeshReconciler{Client: cli, Scheme: scheme, Recorder: record.NewFakeRecorder(10)}
+	ctx := context.Background()
+
+	// The issuer is not ready yet, the domain is recorded regardless
+	if _, err := r.reconcileMesh(ctx, mesh); err != nil {
+		t.Fatalf("reconcile mesh: %v", err)
+	}
+	var got meshv1.Mesh
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(mesh), &got); err != nil {
+		t.Fatalf("get mesh: %v", err)
+	}
+	if got.Status.Domain != "mesh.example.com" {
+		t.Errorf("expected the status domain to be %q, got %q", "mesh.example.com", got.Status.Domain)
+	}
+
+	// The bootstrap nodes are started with the domain
+	conf, err := nodeconfig.New(nodeconfig.Options{Mesh: &got, Group: got.BootstrapGroups()[0], IsBootstrap: true})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	if got := conf.Options.Bootstrap.MeshDomain; got != "mesh.example.com" {
+		t.Errorf("expected the bootstrap mesh domain to be %q, got %q", "mesh.example.com", got)
+	}
+}
@@ -0,0 +1,459 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	v1 "github.com/webmeshproj/api/v1"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// MeshAccessRequestReconciler reconciles a MeshAccessRequest object
+type MeshAccessRequestReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Shard is the subset of meshes reconciled by this replica.
+	Shard *fairness.Shard
+	// Warmup staggers the first reconciles after becoming leader.
+	Warmup *fairness.Warmup
+	// DryRun simulates changes instead of making them. Writes are made with
+	// a server-side dry run, except for the status of the reconciled objects.
+	DryRun bool
+	// Settings resolves the operator settings of each reconcile.
+	Settings *operatorconfig.Store
+}
+
+// meshAccessRequestsForegroundDeletion is the finalizer holding a request until
+// the access it granted in the namespace of its Mesh is revoked.
+const meshAccessRequestsForegroundDeletion = "meshaccessrequests.mesh.webmesh.io"
+
+// accessRequestBindingPrefix is the prefix of the role bindings the operator
+// manages for access requests. It differs from the prefix of access profile
+// bindings so the mesh controller leaves them alone.
+const accessRequestBindingPrefix = "operator-request-"
+
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshaccessrequests,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshaccessrequests/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshaccessrequests/finalizers,verbs=update
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *MeshAccessRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var access meshv1.MeshAccessRequest
+	if err := r.Get(ctx, req.NamespacedName, &access); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch MeshAccessRequest")
+		} else {
+			resources.ForgetOwned("MeshAccessRequest", req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// Requests belong to the shard of their mesh
+	if !r.Shard.Owns(access.MeshKey()) {
+		return ctrl.Result{}, nil
+	}
+	if delay := r.Warmup.Delay("MeshAccessRequest/" + req.String()); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	if r.DryRun {
+		ctx = resources.WithDryRun(ctx)
+	}
+	settings, err := r.Settings.Snapshot(ctx, req.Namespace)
+	if err != nil {
+		log.Error(err, "unable to resolve operator settings")
+		return ctrl.Result{}, err
+	}
+	ctx = operatorconfig.WithSettings(ctx, settings)
+	if err := reconcileSimulated(ctx, r.Client, &access, &access.Status.Conditions, r.DryRun); err != nil {
+		log.Error(err, "unable to update MeshAccessRequest status")
+		return ctrl.Result{}, err
+	}
+	if access.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(&access, meshAccessRequestsForegroundDeletion) {
+			return ctrl.Result{}, nil
+		}
+		log.Info("Revoking access for deleted MeshAccessRequest")
+		if err := r.revokeAccess(ctx, &access); err != nil {
+			log.Error(err, "unable to revoke access")
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(&access, meshAccessRequestsForegroundDeletion)
+		if err := r.Update(ctx, &access); err != nil {
+			log.Error(err, "unable to remove finalizer from MeshAccessRequest")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if access.Status.Phase == meshv1.MeshAccessRequestExpired {
+		return ctrl.Result{}, nil
+	}
+	if err := access.Spec.Validate(); err != nil {
+		log.Error(err, "invalid MeshAccessRequest")
+		return ctrl.Result{}, err
+	}
+
+	// Revoke access once the request expires
+	if access.IsExpired(time.Now()) {
+		log.Info("Access expired, revoking")
+		if err := r.revokeAccess(ctx, &access); err != nil {
+			log.Error(err, "unable to revoke access")
+			return ctrl.Result{}, err
+		}
+		access.Status.Phase = meshv1.MeshAccessRequestExpired
+		access.Status.SecretName = ""
+		if err := r.Status().Update(ctx, &access); err != nil {
+			log.Error(err, "unable to update MeshAccessRequest status")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&access, corev1.EventTypeNormal, "Expired",
+			"Access to mesh %s expired and was revoked", access.Spec.Mesh.Name)
+		return ctrl.Result{}, nil
+	}
+
+	// Set finalizers
+	if !controllerutil.ContainsFinalizer(&access, meshAccessRequestsForegroundDeletion) {
+		log.Info("Adding finalizer to MeshAccessRequest")
+		controllerutil.AddFinalizer(&access, meshAccessRequestsForegroundDeletion)
+		if err := r.Update(ctx, &access); err != nil {
+			log.Error(err, "unable to add finalizer to MeshAccessRequest")
+			return ctrl.Result{}, err
+		}
+	}
+
+	var mesh meshv1.Mesh
+	if err := r.Get(ctx, access.MeshKey(), &mesh); err != nil {
+		log.Error(err, "unable to fetch Mesh")
+		return ctrl.Result{}, err
+	}
+	mesh.DefaultImage(operatorconfig.FromContext(ctx).Images.Node)
+	mesh.Default()
+
+	// Wait for the request to be approved
+	approval := accessRequestApproval(&mesh, &access)
+	current := meta.FindStatusCondition(access.Status.Conditions, approval.Type)
+	changed := current == nil || current.Status != approval.Status || current.Reason != approval.Reason
+	if changed {
+		meta.SetStatusCondition(&access.Status.Conditions, approval)
+		if approval.Status == metav1.ConditionTrue {
+			r.Recorder.Eventf(&access, corev1.EventTypeNormal, approval.Reason, "%s", approval.Message)
+		}
+	}
+	if approval.Status != metav1.ConditionTrue {
+		if access.Status.Phase == meshv1.MeshAccessRequestIssued {
+			// The mesh no longer allows the request
+			log.Info("Access no longer allowed, revoking", "reason", approval.Reason)
+			if err := r.revokeAccess(ctx, &access); err != nil {
+				log.Error(err, "unable to revoke access")
+				return ctrl.Result{}, err
+			}
+			access.Status.SecretName = ""
+		}
+		if access.Status.Phase != meshv1.MeshAccessRequestPending {
+			access.Status.Phase = meshv1.MeshAccessRequestPending
+			changed = true
+		}
+		if changed {
+			if err := r.Status().Update(ctx, &access); err != nil {
+				log.Error(err, "unable to update MeshAccessRequest status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if access.Status.ExpiresAt == nil {
+		// Record the expiry before granting anything
+		expiresAt := metav1.NewTime(time.Now().Add(access.Spec.Duration.Duration))
+		access.Status.ExpiresAt = &expiresAt
+		access.Status.Phase = meshv1.MeshAccessRequestPending
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Update(ctx, &access); err != nil {
+			log.Error(err, "unable to update MeshAccessRequest status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	log.Info("Reconciling MeshAccessRequest")
+
+	// Issue the client certificate
+	if err := resources.Apply(ctx, r.Client, []client.Object{
+		resources.NewMeshAccessRequestCertificate(&mesh, &access),
+	}); err != nil {
+		log.Error(err, "unable to apply certificate")
+		return ctrl.Result{}, err
+	}
+	var cert corev1.Secret
+	err = r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshAccessRequestCertName(&mesh, &access),
+		Namespace: mesh.GetNamespace(),
+	}, &cert)
+	if client.IgnoreNotFound(err) != nil {
+		log.Error(err, "unable to fetch certificate secret")
+		return ctrl.Result{}, err
+	}
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
+		if data, ok := cert.Data[key]; !ok || len(data) == 0 {
+			log.Info("certificate secret missing data, requeueing")
+			return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 3}, nil
+		}
+	}
+
+	// Bind the role in the mesh
+	group := mesh.BootstrapGroups()[0]
+	res, err := r.bindRole(ctx, &mesh, group, &access)
+	if err != nil || !res.IsZero() {
+		if err != nil {
+			log.Error(err, "unable to bind role")
+		}
+		return res, err
+	}
+
+	// Write the config to the namespace of the request
+	serverName := meshv1.MeshNodeGroupHeadlessServiceFQDN(&mesh, group, operatorconfig.FromContext(ctx).ClusterDomain)
+	server := fmt.Sprintf("%s:%d", serverName, meshv1.DefaultGRPCPort)
+	verifyChainOnly, _, err := ctlVerifyChainOnly(ctx, r.Client, &mesh, group, serverName)
+	if err != nil {
+		log.Error(err, "unable to check node certificates")
+		return ctrl.Result{}, err
+	}
+	config, err := marshalCtlConfig(&mesh, server, verifyChainOnly, meshv1.MeshAccessRequestCertName(&mesh, &access), &cert)
+	if err != nil {
+		log.Error(err, "unable to marshal config")
+		return ctrl.Result{}, err
+	}
+	if err := resources.Apply(ctx, r.Client, []client.Object{
+		resources.NewMeshAccessRequestConfigSecret(&access, config),
+	}); err != nil {
+		log.Error(err, "unable to apply config secret")
+		return ctrl.Result{}, err
+	}
+	if access.Status.Phase != meshv1.MeshAccessRequestIssued {
+		access.Status.Phase = meshv1.MeshAccessRequestIssued
+		access.Status.SecretName = meshv1.MeshAccessRequestConfigName(&access)
+		if err := r.Status().Update(ctx, &access); err != nil {
+			log.Error(err, "unable to update MeshAccessRequest status")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&access, corev1.EventTypeNormal, "Issued",
+			"Wrote config for role %s in mesh %s to secret %s until %s",
+			access.Spec.Role, mesh.GetName(), access.Status.SecretName, access.Status.ExpiresAt.Format(time.RFC3339))
+	}
+	return ctrl.Result{RequeueAfter: time.Until(access.Status.ExpiresAt.Time)}, nil
+}
+
+// accessRequestApproval returns the Approved condition of the request. Requests
+// are approved automatically unless the mesh requires them to be annotated by
+// an approver. Requests the mesh does not allow are never approved.
+func accessRequestApproval(mesh *meshv1.Mesh, access *meshv1.MeshAccessRequest) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               meshv1.MeshAccessRequestApproved,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: access.GetGeneration(),
+	}
+	config := mesh.Spec.AccessRequests
+	switch {
+	case config == nil:
+		condition.Reason = "AccessRequestsDisabled"
+		condition.Message = fmt.Sprintf("Mesh %s does not accept access requests", mesh.GetName())
+	case !config.Allows(access.Spec.Role):
+		condition.Reason = "RoleNotAllowed"
+		condition.Message = fmt.Sprintf("Mesh %s does not allow requests for role %s", mesh.GetName(), access.Spec.Role)
+	case access.Spec.Duration.Duration > config.MaxAccessDuration():
+		condition.Reason = "DurationNotAllowed"
+		condition.Message = fmt.Sprintf("Mesh %s allows access for at most %s", mesh.GetName(), config.MaxAccessDuration())
+	case config.RequireApproval && !access.IsApproved():
+		condition.Reason = "AwaitingApproval"
+		condition.Message = fmt.Sprintf("Waiting for the %s annotation to be set by an approver", meshv1.AccessRequestApprovedAnnotation)
+	case config.RequireApproval:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Approved"
+		condition.Message = "The request was approved"
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "AutoApproved"
+		condition.Message = fmt.Sprintf("Mesh %s approves access requests automatically", mesh.GetName())
+	}
+	return condition
+}
+
+// accessRequestBindingName returns the name of the role binding for the given
+// request.
+func accessRequestBindingName(access *meshv1.MeshAccessRequest) string {
+	return fmt.Sprintf("%s%s-%s", accessRequestBindingPrefix, access.GetNamespace(), access.GetName())
+}
+
+// bindRole binds the user of the request to its role through the admin API of
+// the given group.
+func (r *MeshAccessRequestReconciler) bindRole(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, access *meshv1.MeshAccessRequest) (ctrl.Result, error) {
+	role, ok := accessProfileRoles[access.Spec.Role]
+	if !ok {
+		return ctrl.Result{}, fmt.Errorf("unknown access profile role %q", access.Spec.Role)
+	}
+	binding := &v1.RoleBinding{
+		Name: accessRequestBindingName(access),
+		Role: role.Name,
+		Subjects: []*v1.Subject{{
+			Name: meshv1.MeshAccessRequestCertName(mesh, access),
+			Type: v1.SubjectType_SUBJECT_USER,
+		}},
+	}
+	if simulate(ctx, "put role and binding", "role", role.Name, "binding", binding.Name) {
+		return ctrl.Result{}, nil
+	}
+	cli, conn, err := r.adminClient(ctx, mesh, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer conn.Close()
+	if _, err := cli.PutRole(ctx, role); err != nil {
+		log.FromContext(ctx).Info("admin API not ready, requeueing", "error", err.Error())
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 10}, nil
+	}
+	if _, err := cli.PutRoleBinding(ctx, binding); err != nil {
+		return ctrl.Result{}, fmt.Errorf("put role binding %s: %w", binding.Name, err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// revokeAccess deletes the config, certificate, and role binding of the
+// request. The certificate and role binding are gone with the Mesh if it was
+// deleted.
+func (r *MeshAccessRequestReconciler) revokeAccess(ctx context.Context, access *meshv1.MeshAccessRequest) error {
+	err := r.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      meshv1.MeshAccessRequestConfigName(access),
+		Namespace: access.GetNamespace(),
+	}})
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("delete config secret: %w", err)
+	}
+	var mesh meshv1.Mesh
+	if err := r.Get(ctx, access.MeshKey(), &mesh); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if mesh.GetDeletionTimestamp() != nil {
+		return nil
+	}
+	mesh.DefaultImage(operatorconfig.FromContext(ctx).Images.Node)
+	mesh.Default()
+	name := types.NamespacedName{
+		Name:      meshv1.MeshAccessRequestCertName(&mesh, access),
+		Namespace: mesh.GetNamespace(),
+	}
+	for _, obj := range []client.Object{&certv1.Certificate{}, &corev1.Secret{}} {
+		obj.SetName(name.Name)
+		obj.SetNamespace(name.Namespace)
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("delete certificate %s: %w", name, err)
+		}
+	}
+	if access.Status.ExpiresAt == nil {
+		// The role was never bound
+		return nil
+	}
+	binding := &v1.RoleBinding{Name: accessRequestBindingName(access)}
+	if simulate(ctx, "delete role binding", "binding", binding.Name) {
+		return nil
+	}
+	cli, conn, err := r.adminClient(ctx, &mesh, mesh.BootstrapGroups()[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := cli.DeleteRoleBinding(ctx, binding); err != nil {
+		return fmt.Errorf("delete role binding %s: %w", binding.Name, err)
+	}
+	return nil
+}
+
+// adminClient dials the admin API of the given group with the admin
+// certificate of the mesh.
+func (r *MeshAccessRequestReconciler) adminClient(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (v1.AdminClient, *grpc.ClientConn, error) {
+	var admin corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshAdminCertName(mesh),
+		Namespace: mesh.GetNamespace(),
+	}, &admin); err != nil {
+		return nil, nil, fmt.Errorf("get admin certificate secret: %w", err)
+	}
+	serverName := meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group, operatorconfig.FromContext(ctx).ClusterDomain)
+	server := fmt.Sprintf("%s:%d", serverName, meshv1.DefaultGRPCPort)
+	conn, err := dialAdminAPI(ctx, server, serverName, &admin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial admin API: %w", err)
+	}
+	return v1.NewAdminClient(conn), conn, nil
+}
+
+// certificateToAccessRequest maps a certificate issued for an access request
+// to the request.
+func certificateToAccessRequest(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, ok := meshv1.LabelValue(labels, meshv1.MeshAccessRequestNameLabel)
+	if !ok {
+		return nil
+	}
+	namespace, _ := meshv1.LabelValue(labels, meshv1.MeshAccessRequestNamespaceLabel)
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      name,
+		Namespace: namespace,
+	}}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MeshAccessRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.DryRun {
+		r.Client = NewDryRunClient(r.Client)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&meshv1.MeshAccessRequest{}).
+		Owns(&corev1.Secret{}).
+		Watches(&certv1.Certificate{}, handler.EnqueueRequestsFromMapFunc(certificateToAccessRequest)).
+		Watches(&meshv1.WebmeshOperatorConfig{}, handler.EnqueueRequestsFromMapFunc(operatorConfigToObjects(r.Client, r.Settings, &meshv1.MeshAccessRequestList{}))).
+		Complete(r)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+)
+
+func TestAccessRequestApproval(t *testing.T) {
+	readOnly := []meshv1.AccessProfileRole{meshv1.AccessProfileRoleReadOnly}
+	tc := []struct {
+		name     string
+		config   *meshv1.AccessRequestConfig
+		role     meshv1.AccessProfileRole
+		approved bool
+		status   metav1.ConditionStatus
+		reason   string
+	}{
+		{
+			name:   "disabled",
+			role:   meshv1.AccessProfileRoleReadOnly,
+			status: metav1.ConditionFalse,
+			reason: "AccessRequestsDisabled",
+		},
+		{
+			name:   "role not allowed",
+			config: &meshv1.AccessRequestConfig{AllowedRoles: readOnly},
+			role:   meshv1.AccessProfileRoleManager,
+			status: metav1.ConditionFalse,
+			reason: "RoleNotAllowed",
+		},
+		{
+			name:   "auto approved",
+			config: &meshv1.AccessRequestConfig{AllowedRoles: readOnly},
+			role:   meshv1.AccessProfileRoleReadOnly,
+			status: metav1.ConditionTrue,
+			reason: "AutoApproved",
+		},
+		{
+			name:   "awaiting approval",
+			config: &meshv1.AccessRequestConfig{AllowedRoles: readOnly, RequireApproval: true},
+			role:   meshv1.AccessProfileRoleReadOnly,
+			status: metav1.ConditionFalse,
+			reason: "AwaitingApproval",
+		},
+		{
+			name:     "approved",
+			config:   &meshv1.AccessRequestConfig{AllowedRoles: readOnly, RequireApproval: true},
+			role:     meshv1.AccessProfileRoleReadOnly,
+			approved: true,
+			status:   metav1.ConditionTrue,
+			reason:   "Approved",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := &meshv1.Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+				Spec:       meshv1.MeshSpec{AccessRequests: tt.config},
+			}
+			access := &meshv1.MeshAccessRequest{
+				ObjectMeta: metav1.ObjectMeta{Name: "request", Namespace: "team"},
+				Spec: meshv1.MeshAccessRequestSpec{
+					Mesh:     corev1.ObjectReference{Name: "mesh", Namespace: "default"},
+					Role:     tt.role,
+					Duration: metav1.Duration{Duration: time.Hour},
+				},
+			}
+			if tt.approved {
+				access.Annotations = map[string]string{meshv1.AccessRequestApprovedAnnotation: "true"}
+			}
+			condition := accessRequestApproval(mesh, access)
+			if condition.Status != tt.status || condition.Reason != tt.reason {
+				t.Errorf("expected %s/%s, got %s/%s", tt.status, tt.reason, condition.Status, condition.Reason)
+			}
+		})
+	}
+}
+
+func TestMeshAccessRequestExpiry(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := metav1.NewTime(time.Now().Add(-time.Minute))
+	access := &meshv1.MeshAccessRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "request",
+			Namespace:  "team",
+			Finalizers: []string{meshAccessRequestsForegroundDeletion},
+		},
+		Spec: meshv1.MeshAccessRequestSpec{
+			Mesh:     corev1.ObjectReference{Name: "mesh", Namespace: "default"},
+			Role:     meshv1.AccessProfileRoleReadOnly,
+			Duration: metav1.Duration{Duration: time.Hour},
+		},
+		Status: meshv1.MeshAccessRequestStatus{
+			Phase:      meshv1.MeshAccessRequestIssued,
+			ExpiresAt:  &expiresAt,
+			SecretName: "request-config",
+		},
+	}
+	config := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "request-config", Namespace: "team"}}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(access, config).
+		WithStatusSubresource(access).
+		Build()
+	shard, err := fairness.NewShard(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &MeshAccessRequestReconciler{
+		Client:   cli,
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(10),
+		Shard:    shard,
+		Warmup:   fairness.NewWarmup(0),
+	}
+	ctx := context.Background()
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(access)}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(config), &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected config secret to be deleted, got %v", err)
+	}
+	var got meshv1.MeshAccessRequest
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(access), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Phase != meshv1.MeshAccessRequestExpired || got.Status.SecretName != "" {
+		t.Errorf("expected request to be expired, got %+v", got.Status)
+	}
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// MeshPeeringReconciler reconciles a MeshPeering object
+type MeshPeeringReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Shard is the subset of meshes reconciled by this replica.
+	Shard *fairness.Shard
+	// Warmup staggers the first reconciles after becoming leader.
+	Warmup *fairness.Warmup
+	// DryRun simulates changes instead of making them. Writes are made with
+	// a server-side dry run, except for the status of the reconciled objects.
+	DryRun bool
+	// Settings resolves the operator settings of each reconcile.
+	Settings *operatorconfig.Store
+}
+
+//+kubebuilder:rbac:groups="",resources=services;configmaps;persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshpeerings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshpeerings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=meshpeerings/finalizers,verbs=update
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *MeshPeeringReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var peering meshv1.MeshPeering
+	if err := r.Get(ctx, req.NamespacedName, &peering); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch MeshPeering")
+		} else {
+			resources.ForgetOwned("MeshPeering", req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// Peerings belong to the shard of their local mesh
+	if !r.Shard.Owns(types.NamespacedName{Name: peering.Spec.Mesh.Name, Namespace: peering.GetNamespace()}) {
+		return ctrl.Result{}, nil
+	}
+	if delay := r.Warmup.Delay("MeshPeering/" + req.String()); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	if r.DryRun {
+		ctx = resources.WithDryRun(ctx)
+	}
+	settings, err := r.Settings.Snapshot(ctx, req.Namespace)
+	if err != nil {
+		log.Error(err, "unable to resolve operator settings")
+		return ctrl.Result{}, err
+	}
+	ctx = operatorconfig.WithSettings(ctx, settings)
+	if err := reconcileSimulated(ctx, r.Client, &peering, &peering.Status.Conditions, r.DryRun); err != nil {
+		log.Error(err, "unable to update MeshPeering status")
+		return ctrl.Result{}, err
+	}
+	if peering.GetDeletionTimestamp() != nil {
+		// All resources are owned by the peering and garbage collected
+		return ctrl.Result{}, nil
+	}
+	if err := peering.Spec.Validate(); err != nil {
+		log.Error(err, "invalid MeshPeering")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Reconciling MeshPeering")
+
+	// Look up the meshes managed by this operator
+	local, err := r.getMesh(ctx, &peering, peering.Spec.Mesh.Name)
+	if err != nil {
+		log.Error(err, "unable to fetch local Mesh")
+		return ctrl.Result{}, err
+	}
+	managed := []*meshv1.Mesh{local}
+	if !peering.IsExternal() {
+		remote, err := r.getMesh(ctx, &peering, peering.Spec.Remote.Mesh.Name)
+		if err != nil {
+			log.Error(err, "unable to fetch remote Mesh")
+			return ctrl.Result{}, err
+		}
+		managed = append(managed, remote)
+	}
+
+	// Each bridged mesh is served on its own ports of the bridge node
+	var bridgeMeshes []resources.BridgeMesh
+	for i, mesh := range managed {
+		bridgeMeshes = append(bridgeMeshes, resources.BridgeMesh{
+			ID:         mesh.GetName(),
+			Index:      i,
+			CertSecret: meshv1.MeshPeeringCertName(&peering, mesh),
+		})
+	}
+	if peering.IsExternal() {
+		bridgeMeshes = append(bridgeMeshes, resources.BridgeMesh{
+			ID:         "external",
+			Index:      len(bridgeMeshes),
+			CertSecret: peering.Spec.Remote.External.Credentials.Name,
+		})
+	}
+
+	// Issue the bridge node a certificate from each managed mesh and
+	// expose it
+	var toApply []client.Object
+	for _, mesh := range managed {
+		toApply = append(toApply, resources.NewMeshPeeringCertificate(&peering, mesh, settings.ClusterDomain))
+	}
+	toApply = append(toApply, resources.NewMeshPeeringService(&peering, bridgeMeshes))
+	if peering.Spec.ServiceType == corev1.ServiceTypeLoadBalancer {
+		toApply = append(toApply, resources.NewMeshPeeringLBService(&peering, bridgeMeshes))
+	} else if err := r.deleteLBService(ctx, &peering); err != nil {
+		log.Error(err, "unable to delete bridge load balancer")
+		return ctrl.Result{}, err
+	}
+	if err := resources.Apply(ctx, r.Client, toApply); err != nil {
+		log.Error(err, "unable to apply certificates and services")
+		return ctrl.Result{}, err
+	}
+	endpointHost, err := r.getEndpointHost(ctx, &peering)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("bridge load balancer not ready, requeueing")
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+		log.Error(err, "unable to get bridge endpoint")
+		return ctrl.Result{}, err
+	}
+
+	// Build the bridge configuration
+	bridgeOpts := nodeconfig.BridgeOptions{
+		NodeID:   meshv1.MeshPeeringBridgeName(&peering),
+		LogLevel: peering.Spec.LogLevel,
+		Meshes:   make(map[string]nodeconfig.BridgeMeshOptions),
+	}
+	for _, bridgeMesh := range bridgeMeshes {
+		meshOpts := nodeconfig.BridgeMeshOptions{
+			CertDir:             bridgeMesh.CertDir(),
+			InterfaceName:       bridgeMesh.InterfaceName(),
+			GRPCListenPort:      bridgeMesh.GRPCPort(),
+			RaftListenPort:      bridgeMesh.RaftPort(),
+			WireGuardListenPort: bridgeMesh.WireGuardPort(),
+			WireGuardEndpoint:   net.JoinHostPort(endpointHost, strconv.Itoa(bridgeMesh.WireGuardPort())),
+		}
+		if bridgeMesh.Index < len(managed) {
+			mesh := managed[bridgeMesh.Index]
+			joinServer, err := getClusterJoinServer(ctx, r.Client, mesh)
+			if err != nil {
+				log.Error(err, "unable to get join server", "mesh", mesh.GetName())
+				return ctrl.Result{}, err
+			}
+			meshOpts.JoinServer = joinServer
+			meshOpts.VerifyChainOnly = mesh.VerifyChainOnly(nil)
+		} else {
+			meshOpts.JoinServer = peering.Spec.Remote.External.JoinServer
+			meshOpts.VerifyChainOnly = peering.Spec.Remote.External.VerifyChainOnly
+		}
+		bridgeOpts.Meshes[bridgeMesh.ID] = meshOpts
+	}
+	conf, err := nodeconfig.NewBridge(bridgeOpts)
+	if err != nil {
+		log.Error(err, "unable to build bridge config")
+		return ctrl.Result{}, err
+	}
+
+	// Deploy the bridge node
+	image := peering.Spec.Image
+	if image == "" {
+		image = local.Spec.Image
+	}
+	if image == "" {
+		image = operatorconfig.FromContext(ctx).Images.Node
+	}
+	// The bridge keeps no state and its pods are not cached, replace them
+	// with the StatefulSet.
+	recreating, err := migrateStatefulSetSelector(ctx, r.Client, client.ObjectKey{
+		Name:      meshv1.MeshPeeringBridgeName(&peering),
+		Namespace: peering.GetNamespace(),
+	}, meshv1.MeshPeeringSelector(&peering), false)
+	if err != nil {
+		log.Error(err, "unable to migrate legacy labels")
+		return ctrl.Result{}, err
+	}
+	if recreating {
+		return ctrl.Result{RequeueAfter: labelMigrationRetryInterval}, nil
+	}
+	toApply = []client.Object{
+		resources.NewMeshPeeringConfigMap(&peering, conf),
+		resources.NewMeshPeeringStatefulSet(&peering, image, bridgeMeshes, conf.Checksum()),
+	}
+	if err := resources.Apply(ctx, r.Client, toApply); err != nil {
+		log.Error(err, "unable to apply resources")
+		return ctrl.Result{}, err
+	}
+
+	// Report whether the bridge is up
+	var sts appsv1.StatefulSet
+	if err := r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshPeeringBridgeName(&peering),
+		Namespace: peering.GetNamespace(),
+	}, &sts); err != nil {
+		log.Error(err, "unable to fetch bridge StatefulSet")
+		return ctrl.Result{}, err
+	}
+	condition := metav1.Condition{
+		Type:               meshv1.MeshPeeringBridgeUp,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: peering.GetGeneration(),
+		Reason:             "BridgeNotReady",
+		Message:            "The bridge node has not joined all meshes",
+	}
+	switch {
+	case bridgeRolledOut(&sts):
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "BridgeReady"
+		condition.Message = "The bridge node has joined all meshes"
+	case sts.Status.ReadyReplicas > 0:
+		// A bridge with an outdated config may not bridge every mesh
+		condition.Reason = "BridgeUpdating"
+		condition.Message = "The bridge node is restarting with its current configuration"
+	}
+	current := meta.FindStatusCondition(peering.Status.Conditions, condition.Type)
+	if current == nil || current.Status != condition.Status || current.Reason != condition.Reason || current.ObservedGeneration != condition.ObservedGeneration {
+		meta.SetStatusCondition(&peering.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, &peering); err != nil {
+			log.Error(err, "unable to update MeshPeering status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *MeshPeeringReconciler) getMesh(ctx context.Context, peering *meshv1.MeshPeering, name string) (*meshv1.Mesh, error) {
+	var mesh meshv1.Mesh
+	if err := r.Get(ctx, client.ObjectKey{
+		Name:      name,
+		Namespace: peering.GetNamespace(),
+	}, &mesh); err != nil {
+		return nil, err
+	}
+	mesh.DefaultImage(operatorconfig.FromContext(ctx).Images.Node)
+	mesh.Default()
+	return &mesh, nil
+}
+
+// getEndpointHost returns the host the bridge node advertises as its WireGuard
+// endpoint. It is ErrLBNotReady while the load balancer has no address yet.
+func (r *MeshPeeringReconciler) getEndpointHost(ctx context.Context, peering *meshv1.MeshPeering) (string, error) {
+	if peering.Spec.Endpoint != "" {
+		return peering.Spec.Endpoint, nil
+	}
+	if peering.Spec.ServiceType != corev1.ServiceTypeLoadBalancer {
+		// Only reachable from inside the cluster
+		return fmt.Sprintf(`{{ env "POD_NAME" }}.%s`, meshv1.MeshPeeringBridgeServiceFQDN(peering, operatorconfig.FromContext(ctx).ClusterDomain)), nil
+	}
+	var lb corev1.Service
+	if err := r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshPeeringBridgeLBName(peering),
+		Namespace: peering.GetNamespace(),
+	}, &lb); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", ErrLBNotReady
+		}
+		return "", fmt.Errorf("fetch bridge load balancer: %w", err)
+	}
+	addrs, err := inspect.LBExternalIPs(&lb)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", ErrLBNotReady
+	}
+	return addrs[0], nil
+}
+
+// deleteLBService deletes the load balancer of a peering that is no longer
+// exposed through one.
+func (r *MeshPeeringReconciler) deleteLBService(ctx context.Context, peering *meshv1.MeshPeering) error {
+	var lb corev1.Service
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshPeeringBridgeLBName(peering),
+		Namespace: peering.GetNamespace(),
+	}, &lb)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(&lb, peering) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, &lb))
+}
+
+// bridgeRolledOut returns true once every replica of the bridge StatefulSet
+// runs its current template and is ready. The bridge only turns ready after
+// it brought up the interface of every mesh it bridges.
+func bridgeRolledOut(sts *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.ObservedGeneration >= sts.GetGeneration() &&
+		sts.Status.UpdateRevision != "" &&
+		sts.Status.CurrentRevision == sts.Status.UpdateRevision &&
+		sts.Status.UpdatedReplicas == replicas &&
+		sts.Status.ReadyReplicas == replicas
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MeshPeeringReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.DryRun {
+		r.Client = NewDryRunClient(r.Client)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&meshv1.MeshPeering{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&certv1.Certificate{}).
+		Watches(&meshv1.WebmeshOperatorConfig{}, handler.EnqueueRequestsFromMapFunc(operatorConfigToObjects(r.Client, r.Settings, &meshv1.MeshPeeringList{}))).
+		Complete(r)
+}
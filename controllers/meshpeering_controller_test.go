@@ -0,0 +1,237 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// applyAsUpdate stands in for server-side apply, which the fake client does
+// not support, by creating or replacing the applied objects.
+func applyAsUpdate(ctx context.Context, cli client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return cli.Patch(ctx, obj, patch, opts...)
+	}
+	err := cli.Create(ctx, obj)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return cli.Update(ctx, obj)
+}
+
+func TestMeshPeeringReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	newMesh := func(name string) (*meshv1.Mesh, *meshv1.NodeGroup) {
+		mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		bootstrap := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + "-bootstrap",
+				Namespace: "default",
+				Labels:    meshv1.MeshBootstrapGroupSelector(mesh),
+			},
+			Spec: meshv1.NodeGroupSpec{Mesh: corev1.ObjectReference{Name: name}},
+		}
+		return mesh, bootstrap
+	}
+	local, localBootstrap := newMesh("local")
+	remote, remoteBootstrap := newMesh("remote")
+	ctx := context.Background()
+	settings := operatorconfig.Defaults()
+	settings.SkipJoinServerProbe = true
+	shard, err := fairness.NewShard(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := []struct {
+		name      string
+		remote    meshv1.MeshPeeringRemote
+		lb        bool
+		endpoints []string
+		joins     []string
+	}{
+		{
+			name:   "managed meshes in the cluster",
+			remote: meshv1.MeshPeeringRemote{Mesh: &corev1.LocalObjectReference{Name: "remote"}},
+			// Quotes are escaped in the rendered config
+			endpoints: []string{
+				fmt.Sprintf(`{{ env \"POD_NAME\" }}.peering-bridge.default.svc.%s:%d`, meshv1.DefaultClusterDomain, meshv1.DefaultWireGuardPort),
+				fmt.Sprintf(`{{ env \"POD_NAME\" }}.peering-bridge.default.svc.%s:%d`, meshv1.DefaultClusterDomain, meshv1.DefaultWireGuardPort+1),
+			},
+			joins: []string{
+				fmt.Sprintf("%s:%d", meshv1.MeshNodeGroupHeadlessServiceFQDN(local, localBootstrap, meshv1.DefaultClusterDomain), meshv1.DefaultGRPCPort),
+				fmt.Sprintf("%s:%d", meshv1.MeshNodeGroupHeadlessServiceFQDN(remote, remoteBootstrap, meshv1.DefaultClusterDomain), meshv1.DefaultGRPCPort),
+			},
+		},
+		{
+			name: "external mesh through a load balancer",
+			remote: meshv1.MeshPeeringRemote{External: &meshv1.MeshPeeringExternal{
+				JoinServer:  "198.51.100.20:8443",
+				Credentials: corev1.LocalObjectReference{Name: "external-tls"},
+			}},
+			lb: true,
+			endpoints: []string{
+				fmt.Sprintf("203.0.113.10:%d", meshv1.DefaultWireGuardPort),
+				fmt.Sprintf("203.0.113.10:%d", meshv1.DefaultWireGuardPort+1),
+			},
+			joins: []string{"198.51.100.20:8443"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			peering := &meshv1.MeshPeering{
+				ObjectMeta: metav1.ObjectMeta{Name: "peering", Namespace: "default", UID: "peering-uid"},
+				Spec: meshv1.MeshPeeringSpec{
+					Mesh:     corev1.LocalObjectReference{Name: "local"},
+					Remote:   tt.remote,
+					LogLevel: "info",
+				},
+			}
+			if tt.lb {
+				peering.Spec.ServiceType = corev1.ServiceTypeLoadBalancer
+			}
+			cli := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(local, localBootstrap, remote, remoteBootstrap, peering).
+				WithStatusSubresource(peering, &corev1.Service{}, &appsv1.StatefulSet{}).
+				WithInterceptorFuncs(interceptor.Funcs{Patch: applyAsUpdate}).
+				Build()
+			r := &MeshPeeringReconciler{
+				Client:   cli,
+				Scheme:   scheme,
+				Shard:    shard,
+				Warmup:   fairness.NewWarmup(0),
+				Settings: &operatorconfig.Store{Reader: cli, Base: *settings},
+			}
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(peering)}
+			reconcile := func() ctrl.Result {
+				t.Helper()
+				res, err := r.Reconcile(ctx, req)
+				if err != nil {
+					t.Fatalf("reconcile: %v", err)
+				}
+				return res
+			}
+			bridgeUp := func() *metav1.Condition {
+				t.Helper()
+				var got meshv1.MeshPeering
+				if err := cli.Get(ctx, req.NamespacedName, &got); err != nil {
+					t.Fatal(err)
+				}
+				return meta.FindStatusCondition(got.Status.Conditions, meshv1.MeshPeeringBridgeUp)
+			}
+
+			if tt.lb {
+				// The endpoint is only known once the load balancer has an address
+				if res := reconcile(); res.RequeueAfter == 0 {
+					t.Fatal("expected a requeue while the load balancer has no address")
+				}
+				var lb corev1.Service
+				if err := cli.Get(ctx, client.ObjectKey{Name: meshv1.MeshPeeringBridgeLBName(peering), Namespace: "default"}, &lb); err != nil {
+					t.Fatalf("get load balancer: %v", err)
+				}
+				for _, port := range lb.Spec.Ports {
+					if strings.HasPrefix(port.Name, "raft") {
+						t.Errorf("expected raft to stay inside the cluster, got port %s", port.Name)
+					}
+				}
+				lb.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+				if err := cli.Status().Update(ctx, &lb); err != nil {
+					t.Fatal(err)
+				}
+			}
+			reconcile()
+			var cm corev1.ConfigMap
+			if err := cli.Get(ctx, client.ObjectKey{Name: meshv1.MeshPeeringBridgeName(peering), Namespace: "default"}, &cm); err != nil {
+				t.Fatalf("get bridge config: %v", err)
+			}
+			config := cm.Data[meshv1.ConfigFileName]
+			for _, want := range append(tt.endpoints, tt.joins...) {
+				if !strings.Contains(config, want) {
+					t.Errorf("expected the bridge config to contain %q", want)
+				}
+			}
+			if cond := bridgeUp(); cond == nil || cond.Status != metav1.ConditionFalse {
+				t.Errorf("expected the bridge to be down before it is ready, got %+v", cond)
+			}
+
+			// A ready bridge running an outdated template is not up
+			var sts appsv1.StatefulSet
+			if err := cli.Get(ctx, client.ObjectKey{Name: meshv1.MeshPeeringBridgeName(peering), Namespace: "default"}, &sts); err != nil {
+				t.Fatalf("get bridge: %v", err)
+			}
+			sts.Status = appsv1.StatefulSetStatus{
+				ObservedGeneration: sts.GetGeneration(),
+				Replicas:           1,
+				ReadyReplicas:      1,
+				CurrentRevision:    "bridge-1",
+				UpdateRevision:     "bridge-2",
+			}
+			if err := cli.Status().Update(ctx, &sts); err != nil {
+				t.Fatal(err)
+			}
+			reconcile()
+			if cond := bridgeUp(); cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "BridgeUpdating" {
+				t.Errorf("expected the bridge to be updating, got %+v", cond)
+			}
+			sts.Status.CurrentRevision = "bridge-2"
+			sts.Status.UpdatedReplicas = 1
+			if err := cli.Status().Update(ctx, &sts); err != nil {
+				t.Fatal(err)
+			}
+			reconcile()
+			if cond := bridgeUp(); cond == nil || cond.Status != metav1.ConditionTrue {
+				t.Errorf("expected the bridge to be up, got %+v", cond)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+)
+
+// BridgeOptions are options for generating a bridge node config.
+type BridgeOptions struct {
+	// NodeID is the ID of the bridge node in every mesh.
+	NodeID string
+	// LogLevel is the log level.
+	LogLevel string
+	// Meshes are the options for each bridged mesh keyed by mesh ID.
+	Meshes map[string]BridgeMeshOptions
+}
+
+// BridgeMeshOptions are options for the connection to a single bridged mesh.
+type BridgeMeshOptions struct {
+	// JoinServer is the join server.
+	JoinServer string
+	// CertDir is the cert directory.
+	CertDir string
+	// VerifyChainOnly is true if only the certificate chain should be verified.
+	VerifyChainOnly bool
+	// InterfaceName is the WireGuard interface name.
+	InterfaceName string
+	// GRPCListenPort is the gRPC listen port.
+	GRPCListenPort int
+	// RaftListenPort is the Raft listen port.
+	RaftListenPort int
+	// WireGuardListenPort is the WireGuard listen port.
+	WireGuardListenPort int
+	// WireGuardEndpoint is the WireGuard endpoint to advertise.
+	WireGuardEndpoint string
+}
+
+// NewBridge returns a new bridge node config.
+func NewBridge(opts BridgeOptions) (*Config, error) {
+	if len(opts.Meshes) < 2 {
+		return nil, fmt.Errorf("at least two meshes are required for a bridge")
+	}
+	nodeopts := config.NewDefaultConfig(opts.NodeID)
+	nodeopts.Global.LogLevel = opts.LogLevel
+	nodeopts.Bridge.Meshes = make(map[string]*config.Config, len(opts.Meshes))
+	for id, meshopts := range opts.Meshes {
+		if meshopts.JoinServer == "" {
+			return nil, fmt.Errorf("join server is required for mesh %s", id)
+		}
+		conf := config.NewDefaultConfig(opts.NodeID)
+		conf.Global.LogLevel = opts.LogLevel
+		conf.Global.TLSCertFile = fmt.Sprintf(`%s/tls.crt`, meshopts.CertDir)
+		conf.Global.TLSKeyFile = fmt.Sprintf(`%s/tls.key`, meshopts.CertDir)
+		conf.Global.TLSCAFile = fmt.Sprintf(`%s/ca.crt`, meshopts.CertDir)
+		conf.Global.MTLS = true
+		conf.Global.VerifyChainOnly = meshopts.VerifyChainOnly
+		conf.Mesh.JoinAddress = meshopts.JoinServer
+		conf.Raft.ListenAddress = fmt.Sprintf(":%d", meshopts.RaftListenPort)
+		conf.Raft.DataDir = ""
+		conf.Raft.InMemory = true
+		conf.Services.API.ListenAddress = fmt.Sprintf(":%d", meshopts.GRPCListenPort)
+		conf.Mesh.GRPCAdvertisePort = meshopts.GRPCListenPort
+		conf.WireGuard.InterfaceName = meshopts.InterfaceName
+		conf.WireGuard.ForceInterfaceName = true
+		conf.WireGuard.ListenPort = meshopts.WireGuardListenPort
+		if meshopts.WireGuardEndpoint != "" {
+			conf.WireGuard.Endpoints = []string{meshopts.WireGuardEndpoint}
+		}
+		nodeopts.Bridge.Meshes[id] = &conf
+	}
+
+	// Build the config
+	out, err := nodeopts.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	return &Config{
+		Options: &nodeopts,
+		raw:     out,
+	}, nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"testing"
+)
+
+func TestNewBridge(t *testing.T) {
+	meshOpts := func(joinServer string, index int) BridgeMeshOptions {
+		return BridgeMeshOptions{
+			JoinServer:          joinServer,
+			CertDir:             "/etc/webmesh/tls/mesh",
+			InterfaceName:       "webmesh0",
+			GRPCListenPort:      8443 + index,
+			RaftListenPort:      9443 + index,
+			WireGuardListenPort: 51820 + index,
+			WireGuardEndpoint:   "203.0.113.10:51820",
+		}
+	}
+
+	tc := []struct {
+		name   string
+		meshes map[string]BridgeMeshOptions
+		err    bool
+	}{
+		{
+			name:   "single mesh",
+			meshes: map[string]BridgeMeshOptions{"local": meshOpts("local:8443", 0)},
+			err:    true,
+		},
+		{
+			name: "missing join server",
+			meshes: map[string]BridgeMeshOptions{
+				"local":  meshOpts("local:8443", 0),
+				"remote": meshOpts("", 1),
+			},
+			err: true,
+		},
+		{
+			name: "two meshes",
+			meshes: map[string]BridgeMeshOptions{
+				"local":  meshOpts("local:8443", 0),
+				"remote": meshOpts("remote:8443", 1),
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := NewBridge(BridgeOptions{NodeID: "bridge", LogLevel: "debug", Meshes: tt.meshes})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("new bridge: %v", err)
+			}
+			if len(conf.Raw()) == 0 || conf.Checksum() == "" {
+				t.Fatal("expected a rendered config")
+			}
+			if len(conf.Options.Bridge.Meshes) != len(tt.meshes) {
+				t.Fatalf("expected %d bridged meshes, got %d", len(tt.meshes), len(conf.Options.Bridge.Meshes))
+			}
+			for id, want := range tt.meshes {
+				got := conf.Options.Bridge.Meshes[id]
+				if got == nil {
+					t.Fatalf("missing config for mesh %s", id)
+				}
+				if got.Mesh.JoinAddress != want.JoinServer {
+					t.Errorf("%s: expected join address %s, got %s", id, want.JoinServer, got.Mesh.JoinAddress)
+				}
+				if got.WireGuard.ListenPort != want.WireGuardListenPort {
+					t.Errorf("%s: expected WireGuard port %d, got %d", id, want.WireGuardListenPort, got.WireGuard.ListenPort)
+				}
+				if len(got.WireGuard.Endpoints) != 1 || got.WireGuard.Endpoints[0] != want.WireGuardEndpoint {
+					t.Errorf("%s: expected WireGuard endpoint %s, got %v", id, want.WireGuardEndpoint, got.WireGuard.Endpoints)
+				}
+				if got.Global.TLSCertFile != want.CertDir+"/tls.crt" || !got.Global.MTLS {
+					t.Errorf("%s: expected mTLS with the mesh certificate, got %s", id, got.Global.TLSCertFile)
+				}
+				if !got.Raft.InMemory {
+					t.Errorf("%s: expected the bridge to keep no raft state", id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+)
+
+// AcceptChecksum makes the config report the checksum of an equivalent config
+// that is already deployed. Objects recording the checksum are then left as
+// they are, nodes are not restarted for the change.
+func (c *Config) AcceptChecksum(checksum string) {
+	c.accepted = checksum
+}
+
+func (c *Config) setChecksumInput(key, value string) {
+	if c.checksumInputs == nil {
+		c.checksumInputs = make(map[string]string)
+	}
+	c.checksumInputs[key] = value
+}
+
+func (c *Config) checksumData() []byte {
+	data := canonicalize(c.raw)
+	if len(c.replicas) > 0 {
+		files := make([]string, 0, len(c.replicas))
+		for file := range c.replicas {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			data = append(data, []byte("\n"+file+"=")...)
+			data = append(data, canonicalize(c.replicas[file])...)
+		}
+	}
+	if len(c.checksumInputs) == 0 {
+		return data
+	}
+	keys := make([]string, 0, len(c.checksumInputs))
+	for key := range c.checksumInputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		data = append(data, []byte("\n"+key+"="+c.checksumInputs[key])...)
+	}
+	return data
+}
+
+var (
+	defaultOptions     any
+	defaultOptionsOnce sync.Once
+)
+
+// canonicalize returns the form a marshalled config is checksummed in. Keys are
+// sorted and options that are unset or left at the defaults of the config
+// package are omitted. Options added to the config package by an upgrade then
+// leave the checksum of existing configs unchanged.
+func canonicalize(raw []byte) []byte {
+	defaultOptionsOnce.Do(func() {
+		data, err := config.NewDefaultConfig("").MarshalJSON()
+		if err == nil {
+			_ = json.Unmarshal(data, &defaultOptions)
+		}
+	})
+	var opts any
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return raw
+	}
+	pruned, _ := prune(opts, defaultOptions)
+	// Maps are marshalled with sorted keys
+	out, err := json.Marshal(pruned)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+// prune removes the values of opts that are equal to the value at the same path
+// of the defaults, or zero where there is no default. It returns false if
+// nothing is left of opts.
+func prune(opts, defaults any) (any, bool) {
+	if m, ok := opts.(map[string]any); ok {
+		defaultMap, _ := defaults.(map[string]any)
+		out := make(map[string]any, len(m))
+		for key, value := range m {
+			if pruned, ok := prune(value, defaultMap[key]); ok {
+				out[key] = pruned
+			}
+		}
+		return out, len(out) > 0
+	}
+	if reflect.DeepEqual(opts, defaults) {
+		return nil, false
+	}
+	if defaults == nil && isZero(opts) {
+		return nil, false
+	}
+	return opts, true
+}
+
+func isZero(v any) bool {
+	if s, ok := v.([]any); ok {
+		return len(s) == 0
+	}
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
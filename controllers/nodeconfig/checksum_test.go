@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"encoding/json"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestChecksumIgnoresCosmeticChanges(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec:       meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{}},
+	}
+	conf, err := New(Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.10:8443"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var opts map[string]any
+	if err := json.Unmarshal(conf.Raw(), &opts); err != nil {
+		t.Fatal(err)
+	}
+	remarshal := func(mutate func(map[string]any)) *Config {
+		var copied map[string]any
+		data, _ := json.Marshal(opts)
+		_ = json.Unmarshal(data, &copied)
+		mutate(copied)
+		raw, err := json.MarshalIndent(copied, "", "    ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Config{raw: raw}
+	}
+
+	tc := []struct {
+		name    string
+		mutate  func(map[string]any)
+		changed bool
+	}{
+		{
+			name:   "reformatted",
+			mutate: func(map[string]any) {},
+		},
+		{
+			// An upgrade of the config package adds an option that is
+			// unset by default
+			name: "new unset option",
+			mutate: func(o map[string]any) {
+				o["mesh"].(map[string]any)["new-option"] = ""
+				o["new-section"] = map[string]any{"enabled": false, "items": []any{}}
+			},
+		},
+		{
+			name: "changed option",
+			mutate: func(o map[string]any) {
+				o["mesh"].(map[string]any)["join-address"] = "203.0.113.11:8443"
+			},
+			changed: true,
+		},
+		{
+			name: "option set to its zero value",
+			mutate: func(o map[string]any) {
+				o["wireguard"].(map[string]any)["force-interface-name"] = false
+			},
+			changed: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got := remarshal(tt.mutate).Checksum()
+			if changed := got != conf.Checksum(); changed != tt.changed {
+				t.Errorf("expected changed to be %v, got %v", tt.changed, changed)
+			}
+		})
+	}
+
+	conf.AcceptChecksum("deployed")
+	if got := conf.Checksum(); got != "deployed" {
+		t.Errorf("expected the accepted checksum, got %s", got)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"fmt"
+	"net/netip"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// ClusterOptions are options for generating the config of a node group
+// running in a Kubernetes cluster.
+type ClusterOptions struct {
+	// Mesh is the mesh.
+	Mesh *meshv1.Mesh
+	// Group is the node group.
+	Group *meshv1.NodeGroup
+	// ExternalURLs are the external addresses of the group's load balancer.
+	ExternalURLs []string
+	// ReplicaExternalURLs are the external addresses of the load balancer of
+	// each replica, indexed by ordinal, when the group is exposed per replica.
+	// A config is rendered for each replica broadcasting its own addresses.
+	ReplicaExternalURLs [][]string
+	// JoinServer is the join server. It is ignored for bootstrap groups.
+	JoinServer string
+	// ClusterDomain is the DNS domain of the cluster. Empty uses the
+	// default.
+	ClusterDomain string
+}
+
+// NewForCluster returns a new config for a node group running in a
+// Kubernetes cluster.
+func NewForCluster(opts ClusterOptions) (*Config, error) {
+	mesh, group := opts.Mesh, opts.Group
+	clusterDomain := opts.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = meshv1.DefaultClusterDomain
+	}
+	var isBootstrap bool
+	if val, ok := group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation]; ok && val == "true" {
+		isBootstrap = true
+	}
+	var primaryEndpoint string
+	internalEndpoint := fmt.Sprintf(`{{ env "POD_NAME" }}.%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group, clusterDomain), meshv1.DefaultWireGuardPort)
+	wireguardEndpoints := []string{internalEndpoint}
+	if len(opts.ExternalURLs) > 0 {
+		primaryEndpoint = opts.ExternalURLs[0]
+		wgPort := func() int {
+			if group.Spec.Cluster.Service != nil {
+				return int(group.Spec.Cluster.Service.WireGuardPort)
+			}
+			return meshv1.DefaultWireGuardPort
+		}()
+		for _, url := range opts.ExternalURLs {
+			addr, err := netip.ParseAddr(url)
+			if err != nil {
+				return nil, err
+			}
+			var externalEndpoint string
+			if addr.Is4() {
+				externalEndpoint = fmt.Sprintf(`%s:%d`, url, wgPort)
+			} else {
+				externalEndpoint = fmt.Sprintf(`[%s]:%d`, url, wgPort)
+			}
+			wireguardEndpoints = append(wireguardEndpoints, externalEndpoint)
+		}
+	}
+	var advertiseAddress string
+	var joinServer string
+	var bootstrapVoters []string
+	bootstrapServers := make(map[string]string)
+	if isBootstrap {
+		if group.Replicas() > 1 {
+			advertiseAddress = fmt.Sprintf(`{{ env "POD_NAME" }}.%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group, clusterDomain), meshv1.DefaultRaftPort)
+			for i := 0; i < int(group.Replicas()); i++ {
+				bootstrapServers[meshv1.MeshNodeHostname(mesh, group, i)] = fmt.Sprintf("%s:%d", meshv1.MeshNodeClusterFQDN(mesh, group, i, clusterDomain), meshv1.DefaultRaftPort)
+			}
+		}
+		bootstrapVoters = BootstrapVoters(mesh, mesh.BootstrapGroups())
+	} else {
+		joinServer = opts.JoinServer
+	}
+	conf, err := New(Options{
+		Mesh:                mesh,
+		Group:               group,
+		AdvertiseAddress:    advertiseAddress,
+		PrimaryEndpoint:     primaryEndpoint,
+		WireGuardEndpoints:  wireguardEndpoints,
+		IsBootstrap:         isBootstrap,
+		BootstrapServers:    bootstrapServers,
+		BootstrapVoters:     bootstrapVoters,
+		JoinServer:          joinServer,
+		IsPersistent:        group.Spec.Cluster.PVCSpec != nil,
+		CertDir:             meshv1.DefaultTLSDirectory,
+		WireGuardListenPort: meshv1.DefaultWireGuardPort,
+		ClusterDomain:       clusterDomain,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("build node config: %w", err)
+	}
+	if len(opts.ReplicaExternalURLs) > 0 {
+		conf.replicas = make(map[string][]byte, len(opts.ReplicaExternalURLs))
+		for i, urls := range opts.ReplicaExternalURLs {
+			replica, err := NewForCluster(ClusterOptions{
+				Mesh:          mesh,
+				Group:         group,
+				ExternalURLs:  urls,
+				JoinServer:    opts.JoinServer,
+				ClusterDomain: opts.ClusterDomain,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("build config of replica %d: %w", i, err)
+			}
+			conf.replicas[ReplicaFileName(mesh, group, i)] = replica.Raw()
+		}
+	}
+	return conf, nil
+}
+
+// ReplicaFileName returns the name of the file the config of a single replica
+// is stored under. It matches meshv1.ReplicaConfigPath for the replica's pod.
+func ReplicaFileName(mesh *meshv1.Mesh, group *meshv1.NodeGroup, index int) string {
+	return meshv1.MeshNodeGroupPodName(mesh, group, index) + ".yaml"
+}
+
+// BootstrapVoters returns the IDs of the nodes the bootstrap group of a mesh
+// authorizes as voters. These are the nodes of the other groups generated for
+// the bootstrap configuration, such as the load balancer group, and any extra
+// voters configured on the mesh.
+func BootstrapVoters(mesh *meshv1.Mesh, bootstrapGroups []*meshv1.NodeGroup) []string {
+	var voters []string
+	for _, group := range bootstrapGroups {
+		if val, ok := group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation]; ok && val == "true" {
+			continue
+		}
+		for i := 0; i < int(group.Replicas()); i++ {
+			voters = append(voters, meshv1.MeshNodeHostname(mesh, group, i))
+		}
+	}
+	return append(voters, mesh.Spec.Bootstrap.ExtraVoters...)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestBootstrapVoters(t *testing.T) {
+	newMesh := func(exposed bool, extraVoters ...string) *meshv1.Mesh {
+		mesh := &meshv1.Mesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+			Spec: meshv1.MeshSpec{
+				Bootstrap: meshv1.NodeGroupSpec{
+					Replicas:    &[]int32{3}[0],
+					Cluster:     &meshv1.NodeGroupClusterConfig{},
+					ExtraVoters: extraVoters,
+				},
+			},
+		}
+		if exposed {
+			mesh.Spec.Bootstrap.Cluster.Service = &meshv1.NodeGroupLBConfig{}
+		}
+		return mesh
+	}
+	withLBReplicas := func(groups []*meshv1.NodeGroup, replicas int32) []*meshv1.NodeGroup {
+		for _, group := range groups {
+			if group.GetName() == "mesh-bootstrap-lb" {
+				group.Spec.Replicas = &replicas
+			}
+		}
+		return groups
+	}
+
+	tc := []struct {
+		name   string
+		mesh   *meshv1.Mesh
+		groups func(*meshv1.Mesh) []*meshv1.NodeGroup
+		want   []string
+	}{
+		{
+			name:   "not exposed",
+			mesh:   newMesh(false),
+			groups: (*meshv1.Mesh).BootstrapGroups,
+			want:   nil,
+		},
+		{
+			name: "zero lb replicas",
+			mesh: newMesh(true),
+			groups: func(mesh *meshv1.Mesh) []*meshv1.NodeGroup {
+				return withLBReplicas(mesh.BootstrapGroups(), 0)
+			},
+			want: nil,
+		},
+		{
+			name:   "single lb replica",
+			mesh:   newMesh(true),
+			groups: (*meshv1.Mesh).BootstrapGroups,
+			want:   []string{"mesh-bootstrap-lb-0"},
+		},
+		{
+			name: "multiple lb replicas",
+			mesh: newMesh(true),
+			groups: func(mesh *meshv1.Mesh) []*meshv1.NodeGroup {
+				return withLBReplicas(mesh.BootstrapGroups(), 3)
+			},
+			want: []string{"mesh-bootstrap-lb-0", "mesh-bootstrap-lb-1", "mesh-bootstrap-lb-2"},
+		},
+		{
+			name:   "extra voters",
+			mesh:   newMesh(true, "external-node"),
+			groups: (*meshv1.Mesh).BootstrapGroups,
+			want:   []string{"mesh-bootstrap-lb-0", "external-node"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BootstrapVoters(tt.mesh, tt.groups(tt.mesh))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected voters %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewForClusterBootstrapVoters(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{
+			Bootstrap: meshv1.NodeGroupSpec{
+				Replicas: &[]int32{3}[0],
+				Cluster: &meshv1.NodeGroupClusterConfig{
+					Service: &meshv1.NodeGroupLBConfig{},
+				},
+			},
+		},
+	}
+	groups := mesh.BootstrapGroups()
+	conf, err := NewForCluster(ClusterOptions{Mesh: mesh, Group: groups[0]})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	want := []string{"mesh-bootstrap-lb-0"}
+	if got := conf.Options.Bootstrap.Voters; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected voters %v, got %v", want, got)
+	}
+}
+
+func TestNewForClusterReplicaConfigs(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: &[]int32{2}[0],
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{WireGuardPort: 51820, PerReplica: true},
+			},
+		},
+	}
+	build := func(replicaURLs [][]string) *Config {
+		conf, err := NewForCluster(ClusterOptions{
+			Mesh:                mesh,
+			Group:               group,
+			ExternalURLs:        []string{"203.0.113.10", "203.0.113.11"},
+			ReplicaExternalURLs: replicaURLs,
+			JoinServer:          "join:8443",
+		})
+		if err != nil {
+			t.Fatalf("build config: %v", err)
+		}
+		return conf
+	}
+	conf := build([][]string{{"203.0.113.10"}, {"203.0.113.11"}})
+	files := conf.ReplicaFiles()
+	if len(files) != 2 {
+		t.Fatalf("expected a config for each replica, got %d", len(files))
+	}
+	for i, addr := range []string{"203.0.113.10", "203.0.113.11"} {
+		raw, ok := files[ReplicaFileName(mesh, group, i)]
+		if !ok {
+			t.Fatalf("expected a config for replica %d", i)
+		}
+		replica := build(nil)
+		if err := replica.Options.UnmarshalJSON(raw); err != nil {
+			t.Fatalf("parse config of replica %d: %v", i, err)
+		}
+		if got := replica.Options.Mesh.PrimaryEndpoint; got != addr {
+			t.Errorf("expected replica %d to broadcast %s, got %s", i, addr, got)
+		}
+	}
+	if build(nil).Checksum() == conf.Checksum() {
+		t.Error("expected the replica configs to change the checksum")
+	}
+	if build([][]string{{"203.0.113.10"}, {"203.0.113.12"}}).Checksum() == conf.Checksum() {
+		t.Error("expected a changed replica address to change the checksum")
+	}
+}
@@ -20,7 +20,9 @@ package nodeconfig
 import (
 	"crypto/sha256"
 	"fmt"
+	"net"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/webmeshproj/webmesh/pkg/config"
@@ -56,21 +58,43 @@ type Options struct {
 	CertDir string
 	// DetectEndpoints is true if endpoints should be detected.
 	DetectEndpoints bool
+	// DetectIPv6 is true if IPv6 endpoints should be detected as well.
+	DetectIPv6 bool
 	// AllowRemoteDetection is true if remote detection is allowed.
 	AllowRemoteDetection bool
+	// NoIPv6 is true if the network of the nodes has no IPv6. IPv6 is then
+	// not used within the mesh, as with the NoIPv6 option of the group.
+	NoIPv6 bool
 	// PersistentKeepalive is the persistent keepalive.
 	PersistentKeepalive time.Duration
+	// HostAliases are the cluster names that nodes outside of the cluster
+	// resolve through their hosts file.
+	HostAliases []string
+	// ClusterDomain is the DNS domain of the cluster. Empty uses the
+	// default.
+	ClusterDomain string
 }
 
 // Config represents a rendered node group config.
 type Config struct {
 	Options *config.Config
-	raw     []byte
+	// Secrets are the options read from secrets.
+	Secrets []SecretOption
+
+	raw            []byte
+	replicas       map[string][]byte
+	checksumInputs map[string]string
+	accepted       string
 }
 
-// Checksum returns the checksum of the config.
+// Checksum returns the checksum of the config. Only the options that differ
+// from the defaults of the config package are checksummed, together with the
+// versions of the secrets and certificates the nodes read.
 func (c *Config) Checksum() string {
-	return fmt.Sprintf("%x", sha256.Sum256(c.raw))
+	if c.accepted != "" {
+		return c.accepted
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(c.checksumData()))
 }
 
 // Raw returns the raw config.
@@ -78,36 +102,37 @@ func (c *Config) Raw() []byte {
 	return c.raw
 }
 
+// ReplicaFiles returns the raw configs rendered for single replicas, keyed by
+// the file name they are stored under. It is empty unless the group renders
+// a config per replica.
+func (c *Config) ReplicaFiles() map[string][]byte {
+	return c.replicas
+}
+
 // New returns a new node group config.
 func New(opts Options) (*Config, error) {
 	group := opts.Group
 	mesh := opts.Mesh
 
 	// Merge config group if specified
-	groupcfg := group.Spec.Config
-	if group.Spec.ConfigGroup != "" {
-		if mesh.Spec.ConfigGroups == nil {
-			return nil, fmt.Errorf("config group %s not found", group.Spec.ConfigGroup)
-		}
-		configGroup, ok := mesh.Spec.ConfigGroups[group.Spec.ConfigGroup]
-		if !ok {
-			return nil, fmt.Errorf("config group %s not found", group.Spec.ConfigGroup)
-		}
-		groupcfg = configGroup.Merge(groupcfg)
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return nil, err
 	}
 	nodeopts := config.NewDefaultConfig("")
+	var secrets []SecretOption
 
 	// Global options
 	nodeopts.Global.LogLevel = groupcfg.LogLevel
 	nodeopts.Global.TLSCertFile = fmt.Sprintf(`%s/tls.crt`, opts.CertDir)
 	nodeopts.Global.TLSKeyFile = fmt.Sprintf(`%s/tls.key`, opts.CertDir)
 	nodeopts.Global.TLSCAFile = fmt.Sprintf(`%s/ca.crt`, opts.CertDir)
-	nodeopts.Global.MTLS = true
-	nodeopts.Global.VerifyChainOnly = mesh.Spec.Issuer.Create
-	nodeopts.Global.DisableIPv6 = groupcfg.NoIPv6
+	nodeopts.Global.MTLS = mesh.RequireClientCert(groupcfg)
+	nodeopts.Global.VerifyChainOnly = mesh.VerifyChainOnly(groupcfg)
+	nodeopts.Global.DisableIPv6 = groupcfg.NoIPv6 || opts.NoIPv6
 	nodeopts.Global.DetectEndpoints = opts.DetectEndpoints
 	nodeopts.Global.AllowRemoteDetection = opts.AllowRemoteDetection
-	nodeopts.Global.DetectIPv6 = opts.DetectEndpoints // TODO: Make this a separate option
+	nodeopts.Global.DetectIPv6 = opts.DetectEndpoints && opts.DetectIPv6
 
 	// Endpoint and zone awareness options
 	zoneAwarenessID := group.GetName()
@@ -127,13 +152,33 @@ func New(opts Options) (*Config, error) {
 	if opts.WireGuardListenPort > 0 {
 		nodeopts.WireGuard.ListenPort = opts.WireGuardListenPort
 	}
+	if group.Spec.Cluster != nil && group.Spec.Cluster.WireGuardMode == meshv1.WireGuardModeUserspace {
+		nodeopts.WireGuard.ForceTUN = true
+	}
+
+	// Default gateway options
+	if groupcfg.AdvertisesDefaultGateway() {
+		nodeopts.Mesh.Routes = []string{"0.0.0.0/0"}
+		if !nodeopts.Global.DisableIPv6 {
+			nodeopts.Mesh.Routes = append(nodeopts.Mesh.Routes, "::/0")
+		}
+		nodeopts.WireGuard.Masquerade = true
+	}
 
 	// Bootstrap options
 	if opts.IsBootstrap {
 		nodeopts.Bootstrap.Enabled = true
 		nodeopts.Bootstrap.Admin = meshv1.MeshAdminHostname(mesh)
 		nodeopts.Bootstrap.IPv4Network = mesh.Spec.IPv4
+		if mesh.Spec.Domain != "" {
+			nodeopts.Bootstrap.MeshDomain = mesh.Spec.Domain
+		}
 		nodeopts.Bootstrap.DefaultNetworkPolicy = string(mesh.Spec.DefaultNetworkPolicy)
+		if opts.AdvertiseAddress != "" && group.Spec.Cluster != nil {
+			if err := checkRaftAdvertiseAddress(opts.AdvertiseAddress, opts.ClusterDomain); err != nil {
+				return nil, err
+			}
+		}
 		nodeopts.Bootstrap.Transport.TCPAdvertiseAddress = opts.AdvertiseAddress
 		nodeopts.Bootstrap.Transport.TCPServers = opts.BootstrapServers
 		if len(opts.BootstrapVoters) > 0 {
@@ -166,13 +211,53 @@ func New(opts Options) (*Config, error) {
 		if groupcfg.Services.Metrics != nil {
 			nodeopts.Services.Metrics.ListenAddress = groupcfg.Services.Metrics.ListenAddress
 			nodeopts.Services.Metrics.Path = groupcfg.Services.Metrics.Path
+			if groupcfg.MetricsTLS() != nil && group.Spec.Cluster != nil {
+				// The metrics proxy sidecar serves the configured address
+				nodeopts.Services.Metrics.ListenAddress = meshv1.DefaultMetricsUpstreamAddress
+			}
 		}
 		if groupcfg.Services.WebRTC != nil {
 			nodeopts.Services.WebRTC.STUNServers = groupcfg.Services.WebRTC.STUNServers
+			if ref := groupcfg.Services.WebRTC.STUNServersSecret; ref != nil {
+				opt := SecretOption{Key: "services.webrtc.stun-servers", SecretKeyRef: ref}
+				nodeopts.Services.WebRTC.STUNServers = []string{opt.Placeholder()}
+				secrets = append(secrets, opt)
+			}
 		}
 		if groupcfg.Services.MeshDNS != nil {
-			nodeopts.Services.MeshDNS.ListenUDP = groupcfg.Services.MeshDNS.ListenUDP
-			nodeopts.Services.MeshDNS.ListenTCP = groupcfg.Services.MeshDNS.ListenTCP
+			nodeopts.Services.MeshDNS.ListenUDP = meshDNSListenAddress(groupcfg.Services.MeshDNS.ListenUDP)
+			nodeopts.Services.MeshDNS.ListenTCP = meshDNSListenAddress(groupcfg.Services.MeshDNS.ListenTCP)
+		}
+	}
+
+	// Profile options
+	switch group.Spec.Profile {
+	case meshv1.NodeGroupProfileDNS:
+		// DNS nodes only serve MeshDNS. They are not advertised as WireGuard
+		// endpoints and take no routing duties.
+		if groupcfg.Services == nil || groupcfg.Services.MeshDNS == nil {
+			nodeopts.Services.MeshDNS.ListenUDP = meshDNSListenAddress("")
+			nodeopts.Services.MeshDNS.ListenTCP = meshDNSListenAddress("")
+		}
+		nodeopts.Services.MeshDNS.Enabled = true
+		nodeopts.Mesh.PrimaryEndpoint = ""
+		nodeopts.Mesh.Routes = nil
+		nodeopts.WireGuard.Endpoints = nil
+		nodeopts.WireGuard.Masquerade = false
+		nodeopts.Global.DetectEndpoints = false
+		nodeopts.Global.DetectIPv6 = false
+		nodeopts.Global.AllowRemoteDetection = false
+		nodeopts.Raft.RequestVote = false
+		nodeopts.Raft.RequestObserver = true
+	case meshv1.NodeGroupProfileRelay:
+		nodeopts.Raft.RequestVote = false
+		nodeopts.Raft.RequestObserver = true
+	}
+
+	// Nodes outside of the cluster cannot resolve its names
+	if group.Spec.Cluster == nil {
+		if err := checkClusterNames(&nodeopts, opts.HostAliases, opts.ClusterDomain); err != nil {
+			return nil, err
 		}
 	}
 
@@ -183,6 +268,76 @@ func New(opts Options) (*Config, error) {
 	}
 	return &Config{
 		Options: &nodeopts,
+		Secrets: secrets,
 		raw:     out,
 	}, nil
 }
+
+// checkClusterNames returns an error naming the first option that holds a
+// cluster-internal name not covered by the given host aliases.
+func checkClusterNames(nodeopts *config.Config, aliases []string, clusterDomain string) error {
+	resolvable := make(map[string]struct{}, len(aliases))
+	for _, alias := range aliases {
+		resolvable[alias] = struct{}{}
+	}
+	check := func(key, addr string) error {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		if !isClusterName(host, clusterDomain) {
+			return nil
+		}
+		if _, ok := resolvable[host]; ok {
+			return nil
+		}
+		return fmt.Errorf("%s: cluster-internal name %q cannot be resolved by nodes outside of the cluster", key, host)
+	}
+	if err := check("mesh.join-address", nodeopts.Mesh.JoinAddress); err != nil {
+		return err
+	}
+	if err := check("mesh.primary-endpoint", nodeopts.Mesh.PrimaryEndpoint); err != nil {
+		return err
+	}
+	for _, endpoint := range nodeopts.WireGuard.Endpoints {
+		if err := check("wireguard.endpoints", endpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRaftAdvertiseAddress returns an error if the Raft advertise address of
+// a node in the cluster is not a cluster-internal name. Raft is only served
+// through the headless service of a group and never exposed outside of the
+// cluster.
+func checkRaftAdvertiseAddress(addr, clusterDomain string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("bootstrap.transport.tcp-advertise-address: %w", err)
+	}
+	if !isClusterName(host, clusterDomain) {
+		return fmt.Errorf("bootstrap.transport.tcp-advertise-address: %q is not a cluster-internal name, Raft is not exposed outside of the cluster", addr)
+	}
+	return nil
+}
+
+// isClusterName returns true if the host is a service or pod name of the
+// cluster with the given domain.
+func isClusterName(host, clusterDomain string) bool {
+	if clusterDomain == "" {
+		clusterDomain = meshv1.DefaultClusterDomain
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.HasSuffix(host, ".svc") || strings.HasSuffix(host, ".svc."+clusterDomain)
+}
+
+// meshDNSListenAddress returns the address MeshDNS listens on for the given
+// configured address. Nodes default to the port that the pods and services of
+// the group expose instead of the privileged port MeshDNS uses by default.
+func meshDNSListenAddress(addr string) string {
+	if addr == "" {
+		return fmt.Sprintf("[::]:%d", meshv1.DefaultMeshDNSPort)
+	}
+	return addr
+}
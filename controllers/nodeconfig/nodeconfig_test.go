@@ -0,0 +1,207 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestClusterNamesOutsideCluster(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	headless := "mesh-bootstrap.default.svc.cluster.local"
+
+	tc := []struct {
+		name    string
+		cluster bool
+		opts    Options
+		option  string
+	}{
+		{
+			name: "external join server",
+			opts: Options{JoinServer: "203.0.113.10:8443"},
+		},
+		{
+			name:   "cluster join server",
+			opts:   Options{JoinServer: headless + ":8443"},
+			option: "mesh.join-address",
+		},
+		{
+			name: "aliased join server",
+			opts: Options{JoinServer: headless + ":8443", HostAliases: []string{headless}},
+		},
+		{
+			name:   "cluster primary endpoint",
+			opts:   Options{JoinServer: "203.0.113.10:8443", PrimaryEndpoint: "node.default.svc"},
+			option: "mesh.primary-endpoint",
+		},
+		{
+			name:   "cluster wireguard endpoint",
+			opts:   Options{JoinServer: "203.0.113.10:8443", WireGuardEndpoints: []string{headless + ":51820"}},
+			option: "wireguard.endpoints",
+		},
+		{
+			name:    "cluster group",
+			cluster: true,
+			opts:    Options{JoinServer: headless + ":8443"},
+		},
+		{
+			name:    "cluster raft advertise address",
+			cluster: true,
+			opts:    Options{IsBootstrap: true, AdvertiseAddress: `{{ env "POD_NAME" }}.` + headless + ":9443"},
+		},
+		{
+			name:   "custom domain join server",
+			opts:   Options{JoinServer: "mesh-bootstrap.default.svc.k8s.example:8443", ClusterDomain: "k8s.example"},
+			option: "mesh.join-address",
+		},
+		{
+			name: "other domain join server",
+			opts: Options{JoinServer: "mesh-bootstrap.default.svc.k8s.example:8443"},
+		},
+		{
+			name:    "custom domain raft advertise address",
+			cluster: true,
+			opts:    Options{IsBootstrap: true, AdvertiseAddress: `{{ env "POD_NAME" }}.mesh-bootstrap.default.svc.k8s.example:9443`, ClusterDomain: "k8s.example"},
+		},
+		{
+			name:    "external raft advertise address",
+			cluster: true,
+			opts:    Options{IsBootstrap: true, AdvertiseAddress: "203.0.113.10:9443"},
+			option:  "bootstrap.transport.tcp-advertise-address",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec:       meshv1.NodeGroupSpec{GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{}},
+			}
+			if tt.cluster {
+				group.Spec = meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{}}
+			}
+			opts := tt.opts
+			opts.Mesh = mesh
+			opts.Group = group
+			_, err := New(opts)
+			if tt.option == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.option) {
+				t.Fatalf("expected an error naming %s, got %v", tt.option, err)
+			}
+		})
+	}
+}
+
+func TestWireGuardMode(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	for mode, forceTUN := range map[meshv1.WireGuardMode]bool{
+		meshv1.WireGuardModeKernel:    false,
+		meshv1.WireGuardModeUserspace: true,
+		// The node falls back to TUN when the kernel interface fails
+		meshv1.WireGuardModeAuto: false,
+	} {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{
+				Cluster: &meshv1.NodeGroupClusterConfig{WireGuardMode: mode},
+			},
+		}
+		conf, err := New(Options{Mesh: mesh, Group: group, JoinServer: "mesh-bootstrap:8443"})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+		if conf.Options.WireGuard.ForceTUN != forceTUN {
+			t.Errorf("%s: expected force-tun %v, got %v", mode, forceTUN, conf.Options.WireGuard.ForceTUN)
+		}
+	}
+}
+
+func TestDefaultGatewayModes(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	for _, tt := range []struct {
+		mode       meshv1.DefaultGatewayMode
+		noIPv6     bool
+		routes     []string
+		masquerade bool
+	}{
+		{mode: meshv1.DefaultGatewayAdvertise, routes: []string{"0.0.0.0/0", "::/0"}, masquerade: true},
+		{mode: meshv1.DefaultGatewayAdvertise, noIPv6: true, routes: []string{"0.0.0.0/0"}, masquerade: true},
+		// Nodes install the routes advertised by their peers in both modes
+		{mode: meshv1.DefaultGatewayAccept},
+		{mode: meshv1.DefaultGatewayIgnore},
+	} {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{
+				Cluster: &meshv1.NodeGroupClusterConfig{},
+				Config:  &meshv1.NodeGroupConfig{DefaultGateway: tt.mode, NoIPv6: tt.noIPv6},
+			},
+		}
+		conf, err := New(Options{Mesh: mesh, Group: group, JoinServer: "mesh-bootstrap:8443"})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.mode, err)
+		}
+		if got := conf.Options.Mesh.Routes; fmt.Sprint(got) != fmt.Sprint(tt.routes) {
+			t.Errorf("%s (no IPv6 %v): expected routes %v, got %v", tt.mode, tt.noIPv6, tt.routes, got)
+		}
+		if conf.Options.WireGuard.Masquerade != tt.masquerade {
+			t.Errorf("%s: expected masquerade %v, got %v", tt.mode, tt.masquerade, conf.Options.WireGuard.Masquerade)
+		}
+	}
+}
+
+func TestDNSProfileListenAddress(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Profile: meshv1.NodeGroupProfileDNS,
+			Cluster: &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	conf, err := New(Options{Mesh: mesh, Group: group, JoinServer: "mesh-bootstrap:8443"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The node must listen on the port the pods and services expose
+	want := fmt.Sprintf("[::]:%d", meshv1.DefaultMeshDNSPort)
+	if dns := conf.Options.Services.MeshDNS; !dns.Enabled || dns.ListenUDP != want || dns.ListenTCP != want {
+		t.Errorf("expected MeshDNS on %s, got %+v", want, dns)
+	}
+
+	// Configured addresses are kept
+	group.Spec.Config = &meshv1.NodeGroupConfig{
+		Services: &meshv1.NodeServicesConfig{MeshDNS: &meshv1.NodeMeshDNSConfig{ListenUDP: ":53", ListenTCP: ":53"}},
+	}
+	conf, err = New(Options{Mesh: mesh, Group: group, JoinServer: "mesh-bootstrap:8443"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dns := conf.Options.Services.MeshDNS; dns.ListenUDP != ":53" || dns.ListenTCP != ":53" {
+		t.Errorf("expected the configured MeshDNS addresses, got %+v", dns)
+	}
+}
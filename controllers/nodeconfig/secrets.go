@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SecretOption is a node config option whose value is read from a secret.
+// The config only holds a placeholder for the option, the value is passed to
+// the node through an environment variable, which takes precedence over the
+// config file.
+type SecretOption struct {
+	// Key is the key of the option in the node config.
+	Key string
+	// SecretKeyRef selects the key of the secret holding the value.
+	SecretKeyRef *corev1.SecretKeySelector
+}
+
+// EnvName returns the name of the environment variable the node reads the
+// option from.
+func (o SecretOption) EnvName() string {
+	return strings.ToUpper(strings.ReplaceAll(o.Key, ".", "_"))
+}
+
+// Placeholder returns the value written to the config in place of the secret.
+func (o SecretOption) Placeholder() string {
+	return "${" + o.EnvName() + "}"
+}
+
+// EnvVar returns the environment variable passing the option to the node.
+func (o SecretOption) EnvVar() corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: o.EnvName(),
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: o.SecretKeyRef.DeepCopy(),
+		},
+	}
+}
+
+// EnvVars returns the environment variables passing the secret options of the
+// config to the node.
+func (c *Config) EnvVars() []corev1.EnvVar {
+	if len(c.Secrets) == 0 {
+		return nil
+	}
+	out := make([]corev1.EnvVar, 0, len(c.Secrets))
+	for _, opt := range c.Secrets {
+		out = append(out, opt.EnvVar())
+	}
+	return out
+}
+
+// SetSecretVersion records the resource version of a secret the config reads
+// options from. Versions are included in the checksum, so nodes are restarted
+// when the secret is rotated.
+func (c *Config) SetSecretVersion(name, version string) {
+	c.setChecksumInput("secret/"+name, version)
+}
+
+// SetCertificateSANs records the subject alternative names a node certificate
+// was issued with, so nodes are restarted when it is reissued with new names.
+func (c *Config) SetCertificateSANs(name string, sans []string) {
+	c.setChecksumInput("certificate/"+name, strings.Join(sans, ","))
+}
+
+// SetReissuedCertificateNames records the DNS names a node certificate was
+// reissued with, so nodes are restarted once it carries new names.
+func (c *Config) SetReissuedCertificateNames(name string, dnsNames []string) {
+	c.setChecksumInput("reissued-certificate/"+name, strings.Join(dnsNames, ","))
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/webmeshproj/webmesh/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestSecretOptions(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	ref := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "turn"},
+		Key:                  "servers",
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Config: &meshv1.NodeGroupConfig{
+				Services: &meshv1.NodeServicesConfig{
+					WebRTC: &meshv1.NodeWebRTCConfig{
+						STUNServers:       []string{"stun:stun.example.com:3478"},
+						STUNServersSecret: ref,
+					},
+				},
+			},
+		},
+	}
+	conf, err := New(Options{Mesh: mesh, Group: group, JoinServer: "join:8443"})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	if strings.Contains(string(conf.Raw()), "stun.example.com") {
+		t.Error("expected the secret option to replace the plain STUN servers")
+	}
+	env := conf.EnvVars()
+	if len(env) != 1 {
+		t.Fatalf("expected one env var, got %v", env)
+	}
+	if env[0].ValueFrom == nil || !reflect.DeepEqual(env[0].ValueFrom.SecretKeyRef, ref) {
+		t.Errorf("expected env var from secret key %v, got %v", ref, env[0].ValueFrom)
+	}
+
+	// The node reads the value from its environment over the placeholder
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, conf.Raw(), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	t.Setenv(env[0].Name, "turn:user:pass@turn.example.com:3478,stun:stun.example.com:3478")
+	loaded := config.NewDefaultConfig("")
+	if err := loaded.LoadFrom(pflag.NewFlagSet("node", pflag.ContinueOnError), []string{path}); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	want := []string{"turn:user:pass@turn.example.com:3478", "stun:stun.example.com:3478"}
+	if got := loaded.Services.WebRTC.STUNServers; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected STUN servers %v, got %v", want, got)
+	}
+
+	// Rotating the secret changes the checksum
+	checksum := conf.Checksum()
+	conf.SetSecretVersion("turn", "1")
+	rotated := conf.Checksum()
+	if rotated == checksum {
+		t.Error("expected the secret version to change the checksum")
+	}
+	conf.SetSecretVersion("turn", "2")
+	if conf.Checksum() == rotated {
+		t.Error("expected a new secret version to change the checksum")
+	}
+}
+
+func TestCertificateSANsChecksum(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"}}
+	conf, err := New(Options{Mesh: mesh, Group: group, JoinServer: "join:8443"})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	checksum := conf.Checksum()
+	conf.SetCertificateSANs("mesh-group-0", []string{"203.0.113.10"})
+	reissued := conf.Checksum()
+	if reissued == checksum {
+		t.Error("expected the certificate names to change the checksum")
+	}
+	conf.SetCertificateSANs("mesh-group-0", []string{"203.0.113.10"})
+	if conf.Checksum() != reissued {
+		t.Error("expected the same certificate names to keep the checksum")
+	}
+	conf.SetCertificateSANs("mesh-group-0", []string{"203.0.113.10", "mesh.example.com"})
+	if conf.Checksum() == reissued {
+		t.Error("expected new certificate names to change the checksum")
+	}
+}
@@ -19,31 +19,107 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+	"github.com/webmeshproj/operator/controllers/providers"
 	"github.com/webmeshproj/operator/controllers/resources"
 )
 
 // NodeGroupReconciler reconciles a NodeGroup object
 type NodeGroupReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Namespaced is true when the operator is restricted to a set of
+	// namespaces. Cluster-wide lookups are skipped.
+	Namespaced bool
+	// ApplyBudget is the maximum number of objects patched in a single
+	// reconcile. Zero is unlimited.
+	ApplyBudget int
+	// Shard is the subset of meshes reconciled by this replica.
+	Shard *fairness.Shard
+	// Warmup staggers the first reconciles after becoming leader.
+	Warmup *fairness.Warmup
+	// Debounce coalesces spec changes made in quick succession into a
+	// single rollout.
+	Debounce *fairness.Debounce
+	// Limiter bounds concurrent calls to cloud providers.
+	Limiter *fairness.Limiter
+	// DryRun simulates changes instead of making them. Writes are made with
+	// a server-side dry run, except for the status of the reconciled objects.
+	DryRun bool
+	// Settings resolves the operator settings of each reconcile.
+	Settings *operatorconfig.Store
+	// LabelPrivilegedNamespaces labels namespaces enforcing a pod security
+	// level that does not admit the pods of their cluster node groups to
+	// enforce the level they require instead.
+	LabelPrivilegedNamespaces bool
+	// Providers overrides the providers node groups are deployed with.
+	Providers providers.Registry
+
+	// ipFamilies caches the service IP families detected in the local cluster.
+	ipFamilies   *meshv1.ServiceIPFamilies
+	ipFamiliesMu sync.Mutex
+	// awsConfig caches the default AWS configuration of the operator.
+	awsConfig   *aws.Config
+	awsConfigMu sync.Mutex
+	// azureIdentities caches the workload and managed identities of the
+	// operator.
+	azureIdentities map[string]azcore.TokenCredential
+	azureIdentityMu sync.Mutex
+	// googleClients caches the compute API clients of each Google Cloud
+	// credentials secret and project.
+	googleClients   map[string]*googleCloudClients
+	googleClientsMu sync.Mutex
 }
 
 const nodeGroupsForegroundDeletion = "nodegroups.mesh.webmesh.io"
 
+// providers returns the providers node groups are deployed with.
+func (r *NodeGroupReconciler) providers() providers.Registry {
+	if r.Providers != nil {
+		return r.Providers
+	}
+	return providers.Registry{
+		providers.Cluster:      clusterProvider{r},
+		providers.GoogleCloud:  googleCloudProvider{r},
+		providers.AWS:          awsProvider{r},
+		providers.Azure:        azureProvider{r},
+		providers.DigitalOcean: digitalOceanProvider{r},
+		providers.Hetzner:      hetznerProvider{r},
+		providers.BareMetal:    bareMetalProvider{r},
+	}
+}
+
 //+kubebuilder:rbac:groups="",resources=services;configmaps;persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;patch
+//+kubebuilder:rbac:groups="",resources=pods/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 //+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=mesh.webmesh.io,resources=nodegroups,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=mesh.webmesh.io,resources=nodegroups/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=mesh.webmesh.io,resources=nodegroups/finalizers,verbs=update
@@ -57,60 +133,198 @@ func (r *NodeGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	if err := r.Get(ctx, req.NamespacedName, &group); err != nil {
 		if client.IgnoreNotFound(err) != nil {
 			log.Error(err, "unable to fetch NodeGroup")
+		} else {
+			r.Debounce.Forget(req.String())
+			resources.ForgetOwned("NodeGroup", req.NamespacedName)
 		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	if !r.Shard.Owns(group.MeshKey()) {
+		return ctrl.Result{}, nil
+	}
+	if delay := r.Warmup.Delay("NodeGroup/" + req.String()); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	if r.DryRun {
+		ctx = resources.WithDryRun(ctx)
+	}
+	settings, err := r.Settings.Snapshot(ctx, req.Namespace)
+	if err != nil {
+		log.Error(err, "unable to resolve operator settings")
+		return ctrl.Result{}, err
+	}
+	ctx = operatorconfig.WithSettings(ctx, settings)
+
+	// Default the spec in case the object was created without going
+	// through the webhooks. The defaults are only used to render the group,
+	// changes to its metadata are patched so they are not written back.
+	group.Spec.DefaultImage(settings.Images.Node)
+	group.Spec.Default()
+	if err := reconcileSimulated(ctx, r.Client, &group, &group.Status.Conditions, r.DryRun); err != nil {
+		log.Error(err, "unable to update NodeGroup status")
+		return ctrl.Result{}, err
+	}
 
 	if group.GetDeletionTimestamp() != nil {
 		return ctrl.Result{}, r.reconcileDelete(ctx, &group)
 	}
 
+	// Wait for edits made in quick succession, so a change to both the image
+	// and the config rolls the nodes once
+	if delay := r.Debounce.Delay(req.String(), group.GetGeneration()); delay > 0 {
+		log.Info("Waiting for further changes to the NodeGroup", "delay", delay)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
 	log.Info("reconciling NodeGroup")
 
+	res, err := r.reconcileNodeGroup(resources.WithApplyBudget(ctx, r.ApplyBudget), &group)
+	res, err = requeueOnApplyBudget(ctx, res, err)
+	res, err = recordReconcile(ctx, r.Client, &group, &group.Status.ReconcileStatus, res, err)
+	return reconcileRestricted(ctx, r.Client, &group, &group.Status.Conditions, res, err)
+}
+
+// reconcileNodeGroup creates the certificates and workloads of a node group.
+func (r *NodeGroupReconciler) reconcileNodeGroup(ctx context.Context, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
 	// Get the mesh object
 	var mesh meshv1.Mesh
-	if err := r.Get(ctx, client.ObjectKey{
-		Name: group.Spec.Mesh.Name,
-		Namespace: func() string {
-			if group.Spec.Mesh.Namespace != "" {
-				return group.Spec.Mesh.Namespace
-			}
-			return group.GetNamespace()
-		}(),
-	}, &mesh); err != nil {
+	if err := r.Get(ctx, group.MeshKey(), &mesh); err != nil {
 		log.Error(err, "unable to fetch Mesh")
 		return ctrl.Result{}, err
 	}
 
+	// Make sure the group would be admitted before creating any of its objects
+	if group.Spec.Cluster != nil {
+		wait, err := r.reconcileStatefulSetSelector(ctx, &mesh, group)
+		if err != nil {
+			log.Error(err, "unable to check statefulset selector")
+			return ctrl.Result{}, err
+		}
+		if wait > 0 {
+			log.Info("Statefulset selector cannot be applied yet, waiting before applying the node group")
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		wait, err = r.reconcilePodSecurity(ctx, &mesh, group)
+		if err != nil {
+			log.Error(err, "unable to check pod security admission")
+			return ctrl.Result{}, err
+		}
+		if wait > 0 {
+			log.Info("Pod security admission would reject the node pods, waiting before applying the node group")
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		ok, err := r.preflightClusterNodeGroup(ctx, &mesh, group)
+		if err != nil {
+			log.Error(err, "unable to run pre-flight checks")
+			return ctrl.Result{}, err
+		}
+		if !ok {
+			log.Info("Pre-flight checks failed, waiting before applying the node group")
+			return ctrl.Result{RequeueAfter: preflightRetryInterval}, nil
+		}
+	}
+
 	// We need certificates for the node group no matter where they are going
-	var toApply []client.Object
-	for i := 0; i < int(*group.Spec.Replicas); i++ {
-		toApply = append(toApply, resources.NewNodeCertificate(&mesh, &group, i))
+	nodeCerts := resources.RenderNodeCertificates(&mesh, group, operatorconfig.FromContext(ctx).ClusterDomain)
+	if err := r.reportStaleCertificates(ctx, group, nodeCerts); err != nil {
+		log.Error(err, "unable to check certificates")
+		return ctrl.Result{}, err
 	}
-	if err := resources.Apply(ctx, r.Client, toApply); err != nil {
+	if err := resources.Apply(ctx, r.Client, nodeCerts); err != nil {
 		log.Error(err, "unable to apply certificates")
 		return ctrl.Result{}, err
 	}
 
-	var res ctrl.Result
-	var err error
-	if group.Spec.GoogleCloud != nil {
-		res, err = r.reconcileGoogleCloudNodeGroup(ctx, &mesh, &group)
-	} else if group.Spec.Cluster != nil {
-		res, err = r.reconcileClusterNodeGroup(ctx, &mesh, &group)
-	} else {
-		err = fmt.Errorf("no deployment configuration provided")
+	// Renew expiring certificates before a planned upgrade is applied
+	wait, err := r.reconcileCertificatePreRotation(ctx, &mesh, group)
+	if err != nil {
+		log.Error(err, "unable to pre-rotate certificates")
+		return ctrl.Result{}, err
+	}
+	if wait > 0 {
+		log.Info("Waiting for node certificates to be renewed before applying the node group")
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	// Start a new baseline when the deployed checksums are being accepted
+	accepted := group.Status.AcceptedConfigChecksums
+	reissued := group.Status.ReissuedCertificateNames
+	if acceptsConfigChecksums(group) {
+		group.Status.AcceptedConfigChecksums = nil
 	}
+
+	provider, err := r.providers().For(group)
+	if err != nil {
+		log.Error(err, "unable to select provider")
+		return ctrl.Result{}, err
+	}
+	res, err := provider.Reconcile(ctx, &mesh, group)
 	if err != nil {
 		log.Error(err, "unable to reconcile NodeGroup")
 		return ctrl.Result{}, err
 	}
 
+	// Publish the effective config and flag the group if it is currently
+	// a default gateway
+	groupcfg, err := group.MergedConfig(&mesh)
+	if err != nil {
+		log.Error(err, "unable to merge NodeGroup config")
+		return ctrl.Result{}, err
+	}
+	// Track the expiry of the node certificates
+	certs, recheck, err := r.reconcileCertificateExpiry(ctx, &mesh, group)
+	if err != nil {
+		log.Error(err, "unable to check node certificate expiry")
+		return ctrl.Result{}, err
+	}
+	if recheck > 0 && (res.RequeueAfter == 0 || recheck < res.RequeueAfter) {
+		res.RequeueAfter = recheck
+	}
+	if group.Status.DefaultGateway != groupcfg.AdvertisesDefaultGateway() ||
+		!equality.Semantic.DeepEqual(group.Status.Certificates, certs) ||
+		!equality.Semantic.DeepEqual(group.Status.EffectiveConfig, groupcfg) ||
+		!equality.Semantic.DeepEqual(group.Status.AcceptedConfigChecksums, accepted) ||
+		!equality.Semantic.DeepEqual(group.Status.ReissuedCertificateNames, reissued) {
+		group.Status.DefaultGateway = groupcfg.AdvertisesDefaultGateway()
+		group.Status.Certificates = certs
+		group.Status.EffectiveConfig = groupcfg
+		if err := r.Status().Update(ctx, group); err != nil {
+			log.Error(err, "unable to update NodeGroup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The checksums are only accepted once
+	if acceptsConfigChecksums(group) {
+		log.Info("Removing accept config checksum annotation from node group")
+		patch := client.MergeFrom(group.DeepCopy())
+		delete(group.Annotations, meshv1.AcceptConfigChecksumAnnotation)
+		if err := r.Patch(ctx, group, patch); err != nil {
+			log.Error(err, "unable to update NodeGroup")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The certificates are only pre-rotated for the changes applied above
+	if preRotatesCertificates(group) {
+		log.Info("Removing pre-rotate certificates annotation from node group")
+		patch := client.MergeFrom(group.DeepCopy())
+		delete(group.Annotations, meshv1.PreRotateCertificatesAnnotation)
+		if err := r.Patch(ctx, group, patch); err != nil {
+			log.Error(err, "unable to update NodeGroup")
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Set finalizers
-	if !controllerutil.ContainsFinalizer(&group, nodeGroupsForegroundDeletion) {
+	if !controllerutil.ContainsFinalizer(group, nodeGroupsForegroundDeletion) {
 		log.Info("Adding finalizer to node group")
-		controllerutil.AddFinalizer(&group, nodeGroupsForegroundDeletion)
-		if err = r.Update(ctx, &group); err != nil {
+		patch := client.MergeFromWithOptions(group.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		controllerutil.AddFinalizer(group, nodeGroupsForegroundDeletion)
+		if err = r.Patch(ctx, group, patch); err != nil {
 			err = fmt.Errorf("add finalizer to node group: %w", err)
 		}
 	}
@@ -119,35 +333,25 @@ func (r *NodeGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 func (r *NodeGroupReconciler) reconcileDelete(ctx context.Context, group *meshv1.NodeGroup) error {
 	log := log.FromContext(ctx)
-	if group.Spec.GoogleCloud != nil {
-		log.Info("Deleting Google Cloud NodeGroup resources")
-		err := r.deleteGoogleCloudNodeGroup(ctx, group)
+	// Groups without a deployment have nothing to delete
+	if name, err := providers.Name(group); err == nil {
+		provider, err := r.providers().For(group)
 		if err != nil {
 			return err
 		}
-	} else if group.Spec.Cluster != nil {
-		// Make sure the volumes get marked for deletion
-		log.Info("Deleting Cluster NodeGroup resources")
-		for i := 0; i < int(*group.Spec.Replicas); i++ {
-			var pvc corev1.PersistentVolumeClaim
-			err := r.Get(ctx, client.ObjectKey{
-				Name:      fmt.Sprintf("data-%s-%s-%d", group.Spec.Mesh.Name, group.Name, i),
-				Namespace: group.Namespace,
-			}, &pvc)
-			if err != nil {
-				if client.IgnoreNotFound(err) != nil {
-					return fmt.Errorf("unable to fetch PVC: %w", err)
-				}
-				continue
-			}
-			if err = r.Delete(ctx, &pvc); err != nil {
-				return fmt.Errorf("unable to delete PVC: %w", err)
-			}
+		log.Info("Deleting NodeGroup resources", "provider", name)
+		if err := provider.Delete(ctx, group); err != nil {
+			return err
 		}
 	}
+	nodeCertificateExpiry.DeletePartialMatch(prometheus.Labels{
+		"namespace": group.GetNamespace(),
+		"nodegroup": group.GetName(),
+	})
 	// Remove the finalizer
+	patch := client.MergeFromWithOptions(group.DeepCopy(), client.MergeFromWithOptimisticLock{})
 	controllerutil.RemoveFinalizer(group, nodeGroupsForegroundDeletion)
-	if err := r.Update(ctx, group); err != nil {
+	if err := r.Patch(ctx, group, patch); err != nil {
 		return fmt.Errorf("failed to remove finalizer from node group: %w", err)
 	}
 	return nil
@@ -155,11 +359,20 @@ func (r *NodeGroupReconciler) reconcileDelete(ctx context.Context, group *meshv1
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *NodeGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.DryRun {
+		r.Client = NewDryRunClient(r.Client)
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&meshv1.NodeGroup{}).
 		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
 		Owns(&corev1.Service{}).
 		Owns(&appsv1.StatefulSet{}).
 		Owns(&certv1.Certificate{}).
+		Watches(&appsv1.StatefulSet{}, handler.EnqueueRequestsFromMapFunc(r.bootstrapStatefulSetToNodeGroups)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.secretToNodeGroups)).
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(r.lbServiceToNodeGroups)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.nodePodToNodeGroup)).
+		Watches(&meshv1.WebmeshOperatorConfig{}, handler.EnqueueRequestsFromMapFunc(operatorConfigToObjects(r.Client, r.Settings, &meshv1.NodeGroupList{}))).
 		Complete(r)
 }
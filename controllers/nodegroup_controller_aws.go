@@ -0,0 +1,458 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// awsUbuntuOwner is the account Canonical publishes its Ubuntu images from.
+const awsUbuntuOwner = "099720109477"
+
+// awsEC2API is the subset of the EC2 API used to manage the instances of a
+// node group.
+type awsEC2API interface {
+	ec2.DescribeImagesAPIClient
+	ec2.DescribeInstancesAPIClient
+	RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+	TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error)
+}
+
+// awsProvider deploys node groups to EC2 instances.
+type awsProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r awsProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Bound the instances being changed at once across all groups
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderAWS)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer release()
+
+	instances, err := r.getEC2Client(ctx, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	spec := group.Spec.AWS
+
+	imageID, err := lookupAWSImage(ctx, instances, spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Build the nodeconfig
+	joinServer, err := getJoinServer(ctx, r.Client, mesh, group)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("load balancer not ready, requeueing")
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: time.Second * 3,
+			}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("get join server: %w", err)
+	}
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("merge node group config: %w", err)
+	}
+	users, err := getSSHUsers(ctx, r.Client, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	existing, err := describeAWSInstances(ctx, instances, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	byName := make(map[string][]types.Instance, len(existing))
+	for _, instance := range existing {
+		name := awsInstanceTag(instance, "Name")
+		byName[name] = append(byName[name], instance)
+	}
+	var running int
+	for i := 0; i < int(group.Replicas()); i++ {
+		current := byName[fmt.Sprintf("%s-%d", group.GetName(), i)]
+		if len(current) == 1 && awsInstanceRunning(current[0]) {
+			running++
+		}
+	}
+	rollout := newInstanceRollout(int(group.Replicas()), running)
+
+	// Loop over replicas and ensure each instance
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := fmt.Sprintf("%s-%d", group.GetName(), i)
+		current := byName[name]
+		delete(byName, name)
+
+		secret, err := r.getNodeCertificateSecret(ctx, mesh, group, i)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// Build the node and cloud configs
+		opts := awsNodeConfigOptions(mesh, group, joinServer)
+		opts.ClusterDomain = operatorconfig.FromContext(ctx).ClusterDomain
+		nodeconf, err := nodeconfig.New(opts)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build node config: %w", err)
+		}
+		env, err := getConfigSecrets(ctx, r.Client, group.GetNamespace(), nodeconf)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		cloudconf, err := cloudconfig.New(cloudconfig.Options{
+			Image:   group.Spec.Image,
+			Config:  nodeconf,
+			TLSCert: secret.Data[corev1.TLSCertKey],
+			TLSKey:  secret.Data[corev1.TLSPrivateKeyKey],
+			CA:      secret.Data[cmmeta.TLSCAKey],
+			// Forwarding is enabled by disabling the source/destination
+			// check, add the rules needed to masquerade egress traffic.
+			DefaultGateway: groupcfg.AdvertisesDefaultGateway(),
+			Env:            env,
+			Users:          users,
+			OSFamily:       group.Spec.OSFamily(),
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build cloud config: %w", err)
+		}
+		checksum := awsInstanceChecksum(spec, cloudconf.Checksum())
+
+		// Ensure the instance
+		if len(current) == 1 {
+			deployed := awsInstanceTag(current[0], meshv1.ConfigChecksumAnnotation)
+			if resolveConfigChecksum(ctx, group, deployed, checksum) == deployed {
+				log.Info("Config checksum has not changed, skipping instance", "name", name, "instance", aws.ToString(current[0].InstanceId))
+				continue
+			}
+		}
+		if len(current) > 0 {
+			if !rollout.Replace(len(current) == 1 && awsInstanceRunning(current[0])) {
+				log.Info("Waiting for replaced instances to run before replacing instance", "name", name)
+				continue
+			}
+			// Terminate the instances and recreate them
+			log.Info("Config checksum has changed, terminating instance", "name", name)
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstance",
+				"Replacing instance %s after its config changed", name)
+			if err := terminateAWSInstances(ctx, instances, current); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("Creating instance", "name", name)
+		if simulate(ctx, "create instance", "name", name) {
+			continue
+		}
+		out, err := instances.RunInstances(ctx, &ec2.RunInstancesInput{
+			ImageId:      aws.String(imageID),
+			InstanceType: types.InstanceType(spec.InstanceType),
+			MinCount:     aws.Int32(1),
+			MaxCount:     aws.Int32(1),
+			NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{{
+				DeviceIndex:              aws.Int32(0),
+				SubnetId:                 aws.String(spec.SubnetID),
+				Groups:                   spec.SecurityGroupIDs,
+				AssociatePublicIpAddress: aws.Bool(spec.PublicIPAddress()),
+			}},
+			UserData: aws.String(base64.StdEncoding.EncodeToString(cloudconf.Raw())),
+			TagSpecifications: []types.TagSpecification{{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         awsInstanceTags(mesh, group, name, checksum),
+			}},
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("run instance: %w", err)
+		}
+		if len(out.Instances) == 0 {
+			return ctrl.Result{}, errors.New("run instance: no instance in response")
+		}
+		_, err = instances.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+			InstanceId:      out.Instances[0].InstanceId,
+			SourceDestCheck: &types.AttributeBooleanValue{Value: aws.Bool(false)},
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("disable source/destination check: %w", err)
+		}
+	}
+
+	// Terminate the instances of removed replicas
+	for name, current := range byName {
+		log.Info("Terminating instance of removed replica", "name", name)
+		if err := terminateAWSInstances(ctx, instances, current); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return rollout.Result(), nil
+}
+
+// Delete implements providers.Provider.
+func (r awsProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderAWS)
+	if err != nil {
+		return err
+	}
+	defer release()
+	instances, err := r.getEC2Client(ctx, group)
+	if err != nil {
+		return err
+	}
+	existing, err := describeAWSInstances(ctx, instances, group)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Terminating node group instances", "count", len(existing))
+	return terminateAWSInstances(ctx, instances, existing)
+}
+
+// terminateAWSInstances terminates the given instances.
+func terminateAWSInstances(ctx context.Context, instances awsEC2API, toTerminate []types.Instance) error {
+	ids := make([]string, 0, len(toTerminate))
+	for _, instance := range toTerminate {
+		ids = append(ids, aws.ToString(instance.InstanceId))
+	}
+	if len(ids) == 0 || simulate(ctx, "terminate instances", "instances", ids) {
+		return nil
+	}
+	_, err := instances.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: ids})
+	if err != nil {
+		return fmt.Errorf("terminate instances: %w", err)
+	}
+	return nil
+}
+
+// lookupAWSImage returns the image of the group's instances, looking up the
+// latest Ubuntu 22.04 image unless one is set.
+func lookupAWSImage(ctx context.Context, instances awsEC2API, spec *meshv1.NodeGroupAWSConfig) (string, error) {
+	if spec.AMI != "" {
+		return spec.AMI, nil
+	}
+	arch := "amd64"
+	if spec.Architecture == meshv1.AWSArchitectureARM {
+		arch = "arm64"
+	}
+	out, err := instances.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners: []string{awsUbuntuOwner},
+		Filters: []types.Filter{
+			{Name: aws.String("name"), Values: []string{fmt.Sprintf("ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-%s-server-*", arch)}},
+			{Name: aws.String("architecture"), Values: []string{spec.Architecture}},
+			{Name: aws.String("state"), Values: []string{"available"}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describe ubuntu images: %w", err)
+	}
+	images := out.Images
+	if len(images) == 0 {
+		return "", fmt.Errorf("no ubuntu 22.04 image found for %s in %s", spec.Architecture, spec.Region)
+	}
+	// Creation dates are in ISO 8601 and sort lexically
+	sort.Slice(images, func(i, j int) bool {
+		return aws.ToString(images[i].CreationDate) > aws.ToString(images[j].CreationDate)
+	})
+	return aws.ToString(images[0].ImageId), nil
+}
+
+// describeAWSInstances returns the instances of the group that have not been
+// terminated.
+func describeAWSInstances(ctx context.Context, instances awsEC2API, group *meshv1.NodeGroup) ([]types.Instance, error) {
+	var out []types.Instance
+	pages := ec2.NewDescribeInstancesPaginator(instances, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag:" + meshv1.NodeGroupNameLabel), Values: []string{group.GetName()}},
+			{Name: aws.String("tag:" + meshv1.NodeGroupNamespaceLabel), Values: []string{group.GetNamespace()}},
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "stopping", "stopped"}},
+		},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describe instances: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			out = append(out, reservation.Instances...)
+		}
+	}
+	return out, nil
+}
+
+// awsInstanceTag returns the value of the instance's tag with the given key.
+func awsInstanceTag(instance types.Instance, key string) string {
+	for _, tag := range instance.Tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+// awsInstanceRunning returns true if the instance is running.
+func awsInstanceRunning(instance types.Instance) bool {
+	return instance.State != nil && instance.State.Name == types.InstanceStateNameRunning
+}
+
+// awsInstanceChecksum returns the checksum recorded on an instance. It covers
+// the settings of the instance that can only be changed by recreating it on
+// top of its cloud config, and is the checksum of the cloud config as long as
+// they are left at their defaults.
+func awsInstanceChecksum(spec *meshv1.NodeGroupAWSConfig, configChecksum string) string {
+	if spec.PublicIPAddress() {
+		return configChecksum
+	}
+	data := fmt.Sprintf("%s\npublic-ip=false", configChecksum)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
+// awsInstanceTags returns the tags of the instance with the given name. The
+// checksum of its cloud config is recorded so changes recreate the instance.
+func awsInstanceTags(mesh *meshv1.Mesh, group *meshv1.NodeGroup, name, checksum string) []types.Tag {
+	keys := make([]string, 0, len(group.Spec.AWS.Tags))
+	for key := range group.Spec.AWS.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tags := make([]types.Tag, 0, len(keys)+6)
+	for _, key := range keys {
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(group.Spec.AWS.Tags[key])})
+	}
+	for _, tag := range [][2]string{
+		{"Name", name},
+		{meshv1.MeshNameLabel, mesh.GetName()},
+		{meshv1.MeshNamespaceLabel, mesh.GetNamespace()},
+		{meshv1.NodeGroupNameLabel, group.GetName()},
+		{meshv1.NodeGroupNamespaceLabel, group.GetNamespace()},
+		{meshv1.ConfigChecksumAnnotation, checksum},
+	} {
+		tags = append(tags, types.Tag{Key: aws.String(tag[0]), Value: aws.String(tag[1])})
+	}
+	return tags
+}
+
+// awsNodeConfigOptions returns the node config options for the replicas of an
+// AWS node group. The public address of an instance is not on its interface,
+// so it is detected remotely when it has one.
+func awsNodeConfigOptions(mesh *meshv1.Mesh, group *meshv1.NodeGroup, joinServer string) nodeconfig.Options {
+	return nodeconfig.Options{
+		Mesh:                 mesh,
+		Group:                group,
+		JoinServer:           joinServer,
+		IsPersistent:         true,
+		CertDir:              meshv1.DefaultTLSDirectory,
+		DetectEndpoints:      true,
+		AllowRemoteDetection: group.Spec.AWS.PublicIPAddress(),
+	}
+}
+
+// getEC2Client returns a client for the EC2 API of the group's region using
+// the credentials in the group's secret, or the default credential chain of
+// the operator if it has none. The chain includes the role of the operator's
+// service account when IAM roles for service accounts are set up.
+func (r *NodeGroupReconciler) getEC2Client(ctx context.Context, group *meshv1.NodeGroup) (awsEC2API, error) {
+	spec := group.Spec.AWS
+	if spec.Credentials == nil {
+		cfg, err := r.getAWSConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+			o.Region = spec.Region
+		}), nil
+	}
+	var secret corev1.Secret
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      spec.Credentials.Name,
+		Namespace: group.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("get aws credentials secret: %w", err)
+	}
+	data, ok := secret.Data[spec.Credentials.Key]
+	if !ok {
+		return nil, fmt.Errorf("no key %s in secret %s/%s", spec.Credentials.Key, group.GetNamespace(), spec.Credentials.Name)
+	}
+	creds, err := parseAWSCredentials(data)
+	if err != nil {
+		return nil, err
+	}
+	return ec2.New(ec2.Options{
+		Region:      spec.Region,
+		Credentials: creds,
+	}), nil
+}
+
+// parseAWSCredentials parses credentials in the JSON format of a credential
+// process: an object with AccessKeyId, SecretAccessKey and an optional
+// SessionToken.
+func parseAWSCredentials(data []byte) (aws.CredentialsProvider, error) {
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		SessionToken    string `json:"SessionToken"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parse aws credentials: %w", err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("aws credentials must have an AccessKeyId and SecretAccessKey")
+	}
+	return credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken), nil
+}
+
+// getAWSConfig returns the default configuration of the operator. It is
+// shared by all groups without credentials so assumed roles are cached.
+func (r *NodeGroupReconciler) getAWSConfig(ctx context.Context) (aws.Config, error) {
+	r.awsConfigMu.Lock()
+	defer r.awsConfigMu.Unlock()
+	if r.awsConfig != nil {
+		return *r.awsConfig, nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("load aws config: %w", err)
+	}
+	r.awsConfig = &cfg
+	return cfg, nil
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeEC2 serves images and pages of instances and records the instances
+// terminated.
+type fakeEC2 struct {
+	awsEC2API
+	images     []types.Image
+	pages      [][]types.Instance
+	terminated []string
+}
+
+func (f *fakeEC2) DescribeImages(ctx context.Context, in *ec2.DescribeImagesInput, _ ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: f.images}, nil
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, in *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	var page int
+	if in.NextToken != nil {
+		page, _ = strconv.Atoi(*in.NextToken)
+	}
+	out := &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{{Instances: f.pages[page]}},
+	}
+	if page+1 < len(f.pages) {
+		out.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return out, nil
+}
+
+func (f *fakeEC2) TerminateInstances(ctx context.Context, in *ec2.TerminateInstancesInput, _ ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	f.terminated = append(f.terminated, in.InstanceIds...)
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func TestLookupAWSImage(t *testing.T) {
+	spec := &meshv1.NodeGroupAWSConfig{Region: "us-east-1", Architecture: meshv1.AWSArchitectureARM}
+	instances := &fakeEC2{images: []types.Image{
+		{ImageId: aws.String("ami-old"), CreationDate: aws.String("2023-05-01T00:00:00.000Z")},
+		{ImageId: aws.String("ami-new"), CreationDate: aws.String("2023-09-01T00:00:00.000Z")},
+		{ImageId: aws.String("ami-mid"), CreationDate: aws.String("2023-07-01T00:00:00.000Z")},
+	}}
+	image, err := lookupAWSImage(context.Background(), instances, spec)
+	if err != nil {
+		t.Fatalf("lookup image: %v", err)
+	}
+	if image != "ami-new" {
+		t.Errorf("expected the newest image, got %s", image)
+	}
+
+	// Images set on the group are used as is
+	spec.AMI = "ami-custom"
+	image, err = lookupAWSImage(context.Background(), &fakeEC2{}, spec)
+	if err != nil {
+		t.Fatalf("lookup image: %v", err)
+	}
+	if image != "ami-custom" {
+		t.Errorf("expected the group's image, got %s", image)
+	}
+
+	spec.AMI = ""
+	if _, err := lookupAWSImage(context.Background(), &fakeEC2{}, spec); err == nil {
+		t.Error("expected an error when no image is found")
+	}
+}
+
+func TestDescribeAWSInstances(t *testing.T) {
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"}}
+	instance := func(id, name string) types.Instance {
+		return types.Instance{
+			InstanceId: aws.String(id),
+			Tags:       []types.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+		}
+	}
+	instances := &fakeEC2{pages: [][]types.Instance{
+		{instance("i-0", "group-0"), instance("i-1", "group-1")},
+		{instance("i-2", "group-2")},
+	}}
+	existing, err := describeAWSInstances(context.Background(), instances, group)
+	if err != nil {
+		t.Fatalf("describe instances: %v", err)
+	}
+	var names []string
+	for _, instance := range existing {
+		names = append(names, awsInstanceTag(instance, "Name"))
+	}
+	if want := []string{"group-0", "group-1", "group-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected instances %v from every page, got %v", want, names)
+	}
+
+	if err := terminateAWSInstances(context.Background(), instances, existing); err != nil {
+		t.Fatalf("terminate instances: %v", err)
+	}
+	if want := []string{"i-0", "i-1", "i-2"}; !reflect.DeepEqual(instances.terminated, want) {
+		t.Errorf("expected instances %v to be terminated, got %v", want, instances.terminated)
+	}
+}
+
+func TestAWSInstanceTags(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{AWS: &meshv1.NodeGroupAWSConfig{
+			Tags: map[string]string{"team": "mesh", "env": "prod"},
+		}},
+	}
+	tags := awsInstanceTags(mesh, group, "group-0", "checksum")
+	var keys []string
+	for _, tag := range tags {
+		keys = append(keys, aws.ToString(tag.Key))
+	}
+	want := []string{
+		"env", "team", "Name",
+		meshv1.MeshNameLabel, meshv1.MeshNamespaceLabel,
+		meshv1.NodeGroupNameLabel, meshv1.NodeGroupNamespaceLabel,
+		meshv1.ConfigChecksumAnnotation,
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected tag keys %v, got %v", want, keys)
+	}
+	instance := types.Instance{Tags: tags}
+	if got := awsInstanceTag(instance, meshv1.ConfigChecksumAnnotation); got != "checksum" {
+		t.Errorf("expected the config checksum tag, got %q", got)
+	}
+	if got := awsInstanceTag(instance, "missing"); got != "" {
+		t.Errorf("expected no value for a missing tag, got %q", got)
+	}
+}
+
+func TestAWSInstanceChecksum(t *testing.T) {
+	spec := &meshv1.NodeGroupAWSConfig{}
+	if got := awsInstanceChecksum(spec, "config"); got != "config" {
+		t.Errorf("expected the config checksum for default settings, got %q", got)
+	}
+	spec.AssociatePublicIPAddress = pointer(false)
+	if got := awsInstanceChecksum(spec, "config"); got == "config" {
+		t.Error("expected instances without a public address to be recreated")
+	}
+	if awsInstanceRunning(types.Instance{}) {
+		t.Error("expected an instance without a state not to be running")
+	}
+	if !awsInstanceRunning(types.Instance{State: &types.InstanceState{Name: types.InstanceStateNameRunning}}) {
+		t.Error("expected a running instance to be running")
+	}
+}
+
+func TestAWSNodeConfigOptions(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec:       meshv1.NodeGroupSpec{AWS: &meshv1.NodeGroupAWSConfig{}},
+	}
+	if opts := awsNodeConfigOptions(mesh, group, "join:8443"); !opts.AllowRemoteDetection {
+		t.Error("expected instances with a public address to detect it remotely")
+	}
+	// The address detected remotely would be the one of the NAT gateway
+	group.Spec.AWS.AssociatePublicIPAddress = pointer(false)
+	if opts := awsNodeConfigOptions(mesh, group, "join:8443"); opts.AllowRemoteDetection {
+		t.Error("expected instances without a public address not to detect it remotely")
+	}
+}
+
+func TestParseAWSCredentials(t *testing.T) {
+	creds, err := parseAWSCredentials([]byte(`{"AccessKeyId":"AKID","SecretAccessKey":"secret","SessionToken":"token"}`))
+	if err != nil {
+		t.Fatalf("parse credentials: %v", err)
+	}
+	got, err := creds.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("retrieve credentials: %v", err)
+	}
+	if got.AccessKeyID != "AKID" || got.SecretAccessKey != "secret" || got.SessionToken != "token" {
+		t.Errorf("unexpected credentials %+v", got)
+	}
+
+	for name, data := range map[string]string{
+		"InvalidJSON":     `{`,
+		"MissingKeyID":    `{"SecretAccessKey":"secret"}`,
+		"MissingSecret":   `{"AccessKeyId":"AKID"}`,
+		"EmptyObject":     `{}`,
+		"UnexpectedShape": `[]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseAWSCredentials([]byte(data)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
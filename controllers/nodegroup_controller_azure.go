@@ -0,0 +1,519 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// azureAdminUsername is the administrator account of Azure virtual machines.
+const azureAdminUsername = "webmesh"
+
+// azureVMAPI is the subset of the virtual machines API used to manage the
+// virtual machines of a node group.
+type azureVMAPI interface {
+	Get(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error)
+	NewListPager(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse]
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error)
+	BeginDelete(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientBeginDeleteOptions) (*runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse], error)
+}
+
+// azureProvider deploys node groups to Azure virtual machines.
+type azureProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r azureProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Bound the virtual machines being changed at once across all groups
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderAzure)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer release()
+
+	vms, err := r.getAzureClient(ctx, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	spec := group.Spec.Azure
+
+	// Build the nodeconfig
+	joinServer, err := getJoinServer(ctx, r.Client, mesh, group)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("load balancer not ready, requeueing")
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: time.Second * 3,
+			}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("get join server: %w", err)
+	}
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("merge node group config: %w", err)
+	}
+	users, err := getSSHUsers(ctx, r.Client, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	existing, err := listAzureVirtualMachines(ctx, vms, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	replicas := make(map[string]struct{}, group.Replicas())
+	for i := 0; i < int(group.Replicas()); i++ {
+		replicas[fmt.Sprintf("%s-%d", group.GetName(), i)] = struct{}{}
+	}
+	var running int
+	for _, vm := range existing {
+		if _, ok := replicas[deref(vm.Name)]; ok && azureVMRunning(vm) {
+			running++
+		}
+	}
+	rollout := newInstanceRollout(int(group.Replicas()), running)
+
+	// Loop over replicas and ensure each virtual machine
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := fmt.Sprintf("%s-%d", group.GetName(), i)
+
+		secret, err := r.getNodeCertificateSecret(ctx, mesh, group, i)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// Build the node and cloud configs
+		opts := azureNodeConfigOptions(mesh, group, joinServer)
+		opts.ClusterDomain = operatorconfig.FromContext(ctx).ClusterDomain
+		nodeconf, err := nodeconfig.New(opts)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build node config: %w", err)
+		}
+		env, err := getConfigSecrets(ctx, r.Client, group.GetNamespace(), nodeconf)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		cloudconf, err := cloudconfig.New(cloudconfig.Options{
+			Image:   group.Spec.Image,
+			Config:  nodeconf,
+			TLSCert: secret.Data[corev1.TLSCertKey],
+			TLSKey:  secret.Data[corev1.TLSPrivateKeyKey],
+			CA:      secret.Data[cmmeta.TLSCAKey],
+			// Forwarding is enabled on the network interface, add the
+			// rules needed to masquerade egress traffic.
+			DefaultGateway: groupcfg.AdvertisesDefaultGateway(),
+			Env:            env,
+			Users:          users,
+			OSFamily:       group.Spec.OSFamily(),
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build cloud config: %w", err)
+		}
+		checksum := azureVMChecksum(spec, cloudconf.Checksum())
+
+		// Ensure the virtual machine
+		vm, err := vms.Get(ctx, spec.ResourceGroup, name, nil)
+		if err == nil {
+			log.Info("Node virtual machine already exists", "name", name)
+			deployed := azureVMTag(&vm.VirtualMachine, azureTag(meshv1.ConfigChecksumAnnotation))
+			if resolveConfigChecksum(ctx, group, deployed, checksum) == deployed {
+				log.Info("Config checksum has not changed, skipping virtual machine", "name", name)
+				continue
+			}
+			if !rollout.Replace(azureVMRunning(&vm.VirtualMachine)) {
+				log.Info("Waiting for replaced virtual machines to run before replacing virtual machine", "name", name)
+				continue
+			}
+			// Delete the virtual machine and recreate it
+			log.Info("Config checksum has changed, deleting virtual machine", "name", name)
+			if simulate(ctx, "recreate virtual machine", "name", name) {
+				continue
+			}
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstance",
+				"Replacing virtual machine %s after its config changed", name)
+			if err := deleteAzureVirtualMachine(ctx, vms, spec.ResourceGroup, name); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else if !isAzureNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("lookup existing virtual machine: %w", err)
+		}
+		log.Info("Creating virtual machine", "name", name)
+		if simulate(ctx, "create virtual machine", "name", name) {
+			continue
+		}
+		sshKey, err := newAzureSSHKey()
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		poller, err := vms.BeginCreateOrUpdate(ctx, spec.ResourceGroup, name,
+			newAzureVirtualMachine(mesh, group, name, sshKey, cloudconf.Raw(), checksum), nil)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("create virtual machine: %w", err)
+		}
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return ctrl.Result{}, fmt.Errorf("create virtual machine: %w", err)
+		}
+	}
+
+	// Delete the virtual machines of removed replicas
+	for _, vm := range existing {
+		name := deref(vm.Name)
+		if _, ok := replicas[name]; ok {
+			continue
+		}
+		log.Info("Deleting virtual machine of removed replica", "name", name)
+		if err := deleteAzureVirtualMachine(ctx, vms, spec.ResourceGroup, name); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return rollout.Result(), nil
+}
+
+// Delete implements providers.Provider.
+func (r azureProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderAzure)
+	if err != nil {
+		return err
+	}
+	defer release()
+	vms, err := r.getAzureClient(ctx, group)
+	if err != nil {
+		return err
+	}
+	existing, err := listAzureVirtualMachines(ctx, vms, group)
+	if err != nil {
+		return err
+	}
+	for _, vm := range existing {
+		name := deref(vm.Name)
+		log.FromContext(ctx).Info("Deleting node group virtual machine", "name", name)
+		if err := deleteAzureVirtualMachine(ctx, vms, group.Spec.Azure.ResourceGroup, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listAzureVirtualMachines returns the virtual machines tagged for the group
+// in its resource group.
+func listAzureVirtualMachines(ctx context.Context, vms azureVMAPI, group *meshv1.NodeGroup) ([]*armcompute.VirtualMachine, error) {
+	var out []*armcompute.VirtualMachine
+	pages := vms.NewListPager(group.Spec.Azure.ResourceGroup, nil)
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list virtual machines: %w", err)
+		}
+		for _, vm := range page.Value {
+			if azureVMTag(vm, azureTag(meshv1.NodeGroupNameLabel)) == group.GetName() &&
+				azureVMTag(vm, azureTag(meshv1.NodeGroupNamespaceLabel)) == group.GetNamespace() {
+				out = append(out, vm)
+			}
+		}
+	}
+	return out, nil
+}
+
+// deleteAzureVirtualMachine deletes the virtual machine with the given name
+// along with its network interface, public IP address and disk, and waits for
+// it to be gone. Deleting a virtual machine that does not exist is not an
+// error.
+func deleteAzureVirtualMachine(ctx context.Context, vms azureVMAPI, resourceGroup, name string) error {
+	if simulate(ctx, "delete virtual machine", "name", name) {
+		return nil
+	}
+	poller, err := vms.BeginDelete(ctx, resourceGroup, name, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil && !isAzureNotFound(err) {
+		return fmt.Errorf("delete virtual machine: %w", err)
+	}
+	return nil
+}
+
+// isAzureNotFound returns true if the error is a response for a resource that
+// does not exist.
+func isAzureNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// azureVMRunning returns true if the virtual machine was provisioned.
+func azureVMRunning(vm *armcompute.VirtualMachine) bool {
+	return vm.Properties != nil && deref(vm.Properties.ProvisioningState) == "Succeeded"
+}
+
+// azureVMChecksum returns the checksum recorded on a virtual machine. It
+// covers the settings of the virtual machine that can only be changed by
+// recreating it on top of its cloud config, and is the checksum of the cloud
+// config as long as they are left at their defaults.
+func azureVMChecksum(spec *meshv1.NodeGroupAzureConfig, configChecksum string) string {
+	if spec.PublicIPAddress() {
+		return configChecksum
+	}
+	data := fmt.Sprintf("%s\npublic-ip=false", configChecksum)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
+// azureVMTag returns the value of the virtual machine's tag with the given
+// key.
+func azureVMTag(vm *armcompute.VirtualMachine, key string) string {
+	return deref(vm.Tags[key])
+}
+
+// azureTag returns the tag key for the given label. Azure tag keys may not
+// contain slashes.
+func azureTag(label string) string {
+	return strings.ReplaceAll(label, "/", "-")
+}
+
+// azureVMTags returns the tags of the group's virtual machines. The checksum
+// of their cloud config is recorded so changes recreate them.
+func azureVMTags(mesh *meshv1.Mesh, group *meshv1.NodeGroup, checksum string) map[string]*string {
+	tags := make(map[string]*string, len(group.Spec.Azure.Tags)+5)
+	for key, value := range group.Spec.Azure.Tags {
+		tags[key] = pointer(value)
+	}
+	tags[azureTag(meshv1.MeshNameLabel)] = pointer(mesh.GetName())
+	tags[azureTag(meshv1.MeshNamespaceLabel)] = pointer(mesh.GetNamespace())
+	tags[azureTag(meshv1.NodeGroupNameLabel)] = pointer(group.GetName())
+	tags[azureTag(meshv1.NodeGroupNamespaceLabel)] = pointer(group.GetNamespace())
+	tags[azureTag(meshv1.ConfigChecksumAnnotation)] = pointer(checksum)
+	return tags
+}
+
+// newAzureVirtualMachine returns the virtual machine of the replica with the
+// given name. Its network interface, public IP address, unless disabled, and
+// OS disk are created with it and deleted along with it. The interface
+// forwards traffic that is not addressed to the virtual machine, as needed to
+// route traffic.
+func newAzureVirtualMachine(mesh *meshv1.Mesh, group *meshv1.NodeGroup, name, sshKey string, customData []byte, checksum string) armcompute.VirtualMachine {
+	spec := group.Spec.Azure
+	var publicIP *armcompute.VirtualMachinePublicIPAddressConfiguration
+	if spec.PublicIPAddress() {
+		publicIP = &armcompute.VirtualMachinePublicIPAddressConfiguration{
+			Name: pointer(name + "-ip"),
+			SKU:  &armcompute.PublicIPAddressSKU{Name: pointer(armcompute.PublicIPAddressSKUNameStandard)},
+			Properties: &armcompute.VirtualMachinePublicIPAddressConfigurationProperties{
+				PublicIPAllocationMethod: pointer(armcompute.PublicIPAllocationMethodStatic),
+				DeleteOption:             pointer(armcompute.DeleteOptionsDelete),
+			},
+		}
+	}
+	return armcompute.VirtualMachine{
+		Location: pointer(spec.Location),
+		Tags:     azureVMTags(mesh, group, checksum),
+		Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile: &armcompute.HardwareProfile{
+				VMSize: pointer(armcompute.VirtualMachineSizeTypes(spec.VMSize)),
+			},
+			StorageProfile: &armcompute.StorageProfile{
+				ImageReference: &armcompute.ImageReference{
+					Publisher: pointer(spec.Image.Publisher),
+					Offer:     pointer(spec.Image.Offer),
+					SKU:       pointer(spec.Image.SKU),
+					Version:   pointer(spec.Image.Version),
+				},
+				OSDisk: &armcompute.OSDisk{
+					CreateOption: pointer(armcompute.DiskCreateOptionTypesFromImage),
+					DeleteOption: pointer(armcompute.DiskDeleteOptionTypesDelete),
+				},
+			},
+			OSProfile: &armcompute.OSProfile{
+				ComputerName:  pointer(name),
+				AdminUsername: pointer(azureAdminUsername),
+				CustomData:    pointer(base64.StdEncoding.EncodeToString(customData)),
+				LinuxConfiguration: &armcompute.LinuxConfiguration{
+					DisablePasswordAuthentication: pointer(true),
+					SSH: &armcompute.SSHConfiguration{
+						PublicKeys: []*armcompute.SSHPublicKey{{
+							Path:    pointer(fmt.Sprintf("/home/%s/.ssh/authorized_keys", azureAdminUsername)),
+							KeyData: pointer(sshKey),
+						}},
+					},
+				},
+			},
+			NetworkProfile: &armcompute.NetworkProfile{
+				NetworkAPIVersion: pointer(armcompute.NetworkAPIVersionTwoThousandTwenty1101),
+				NetworkInterfaceConfigurations: []*armcompute.VirtualMachineNetworkInterfaceConfiguration{{
+					Name: pointer(name + "-nic"),
+					Properties: &armcompute.VirtualMachineNetworkInterfaceConfigurationProperties{
+						Primary:            pointer(true),
+						EnableIPForwarding: pointer(true),
+						DeleteOption:       pointer(armcompute.DeleteOptionsDelete),
+						IPConfigurations: []*armcompute.VirtualMachineNetworkInterfaceIPConfiguration{{
+							Name: pointer(name + "-ipconfig"),
+							Properties: &armcompute.VirtualMachineNetworkInterfaceIPConfigurationProperties{
+								Primary:                      pointer(true),
+								Subnet:                       &armcompute.SubResource{ID: pointer(spec.SubnetID())},
+								PublicIPAddressConfiguration: publicIP,
+							},
+						}},
+					},
+				}},
+			},
+		},
+	}
+}
+
+// newAzureSSHKey returns the authorized key of a key pair that is thrown away.
+// Azure requires a credential for the administrator account of Linux virtual
+// machines, nodes are not meant to be logged in to.
+func newAzureSSHKey() (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return "", fmt.Errorf("generate ssh key: %w", err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("encode ssh key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))), nil
+}
+
+// azureNodeConfigOptions returns the node config options for the replicas of
+// an Azure node group. The public address of a virtual machine is not on its
+// interface, so it is detected remotely when it has one.
+func azureNodeConfigOptions(mesh *meshv1.Mesh, group *meshv1.NodeGroup, joinServer string) nodeconfig.Options {
+	return nodeconfig.Options{
+		Mesh:                 mesh,
+		Group:                group,
+		JoinServer:           joinServer,
+		IsPersistent:         true,
+		CertDir:              meshv1.DefaultTLSDirectory,
+		DetectEndpoints:      true,
+		AllowRemoteDetection: group.Spec.Azure.PublicIPAddress(),
+	}
+}
+
+// getAzureClient returns a client for the virtual machines of the group's
+// subscription using the service principal in the group's secret, or the
+// identity of the operator if it has none.
+func (r *NodeGroupReconciler) getAzureClient(ctx context.Context, group *meshv1.NodeGroup) (azureVMAPI, error) {
+	spec := group.Spec.Azure
+	if spec.Credentials == nil {
+		creds, err := r.getAzureIdentity(spec.ManagedIdentityClientID)
+		if err != nil {
+			return nil, err
+		}
+		return armcompute.NewVirtualMachinesClient(spec.SubscriptionID, creds, nil)
+	}
+	var secret corev1.Secret
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      spec.Credentials.Name,
+		Namespace: group.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("get azure credentials secret: %w", err)
+	}
+	data, ok := secret.Data[spec.Credentials.Key]
+	if !ok {
+		return nil, fmt.Errorf("no key %s in secret %s/%s", spec.Credentials.Key, group.GetNamespace(), spec.Credentials.Name)
+	}
+	creds, err := parseAzureServicePrincipal(data)
+	if err != nil {
+		return nil, err
+	}
+	return armcompute.NewVirtualMachinesClient(spec.SubscriptionID, creds, nil)
+}
+
+// parseAzureServicePrincipal parses service principal credentials in the JSON
+// format printed by 'az ad sp create-for-rbac': an object with an appId, a
+// password and a tenant.
+func parseAzureServicePrincipal(data []byte) (azcore.TokenCredential, error) {
+	var sp struct {
+		AppID    string `json:"appId"`
+		Password string `json:"password"`
+		Tenant   string `json:"tenant"`
+	}
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, fmt.Errorf("parse service principal: %w", err)
+	}
+	if sp.AppID == "" || sp.Password == "" || sp.Tenant == "" {
+		return nil, errors.New("service principal must have an appId, password and tenant")
+	}
+	creds, err := azidentity.NewClientSecretCredential(sp.Tenant, sp.AppID, sp.Password, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create service principal credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// getAzureIdentity returns the identity of the operator. Workload identity is
+// used when configured, otherwise the managed identity with the given client
+// ID. Identities are shared by all groups so their tokens are cached.
+func (r *NodeGroupReconciler) getAzureIdentity(clientID string) (azcore.TokenCredential, error) {
+	r.azureIdentityMu.Lock()
+	defer r.azureIdentityMu.Unlock()
+	if r.azureIdentities == nil {
+		r.azureIdentities = make(map[string]azcore.TokenCredential)
+	}
+	// Workload identity fails to configure without the environment set by
+	// its webhook
+	workload, err := azidentity.NewWorkloadIdentityCredential(nil)
+	key := "managed:" + clientID
+	if err == nil {
+		key = "workload"
+	}
+	if creds, ok := r.azureIdentities[key]; ok {
+		return creds, nil
+	}
+	var creds azcore.TokenCredential = workload
+	if err != nil {
+		var opts azidentity.ManagedIdentityCredentialOptions
+		if clientID != "" {
+			opts.ID = azidentity.ClientID(clientID)
+		}
+		creds, err = azidentity.NewManagedIdentityCredential(&opts)
+		if err != nil {
+			return nil, fmt.Errorf("create managed identity credentials: %w", err)
+		}
+	}
+	r.azureIdentities[key] = creds
+	return creds, nil
+}
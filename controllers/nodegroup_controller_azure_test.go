@@ -0,0 +1,206 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeAzureVMs serves pages of virtual machines.
+type fakeAzureVMs struct {
+	azureVMAPI
+	pages [][]*armcompute.VirtualMachine
+}
+
+func (f *fakeAzureVMs) NewListPager(string, *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse] {
+	page := 0
+	return runtime.NewPager(runtime.PagingHandler[armcompute.VirtualMachinesClientListResponse]{
+		More: func(armcompute.VirtualMachinesClientListResponse) bool {
+			return page < len(f.pages)
+		},
+		Fetcher: func(context.Context, *armcompute.VirtualMachinesClientListResponse) (armcompute.VirtualMachinesClientListResponse, error) {
+			page++
+			return armcompute.VirtualMachinesClientListResponse{
+				VirtualMachineListResult: armcompute.VirtualMachineListResult{Value: f.pages[page-1]},
+			}, nil
+		},
+	})
+}
+
+func TestNewAzureVirtualMachine(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{Azure: &meshv1.NodeGroupAzureConfig{
+			SubscriptionID: "sub",
+			ResourceGroup:  "rg",
+			Location:       "eastus",
+			VirtualNetwork: "vnet",
+			Subnet:         "subnet",
+			VMSize:         "Standard_B1s",
+			Image:          &meshv1.AzureImageReference{Publisher: "Canonical", Offer: "jammy", SKU: "22_04-lts", Version: "latest"},
+			Tags:           map[string]string{"team": "mesh"},
+		}},
+	}
+	vm := newAzureVirtualMachine(mesh, group, "group-0", "ssh-rsa AAAA", []byte("#cloud-config"), "abc")
+
+	props := vm.Properties
+	if got := deref(props.OSProfile.CustomData); got != "I2Nsb3VkLWNvbmZpZw==" {
+		t.Errorf("expected encoded custom data, got %q", got)
+	}
+	nic := props.NetworkProfile.NetworkInterfaceConfigurations[0].Properties
+	if !deref(nic.EnableIPForwarding) {
+		t.Error("expected IP forwarding to be enabled")
+	}
+	if got := deref(nic.IPConfigurations[0].Properties.Subnet.ID); got != group.Spec.Azure.SubnetID() {
+		t.Errorf("expected subnet %s, got %s", group.Spec.Azure.SubnetID(), got)
+	}
+	if deref(nic.DeleteOption) != armcompute.DeleteOptionsDelete || deref(props.StorageProfile.OSDisk.DeleteOption) != armcompute.DiskDeleteOptionTypesDelete {
+		t.Error("expected the network interface and disk to be deleted with the virtual machine")
+	}
+	for key, want := range map[string]string{
+		azureTag(meshv1.NodeGroupNameLabel):       "group",
+		azureTag(meshv1.ConfigChecksumAnnotation): "abc",
+		"team": "mesh",
+	} {
+		if got := azureVMTag(&vm, key); got != want {
+			t.Errorf("expected tag %s to be %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestAzureVirtualMachinePublicIPAddress(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{Azure: &meshv1.NodeGroupAzureConfig{
+			Image: &meshv1.DefaultAzureImage,
+		}},
+	}
+	ipConfig := func() *armcompute.VirtualMachineNetworkInterfaceIPConfigurationProperties {
+		vm := newAzureVirtualMachine(mesh, group, "group-0", "ssh-rsa AAAA", nil, "abc")
+		return vm.Properties.NetworkProfile.NetworkInterfaceConfigurations[0].Properties.IPConfigurations[0].Properties
+	}
+	if ipConfig().PublicIPAddressConfiguration == nil {
+		t.Error("expected a public IP address by default")
+	}
+	if !azureNodeConfigOptions(mesh, group, "join:8443").AllowRemoteDetection {
+		t.Error("expected virtual machines with a public address to detect it remotely")
+	}
+	if got := azureVMChecksum(group.Spec.Azure, "config"); got != "config" {
+		t.Errorf("expected the config checksum for default settings, got %q", got)
+	}
+
+	group.Spec.Azure.EnablePublicIPAddress = pointer(false)
+	if ipConfig().PublicIPAddressConfiguration != nil {
+		t.Error("expected no public IP address when disabled")
+	}
+	if azureNodeConfigOptions(mesh, group, "join:8443").AllowRemoteDetection {
+		t.Error("expected virtual machines without a public address not to detect it remotely")
+	}
+	if got := azureVMChecksum(group.Spec.Azure, "config"); got == "config" {
+		t.Error("expected virtual machines without a public address to be recreated")
+	}
+}
+
+func TestAzureVMRunning(t *testing.T) {
+	if azureVMRunning(&armcompute.VirtualMachine{}) {
+		t.Error("expected a virtual machine without properties not to be running")
+	}
+	vm := &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{ProvisioningState: pointer("Creating")}}
+	if azureVMRunning(vm) {
+		t.Error("expected a virtual machine being created not to be running")
+	}
+	vm.Properties.ProvisioningState = pointer("Succeeded")
+	if !azureVMRunning(vm) {
+		t.Error("expected a provisioned virtual machine to be running")
+	}
+}
+
+func TestListAzureVirtualMachines(t *testing.T) {
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec:       meshv1.NodeGroupSpec{Azure: &meshv1.NodeGroupAzureConfig{ResourceGroup: "rg"}},
+	}
+	vm := func(name, groupName, namespace string) *armcompute.VirtualMachine {
+		return &armcompute.VirtualMachine{
+			Name: pointer(name),
+			Tags: map[string]*string{
+				azureTag(meshv1.NodeGroupNameLabel):      pointer(groupName),
+				azureTag(meshv1.NodeGroupNamespaceLabel): pointer(namespace),
+			},
+		}
+	}
+	vms := &fakeAzureVMs{pages: [][]*armcompute.VirtualMachine{
+		{vm("group-0", "group", "default"), vm("other-0", "other", "default")},
+		{vm("group-1", "group", "default"), vm("group-0", "group", "other"), {Name: pointer("untagged")}},
+	}}
+	existing, err := listAzureVirtualMachines(context.Background(), vms, group)
+	if err != nil {
+		t.Fatalf("list virtual machines: %v", err)
+	}
+	var names []string
+	for _, vm := range existing {
+		names = append(names, deref(vm.Name))
+	}
+	if want := []string{"group-0", "group-1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected the group's virtual machines %v from every page, got %v", want, names)
+	}
+}
+
+func TestIsAzureNotFound(t *testing.T) {
+	notFound := &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}
+	if !isAzureNotFound(fmt.Errorf("get virtual machine: %w", notFound)) {
+		t.Error("expected a wrapped not found response to be detected")
+	}
+	if isAzureNotFound(&azcore.ResponseError{StatusCode: http.StatusForbidden}) {
+		t.Error("expected a forbidden response not to be detected")
+	}
+	if isAzureNotFound(errors.New("connection refused")) {
+		t.Error("expected other errors not to be detected")
+	}
+}
+
+func TestParseAzureServicePrincipal(t *testing.T) {
+	if _, err := parseAzureServicePrincipal([]byte(`{"appId":"app","password":"secret","tenant":"tenant"}`)); err != nil {
+		t.Fatalf("parse service principal: %v", err)
+	}
+	for name, data := range map[string]string{
+		"InvalidJSON":     `{`,
+		"MissingAppID":    `{"password":"secret","tenant":"tenant"}`,
+		"MissingPassword": `{"appId":"app","tenant":"tenant"}`,
+		"MissingTenant":   `{"appId":"app","password":"secret"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseAzureServicePrincipal([]byte(data)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
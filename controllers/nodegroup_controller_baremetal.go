@@ -0,0 +1,335 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/baremetal"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+const (
+	// bareMetalKnownHostsKey is the key of the known_hosts entries in the
+	// credentials secret of a bare metal group.
+	bareMetalKnownHostsKey = "known_hosts"
+	// bareMetalProvisionTimeout bounds provisioning a single host, including
+	// pulling the node image when the unit is restarted.
+	bareMetalProvisionTimeout = 5 * time.Minute
+	// bareMetalMinBackoff is how long a host is left alone after its first
+	// failed attempt. The backoff doubles with every further failure, up to
+	// the maximum of the operator settings.
+	bareMetalMinBackoff = 15 * time.Second
+)
+
+// bareMetalProvider deploys node groups to bare metal hosts provisioned over SSH.
+type bareMetalProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r bareMetalProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Bound the hosts being changed at once across all groups
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderBareMetal)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer release()
+
+	sshConfig, err := r.getBareMetalClientConfig(ctx, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Build the nodeconfig
+	joinServer, err := getJoinServer(ctx, r.Client, mesh, group)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("load balancer not ready, requeueing")
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: time.Second * 3,
+			}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("get join server: %w", err)
+	}
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("merge node group config: %w", err)
+	}
+
+	previous := make(map[string]meshv1.NodeGroupHostStatus, len(group.Status.Hosts))
+	for _, host := range group.Status.Hosts {
+		previous[host.Address] = host
+	}
+	hosts := make([]meshv1.NodeGroupHostStatus, 0, len(group.Spec.BareMetal.Hosts))
+	maxBackoff := operatorconfig.FromContext(ctx).MaxRetryBackoff
+	var requeueAfter time.Duration
+	retryIn := func(d time.Duration) {
+		if d > 0 && (requeueAfter == 0 || d < requeueAfter) {
+			requeueAfter = d
+		}
+	}
+
+	// Loop over the hosts and ensure each node
+	for i, addr := range group.Spec.BareMetal.Hosts {
+		status := previous[addr]
+		delete(previous, addr)
+		status.Address = addr
+
+		secret, err := r.getNodeCertificateSecret(ctx, mesh, group, i)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// Build the node and cloud configs
+		nodeconf, err := nodeconfig.New(nodeconfig.Options{
+			Mesh:            mesh,
+			Group:           group,
+			JoinServer:      joinServer,
+			IsPersistent:    true,
+			CertDir:         meshv1.DefaultTLSDirectory,
+			DetectEndpoints: true,
+			DetectIPv6:      true,
+			ClusterDomain:   operatorconfig.FromContext(ctx).ClusterDomain,
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build node config: %w", err)
+		}
+		env, err := getConfigSecrets(ctx, r.Client, group.GetNamespace(), nodeconf)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		cloudconf, err := cloudconfig.New(cloudconfig.Options{
+			Image:          group.Spec.Image,
+			Config:         nodeconf,
+			TLSCert:        secret.Data[corev1.TLSCertKey],
+			TLSKey:         secret.Data[corev1.TLSPrivateKeyKey],
+			CA:             secret.Data[cmmeta.TLSCAKey],
+			DefaultGateway: groupcfg.AdvertisesDefaultGateway(),
+			Env:            env,
+			// The firewall of the host is not ours to flush
+			KeepFirewall: true,
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build cloud config: %w", err)
+		}
+		checksum := cloudconf.Checksum()
+
+		// Ensure the node
+		if status.LastError == "" && resolveConfigChecksum(ctx, group, status.ConfigChecksum, checksum) == status.ConfigChecksum {
+			log.Info("Config checksum has not changed, skipping host", "host", addr)
+			hosts = append(hosts, status)
+			continue
+		}
+		if wait := bareMetalRetryIn(&status, time.Now(), maxBackoff); wait > 0 {
+			log.Info("Waiting to retry failed host", "host", addr, "failures", status.Failures, "retryIn", wait)
+			retryIn(wait)
+			hosts = append(hosts, status)
+			continue
+		}
+		log.Info("Provisioning host", "host", addr)
+		if simulate(ctx, "provision host", "host", addr) {
+			hosts = append(hosts, status)
+			continue
+		}
+		err = withBareMetalHost(ctx, addr, sshConfig, func(ctx context.Context, host *baremetal.Host) error {
+			return baremetal.Provision(ctx, host, cloudconf)
+		})
+		status.LastAttemptTime = &metav1.Time{Time: time.Now()}
+		if err != nil {
+			log.Error(err, "unable to provision host", "host", addr)
+			r.Recorder.Eventf(group, corev1.EventTypeWarning, "HostFailed", "Provisioning host %s failed: %v", addr, err)
+			status.Failures++
+			status.LastError = err.Error()
+			retryIn(bareMetalBackoff(status.Failures, maxBackoff))
+		} else {
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "HostProvisioned", "Provisioned host %s", addr)
+			status.ConfigChecksum = checksum
+			status.Failures = 0
+			status.LastError = ""
+		}
+		hosts = append(hosts, status)
+	}
+
+	// Stop the nodes on removed hosts
+	removed := make([]string, 0, len(previous))
+	for addr := range previous {
+		removed = append(removed, addr)
+	}
+	sort.Strings(removed)
+	for _, addr := range removed {
+		status := previous[addr]
+		if wait := bareMetalRetryIn(&status, time.Now(), maxBackoff); wait > 0 {
+			retryIn(wait)
+			hosts = append(hosts, status)
+			continue
+		}
+		log.Info("Stopping node on removed host", "host", addr)
+		if simulate(ctx, "deprovision host", "host", addr) {
+			hosts = append(hosts, status)
+			continue
+		}
+		err := withBareMetalHost(ctx, addr, sshConfig, baremetal.Deprovision)
+		if err == nil {
+			continue
+		}
+		log.Error(err, "unable to stop node on removed host", "host", addr)
+		r.Recorder.Eventf(group, corev1.EventTypeWarning, "HostFailed", "Stopping the node on removed host %s failed: %v", addr, err)
+		status.LastAttemptTime = &metav1.Time{Time: time.Now()}
+		status.Failures++
+		status.LastError = err.Error()
+		retryIn(bareMetalBackoff(status.Failures, maxBackoff))
+		hosts = append(hosts, status)
+	}
+
+	if len(hosts) == 0 {
+		hosts = nil
+	}
+	if !equality.Semantic.DeepEqual(group.Status.Hosts, hosts) {
+		group.Status.Hosts = hosts
+		if err := r.Status().Update(ctx, group); err != nil {
+			return ctrl.Result{}, fmt.Errorf("update host status: %w", err)
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// Delete implements providers.Provider.
+func (r bareMetalProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderBareMetal)
+	if err != nil {
+		return err
+	}
+	defer release()
+	sshConfig, err := r.getBareMetalClientConfig(ctx, group)
+	if err != nil {
+		return err
+	}
+	// Hosts removed from the spec may not have been stopped yet
+	addrs := append([]string(nil), group.Spec.BareMetal.Hosts...)
+	for _, host := range group.Status.Hosts {
+		if !slices.Contains(addrs, host.Address) {
+			addrs = append(addrs, host.Address)
+		}
+	}
+	log.FromContext(ctx).Info("Stopping node group nodes", "count", len(addrs))
+	var errs []error
+	for _, addr := range addrs {
+		if simulate(ctx, "deprovision host", "host", addr) {
+			continue
+		}
+		if err := withBareMetalHost(ctx, addr, sshConfig, baremetal.Deprovision); err != nil {
+			errs = append(errs, fmt.Errorf("host %s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withBareMetalHost connects to the host at the given address and runs fn with
+// the connection.
+func withBareMetalHost(ctx context.Context, addr string, config *ssh.ClientConfig, fn func(context.Context, *baremetal.Host) error) error {
+	ctx, cancel := context.WithTimeout(ctx, bareMetalProvisionTimeout)
+	defer cancel()
+	host, err := baremetal.Dial(ctx, meshv1.BareMetalHostAddress(addr), config)
+	if err != nil {
+		return err
+	}
+	defer host.Close()
+	return fn(ctx, host)
+}
+
+// bareMetalBackoff returns how long a host is left alone after the given
+// number of consecutive failures.
+func bareMetalBackoff(failures int32, max time.Duration) time.Duration {
+	backoff := bareMetalMinBackoff
+	for i := int32(1); i < failures; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// bareMetalRetryIn returns how long until a failed host may be retried. Zero
+// is returned for hosts that may be retried now.
+func bareMetalRetryIn(status *meshv1.NodeGroupHostStatus, now time.Time, max time.Duration) time.Duration {
+	if status.Failures == 0 || status.LastAttemptTime == nil {
+		return 0
+	}
+	wait := status.LastAttemptTime.Add(bareMetalBackoff(status.Failures, max)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// getBareMetalClientConfig returns the SSH client config for the hosts of the
+// group using the credentials in the group's secret.
+func (r *NodeGroupReconciler) getBareMetalClientConfig(ctx context.Context, group *meshv1.NodeGroup) (*ssh.ClientConfig, error) {
+	spec := group.Spec.BareMetal
+	var secret corev1.Secret
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      spec.CredentialsSecret,
+		Namespace: group.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("get bare metal credentials secret: %w", err)
+	}
+	key, ok := secret.Data[corev1.SSHAuthPrivateKey]
+	if !ok {
+		return nil, fmt.Errorf("no key %s in secret %s/%s", corev1.SSHAuthPrivateKey, group.GetNamespace(), spec.CredentialsSecret)
+	}
+	creds := baremetal.Credentials{
+		User:                            spec.User,
+		PrivateKey:                      key,
+		KnownHosts:                      secret.Data[bareMetalKnownHostsKey],
+		InsecureSkipHostKeyVerification: spec.InsecureSkipHostKeyVerification,
+	}
+	if strings.TrimSpace(string(creds.KnownHosts)) == "" {
+		creds.KnownHosts = nil
+	}
+	config, err := creds.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("bare metal credentials secret %s/%s: %w", group.GetNamespace(), spec.CredentialsSecret, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+func TestBareMetalBackoff(t *testing.T) {
+	tc := []struct {
+		failures int32
+		want     time.Duration
+	}{
+		{failures: 1, want: 15 * time.Second},
+		{failures: 2, want: 30 * time.Second},
+		{failures: 4, want: 2 * time.Minute},
+		{failures: 6, want: 8 * time.Minute},
+		{failures: 7, want: operatorconfig.DefaultMaxRetryBackoff},
+		{failures: 100, want: operatorconfig.DefaultMaxRetryBackoff},
+	}
+	for _, c := range tc {
+		if got := bareMetalBackoff(c.failures, operatorconfig.DefaultMaxRetryBackoff); got != c.want {
+			t.Errorf("bareMetalBackoff(%d): expected %s, got %s", c.failures, c.want, got)
+		}
+	}
+
+	if got := bareMetalBackoff(3, 45*time.Second); got != 45*time.Second {
+		t.Errorf("expected the backoff to be capped at 45s, got %s", got)
+	}
+
+	now := time.Now()
+	status := meshv1.NodeGroupHostStatus{
+		Address:         "10.0.0.1",
+		Failures:        2,
+		LastAttemptTime: &metav1.Time{Time: now.Add(-10 * time.Second)},
+	}
+	if got := bareMetalRetryIn(&status, now, operatorconfig.DefaultMaxRetryBackoff); got != 20*time.Second {
+		t.Errorf("expected the host to be retried in 20s, got %s", got)
+	}
+	status.LastAttemptTime = &metav1.Time{Time: now.Add(-time.Minute)}
+	if got := bareMetalRetryIn(&status, now, operatorconfig.DefaultMaxRetryBackoff); got != 0 {
+		t.Errorf("expected the host to be retried now, got %s", got)
+	}
+	status.Failures = 0
+	if got := bareMetalRetryIn(&status, now, operatorconfig.DefaultMaxRetryBackoff); got != 0 {
+		t.Errorf("expected hosts without failures to be retried now, got %s", got)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// bootstrapQuorumRetryInterval is how often a group waiting for the bootstrap
+// groups of its mesh is requeued, in case no watched object changes.
+const bootstrapQuorumRetryInterval = 30 * time.Second
+
+// waitForBootstrapQuorum returns true while the group can only join the mesh
+// through the headless services of bootstrap groups that do not yet have a
+// quorum of ready nodes, or while no join server exists yet. Joining before
+// then only leaves the group crash-looping. Candidates are not probed here;
+// that only happens when the node configuration is rendered. Errors reading
+// the candidates are returned.
+func (r *NodeGroupReconciler) waitForBootstrapQuorum(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (bool, error) {
+	if val, ok := group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation]; ok && val == "true" {
+		return false, nil
+	}
+	log := log.FromContext(ctx)
+	candidates, err := getJoinServerCandidates(ctx, r.Client, mesh, group)
+	if err != nil {
+		if !joinServerPending(err) {
+			return false, fmt.Errorf("get join server candidates: %w", err)
+		}
+		log.Info("Waiting for a join server", "error", err.Error())
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "WaitingForBootstrap",
+			"Waiting for a join server before joining: %v", err)
+		return true, nil
+	}
+	var bootstrapGroups meshv1.NodeGroupList
+	err = r.List(ctx, &bootstrapGroups,
+		client.InNamespace(mesh.GetNamespace()),
+		client.MatchingLabels(meshv1.MeshBootstrapGroupSelector(mesh)))
+	if err != nil {
+		return false, fmt.Errorf("list bootstrap node groups: %w", err)
+	}
+	var waitingOn *meshv1.NodeGroup
+	var ready, quorum int32
+Candidates:
+	for _, candidate := range candidates {
+		joinHost, _, err := net.SplitHostPort(candidate)
+		if err != nil {
+			return false, fmt.Errorf("parse join server: %w", err)
+		}
+		for i := range bootstrapGroups.Items {
+			bootstrapGroup := &bootstrapGroups.Items[i]
+			if bootstrapGroup.GetName() == group.GetName() ||
+				meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, bootstrapGroup, operatorconfig.FromContext(ctx).ClusterDomain) != joinHost {
+				continue
+			}
+			var sts appsv1.StatefulSet
+			err := r.Get(ctx, client.ObjectKey{
+				Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, bootstrapGroup),
+				Namespace: bootstrapGroup.GetNamespace(),
+			}, &sts)
+			if client.IgnoreNotFound(err) != nil {
+				return false, fmt.Errorf("get bootstrap statefulset: %w", err)
+			}
+			if sts.Status.ReadyReplicas < bootstrapGroup.Replicas()/2+1 {
+				if waitingOn == nil {
+					waitingOn, ready, quorum = bootstrapGroup, sts.Status.ReadyReplicas, bootstrapGroup.Replicas()/2+1
+				}
+				continue Candidates
+			}
+		}
+		// The candidate is a load balancer or a bootstrap group with quorum
+		return false, nil
+	}
+	log.Info("Waiting for bootstrap quorum",
+		"bootstrapGroup", waitingOn.GetName(), "ready", ready, "quorum", quorum)
+	r.Recorder.Eventf(group, corev1.EventTypeNormal, "WaitingForBootstrap",
+		"Waiting for %d of %d nodes in bootstrap group %s to be ready before joining",
+		quorum, waitingOn.Replicas(), waitingOn.GetName())
+	return true, nil
+}
+
+// bootstrapStatefulSetToNodeGroups maps a bootstrap group's StatefulSet to the
+// non-bootstrap node groups of its mesh.
+func (r *NodeGroupReconciler) bootstrapStatefulSetToNodeGroups(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	if bootstrap, _ := meshv1.LabelValue(labels, meshv1.BootstrapNodeGroupLabel); bootstrap != "true" {
+		return nil
+	}
+	meshName, _ := meshv1.LabelValue(labels, meshv1.MeshNameLabel)
+	meshNamespace, _ := meshv1.LabelValue(labels, meshv1.MeshNamespaceLabel)
+	meshKey := types.NamespacedName{
+		Name:      meshName,
+		Namespace: meshNamespace,
+	}
+	var groups meshv1.NodeGroupList
+	if err := r.List(ctx, &groups); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list node groups")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, group := range groups.Items {
+		if group.MeshKey() != meshKey {
+			continue
+		}
+		if val, ok := group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation]; ok && val == "true" {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&group)})
+	}
+	return requests
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestWaitForBootstrapQuorum(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	replicas := int32(3)
+	bootstrap := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap",
+			Namespace: "default",
+			Labels:    meshv1.MeshBootstrapGroupSelector(mesh),
+		},
+		Spec: meshv1.NodeGroupSpec{Replicas: &replicas},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshNodeGroupStatefulSetName(mesh, bootstrap), Namespace: "default"},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1},
+	}
+	// A second bootstrap group falls back to the headless service of the first
+	joining := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-extra",
+			Namespace: "default",
+			Labels:    meshv1.MeshBootstrapGroupSelector(mesh),
+		},
+	}
+	expectWait := func(t *testing.T, r *NodeGroupReconciler, recorder *record.FakeRecorder, group *meshv1.NodeGroup, want bool) {
+		t.Helper()
+		wait, err := r.waitForBootstrapQuorum(context.Background(), mesh, group)
+		if err != nil {
+			t.Fatalf("wait for bootstrap quorum: %v", err)
+		}
+		if wait != want {
+			t.Fatalf("expected wait to be %v, got %v", want, wait)
+		}
+		select {
+		case event := <-recorder.Events:
+			if !want {
+				t.Errorf("unexpected event %q", event)
+			} else if !strings.Contains(event, "WaitingForBootstrap") {
+				t.Errorf("unexpected event %q", event)
+			}
+		default:
+			if want {
+				t.Error("expected an event while waiting")
+			}
+		}
+	}
+
+	t.Run("NoJoinServer", func(t *testing.T) {
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(joining).Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+		expectWait(t, r, recorder, joining, true)
+	})
+
+	t.Run("BootstrapQuorum", func(t *testing.T) {
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(bootstrap.DeepCopy(), joining.DeepCopy(), sts.DeepCopy()).
+			WithStatusSubresource(&appsv1.StatefulSet{}).
+			Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+		expectWait(t, r, recorder, joining, true)
+
+		var got appsv1.StatefulSet
+		if err := cli.Get(context.Background(), client.ObjectKeyFromObject(sts), &got); err != nil {
+			t.Fatalf("get statefulset: %v", err)
+		}
+		got.Status.ReadyReplicas = 2
+		if err := cli.Status().Update(context.Background(), &got); err != nil {
+			t.Fatalf("update statefulset: %v", err)
+		}
+		expectWait(t, r, recorder, joining, false)
+	})
+
+	t.Run("ListError", func(t *testing.T) {
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(joining).
+			WithInterceptorFuncs(interceptor.Funcs{
+				List: func(context.Context, client.WithWatch, client.ObjectList, ...client.ListOption) error {
+					return errors.New("connection refused")
+				},
+			}).
+			Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+		// Failing to read the candidates is not mistaken for a missing quorum
+		wait, err := r.waitForBootstrapQuorum(context.Background(), mesh, joining)
+		if err == nil {
+			t.Fatal("expected the list error to be returned")
+		}
+		if wait {
+			t.Error("expected not to wait on an error")
+		}
+		if len(recorder.Events) != 0 {
+			t.Errorf("unexpected event %q", <-recorder.Events)
+		}
+	})
+
+	t.Run("LoadBalancer", func(t *testing.T) {
+		exposed := bootstrap.DeepCopy()
+		exposed.Spec.Cluster = &meshv1.NodeGroupClusterConfig{
+			Service: &meshv1.NodeGroupLBConfig{GRPCPort: meshv1.DefaultGRPCPort},
+		}
+		lb := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshNodeGroupLBName(mesh, exposed), Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		}
+		workers := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: "default", UID: "workers"},
+		}
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(exposed, lb, workers).
+			WithStatusSubresource(&corev1.Service{}, &meshv1.NodeGroup{}).
+			Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+
+		// The group waits until the load balancer has an address
+		expectWait(t, r, recorder, workers, true)
+
+		lb.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		if err := cli.Status().Update(context.Background(), lb); err != nil {
+			t.Fatalf("update load balancer: %v", err)
+		}
+		expectWait(t, r, recorder, workers, false)
+
+		// Candidates are not probed, so nothing is recorded in the status
+		var got meshv1.NodeGroup
+		if err := cli.Get(context.Background(), client.ObjectKeyFromObject(workers), &got); err != nil {
+			t.Fatalf("get node group: %v", err)
+		}
+		if got.Status.JoinServer != "" {
+			t.Errorf("expected no join server in the status, got %q", got.Status.JoinServer)
+		}
+	})
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+const (
+	// certExpiryWarningWindow is how close to expiry a node certificate must be
+	// before a warning event is raised for its group.
+	certExpiryWarningWindow = 7 * 24 * time.Hour
+	// certExpiryWarningInterval is how often an expiring certificate is
+	// re-checked once it is inside the warning window.
+	certExpiryWarningInterval = 12 * time.Hour
+)
+
+var nodeCertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "webmesh_node_certificate_expiry_timestamp_seconds",
+	Help: "The time at which a node certificate expires in seconds since the epoch.",
+}, []string{"namespace", "nodegroup", "certificate"})
+
+func init() {
+	metrics.Registry.MustRegister(nodeCertificateExpiry)
+}
+
+// reconcileCertificateExpiry inspects the issued certificate of every node in the
+// group. It records the expiry of each in the returned statuses and the expiry
+// metric, raises a warning event for any certificate close to expiring, and returns
+// how long until the certificates should be checked again.
+//
+// Nodes delivered outside the cluster receive their certificates in the cloud config,
+// whose checksum changes when a certificate is renewed. Requeueing ahead of the warning
+// window ensures a renewed certificate is picked up and the instance refreshed.
+func (r *NodeGroupReconciler) reconcileCertificateExpiry(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) ([]meshv1.NodeCertificateStatus, time.Duration, error) {
+	log := log.FromContext(ctx)
+	nodeCertificateExpiry.DeletePartialMatch(prometheus.Labels{
+		"namespace": group.GetNamespace(),
+		"nodegroup": group.GetName(),
+	})
+	var statuses []meshv1.NodeCertificateStatus
+	var recheck time.Duration
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := meshv1.MeshNodeCertName(mesh, group, i)
+		var secret corev1.Secret
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      name,
+			Namespace: group.GetNamespace(),
+		}, &secret)
+		if err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, 0, fmt.Errorf("get node certificate secret: %w", err)
+			}
+			// The certificate has not been issued yet
+			continue
+		}
+		if _, ok := secret.Data[corev1.TLSCertKey]; !ok {
+			continue
+		}
+		notAfter, err := certificateNotAfter(secret.Data[corev1.TLSCertKey])
+		if err != nil {
+			log.Error(err, "unable to parse node certificate", "certificate", name)
+			continue
+		}
+		remaining := time.Until(notAfter)
+		statuses = append(statuses, meshv1.NodeCertificateStatus{
+			Name:          name,
+			NotAfter:      metav1.NewTime(notAfter),
+			DaysRemaining: int32(remaining / (24 * time.Hour)),
+		})
+		nodeCertificateExpiry.WithLabelValues(group.GetNamespace(), group.GetName(), name).
+			Set(float64(notAfter.Unix()))
+		next := remaining - certExpiryWarningWindow
+		if next <= 0 {
+			r.Recorder.Eventf(group, corev1.EventTypeWarning, "CertificateExpiring",
+				"Certificate %s expires at %s", name, notAfter.Format(time.RFC3339))
+			next = certExpiryWarningInterval
+		}
+		if recheck == 0 || next < recheck {
+			recheck = next
+		}
+	}
+	return statuses, recheck, nil
+}
+
+// certificateNotAfter returns the expiry of the first certificate in the given
+// PEM data.
+func certificateNotAfter(data []byte) (time.Time, error) {
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// parseCertificate parses the first certificate in the given PEM data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// reconcileLoadBalancerAddresses records the external addresses of the group's
+// service in its status. The node certificates are re-applied when they change,
+// so they are reissued with the new addresses.
+func (r *NodeGroupReconciler) reconcileLoadBalancerAddresses(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, addrs []string) error {
+	if equality.Semantic.DeepEqual(group.Status.LoadBalancerAddresses, addrs) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Load balancer addresses changed, updating node certificates", "addresses", addrs)
+	group.Status.LoadBalancerAddresses = addrs
+	if err := r.Status().Update(ctx, group); err != nil {
+		return fmt.Errorf("update load balancer addresses: %w", err)
+	}
+	if err := resources.Apply(ctx, r.Client, resources.RenderNodeCertificates(mesh, group, operatorconfig.FromContext(ctx).ClusterDomain)); err != nil {
+		return fmt.Errorf("apply certificates: %w", err)
+	}
+	return nil
+}
+
+// recordCertificateSANs adds the load balancer names each node's certificate
+// was issued with, and the node names of reissued certificates, to the checksum
+// of the node config. Nodes only read their certificates at startup, so they
+// are rolled once their certificates are reissued with new names. It returns
+// true while a certificate still lacks any of its node's names, in which case
+// the rollout should be held until cert-manager reissues it.
+func (r *NodeGroupReconciler) recordCertificateSANs(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nodeconfig.Config) (bool, error) {
+	dnsNames, ipAddresses := group.LoadBalancerSANs()
+	sans := append(dnsNames, ipAddresses...)
+	var stale bool
+	reissued := make(map[string][]string)
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := meshv1.MeshNodeCertName(mesh, group, i)
+		if names, ok := group.Status.ReissuedCertificateNames[name]; ok {
+			reissued[name] = names
+		}
+		var secret corev1.Secret
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      name,
+			Namespace: group.GetNamespace(),
+		}, &secret)
+		if err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return false, fmt.Errorf("get node certificate secret: %w", err)
+			}
+			continue
+		}
+		cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+		if err != nil {
+			// The certificate has not been issued yet
+			continue
+		}
+		var issued []string
+		for _, san := range sans {
+			if cert.VerifyHostname(san) == nil {
+				issued = append(issued, san)
+			}
+		}
+		if len(issued) > 0 {
+			// Nodes are left alone until their certificates carry
+			// any of the names
+			conf.SetCertificateSANs(name, issued)
+		}
+		nodeNames := meshv1.MeshNodeDNSNames(mesh, group, i, operatorconfig.FromContext(ctx).ClusterDomain)
+		var missing bool
+		for _, dnsName := range nodeNames {
+			if cert.VerifyHostname(dnsName) != nil {
+				missing = true
+				break
+			}
+		}
+		if missing {
+			// Remember the certificate is being reissued, the names it
+			// was last recorded with stay in the checksum until then
+			stale = true
+			if _, ok := reissued[name]; !ok {
+				reissued[name] = []string{}
+			}
+		} else if names, ok := reissued[name]; ok && !equality.Semantic.DeepEqual(names, nodeNames) {
+			reissued[name] = nodeNames
+		}
+		if names := reissued[name]; len(names) > 0 {
+			conf.SetReissuedCertificateNames(name, names)
+		}
+	}
+	if len(reissued) == 0 {
+		reissued = nil
+	}
+	group.Status.ReissuedCertificateNames = reissued
+	return stale, nil
+}
+
+// reportStaleCertificates raises an event for every existing node certificate
+// whose DNS names differ from the ones it is rendered with, for example after
+// the naming scheme changed in an upgrade. Applying the certificates updates
+// them and cert-manager reissues their secrets.
+func (r *NodeGroupReconciler) reportStaleCertificates(ctx context.Context, group *meshv1.NodeGroup, certs []client.Object) error {
+	for _, obj := range certs {
+		desired, ok := obj.(*certv1.Certificate)
+		if !ok {
+			continue
+		}
+		var existing certv1.Certificate
+		err := r.Get(ctx, client.ObjectKeyFromObject(desired), &existing)
+		if err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("get certificate: %w", err)
+			}
+			continue
+		}
+		if sets.New(existing.Spec.DNSNames...).Equal(sets.New(desired.Spec.DNSNames...)) {
+			continue
+		}
+		log.FromContext(ctx).Info("Updating stale certificate DNS names", "certificate", desired.GetName())
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "CertificateNamesChanged",
+			"Updating DNS names of certificate %s, its nodes are restarted once it is reissued", desired.GetName())
+	}
+	return nil
+}
+
+// certificatesCover returns true if the certificates of every node in the group
+// are valid for the given host.
+func certificatesCover(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, host string) (bool, error) {
+	for i := 0; i < int(group.Replicas()); i++ {
+		var secret corev1.Secret
+		err := cli.Get(ctx, client.ObjectKey{
+			Name:      meshv1.MeshNodeCertName(mesh, group, i),
+			Namespace: group.GetNamespace(),
+		}, &secret)
+		if err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+		if err != nil || cert.VerifyHostname(host) != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
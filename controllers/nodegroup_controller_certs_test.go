@@ -0,0 +1,317 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+func TestStaleCertificateNames(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: pointer(int32(1)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	certs := resources.RenderNodeCertificates(mesh, group, meshv1.DefaultClusterDomain)
+	current := certs[0].(*certv1.Certificate)
+
+	// Simulate certificates issued by a release that named the headless
+	// service differently
+	stale := current.DeepCopy()
+	stale.Spec.DNSNames = nil
+	svcName := meshv1.MeshNodeGroupHeadlessServiceName(mesh, group)
+	for _, name := range current.Spec.DNSNames {
+		stale.Spec.DNSNames = append(stale.Spec.DNSNames, strings.Replace(name, svcName, svcName+"-legacy", 1))
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: current.Spec.SecretName, Namespace: "default"},
+		Data:       map[string][]byte{corev1.TLSCertKey: issueTestCertificate(t, stale.Spec.DNSNames)},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(stale, secret).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+	ctx := context.Background()
+
+	if err := r.reportStaleCertificates(ctx, group, certs); err != nil {
+		t.Fatalf("report stale certificates: %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "CertificateNamesChanged") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Fatal("expected an event for the stale certificate")
+	}
+
+	checksum := func(wantStale bool) string {
+		conf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "join:8443"})
+		if err != nil {
+			t.Fatalf("build config: %v", err)
+		}
+		stale, err := r.recordCertificateSANs(ctx, mesh, group, conf)
+		if err != nil {
+			t.Fatalf("record certificate names: %v", err)
+		}
+		if stale != wantStale {
+			t.Errorf("expected stale to be %v, got %v", wantStale, stale)
+		}
+		return conf.Checksum()
+	}
+	unissued, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "join:8443"})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	// The stale certificate must not roll the nodes by itself, they would
+	// restart with the certificate they already have
+	staleChecksum := checksum(true)
+	if staleChecksum != unissued.Checksum() {
+		t.Error("expected the stale certificate to leave the config checksum unchanged")
+	}
+
+	// The certificate is updated and reissued with the current names
+	updated := stale.DeepCopy()
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(stale), updated); err != nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+	updated.Spec.DNSNames = current.Spec.DNSNames
+	if err := cli.Update(ctx, updated); err != nil {
+		t.Fatalf("update certificate: %v", err)
+	}
+	secret.Data[corev1.TLSCertKey] = issueTestCertificate(t, current.Spec.DNSNames)
+	if err := cli.Update(ctx, secret); err != nil {
+		t.Fatalf("update secret: %v", err)
+	}
+	if err := r.reportStaleCertificates(ctx, group, certs); err != nil {
+		t.Fatalf("report stale certificates: %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected event %q for an up to date certificate", event)
+	default:
+	}
+	reissuedChecksum := checksum(false)
+	if reissuedChecksum == staleChecksum {
+		t.Error("expected the reissued certificate to change the config checksum")
+	}
+	if checksum(false) != reissuedChecksum {
+		t.Error("expected the nodes to be rolled only once for the reissued certificate")
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCA(t)
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "expiry", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: pointer(int32(4)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	// Certificates only carry whole seconds
+	now := time.Now().Truncate(time.Second)
+	healthy := now.Add(30*24*time.Hour + time.Hour)
+	expiring := now.Add(3*24*time.Hour + time.Hour)
+	secret := func(i int, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshNodeCertName(mesh, group, i), Namespace: "default"},
+			Data:       data,
+		}
+	}
+	// The third certificate is unparseable and the fourth is not issued yet
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		secret(0, ca.issueUntil(t, healthy)),
+		secret(1, ca.issueUntil(t, expiring)),
+		secret(2, map[string][]byte{corev1.TLSCertKey: []byte("not a certificate")}),
+	).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+	ctx := context.Background()
+	expiry := func(i int) float64 {
+		return testutil.ToFloat64(nodeCertificateExpiry.WithLabelValues("default", group.GetName(), meshv1.MeshNodeCertName(mesh, group, i)))
+	}
+
+	statuses, recheck, err := r.reconcileCertificateExpiry(ctx, mesh, group)
+	if err != nil {
+		t.Fatalf("reconcile certificate expiry: %v", err)
+	}
+	want := []struct {
+		notAfter time.Time
+		days     int32
+	}{{healthy, 30}, {expiring, 3}}
+	if len(statuses) != len(want) {
+		t.Fatalf("expected %d certificate statuses, got %+v", len(want), statuses)
+	}
+	for i, w := range want {
+		if statuses[i].Name != meshv1.MeshNodeCertName(mesh, group, i) {
+			t.Errorf("expected status %d for %q, got %q", i, meshv1.MeshNodeCertName(mesh, group, i), statuses[i].Name)
+		}
+		if !statuses[i].NotAfter.Time.Equal(w.notAfter) {
+			t.Errorf("expected %q to expire at %s, got %s", statuses[i].Name, w.notAfter, statuses[i].NotAfter)
+		}
+		if statuses[i].DaysRemaining != w.days {
+			t.Errorf("expected %d days remaining for %q, got %d", w.days, statuses[i].Name, statuses[i].DaysRemaining)
+		}
+		if got := expiry(i); got != float64(w.notAfter.Unix()) {
+			t.Errorf("expected expiry metric %v for %q, got %v", w.notAfter.Unix(), statuses[i].Name, got)
+		}
+	}
+	// The expiring certificate is re-checked on the warning interval
+	if recheck != certExpiryWarningInterval {
+		t.Errorf("expected a recheck after %s, got %s", certExpiryWarningInterval, recheck)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "Warning CertificateExpiring") || !strings.Contains(event, meshv1.MeshNodeCertName(mesh, group, 1)) {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Fatal("expected a warning for the expiring certificate")
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected event %q", event)
+	default:
+	}
+
+	// Once scaled down to the healthy node, the group is re-checked as its
+	// certificate enters the warning window and the metrics of removed nodes
+	// are dropped
+	group.Spec.Replicas = pointer(int32(1))
+	wantRecheck := time.Until(healthy) - certExpiryWarningWindow
+	statuses, recheck, err = r.reconcileCertificateExpiry(ctx, mesh, group)
+	if err != nil {
+		t.Fatalf("reconcile certificate expiry: %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("expected one certificate status, got %+v", statuses)
+	}
+	if recheck > wantRecheck || recheck < wantRecheck-time.Minute {
+		t.Errorf("expected a recheck after about %s, got %s", wantRecheck, recheck)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected event %q", <-recorder.Events)
+	}
+	if expiry(0) != float64(healthy.Unix()) {
+		t.Errorf("expected the expiry metric of the healthy certificate to remain")
+	}
+	if nodeCertificateExpiry.DeleteLabelValues("default", group.GetName(), meshv1.MeshNodeCertName(mesh, group, 1)) {
+		t.Error("expected the expiry metric of the removed node to be deleted")
+	}
+
+	t.Run("GetError", func(t *testing.T) {
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(context.Context, client.WithWatch, client.ObjectKey, client.Object, ...client.GetOption) error {
+					return errors.New("connection refused")
+				},
+			}).
+			Build()
+		r := &NodeGroupReconciler{Client: cli, Recorder: record.NewFakeRecorder(10)}
+		if _, _, err := r.reconcileCertificateExpiry(ctx, mesh, group); err == nil {
+			t.Fatal("expected the get error to be returned")
+		}
+	})
+}
+
+func TestCertificateNotAfter(t *testing.T) {
+	ca := newTestCA(t)
+	notAfter := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	data := ca.issueUntil(t, notAfter)
+	// The CA that follows the leaf in a chain expires sooner
+	chain := append(append([]byte{}, data[corev1.TLSCertKey]...), ca.certPEM...)
+	got, err := certificateNotAfter(chain)
+	if err != nil {
+		t.Fatalf("certificate expiry: %v", err)
+	}
+	if !got.Equal(notAfter) {
+		t.Errorf("expected the leaf to expire at %s, got %s", notAfter, got)
+	}
+	for name, data := range map[string][]byte{
+		"Empty":    nil,
+		"NotPEM":   []byte("not a certificate"),
+		"WrongPEM": data[corev1.TLSPrivateKeyKey],
+	} {
+		if _, err := certificateNotAfter(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// issueTestCertificate returns a self-signed PEM certificate for the given
+// names.
+func issueTestCertificate(t *testing.T, dnsNames []string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
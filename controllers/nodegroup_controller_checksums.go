@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+// acceptsConfigChecksums returns true if the group asks for the config
+// checksums of its deployed nodes to be accepted.
+func acceptsConfigChecksums(group *meshv1.NodeGroup) bool {
+	return group.GetAnnotations()[meshv1.AcceptConfigChecksumAnnotation] == "true"
+}
+
+// resolveConfigChecksum returns the config checksum to record on an object of
+// the group that was deployed with the given checksum. The deployed checksum
+// is kept if it was accepted for the computed one, and is accepted while the
+// group has the accept annotation. Otherwise the computed checksum is returned
+// and the object is replaced.
+func resolveConfigChecksum(ctx context.Context, group *meshv1.NodeGroup, deployed, computed string) string {
+	if deployed == "" || deployed == computed {
+		return computed
+	}
+	if group.Status.AcceptedConfigChecksums[computed] == deployed {
+		return deployed
+	}
+	if !acceptsConfigChecksums(group) {
+		return computed
+	}
+	log.FromContext(ctx).Info("Accepting config checksum of deployed nodes", "deployed", deployed, "checksum", computed)
+	if group.Status.AcceptedConfigChecksums == nil {
+		group.Status.AcceptedConfigChecksums = make(map[string]string)
+	}
+	group.Status.AcceptedConfigChecksums[computed] = deployed
+	return deployed
+}
+
+// acceptStatefulSetChecksum makes the config report the checksum the group's
+// StatefulSet was deployed with if it is accepted for the config.
+func acceptStatefulSetChecksum(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nodeconfig.Config) error {
+	var sts appsv1.StatefulSet
+	err := cli.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, group),
+		Namespace: group.GetNamespace(),
+	}, &sts)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil
+		}
+		return fmt.Errorf("get statefulset: %w", err)
+	}
+	computed := conf.Checksum()
+	deployed := sts.Spec.Template.GetAnnotations()[meshv1.ConfigChecksumAnnotation]
+	if checksum := resolveConfigChecksum(ctx, group, deployed, computed); checksum != computed {
+		conf.AcceptChecksum(checksum)
+	}
+	return nil
+}
+
+// holdStatefulSetChecksum makes the config report the checksum the group's
+// StatefulSet was deployed with, holding the rollout of its nodes.
+func holdStatefulSetChecksum(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nodeconfig.Config) error {
+	var sts appsv1.StatefulSet
+	err := cli.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, group),
+		Namespace: group.GetNamespace(),
+	}, &sts)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if deployed := sts.Spec.Template.GetAnnotations()[meshv1.ConfigChecksumAnnotation]; deployed != "" {
+		conf.AcceptChecksum(deployed)
+	}
+	return nil
+}
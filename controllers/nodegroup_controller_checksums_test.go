@@ -0,0 +1,55 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestResolveConfigChecksum(t *testing.T) {
+	ctx := context.Background()
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"}}
+
+	if got := resolveConfigChecksum(ctx, group, "old", "new"); got != "new" {
+		t.Errorf("expected changed nodes to be replaced, got %s", got)
+	}
+	if got := resolveConfigChecksum(ctx, group, "", "new"); got != "new" {
+		t.Errorf("expected new nodes to use the computed checksum, got %s", got)
+	}
+
+	group.Annotations = map[string]string{meshv1.AcceptConfigChecksumAnnotation: "true"}
+	if got := resolveConfigChecksum(ctx, group, "old", "new"); got != "old" {
+		t.Errorf("expected the deployed checksum to be accepted, got %s", got)
+	}
+	if got := group.Status.AcceptedConfigChecksums["new"]; got != "old" {
+		t.Errorf("expected the accepted checksum to be recorded, got %q", got)
+	}
+
+	// The baseline holds once the annotation is removed
+	group.Annotations = nil
+	if got := resolveConfigChecksum(ctx, group, "old", "new"); got != "old" {
+		t.Errorf("expected the accepted checksum to be kept, got %s", got)
+	}
+	if got := resolveConfigChecksum(ctx, group, "old", "newer"); got != "newer" {
+		t.Errorf("expected later changes to replace the nodes, got %s", got)
+	}
+}
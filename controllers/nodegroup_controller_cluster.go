@@ -20,64 +20,91 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/netip"
+	"strings"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
 	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
 	"github.com/webmeshproj/operator/controllers/resources"
 )
 
-func (r *NodeGroupReconciler) reconcileClusterNodeGroup(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+// clusterProvider deploys node groups to a Kubernetes cluster.
+type clusterProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r clusterProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("Reconciling cluster node group")
 
 	toApply := make([]client.Object, 0)
-	cli := r.Client
-	if group.Spec.Cluster.Kubeconfig != nil {
-		// TODO: Doesn't account for certificates needing to be copied
-		// to the remote cluster
-		var secret corev1.Secret
-		err := r.Get(ctx, client.ObjectKey{
-			Name:      group.Spec.Cluster.Kubeconfig.Name,
-			Namespace: group.GetNamespace(),
-		}, &secret)
-		if err != nil {
-			log.Error(err, "unable to fetch kubeconfig secret")
-			return ctrl.Result{}, err
-		}
-		kubeconfig, ok := secret.Data[group.Spec.Cluster.Kubeconfig.Key]
-		if !ok {
-			err := errors.New("kubeconfig secret does not contain key")
-			log.Error(err, "unable to fetch kubeconfig secret")
+	// TODO: Doesn't account for certificates needing to be copied
+	// to the remote cluster
+	cli, err := r.getClusterClient(ctx, group)
+	if err != nil {
+		log.Error(err, "unable to create cluster client")
+		return ctrl.Result{}, err
+	}
+
+	families, err := r.getServiceIPFamilies(ctx, cli, mesh, group)
+	if err != nil {
+		log.Error(err, "unable to determine service IP families")
+		return ctrl.Result{}, err
+	}
+	adopted, err := getAdoptedStatefulSet(ctx, cli, mesh, group)
+	if err != nil {
+		log.Error(err, "unable to get adopted statefulset")
+		return ctrl.Result{}, err
+	}
+
+	// Create the service if we are exposing the node group
+	var externalURLs []string
+	var replicaURLs [][]string
+	if group.Spec.Cluster.Service != nil {
+		if err := r.deleteStaleLBServices(ctx, cli, mesh, group); err != nil {
+			log.Error(err, "unable to delete stale load balancer services")
 			return ctrl.Result{}, err
 		}
-		cfg, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	}
+	if group.ExposedPerReplica() {
+		var ready bool
+		replicaURLs, ready, err = r.reconcileReplicaServices(ctx, cli, mesh, group, families)
 		if err != nil {
-			log.Error(err, "unable to create client config")
+			log.Error(err, "unable to reconcile replica load balancer services")
 			return ctrl.Result{}, err
 		}
-		cli, err = client.New(cfg, client.Options{})
-		if err != nil {
-			log.Error(err, "unable to create client")
+		if !ready {
+			log.Info("waiting for replica load balancers to be ready")
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+		externalURLs = replicaExternalURLs(replicaURLs)
+	} else if group.Spec.Cluster.Service != nil {
+		svc := resources.NewNodeGroupLBService(mesh, group, families)
+		if err := setLoadBalancerClass(ctx, cli, svc); err != nil {
+			log.Error(err, "unable to determine load balancer class")
 			return ctrl.Result{}, err
 		}
-	}
-
-	// Create the service if we are exposing the node group
-	var externalURLs []string
-	if group.Spec.Cluster.Service != nil {
-		toApply = append(toApply, resources.NewNodeGroupLBService(mesh, group))
+		toApply = append(toApply, svc)
 		if group.Spec.Cluster.Service.ExternalURL != "" {
 			externalURLs = []string{group.Spec.Cluster.Service.ExternalURL}
 		} else {
 			// We need to pre-create the service so we can use it as the external URL
+			resources.AdoptStatefulSet(adopted, toApply)
 			err := resources.Apply(ctx, cli, toApply)
 			if err != nil {
 				log.Error(err, "unable to apply resources")
@@ -98,92 +125,477 @@ func (r *NodeGroupReconciler) reconcileClusterNodeGroup(ctx context.Context, mes
 		}
 	}
 
+	if group.Spec.Cluster.Service != nil {
+		if err := r.reconcileLoadBalancerAddresses(ctx, mesh, group, externalURLs); err != nil {
+			log.Error(err, "unable to record load balancer addresses")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Hold off on the workload until the group can join the mesh
+	wait, err := r.waitForBootstrapQuorum(ctx, mesh, group)
+	if err != nil {
+		log.Error(err, "unable to check bootstrap quorum")
+		return ctrl.Result{}, err
+	}
+	if wait {
+		resources.AdoptStatefulSet(adopted, toApply)
+		if len(toApply) > 0 {
+			if err := resources.Apply(ctx, cli, toApply); err != nil {
+				log.Error(err, "unable to apply resources")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: bootstrapQuorumRetryInterval}, nil
+	}
+
 	// Create Node group service, config, and statefulset
-	conf, err := r.buildClusterNodeConfig(ctx, mesh, group, externalURLs)
+	conf, err := r.buildClusterNodeConfig(ctx, mesh, group, externalURLs, replicaURLs)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	toApply = append(toApply,
-		resources.NewNodeGroupConfigMap(mesh, group, conf),
-		resources.NewNodeGroupHeadlessService(mesh, group),
-		resources.NewNodeGroupStatefulSet(mesh, group, conf.Checksum()),
-	)
+	if _, err := getConfigSecrets(ctx, cli, group.GetNamespace(), conf); err != nil {
+		log.Error(err, "unable to read node config secrets")
+		return ctrl.Result{}, err
+	}
+	staleCerts, err := r.recordCertificateSANs(ctx, mesh, group, conf)
+	if err != nil {
+		log.Error(err, "unable to check node certificates")
+		return ctrl.Result{}, err
+	}
+	if err := acceptStatefulSetChecksum(ctx, cli, mesh, group, conf); err != nil {
+		log.Error(err, "unable to check deployed config checksum")
+		return ctrl.Result{}, err
+	}
+	if staleCerts {
+		// Nodes would restart with the certificates they already have
+		log.Info("Holding node rollout until certificates are reissued")
+		if err := holdStatefulSetChecksum(ctx, cli, mesh, group, conf); err != nil {
+			log.Error(err, "unable to hold deployed config checksum")
+			return ctrl.Result{}, err
+		}
+	}
+	toApply = append(toApply, resources.RenderClusterNodeGroup(mesh, group, conf, families, operatorconfig.FromContext(ctx).Images.MetricsProxy)...)
+	resources.AdoptStatefulSet(adopted, toApply)
 	if err := resources.Apply(ctx, cli, toApply); err != nil {
 		log.Error(err, "unable to apply resources")
 		return ctrl.Result{}, err
 	}
+	if err := r.deleteStaleNodeConfig(ctx, cli, mesh, group); err != nil {
+		log.Error(err, "unable to delete stale node config")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileNodeTLSInjection(ctx, cli, mesh, group); err != nil {
+		log.Error(err, "node pods are missing their certificate secret")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileMaintenance(ctx, cli, mesh, group); err != nil {
+		log.Error(err, "unable to reconcile node maintenance")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileNodeFailures(ctx, cli, mesh, group); err != nil {
+		log.Error(err, "unable to check for failed nodes")
+		return ctrl.Result{}, err
+	}
+	recheck, err := r.reconcileCapacityPending(ctx, cli, mesh, group)
+	if err != nil {
+		log.Error(err, "unable to check for unschedulable nodes")
+		return ctrl.Result{}, err
+	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: recheck}, nil
 }
 
-func (r *NodeGroupReconciler) buildClusterNodeConfig(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, externalURLs []string) (*nodeconfig.Config, error) {
-	var isBootstrap bool
-	if val, ok := group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation]; ok && val == "true" {
-		isBootstrap = true
-	}
-	var primaryEndpoint string
-	internalEndpoint := fmt.Sprintf(`{{ env "POD_NAME" }}.%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group), meshv1.DefaultWireGuardPort)
-	wireguardEndpoints := []string{internalEndpoint}
-	if len(externalURLs) > 0 {
-		primaryEndpoint = externalURLs[0]
-		wgPort := func() int {
-			if group.Spec.Cluster.Service != nil {
-				return int(group.Spec.Cluster.Service.WireGuardPort)
-			}
-			return meshv1.DefaultWireGuardPort
-		}()
-		for _, url := range externalURLs {
-			addr, err := netip.ParseAddr(url)
-			if err != nil {
-				return nil, err
-			}
-			var externalEndpoint string
-			if addr.Is4() {
-				externalEndpoint = fmt.Sprintf(`%s:%d`, url, wgPort)
-			} else {
-				externalEndpoint = fmt.Sprintf(`[%s]:%d`, url, wgPort)
-			}
-			wireguardEndpoints = append(wireguardEndpoints, externalEndpoint)
-		}
-	}
-	var advertiseAddress string
-	var joinServer string
-	var bootstrapVoters []string
-	bootstrapServers := make(map[string]string)
-	if isBootstrap {
-		if *group.Spec.Replicas > 1 {
-			advertiseAddress = fmt.Sprintf(`{{ env "POD_NAME" }}.%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, group), meshv1.DefaultRaftPort)
-			for i := 0; i < int(*group.Spec.Replicas); i++ {
-				bootstrapServers[meshv1.MeshNodeHostname(mesh, group, i)] = fmt.Sprintf("%s:%d", meshv1.MeshNodeClusterFQDN(mesh, group, i), meshv1.DefaultRaftPort)
+// getAdoptedStatefulSet returns the existing StatefulSet adopted by the group,
+// or nil if the group does not adopt one or it does not exist. A group whose
+// adopted StatefulSet was deleted creates it again under the same name.
+func getAdoptedStatefulSet(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (*appsv1.StatefulSet, error) {
+	if group.Spec.Cluster.AdoptExisting == nil {
+		return nil, nil
+	}
+	var sts appsv1.StatefulSet
+	err := cli.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, group),
+		Namespace: group.GetNamespace(),
+	}, &sts)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get adopted statefulset: %w", err)
+	}
+	if metav1.GetControllerOf(&sts) == nil {
+		log.FromContext(ctx).Info("Adopting existing statefulset", "statefulset", sts.GetName())
+	}
+	return &sts, nil
+}
+
+// maxConditionMessageLength is the maximum length of a condition message.
+const maxConditionMessageLength = 32768
+
+// reconcileNodeFailures records node containers that exited with an error in
+// the NodesFailed condition of the group, and raises a warning event for each
+// failure not yet in the condition. The node container falls back to its logs
+// for its termination message, so startup errors can be seen without reading
+// the logs of the previous container.
+func (r *NodeGroupReconciler) reconcileNodeFailures(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) error {
+	var pods corev1.PodList
+	err := cli.List(ctx, &pods,
+		client.InNamespace(group.GetNamespace()),
+		client.MatchingLabels(meshv1.NodeGroupSelector(mesh, group)))
+	if err != nil {
+		return fmt.Errorf("list node pods: %w", err)
+	}
+	condition := metav1.Condition{
+		Type:               meshv1.NodesFailedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             "NodesRunning",
+		Message:            "No node containers have failed",
+	}
+	failed := inspect.FailedContainers(pods.Items, resources.NodeContainerName)
+	messages := make([]string, 0, len(failed))
+	for _, termination := range failed {
+		messages = append(messages, terminationMessage(termination))
+	}
+	if len(failed) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ContainerFailed"
+		condition.Message = strings.Join(messages, "\n")
+		if len(condition.Message) > maxConditionMessageLength {
+			condition.Message = condition.Message[:maxConditionMessageLength]
+		}
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once a node has failed
+		return nil
+	}
+	if current != nil && current.Status == condition.Status &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	for _, message := range messages {
+		if current == nil || !strings.Contains(current.Message, message) {
+			r.Recorder.Event(group, corev1.EventTypeWarning, "NodeFailed", message)
+		}
+	}
+	meta.SetStatusCondition(&group.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, group); err != nil {
+		return fmt.Errorf("update nodes failed condition: %w", err)
+	}
+	return nil
+}
+
+// reconcileNodeTLSInjection records node pods the node pod webhook did not
+// project the certificate secret into in the NodeTLSInjectionFailed condition
+// of the group, and raises a warning event when it changes. Such pods mount a
+// secret that does not exist and never start, which otherwise only shows as a
+// mount failure on each pod. An error is returned while any such pod exists.
+func (r *NodeGroupReconciler) reconcileNodeTLSInjection(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) error {
+	var pods corev1.PodList
+	err := cli.List(ctx, &pods,
+		client.InNamespace(group.GetNamespace()),
+		client.MatchingLabels(meshv1.NodeGroupSelector(mesh, group)))
+	if err != nil {
+		return fmt.Errorf("list node pods: %w", err)
+	}
+	var pending []string
+	for i := range pods.Items {
+		if resources.PendingNodeTLSSecret(&pods.Items[i]) {
+			pending = append(pending, pods.Items[i].GetName())
+		}
+	}
+	condition := metav1.Condition{
+		Type:               meshv1.NodeTLSInjectionFailedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             "SecretsProjected",
+		Message:            "Node pods project their certificate secret",
+	}
+	if len(pending) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "WebhookMissing"
+		condition.Message = fmt.Sprintf("Node pods %s were created without their certificate secret. "+
+			"Groups with more than one replica require the node pod webhook at %s to be installed; "+
+			"install the webhook configuration and delete the pods, or run a single replica",
+			strings.Join(pending, ", "), NodePodWebhookPath)
+		if len(condition.Message) > maxConditionMessageLength {
+			condition.Message = condition.Message[:maxConditionMessageLength]
+		}
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once a pod was not mutated
+		return nil
+	}
+	if current == nil || current.Status != condition.Status ||
+		current.Message != condition.Message || current.ObservedGeneration != condition.ObservedGeneration {
+		if condition.Status == metav1.ConditionTrue && (current == nil || current.Message != condition.Message) {
+			r.Recorder.Event(group, corev1.EventTypeWarning, "NodeTLSInjectionFailed", condition.Message)
+		}
+		meta.SetStatusCondition(&group.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, group); err != nil {
+			return fmt.Errorf("update node TLS injection condition: %w", err)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("node pods %s were not mutated by the node pod webhook", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// capacityPendingThreshold is how long node pods may be unschedulable before
+// the group reports pending capacity. It leaves the cluster autoscaler time to
+// add nodes.
+const capacityPendingThreshold = 2 * time.Minute
+
+// reconcileCapacityPending records node pods that could not be scheduled for
+// longer than capacityPendingThreshold in the CapacityPending condition of the
+// group, together with the message of the scheduler, and raises a warning event
+// for each pod not yet in the condition. It returns how long to wait before
+// checking pods that are unschedulable but still within the threshold.
+func (r *NodeGroupReconciler) reconcileCapacityPending(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (time.Duration, error) {
+	var pods corev1.PodList
+	err := cli.List(ctx, &pods,
+		client.InNamespace(group.GetNamespace()),
+		client.MatchingLabels(meshv1.NodeGroupSelector(mesh, group)))
+	if err != nil {
+		return 0, fmt.Errorf("list node pods: %w", err)
+	}
+	condition := metav1.Condition{
+		Type:               meshv1.CapacityPendingCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             "NodesScheduled",
+		Message:            "All node pods could be scheduled",
+	}
+	var recheck time.Duration
+	var messages []string
+	for _, failure := range inspect.UnschedulablePods(pods.Items) {
+		if wait := capacityPendingThreshold - time.Since(failure.Since.Time); wait > 0 {
+			if recheck == 0 || wait < recheck {
+				recheck = wait
 			}
+			continue
 		}
-		if mesh.Spec.Bootstrap.Cluster != nil && mesh.Spec.Bootstrap.Cluster.Service != nil {
-			// Make sure the lb node can vote in the cluster
-			bootstrapVoters = append(bootstrapVoters, fmt.Sprintf("%s-0", meshv1.MeshBootstrapLBGroupName(mesh)))
+		messages = append(messages, fmt.Sprintf("Node %s could not be scheduled: %s", failure.Pod, failure.Message))
+	}
+	if len(messages) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Unschedulable"
+		condition.Message = strings.Join(messages, "\n")
+		if len(condition.Message) > maxConditionMessageLength {
+			condition.Message = condition.Message[:maxConditionMessageLength]
 		}
-	} else {
-		var err error
-		joinServer, err = getJoinServer(ctx, r.Client, mesh, group)
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once a node has been pending
+		return recheck, nil
+	}
+	if current != nil && current.Status == condition.Status &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return recheck, nil
+	}
+	for _, message := range messages {
+		if current == nil || !strings.Contains(current.Message, message) {
+			r.Recorder.Event(group, corev1.EventTypeWarning, "CapacityPending", message)
+		}
+	}
+	meta.SetStatusCondition(&group.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, group); err != nil {
+		return 0, fmt.Errorf("update capacity pending condition: %w", err)
+	}
+	return recheck, nil
+}
+
+// terminationMessage describes the termination of a node container.
+func terminationMessage(termination inspect.ContainerTermination) string {
+	message := fmt.Sprintf("Node %s exited with code %d", termination.Pod, termination.ExitCode)
+	if termination.Reason != "" {
+		message += fmt.Sprintf(" (%s)", termination.Reason)
+	}
+	if msg := strings.TrimSpace(termination.Message); msg != "" {
+		message += ": " + msg
+	}
+	return message
+}
+
+// nodePodToNodeGroup maps a node pod to the group it belongs to.
+func (r *NodeGroupReconciler) nodePodToNodeGroup(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, ok := meshv1.LabelValue(labels, meshv1.NodeGroupNameLabel)
+	if !ok {
+		return nil
+	}
+	namespace, _ := meshv1.LabelValue(labels, meshv1.NodeGroupNamespaceLabel)
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      name,
+		Namespace: namespace,
+	}}}
+}
+
+// getClusterClient returns the client for the cluster the group is deployed to.
+// This is the local client unless the group references a kubeconfig.
+func (r *NodeGroupReconciler) getClusterClient(ctx context.Context, group *meshv1.NodeGroup) (client.Client, error) {
+	if group.Spec.Cluster.Kubeconfig == nil {
+		return r.Client, nil
+	}
+	var secret corev1.Secret
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      group.Spec.Cluster.Kubeconfig.Name,
+		Namespace: group.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("fetch kubeconfig secret: %w", err)
+	}
+	kubeconfig, ok := secret.Data[group.Spec.Cluster.Kubeconfig.Key]
+	if !ok {
+		return nil, errors.New("kubeconfig secret does not contain key")
+	}
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("create client config: %w", err)
+	}
+	cli, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+	if resources.IsDryRun(ctx) {
+		// No status is written to remote clusters, every write is a dry run
+		cli = client.NewDryRunClient(cli)
+	}
+	return resources.ForCluster(cli, cfg.Host), nil
+}
+
+// Delete deletes the objects created for the group through the client of the
+// cluster it is deployed to. Owner references do not reach across clusters, so
+// remote objects are not garbage collected. Objects already gone are skipped,
+// so it can be retried after a partial failure. Objects that only share a name
+// with those of the group are left alone. Replicas cordoned for maintenance
+// are restored first.
+func (r clusterProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	r.releaseMaintenance(ctx, group)
+	log := log.FromContext(ctx)
+	cli, err := r.getClusterClient(ctx, group)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// Nothing can be cleaned up without the kubeconfig
+			log.Info("Kubeconfig secret not found, skipping cleanup of remote cluster resources")
+			r.Recorder.Event(group, corev1.EventTypeWarning, "CleanupSkipped",
+				"Kubeconfig secret not found, resources in the remote cluster were not deleted")
+			return nil
+		}
+		return fmt.Errorf("create cluster client: %w", err)
+	}
+	// Only names are needed and they depend on the mesh name alone,
+	// which allows cleanup after the mesh is gone.
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{
+		Name:      group.MeshKey().Name,
+		Namespace: group.MeshKey().Namespace,
+	}}
+	for _, obj := range resources.ClusterNodeGroupInventory(mesh, group) {
+		if err := deleteOwnedObject(ctx, cli, group, obj); err != nil {
+			return fmt.Errorf("delete %T %s/%s: %w", obj, obj.GetNamespace(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// deleteStaleNodeConfig removes the config object of the storage type the group
+// is not using, left behind when the group's config storage is switched. An
+// object of the same name that was not created for the group is left alone.
+func (r *NodeGroupReconciler) deleteStaleNodeConfig(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) error {
+	if group.Spec.Cluster.AdoptExisting != nil {
+		// Adopted configs are always ConfigMaps, a Secret of the same
+		// name was not created by the operator
+		return nil
+	}
+	meta := metav1.ObjectMeta{
+		Name:      meshv1.MeshNodeGroupConfigMapName(mesh, group),
+		Namespace: group.GetNamespace(),
+	}
+	var stale client.Object = &corev1.Secret{ObjectMeta: meta}
+	if group.Spec.Cluster.ConfigStorage == meshv1.ConfigStorageSecret {
+		stale = &corev1.ConfigMap{ObjectMeta: meta}
+	}
+	if err := deleteOwnedObject(ctx, cli, group, stale); err != nil {
+		return fmt.Errorf("delete stale node config: %w", err)
+	}
+	return nil
+}
+
+// deleteOwnedObject deletes the object with the name and namespace of the
+// given one if it was created for the group. Objects that do not exist or
+// belong to something else are skipped.
+func deleteOwnedObject(ctx context.Context, cli client.Client, group *meshv1.NodeGroup, obj client.Object) error {
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !resources.OwnedByNodeGroup(obj, group) {
+		log.FromContext(ctx).V(1).Info("Skipping deletion of object not created for the group",
+			"kind", fmt.Sprintf("%T", obj), "name", obj.GetName())
+		return nil
+	}
+	uid := obj.GetUID()
+	if err := cli.Delete(ctx, obj, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	resources.Forget(cli, obj)
+	return nil
+}
+
+// getServiceIPFamilies returns the IP families to use for the group's
+// services. The group's service configuration takes precedence over the
+// mesh default. If neither is set the families are detected from the
+// cluster the group is deployed to.
+func (r *NodeGroupReconciler) getServiceIPFamilies(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (meshv1.ServiceIPFamilies, error) {
+	if group.Spec.Cluster.Service != nil && group.Spec.Cluster.Service.ServiceIPFamilies.IsSet() {
+		return group.Spec.Cluster.Service.ServiceIPFamilies, nil
+	}
+	if mesh.Spec.ServiceIPFamilies.IsSet() {
+		return mesh.Spec.ServiceIPFamilies, nil
+	}
+	if group.Spec.Cluster.Kubeconfig != nil {
+		// Remote clusters are not cached
+		return detectServiceIPFamilies(ctx, cli, group.GetNamespace())
+	}
+	if r.Namespaced {
+		// The API server's service lives outside of the namespaces we may
+		// read, leave the families to the cluster defaults
+		return meshv1.ServiceIPFamilies{}, nil
+	}
+	r.ipFamiliesMu.Lock()
+	defer r.ipFamiliesMu.Unlock()
+	if r.ipFamilies == nil {
+		families, err := detectServiceIPFamilies(ctx, cli, group.GetNamespace())
 		if err != nil {
-			return nil, fmt.Errorf("get join server: %w", err)
+			return families, err
+		}
+		if families.IsSet() {
+			log.FromContext(ctx).Info("Detected cluster service IP families",
+				"policy", *families.IPFamilyPolicy, "families", families.IPFamilies)
 		}
+		r.ipFamilies = &families
 	}
-	conf, err := nodeconfig.New(nodeconfig.Options{
+	return *r.ipFamilies, nil
+}
+
+func (r *NodeGroupReconciler) buildClusterNodeConfig(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, externalURLs []string, replicaURLs [][]string) (*nodeconfig.Config, error) {
+	opts := nodeconfig.ClusterOptions{
 		Mesh:                mesh,
 		Group:               group,
-		AdvertiseAddress:    advertiseAddress,
-		PrimaryEndpoint:     primaryEndpoint,
-		WireGuardEndpoints:  wireguardEndpoints,
-		IsBootstrap:         isBootstrap,
-		BootstrapServers:    bootstrapServers,
-		BootstrapVoters:     bootstrapVoters,
-		JoinServer:          joinServer,
-		IsPersistent:        group.Spec.Cluster.PVCSpec != nil,
-		CertDir:             fmt.Sprintf(`%s/{{ env "POD_NAME" }}`, meshv1.DefaultTLSDirectory),
-		WireGuardListenPort: meshv1.DefaultWireGuardPort,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("build node config: %w", err)
+		ExternalURLs:        externalURLs,
+		ReplicaExternalURLs: replicaURLs,
+		ClusterDomain:       operatorconfig.FromContext(ctx).ClusterDomain,
+	}
+	if val, ok := group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation]; !ok || val != "true" {
+		var err error
+		opts.JoinServer, err = getJoinServer(ctx, r.Client, mesh, group)
+		if err != nil {
+			return nil, fmt.Errorf("get join server: %w", err)
+		}
 	}
-	return conf, nil
+	return nodeconfig.NewForCluster(opts)
 }
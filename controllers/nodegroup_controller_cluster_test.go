@@ -0,0 +1,277 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+func TestGetServiceIPFamilies(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	apiserver := func(families ...corev1.IPFamily) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+			Spec:       corev1.ServiceSpec{IPFamilies: families},
+		}
+	}
+	dualStack := meshv1.ServiceIPFamilies{
+		IPFamilyPolicy: pointer(corev1.IPFamilyPolicyPreferDualStack),
+	}
+	singleStack := func(family corev1.IPFamily) meshv1.ServiceIPFamilies {
+		return meshv1.ServiceIPFamilies{
+			IPFamilyPolicy: pointer(corev1.IPFamilyPolicySingleStack),
+			IPFamilies:     []corev1.IPFamily{family},
+		}
+	}
+	// rejectDualStack fails dry-run service creates the way a single-stack
+	// API server does and counts the reads of the API server's service.
+	rejectDualStack := func(gets *int) interceptor.Funcs {
+		return interceptor.Funcs{
+			Get: func(ctx context.Context, cli client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if _, ok := obj.(*corev1.Service); ok {
+					*gets++
+				}
+				return cli.Get(ctx, key, obj, opts...)
+			},
+			Create: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				return apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, obj.GetName(), field.ErrorList{
+					field.Invalid(field.NewPath("spec", "ipFamilyPolicy"), corev1.IPFamilyPolicyRequireDualStack, "cluster is not configured for dual-stack"),
+				})
+			},
+		}
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec:       meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{}},
+	}
+	expectFamilies := func(t *testing.T, r *NodeGroupReconciler, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, want meshv1.ServiceIPFamilies) {
+		t.Helper()
+		got, err := r.getServiceIPFamilies(context.Background(), cli, mesh, group)
+		if err != nil {
+			t.Fatalf("get service IP families: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected families %+v, got %+v", want, got)
+		}
+	}
+
+	t.Run("Precedence", func(t *testing.T) {
+		// Detection would fail without the API server's service
+		cli := fake.NewClientBuilder().WithScheme(scheme).Build()
+		r := &NodeGroupReconciler{Client: cli}
+		mesh := mesh.DeepCopy()
+		mesh.Spec.ServiceIPFamilies = singleStack(corev1.IPv4Protocol)
+		expectFamilies(t, r, cli, mesh, group, singleStack(corev1.IPv4Protocol))
+
+		group := group.DeepCopy()
+		group.Spec.Cluster.Service = &meshv1.NodeGroupLBConfig{ServiceIPFamilies: singleStack(corev1.IPv6Protocol)}
+		expectFamilies(t, r, cli, mesh, group, singleStack(corev1.IPv6Protocol))
+	})
+
+	t.Run("DetectDualStack", func(t *testing.T) {
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(apiserver(corev1.IPv4Protocol, corev1.IPv6Protocol)).Build()
+		r := &NodeGroupReconciler{Client: cli}
+		expectFamilies(t, r, cli, mesh, group, dualStack)
+
+		// The probe is only ever a dry run
+		var services corev1.ServiceList
+		if err := cli.List(context.Background(), &services); err != nil {
+			t.Fatalf("list services: %v", err)
+		}
+		if len(services.Items) != 1 {
+			t.Errorf("expected only the API server's service, got %d services", len(services.Items))
+		}
+	})
+
+	t.Run("DetectSingleStack", func(t *testing.T) {
+		var gets int
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(apiserver(corev1.IPv6Protocol)).
+			WithInterceptorFuncs(rejectDualStack(&gets)).
+			Build()
+		r := &NodeGroupReconciler{Client: cli}
+		expectFamilies(t, r, cli, mesh, group, singleStack(corev1.IPv6Protocol))
+
+		// The families of the local cluster are detected once
+		expectFamilies(t, r, cli, mesh, group, singleStack(corev1.IPv6Protocol))
+		if gets != 1 {
+			t.Errorf("expected the API server's service to be read once, got %d reads", gets)
+		}
+	})
+
+	t.Run("RemoteCluster", func(t *testing.T) {
+		local := fake.NewClientBuilder().WithScheme(scheme).WithObjects(apiserver(corev1.IPv4Protocol)).Build()
+		var gets int
+		remote := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(apiserver(corev1.IPv6Protocol)).
+			WithInterceptorFuncs(rejectDualStack(&gets)).
+			Build()
+		r := &NodeGroupReconciler{Client: local, Namespaced: true}
+		group := group.DeepCopy()
+		group.Spec.Cluster.Kubeconfig = &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "kubeconfig"},
+			Key:                  "config",
+		}
+		// Remote clusters are detected on every call, even when namespaced,
+		// and do not populate the local cache
+		expectFamilies(t, r, remote, mesh, group, singleStack(corev1.IPv6Protocol))
+		expectFamilies(t, r, remote, mesh, group, singleStack(corev1.IPv6Protocol))
+		if gets != 2 {
+			t.Errorf("expected the remote API server's service to be read twice, got %d reads", gets)
+		}
+		if r.ipFamilies != nil {
+			t.Errorf("expected the local families to stay undetected, got %+v", *r.ipFamilies)
+		}
+	})
+
+	t.Run("Namespaced", func(t *testing.T) {
+		var gets int
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(apiserver(corev1.IPv6Protocol)).
+			WithInterceptorFuncs(rejectDualStack(&gets)).
+			Build()
+		r := &NodeGroupReconciler{Client: cli, Namespaced: true}
+		expectFamilies(t, r, cli, mesh, group, meshv1.ServiceIPFamilies{})
+		if gets != 0 {
+			t.Errorf("expected the API server's service not to be read, got %d reads", gets)
+		}
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(context.Context, client.WithWatch, client.ObjectKey, client.Object, ...client.GetOption) error {
+					return apierrors.NewForbidden(schema.GroupResource{Resource: "services"}, "kubernetes", errors.New("denied"))
+				},
+			}).
+			Build()
+		r := &NodeGroupReconciler{Client: cli}
+		expectFamilies(t, r, cli, mesh, group, meshv1.ServiceIPFamilies{})
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for name, cli := range map[string]client.Client{
+			"MissingService": fake.NewClientBuilder().WithScheme(scheme).Build(),
+			"NoFamilies":     fake.NewClientBuilder().WithScheme(scheme).WithObjects(apiserver()).Build(),
+			"ProbeFailure": fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(apiserver(corev1.IPv4Protocol)).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Create: func(context.Context, client.WithWatch, client.Object, ...client.CreateOption) error {
+						return errors.New("connection refused")
+					},
+				}).
+				Build(),
+		} {
+			t.Run(name, func(t *testing.T) {
+				r := &NodeGroupReconciler{Client: cli}
+				if _, err := r.getServiceIPFamilies(context.Background(), cli, mesh, group); err == nil {
+					t.Fatal("expected detection to fail")
+				}
+				// Failures are retried rather than cached
+				if r.ipFamilies != nil {
+					t.Errorf("expected no families to be cached, got %+v", *r.ipFamilies)
+				}
+			})
+		}
+	})
+}
+
+func TestReconcileNodeTLSInjection(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", Generation: 1},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: pointer(int32(2)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	sts := resources.NewNodeGroupStatefulSet(mesh, group.DeepCopy(), "", "checksum")
+	replicas := resources.StatefulSetReplicas(sts)
+	mutated, missed := replicas[0].(*corev1.Pod), replicas[1].(*corev1.Pod)
+	if err := resources.InjectNodeTLSSecret(mutated); err != nil {
+		t.Fatal(err)
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(group, mutated, missed).WithStatusSubresource(group).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+
+	// The pod created without the webhook is reported once
+	for i := 0; i < 2; i++ {
+		if err := r.reconcileNodeTLSInjection(ctx, cli, mesh, group); err == nil {
+			t.Fatal("expected an error while a pod is missing its certificate secret")
+		}
+	}
+	cond := meta.FindStatusCondition(group.Status.Conditions, meshv1.NodeTLSInjectionFailedCondition)
+	if cond == nil || cond.Status != metav1.ConditionTrue || !strings.Contains(cond.Message, missed.GetName()) ||
+		strings.Contains(cond.Message, mutated.GetName()) {
+		t.Fatalf("expected only %s to be reported, got %+v", missed.GetName(), cond)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a single warning event, got %d", len(recorder.Events))
+	}
+
+	if err := resources.InjectNodeTLSSecret(missed); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Update(ctx, missed); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileNodeTLSInjection(ctx, cli, mesh, group); err != nil {
+		t.Fatalf("expected no error once every pod projects its secret, got %v", err)
+	}
+	if meta.IsStatusConditionTrue(group.Status.Conditions, meshv1.NodeTLSInjectionFailedCondition) {
+		t.Error("expected the condition to be cleared")
+	}
+}
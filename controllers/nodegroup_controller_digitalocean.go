@@ -0,0 +1,337 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/digitalocean/godo"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+const (
+	// digitalOceanGroupTagPrefix prefixes the tag holding the UID of the
+	// node group of a droplet.
+	digitalOceanGroupTagPrefix = "webmesh-nodegroup:"
+	// digitalOceanChecksumTagPrefix prefixes the tag holding the checksum of
+	// the cloud config of a droplet. Tags may not contain slashes, so the
+	// checksum annotation cannot be used as is.
+	digitalOceanChecksumTagPrefix = "webmesh-checksum:"
+)
+
+// digitalOceanProvider deploys node groups to DigitalOcean droplets.
+type digitalOceanProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r digitalOceanProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Bound the droplets being changed at once across all groups
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderDigitalOcean)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer release()
+
+	droplets, err := r.getDigitalOceanClient(ctx, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	spec := group.Spec.DigitalOcean
+
+	// Build the nodeconfig
+	joinServer, err := getJoinServer(ctx, r.Client, mesh, group)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("load balancer not ready, requeueing")
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: time.Second * 3,
+			}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("get join server: %w", err)
+	}
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("merge node group config: %w", err)
+	}
+	users, err := getSSHUsers(ctx, r.Client, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	existing, err := listDigitalOceanDroplets(ctx, droplets, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	byName := make(map[string][]godo.Droplet, len(existing))
+	for _, droplet := range existing {
+		byName[droplet.Name] = append(byName[droplet.Name], droplet)
+	}
+	var running int
+	for i := 0; i < int(group.Replicas()); i++ {
+		current := byName[fmt.Sprintf("%s-%d", group.GetName(), i)]
+		if len(current) == 1 && digitalOceanDropletRunning(&current[0]) {
+			running++
+		}
+	}
+	rollout := newInstanceRollout(int(group.Replicas()), running)
+
+	// Loop over replicas and ensure each droplet
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := fmt.Sprintf("%s-%d", group.GetName(), i)
+		current := byName[name]
+		delete(byName, name)
+
+		secret, err := r.getNodeCertificateSecret(ctx, mesh, group, i)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// Build the node and cloud configs
+		opts := digitalOceanNodeConfigOptions(mesh, group, joinServer)
+		opts.ClusterDomain = operatorconfig.FromContext(ctx).ClusterDomain
+		nodeconf, err := nodeconfig.New(opts)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build node config: %w", err)
+		}
+		env, err := getConfigSecrets(ctx, r.Client, group.GetNamespace(), nodeconf)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		cloudconf, err := cloudconfig.New(cloudconfig.Options{
+			Image:   group.Spec.Image,
+			Config:  nodeconf,
+			TLSCert: secret.Data[corev1.TLSCertKey],
+			TLSKey:  secret.Data[corev1.TLSPrivateKeyKey],
+			CA:      secret.Data[cmmeta.TLSCAKey],
+			// Droplets forward traffic once it is enabled in the kernel,
+			// add the rules needed to masquerade egress traffic.
+			DefaultGateway: groupcfg.AdvertisesDefaultGateway(),
+			Env:            env,
+			Users:          users,
+			OSFamily:       group.Spec.OSFamily(),
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build cloud config: %w", err)
+		}
+		checksum := cloudconf.Checksum()
+
+		// Ensure the droplet
+		if len(current) == 1 {
+			deployed := digitalOceanChecksum(&current[0])
+			if resolveConfigChecksum(ctx, group, deployed, checksum) == deployed {
+				log.Info("Config checksum has not changed, skipping droplet", "name", name, "droplet", current[0].ID)
+				continue
+			}
+		}
+		if len(current) > 0 {
+			if !rollout.Replace(len(current) == 1 && digitalOceanDropletRunning(&current[0])) {
+				log.Info("Waiting for replaced droplets to run before replacing droplet", "name", name)
+				continue
+			}
+			// Delete the droplets and recreate them
+			log.Info("Config checksum has changed, deleting droplet", "name", name)
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstance",
+				"Replacing droplet %s after its config changed", name)
+			if err := deleteDigitalOceanDroplets(ctx, droplets, current); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("Creating droplet", "name", name)
+		if simulate(ctx, "create droplet", "name", name) {
+			continue
+		}
+		_, _, err = droplets.Create(ctx, &godo.DropletCreateRequest{
+			Name:     name,
+			Region:   spec.Region,
+			Size:     spec.Size,
+			Image:    digitalOceanImage(spec.Image),
+			VPCUUID:  spec.VPCUUID,
+			IPv6:     !groupcfg.NoIPv6,
+			Tags:     digitalOceanDropletTags(group, checksum),
+			UserData: string(cloudconf.Raw()),
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("create droplet: %w", err)
+		}
+	}
+
+	// Delete the droplets of removed replicas
+	for name, current := range byName {
+		log.Info("Deleting droplet of removed replica", "name", name)
+		if err := deleteDigitalOceanDroplets(ctx, droplets, current); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return rollout.Result(), nil
+}
+
+// Delete implements providers.Provider.
+func (r digitalOceanProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderDigitalOcean)
+	if err != nil {
+		return err
+	}
+	defer release()
+	droplets, err := r.getDigitalOceanClient(ctx, group)
+	if err != nil {
+		return err
+	}
+	existing, err := listDigitalOceanDroplets(ctx, droplets, group)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Deleting node group droplets", "count", len(existing))
+	return deleteDigitalOceanDroplets(ctx, droplets, existing)
+}
+
+// listDigitalOceanDroplets returns the droplets tagged for the group.
+func listDigitalOceanDroplets(ctx context.Context, droplets godo.DropletsService, group *meshv1.NodeGroup) ([]godo.Droplet, error) {
+	var out []godo.Droplet
+	opts := &godo.ListOptions{PerPage: 200}
+	for {
+		page, resp, err := droplets.ListByTag(ctx, digitalOceanGroupTag(group), opts)
+		if err != nil {
+			return nil, fmt.Errorf("list droplets: %w", err)
+		}
+		out = append(out, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return out, nil
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("list droplets: %w", err)
+		}
+		opts.Page = current + 1
+	}
+}
+
+// deleteDigitalOceanDroplets deletes the given droplets. Droplets that are
+// already gone are skipped.
+func deleteDigitalOceanDroplets(ctx context.Context, droplets godo.DropletsService, toDelete []godo.Droplet) error {
+	for _, droplet := range toDelete {
+		if simulate(ctx, "delete droplet", "name", droplet.Name, "droplet", droplet.ID) {
+			continue
+		}
+		if _, err := droplets.Delete(ctx, droplet.ID); err != nil && !isDigitalOceanNotFound(err) {
+			return fmt.Errorf("delete droplet: %w", err)
+		}
+	}
+	return nil
+}
+
+// isDigitalOceanNotFound returns true if the error is a response for a
+// resource that does not exist.
+func isDigitalOceanNotFound(err error) bool {
+	var errResp *godo.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
+
+// digitalOceanImage returns the image to create droplets from. Custom images
+// have no slug and are referenced by their ID.
+func digitalOceanImage(image string) godo.DropletCreateImage {
+	if id, err := strconv.Atoi(image); err == nil {
+		return godo.DropletCreateImage{ID: id}
+	}
+	return godo.DropletCreateImage{Slug: image}
+}
+
+// digitalOceanGroupTag returns the tag of the droplets of the group. Names may
+// hold characters that are not allowed in tags, so the UID of the group is
+// used.
+func digitalOceanGroupTag(group *meshv1.NodeGroup) string {
+	return digitalOceanGroupTagPrefix + string(group.GetUID())
+}
+
+// digitalOceanDropletRunning returns true if the droplet is active.
+func digitalOceanDropletRunning(droplet *godo.Droplet) bool {
+	return droplet.Status == "active"
+}
+
+// digitalOceanChecksum returns the checksum of the cloud config the droplet
+// was created with.
+func digitalOceanChecksum(droplet *godo.Droplet) string {
+	for _, tag := range droplet.Tags {
+		if strings.HasPrefix(tag, digitalOceanChecksumTagPrefix) {
+			return strings.TrimPrefix(tag, digitalOceanChecksumTagPrefix)
+		}
+	}
+	return ""
+}
+
+// digitalOceanDropletTags returns the tags of the group's droplets. The
+// checksum of their cloud config is recorded so changes recreate them.
+func digitalOceanDropletTags(group *meshv1.NodeGroup, checksum string) []string {
+	tags := make([]string, 0, len(group.Spec.DigitalOcean.Tags)+2)
+	tags = append(tags, group.Spec.DigitalOcean.Tags...)
+	return append(tags,
+		digitalOceanGroupTag(group),
+		digitalOceanChecksumTagPrefix+checksum,
+	)
+}
+
+// digitalOceanNodeConfigOptions returns the node config options for the
+// replicas of a DigitalOcean node group. The public addresses of a droplet are
+// on its interface, so they are detected locally.
+func digitalOceanNodeConfigOptions(mesh *meshv1.Mesh, group *meshv1.NodeGroup, joinServer string) nodeconfig.Options {
+	return nodeconfig.Options{
+		Mesh:            mesh,
+		Group:           group,
+		JoinServer:      joinServer,
+		IsPersistent:    true,
+		CertDir:         meshv1.DefaultTLSDirectory,
+		DetectEndpoints: true,
+		DetectIPv6:      true,
+	}
+}
+
+// getDigitalOceanClient returns a client for the DigitalOcean API using the
+// token in the group's secret.
+func (r *NodeGroupReconciler) getDigitalOceanClient(ctx context.Context, group *meshv1.NodeGroup) (godo.DropletsService, error) {
+	ref := group.Spec.DigitalOcean.Token
+	var secret corev1.Secret
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      ref.Name,
+		Namespace: group.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("get digitalocean token secret: %w", err)
+	}
+	token, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("no key %s in secret %s/%s", ref.Key, group.GetNamespace(), ref.Name)
+	}
+	return godo.NewFromToken(strings.TrimSpace(string(token))).Droplets, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeDroplets serves pages of droplets and records the droplets deleted.
+// Droplets with a negative ID are already gone.
+type fakeDroplets struct {
+	godo.DropletsService
+	pages   [][]godo.Droplet
+	tags    []string
+	deleted []int
+}
+
+func (f *fakeDroplets) ListByTag(ctx context.Context, tag string, opts *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+	f.tags = append(f.tags, tag)
+	page := opts.Page
+	if page == 0 {
+		page = 1
+	}
+	pages := &godo.Pages{}
+	if page > 1 {
+		pages.Prev = fmt.Sprintf("https://api.digitalocean.com/v2/droplets?page=%d", page-1)
+	}
+	if page < len(f.pages) {
+		pages.Next = fmt.Sprintf("https://api.digitalocean.com/v2/droplets?page=%d", page+1)
+	}
+	return f.pages[page-1], &godo.Response{Links: &godo.Links{Pages: pages}}, nil
+}
+
+func (f *fakeDroplets) Delete(ctx context.Context, id int) (*godo.Response, error) {
+	if id < 0 {
+		resp := &http.Response{StatusCode: http.StatusNotFound, Request: &http.Request{}}
+		return &godo.Response{Response: resp}, &godo.ErrorResponse{Response: resp, Message: "not found"}
+	}
+	f.deleted = append(f.deleted, id)
+	return &godo.Response{}, nil
+}
+
+func TestListDigitalOceanDroplets(t *testing.T) {
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", UID: "uid"}}
+	droplets := &fakeDroplets{pages: [][]godo.Droplet{
+		{{ID: 1, Name: "group-0"}, {ID: 2, Name: "group-1"}},
+		{{ID: 3, Name: "group-2"}},
+		{{ID: -4, Name: "group-3"}},
+	}}
+	existing, err := listDigitalOceanDroplets(context.Background(), droplets, group)
+	if err != nil {
+		t.Fatalf("list droplets: %v", err)
+	}
+	var names []string
+	for _, droplet := range existing {
+		names = append(names, droplet.Name)
+	}
+	if want := []string{"group-0", "group-1", "group-2", "group-3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected droplets %v from every page, got %v", want, names)
+	}
+	for _, tag := range droplets.tags {
+		if tag != digitalOceanGroupTag(group) {
+			t.Errorf("expected droplets to be listed by the group's tag, got %s", tag)
+		}
+	}
+
+	// Droplets that are already gone are skipped
+	if err := deleteDigitalOceanDroplets(context.Background(), droplets, existing); err != nil {
+		t.Fatalf("delete droplets: %v", err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(droplets.deleted, want) {
+		t.Errorf("expected droplets %v to be deleted, got %v", want, droplets.deleted)
+	}
+}
+
+func TestDigitalOceanImage(t *testing.T) {
+	if got := digitalOceanImage("ubuntu-22-04-x64"); got != (godo.DropletCreateImage{Slug: "ubuntu-22-04-x64"}) {
+		t.Errorf("expected a slug image, got %+v", got)
+	}
+	if got := digitalOceanImage("12345"); got != (godo.DropletCreateImage{ID: 12345}) {
+		t.Errorf("expected a custom image ID, got %+v", got)
+	}
+}
+
+func TestDigitalOceanDropletTags(t *testing.T) {
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", UID: "uid"},
+		Spec:       meshv1.NodeGroupSpec{DigitalOcean: &meshv1.NodeGroupDigitalOceanConfig{Tags: []string{"team"}}},
+	}
+	droplet := godo.Droplet{Tags: digitalOceanDropletTags(group, "abc")}
+	if want := []string{"team", "webmesh-nodegroup:uid", "webmesh-checksum:abc"}; !reflect.DeepEqual(droplet.Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, droplet.Tags)
+	}
+	if got := digitalOceanChecksum(&droplet); got != "abc" {
+		t.Errorf("expected checksum abc, got %s", got)
+	}
+}
+
+func TestDigitalOceanDropletRunning(t *testing.T) {
+	if digitalOceanDropletRunning(&godo.Droplet{Status: "new"}) {
+		t.Error("expected a new droplet not to be running")
+	}
+	if !digitalOceanDropletRunning(&godo.Droplet{Status: "active"}) {
+		t.Error("expected an active droplet to be running")
+	}
+}
@@ -18,9 +18,13 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,67 +32,96 @@ import (
 	"cloud.google.com/go/compute/apiv1/computepb"
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/option"
+	"google.golang.org/api/iterator"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
 	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/fairness"
 	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
 )
 
-func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+// googleCloudProvider deploys node groups to Google Cloud instances.
+type googleCloudProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r googleCloudProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
-	opts, err := r.getGoogleClientOptions(ctx, group)
+	// Wait for the certificates of every replica before making any changes,
+	// so a missing one does not leave the instances half updated
+	secrets, missing, err := r.getNodeCertificateSecrets(ctx, mesh, group)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	// Create clients
-	images, err := compute.NewImageFamilyViewsRESTClient(ctx, opts...)
+	if len(missing) > 0 {
+		log.Info("Waiting for node certificates to be issued", "replicas", missing)
+		return ctrl.Result{RequeueAfter: certificatesPendingInterval}, nil
+	}
+
+	// Bound the instances being changed at once across all groups
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderGoogleCloud)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("create compute images client: %w", err)
+		return ctrl.Result{}, err
 	}
-	defer images.Close()
-	subnets, err := compute.NewSubnetworksRESTClient(ctx, opts...)
+	defer release()
+
+	clients, err := r.getGoogleCloudClients(ctx, group)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("create compute subnetworks client: %w", err)
+		return ctrl.Result{}, err
 	}
-	defer subnets.Close()
-	instances, err := compute.NewInstancesRESTClient(ctx, opts...)
+	instances, err := clients.Instances()
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("create compute instances client: %w", err)
+		return ctrl.Result{}, err
 	}
-	defer instances.Close()
 
 	spec := group.Spec.GoogleCloud
 
-	// Fetch the latest ubuntu boot image
-	bootImage, err := images.Get(ctx, &computepb.GetImageFamilyViewRequest{
-		Family:  "ubuntu-2204-lts",
-		Project: "ubuntu-os-cloud",
-		Zone:    spec.Zone,
-	})
+	// Determine if the instances should be running
+	schedule, err := getScheduleStatus(group, time.Now())
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("get latest ubuntu image: %w", err)
+		return ctrl.Result{}, err
+	}
+	suspended := schedule != nil && schedule.State == meshv1.ScheduleStateSuspended
+
+	// Resolve the boot image
+	bootImage, err := googleCloudBootImage(ctx, spec, clients)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	// Fetch the subnet
-	subnet, err := subnets.Get(ctx, &computepb.GetSubnetworkRequest{
-		Project: spec.ProjectID,
-		Region: func() string {
-			if spec.Region != "" {
-				return spec.Region
-			}
-			zone := strings.Split(spec.Zone, "-")
-			return strings.Join(zone[:len(zone)-1], "-")
-		}(),
-		Subnetwork: spec.Subnetwork,
-	})
+	subnet, err := clients.Subnetwork(ctx, spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	nic, ipv6, err := newGoogleCloudNetworkInterface(spec, subnet)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("get subnet: %w", err)
+		return ctrl.Result{}, err
+	}
+
+	// Reserve the static addresses of the replicas
+	var addresses *compute.AddressesClient
+	var static map[int]googleCloudStaticAddresses
+	var reserved []string
+	if spec.StaticIPs || len(spec.Addresses) > 0 || len(group.Status.ReservedAddresses) > 0 {
+		addresses, err = clients.Addresses()
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		static, reserved, err = reserveGoogleCloudAddresses(ctx, addresses, mesh, group, subnet, ipv6, r.recordReservedAddress(group))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	// Build the nodeconfig
@@ -103,52 +136,79 @@ func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context,
 		}
 		return ctrl.Result{}, fmt.Errorf("get join server: %w", err)
 	}
-	nodeconf, err := nodeconfig.New(nodeconfig.Options{
-		Mesh:                 mesh,
-		Group:                group,
-		JoinServer:           joinServer,
-		IsPersistent:         true,
-		CertDir:              meshv1.DefaultTLSDirectory,
-		DetectEndpoints:      true,
-		AllowRemoteDetection: true,
-	})
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("merge node group config: %w", err)
+	}
+	files, err := r.getFileSecrets(ctx, group)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("build node config: %w", err)
+		return ctrl.Result{}, err
+	}
+	users, err := getSSHUsers(ctx, r.Client, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	hostAliases, err := r.getHostAliases(ctx, mesh, group)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("host alias load balancer not ready, requeueing")
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: time.Second * 3,
+			}, nil
+		}
+		return ctrl.Result{}, err
 	}
 
-	// Loop over replicas and ensure each instance
-	for i := 0; i < int(*group.Spec.Replicas); i++ {
-		name := fmt.Sprintf("%s-%d", group.GetName(), i)
+	// Instances only learn the versions of the secrets holding their TLS
+	// material when it is stored in Secret Manager
+	var secretVersions map[int]googleCloudSecretVersions
+	if spec.UsesSecretManager() {
+		secretVersions, err = syncGoogleCloudSecrets(ctx, clients, mesh, group, secrets)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
-		// Get the certificate secret for this node
-		var secret corev1.Secret
-		err = r.Get(ctx, client.ObjectKey{
-			Name:      meshv1.MeshNodeCertName(mesh, group, i),
-			Namespace: group.GetNamespace(),
-		}, &secret)
+	shared := googleCloudSharedConfig{
+		joinServer:     joinServer,
+		nic:            nic,
+		hostAliases:    hostAliases,
+		groupcfg:       groupcfg,
+		files:          files,
+		users:          users,
+		secretVersions: secretVersions,
+		static:         static,
+	}
+	if spec.UseManagedInstanceGroup {
+		return r.reconcileManagedInstanceGroup(ctx, mesh, group, clients, bootImage, shared, secrets)
+	}
+
+	// Instances whose config changed are replaced in order, without taking
+	// down more than the allowed number of replicas at once
+	existing, err := listGoogleCloudInstances(ctx, instances, mesh, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var available map[int]bool
+	var unavailable int
+	var rolling, changed bool
+	seen := make([]string, 0, group.Replicas())
+
+	// Loop over replicas and ensure each instance
+	for i := 0; i < int(group.Replicas()); i++ {
+		name, err := group.GoogleCloudInstanceName(i)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("get node certificate secret: %w", err)
-		}
-		for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
-			if _, ok := secret.Data[key]; !ok {
-				return ctrl.Result{
-					Requeue:      true,
-					RequeueAfter: time.Second * 3,
-				}, fmt.Errorf("node certificate secret missing key %q", key)
-			}
+			return ctrl.Result{}, fmt.Errorf("render instance name: %w", err)
 		}
-		// Build the cloud config
-		cloudconf, err := cloudconfig.New(cloudconfig.Options{
-			Image:   group.Spec.Image,
-			Config:  nodeconf,
-			TLSCert: secret.Data[corev1.TLSCertKey],
-			TLSKey:  secret.Data[corev1.TLSPrivateKeyKey],
-			CA:      secret.Data[cmmeta.TLSCAKey],
-		})
+
+		// Build the node and cloud configs
+		cloudconf, err := r.replicaCloudConfig(ctx, mesh, group, shared, secrets[i], i)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("build cloud config: %w", err)
+			return ctrl.Result{}, err
 		}
-		description := fmt.Sprintf("%s %s", name, cloudconf.Checksum())
+		checksum := googleCloudInstanceChecksum(spec, static[i], cloudconf.Checksum())
+		description := fmt.Sprintf("%s %s", name, checksum)
 
 		// Ensure the instance
 		instance, err := instances.Get(ctx, &computepb.GetInstanceRequest{
@@ -157,10 +217,39 @@ func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context,
 			Instance: name,
 		})
 		if err == nil {
+			seen = append(seen, name)
+			if suspended {
+				changed = changed || instance.GetStatus() != "TERMINATED"
+				if err := stopGoogleCloudInstance(ctx, instances, spec, instance); err != nil {
+					return ctrl.Result{}, err
+				}
+				continue
+			}
 			log.Info("Node instance already exists", "name", instance.GetName())
-			if instance.GetDescription() != description {
+			deployed := strings.TrimPrefix(instance.GetDescription(), name+" ")
+			if resolveConfigChecksum(ctx, group, deployed, checksum) != deployed {
+				rolling = true
+				if available == nil {
+					available = googleCloudReplicaAvailability(group, existing, func(instance *computepb.Instance) bool {
+						return probeGoogleCloudInstance(ctx, instance)
+					})
+					unavailable = int(group.Replicas()) - len(available)
+				}
+				if available[i] && unavailable >= spec.MaxUnavailableReplicas() {
+					log.Info("Waiting for replaced instances to become available", "name", instance.GetName(), "unavailable", unavailable)
+					continue
+				}
 				// Delete the instance and recreate it
 				log.Info("Config checksum has changed, deleting instance", "name", instance.GetName())
+				if simulate(ctx, "recreate instance", "name", instance.GetName()) {
+					continue
+				}
+				r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstance",
+					"Replacing instance %s after its config changed", instance.GetName())
+				if available[i] {
+					unavailable++
+				}
+				changed = true
 				op, err := instances.Delete(ctx, &computepb.DeleteInstanceRequest{
 					Project:  spec.ProjectID,
 					Zone:     spec.Zone,
@@ -173,7 +262,37 @@ func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context,
 					return ctrl.Result{}, fmt.Errorf("wait for instance delete: %w", err)
 				}
 			} else {
-				log.Info("Config checksum has not changed, skipping instance", "name", instance.GetName())
+				if spec.RenewsCertificatesInPlace() {
+					renewed, err := r.renewGoogleCloudCertificates(ctx, instances, group, instance, cloudconf, secrets[i])
+					if err != nil {
+						return ctrl.Result{}, err
+					}
+					changed = changed || renewed
+				}
+				if instance.GetStatus() != "TERMINATED" {
+					log.Info("Config checksum has not changed, skipping instance", "name", instance.GetName())
+					continue
+				}
+				log.Info("Starting stopped instance", "name", instance.GetName())
+				if googleCloudPreempted(group, instance) {
+					r.Recorder.Eventf(group, corev1.EventTypeWarning, "InstancePreempted",
+						"Restarting instance %s after it was preempted", instance.GetName())
+				}
+				if simulate(ctx, "start instance", "name", instance.GetName()) {
+					continue
+				}
+				changed = true
+				op, err := instances.Start(ctx, &computepb.StartInstanceRequest{
+					Project:  spec.ProjectID,
+					Zone:     spec.Zone,
+					Instance: instance.GetName(),
+				})
+				if err != nil {
+					return ctrl.Result{}, fmt.Errorf("start instance: %w", err)
+				}
+				if err := op.Wait(ctx); err != nil {
+					return ctrl.Result{}, fmt.Errorf("wait for instance start: %w", err)
+				}
 				continue
 			}
 		} else {
@@ -182,6 +301,15 @@ func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context,
 			if (ok && gerr.Code != http.StatusNotFound) || !ok {
 				return ctrl.Result{}, fmt.Errorf("lookup existing instance: %w", err)
 			}
+			if slices.Contains(group.Status.Instances, name) {
+				log.Info("Instance has disappeared", "name", name)
+				r.Recorder.Eventf(group, corev1.EventTypeWarning, "InstanceLost",
+					"Instance %s has disappeared, it was likely preempted", name)
+			}
+			if suspended {
+				// The instance will be created when the group resumes
+				continue
+			}
 		}
 		log.Info("Creating instance", "name", name)
 		instanceReq := &computepb.InsertInstanceRequest{
@@ -191,49 +319,40 @@ func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context,
 				Name:         &name,
 				Description:  &description,
 				MachineType:  pointer(fmt.Sprintf("zones/%s/machineTypes/%s", spec.Zone, spec.MachineType)),
-				Labels:       map[string]string{"mesh": mesh.GetName(), "group": group.GetName()},
+				Labels:       googleCloudInstanceLabels(mesh, group, i),
 				CanIpForward: pointer(true),
 				AdvancedMachineFeatures: &computepb.AdvancedMachineFeatures{
 					EnableUefiNetworking: pointer(true),
 				},
+				Scheduling: googleCloudScheduling(spec),
 				Disks: []*computepb.AttachedDisk{
 					{
-						Boot:       pointer(true),
-						AutoDelete: pointer(true),
-						InitializeParams: &computepb.AttachedDiskInitializeParams{
-							SourceImage: bootImage.Image.SelfLink,
-						},
+						Boot:             pointer(true),
+						AutoDelete:       pointer(true),
+						InitializeParams: googleCloudBootDisk(spec, bootImage),
 					},
 				},
 				Metadata: &computepb.Metadata{
-					Items: []*computepb.Items{
-						{
-							Key:   pointer("user-data"),
-							Value: pointer(string(cloudconf.Raw())),
-						},
-					},
-				},
-				NetworkInterfaces: []*computepb.NetworkInterface{
-					{
-						Subnetwork: subnet.SelfLink,
-						StackType:  pointer("IPV4_IPV6"),
-						AccessConfigs: []*computepb.AccessConfig{{
-							Name: pointer("wanv4"),
-						}},
-						Ipv6AccessConfigs: []*computepb.AccessConfig{
-							{
-								Name:        pointer("wanv6"),
-								Type:        pointer("DIRECT_IPV6"),
-								NetworkTier: pointer("PREMIUM"),
-							},
-						},
-					},
+					Items: googleCloudInstanceMetadata(spec, cloudconf, secrets[i]),
 				},
+				NetworkInterfaces: []*computepb.NetworkInterface{googleCloudReplicaInterface(nic, static[i])},
 				Tags: &computepb.Tags{
 					Items: spec.Tags,
 				},
 			},
 		}
+		if spec.ServiceAccountEmail != "" {
+			instanceReq.InstanceResource.ServiceAccounts = []*computepb.ServiceAccount{
+				{
+					Email:  &spec.ServiceAccountEmail,
+					Scopes: spec.ServiceAccountScopes(),
+				},
+			}
+		}
+		if simulate(ctx, "create instance", "name", name) {
+			continue
+		}
+		changed = true
 		op, err := instances.Insert(ctx, instanceReq)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("create instance: %w", err)
@@ -242,72 +361,1000 @@ func (r *NodeGroupReconciler) reconcileGoogleCloudNodeGroup(ctx context.Context,
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("wait for instance creation: %w", err)
 		}
+		seen = append(seen, name)
+	}
+
+	// Check on the rollout until every replica runs the current config, and
+	// destroy the secret versions of earlier configs once it does
+	var res ctrl.Result
+	if rolling {
+		res.RequeueAfter = googleCloudRolloutInterval
+	} else if secretVersions != nil && !suspended {
+		if err := destroySupersededGoogleCloudSecretVersions(ctx, clients, secretVersions); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
-	return ctrl.Result{}, nil
+	// Delete the instances of removed replicas
+	orphans := googleCloudOrphans(group, existing)
+	if len(orphans) > 0 {
+		log.Info("Deleting instances of removed replicas", "count", len(orphans))
+		busy, err := deleteGoogleCloudInstances(ctx, instances, spec, orphans)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if busy && (res.RequeueAfter == 0 || googleCloudBusyRetryInterval < res.RequeueAfter) {
+			res.RequeueAfter = googleCloudBusyRetryInterval
+		}
+	}
+
+	// Look up the instances again if any were changed to record their
+	// current addresses and state, and check on them until they settled
+	if changed {
+		existing, err = listGoogleCloudInstances(ctx, instances, mesh, group)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	details := googleCloudInstanceDetails(group, existing)
+	if googleCloudInstancesSettling(details) && (res.RequeueAfter == 0 || googleCloudRolloutInterval < res.RequeueAfter) {
+		res.RequeueAfter = googleCloudRolloutInterval
+	}
+
+	// Release the addresses no longer reserved once their instances are gone
+	if addresses != nil {
+		remaining, busy, err := releaseGoogleCloudAddresses(ctx, addresses, spec, staleGoogleCloudAddresses(group, reserved))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		reserved = append(reserved, remaining...)
+		if busy && (res.RequeueAfter == 0 || googleCloudBusyRetryInterval < res.RequeueAfter) {
+			res.RequeueAfter = googleCloudBusyRetryInterval
+		}
+	}
+
+	// Record the instances, addresses and schedule state, and requeue at the
+	// next transition
+	if !equality.Semantic.DeepEqual(group.Status.Schedule, schedule) ||
+		!slices.Equal(group.Status.Instances, seen) ||
+		!equality.Semantic.DeepEqual(group.Status.InstanceDetails, details) ||
+		!slices.Equal(group.Status.ReservedAddresses, reserved) {
+		group.Status.Schedule = schedule
+		group.Status.Instances = seen
+		if len(seen) == 0 {
+			group.Status.Instances = nil
+		}
+		group.Status.InstanceDetails = details
+		group.Status.ReservedAddresses = reserved
+		if err := r.Status().Update(ctx, group); err != nil {
+			return ctrl.Result{}, fmt.Errorf("update instance status: %w", err)
+		}
+	}
+	return requeueForSchedule(res, schedule, time.Now()), nil
+}
+
+// requeueForSchedule returns the result requeued for the next transition of
+// the schedule, unless it is already requeued sooner.
+func requeueForSchedule(res ctrl.Result, schedule *meshv1.NodeGroupScheduleStatus, now time.Time) ctrl.Result {
+	if schedule == nil || schedule.NextTransition == nil {
+		return res
+	}
+	next := schedule.NextTransition.Sub(now) + time.Second
+	if res.RequeueAfter == 0 || next < res.RequeueAfter {
+		res.RequeueAfter = next
+	}
+	return res
+}
+
+// googleCloudSharedConfig are the inputs shared by the cloud configs of the
+// replicas of a group.
+type googleCloudSharedConfig struct {
+	joinServer  string
+	nic         *computepb.NetworkInterface
+	hostAliases []cloudconfig.HostAlias
+	groupcfg    *meshv1.NodeGroupConfig
+	files       []cloudconfig.File
+	users       []cloudconfig.User
+	// secretVersions are the Secret Manager versions holding the TLS
+	// material of the replicas, if it is not part of their user-data.
+	secretVersions map[int]googleCloudSecretVersions
+	// static are the static external addresses of the replicas.
+	static map[int]googleCloudStaticAddresses
+}
+
+// replicaCloudConfig builds the cloud config of the replica with the given
+// ordinal from its certificate secret.
+func (r googleCloudProvider) replicaCloudConfig(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, shared googleCloudSharedConfig, secret *corev1.Secret, ordinal int) (*cloudconfig.Config, error) {
+	opts, err := googleCloudNodeConfigOptions(mesh, group, shared.joinServer, shared.nic, shared.hostAliases, shared.static[ordinal], ordinal)
+	if err != nil {
+		return nil, err
+	}
+	opts.ClusterDomain = operatorconfig.FromContext(ctx).ClusterDomain
+	nodeconf, err := nodeconfig.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("build node config: %w", err)
+	}
+	env, err := getConfigSecrets(ctx, r.Client, group.GetNamespace(), nodeconf)
+	if err != nil {
+		return nil, err
+	}
+	cloudconf, err := cloudconfig.New(cloudconfig.Options{
+		Image:        group.Spec.Image,
+		Config:       nodeconf,
+		TLSCert:      secret.Data[corev1.TLSCertKey],
+		TLSKey:       secret.Data[corev1.TLSPrivateKeyKey],
+		CA:           secret.Data[cmmeta.TLSCAKey],
+		SecretsFetch: googleCloudSecretsFetch(group.Spec.GoogleCloud, shared.secretVersions[ordinal]),
+		// Forwarding is already enabled on the instance, add the
+		// rules needed to masquerade egress traffic.
+		DefaultGateway:   shared.groupcfg.AdvertisesDefaultGateway(),
+		Files:            shared.files,
+		Env:              env,
+		CommandEnv:       googleCloudCommandEnv(group.Spec.GoogleCloud),
+		HostAliases:      shared.hostAliases,
+		DockerBridgeCIDR: group.Spec.GoogleCloud.DockerBridgeCIDR,
+		ExtraFiles:       googleCloudExtraFiles(group.Spec.GoogleCloud),
+		ExtraPackages:    group.Spec.GoogleCloud.ExtraPackages,
+		ExtraRunCmd:      group.Spec.GoogleCloud.ExtraRunCmd,
+		Users:            shared.users,
+		OSFamily:         group.Spec.OSFamily(),
+		SecretsRefresh:   googleCloudSecretsRefresh(group.Spec.GoogleCloud),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("build cloud config: %w", err)
+	}
+	return cloudconf, nil
 }
 
-func (r *NodeGroupReconciler) deleteGoogleCloudNodeGroup(ctx context.Context, group *meshv1.NodeGroup) error {
+// googleCloudExtraFiles returns the extra files written to the instances of
+// a group.
+func googleCloudExtraFiles(spec *meshv1.NodeGroupGoogleCloudConfig) []cloudconfig.File {
+	if len(spec.ExtraFiles) == 0 {
+		return nil
+	}
+	files := make([]cloudconfig.File, 0, len(spec.ExtraFiles))
+	for _, file := range spec.ExtraFiles {
+		perms := file.Permissions
+		if perms == "" {
+			perms = "0644"
+		}
+		files = append(files, cloudconfig.File{
+			Path:        file.Path,
+			Permissions: perms,
+			Content:     []byte(file.Content),
+		})
+	}
+	return files
+}
+
+// getScheduleStatus returns the desired schedule state of the group at the
+// given time, or nil if the group has no schedule or override.
+func getScheduleStatus(group *meshv1.NodeGroup, now time.Time) (*meshv1.NodeGroupScheduleStatus, error) {
+	if override, ok := group.GetAnnotations()[meshv1.ScheduleOverrideAnnotation]; ok {
+		state := meshv1.ScheduleState(override)
+		if state != meshv1.ScheduleStateRunning && state != meshv1.ScheduleStateSuspended {
+			return nil, fmt.Errorf("invalid %s annotation: %q", meshv1.ScheduleOverrideAnnotation, override)
+		}
+		return &meshv1.NodeGroupScheduleStatus{
+			State:      state,
+			Overridden: true,
+		}, nil
+	}
+	if group.Spec.GoogleCloud.Schedule == nil {
+		return nil, nil
+	}
+	active, next, err := group.Spec.GoogleCloud.Schedule.Evaluate(now)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate schedule: %w", err)
+	}
+	status := &meshv1.NodeGroupScheduleStatus{
+		State:          meshv1.ScheduleStateSuspended,
+		NextTransition: &metav1.Time{Time: next},
+	}
+	if active {
+		status.State = meshv1.ScheduleStateRunning
+	}
+	return status, nil
+}
+
+// googleCloudRegion returns the region of the group, which defaults to the
+// region of its zone.
+func googleCloudRegion(spec *meshv1.NodeGroupGoogleCloudConfig) string {
+	if spec.Region != "" {
+		return spec.Region
+	}
+	zone := strings.Split(spec.Zone, "-")
+	return strings.Join(zone[:len(zone)-1], "-")
+}
+
+// newGoogleCloudNetworkInterface returns the network interface of the instances
+// of a group on the given subnetwork, and whether it has an external IPv6
+// address. The interface has the stack type of the subnetwork unless one is
+// set. Unless enabled explicitly, external IPv6 addresses are only added when
+// the subnetwork has them and the premium tier is not ruled out.
+func newGoogleCloudNetworkInterface(spec *meshv1.NodeGroupGoogleCloudConfig, subnet *computepb.Subnetwork) (*computepb.NetworkInterface, bool, error) {
+	subnetStack := subnet.GetStackType()
+	if subnetStack == "" {
+		subnetStack = meshv1.GoogleCloudStackTypeIPv4Only
+	}
+	stackType := spec.StackType
+	if stackType == "" {
+		stackType = subnetStack
+	}
+	// Dual-stack subnetworks host instances of any stack type
+	if stackType != subnetStack && subnetStack != meshv1.GoogleCloudStackTypeDualStack {
+		return nil, false, fmt.Errorf("subnetwork %s does not support stack type %s", subnet.GetName(), stackType)
+	}
+	externalIPv6 := stackType != meshv1.GoogleCloudStackTypeIPv4Only && subnet.GetIpv6AccessType() == "EXTERNAL"
+	ipv6 := externalIPv6 && spec.NetworkTier != meshv1.GoogleCloudNetworkTierStandard && !spec.InternalOnly
+	if spec.EnableIPv6 != nil {
+		if *spec.EnableIPv6 && !externalIPv6 {
+			return nil, false, fmt.Errorf("subnetwork %s does not have external IPv6 addresses", subnet.GetName())
+		}
+		ipv6 = *spec.EnableIPv6
+	}
+	nic := &computepb.NetworkInterface{
+		Subnetwork: subnet.SelfLink,
+		StackType:  pointer(stackType),
+	}
+	if spec.ExternalIPv4() && stackType != meshv1.GoogleCloudStackTypeIPv6Only {
+		access := &computepb.AccessConfig{Name: pointer("wanv4")}
+		if spec.NetworkTier != "" {
+			access.NetworkTier = pointer(spec.NetworkTier)
+		}
+		nic.AccessConfigs = []*computepb.AccessConfig{access}
+	}
+	if ipv6 {
+		nic.Ipv6AccessConfigs = []*computepb.AccessConfig{{
+			Name:        pointer("wanv6"),
+			Type:        pointer("DIRECT_IPV6"),
+			NetworkTier: pointer(meshv1.GoogleCloudNetworkTierPremium),
+		}}
+	}
+	return nic, ipv6, nil
+}
+
+// stopGoogleCloudInstance stops the given instance if it is running. Disks
+// and static addresses are preserved.
+func stopGoogleCloudInstance(ctx context.Context, instances *compute.InstancesClient, spec *meshv1.NodeGroupGoogleCloudConfig, instance *computepb.Instance) error {
+	switch instance.GetStatus() {
+	case "STOPPING", "TERMINATED":
+		return nil
+	}
+	log.FromContext(ctx).Info("Stopping instance for schedule", "name", instance.GetName())
+	if simulate(ctx, "stop instance", "name", instance.GetName()) {
+		return nil
+	}
+	op, err := instances.Stop(ctx, &computepb.StopInstanceRequest{
+		Project:  spec.ProjectID,
+		Zone:     spec.Zone,
+		Instance: instance.GetName(),
+	})
+	if err != nil {
+		return fmt.Errorf("stop instance: %w", err)
+	}
+	if err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("wait for instance stop: %w", err)
+	}
+	return nil
+}
+
+// Delete implements providers.Provider.
+func (r googleCloudProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
 	spec := group.Spec.GoogleCloud
-	opts, err := r.getGoogleClientOptions(ctx, group)
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderGoogleCloud)
 	if err != nil {
-		return fmt.Errorf("get google client options: %w", err)
+		return err
 	}
-	instances, err := compute.NewInstancesRESTClient(ctx, opts...)
+	defer release()
+	clients, err := r.getGoogleCloudClients(ctx, group)
 	if err != nil {
-		return fmt.Errorf("create compute instances client: %w", err)
+		return err
 	}
-	defer instances.Close()
-	for i := 0; i < int(*group.Spec.Replicas); i++ {
-		name := fmt.Sprintf("%s-%d", group.GetName(), i)
-		// Check if the instance already exists
-		instance, err := instances.Get(ctx, &computepb.GetInstanceRequest{
+	var mesh meshv1.Mesh
+	mesh.SetName(group.MeshKey().Name)
+	mesh.SetNamespace(group.MeshKey().Namespace)
+	if spec.UseManagedInstanceGroup {
+		if err := deleteManagedInstanceGroup(ctx, clients, group); err != nil {
+			return err
+		}
+		if spec.UsesSecretManager() {
+			return deleteGroupGoogleCloudSecrets(ctx, clients, &mesh, group)
+		}
+		return nil
+	}
+	instances, err := clients.Instances()
+	if err != nil {
+		return err
+	}
+	existing, err := listGoogleCloudInstances(ctx, instances, &mesh, group)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Deleting node group instances", "count", len(existing))
+	busy, err := deleteGoogleCloudInstances(ctx, instances, spec, existing)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return fmt.Errorf("instances are busy with other operations, retrying deletion")
+	}
+	if spec.UsesSecretManager() {
+		if err := deleteGroupGoogleCloudSecrets(ctx, clients, &mesh, group); err != nil {
+			return err
+		}
+	}
+	if len(group.Status.ReservedAddresses) == 0 {
+		return nil
+	}
+	addresses, err := clients.Addresses()
+	if err != nil {
+		return err
+	}
+	_, busy, err = releaseGoogleCloudAddresses(ctx, addresses, spec, group.Status.ReservedAddresses)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return fmt.Errorf("addresses are still in use, retrying deletion")
+	}
+	return nil
+}
+
+// googleCloudBootDisk returns the initialize params of the boot disk of
+// instances. Unset fields are left to the image and zone defaults.
+func googleCloudBootDisk(spec *meshv1.NodeGroupGoogleCloudConfig, bootImage string) *computepb.AttachedDiskInitializeParams {
+	params := &computepb.AttachedDiskInitializeParams{
+		SourceImage: &bootImage,
+		DiskSizeGb:  spec.DiskSizeGB,
+	}
+	if spec.DiskType != "" {
+		params.DiskType = pointer(fmt.Sprintf("zones/%s/diskTypes/%s", spec.Zone, spec.DiskType))
+	}
+	return params
+}
+
+// googleCloudScheduling returns the scheduling options of instances, or nil if
+// they are left to the defaults of Google Cloud. Spot instances can neither be
+// restarted automatically nor migrated.
+func googleCloudScheduling(spec *meshv1.NodeGroupGoogleCloudConfig) *computepb.Scheduling {
+	if spec.ProvisioningModel == "" && spec.AutomaticRestart == nil && spec.OnHostMaintenance == "" {
+		return nil
+	}
+	scheduling := &computepb.Scheduling{
+		AutomaticRestart: spec.AutomaticRestart,
+	}
+	if spec.ProvisioningModel != "" {
+		scheduling.ProvisioningModel = pointer(spec.ProvisioningModel)
+	}
+	if spec.OnHostMaintenance != "" {
+		scheduling.OnHostMaintenance = pointer(spec.OnHostMaintenance)
+	}
+	if spec.Spot() {
+		scheduling.AutomaticRestart = pointer(false)
+		scheduling.OnHostMaintenance = pointer(meshv1.GoogleCloudOnHostMaintenanceTerminate)
+	}
+	return scheduling
+}
+
+// googleCloudPreempted returns true if the given stopped instance is a spot
+// instance that was preempted, rather than stopped by the schedule of the
+// group.
+func googleCloudPreempted(group *meshv1.NodeGroup, instance *computepb.Instance) bool {
+	if instance.GetScheduling().GetProvisioningModel() != meshv1.GoogleCloudProvisioningModelSpot {
+		return false
+	}
+	schedule := group.Status.Schedule
+	return schedule == nil || schedule.State != meshv1.ScheduleStateSuspended
+}
+
+// googleCloudInstanceChecksum returns the checksum recorded in the description
+// of instances. It covers the boot disk, service account, scheduling and static
+// address settings so that changing them rebuilds the instances. Instances without any
+// of them keep the checksum of their cloud config.
+func googleCloudInstanceChecksum(spec *meshv1.NodeGroupGoogleCloudConfig, static googleCloudStaticAddresses, configChecksum string) string {
+	scheduling := googleCloudScheduling(spec)
+	if spec.DiskSizeGB == nil && spec.DiskType == "" && spec.ServiceAccountEmail == "" && scheduling == nil && static == (googleCloudStaticAddresses{}) {
+		return configChecksum
+	}
+	var size int64
+	if spec.DiskSizeGB != nil {
+		size = *spec.DiskSizeGB
+	}
+	data := fmt.Sprintf("%s\ndisk-size=%d\ndisk-type=%s", configChecksum, size, spec.DiskType)
+	if spec.ServiceAccountEmail != "" {
+		data += fmt.Sprintf("\nservice-account=%s\nscopes=%s", spec.ServiceAccountEmail, strings.Join(spec.ServiceAccountScopes(), ","))
+	}
+	if scheduling != nil {
+		var restart string
+		if scheduling.AutomaticRestart != nil {
+			restart = strconv.FormatBool(*scheduling.AutomaticRestart)
+		}
+		data += fmt.Sprintf("\nprovisioning-model=%s\nautomatic-restart=%s\non-host-maintenance=%s",
+			scheduling.GetProvisioningModel(), restart, scheduling.GetOnHostMaintenance())
+	}
+	if static != (googleCloudStaticAddresses{}) {
+		data += fmt.Sprintf("\nstatic-ipv4=%s\nstatic-ipv6=%s", static.IPv4, static.IPv6)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
+// googleCloudBootImage returns the image instances boot from. An image set on
+// the group is used as is, otherwise the latest image of its family is looked up.
+func googleCloudBootImage(ctx context.Context, spec *meshv1.NodeGroupGoogleCloudConfig, clients *googleCloudClients) (string, error) {
+	if spec.Image != "" {
+		return spec.Image, nil
+	}
+	family, project := spec.BootImageFamily()
+	view, err := clients.ImageFamilyView(ctx, project, family, spec.Zone)
+	if err != nil {
+		return "", fmt.Errorf("get latest image of family %s/%s: %w", project, family, err)
+	}
+	return view.GetImage().GetSelfLink(), nil
+}
+
+// googleCloudBusyRetryInterval is how long to wait before deleting instances
+// again that were busy with another operation.
+const googleCloudBusyRetryInterval = 15 * time.Second
+
+// googleCloudRolloutInterval is how often a group is requeued while its
+// instances are replaced.
+const googleCloudRolloutInterval = 15 * time.Second
+
+// googleCloudProbeTimeout is how long to wait for the gRPC port of an
+// instance to accept a connection.
+const googleCloudProbeTimeout = 3 * time.Second
+
+// googleCloudReplicaAvailability returns the ordinals of the replicas of the
+// group whose instances are available. An instance is available once it is
+// running and passes the given probe.
+func googleCloudReplicaAvailability(group *meshv1.NodeGroup, instances []*computepb.Instance, probe func(*computepb.Instance) bool) map[int]bool {
+	available := make(map[int]bool)
+	for _, instance := range instances {
+		ordinal, ok := googleCloudInstanceOrdinal(group, instance)
+		if !ok || ordinal >= int(group.Replicas()) {
+			continue
+		}
+		if instance.GetStatus() == "RUNNING" && probe(instance) {
+			available[ordinal] = true
+		}
+	}
+	return available
+}
+
+// probeGoogleCloudInstance returns true if the gRPC port of the instance
+// accepts connections. Instances without an external address cannot be
+// reached from the cluster and are assumed to be serving once running.
+func probeGoogleCloudInstance(ctx context.Context, instance *computepb.Instance) bool {
+	addr := googleCloudExternalIPv4(instance)
+	if addr == "" {
+		return true
+	}
+	dialer := net.Dialer{Timeout: googleCloudProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(meshv1.DefaultGRPCPort)))
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Instance gRPC port is not reachable", "name", instance.GetName(), "error", err.Error())
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// googleCloudExternalIPv4 returns the first external IPv4 address of the
+// instance, or an empty string if it has none.
+func googleCloudExternalIPv4(instance *computepb.Instance) string {
+	for _, nic := range instance.GetNetworkInterfaces() {
+		for _, access := range nic.GetAccessConfigs() {
+			if access.GetNatIP() != "" {
+				return access.GetNatIP()
+			}
+		}
+	}
+	return ""
+}
+
+// googleCloudInstanceLabels returns the labels of the instance of the replica
+// of a group with the given ordinal. The labels of the group copied onto its
+// objects are added in the syntax of Google Cloud labels, except for those
+// under the operator's prefixes, which are replaced by the labels identifying
+// the instance.
+func googleCloudInstanceLabels(mesh *meshv1.Mesh, group *meshv1.NodeGroup, ordinal int) map[string]string {
+	propagated := mesh.Spec.PropagateMetadata.PropagatedLabels(group.GetLabels())
+	for k := range propagated {
+		if strings.HasPrefix(k, meshv1.LegacyLabelPrefix) || strings.HasPrefix(k, meshv1.GroupLabelPrefix) {
+			delete(propagated, k)
+		}
+	}
+	labels := googleCloudLabels(propagated)
+	for k, v := range googleCloudInstanceSelector(mesh, group) {
+		labels[k] = v
+	}
+	labels["index"] = strconv.Itoa(ordinal)
+	return labels
+}
+
+// googleCloudInstanceSelector returns the labels identifying the instances of
+// a group.
+func googleCloudInstanceSelector(mesh *meshv1.Mesh, group *meshv1.NodeGroup) map[string]string {
+	return map[string]string{
+		"mesh":      googleCloudLabelValue(mesh.GetName()),
+		"group":     googleCloudLabelValue(group.GetName()),
+		"namespace": googleCloudLabelValue(group.GetNamespace()),
+	}
+}
+
+// maxGoogleCloudLabelLength is the maximum length of the keys and values of
+// Google Cloud labels.
+const maxGoogleCloudLabelLength = 63
+
+// googleCloudLabels converts Kubernetes labels to Google Cloud labels. Keys
+// and values may only hold lowercase letters, digits, underscores and dashes,
+// and keys must start with a letter. Slashes are replaced by underscores and
+// other characters by dashes. Keys that collide once converted are kept for
+// the first key in sorted order.
+func googleCloudLabels(labels map[string]string) map[string]string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	out := make(map[string]string, len(labels))
+	for _, k := range keys {
+		key := googleCloudLabelValue(k)
+		if key == "" || key[0] < 'a' || key[0] > 'z' {
+			key = googleCloudLabelValue("k-" + k)
+		}
+		if _, ok := out[key]; ok {
+			continue
+		}
+		out[key] = googleCloudLabelValue(labels[k])
+	}
+	return out
+}
+
+// googleCloudLabelValue converts a Kubernetes label key or value to the syntax
+// of Google Cloud labels. Values that are too long are truncated and end with
+// a hash of the original, so distinct values remain distinct.
+func googleCloudLabelValue(value string) string {
+	out := []byte(strings.ToLower(value))
+	for i, c := range out {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-':
+		case c == '/':
+			out[i] = '_'
+		default:
+			out[i] = '-'
+		}
+	}
+	if len(out) <= maxGoogleCloudLabelLength {
+		return string(out)
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:8]
+	return string(out[:maxGoogleCloudLabelLength-len(sum)-1]) + "-" + sum
+}
+
+// listGoogleCloudInstances lists the instances of a group by their labels.
+// Instances created before the namespace label was set are matched by the
+// names of the mesh and group alone.
+func listGoogleCloudInstances(ctx context.Context, instances *compute.InstancesClient, mesh *meshv1.Mesh, group *meshv1.NodeGroup) ([]*computepb.Instance, error) {
+	spec := group.Spec.GoogleCloud
+	selector := googleCloudInstanceSelector(mesh, group)
+	it := instances.List(ctx, &computepb.ListInstancesRequest{
+		Project: spec.ProjectID,
+		Zone:    spec.Zone,
+		Filter:  pointer(fmt.Sprintf("labels.mesh = %q AND labels.group = %q", selector["mesh"], selector["group"])),
+	})
+	var out []*computepb.Instance
+	for {
+		instance, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list instances: %w", err)
+		}
+		if ns, ok := instance.GetLabels()["namespace"]; ok && ns != selector["namespace"] {
+			// A group of the same name in another namespace
+			continue
+		}
+		out = append(out, instance)
+	}
+}
+
+// googleCloudInstanceDetails returns the observed state of the instances of
+// the replicas of the group, ordered by ordinal. Instances of removed replicas
+// are left out.
+func googleCloudInstanceDetails(group *meshv1.NodeGroup, instances []*computepb.Instance) []meshv1.NodeGroupInstanceStatus {
+	var out []meshv1.NodeGroupInstanceStatus
+	for _, instance := range instances {
+		ordinal, ok := googleCloudInstanceOrdinal(group, instance)
+		if !ok || ordinal >= int(group.Replicas()) {
+			continue
+		}
+		zone := instance.GetZone()
+		if i := strings.LastIndex(zone, "/"); i >= 0 {
+			zone = zone[i+1:]
+		}
+		details := meshv1.NodeGroupInstanceStatus{
+			Ordinal: int32(ordinal),
+			Name:    instance.GetName(),
+			Zone:    zone,
+			Status:  instance.GetStatus(),
+		}
+		if nics := instance.GetNetworkInterfaces(); len(nics) > 0 {
+			nic := nics[0]
+			details.InternalIPv4 = nic.GetNetworkIP()
+			details.InternalIPv6 = nic.GetIpv6Address()
+			for _, access := range nic.GetAccessConfigs() {
+				if access.GetNatIP() != "" {
+					details.ExternalIPv4 = access.GetNatIP()
+					break
+				}
+			}
+			for _, access := range nic.GetIpv6AccessConfigs() {
+				if access.GetExternalIpv6() != "" {
+					details.ExternalIPv6 = access.GetExternalIpv6()
+					break
+				}
+			}
+		}
+		out = append(out, details)
+	}
+	slices.SortFunc(out, func(a, b meshv1.NodeGroupInstanceStatus) int {
+		return int(a.Ordinal - b.Ordinal)
+	})
+	return out
+}
+
+// googleCloudInstancesSettling returns true if any of the instances is still
+// being provisioned, started or stopped.
+func googleCloudInstancesSettling(details []meshv1.NodeGroupInstanceStatus) bool {
+	for _, instance := range details {
+		switch instance.Status {
+		case "PROVISIONING", "STAGING", "STOPPING", "SUSPENDING", "REPAIRING":
+			return true
+		}
+	}
+	return false
+}
+
+// googleCloudInstanceOrdinal returns the ordinal of the replica an instance of
+// the group was created for. It is read from the index label, from the index
+// metadata of managed instances, or from the name of instances created before
+// the label was set.
+func googleCloudInstanceOrdinal(group *meshv1.NodeGroup, instance *computepb.Instance) (int, bool) {
+	index, ok := instance.GetLabels()["index"]
+	if !ok {
+		for _, item := range instance.GetMetadata().GetItems() {
+			if item.GetKey() == googleCloudIndexMetadataKey {
+				index, ok = item.GetValue(), true
+			}
+		}
+	}
+	if !ok {
+		index, ok = strings.CutPrefix(instance.GetName(), group.GetName()+"-")
+		if !ok {
+			return 0, false
+		}
+	}
+	ordinal, err := strconv.Atoi(index)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// googleCloudOrphans returns the instances of the group that belong to
+// replicas beyond its replica count. Instances whose replica cannot be
+// determined are left alone.
+func googleCloudOrphans(group *meshv1.NodeGroup, instances []*computepb.Instance) []*computepb.Instance {
+	var orphans []*computepb.Instance
+	for _, instance := range instances {
+		ordinal, ok := googleCloudInstanceOrdinal(group, instance)
+		if ok && ordinal >= int(group.Replicas()) {
+			orphans = append(orphans, instance)
+		}
+	}
+	return orphans
+}
+
+// deleteGoogleCloudInstances deletes the given instances. Instances that are
+// already gone are skipped. Instances busy with another operation, such as
+// one that is already being deleted, are left to be retried and busy is
+// returned true, so a single race does not fail the whole reconcile.
+func deleteGoogleCloudInstances(ctx context.Context, instances *compute.InstancesClient, spec *meshv1.NodeGroupGoogleCloudConfig, toDelete []*computepb.Instance) (busy bool, err error) {
+	log := log.FromContext(ctx)
+	for _, instance := range toDelete {
+		name := instance.GetName()
+		log.Info("Deleting node group instance", "name", name)
+		if simulate(ctx, "delete instance", "name", name) {
+			continue
+		}
+		op, err := instances.Delete(ctx, &computepb.DeleteInstanceRequest{
 			Project:  spec.ProjectID,
 			Zone:     spec.Zone,
 			Instance: name,
 		})
 		if err == nil {
-			// Delete the instance
-			log.FromContext(ctx).Info("Deleting node group instance", "name", name)
-			op, err := instances.Delete(ctx, &computepb.DeleteInstanceRequest{
-				Project:  spec.ProjectID,
-				Zone:     spec.Zone,
-				Instance: instance.GetName(),
-			})
-			if err != nil {
-				return fmt.Errorf("delete instance: %w", err)
-			}
-			if err := op.Wait(ctx); err != nil {
-				return fmt.Errorf("wait for instance deletion: %w", err)
-			}
-		} else {
-			gerr := &googleapi.Error{}
-			ok := errors.As(err, &gerr)
-			if (ok && gerr.Code != http.StatusNotFound) || !ok {
-				return fmt.Errorf("failed to lookup existing instance: %w", err)
-			}
+			err = op.Wait(ctx)
+		}
+		switch {
+		case err == nil:
+		case googleCloudErrorCode(err) == http.StatusNotFound:
+			log.Info("Instance already deleted", "name", name)
+		case isGoogleCloudBusy(err):
+			log.Info("Instance is busy with another operation, retrying later", "name", name, "error", err.Error())
+			busy = true
+		default:
+			return busy, fmt.Errorf("delete instance %s: %w", name, err)
 		}
 	}
-	return nil
+	return busy, nil
 }
 
-func (r *NodeGroupReconciler) getGoogleClientOptions(ctx context.Context, group *meshv1.NodeGroup) ([]option.ClientOption, error) {
-	if group.Spec.GoogleCloud.Credentials == nil {
-		// We assume workload identity is enabled
-		return nil, nil
+// googleCloudErrorCode returns the HTTP status code of a Google API error, or
+// zero if err is not one.
+func googleCloudErrorCode(err error) int {
+	gerr := &googleapi.Error{}
+	if errors.As(err, &gerr) {
+		return gerr.Code
+	}
+	return 0
+}
+
+// isGoogleCloudBusy returns true if the error is returned because the
+// resource is busy with another operation.
+func isGoogleCloudBusy(err error) bool {
+	gerr := &googleapi.Error{}
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	if gerr.Code == http.StatusConflict {
+		return true
+	}
+	for _, item := range gerr.Errors {
+		if item.Reason == "resourceNotReady" || item.Reason == "resourceInUseByAnotherResource" {
+			return true
+		}
 	}
+	return false
+}
+
+// getNodeCertificateSecret returns the certificate secret of the replica of a
+// group with the given ordinal, once it holds a certificate.
+func (r *NodeGroupReconciler) getNodeCertificateSecret(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, ordinal int) (*corev1.Secret, error) {
 	var secret corev1.Secret
 	err := r.Get(ctx, client.ObjectKey{
-		Name:      group.Spec.GoogleCloud.Credentials.Name,
+		Name:      meshv1.MeshNodeCertName(mesh, group, ordinal),
 		Namespace: group.GetNamespace(),
 	}, &secret)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("get node certificate secret: %w", err)
 	}
-	key, ok := secret.Data[group.Spec.GoogleCloud.Credentials.Key]
-	if !ok {
-		return nil, fmt.Errorf("no key %s in secret %s/%s",
-			group.Spec.GoogleCloud.Credentials.Key, group.GetNamespace(), group.Spec.GoogleCloud.Credentials.Name)
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
+		if _, ok := secret.Data[key]; !ok {
+			return nil, fmt.Errorf("node certificate secret missing key %q", key)
+		}
+	}
+	return &secret, nil
+}
+
+// certificatesPendingInterval is how often a group is requeued while the
+// certificates of its replicas are being issued.
+const certificatesPendingInterval = 5 * time.Second
+
+// getNodeCertificateSecrets returns the certificate secrets of the replicas of
+// a group by ordinal, along with the ordinals of the replicas whose secrets do
+// not hold a certificate yet. The secrets are listed by their labels. Secrets
+// created before they were labeled are looked up by name.
+func (r *NodeGroupReconciler) getNodeCertificateSecrets(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (map[int]*corev1.Secret, []int, error) {
+	var list corev1.SecretList
+	err := r.List(ctx, &list,
+		client.InNamespace(group.GetNamespace()),
+		client.MatchingLabels(meshv1.NodeGroupSelector(mesh, group)),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list node certificate secrets: %w", err)
+	}
+	byName := make(map[string]*corev1.Secret, len(list.Items))
+	for i := range list.Items {
+		byName[list.Items[i].GetName()] = &list.Items[i]
+	}
+	secrets := make(map[int]*corev1.Secret, group.Replicas())
+	var missing []int
+	for i := 0; i < int(group.Replicas()); i++ {
+		secret, ok := byName[meshv1.MeshNodeCertName(mesh, group, i)]
+		if !ok {
+			secret = &corev1.Secret{}
+			err := r.Get(ctx, client.ObjectKey{
+				Name:      meshv1.MeshNodeCertName(mesh, group, i),
+				Namespace: group.GetNamespace(),
+			}, secret)
+			if client.IgnoreNotFound(err) != nil {
+				return nil, nil, fmt.Errorf("get node certificate secret: %w", err)
+			}
+			if err != nil {
+				missing = append(missing, i)
+				continue
+			}
+		}
+		if !nodeCertificateSecretComplete(secret) {
+			missing = append(missing, i)
+			continue
+		}
+		secrets[i] = secret
+	}
+	return secrets, missing, nil
+}
+
+// nodeCertificateSecretComplete returns true if the secret holds a node
+// certificate, its key and the CA.
+func nodeCertificateSecretComplete(secret *corev1.Secret) bool {
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, cmmeta.TLSCAKey} {
+		if _, ok := secret.Data[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// getFileSecrets resolves the content of the group's file secrets. The content
+// is part of the cloud config, so a rotated secret changes its checksum and the
+// instances are recreated.
+func (r *NodeGroupReconciler) getFileSecrets(ctx context.Context, group *meshv1.NodeGroup) ([]cloudconfig.File, error) {
+	files := make([]cloudconfig.File, 0, len(group.Spec.GoogleCloud.FileSecrets))
+	for _, file := range group.Spec.GoogleCloud.FileSecrets {
+		var secret corev1.Secret
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      file.SecretRef.Name,
+			Namespace: group.GetNamespace(),
+		}, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("get file secret %s: %w", file.SecretRef.Name, err)
+		}
+		data, ok := secret.Data[file.Key]
+		if !ok {
+			return nil, fmt.Errorf("no key %s in secret %s/%s", file.Key, group.GetNamespace(), file.SecretRef.Name)
+		}
+		files = append(files, cloudconfig.File{
+			Path:        file.Path,
+			Permissions: file.Mode,
+			Content:     data,
+		})
 	}
-	return []option.ClientOption{option.WithCredentialsJSON(key)}, nil
+	return files, nil
+}
+
+// getHostAliases returns the host aliases of the group's instances. Each maps
+// the cluster names of the nodes of an exposed node group to the external
+// address of its load balancer. The addresses are part of the cloud config,
+// so the instances are recreated when they change.
+func (r *NodeGroupReconciler) getHostAliases(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) ([]cloudconfig.HostAlias, error) {
+	aliases := make([]cloudconfig.HostAlias, 0, len(group.Spec.GoogleCloud.HostAliases))
+	clusterDomain := operatorconfig.FromContext(ctx).ClusterDomain
+	for _, ref := range group.Spec.GoogleCloud.HostAliases {
+		var target meshv1.NodeGroup
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      ref.NodeGroup,
+			Namespace: group.GetNamespace(),
+		}, &target)
+		if err != nil {
+			return nil, fmt.Errorf("get host alias node group %s: %w", ref.NodeGroup, err)
+		}
+		if target.MeshKey() != group.MeshKey() {
+			return nil, fmt.Errorf("host alias node group %s is not part of mesh %s", ref.NodeGroup, mesh.GetName())
+		}
+		if target.Spec.Cluster == nil || target.Spec.Cluster.Service == nil {
+			return nil, fmt.Errorf("host alias node group %s is not exposed through a load balancer", ref.NodeGroup)
+		}
+		addrs, err := getLBExternalIPs(ctx, r.Client, mesh, &target)
+		if err != nil {
+			return nil, err
+		}
+		var ip string
+		for _, addr := range addrs {
+			if net.ParseIP(addr) != nil {
+				ip = addr
+				break
+			}
+		}
+		if ip == "" {
+			return nil, fmt.Errorf("load balancer of host alias node group %s has no IP address", ref.NodeGroup)
+		}
+		hostnames := []string{meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, &target, clusterDomain)}
+		for i := 0; i < int(target.Replicas()); i++ {
+			hostnames = append(hostnames, meshv1.MeshNodeClusterFQDN(mesh, &target, i, clusterDomain))
+		}
+		aliases = append(aliases, cloudconfig.HostAlias{IP: ip, Hostnames: hostnames})
+	}
+	return aliases, nil
+}
+
+// lbServiceToNodeGroups maps the service of a node group to the Google Cloud
+// node groups in its namespace with a host alias for it.
+func (r *NodeGroupReconciler) lbServiceToNodeGroups(ctx context.Context, obj client.Object) []reconcile.Request {
+	var owner string
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "NodeGroup" {
+			owner = ref.Name
+		}
+	}
+	if owner == "" {
+		return nil
+	}
+	var groups meshv1.NodeGroupList
+	if err := r.List(ctx, &groups, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list node groups")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, group := range groups.Items {
+		if group.Spec.GoogleCloud == nil {
+			continue
+		}
+		for _, alias := range group.Spec.GoogleCloud.HostAliases {
+			if alias.NodeGroup == owner {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&group)})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+const (
+	// googleCloudInternalIPEnv is the environment variable holding the
+	// internal IPv4 address of an instance in the node container.
+	googleCloudInternalIPEnv = "INSTANCE_INTERNAL_IP"
+	// googleCloudInternalIPCommand reads the internal IPv4 address of an
+	// instance from the metadata server.
+	googleCloudInternalIPCommand = "curl -fsS -H 'Metadata-Flavor: Google' http://metadata.google.internal/computeMetadata/v1/instance/network-interfaces/0/ip"
+)
+
+// googleCloudCommandEnv returns the environment variables of the node
+// container of a Google Cloud node group read on the instance at boot.
+func googleCloudCommandEnv(spec *meshv1.NodeGroupGoogleCloudConfig) map[string]string {
+	if !spec.InternalOnly {
+		return nil
+	}
+	return map[string]string{googleCloudInternalIPEnv: googleCloudInternalIPCommand}
+}
+
+// googleCloudNodeConfigOptions returns the node config options for the replica
+// of a Google Cloud node group with the given ordinal. Replicas with a static
+// external address advertise it as their primary endpoint unless the group
+// sets one.
+func googleCloudNodeConfigOptions(mesh *meshv1.Mesh, group *meshv1.NodeGroup, joinServer string, nic *computepb.NetworkInterface, hostAliases []cloudconfig.HostAlias, static googleCloudStaticAddresses, ordinal int) (nodeconfig.Options, error) {
+	spec := group.Spec.GoogleCloud
+	primaryEndpoint, err := spec.PrimaryEndpointFor(ordinal)
+	if err != nil {
+		return nodeconfig.Options{}, fmt.Errorf("render primary endpoint: %w", err)
+	}
+	switch {
+	case primaryEndpoint != "":
+	case spec.InternalOnly:
+		// Rendered by the node from the environment of its container
+		primaryEndpoint = fmt.Sprintf(`{{ env %q }}`, googleCloudInternalIPEnv)
+	case static.IPv4 != "":
+		primaryEndpoint = static.IPv4
+	case static.IPv6 != "":
+		primaryEndpoint = static.IPv6
+	}
+	var aliases []string
+	for _, alias := range hostAliases {
+		aliases = append(aliases, alias.Hostnames...)
+	}
+	return nodeconfig.Options{
+		Mesh:                 mesh,
+		Group:                group,
+		JoinServer:           joinServer,
+		PrimaryEndpoint:      primaryEndpoint,
+		IsPersistent:         true,
+		CertDir:              meshv1.DefaultTLSDirectory,
+		DetectEndpoints:      spec.DetectsEndpoints(),
+		DetectIPv6:           len(nic.GetIpv6AccessConfigs()) > 0,
+		NoIPv6:               nic.GetStackType() == meshv1.GoogleCloudStackTypeIPv4Only,
+		AllowRemoteDetection: spec.AllowsRemoteDetection(),
+		HostAliases:          aliases,
+	}, nil
 }
@@ -0,0 +1,232 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// googleCloudStaticAddresses are the static external addresses of a replica.
+// Empty addresses are left to be ephemeral.
+type googleCloudStaticAddresses struct {
+	IPv4 string
+	IPv6 string
+}
+
+// googleCloudAddressName returns the name of the address of the given IP
+// version reserved for the instance with the given name.
+func googleCloudAddressName(instance string, version string) string {
+	return fmt.Sprintf("%s-%s", instance, version)
+}
+
+// googleCloudAddressRecorder records an address reserved by the operator as
+// soon as it exists, so it is released even if the rest of the reconcile fails.
+type googleCloudAddressRecorder func(ctx context.Context, name string) error
+
+// recordReservedAddress returns a googleCloudAddressRecorder adding the
+// addresses to the reserved addresses in the status of the group.
+func (r googleCloudProvider) recordReservedAddress(group *meshv1.NodeGroup) googleCloudAddressRecorder {
+	return func(ctx context.Context, name string) error {
+		if slices.Contains(group.Status.ReservedAddresses, name) {
+			return nil
+		}
+		group.Status.ReservedAddresses = append(group.Status.ReservedAddresses, name)
+		if err := r.Status().Update(ctx, group); err != nil {
+			return fmt.Errorf("record reserved address %s: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// reserveGoogleCloudAddresses returns the static addresses of each replica of
+// the group. Addresses named in the group are looked up, and the addresses of
+// other replicas are reserved if static addresses are enabled. The names of
+// the addresses reserved by the operator are returned, and each one is
+// recorded as soon as it exists.
+func reserveGoogleCloudAddresses(ctx context.Context, addresses *compute.AddressesClient, mesh *meshv1.Mesh, group *meshv1.NodeGroup, subnet *computepb.Subnetwork, ipv6 bool, record googleCloudAddressRecorder) (map[int]googleCloudStaticAddresses, []string, error) {
+	spec := group.Spec.GoogleCloud
+	static := make(map[int]googleCloudStaticAddresses, group.Replicas())
+	var reserved []string
+	for i := 0; i < int(group.Replicas()); i++ {
+		instance, err := group.GoogleCloudInstanceName(i)
+		if err != nil {
+			return nil, nil, fmt.Errorf("render instance name: %w", err)
+		}
+		var replica googleCloudStaticAddresses
+		if i < len(spec.Addresses) {
+			address, err := addresses.Get(ctx, &computepb.GetAddressRequest{
+				Project: spec.ProjectID,
+				Region:  googleCloudRegion(spec),
+				Address: spec.Addresses[i],
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("get address %s: %w", spec.Addresses[i], err)
+			}
+			replica.IPv4 = address.GetAddress()
+		} else if spec.StaticIPs && spec.ExternalIPv4() {
+			address := &computepb.Address{
+				Name:        pointer(googleCloudAddressName(instance, "v4")),
+				AddressType: pointer("EXTERNAL"),
+				IpVersion:   pointer("IPV4"),
+			}
+			if spec.NetworkTier != "" {
+				address.NetworkTier = pointer(spec.NetworkTier)
+			}
+			ip, err := reserveGoogleCloudAddress(ctx, addresses, mesh, group, address, record)
+			if err != nil {
+				return nil, nil, err
+			}
+			replica.IPv4 = ip
+			reserved = append(reserved, address.GetName())
+		}
+		if spec.StaticIPs && ipv6 {
+			address := &computepb.Address{
+				Name:             pointer(googleCloudAddressName(instance, "v6")),
+				AddressType:      pointer("EXTERNAL"),
+				IpVersion:        pointer("IPV6"),
+				Ipv6EndpointType: pointer("VM"),
+				Subnetwork:       subnet.SelfLink,
+				NetworkTier:      pointer(meshv1.GoogleCloudNetworkTierPremium),
+			}
+			ip, err := reserveGoogleCloudAddress(ctx, addresses, mesh, group, address, record)
+			if err != nil {
+				return nil, nil, err
+			}
+			replica.IPv6 = ip
+			reserved = append(reserved, address.GetName())
+		}
+		static[i] = replica
+	}
+	return static, reserved, nil
+}
+
+// reserveGoogleCloudAddress returns the IP of the given address, reserving it
+// if it does not exist yet. An empty IP is returned when changes are only
+// simulated.
+func reserveGoogleCloudAddress(ctx context.Context, addresses *compute.AddressesClient, mesh *meshv1.Mesh, group *meshv1.NodeGroup, address *computepb.Address, record googleCloudAddressRecorder) (string, error) {
+	spec := group.Spec.GoogleCloud
+	get := &computepb.GetAddressRequest{
+		Project: spec.ProjectID,
+		Region:  googleCloudRegion(spec),
+		Address: address.GetName(),
+	}
+	existing, err := addresses.Get(ctx, get)
+	if err == nil {
+		// Recorded again in case recording it failed after it was created
+		return existing.GetAddress(), record(ctx, address.GetName())
+	}
+	if googleCloudErrorCode(err) != http.StatusNotFound {
+		return "", fmt.Errorf("get address %s: %w", address.GetName(), err)
+	}
+	log.FromContext(ctx).Info("Reserving static address", "name", address.GetName())
+	if simulate(ctx, "reserve address", "name", address.GetName()) {
+		return "", nil
+	}
+	address.Description = pointer(fmt.Sprintf("Reserved for node group %s of mesh %s", group.GetName(), mesh.GetName()))
+	op, err := addresses.Insert(ctx, &computepb.InsertAddressRequest{
+		Project:         spec.ProjectID,
+		Region:          googleCloudRegion(spec),
+		AddressResource: address,
+	})
+	if err != nil {
+		return "", fmt.Errorf("reserve address %s: %w", address.GetName(), err)
+	}
+	if err := record(ctx, address.GetName()); err != nil {
+		return "", err
+	}
+	if err := op.Wait(ctx); err != nil {
+		return "", fmt.Errorf("wait for address %s: %w", address.GetName(), err)
+	}
+	existing, err = addresses.Get(ctx, get)
+	if err != nil {
+		return "", fmt.Errorf("get address %s: %w", address.GetName(), err)
+	}
+	return existing.GetAddress(), nil
+}
+
+// staleGoogleCloudAddresses returns the addresses previously reserved for the
+// group that are no longer reserved.
+func staleGoogleCloudAddresses(group *meshv1.NodeGroup, reserved []string) []string {
+	var stale []string
+	for _, name := range group.Status.ReservedAddresses {
+		if !slices.Contains(reserved, name) {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
+// releaseGoogleCloudAddresses releases the given addresses and returns the
+// addresses that could not be released yet. Addresses still attached to an
+// instance that is being deleted are busy and retried later.
+func releaseGoogleCloudAddresses(ctx context.Context, addresses *compute.AddressesClient, spec *meshv1.NodeGroupGoogleCloudConfig, names []string) (remaining []string, busy bool, err error) {
+	log := log.FromContext(ctx)
+	for _, name := range names {
+		log.Info("Releasing static address", "name", name)
+		if simulate(ctx, "release address", "name", name) {
+			remaining = append(remaining, name)
+			continue
+		}
+		op, err := addresses.Delete(ctx, &computepb.DeleteAddressRequest{
+			Project: spec.ProjectID,
+			Region:  googleCloudRegion(spec),
+			Address: name,
+		})
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		switch {
+		case err == nil:
+		case googleCloudErrorCode(err) == http.StatusNotFound:
+			log.Info("Address already released", "name", name)
+		case isGoogleCloudBusy(err):
+			log.Info("Address is still in use, retrying later", "name", name, "error", err.Error())
+			remaining = append(remaining, name)
+			busy = true
+		default:
+			return nil, busy, fmt.Errorf("release address %s: %w", name, err)
+		}
+	}
+	return remaining, busy, nil
+}
+
+// googleCloudReplicaInterface returns the network interface of a replica with
+// its static addresses attached.
+func googleCloudReplicaInterface(nic *computepb.NetworkInterface, static googleCloudStaticAddresses) *computepb.NetworkInterface {
+	if static.IPv4 == "" && static.IPv6 == "" {
+		return nic
+	}
+	nic = proto.Clone(nic).(*computepb.NetworkInterface)
+	if static.IPv4 != "" && len(nic.AccessConfigs) > 0 {
+		nic.AccessConfigs[0].NatIP = pointer(static.IPv4)
+	}
+	if static.IPv6 != "" && len(nic.Ipv6AccessConfigs) > 0 {
+		nic.Ipv6AccessConfigs[0].ExternalIpv6 = pointer(static.IPv6)
+		nic.Ipv6AccessConfigs[0].ExternalIpv6PrefixLength = pointer(int32(96))
+	}
+	return nic
+}
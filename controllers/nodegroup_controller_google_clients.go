@@ -0,0 +1,398 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// googleCloudLookupTTL is how long image family and subnet lookups are reused
+// before they are made again.
+const googleCloudLookupTTL = 5 * time.Minute
+
+// googleCloudClients are the compute API clients of a project and the
+// credentials they were created with. Clients are created on first use and
+// shared by every group using the same credentials and project.
+type googleCloudClients struct {
+	// checksum is the checksum of the credentials the clients were created
+	// with. It is empty for the ambient credentials of the operator.
+	checksum string
+	opts     []option.ClientOption
+
+	mu        sync.Mutex
+	instances *compute.InstancesClient
+	subnets   *compute.SubnetworksClient
+	addresses *compute.AddressesClient
+	images    *compute.ImageFamilyViewsClient
+	managers  *compute.InstanceGroupManagersClient
+	templates *compute.InstanceTemplatesClient
+	secrets   *secretmanager.Service
+	lookups   map[string]googleCloudLookup
+	now       func() time.Time
+}
+
+// googleCloudLookup is a cached result of a read from the compute API.
+type googleCloudLookup struct {
+	value   proto.Message
+	expires time.Time
+}
+
+// googleCloudScopes are the scopes of the tokens of impersonated service
+// accounts.
+var googleCloudScopes = []string{"https://www.googleapis.com/auth/cloud-platform"}
+
+// googleCloudClientsKey returns the key of the clients of the group. Groups
+// sharing the source of their credentials, the impersonated service account
+// and the project share their clients.
+func googleCloudClientsKey(group *meshv1.NodeGroup) string {
+	spec := group.Spec.GoogleCloud
+	source := "workload"
+	switch {
+	case spec.Credentials != nil:
+		source = fmt.Sprintf("secret/%s/%s/%s", group.GetNamespace(), spec.Credentials.Name, spec.Credentials.Key)
+	case spec.ExternalAccount != nil && spec.ExternalAccount.ConfigMapKeyRef != nil:
+		ref := spec.ExternalAccount.ConfigMapKeyRef
+		source = fmt.Sprintf("external/configmap/%s/%s/%s", group.GetNamespace(), ref.Name, ref.Key)
+	case spec.ExternalAccount != nil && spec.ExternalAccount.SecretKeyRef != nil:
+		ref := spec.ExternalAccount.SecretKeyRef
+		source = fmt.Sprintf("external/secret/%s/%s/%s", group.GetNamespace(), ref.Name, ref.Key)
+	}
+	return fmt.Sprintf("%s|%s|%s", source, spec.ImpersonateServiceAccount, spec.ProjectID)
+}
+
+// getGoogleCloudCredentials returns the credentials JSON of the group, read
+// from its credentials secret or external account configuration. It returns
+// nil if the ambient credentials of the operator are used.
+func (r *NodeGroupReconciler) getGoogleCloudCredentials(ctx context.Context, group *meshv1.NodeGroup) ([]byte, error) {
+	spec := group.Spec.GoogleCloud
+	if spec.ExternalAccount == nil {
+		return getGoogleCredentials(ctx, r.Client, group.GetNamespace(), spec.Credentials)
+	}
+	var data []byte
+	if ref := spec.ExternalAccount.ConfigMapKeyRef; ref != nil {
+		var cm corev1.ConfigMap
+		err := r.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: group.GetNamespace()}, &cm)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := cm.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("no key %s in configmap %s/%s", ref.Key, group.GetNamespace(), ref.Name)
+		}
+		data = []byte(value)
+	} else {
+		var err error
+		data, err = getGoogleCredentials(ctx, r.Client, group.GetNamespace(), spec.ExternalAccount.SecretKeyRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var config struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse external account configuration: %w", err)
+	}
+	if config.Type != "external_account" {
+		return nil, fmt.Errorf("external account configuration has type %q, expected external_account", config.Type)
+	}
+	return data, nil
+}
+
+// googleCloudClientOptions returns the client options using the given
+// credentials, or the ambient credentials if nil, and impersonating the
+// service account of the group if set. A token of the impersonated service
+// account is requested up front, so a missing grant fails here.
+func googleCloudClientOptions(spec *meshv1.NodeGroupGoogleCloudConfig, creds []byte) ([]option.ClientOption, error) {
+	var opts []option.ClientOption
+	if creds != nil {
+		opts = []option.ClientOption{option.WithCredentialsJSON(creds)}
+	}
+	if spec.ImpersonateServiceAccount == "" {
+		return opts, nil
+	}
+	ts, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+		TargetPrincipal: spec.ImpersonateServiceAccount,
+		Scopes:          googleCloudScopes,
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("impersonate %s: %w", spec.ImpersonateServiceAccount, err)
+	}
+	if _, err := ts.Token(); err != nil {
+		return nil, fmt.Errorf("impersonate %s: %w", spec.ImpersonateServiceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
+
+// getGoogleCloudClients returns the clients of the group. The credentials are
+// read on every call and the clients are replaced when they changed.
+func (r *NodeGroupReconciler) getGoogleCloudClients(ctx context.Context, group *meshv1.NodeGroup) (*googleCloudClients, error) {
+	creds, err := r.getGoogleCloudCredentials(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("get google credentials: %w", err)
+	}
+	var checksum string
+	if creds != nil {
+		checksum = fmt.Sprintf("%x", sha256.Sum256(creds))
+	}
+	key := googleCloudClientsKey(group)
+	r.googleClientsMu.Lock()
+	if clients, ok := r.googleClients[key]; ok && clients.checksum == checksum {
+		r.googleClientsMu.Unlock()
+		return clients, nil
+	}
+	r.googleClientsMu.Unlock()
+
+	// Impersonation requests a token, so the options are built unlocked
+	opts, err := googleCloudClientOptions(group.Spec.GoogleCloud, creds)
+	if err != nil {
+		if group.Spec.GoogleCloud.ImpersonateServiceAccount != "" {
+			r.Recorder.Eventf(group, corev1.EventTypeWarning, "ImpersonationFailed",
+				"Failed to impersonate %s: %v", group.Spec.GoogleCloud.ImpersonateServiceAccount, err)
+		}
+		return nil, err
+	}
+	clients := &googleCloudClients{
+		checksum: checksum,
+		opts:     opts,
+		lookups:  make(map[string]googleCloudLookup),
+		now:      time.Now,
+	}
+	r.googleClientsMu.Lock()
+	defer r.googleClientsMu.Unlock()
+	if r.googleClients == nil {
+		r.googleClients = make(map[string]*googleCloudClients)
+	}
+	if old, ok := r.googleClients[key]; ok {
+		old.Close()
+	}
+	r.googleClients[key] = clients
+	return clients, nil
+}
+
+// Close closes the clients created so far and drops the cached lookups.
+func (c *googleCloudClients) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instances != nil {
+		c.instances.Close()
+		c.instances = nil
+	}
+	if c.subnets != nil {
+		c.subnets.Close()
+		c.subnets = nil
+	}
+	if c.addresses != nil {
+		c.addresses.Close()
+		c.addresses = nil
+	}
+	if c.images != nil {
+		c.images.Close()
+		c.images = nil
+	}
+	if c.managers != nil {
+		c.managers.Close()
+		c.managers = nil
+	}
+	if c.templates != nil {
+		c.templates.Close()
+		c.templates = nil
+	}
+	c.secrets = nil
+	c.lookups = make(map[string]googleCloudLookup)
+}
+
+// The clients outlive the reconcile creating them, so they are created with a
+// background context. Their credentials refresh tokens with it.
+
+// Instances returns the instances client.
+func (c *googleCloudClients) Instances() (*compute.InstancesClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instances == nil {
+		client, err := compute.NewInstancesRESTClient(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create compute instances client: %w", err)
+		}
+		c.instances = client
+	}
+	return c.instances, nil
+}
+
+// Subnetworks returns the subnetworks client.
+func (c *googleCloudClients) Subnetworks() (*compute.SubnetworksClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subnets == nil {
+		client, err := compute.NewSubnetworksRESTClient(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create compute subnetworks client: %w", err)
+		}
+		c.subnets = client
+	}
+	return c.subnets, nil
+}
+
+// Addresses returns the addresses client.
+func (c *googleCloudClients) Addresses() (*compute.AddressesClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.addresses == nil {
+		client, err := compute.NewAddressesRESTClient(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create compute addresses client: %w", err)
+		}
+		c.addresses = client
+	}
+	return c.addresses, nil
+}
+
+// ImageFamilyViews returns the image family views client.
+func (c *googleCloudClients) ImageFamilyViews() (*compute.ImageFamilyViewsClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.images == nil {
+		client, err := compute.NewImageFamilyViewsRESTClient(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create compute images client: %w", err)
+		}
+		c.images = client
+	}
+	return c.images, nil
+}
+
+// InstanceGroupManagers returns the instance group managers client.
+func (c *googleCloudClients) InstanceGroupManagers() (*compute.InstanceGroupManagersClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.managers == nil {
+		client, err := compute.NewInstanceGroupManagersRESTClient(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create compute instance group managers client: %w", err)
+		}
+		c.managers = client
+	}
+	return c.managers, nil
+}
+
+// InstanceTemplates returns the instance templates client.
+func (c *googleCloudClients) InstanceTemplates() (*compute.InstanceTemplatesClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.templates == nil {
+		client, err := compute.NewInstanceTemplatesRESTClient(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create compute instance templates client: %w", err)
+		}
+		c.templates = client
+	}
+	return c.templates, nil
+}
+
+// SecretManager returns the Secret Manager service.
+func (c *googleCloudClients) SecretManager() (*secretmanager.Service, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.secrets == nil {
+		service, err := secretmanager.NewService(context.Background(), c.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create secret manager client: %w", err)
+		}
+		c.secrets = service
+	}
+	return c.secrets, nil
+}
+
+// lookup returns the cached result of the read with the given key, or makes it
+// when there is none or it expired. Failed reads are not cached. A copy of the
+// result is returned so callers are free to modify it.
+func (c *googleCloudClients) lookup(key string, get func() (proto.Message, error)) (proto.Message, error) {
+	c.mu.Lock()
+	cached, ok := c.lookups[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(cached.expires) {
+		return proto.Clone(cached.value), nil
+	}
+	value, err := get()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.lookups[key] = googleCloudLookup{
+		value:   proto.Clone(value),
+		expires: c.now().Add(googleCloudLookupTTL),
+	}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Subnetwork returns the subnet instances of the group are attached to.
+func (c *googleCloudClients) Subnetwork(ctx context.Context, spec *meshv1.NodeGroupGoogleCloudConfig) (*computepb.Subnetwork, error) {
+	region := googleCloudRegion(spec)
+	key := fmt.Sprintf("subnet/%s/%s", region, spec.Subnetwork)
+	subnet, err := c.lookup(key, func() (proto.Message, error) {
+		subnets, err := c.Subnetworks()
+		if err != nil {
+			return nil, err
+		}
+		return subnets.Get(ctx, &computepb.GetSubnetworkRequest{
+			Project:    spec.ProjectID,
+			Region:     region,
+			Subnetwork: spec.Subnetwork,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get subnet: %w", err)
+	}
+	return subnet.(*computepb.Subnetwork), nil
+}
+
+// ImageFamilyView returns the latest image of the given family.
+func (c *googleCloudClients) ImageFamilyView(ctx context.Context, project, family, zone string) (*computepb.ImageFamilyView, error) {
+	key := fmt.Sprintf("image/%s/%s/%s", project, family, zone)
+	view, err := c.lookup(key, func() (proto.Message, error) {
+		images, err := c.ImageFamilyViews()
+		if err != nil {
+			return nil, err
+		}
+		return images.Get(ctx, &computepb.GetImageFamilyViewRequest{
+			Family:  family,
+			Project: project,
+			Zone:    zone,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return view.(*computepb.ImageFamilyView), nil
+}
@@ -0,0 +1,570 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+const (
+	// googleCloudChecksumMetadataKey is the per-instance metadata key holding
+	// the checksum of the cloud config of a replica.
+	googleCloudChecksumMetadataKey = "webmesh-config-checksum"
+	// googleCloudIndexMetadataKey is the per-instance metadata key holding the
+	// ordinal of a replica. Instances of a managed group share the labels of
+	// their template, so the ordinal cannot be a label.
+	googleCloudIndexMetadataKey = "webmesh-index"
+)
+
+// maxGoogleCloudTemplatePrefix is the maximum length of the group name in the
+// names of its instance templates, leaving room for their checksum suffix.
+const maxGoogleCloudTemplatePrefix = 52
+
+// reconcileManagedInstanceGroup runs the replicas of the group in a stateful
+// managed instance group. The template is replaced when the machine settings
+// change and the group rolls its instances over to it. The cloud config of
+// each replica is kept in its per-instance config, and instances are replaced
+// in order when it changes.
+func (r googleCloudProvider) reconcileManagedInstanceGroup(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup, clients *googleCloudClients, bootImage string, shared googleCloudSharedConfig, secrets map[int]*corev1.Secret) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	spec := group.Spec.GoogleCloud
+	managers, err := clients.InstanceGroupManagers()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	templates, err := clients.InstanceTemplates()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Ensure the template of the current machine settings
+	template := &computepb.InstanceTemplate{
+		Description: pointer(googleCloudTemplateOwner(group)),
+		Properties:  googleCloudTemplateProperties(mesh, group, bootImage, shared.nic),
+	}
+	template.Name = pointer(googleCloudTemplateName(group, template.Properties))
+	_, err = templates.Get(ctx, &computepb.GetInstanceTemplateRequest{
+		Project:          spec.ProjectID,
+		InstanceTemplate: template.GetName(),
+	})
+	if isGoogleNotFound(err) {
+		log.Info("Creating instance template", "name", template.GetName())
+		if !simulate(ctx, "create instance template", "name", template.GetName()) {
+			op, err := templates.Insert(ctx, &computepb.InsertInstanceTemplateRequest{
+				Project:                  spec.ProjectID,
+				InstanceTemplateResource: template,
+			})
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("create instance template: %w", err)
+			}
+			if err := op.Wait(ctx); err != nil {
+				return ctrl.Result{}, fmt.Errorf("wait for instance template creation: %w", err)
+			}
+		}
+	} else if err != nil {
+		return ctrl.Result{}, fmt.Errorf("get instance template: %w", err)
+	}
+	templateURL := fmt.Sprintf("global/instanceTemplates/%s", template.GetName())
+
+	// Ensure the managed group uses the template
+	policy := googleCloudManagedUpdatePolicy(spec)
+	manager, err := managers.Get(ctx, &computepb.GetInstanceGroupManagerRequest{
+		Project:              spec.ProjectID,
+		Zone:                 spec.Zone,
+		InstanceGroupManager: group.GetName(),
+	})
+	switch {
+	case isGoogleNotFound(err):
+		log.Info("Creating managed instance group", "name", group.GetName())
+		if simulate(ctx, "create managed instance group", "name", group.GetName()) {
+			return ctrl.Result{}, nil
+		}
+		op, err := managers.Insert(ctx, &computepb.InsertInstanceGroupManagerRequest{
+			Project: spec.ProjectID,
+			Zone:    spec.Zone,
+			InstanceGroupManagerResource: &computepb.InstanceGroupManager{
+				Name:             pointer(group.GetName()),
+				Description:      pointer(googleCloudTemplateOwner(group)),
+				BaseInstanceName: pointer(googleCloudTemplatePrefix(group)),
+				InstanceTemplate: &templateURL,
+				TargetSize:       pointer(int32(0)),
+				UpdatePolicy:     policy,
+			},
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("create managed instance group: %w", err)
+		}
+		if err := op.Wait(ctx); err != nil {
+			return ctrl.Result{}, fmt.Errorf("wait for managed instance group creation: %w", err)
+		}
+		manager, err = managers.Get(ctx, &computepb.GetInstanceGroupManagerRequest{
+			Project:              spec.ProjectID,
+			Zone:                 spec.Zone,
+			InstanceGroupManager: group.GetName(),
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("get managed instance group: %w", err)
+		}
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("get managed instance group: %w", err)
+	case !strings.HasSuffix(manager.GetInstanceTemplate(), "/"+template.GetName()) || !googleCloudUpdatePolicyEqual(manager.GetUpdatePolicy(), policy):
+		log.Info("Rolling managed instance group to new template", "name", group.GetName(), "template", template.GetName())
+		if !simulate(ctx, "update managed instance group", "name", group.GetName(), "template", template.GetName()) {
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstances",
+				"Rolling managed instance group %s to instance template %s", group.GetName(), template.GetName())
+			op, err := managers.Patch(ctx, &computepb.PatchInstanceGroupManagerRequest{
+				Project:              spec.ProjectID,
+				Zone:                 spec.Zone,
+				InstanceGroupManager: group.GetName(),
+				InstanceGroupManagerResource: &computepb.InstanceGroupManager{
+					InstanceTemplate: &templateURL,
+					UpdatePolicy:     policy,
+				},
+			})
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("update managed instance group: %w", err)
+			}
+			if err := op.Wait(ctx); err != nil {
+				return ctrl.Result{}, fmt.Errorf("wait for managed instance group update: %w", err)
+			}
+		}
+	}
+
+	// Build the per-instance config of every replica
+	configs, err := listGoogleCloudPerInstanceConfigs(ctx, managers, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var create, changed []*computepb.PerInstanceConfig
+	for i := 0; i < int(group.Replicas()); i++ {
+		name, err := group.GoogleCloudInstanceName(i)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("render instance name: %w", err)
+		}
+		cloudconf, err := r.replicaCloudConfig(ctx, mesh, group, shared, secrets[i], i)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		existing, ok := configs[name]
+		if !ok {
+			create = append(create, googleCloudPerInstanceConfig(name, i, cloudconf.Raw(), cloudconf.Checksum()))
+			continue
+		}
+		deployed := existing.GetPreservedState().GetMetadata()[googleCloudChecksumMetadataKey]
+		if resolveConfigChecksum(ctx, group, deployed, cloudconf.Checksum()) != deployed {
+			changed = append(changed, googleCloudPerInstanceConfig(name, i, cloudconf.Raw(), cloudconf.Checksum()))
+		}
+	}
+	var res ctrl.Result
+	if len(create) > 0 {
+		log.Info("Creating managed instances", "count", len(create))
+		if !simulate(ctx, "create managed instances", "count", len(create)) {
+			op, err := managers.CreateInstances(ctx, &computepb.CreateInstancesInstanceGroupManagerRequest{
+				Project:              spec.ProjectID,
+				Zone:                 spec.Zone,
+				InstanceGroupManager: group.GetName(),
+				InstanceGroupManagersCreateInstancesRequestResource: &computepb.InstanceGroupManagersCreateInstancesRequest{
+					Instances: create,
+				},
+			})
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("create managed instances: %w", err)
+			}
+			if err := op.Wait(ctx); err != nil {
+				return ctrl.Result{}, fmt.Errorf("wait for managed instance creation: %w", err)
+			}
+		}
+	}
+
+	// Replace the instances whose cloud config changed in batches of the
+	// allowed number of unavailable replicas, once the group settled
+	if len(changed) > 0 {
+		res.RequeueAfter = googleCloudRolloutInterval
+		if !manager.GetStatus().GetIsStable() {
+			log.Info("Waiting for managed instance group to settle before replacing instances", "pending", len(changed))
+		} else {
+			if len(changed) > spec.MaxUnavailableReplicas() {
+				changed = changed[:spec.MaxUnavailableReplicas()]
+			}
+			if err := r.replaceManagedInstances(ctx, managers, group, changed); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// Destroy the secret versions of earlier configs once every instance was
+	// replaced with the current one
+	if shared.secretVersions != nil && len(create) == 0 && len(changed) == 0 && manager.GetStatus().GetIsStable() {
+		if err := destroySupersededGoogleCloudSecretVersions(ctx, clients, shared.secretVersions); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Delete the instances of removed replicas, which removes their
+	// per-instance configs as well
+	var orphans []string
+	for name, config := range configs {
+		ordinal, err := strconv.Atoi(config.GetPreservedState().GetMetadata()[googleCloudIndexMetadataKey])
+		if err == nil && ordinal >= int(group.Replicas()) {
+			orphans = append(orphans, fmt.Sprintf("zones/%s/instances/%s", spec.Zone, name))
+		}
+	}
+	if len(orphans) > 0 {
+		log.Info("Deleting managed instances of removed replicas", "count", len(orphans))
+		if !simulate(ctx, "delete managed instances", "count", len(orphans)) {
+			op, err := managers.DeleteInstances(ctx, &computepb.DeleteInstancesInstanceGroupManagerRequest{
+				Project:              spec.ProjectID,
+				Zone:                 spec.Zone,
+				InstanceGroupManager: group.GetName(),
+				InstanceGroupManagersDeleteInstancesRequestResource: &computepb.InstanceGroupManagersDeleteInstancesRequest{
+					Instances:                      orphans,
+					SkipInstancesOnValidationError: pointer(true),
+				},
+			})
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("delete managed instances: %w", err)
+			}
+			if err := op.Wait(ctx); err != nil {
+				return ctrl.Result{}, fmt.Errorf("wait for managed instance deletion: %w", err)
+			}
+		}
+	}
+
+	// Remove the templates the group no longer uses
+	busy, err := deleteGoogleCloudTemplates(ctx, templates, group, template.GetName())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if busy && (res.RequeueAfter == 0 || googleCloudBusyRetryInterval < res.RequeueAfter) {
+		res.RequeueAfter = googleCloudBusyRetryInterval
+	}
+
+	// Record the instances and check on them until the group settled
+	instances, err := clients.Instances()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	existing, err := listGoogleCloudInstances(ctx, instances, mesh, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	details := googleCloudInstanceDetails(group, existing)
+	seen := make([]string, 0, len(details))
+	for _, instance := range details {
+		seen = append(seen, instance.Name)
+	}
+	if len(seen) == 0 {
+		seen = nil
+	}
+	settling := len(create) > 0 || len(orphans) > 0 || !manager.GetStatus().GetIsStable() || googleCloudInstancesSettling(details)
+	if settling && (res.RequeueAfter == 0 || googleCloudRolloutInterval < res.RequeueAfter) {
+		res.RequeueAfter = googleCloudRolloutInterval
+	}
+	if group.Status.Schedule != nil ||
+		!equality.Semantic.DeepEqual(group.Status.Instances, seen) ||
+		!equality.Semantic.DeepEqual(group.Status.InstanceDetails, details) {
+		group.Status.Schedule = nil
+		group.Status.Instances = seen
+		group.Status.InstanceDetails = details
+		if err := r.Status().Update(ctx, group); err != nil {
+			return ctrl.Result{}, fmt.Errorf("update instance status: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// replaceManagedInstances updates the per-instance configs of the given
+// replicas and recreates their instances, so they boot with the new user-data.
+func (r googleCloudProvider) replaceManagedInstances(ctx context.Context, managers *compute.InstanceGroupManagersClient, group *meshv1.NodeGroup, configs []*computepb.PerInstanceConfig) error {
+	spec := group.Spec.GoogleCloud
+	names := make([]string, 0, len(configs))
+	for _, config := range configs {
+		names = append(names, fmt.Sprintf("zones/%s/instances/%s", spec.Zone, config.GetName()))
+	}
+	log.FromContext(ctx).Info("Config checksum has changed, replacing managed instances", "instances", names)
+	if simulate(ctx, "replace managed instances", "count", len(names)) {
+		return nil
+	}
+	for _, config := range configs {
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstance",
+			"Replacing instance %s after its config changed", config.GetName())
+	}
+	op, err := managers.UpdatePerInstanceConfigs(ctx, &computepb.UpdatePerInstanceConfigsInstanceGroupManagerRequest{
+		Project:              spec.ProjectID,
+		Zone:                 spec.Zone,
+		InstanceGroupManager: group.GetName(),
+		InstanceGroupManagersUpdatePerInstanceConfigsReqResource: &computepb.InstanceGroupManagersUpdatePerInstanceConfigsReq{
+			PerInstanceConfigs: configs,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("update per-instance configs: %w", err)
+	}
+	if err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("wait for per-instance config update: %w", err)
+	}
+	op, err = managers.ApplyUpdatesToInstances(ctx, &computepb.ApplyUpdatesToInstancesInstanceGroupManagerRequest{
+		Project:              spec.ProjectID,
+		Zone:                 spec.Zone,
+		InstanceGroupManager: group.GetName(),
+		InstanceGroupManagersApplyUpdatesRequestResource: &computepb.InstanceGroupManagersApplyUpdatesRequest{
+			Instances:                   names,
+			MinimalAction:               pointer("REPLACE"),
+			MostDisruptiveAllowedAction: pointer("REPLACE"),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("replace managed instances: %w", err)
+	}
+	if err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("wait for managed instance replacement: %w", err)
+	}
+	return nil
+}
+
+// deleteManagedInstanceGroup deletes the managed instance group of the group,
+// along with its instances, and its instance templates.
+func deleteManagedInstanceGroup(ctx context.Context, clients *googleCloudClients, group *meshv1.NodeGroup) error {
+	spec := group.Spec.GoogleCloud
+	managers, err := clients.InstanceGroupManagers()
+	if err != nil {
+		return err
+	}
+	templates, err := clients.InstanceTemplates()
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Deleting managed instance group", "name", group.GetName())
+	if simulate(ctx, "delete managed instance group", "name", group.GetName()) {
+		return nil
+	}
+	op, err := managers.Delete(ctx, &computepb.DeleteInstanceGroupManagerRequest{
+		Project:              spec.ProjectID,
+		Zone:                 spec.Zone,
+		InstanceGroupManager: group.GetName(),
+	})
+	if err != nil && !isGoogleNotFound(err) {
+		return fmt.Errorf("delete managed instance group: %w", err)
+	}
+	if err == nil {
+		if err := op.Wait(ctx); err != nil {
+			return fmt.Errorf("wait for managed instance group deletion: %w", err)
+		}
+	}
+	busy, err := deleteGoogleCloudTemplates(ctx, templates, group, "")
+	if err != nil {
+		return err
+	}
+	if busy {
+		return fmt.Errorf("instance templates are still in use, retrying deletion")
+	}
+	return nil
+}
+
+// deleteGoogleCloudTemplates deletes the instance templates of the group other
+// than the one to keep. Templates still used by the managed instance group
+// are busy and left to be retried.
+func deleteGoogleCloudTemplates(ctx context.Context, templates *compute.InstanceTemplatesClient, group *meshv1.NodeGroup, keep string) (busy bool, err error) {
+	spec := group.Spec.GoogleCloud
+	it := templates.List(ctx, &computepb.ListInstanceTemplatesRequest{
+		Project: spec.ProjectID,
+		Filter:  pointer(fmt.Sprintf(`name eq "%s-[0-9a-f]{10}"`, googleCloudTemplatePrefix(group))),
+	})
+	for {
+		template, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return busy, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("list instance templates: %w", err)
+		}
+		if template.GetName() == keep || template.GetDescription() != googleCloudTemplateOwner(group) {
+			continue
+		}
+		log.FromContext(ctx).Info("Deleting instance template", "name", template.GetName())
+		if simulate(ctx, "delete instance template", "name", template.GetName()) {
+			continue
+		}
+		op, err := templates.Delete(ctx, &computepb.DeleteInstanceTemplateRequest{
+			Project:          spec.ProjectID,
+			InstanceTemplate: template.GetName(),
+		})
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		gerr := &googleapi.Error{}
+		switch {
+		case err == nil, isGoogleNotFound(err):
+		case errors.As(err, &gerr) && gerr.Code == http.StatusBadRequest && strings.Contains(gerr.Message, "being used"):
+			busy = true
+		default:
+			return false, fmt.Errorf("delete instance template: %w", err)
+		}
+	}
+}
+
+// listGoogleCloudPerInstanceConfigs returns the per-instance configs of the
+// managed instance group of the group by instance name.
+func listGoogleCloudPerInstanceConfigs(ctx context.Context, managers *compute.InstanceGroupManagersClient, group *meshv1.NodeGroup) (map[string]*computepb.PerInstanceConfig, error) {
+	spec := group.Spec.GoogleCloud
+	it := managers.ListPerInstanceConfigs(ctx, &computepb.ListPerInstanceConfigsInstanceGroupManagersRequest{
+		Project:              spec.ProjectID,
+		Zone:                 spec.Zone,
+		InstanceGroupManager: group.GetName(),
+	})
+	out := make(map[string]*computepb.PerInstanceConfig)
+	for {
+		config, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list per-instance configs: %w", err)
+		}
+		out[config.GetName()] = config
+	}
+}
+
+// googleCloudPerInstanceConfig returns the per-instance config of the replica
+// with the given ordinal. It holds the cloud config of the replica and fixes
+// the name of its instance.
+func googleCloudPerInstanceConfig(name string, ordinal int, userData []byte, checksum string) *computepb.PerInstanceConfig {
+	return &computepb.PerInstanceConfig{
+		Name: &name,
+		PreservedState: &computepb.PreservedState{
+			Metadata: map[string]string{
+				"user-data":                    string(userData),
+				googleCloudChecksumMetadataKey: checksum,
+				googleCloudIndexMetadataKey:    strconv.Itoa(ordinal),
+			},
+		},
+	}
+}
+
+// googleCloudTemplateProperties returns the properties of the instance
+// template of the group. They hold the settings shared by all replicas.
+func googleCloudTemplateProperties(mesh *meshv1.Mesh, group *meshv1.NodeGroup, bootImage string, nic *computepb.NetworkInterface) *computepb.InstanceProperties {
+	spec := group.Spec.GoogleCloud
+	labels := googleCloudInstanceLabels(mesh, group, 0)
+	delete(labels, "index")
+	props := &computepb.InstanceProperties{
+		MachineType:  &spec.MachineType,
+		Labels:       labels,
+		CanIpForward: pointer(true),
+		AdvancedMachineFeatures: &computepb.AdvancedMachineFeatures{
+			EnableUefiNetworking: pointer(true),
+		},
+		Scheduling: googleCloudScheduling(spec),
+		Disks: []*computepb.AttachedDisk{
+			{
+				Boot:             pointer(true),
+				AutoDelete:       pointer(true),
+				InitializeParams: googleCloudTemplateBootDisk(spec, bootImage),
+			},
+		},
+		NetworkInterfaces: []*computepb.NetworkInterface{nic},
+		Tags: &computepb.Tags{
+			Items: spec.Tags,
+		},
+	}
+	if spec.ServiceAccountEmail != "" {
+		props.ServiceAccounts = []*computepb.ServiceAccount{
+			{
+				Email:  &spec.ServiceAccountEmail,
+				Scopes: spec.ServiceAccountScopes(),
+			},
+		}
+	}
+	return props
+}
+
+// googleCloudTemplateBootDisk returns the initialize params of the boot disk
+// of a template. Templates are global, so disk types are named without a zone.
+func googleCloudTemplateBootDisk(spec *meshv1.NodeGroupGoogleCloudConfig, bootImage string) *computepb.AttachedDiskInitializeParams {
+	params := googleCloudBootDisk(spec, bootImage)
+	if spec.DiskType != "" {
+		params.DiskType = &spec.DiskType
+	}
+	return params
+}
+
+// googleCloudManagedUpdatePolicy returns the update policy of the managed
+// instance group of the group. Instances keep their names, so they are
+// recreated in place without surging.
+func googleCloudManagedUpdatePolicy(spec *meshv1.NodeGroupGoogleCloudConfig) *computepb.InstanceGroupManagerUpdatePolicy {
+	return &computepb.InstanceGroupManagerUpdatePolicy{
+		Type:                        pointer("PROACTIVE"),
+		MinimalAction:               pointer("REPLACE"),
+		MostDisruptiveAllowedAction: pointer("REPLACE"),
+		ReplacementMethod:           pointer("RECREATE"),
+		MaxSurge:                    &computepb.FixedOrPercent{Fixed: pointer(int32(0))},
+		MaxUnavailable:              &computepb.FixedOrPercent{Fixed: pointer(int32(spec.MaxUnavailableReplicas()))},
+	}
+}
+
+// googleCloudUpdatePolicyEqual returns true if the observed update policy
+// matches the desired one in the fields set by the operator.
+func googleCloudUpdatePolicyEqual(observed, desired *computepb.InstanceGroupManagerUpdatePolicy) bool {
+	return observed.GetType() == desired.GetType() &&
+		observed.GetMinimalAction() == desired.GetMinimalAction() &&
+		observed.GetMostDisruptiveAllowedAction() == desired.GetMostDisruptiveAllowedAction() &&
+		observed.GetReplacementMethod() == desired.GetReplacementMethod() &&
+		observed.GetMaxSurge().GetFixed() == desired.GetMaxSurge().GetFixed() &&
+		observed.GetMaxUnavailable().GetFixed() == desired.GetMaxUnavailable().GetFixed()
+}
+
+// googleCloudTemplatePrefix returns the prefix of the names of the instance
+// templates of the group.
+func googleCloudTemplatePrefix(group *meshv1.NodeGroup) string {
+	prefix := group.GetName()
+	if len(prefix) > maxGoogleCloudTemplatePrefix {
+		prefix = strings.TrimRight(prefix[:maxGoogleCloudTemplatePrefix], "-")
+	}
+	return prefix
+}
+
+// googleCloudTemplateName returns the name of the instance template with the
+// given properties. Templates cannot be changed, so the name holds a checksum
+// of the properties and changed settings create a new template.
+func googleCloudTemplateName(group *meshv1.NodeGroup, props *computepb.InstanceProperties) string {
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(props)
+	return fmt.Sprintf("%s-%x", googleCloudTemplatePrefix(group), sha256.Sum256(data))[:len(googleCloudTemplatePrefix(group))+11]
+}
+
+// googleCloudTemplateOwner returns the description of the instance templates
+// and managed instance group of the group, identifying them as its own.
+func googleCloudTemplateOwner(group *meshv1.NodeGroup) string {
+	return fmt.Sprintf("webmesh node group %s/%s", group.GetNamespace(), group.GetName())
+}
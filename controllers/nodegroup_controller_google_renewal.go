@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+)
+
+const (
+	// googleCloudSecretsChecksumMetadataKey is the metadata key holding the
+	// checksum of the TLS material of instances renewing their certificates
+	// in place.
+	googleCloudSecretsChecksumMetadataKey = "webmesh-secrets-checksum"
+	// googleCloudTLSCertMetadataKey is the metadata key holding the node
+	// certificate of instances renewing their certificates in place.
+	googleCloudTLSCertMetadataKey = "webmesh-tls-crt"
+	// googleCloudTLSKeyMetadataKey is the metadata key holding the node key
+	// of instances renewing their certificates in place.
+	googleCloudTLSKeyMetadataKey = "webmesh-tls-key"
+	// googleCloudCAMetadataKey is the metadata key holding the CA of
+	// instances renewing their certificates in place.
+	googleCloudCAMetadataKey = "webmesh-ca-crt"
+)
+
+// googleCloudMetadataCommand returns the command printing the value of the
+// given metadata key of an instance.
+func googleCloudMetadataCommand(key string) string {
+	return fmt.Sprintf("curl -fsS -H 'Metadata-Flavor: Google' http://metadata.google.internal/computeMetadata/v1/instance/attributes/%s", key)
+}
+
+// googleCloudSecretsRefresh returns how instances of the group pick up renewed
+// certificates from their metadata, or nil if they are recreated instead.
+func googleCloudSecretsRefresh(spec *meshv1.NodeGroupGoogleCloudConfig) *cloudconfig.SecretsRefresh {
+	if !spec.RenewsCertificatesInPlace() {
+		return nil
+	}
+	return &cloudconfig.SecretsRefresh{
+		ChecksumCommand: googleCloudMetadataCommand(googleCloudSecretsChecksumMetadataKey),
+		CertCommand:     googleCloudMetadataCommand(googleCloudTLSCertMetadataKey),
+		KeyCommand:      googleCloudMetadataCommand(googleCloudTLSKeyMetadataKey),
+		CACommand:       googleCloudMetadataCommand(googleCloudCAMetadataKey),
+	}
+}
+
+// googleCloudInstanceMetadata returns the metadata items of an instance. The
+// TLS material is published next to the cloud config when the group renews
+// certificates in place.
+func googleCloudInstanceMetadata(spec *meshv1.NodeGroupGoogleCloudConfig, cloudconf *cloudconfig.Config, secret *corev1.Secret) []*computepb.Items {
+	items := []*computepb.Items{
+		{
+			Key:   pointer("user-data"),
+			Value: pointer(string(cloudconf.Raw())),
+		},
+	}
+	if !spec.RenewsCertificatesInPlace() {
+		return items
+	}
+	return append(items,
+		&computepb.Items{Key: pointer(googleCloudSecretsChecksumMetadataKey), Value: pointer(cloudconf.SecretsChecksum())},
+		&computepb.Items{Key: pointer(googleCloudTLSCertMetadataKey), Value: pointer(string(secret.Data[corev1.TLSCertKey]))},
+		&computepb.Items{Key: pointer(googleCloudTLSKeyMetadataKey), Value: pointer(string(secret.Data[corev1.TLSPrivateKeyKey]))},
+		&computepb.Items{Key: pointer(googleCloudCAMetadataKey), Value: pointer(string(secret.Data[cmmeta.TLSCAKey]))},
+	)
+}
+
+// googleCloudMergedMetadata returns the metadata of an instance with the given
+// items set, keeping the items set by others.
+func googleCloudMergedMetadata(metadata *computepb.Metadata, items []*computepb.Items) *computepb.Metadata {
+	set := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		set[item.GetKey()] = struct{}{}
+	}
+	merged := &computepb.Metadata{Fingerprint: metadata.Fingerprint}
+	for _, item := range metadata.GetItems() {
+		if _, ok := set[item.GetKey()]; !ok {
+			merged.Items = append(merged.Items, item)
+		}
+	}
+	merged.Items = append(merged.Items, items...)
+	return merged
+}
+
+// renewGoogleCloudCertificates publishes renewed certificates in the metadata
+// of a running instance, where the instance picks them up and restarts its
+// node. It returns true if the metadata was updated.
+func (r googleCloudProvider) renewGoogleCloudCertificates(ctx context.Context, instances *compute.InstancesClient, group *meshv1.NodeGroup, instance *computepb.Instance, cloudconf *cloudconfig.Config, secret *corev1.Secret) (bool, error) {
+	spec := group.Spec.GoogleCloud
+	for _, item := range instance.GetMetadata().GetItems() {
+		if item.GetKey() == googleCloudSecretsChecksumMetadataKey && item.GetValue() == cloudconf.SecretsChecksum() {
+			return false, nil
+		}
+	}
+	log.FromContext(ctx).Info("Certificates have been renewed, updating instance metadata", "name", instance.GetName())
+	if simulate(ctx, "update instance metadata", "name", instance.GetName()) {
+		return false, nil
+	}
+	r.Recorder.Eventf(group, corev1.EventTypeNormal, "RenewingCertificates",
+		"Publishing renewed certificates to instance %s", instance.GetName())
+	op, err := instances.SetMetadata(ctx, &computepb.SetMetadataInstanceRequest{
+		Project:          spec.ProjectID,
+		Zone:             spec.Zone,
+		Instance:         instance.GetName(),
+		MetadataResource: googleCloudMergedMetadata(instance.GetMetadata(), googleCloudInstanceMetadata(spec, cloudconf, secret)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("set instance metadata: %w", err)
+	}
+	if err := op.Wait(ctx); err != nil {
+		return false, fmt.Errorf("wait for instance metadata: %w", err)
+	}
+	return true, nil
+}
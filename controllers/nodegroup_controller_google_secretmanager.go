@@ -0,0 +1,271 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"google.golang.org/api/secretmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+)
+
+// googleCloudSecretFiles are the keys of a node certificate secret stored in
+// Secret Manager, by the suffix of the name of their secret.
+var googleCloudSecretFiles = []struct{ suffix, key string }{
+	{"tls-crt", corev1.TLSCertKey},
+	{"tls-key", corev1.TLSPrivateKeyKey},
+	{"ca-crt", cmmeta.TLSCAKey},
+}
+
+const (
+	// googleCloudSecretChecksumAnnotation is the annotation of a secret in
+	// Secret Manager holding the checksum of its latest version.
+	googleCloudSecretChecksumAnnotation = "webmesh-checksum"
+	// googleCloudSecretVersionAnnotation is the annotation of a secret in
+	// Secret Manager holding the name of its latest version.
+	googleCloudSecretVersionAnnotation = "webmesh-version"
+)
+
+// googleCloudSecretVersions are the names of the Secret Manager versions
+// holding the TLS material of a replica, by key of its certificate secret.
+type googleCloudSecretVersions map[string]string
+
+// googleCloudSecretID returns the ID of the secret in Secret Manager holding
+// the file with the given suffix of the replica with the given ordinal.
+// Secrets are shared by the whole project, so the ID includes the namespace
+// of the group.
+func googleCloudSecretID(group *meshv1.NodeGroup, ordinal int, suffix string) string {
+	return googleCloudLabelValue(fmt.Sprintf("webmesh-%s-%s-%d-%s", group.GetNamespace(), group.GetName(), ordinal, suffix))
+}
+
+// googleCloudSecretManagerCommand returns the command printing the payload of
+// the given Secret Manager version, read with a token of the service account
+// of the instance.
+func googleCloudSecretManagerCommand(version string) string {
+	token := `$(curl -fsS -H 'Metadata-Flavor: Google' http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token | sed -n 's/.*"access_token" *: *"\([^"]*\)".*/\1/p')`
+	return fmt.Sprintf(`curl -fsS --retry 5 -H "Authorization: Bearer %s" https://secretmanager.googleapis.com/v1/%s:access | sed -n 's/.*"data" *: *"\([^"]*\)".*/\1/p' | base64 -d`, token, version)
+}
+
+// googleCloudSecretsFetch returns how an instance fetches the TLS material of
+// its node from the given versions, or nil if it is part of the user-data.
+func googleCloudSecretsFetch(spec *meshv1.NodeGroupGoogleCloudConfig, versions googleCloudSecretVersions) *cloudconfig.SecretsFetch {
+	if !spec.UsesSecretManager() {
+		return nil
+	}
+	return &cloudconfig.SecretsFetch{
+		CertCommand: googleCloudSecretManagerCommand(versions[corev1.TLSCertKey]),
+		KeyCommand:  googleCloudSecretManagerCommand(versions[corev1.TLSPrivateKeyKey]),
+		CACommand:   googleCloudSecretManagerCommand(versions[cmmeta.TLSCAKey]),
+	}
+}
+
+// syncGoogleCloudSecrets stores the TLS material of the replicas of a group in
+// Secret Manager and returns the versions holding it. A version is added when
+// the material of a replica changes, and the secrets of removed replicas are
+// deleted.
+func syncGoogleCloudSecrets(ctx context.Context, clients *googleCloudClients, mesh *meshv1.Mesh, group *meshv1.NodeGroup, secrets map[int]*corev1.Secret) (map[int]googleCloudSecretVersions, error) {
+	spec := group.Spec.GoogleCloud
+	service, err := clients.SecretManager()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := listGoogleCloudSecrets(ctx, service, mesh, group)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[int]googleCloudSecretVersions, len(secrets))
+	for i := 0; i < int(group.Replicas()); i++ {
+		versions[i] = make(googleCloudSecretVersions, len(googleCloudSecretFiles))
+		for _, file := range googleCloudSecretFiles {
+			id := googleCloudSecretID(group, i, file.suffix)
+			version, err := ensureGoogleCloudSecret(ctx, service, spec.ProjectID, id, existing[id], googleCloudInstanceLabels(mesh, group, i), secrets[i].Data[file.key])
+			if err != nil {
+				return nil, err
+			}
+			versions[i][file.key] = version
+			delete(existing, id)
+		}
+	}
+	// What is left belongs to removed replicas
+	if err := deleteGoogleCloudSecrets(ctx, service, existing); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// ensureGoogleCloudSecret ensures the latest version of the secret with the
+// given ID holds the given data and returns its name. The secret is created
+// if it does not exist yet.
+func ensureGoogleCloudSecret(ctx context.Context, service *secretmanager.Service, project, id string, secret *secretmanager.Secret, labels map[string]string, data []byte) (string, error) {
+	checksum := fmt.Sprintf("%x", sha256.Sum256(data))
+	if secret != nil && secret.Annotations[googleCloudSecretChecksumAnnotation] == checksum && secret.Annotations[googleCloudSecretVersionAnnotation] != "" {
+		return secret.Annotations[googleCloudSecretVersionAnnotation], nil
+	}
+	log.FromContext(ctx).Info("Storing TLS material in Secret Manager", "secret", id)
+	if simulate(ctx, "add secret version", "secret", id) {
+		return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", project, id), nil
+	}
+	var err error
+	if secret == nil {
+		secret, err = service.Projects.Secrets.Create("projects/"+project, &secretmanager.Secret{
+			Labels:      labels,
+			Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+		}).SecretId(id).Context(ctx).Do()
+		if googleCloudErrorCode(err) == http.StatusConflict {
+			// Created by an earlier reconcile that failed to add a version
+			secret, err = service.Projects.Secrets.Get(fmt.Sprintf("projects/%s/secrets/%s", project, id)).Context(ctx).Do()
+		}
+		if err != nil {
+			return "", fmt.Errorf("create secret %s: %w", id, err)
+		}
+	}
+	version, err := service.Projects.Secrets.AddVersion(secret.Name, &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString(data)},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("add version to secret %s: %w", id, err)
+	}
+	_, err = service.Projects.Secrets.Patch(secret.Name, &secretmanager.Secret{
+		Annotations: map[string]string{
+			googleCloudSecretChecksumAnnotation: checksum,
+			googleCloudSecretVersionAnnotation:  version.Name,
+		},
+	}).UpdateMask("annotations").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("annotate secret %s: %w", id, err)
+	}
+	return version.Name, nil
+}
+
+// destroySupersededGoogleCloudSecretVersions destroys the versions of the
+// secrets of a group other than the given ones. It must only be called once
+// every instance references the given versions, since instances fetch their
+// TLS material again whenever the node restarts. Superseded versions hold the
+// keys of earlier certificates, which should not stay readable.
+func destroySupersededGoogleCloudSecretVersions(ctx context.Context, clients *googleCloudClients, versions map[int]googleCloudSecretVersions) error {
+	service, err := clients.SecretManager()
+	if err != nil {
+		return err
+	}
+	for _, replica := range versions {
+		for _, current := range replica {
+			if path.Base(current) == "latest" {
+				// A simulated version that was never added
+				continue
+			}
+			secret := path.Dir(path.Dir(current))
+			var superseded []string
+			err := service.Projects.Secrets.Versions.List(secret).
+				Filter("state:ENABLED OR state:DISABLED").
+				Pages(ctx, func(page *secretmanager.ListSecretVersionsResponse) error {
+					for _, version := range page.Versions {
+						if version.Name != current && version.State != "DESTROYED" {
+							superseded = append(superseded, version.Name)
+						}
+					}
+					return nil
+				})
+			if err != nil {
+				return fmt.Errorf("list versions of secret %s: %w", path.Base(secret), err)
+			}
+			for _, version := range superseded {
+				log.FromContext(ctx).Info("Destroying superseded secret version in Secret Manager", "version", version)
+				if simulate(ctx, "destroy secret version", "version", version) {
+					continue
+				}
+				_, err := service.Projects.Secrets.Versions.Destroy(version, &secretmanager.DestroySecretVersionRequest{}).Context(ctx).Do()
+				if err != nil && !isGoogleNotFound(err) {
+					return fmt.Errorf("destroy secret version %s: %w", version, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// listGoogleCloudSecrets lists the secrets of a group in Secret Manager by
+// their labels, keyed by their IDs.
+func listGoogleCloudSecrets(ctx context.Context, service *secretmanager.Service, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (map[string]*secretmanager.Secret, error) {
+	selector := googleCloudInstanceSelector(mesh, group)
+	out := make(map[string]*secretmanager.Secret)
+	err := service.Projects.Secrets.List("projects/"+group.Spec.GoogleCloud.ProjectID).
+		Filter(fmt.Sprintf("labels.mesh=%s AND labels.group=%s AND labels.namespace=%s", selector["mesh"], selector["group"], selector["namespace"])).
+		Pages(ctx, func(page *secretmanager.ListSecretsResponse) error {
+			for _, secret := range page.Secrets {
+				if !googleCloudLabelsMatch(secret.Labels, selector) {
+					continue
+				}
+				out[path.Base(secret.Name)] = secret
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	return out, nil
+}
+
+// googleCloudLabelsMatch returns true if the labels hold every label of the
+// selector.
+func googleCloudLabelsMatch(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteGoogleCloudSecrets deletes the given secrets from Secret Manager with
+// all their versions. Secrets that are already gone are ignored.
+func deleteGoogleCloudSecrets(ctx context.Context, service *secretmanager.Service, secrets map[string]*secretmanager.Secret) error {
+	for id, secret := range secrets {
+		log.FromContext(ctx).Info("Deleting secret from Secret Manager", "secret", id)
+		if simulate(ctx, "delete secret", "secret", id) {
+			continue
+		}
+		_, err := service.Projects.Secrets.Delete(secret.Name).Context(ctx).Do()
+		if err != nil && !isGoogleNotFound(err) {
+			return fmt.Errorf("delete secret %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// deleteGroupGoogleCloudSecrets deletes the secrets of a group from Secret
+// Manager.
+func deleteGroupGoogleCloudSecrets(ctx context.Context, clients *googleCloudClients, mesh *meshv1.Mesh, group *meshv1.NodeGroup) error {
+	service, err := clients.SecretManager()
+	if err != nil {
+		return err
+	}
+	existing, err := listGoogleCloudSecrets(ctx, service, mesh, group)
+	if err != nil {
+		return err
+	}
+	return deleteGoogleCloudSecrets(ctx, service, existing)
+}
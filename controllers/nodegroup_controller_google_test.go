@@ -0,0 +1,1664 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+func TestNewGoogleCloudNetworkInterface(t *testing.T) {
+	ipv4Only := &computepb.Subnetwork{Name: pointer("v4"), StackType: pointer("IPV4_ONLY")}
+	internalIPv6 := &computepb.Subnetwork{Name: pointer("internal"), StackType: pointer("IPV4_IPV6"), Ipv6AccessType: pointer("INTERNAL")}
+	externalIPv6 := &computepb.Subnetwork{Name: pointer("external"), StackType: pointer("IPV4_IPV6"), Ipv6AccessType: pointer("EXTERNAL")}
+	ipv6Only := &computepb.Subnetwork{Name: pointer("v6"), StackType: pointer("IPV6_ONLY"), Ipv6AccessType: pointer("EXTERNAL")}
+
+	tc := []struct {
+		name         string
+		spec         meshv1.NodeGroupGoogleCloudConfig
+		subnet       *computepb.Subnetwork
+		stackType    string
+		externalIPv4 bool
+		ipv6         bool
+		err          bool
+	}{
+		{
+			name:         "ipv4 only subnetwork",
+			subnet:       ipv4Only,
+			stackType:    "IPV4_ONLY",
+			externalIPv4: true,
+		},
+		{
+			name:         "internal ipv6 subnetwork",
+			subnet:       internalIPv6,
+			stackType:    "IPV4_IPV6",
+			externalIPv4: true,
+		},
+		{
+			name:         "external ipv6 subnetwork",
+			subnet:       externalIPv6,
+			stackType:    "IPV4_IPV6",
+			externalIPv4: true,
+			ipv6:         true,
+		},
+		{
+			name:         "standard tier leaves out ipv6",
+			spec:         meshv1.NodeGroupGoogleCloudConfig{NetworkTier: meshv1.GoogleCloudNetworkTierStandard},
+			subnet:       externalIPv6,
+			stackType:    "IPV4_IPV6",
+			externalIPv4: true,
+		},
+		{
+			name:         "ipv6 disabled",
+			spec:         meshv1.NodeGroupGoogleCloudConfig{EnableIPv6: pointer(false)},
+			subnet:       externalIPv6,
+			stackType:    "IPV4_IPV6",
+			externalIPv4: true,
+		},
+		{
+			name:   "ipv6 enabled on unsupported subnetwork",
+			spec:   meshv1.NodeGroupGoogleCloudConfig{EnableIPv6: pointer(true)},
+			subnet: internalIPv6,
+			err:    true,
+		},
+		{
+			name:         "ipv4 only on dual-stack subnetwork",
+			spec:         meshv1.NodeGroupGoogleCloudConfig{StackType: meshv1.GoogleCloudStackTypeIPv4Only},
+			subnet:       externalIPv6,
+			stackType:    "IPV4_ONLY",
+			externalIPv4: true,
+		},
+		{
+			name:      "ipv6 only on dual-stack subnetwork",
+			spec:      meshv1.NodeGroupGoogleCloudConfig{StackType: meshv1.GoogleCloudStackTypeIPv6Only},
+			subnet:    externalIPv6,
+			stackType: "IPV6_ONLY",
+			ipv6:      true,
+		},
+		{
+			name:      "ipv6 only subnetwork",
+			subnet:    ipv6Only,
+			stackType: "IPV6_ONLY",
+			ipv6:      true,
+		},
+		{
+			name:   "dual-stack on ipv4 only subnetwork",
+			spec:   meshv1.NodeGroupGoogleCloudConfig{StackType: meshv1.GoogleCloudStackTypeDualStack},
+			subnet: ipv4Only,
+			err:    true,
+		},
+		{
+			name:   "ipv4 only on ipv6 only subnetwork",
+			spec:   meshv1.NodeGroupGoogleCloudConfig{StackType: meshv1.GoogleCloudStackTypeIPv4Only},
+			subnet: ipv6Only,
+			err:    true,
+		},
+		{
+			name:      "no external addresses",
+			spec:      meshv1.NodeGroupGoogleCloudConfig{EnableExternalIPv4: pointer(false), EnableIPv6: pointer(false)},
+			subnet:    externalIPv6,
+			stackType: "IPV4_IPV6",
+		},
+		{
+			name:      "internal only",
+			spec:      meshv1.NodeGroupGoogleCloudConfig{InternalOnly: true},
+			subnet:    externalIPv6,
+			stackType: "IPV4_IPV6",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			nic, ipv6, err := newGoogleCloudNetworkInterface(&tt.spec, tt.subnet)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if nic.GetStackType() != tt.stackType {
+				t.Errorf("expected stack type %s, got %s", tt.stackType, nic.GetStackType())
+			}
+			if got := len(nic.AccessConfigs) > 0; got != tt.externalIPv4 {
+				t.Errorf("expected external IPv4 %v, got %v", tt.externalIPv4, got)
+			}
+			if got := len(nic.Ipv6AccessConfigs) > 0; got != tt.ipv6 || ipv6 != tt.ipv6 {
+				t.Errorf("expected external IPv6 %v, got %v", tt.ipv6, got)
+			}
+			if tt.spec.NetworkTier != "" && tt.externalIPv4 && nic.AccessConfigs[0].GetNetworkTier() != tt.spec.NetworkTier {
+				t.Errorf("expected network tier %s, got %s", tt.spec.NetworkTier, nic.AccessConfigs[0].GetNetworkTier())
+			}
+		})
+	}
+}
+
+func TestGoogleCloudNodeConfigOptions(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+
+	tc := []struct {
+		name            string
+		spec            meshv1.NodeGroupGoogleCloudConfig
+		ipv4Only        bool
+		ipv6            bool
+		ordinal         int
+		detect          bool
+		detectIPv6      bool
+		remote          bool
+		noIPv6          bool
+		static          googleCloudStaticAddresses
+		primaryEndpoint string
+	}{
+		{
+			name:   "defaults",
+			detect: true,
+			remote: true,
+		},
+		{
+			name:       "defaults with ipv6",
+			ipv6:       true,
+			detect:     true,
+			detectIPv6: true,
+			remote:     true,
+		},
+		{
+			name:     "ipv4 only network",
+			ipv4Only: true,
+			detect:   true,
+			remote:   true,
+			noIPv6:   true,
+		},
+		{
+			name:   "no external ipv4",
+			spec:   meshv1.NodeGroupGoogleCloudConfig{EnableExternalIPv4: pointer(false)},
+			detect: true,
+		},
+		{
+			name:   "remote detection without external ipv4",
+			spec:   meshv1.NodeGroupGoogleCloudConfig{EnableExternalIPv4: pointer(false), AllowRemoteDetection: pointer(true)},
+			detect: true,
+			remote: true,
+		},
+		{
+			name:   "remote detection disabled",
+			spec:   meshv1.NodeGroupGoogleCloudConfig{AllowRemoteDetection: pointer(false)},
+			detect: true,
+		},
+		{
+			name: "detection disabled",
+			spec: meshv1.NodeGroupGoogleCloudConfig{DetectEndpoints: pointer(false)},
+			ipv6: true,
+		},
+		{
+			name:            "internal only",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{InternalOnly: true},
+			detect:          true,
+			primaryEndpoint: `{{ env "INSTANCE_INTERNAL_IP" }}`,
+		},
+		{
+			name:            "internal only with primary endpoint",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{InternalOnly: true, PrimaryEndpoint: "10.128.0.10"},
+			detect:          true,
+			primaryEndpoint: "10.128.0.10",
+		},
+		{
+			name:            "primary endpoint",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{DetectEndpoints: pointer(false), PrimaryEndpoint: "203.0.113.10"},
+			primaryEndpoint: "203.0.113.10",
+		},
+		{
+			name:            "static address",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{StaticIPs: true},
+			static:          googleCloudStaticAddresses{IPv4: "203.0.113.20", IPv6: "2001:db8::"},
+			detect:          true,
+			remote:          true,
+			primaryEndpoint: "203.0.113.20",
+		},
+		{
+			name:            "static ipv6 address",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{StaticIPs: true, EnableExternalIPv4: pointer(false)},
+			static:          googleCloudStaticAddresses{IPv6: "2001:db8::"},
+			detect:          true,
+			primaryEndpoint: "2001:db8::",
+		},
+		{
+			name:            "primary endpoint over static address",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{StaticIPs: true, PrimaryEndpoint: "node.example.com"},
+			static:          googleCloudStaticAddresses{IPv4: "203.0.113.20"},
+			detect:          true,
+			remote:          true,
+			primaryEndpoint: "node.example.com",
+		},
+		{
+			name:            "templated primary endpoint",
+			spec:            meshv1.NodeGroupGoogleCloudConfig{PrimaryEndpoint: "node-{{ .Ordinal }}.example.com"},
+			ordinal:         2,
+			detect:          true,
+			remote:          true,
+			primaryEndpoint: "node-2.example.com",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec:       meshv1.NodeGroupSpec{GoogleCloud: &tt.spec},
+			}
+			nic := &computepb.NetworkInterface{StackType: pointer(meshv1.GoogleCloudStackTypeDualStack)}
+			if tt.ipv4Only {
+				nic.StackType = pointer(meshv1.GoogleCloudStackTypeIPv4Only)
+			}
+			if tt.ipv6 {
+				nic.Ipv6AccessConfigs = []*computepb.AccessConfig{{Name: pointer("wanv6")}}
+			}
+			opts, err := googleCloudNodeConfigOptions(mesh, group, "join:8443", nic, nil, tt.static, tt.ordinal)
+			if err != nil {
+				t.Fatalf("build options: %v", err)
+			}
+			conf, err := nodeconfig.New(opts)
+			if err != nil {
+				t.Fatalf("build config: %v", err)
+			}
+			global := conf.Options.Global
+			if global.DetectEndpoints != tt.detect {
+				t.Errorf("expected detect endpoints %v, got %v", tt.detect, global.DetectEndpoints)
+			}
+			if global.DetectIPv6 != tt.detectIPv6 {
+				t.Errorf("expected detect IPv6 %v, got %v", tt.detectIPv6, global.DetectIPv6)
+			}
+			if global.AllowRemoteDetection != tt.remote {
+				t.Errorf("expected remote detection %v, got %v", tt.remote, global.AllowRemoteDetection)
+			}
+			if global.DisableIPv6 != tt.noIPv6 {
+				t.Errorf("expected IPv6 disabled %v, got %v", tt.noIPv6, global.DisableIPv6)
+			}
+			if got := conf.Options.Mesh.PrimaryEndpoint; got != tt.primaryEndpoint {
+				t.Errorf("expected primary endpoint %q, got %q", tt.primaryEndpoint, got)
+			}
+		})
+	}
+}
+
+func TestGetHostAliases(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	exposed := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "exposed", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:     corev1.ObjectReference{Name: "mesh"},
+			Replicas: pointer(int32(1)),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{Type: corev1.ServiceTypeLoadBalancer},
+			},
+		},
+	}
+	internal := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:    corev1.ObjectReference{Name: "mesh"},
+			Cluster: &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	lb := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshNodeGroupLBName(mesh, exposed), Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}},
+		}},
+	}
+	r := &NodeGroupReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(exposed, internal, lb).Build(),
+	}
+	newGroup := func(aliases ...string) *meshv1.NodeGroup {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{
+				Mesh:        corev1.ObjectReference{Name: "mesh"},
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+			},
+		}
+		for _, alias := range aliases {
+			group.Spec.GoogleCloud.HostAliases = append(group.Spec.GoogleCloud.HostAliases, meshv1.GoogleCloudHostAlias{NodeGroup: alias})
+		}
+		return group
+	}
+
+	group := newGroup("exposed")
+	aliases, err := r.getHostAliases(context.Background(), mesh, group)
+	if err != nil {
+		t.Fatalf("get host aliases: %v", err)
+	}
+	want := []cloudconfig.HostAlias{{
+		IP: "203.0.113.10",
+		Hostnames: []string{
+			meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, exposed, meshv1.DefaultClusterDomain),
+			meshv1.MeshNodeClusterFQDN(mesh, exposed, 0, meshv1.DefaultClusterDomain),
+		},
+	}}
+	if !reflect.DeepEqual(aliases, want) {
+		t.Fatalf("expected host aliases %v, got %v", want, aliases)
+	}
+
+	// Joining through the cluster names is allowed once they are aliased
+	joinServer := fmt.Sprintf("%s:%d", meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, exposed, meshv1.DefaultClusterDomain), meshv1.DefaultGRPCPort)
+	opts, err := googleCloudNodeConfigOptions(mesh, group, joinServer, &computepb.NetworkInterface{}, aliases, googleCloudStaticAddresses{}, 0)
+	if err != nil {
+		t.Fatalf("build options: %v", err)
+	}
+	if _, err := nodeconfig.New(opts); err != nil {
+		t.Errorf("expected aliased join server to be allowed: %v", err)
+	}
+	opts, err = googleCloudNodeConfigOptions(mesh, group, joinServer, &computepb.NetworkInterface{}, nil, googleCloudStaticAddresses{}, 0)
+	if err != nil {
+		t.Fatalf("build options: %v", err)
+	}
+	if _, err := nodeconfig.New(opts); err == nil {
+		t.Error("expected cluster join server without alias to be rejected")
+	}
+
+	if _, err := r.getHostAliases(context.Background(), mesh, newGroup("internal")); err == nil {
+		t.Error("expected an error for a group that is not exposed")
+	}
+}
+
+func TestGoogleCloudOrphans(t *testing.T) {
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas:    pointer(int32(2)),
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	instance := func(name string, labels map[string]string) *computepb.Instance {
+		return &computepb.Instance{Name: pointer(name), Labels: labels}
+	}
+	instances := []*computepb.Instance{
+		instance("vms-0", map[string]string{"index": "0"}),
+		instance("vms-1", map[string]string{"index": "1"}),
+		instance("vms-2", map[string]string{"index": "2"}),
+		// Created before the index label was set
+		instance("vms-3", nil),
+		instance("vms-10", map[string]string{}),
+		// Not a replica of the group
+		instance("vms-extra", nil),
+		instance("other-5", nil),
+		instance("vms-4", map[string]string{"index": "bad"}),
+	}
+	// Managed instances carry their index in metadata
+	managed := instance("vms-5", nil)
+	managed.Metadata = &computepb.Metadata{Items: []*computepb.Items{
+		{Key: pointer(googleCloudIndexMetadataKey), Value: pointer("5")},
+	}}
+	instances = append(instances, managed)
+	var got []string
+	for _, orphan := range googleCloudOrphans(group, instances) {
+		got = append(got, orphan.GetName())
+	}
+	want := []string{"vms-2", "vms-3", "vms-10", "vms-5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got orphans %v, want %v", got, want)
+	}
+}
+
+func TestGoogleCloudLabels(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tc := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "valid labels are kept",
+			labels: map[string]string{"team": "network", "cost_center": "a-1"},
+			want:   map[string]string{"team": "network", "cost_center": "a-1"},
+		},
+		{
+			name:   "prefixed keys",
+			labels: map[string]string{"example.com/team": "network"},
+			want:   map[string]string{"example-com_team": "network"},
+		},
+		{
+			name:   "uppercase and dots",
+			labels: map[string]string{"Tier": "Edge.Nodes"},
+			want:   map[string]string{"tier": "edge-nodes"},
+		},
+		{
+			name:   "keys starting with a digit",
+			labels: map[string]string{"1st": "true"},
+			want:   map[string]string{"k-1st": "true"},
+		},
+		{
+			name:   "empty values",
+			labels: map[string]string{"canary": ""},
+			want:   map[string]string{"canary": ""},
+		},
+		{
+			name:   "colliding keys keep the first in order",
+			labels: map[string]string{"a.b": "dot", "a-b": "dash"},
+			want:   map[string]string{"a-b": "dash"},
+		},
+		{
+			name:   "long values",
+			labels: map[string]string{"name": long},
+			want:   map[string]string{"name": googleCloudLabelValue(long)},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got := googleCloudLabels(tt.labels)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	truncated := googleCloudLabelValue(long)
+	if len(truncated) != maxGoogleCloudLabelLength || !strings.HasPrefix(truncated, "aaaa") {
+		t.Errorf("expected the value to be truncated to %d characters, got %q", maxGoogleCloudLabelLength, truncated)
+	}
+	if googleCloudLabelValue(long+"b") == truncated {
+		t.Errorf("expected distinct long values to remain distinct")
+	}
+
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh.prod", Namespace: "default"}}
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{
+		Name:      "edge",
+		Namespace: "team-a",
+		Labels: map[string]string{
+			meshv1.NodeGroupNameLabel:   "edge",
+			"app.kubernetes.io/part-of": "Network",
+			"example.com/secret":        "not-propagated",
+		},
+	}}
+	want := map[string]string{
+		"mesh":                      "mesh-prod",
+		"group":                     "edge",
+		"namespace":                 "team-a",
+		"index":                     "2",
+		"app-kubernetes-io_part-of": "network",
+	}
+	if got := googleCloudInstanceLabels(mesh, group, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected instance labels %v, got %v", want, got)
+	}
+}
+
+func TestGoogleCloudReplicaAvailability(t *testing.T) {
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas:    pointer(int32(4)),
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	instance := func(name, status string) *computepb.Instance {
+		return &computepb.Instance{Name: pointer(name), Status: pointer(status)}
+	}
+	instances := []*computepb.Instance{
+		instance("vms-0", "RUNNING"),
+		instance("vms-1", "RUNNING"),
+		// Recreated after a config change
+		instance("vms-2", "PROVISIONING"),
+		// Removed replica
+		instance("vms-4", "RUNNING"),
+	}
+	probed := map[string]bool{"vms-0": true, "vms-1": false}
+	got := googleCloudReplicaAvailability(group, instances, func(instance *computepb.Instance) bool {
+		return probed[instance.GetName()]
+	})
+	want := map[int]bool{0: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got available replicas %v, want %v", got, want)
+	}
+	if max := group.Spec.GoogleCloud.MaxUnavailableReplicas(); max != 1 {
+		t.Errorf("expected one unavailable replica by default, got %d", max)
+	}
+}
+
+func TestGoogleCloudInstanceDetails(t *testing.T) {
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas:    pointer(int32(2)),
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	zone := "https://www.googleapis.com/compute/v1/projects/demo/zones/us-central1-a"
+	instances := []*computepb.Instance{
+		{
+			Name:   pointer("vms-1"),
+			Zone:   &zone,
+			Status: pointer("PROVISIONING"),
+			NetworkInterfaces: []*computepb.NetworkInterface{{
+				NetworkIP: pointer("10.0.0.3"),
+			}},
+		},
+		{
+			Name:   pointer("vms-0"),
+			Zone:   &zone,
+			Status: pointer("RUNNING"),
+			NetworkInterfaces: []*computepb.NetworkInterface{{
+				NetworkIP:         pointer("10.0.0.2"),
+				Ipv6Address:       pointer("fd20::2"),
+				AccessConfigs:     []*computepb.AccessConfig{{NatIP: pointer("203.0.113.10")}},
+				Ipv6AccessConfigs: []*computepb.AccessConfig{{ExternalIpv6: pointer("2001:db8::10")}},
+			}},
+		},
+		// Removed replica
+		{Name: pointer("vms-2"), Zone: &zone, Status: pointer("RUNNING")},
+	}
+	want := []meshv1.NodeGroupInstanceStatus{
+		{
+			Ordinal:      0,
+			Name:         "vms-0",
+			Zone:         "us-central1-a",
+			Status:       "RUNNING",
+			InternalIPv4: "10.0.0.2",
+			InternalIPv6: "fd20::2",
+			ExternalIPv4: "203.0.113.10",
+			ExternalIPv6: "2001:db8::10",
+		},
+		{
+			Ordinal:      1,
+			Name:         "vms-1",
+			Zone:         "us-central1-a",
+			Status:       "PROVISIONING",
+			InternalIPv4: "10.0.0.3",
+		},
+	}
+	got := googleCloudInstanceDetails(group, instances)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected instance details %+v, got %+v", want, got)
+	}
+	if !googleCloudInstancesSettling(got) {
+		t.Error("expected a provisioning instance to be settling")
+	}
+	if googleCloudInstancesSettling(got[:1]) {
+		t.Error("expected running instances to have settled")
+	}
+}
+
+func TestGoogleCloudBootImage(t *testing.T) {
+	// An explicit image is used without looking up an image family
+	spec := &meshv1.NodeGroupGoogleCloudConfig{Image: "projects/golden/global/images/router-v2"}
+	image, err := googleCloudBootImage(context.Background(), spec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image != spec.Image {
+		t.Errorf("expected image %q, got %q", spec.Image, image)
+	}
+	family, project := (&meshv1.NodeGroupGoogleCloudConfig{}).BootImageFamily()
+	if family != meshv1.DefaultGoogleCloudImageFamily || project != meshv1.DefaultGoogleCloudImageProject {
+		t.Errorf("expected the default image family, got %s/%s", project, family)
+	}
+}
+
+func TestGoogleCloudBootDisk(t *testing.T) {
+	spec := &meshv1.NodeGroupGoogleCloudConfig{Zone: "us-central1-a"}
+	disk := googleCloudBootDisk(spec, "image")
+	if disk.DiskSizeGb != nil || disk.DiskType != nil {
+		t.Errorf("expected the image and zone defaults, got %v", disk)
+	}
+	// Instances with a default boot disk keep their checksum
+	if got := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum"); got != "checksum" {
+		t.Errorf("expected the config checksum, got %q", got)
+	}
+
+	spec.DiskSizeGB = pointer(int64(50))
+	spec.DiskType = meshv1.GoogleCloudDiskTypeSSD
+	disk = googleCloudBootDisk(spec, "image")
+	if disk.GetDiskSizeGb() != 50 || disk.GetDiskType() != "zones/us-central1-a/diskTypes/pd-ssd" {
+		t.Errorf("unexpected boot disk %v", disk)
+	}
+	sized := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum")
+	if sized == "checksum" {
+		t.Errorf("expected the boot disk to change the checksum")
+	}
+	spec.DiskType = meshv1.GoogleCloudDiskTypeBalanced
+	if googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum") == sized {
+		t.Errorf("expected the disk type to change the checksum")
+	}
+}
+
+func TestGoogleCloudTemplateName(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+				Zone:        "us-central1-a",
+				MachineType: "e2-small",
+				DiskType:    meshv1.GoogleCloudDiskTypeSSD,
+			},
+		},
+	}
+	nic := &computepb.NetworkInterface{Subnetwork: pointer("default")}
+	props := googleCloudTemplateProperties(mesh, group, "image", nic)
+	if _, ok := props.GetLabels()["index"]; ok {
+		t.Errorf("expected template labels without an index, got %v", props.GetLabels())
+	}
+	if got := props.GetDisks()[0].GetInitializeParams().GetDiskType(); got != "pd-ssd" {
+		t.Errorf("expected a zoneless disk type, got %q", got)
+	}
+	name := googleCloudTemplateName(group, props)
+	if !strings.HasPrefix(name, "vms-") || len(name) != len("vms-")+10 {
+		t.Errorf("unexpected template name %q", name)
+	}
+	// Names are stable for the same settings and change with them
+	if got := googleCloudTemplateName(group, googleCloudTemplateProperties(mesh, group, "image", nic)); got != name {
+		t.Errorf("expected a stable template name, got %q and %q", name, got)
+	}
+	group.Spec.GoogleCloud.MachineType = "e2-medium"
+	if got := googleCloudTemplateName(group, googleCloudTemplateProperties(mesh, group, "image", nic)); got == name {
+		t.Errorf("expected the machine type to change the template name")
+	}
+
+	group.SetName(strings.Repeat("a", 62) + "-b")
+	name = googleCloudTemplateName(group, props)
+	if len(name) > 63 || strings.Contains(name, "--") {
+		t.Errorf("unexpected template name %q", name)
+	}
+}
+
+func TestGoogleCloudServiceAccountChecksum(t *testing.T) {
+	spec := &meshv1.NodeGroupGoogleCloudConfig{}
+	if got := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum"); got != "checksum" {
+		t.Errorf("expected the config checksum without a service account, got %q", got)
+	}
+	spec.ServiceAccountEmail = "router@project.iam.gserviceaccount.com"
+	if scopes := spec.ServiceAccountScopes(); len(scopes) != 1 || scopes[0] != meshv1.DefaultGoogleCloudScope {
+		t.Errorf("expected the default scope, got %v", scopes)
+	}
+	attached := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum")
+	if attached == "checksum" {
+		t.Errorf("expected the service account to change the checksum")
+	}
+	spec.Scopes = []string{"https://www.googleapis.com/auth/devstorage.read_only"}
+	if googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum") == attached {
+		t.Errorf("expected the scopes to change the checksum")
+	}
+}
+
+func TestGoogleCloudScheduling(t *testing.T) {
+	spec := &meshv1.NodeGroupGoogleCloudConfig{}
+	if scheduling := googleCloudScheduling(spec); scheduling != nil {
+		t.Errorf("expected the default scheduling, got %v", scheduling)
+	}
+	if got := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum"); got != "checksum" {
+		t.Errorf("expected the config checksum, got %q", got)
+	}
+
+	spec.ProvisioningModel = meshv1.GoogleCloudProvisioningModelSpot
+	scheduling := googleCloudScheduling(spec)
+	if scheduling.GetProvisioningModel() != "SPOT" || scheduling.AutomaticRestart == nil || *scheduling.AutomaticRestart ||
+		scheduling.GetOnHostMaintenance() != meshv1.GoogleCloudOnHostMaintenanceTerminate {
+		t.Errorf("expected spot instances to terminate without restarting, got %v", scheduling)
+	}
+	spot := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum")
+	if spot == "checksum" {
+		t.Errorf("expected the provisioning model to change the checksum")
+	}
+
+	// Explicitly disabling restarts differs from the default
+	spec = &meshv1.NodeGroupGoogleCloudConfig{OnHostMaintenance: meshv1.GoogleCloudOnHostMaintenanceMigrate}
+	migrate := googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum")
+	spec.AutomaticRestart = pointer(false)
+	if googleCloudInstanceChecksum(spec, googleCloudStaticAddresses{}, "checksum") == migrate {
+		t.Errorf("expected automatic restarts to change the checksum")
+	}
+}
+
+func TestGoogleCloudPreempted(t *testing.T) {
+	spot := &computepb.Instance{Scheduling: &computepb.Scheduling{ProvisioningModel: pointer("SPOT")}}
+	group := &meshv1.NodeGroup{}
+	if !googleCloudPreempted(group, spot) {
+		t.Errorf("expected a stopped spot instance to be preempted")
+	}
+	if googleCloudPreempted(group, &computepb.Instance{}) {
+		t.Errorf("expected standard instances not to be preempted")
+	}
+	// Instances stopped by the schedule are started again when it resumes
+	group.Status.Schedule = &meshv1.NodeGroupScheduleStatus{State: meshv1.ScheduleStateSuspended}
+	if googleCloudPreempted(group, spot) {
+		t.Errorf("expected instances stopped by the schedule not to be preempted")
+	}
+}
+
+func TestGoogleCloudStaticAddresses(t *testing.T) {
+	spec := &meshv1.NodeGroupGoogleCloudConfig{}
+	dualStack := &computepb.Subnetwork{
+		Name:           pointer("dual"),
+		StackType:      pointer("IPV4_IPV6"),
+		Ipv6AccessType: pointer("EXTERNAL"),
+	}
+	nic, _, err := newGoogleCloudNetworkInterface(spec, dualStack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := googleCloudReplicaInterface(nic, googleCloudStaticAddresses{}); got != nic {
+		t.Errorf("expected replicas without static addresses to use ephemeral addresses")
+	}
+	static := googleCloudStaticAddresses{IPv4: "203.0.113.10", IPv6: "2001:db8::"}
+	replica := googleCloudReplicaInterface(nic, static)
+	if replica.AccessConfigs[0].GetNatIP() != static.IPv4 {
+		t.Errorf("expected the static IPv4 address, got %q", replica.AccessConfigs[0].GetNatIP())
+	}
+	if v6 := replica.Ipv6AccessConfigs[0]; v6.GetExternalIpv6() != static.IPv6 || v6.GetExternalIpv6PrefixLength() != 96 {
+		t.Errorf("expected the static IPv6 range, got %v", v6)
+	}
+	if nic.AccessConfigs[0].NatIP != nil {
+		t.Errorf("expected the shared interface to be left unchanged")
+	}
+	if googleCloudInstanceChecksum(spec, static, "checksum") == "checksum" {
+		t.Errorf("expected static addresses to change the checksum")
+	}
+
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "router"}}
+	group.Status.ReservedAddresses = []string{"router-0-v4", "router-1-v4", "router-1-v6"}
+	stale := staleGoogleCloudAddresses(group, []string{googleCloudAddressName("router-0", "v4")})
+	if !reflect.DeepEqual(stale, []string{"router-1-v4", "router-1-v6"}) {
+		t.Errorf("expected the addresses of the removed replica to be stale, got %v", stale)
+	}
+}
+
+func TestGoogleCloudRecordReservedAddress(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"}}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(group).WithStatusSubresource(group).Build()
+	r := googleCloudProvider{&NodeGroupReconciler{Client: cli}}
+	record := r.recordReservedAddress(group)
+	// Each address is persisted as soon as it exists, before the rest of
+	// the reconcile can fail
+	for _, name := range []string{"router-0-v4", "router-0-v4", "router-1-v4"} {
+		if err := record(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var persisted meshv1.NodeGroup
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(group), &persisted); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"router-0-v4", "router-1-v4"}; !reflect.DeepEqual(persisted.Status.ReservedAddresses, want) {
+		t.Errorf("expected reserved addresses %v, got %v", want, persisted.Status.ReservedAddresses)
+	}
+}
+
+func TestGoogleCloudClientsCache(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gcp", Namespace: "default"},
+		Data:       map[string][]byte{"key.json": []byte(`{"type":"service_account"}`)},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+	r := &NodeGroupReconciler{Client: cli}
+	newGroup := func(name, project string) *meshv1.NodeGroup {
+		return &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+					ProjectID: project,
+					Credentials: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "gcp"},
+						Key:                  "key.json",
+					},
+				},
+			},
+		}
+	}
+
+	// Groups sharing credentials and a project share their clients
+	first, err := r.getGoogleCloudClients(ctx, newGroup("a", "project"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shared, err := r.getGoogleCloudClients(ctx, newGroup("b", "project"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shared != first {
+		t.Error("expected groups with the same credentials and project to share clients")
+	}
+	other, err := r.getGoogleCloudClients(ctx, newGroup("c", "other"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == first {
+		t.Error("expected groups in another project to use other clients")
+	}
+
+	// Lookups are reused until they expire
+	now := time.Now()
+	first.now = func() time.Time { return now }
+	var calls int
+	get := func() (proto.Message, error) {
+		calls++
+		return &computepb.Subnetwork{Name: pointer(fmt.Sprintf("subnet-%d", calls))}, nil
+	}
+	for i := 0; i < 2; i++ {
+		subnet, err := first.lookup("subnet", get)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name := subnet.(*computepb.Subnetwork).GetName(); name != "subnet-1" {
+			t.Errorf("expected the cached subnet, got %s", name)
+		}
+	}
+	now = now.Add(googleCloudLookupTTL)
+	subnet, err := first.lookup("subnet", get)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name := subnet.(*computepb.Subnetwork).GetName(); name != "subnet-2" {
+		t.Errorf("expected the expired lookup to be made again, got %s", name)
+	}
+	if _, err := first.lookup("failing", func() (proto.Message, error) {
+		return nil, fmt.Errorf("unavailable")
+	}); err == nil {
+		t.Error("expected the error of the lookup")
+	}
+	if _, ok := first.lookups["failing"]; ok {
+		t.Error("expected failed lookups not to be cached")
+	}
+
+	// Changed credentials replace the clients and their lookups
+	secret.Data["key.json"] = []byte(`{"type":"service_account","private_key_id":"rotated"}`)
+	if err := cli.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := r.getGoogleCloudClients(ctx, newGroup("a", "project"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rotated == first {
+		t.Fatal("expected new clients after the credentials changed")
+	}
+	if len(first.lookups) != 0 {
+		t.Error("expected the lookups of the replaced clients to be dropped")
+	}
+	if len(rotated.lookups) != 0 {
+		t.Error("expected the new clients to start without lookups")
+	}
+}
+
+func TestGetNodeCertificateSecrets(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:        corev1.ObjectReference{Name: "mesh"},
+			Replicas:    pointer(int32(3)),
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	issued := map[string][]byte{
+		corev1.TLSCertKey:       []byte("cert"),
+		corev1.TLSPrivateKeyKey: []byte("key"),
+		"ca.crt":                []byte("ca"),
+	}
+	labeled := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meshv1.MeshNodeCertName(mesh, group, 0),
+			Namespace: "default",
+			Labels:    meshv1.NodeGroupSelector(mesh, group),
+		},
+		Data: issued,
+	}
+	// Created before the secrets were labeled
+	unlabeled := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshNodeCertName(mesh, group, 1), Namespace: "default"},
+		Data:       issued,
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(labeled, unlabeled).Build()
+	r := &NodeGroupReconciler{Client: cli}
+
+	secrets, missing, err := r.getNodeCertificateSecrets(ctx, mesh, group)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(missing, []int{2}) {
+		t.Errorf("expected the secret of replica 2 to be missing, got %v", missing)
+	}
+	if len(secrets) != 2 || secrets[0].GetName() != labeled.GetName() || secrets[1].GetName() != unlabeled.GetName() {
+		t.Errorf("expected the secrets of replicas 0 and 1, got %v", secrets)
+	}
+
+	// A secret without a certificate is still missing
+	pending := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meshv1.MeshNodeCertName(mesh, group, 2),
+			Namespace: "default",
+			Labels:    meshv1.NodeGroupSelector(mesh, group),
+		},
+		Data: map[string][]byte{corev1.TLSPrivateKeyKey: []byte("key")},
+	}
+	if err := cli.Create(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	_, missing, err = r.getNodeCertificateSecrets(ctx, mesh, group)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(missing, []int{2}) {
+		t.Errorf("expected the incomplete secret of replica 2 to be missing, got %v", missing)
+	}
+
+	// Every replica is ready once all certificates are issued
+	pending.Data = issued
+	if err := cli.Update(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	secrets, missing, err = r.getNodeCertificateSecrets(ctx, mesh, group)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 0 || len(secrets) != 3 {
+		t.Errorf("expected the secrets of every replica, got %d with %v missing", len(secrets), missing)
+	}
+}
+
+func TestGetGoogleCloudCredentials(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	federation := `{"type":"external_account","audience":"//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/k8s/providers/cluster"}`
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "federation", Namespace: "default"},
+		Data: map[string]string{
+			"credentials.json": federation,
+			"key.json":         `{"type":"service_account"}`,
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm).Build()
+	r := &NodeGroupReconciler{Client: cli}
+	newGroup := func(key, impersonate string) *meshv1.NodeGroup {
+		return &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+					ProjectID: "project",
+					ExternalAccount: &meshv1.GoogleCloudExternalAccount{
+						ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "federation"},
+							Key:                  key,
+						},
+					},
+					ImpersonateServiceAccount: impersonate,
+				},
+			},
+		}
+	}
+
+	creds, err := r.getGoogleCloudCredentials(ctx, newGroup("credentials.json", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(creds) != federation {
+		t.Errorf("expected the external account configuration, got %s", creds)
+	}
+	if _, err := r.getGoogleCloudCredentials(ctx, newGroup("key.json", "")); err == nil {
+		t.Error("expected an error for a configuration that is not an external account")
+	}
+	if _, err := r.getGoogleCloudCredentials(ctx, newGroup("missing.json", "")); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+
+	// Impersonated service accounts get their own clients
+	plain := googleCloudClientsKey(newGroup("credentials.json", ""))
+	impersonated := googleCloudClientsKey(newGroup("credentials.json", "router@project.iam.gserviceaccount.com"))
+	if plain == impersonated {
+		t.Errorf("expected impersonation to be part of the clients key, got %s", plain)
+	}
+}
+
+func TestGoogleCloudExtraCloudConfig(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func() *cloudconfig.Config {
+		conf, err := cloudconfig.New(cloudconfig.Options{
+			Image:         "ghcr.io/webmeshproj/node:latest",
+			Config:        nodeconf,
+			ExtraFiles:    googleCloudExtraFiles(group.Spec.GoogleCloud),
+			ExtraPackages: group.Spec.GoogleCloud.ExtraPackages,
+			ExtraRunCmd:   group.Spec.GoogleCloud.ExtraRunCmd,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+	plain := render()
+
+	group.Spec.GoogleCloud.ExtraFiles = []meshv1.CloudConfigFile{{Path: "/etc/sysctl.d/99-custom.conf", Content: "vm.swappiness=10\n"}}
+	group.Spec.GoogleCloud.ExtraPackages = []string{"prometheus-node-exporter"}
+	group.Spec.GoogleCloud.ExtraRunCmd = []string{"sysctl --system"}
+	extra := render()
+	if extra.Checksum() == plain.Checksum() {
+		t.Error("expected the extra entries to change the checksum")
+	}
+	files := extra.Files()
+	last := files[len(files)-1]
+	if last.Path != "/etc/sysctl.d/99-custom.conf" || last.Permissions != "0644" || string(last.Content) != "vm.swappiness=10\n" {
+		t.Errorf("expected the extra file to be written last, got %+v", last)
+	}
+	raw := string(extra.Raw())
+	if !strings.Contains(raw, "- prometheus-node-exporter\n") {
+		t.Errorf("expected the extra package to be installed:\n%s", raw)
+	}
+	if start, cmd := strings.Index(raw, "- systemctl start node"), strings.Index(raw, "- sysctl --system"); cmd < start {
+		t.Errorf("expected the extra command to run after the node is started:\n%s", raw)
+	}
+}
+
+func TestGoogleCloudIgnitionConfig(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+				OSFamily:     meshv1.OSFamilyFlatcar,
+				InternalOnly: true,
+				ExtraRunCmd:  []string{"sysctl --system"},
+			},
+		},
+	}
+	if family, project := group.Spec.GoogleCloud.BootImageFamily(); family != "flatcar-stable" || project != "kinvolk-public" {
+		t.Errorf("expected the flatcar image family by default, got %s/%s", project, family)
+	}
+	render := func(joinServer string) *cloudconfig.Config {
+		nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: joinServer, CertDir: meshv1.DefaultTLSDirectory})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := cloudconfig.New(cloudconfig.Options{
+			Image:       "ghcr.io/webmeshproj/node:latest",
+			Config:      nodeconf,
+			CommandEnv:  googleCloudCommandEnv(group.Spec.GoogleCloud),
+			ExtraRunCmd: group.Spec.GoogleCloud.ExtraRunCmd,
+			Users:       []cloudconfig.User{{Name: "ops", AuthorizedKeys: []string{"ssh-ed25519 AAAA"}, Sudo: true}},
+			OSFamily:    group.Spec.OSFamily(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+	conf := render("203.0.113.1:8443")
+
+	var ign struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+		Passwd struct {
+			Users []struct {
+				Name   string   `json:"name"`
+				Groups []string `json:"groups"`
+			} `json:"users"`
+		} `json:"passwd"`
+		Storage struct {
+			Files []struct {
+				Path     string `json:"path"`
+				Mode     int    `json:"mode"`
+				Contents struct {
+					Source string `json:"source"`
+				} `json:"contents"`
+			} `json:"files"`
+		} `json:"storage"`
+		Systemd struct {
+			Units []struct {
+				Name     string `json:"name"`
+				Enabled  bool   `json:"enabled"`
+				Contents string `json:"contents"`
+				Dropins  []struct {
+					Contents string `json:"contents"`
+				} `json:"dropins"`
+			} `json:"units"`
+		} `json:"systemd"`
+	}
+	if err := json.Unmarshal(conf.Raw(), &ign); err != nil {
+		t.Fatalf("expected an Ignition config: %v\n%s", err, conf.Raw())
+	}
+	if ign.Ignition.Version != "3.3.0" {
+		t.Errorf("expected Ignition spec 3.3.0, got %q", ign.Ignition.Version)
+	}
+	if strings.Contains(string(conf.Raw()), "apt-get") {
+		t.Error("expected no packages to be installed")
+	}
+	files := make(map[string]int)
+	for _, file := range ign.Storage.Files {
+		files[file.Path] = file.Mode
+	}
+	for _, path := range []string{meshv1.DefaultConfigPath, meshv1.DefaultTLSDirectory + "/tls.key", "/etc/docker/daemon.json", "/etc/webmesh-instance-env.sh"} {
+		if _, ok := files[path]; !ok {
+			t.Errorf("expected %s to be written, got %v", path, files)
+		}
+	}
+	if mode := files["/etc/webmesh-runcmd.sh"]; mode != 0o755 {
+		t.Errorf("expected an executable script for the extra commands, got mode %o", mode)
+	}
+	if _, ok := files["/etc/systemd/system/node.service"]; ok {
+		t.Error("expected the node unit to be a systemd unit rather than a file")
+	}
+	units := make(map[string]int, len(ign.Systemd.Units))
+	for i, unit := range ign.Systemd.Units {
+		units[unit.Name] = i
+	}
+	node, ok := units["node.service"]
+	if !ok || !ign.Systemd.Units[node].Enabled || !strings.Contains(ign.Systemd.Units[node].Contents, "ghcr.io/webmeshproj/node:latest") {
+		t.Fatalf("expected an enabled node unit, got %+v", ign.Systemd.Units)
+	}
+	if dropins := ign.Systemd.Units[node].Dropins; len(dropins) != 1 || !strings.Contains(dropins[0].Contents, "After=webmesh-instance-env.service") {
+		t.Errorf("expected the node to start after the instance environment is read, got %+v", dropins)
+	}
+	if runcmd, ok := units["webmesh-runcmd.service"]; !ok || runcmd < node || !strings.Contains(ign.Systemd.Units[runcmd].Contents, "After=node.service") {
+		t.Errorf("expected the extra commands to run after the node, got %+v", ign.Systemd.Units)
+	}
+	if users := ign.Passwd.Users; len(users) != 1 || users[0].Name != "ops" || !slices.Equal(users[0].Groups, []string{"sudo"}) {
+		t.Errorf("expected the ops user in the sudo group, got %+v", users)
+	}
+
+	// The checksum only depends on the rendered configs
+	if render("203.0.113.1:8443").Checksum() != conf.Checksum() {
+		t.Error("expected the checksum to be stable")
+	}
+	if render("203.0.113.2:8443").Checksum() == conf.Checksum() {
+		t.Error("expected a change to the node config to change the checksum")
+	}
+	group.Spec.GoogleCloud.OSFamily = ""
+	if render("203.0.113.1:8443").Checksum() == conf.Checksum() {
+		t.Error("expected the format of the user data to change the checksum")
+	}
+}
+
+func TestGoogleCloudInPlaceCertificateRenewal(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+				CertificateRenewal: meshv1.GoogleCloudCertificateRenewalInPlace,
+			},
+		},
+	}
+	nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCA(t)
+	issued, renewed := &corev1.Secret{Data: ca.issue(t)}, &corev1.Secret{Data: ca.issue(t)}
+	render := func(secret *corev1.Secret) *cloudconfig.Config {
+		conf, err := cloudconfig.New(cloudconfig.Options{
+			Image:          "ghcr.io/webmeshproj/node:latest",
+			Config:         nodeconf,
+			TLSCert:        secret.Data[corev1.TLSCertKey],
+			TLSKey:         secret.Data[corev1.TLSPrivateKeyKey],
+			CA:             secret.Data[cmmeta.TLSCAKey],
+			SecretsRefresh: googleCloudSecretsRefresh(group.Spec.GoogleCloud),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+
+	before, after := render(issued), render(renewed)
+	if before.Checksum() != after.Checksum() {
+		t.Error("expected renewed certificates to keep the checksum of the config")
+	}
+	if before.SecretsChecksum() == after.SecretsChecksum() {
+		t.Error("expected renewed certificates to change the secrets checksum")
+	}
+	raw := string(after.Raw())
+	for _, want := range []string{
+		"systemctl enable --now webmesh-secrets-refresh.timer",
+		"instance/attributes/webmesh-tls-key > /etc/webmesh/tls/tls.key.new",
+		after.SecretsChecksum(),
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("expected the cloud config to contain %q:\n%s", want, raw)
+		}
+	}
+
+	// The certificates are published next to the cloud config
+	items := googleCloudInstanceMetadata(group.Spec.GoogleCloud, after, renewed)
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		values[item.GetKey()] = item.GetValue()
+	}
+	if values[googleCloudSecretsChecksumMetadataKey] != after.SecretsChecksum() || values[googleCloudTLSCertMetadataKey] != string(renewed.Data[corev1.TLSCertKey]) {
+		t.Errorf("expected the renewed certificate in the metadata, got %v", values)
+	}
+	existing := &computepb.Metadata{
+		Fingerprint: pointer("abc"),
+		Items: []*computepb.Items{
+			{Key: pointer("ssh-keys"), Value: pointer("ops:ssh-ed25519 AAAA")},
+			{Key: pointer(googleCloudSecretsChecksumMetadataKey), Value: pointer(before.SecretsChecksum())},
+		},
+	}
+	merged := googleCloudMergedMetadata(existing, items)
+	if merged.GetFingerprint() != "abc" || len(merged.GetItems()) != len(items)+1 || merged.GetItems()[0].GetKey() != "ssh-keys" {
+		t.Errorf("expected the metadata set by others to be kept, got %v", merged)
+	}
+
+	// Groups recreating their instances checksum the certificates
+	group.Spec.GoogleCloud.CertificateRenewal = meshv1.GoogleCloudCertificateRenewalRecreate
+	if render(issued).Checksum() == render(renewed).Checksum() {
+		t.Error("expected renewed certificates to change the checksum of recreated instances")
+	}
+	if render(issued).Checksum() == before.Checksum() {
+		t.Error("expected changing the renewal to recreate the instances")
+	}
+	if items := googleCloudInstanceMetadata(group.Spec.GoogleCloud, after, renewed); len(items) != 1 {
+		t.Errorf("expected only the cloud config in the metadata, got %d items", len(items))
+	}
+}
+
+func TestGoogleCloudSecretManagerDelivery(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+				ProjectID:           "project",
+				ServiceAccountEmail: "node@project.iam.gserviceaccount.com",
+				SecretDelivery:      meshv1.GoogleCloudSecretDeliverySecretManager,
+			},
+		},
+	}
+	nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := func(version int) googleCloudSecretVersions {
+		out := make(googleCloudSecretVersions)
+		for _, file := range googleCloudSecretFiles {
+			out[file.key] = fmt.Sprintf("projects/1234/secrets/%s/versions/%d", googleCloudSecretID(group, 0, file.suffix), version)
+		}
+		return out
+	}
+	ca := newTestCA(t)
+	issued, renewed := ca.issue(t), ca.issue(t)
+	render := func(data map[string][]byte, versions googleCloudSecretVersions) *cloudconfig.Config {
+		conf, err := cloudconfig.New(cloudconfig.Options{
+			Image:        "ghcr.io/webmeshproj/node:latest",
+			Config:       nodeconf,
+			TLSCert:      data[corev1.TLSCertKey],
+			TLSKey:       data[corev1.TLSPrivateKeyKey],
+			CA:           data[cmmeta.TLSCAKey],
+			SecretsFetch: googleCloudSecretsFetch(group.Spec.GoogleCloud, versions),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+
+	conf := render(issued, versions(1))
+	raw := string(conf.Raw())
+	if strings.Contains(raw, string(issued[corev1.TLSPrivateKeyKey])) || strings.Contains(raw, "path: /etc/webmesh/tls/tls.key\n") {
+		t.Errorf("expected the node key to be left out of the cloud config:\n%s", raw)
+	}
+	for _, want := range []string{
+		"ExecStartPre=/bin/sh /etc/webmesh-secrets-fetch.sh",
+		"https://secretmanager.googleapis.com/v1/projects/1234/secrets/webmesh-default-vms-0-tls-key/versions/1:access",
+		"> /etc/webmesh/tls/tls.key.new",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("expected the cloud config to contain %q:\n%s", want, raw)
+		}
+	}
+	if render(renewed, versions(1)).Checksum() != conf.Checksum() {
+		t.Error("expected only the versions of the secrets to be part of the checksum")
+	}
+	if render(renewed, versions(2)).Checksum() == conf.Checksum() {
+		t.Error("expected new versions of the secrets to change the checksum")
+	}
+
+	// Secrets are shared by the project, distinct groups never share them
+	other := group.DeepCopy()
+	other.SetNamespace("other")
+	long := group.DeepCopy()
+	long.SetName(strings.Repeat("a", 80))
+	ids := map[string]struct{}{}
+	for _, g := range []*meshv1.NodeGroup{group, other, long} {
+		for _, file := range googleCloudSecretFiles {
+			id := googleCloudSecretID(g, 0, file.suffix)
+			if len(id) > maxGoogleCloudLabelLength {
+				t.Errorf("expected secret IDs to be usable as label values, got %q", id)
+			}
+			ids[id] = struct{}{}
+		}
+	}
+	if len(ids) != 3*len(googleCloudSecretFiles) {
+		t.Errorf("expected distinct secret IDs, got %v", ids)
+	}
+	selector := googleCloudInstanceSelector(mesh, group)
+	if !googleCloudLabelsMatch(googleCloudInstanceLabels(mesh, group, 1), selector) || googleCloudLabelsMatch(googleCloudInstanceLabels(mesh, other, 1), selector) {
+		t.Error("expected secrets to be matched by the labels of their group")
+	}
+
+	// Groups delivering secrets in the user-data fetch nothing
+	group.Spec.GoogleCloud.SecretDelivery = meshv1.GoogleCloudSecretDeliveryMetadata
+	if !strings.Contains(string(render(issued, nil).Raw()), "path: /etc/webmesh/tls/tls.key\n") {
+		t.Error("expected the node key in the cloud config")
+	}
+}
+
+func TestDestroySupersededGoogleCloudSecretVersions(t *testing.T) {
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"}}
+	secret := "projects/1234/secrets/" + googleCloudSecretID(group, 0, "tls-key")
+	var mu sync.Mutex
+	var destroyed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/v1/"+secret+"/versions":
+			_ = json.NewEncoder(w).Encode(&secretmanager.ListSecretVersionsResponse{
+				Versions: []*secretmanager.SecretVersion{
+					{Name: secret + "/versions/1", State: "DISABLED"},
+					{Name: secret + "/versions/2", State: "ENABLED"},
+					{Name: secret + "/versions/3", State: "ENABLED"},
+				},
+			})
+		case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, ":destroy"):
+			name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/"), ":destroy")
+			destroyed = append(destroyed, name)
+			_ = json.NewEncoder(w).Encode(&secretmanager.SecretVersion{Name: name, State: "DESTROYED"})
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	clients := &googleCloudClients{opts: []option.ClientOption{
+		option.WithEndpoint(srv.URL + "/"),
+		option.WithHTTPClient(srv.Client()),
+	}}
+
+	versions := map[int]googleCloudSecretVersions{
+		0: {corev1.TLSPrivateKeyKey: secret + "/versions/3"},
+	}
+	if err := destroySupersededGoogleCloudSecretVersions(context.Background(), clients, versions); err != nil {
+		t.Fatalf("destroy superseded versions: %v", err)
+	}
+	want := []string{secret + "/versions/1", secret + "/versions/2"}
+	if !slices.Equal(destroyed, want) {
+		t.Errorf("expected the superseded versions %v to be destroyed, got %v", want, destroyed)
+	}
+
+	// Versions that were only simulated leave the secret untouched
+	destroyed = nil
+	versions[0][corev1.TLSPrivateKeyKey] = secret + "/versions/latest"
+	if err := destroySupersededGoogleCloudSecretVersions(context.Background(), clients, versions); err != nil {
+		t.Fatalf("destroy superseded versions: %v", err)
+	}
+	if len(destroyed) != 0 {
+		t.Errorf("expected no versions to be destroyed, got %v", destroyed)
+	}
+}
+
+func TestGetSSHUsers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	const (
+		specKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILz/FQBSqHkyp1KY8p67LdmSOp2wvmuyyj8x5b8IsBTx"
+		secretKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINUYwdFkgJR7r3GV16b1HNdykLYo1F7hU0laQ766LG12"
+	)
+	keys := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ssh-keys", Namespace: "default"},
+		Data:       map[string][]byte{"authorized_keys": []byte("# oncall\n" + secretKey + "\n\n")},
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(keys).Build()
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vms", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Hetzner: &meshv1.NodeGroupHetznerConfig{
+				SSH: &meshv1.CloudConfigSSH{Users: []meshv1.CloudConfigSSHUser{{
+					Name:           "ops",
+					AuthorizedKeys: []string{specKey},
+					AuthorizedKeysSecretRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "ssh-keys"},
+						Key:                  "authorized_keys",
+					},
+					Sudo: true,
+				}}},
+			},
+		},
+	}
+	users, err := getSSHUsers(context.Background(), cli, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cloudconfig.User{{Name: "ops", AuthorizedKeys: []string{specKey, secretKey}, Sudo: true}}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("got users %+v, want %+v", users, want)
+	}
+
+	// The default user of the image is kept
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	nodeconf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "203.0.113.1:8443", CertDir: meshv1.DefaultTLSDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := cloudconfig.New(cloudconfig.Options{Config: nodeconf, Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := string(conf.Raw())
+	for _, want := range []string{"users:\n  - default\n  - name: ops\n", "sudo: ALL=(ALL) NOPASSWD:ALL", "- " + secretKey} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("expected cloud config to contain %q:\n%s", want, raw)
+		}
+	}
+
+	group.Spec.Hetzner.SSH.Users[0].AuthorizedKeysSecretRef.Key = "missing"
+	if _, err := getSSHUsers(context.Background(), cli, group); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestGetFileSecrets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "files", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("s3cret")},
+	}
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "team"},
+		Data:       map[string][]byte{"token": []byte("theirs")},
+	}
+	r := &NodeGroupReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret, other).Build()}
+	newGroup := func(files ...meshv1.FileSecret) *meshv1.NodeGroup {
+		return &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+				FileSecrets: files,
+			}},
+		}
+	}
+
+	tc := []struct {
+		name  string
+		file  meshv1.FileSecret
+		files []cloudconfig.File
+		err   bool
+	}{
+		{
+			name:  "key of a secret",
+			file:  meshv1.FileSecret{SecretRef: corev1.LocalObjectReference{Name: "files"}, Key: "token", Path: "/etc/token", Mode: "0600"},
+			files: []cloudconfig.File{{Path: "/etc/token", Permissions: "0600", Content: []byte("s3cret")}},
+		},
+		{
+			name: "missing key",
+			file: meshv1.FileSecret{SecretRef: corev1.LocalObjectReference{Name: "files"}, Key: "missing", Path: "/etc/token"},
+			err:  true,
+		},
+		{
+			name: "secret in another namespace",
+			file: meshv1.FileSecret{SecretRef: corev1.LocalObjectReference{Name: "other"}, Key: "token", Path: "/etc/token"},
+			err:  true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := r.getFileSecrets(context.Background(), newGroup(tt.file))
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get file secrets: %v", err)
+			}
+			if !reflect.DeepEqual(files, tt.files) {
+				t.Errorf("expected files %+v, got %+v", tt.files, files)
+			}
+		})
+	}
+}
+
+func TestGoogleCloudSchedule(t *testing.T) {
+	now := time.Date(2023, 6, 14, 12, 0, 0, 0, time.UTC)
+	group := &meshv1.NodeGroup{
+		Spec: meshv1.NodeGroupSpec{GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{}},
+	}
+	status, err := getScheduleStatus(group, now)
+	if err != nil || status != nil {
+		t.Fatalf("expected no status without a schedule, got %+v, %v", status, err)
+	}
+	if got := requeueForSchedule(ctrl.Result{}, status, now); !got.IsZero() {
+		t.Errorf("expected no requeue without a schedule, got %+v", got)
+	}
+
+	// Outside of the window the group is suspended until it starts
+	group.Spec.GoogleCloud.Schedule = &meshv1.NodeGroupSchedule{Start: "18:00", Stop: "06:00", TimeZone: "UTC"}
+	status, err = getScheduleStatus(group, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &meshv1.NodeGroupScheduleStatus{
+		State:          meshv1.ScheduleStateSuspended,
+		NextTransition: &metav1.Time{Time: time.Date(2023, 6, 14, 18, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("got status %+v, want %+v", status, want)
+	}
+	// The group is requeued just after the transition, unless it already
+	// is sooner
+	if got := requeueForSchedule(ctrl.Result{}, status, now); got.RequeueAfter != 6*time.Hour+time.Second {
+		t.Errorf("expected a requeue after the window starts, got %+v", got)
+	}
+	if got := requeueForSchedule(ctrl.Result{RequeueAfter: 10 * time.Hour}, status, now); got.RequeueAfter != 6*time.Hour+time.Second {
+		t.Errorf("expected the sooner transition to win, got %+v", got)
+	}
+	if got := requeueForSchedule(ctrl.Result{RequeueAfter: time.Minute}, status, now); got.RequeueAfter != time.Minute {
+		t.Errorf("expected the sooner requeue to win, got %+v", got)
+	}
+
+	// In the window the group runs until it stops
+	status, err = getScheduleStatus(group, now.Add(8*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != meshv1.ScheduleStateRunning || !status.NextTransition.Time.Equal(time.Date(2023, 6, 15, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the group to run until the window stops, got %+v", status)
+	}
+
+	// The override annotation wins over the schedule, without a transition
+	group.Annotations = map[string]string{meshv1.ScheduleOverrideAnnotation: string(meshv1.ScheduleStateRunning)}
+	status, err = getScheduleStatus(group, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &meshv1.NodeGroupScheduleStatus{State: meshv1.ScheduleStateRunning, Overridden: true}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("got status %+v, want %+v", status, want)
+	}
+	if got := requeueForSchedule(ctrl.Result{}, status, now); !got.IsZero() {
+		t.Errorf("expected no requeue while overridden, got %+v", got)
+	}
+	group.Spec.GoogleCloud.Schedule = nil
+	if status, err := getScheduleStatus(group, now); err != nil || status == nil || !status.Overridden {
+		t.Errorf("expected the override to apply without a schedule, got %+v, %v", status, err)
+	}
+	group.Annotations[meshv1.ScheduleOverrideAnnotation] = "Paused"
+	if _, err := getScheduleStatus(group, now); err == nil {
+		t.Error("expected an error for an unknown override")
+	}
+}
@@ -0,0 +1,357 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// hetznerServerAPI is the subset of the servers API used to manage the
+// servers of a node group.
+type hetznerServerAPI interface {
+	AllWithOpts(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, error)
+	Create(ctx context.Context, opts hcloud.ServerCreateOpts) (hcloud.ServerCreateResult, *hcloud.Response, error)
+	DeleteWithResult(ctx context.Context, server *hcloud.Server) (*hcloud.ServerDeleteResult, *hcloud.Response, error)
+}
+
+// hetznerSSHKeyAPI is the subset of the SSH keys API used to look up the keys
+// authorized on the servers of a node group.
+type hetznerSSHKeyAPI interface {
+	Get(ctx context.Context, idOrName string) (*hcloud.SSHKey, *hcloud.Response, error)
+}
+
+// hetznerProvider deploys node groups to Hetzner Cloud servers.
+type hetznerProvider struct {
+	*NodeGroupReconciler
+}
+
+// Reconcile implements providers.Provider.
+func (r hetznerProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Bound the servers being changed at once across all groups
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderHetzner)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer release()
+
+	hc, err := r.getHetznerClient(ctx, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	servers := &hc.Server
+	spec := group.Spec.Hetzner
+
+	// Build the nodeconfig
+	joinServer, err := getJoinServer(ctx, r.Client, mesh, group)
+	if err != nil {
+		if errors.Is(err, ErrLBNotReady) {
+			log.Info("load balancer not ready, requeueing")
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: time.Second * 3,
+			}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("get join server: %w", err)
+	}
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("merge node group config: %w", err)
+	}
+	users, err := getSSHUsers(ctx, r.Client, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	existing, err := listHetznerServers(ctx, servers, group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	byName := make(map[string][]*hcloud.Server, len(existing))
+	for _, server := range existing {
+		byName[server.Name] = append(byName[server.Name], server)
+	}
+	var running int
+	for i := 0; i < int(group.Replicas()); i++ {
+		current := byName[fmt.Sprintf("%s-%d", group.GetName(), i)]
+		if len(current) == 1 && hetznerServerRunning(current[0]) {
+			running++
+		}
+	}
+	rollout := newInstanceRollout(int(group.Replicas()), running)
+
+	// Loop over replicas and ensure each server
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := fmt.Sprintf("%s-%d", group.GetName(), i)
+		current := byName[name]
+		delete(byName, name)
+
+		secret, err := r.getNodeCertificateSecret(ctx, mesh, group, i)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// Build the node and cloud configs
+		opts := hetznerNodeConfigOptions(mesh, group, joinServer)
+		opts.ClusterDomain = operatorconfig.FromContext(ctx).ClusterDomain
+		nodeconf, err := nodeconfig.New(opts)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build node config: %w", err)
+		}
+		env, err := getConfigSecrets(ctx, r.Client, group.GetNamespace(), nodeconf)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		cloudconf, err := cloudconfig.New(cloudconfig.Options{
+			Image:   group.Spec.Image,
+			Config:  nodeconf,
+			TLSCert: secret.Data[corev1.TLSCertKey],
+			TLSKey:  secret.Data[corev1.TLSPrivateKeyKey],
+			CA:      secret.Data[cmmeta.TLSCAKey],
+			// Servers forward traffic once it is enabled in the kernel,
+			// add the rules needed to masquerade egress traffic.
+			DefaultGateway: groupcfg.AdvertisesDefaultGateway(),
+			Env:            env,
+			Users:          users,
+			OSFamily:       group.Spec.OSFamily(),
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("build cloud config: %w", err)
+		}
+		checksum := hetznerChecksum(spec, cloudconf.Checksum())
+
+		// Ensure the server
+		if len(current) == 1 {
+			deployed := current[0].Labels[meshv1.ConfigChecksumAnnotation]
+			if resolveConfigChecksum(ctx, group, deployed, checksum) == deployed {
+				log.Info("Config checksum has not changed, skipping server", "name", name, "server", current[0].ID)
+				continue
+			}
+		}
+		if len(current) > 0 {
+			if !rollout.Replace(len(current) == 1 && hetznerServerRunning(current[0])) {
+				log.Info("Waiting for replaced servers to run before replacing server", "name", name)
+				continue
+			}
+			// Delete the servers and recreate them
+			log.Info("Config checksum has changed, deleting server", "name", name)
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "ReplacingInstance",
+				"Replacing server %s after its config changed", name)
+			if err := deleteHetznerServers(ctx, servers, current); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("Creating server", "name", name)
+		if simulate(ctx, "create server", "name", name) {
+			continue
+		}
+		sshKeys, err := getHetznerSSHKeys(ctx, &hc.SSHKey, spec.SSHKeys)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		create := hcloud.ServerCreateOpts{
+			Name:       name,
+			Location:   &hcloud.Location{Name: spec.Location},
+			ServerType: &hcloud.ServerType{Name: spec.ServerType},
+			Image:      &hcloud.Image{Name: spec.Image},
+			SSHKeys:    sshKeys,
+			Labels:     hetznerServerLabels(mesh, group, checksum),
+			PublicNet: &hcloud.ServerCreatePublicNet{
+				EnableIPv4: spec.PublicIPv4(),
+				EnableIPv6: !groupcfg.NoIPv6,
+			},
+			UserData: string(cloudconf.Raw()),
+		}
+		if spec.NetworkID != 0 {
+			create.Networks = []*hcloud.Network{{ID: spec.NetworkID}}
+		}
+		_, _, err = servers.Create(ctx, create)
+		if err != nil {
+			if hcloud.IsError(err, hcloud.ErrorCodeUniquenessError) || hcloud.IsError(err, hcloud.ErrorCodeConflict) {
+				// Names are unique within a project and are only released
+				// once a replaced server is gone.
+				log.Info("Server name is still taken, requeueing", "name", name)
+				return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 5}, nil
+			}
+			return ctrl.Result{}, fmt.Errorf("create server: %w", err)
+		}
+	}
+
+	// Delete the servers of removed replicas
+	for name, current := range byName {
+		log.Info("Deleting server of removed replica", "name", name)
+		if err := deleteHetznerServers(ctx, servers, current); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return rollout.Result(), nil
+}
+
+// Delete implements providers.Provider.
+func (r hetznerProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	release, err := r.Limiter.Acquire(ctx, fairness.ProviderHetzner)
+	if err != nil {
+		return err
+	}
+	defer release()
+	hc, err := r.getHetznerClient(ctx, group)
+	if err != nil {
+		return err
+	}
+	existing, err := listHetznerServers(ctx, &hc.Server, group)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Deleting node group servers", "count", len(existing))
+	return deleteHetznerServers(ctx, &hc.Server, existing)
+}
+
+// listHetznerServers returns the servers labeled for the group.
+func listHetznerServers(ctx context.Context, servers hetznerServerAPI, group *meshv1.NodeGroup) ([]*hcloud.Server, error) {
+	existing, err := servers.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: hetznerGroupSelector(group)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list servers: %w", err)
+	}
+	return existing, nil
+}
+
+// deleteHetznerServers deletes the given servers. Servers that are already
+// gone are skipped.
+func deleteHetznerServers(ctx context.Context, servers hetznerServerAPI, toDelete []*hcloud.Server) error {
+	for _, server := range toDelete {
+		if simulate(ctx, "delete server", "name", server.Name, "server", server.ID) {
+			continue
+		}
+		_, _, err := servers.DeleteWithResult(ctx, server)
+		if err != nil && !hcloud.IsError(err, hcloud.ErrorCodeNotFound) {
+			return fmt.Errorf("delete server: %w", err)
+		}
+	}
+	return nil
+}
+
+// getHetznerSSHKeys returns the SSH keys of the project with the given names.
+func getHetznerSSHKeys(ctx context.Context, keys hetznerSSHKeyAPI, names []string) ([]*hcloud.SSHKey, error) {
+	out := make([]*hcloud.SSHKey, 0, len(names))
+	for _, name := range names {
+		key, _, err := keys.Get(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("get ssh key %s: %w", name, err)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("ssh key %s not found", name)
+		}
+		out = append(out, key)
+	}
+	return out, nil
+}
+
+// hetznerGroupSelector returns the label selector of the group's servers.
+func hetznerGroupSelector(group *meshv1.NodeGroup) string {
+	return labels.SelectorFromSet(labels.Set{
+		meshv1.NodeGroupNameLabel:      group.GetName(),
+		meshv1.NodeGroupNamespaceLabel: group.GetNamespace(),
+	}).String()
+}
+
+// hetznerServerRunning returns true if the server is running.
+func hetznerServerRunning(server *hcloud.Server) bool {
+	return server.Status == hcloud.ServerStatusRunning
+}
+
+// hetznerChecksum returns the checksum of a cloud config as recorded in the
+// labels of a server. It also covers the settings of the server that can only
+// be changed by recreating it once they are changed from their defaults.
+// Label values are limited in length, so it is truncated.
+func hetznerChecksum(spec *meshv1.NodeGroupHetznerConfig, checksum string) string {
+	if !spec.PublicIPv4() {
+		data := fmt.Sprintf("%s\npublic-ipv4=false", checksum)
+		checksum = fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+	}
+	if len(checksum) > validation.LabelValueMaxLength {
+		return checksum[:validation.LabelValueMaxLength]
+	}
+	return checksum
+}
+
+// hetznerServerLabels returns the labels of the group's servers. The checksum
+// of their cloud config is recorded so changes recreate them.
+func hetznerServerLabels(mesh *meshv1.Mesh, group *meshv1.NodeGroup, checksum string) map[string]string {
+	return map[string]string{
+		meshv1.MeshNameLabel:            mesh.GetName(),
+		meshv1.MeshNamespaceLabel:       mesh.GetNamespace(),
+		meshv1.NodeGroupNameLabel:       group.GetName(),
+		meshv1.NodeGroupNamespaceLabel:  group.GetNamespace(),
+		meshv1.ConfigChecksumAnnotation: checksum,
+	}
+}
+
+// hetznerNodeConfigOptions returns the node config options for the replicas
+// of a Hetzner node group. The public addresses of a server are on its
+// interface, so they are detected locally.
+func hetznerNodeConfigOptions(mesh *meshv1.Mesh, group *meshv1.NodeGroup, joinServer string) nodeconfig.Options {
+	return nodeconfig.Options{
+		Mesh:            mesh,
+		Group:           group,
+		JoinServer:      joinServer,
+		IsPersistent:    true,
+		CertDir:         meshv1.DefaultTLSDirectory,
+		DetectEndpoints: true,
+		DetectIPv6:      true,
+	}
+}
+
+// getHetznerClient returns a client for the Hetzner Cloud API using the token
+// in the group's secret.
+func (r *NodeGroupReconciler) getHetznerClient(ctx context.Context, group *meshv1.NodeGroup) (*hcloud.Client, error) {
+	ref := group.Spec.Hetzner.Token
+	var secret corev1.Secret
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      ref.Name,
+		Namespace: group.GetNamespace(),
+	}, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("get hetzner token secret: %w", err)
+	}
+	token, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("no key %s in secret %s/%s", ref.Key, group.GetNamespace(), ref.Name)
+	}
+	return hcloud.NewClient(hcloud.WithToken(strings.TrimSpace(string(token)))), nil
+}
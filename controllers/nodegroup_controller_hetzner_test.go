@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeHetznerServers serves servers and records the servers deleted. Servers
+// with a negative ID are already gone.
+type fakeHetznerServers struct {
+	hetznerServerAPI
+	servers  []*hcloud.Server
+	selector string
+	deleted  []int64
+}
+
+func (f *fakeHetznerServers) AllWithOpts(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, error) {
+	f.selector = opts.LabelSelector
+	return f.servers, nil
+}
+
+func (f *fakeHetznerServers) DeleteWithResult(ctx context.Context, server *hcloud.Server) (*hcloud.ServerDeleteResult, *hcloud.Response, error) {
+	if server.ID < 0 {
+		return nil, nil, hcloud.Error{Code: hcloud.ErrorCodeNotFound, Message: "server not found"}
+	}
+	f.deleted = append(f.deleted, server.ID)
+	return &hcloud.ServerDeleteResult{}, nil, nil
+}
+
+// fakeHetznerSSHKeys serves the SSH keys of a project by name.
+type fakeHetznerSSHKeys map[string]int64
+
+func (f fakeHetznerSSHKeys) Get(ctx context.Context, name string) (*hcloud.SSHKey, *hcloud.Response, error) {
+	id, ok := f[name]
+	if !ok {
+		return nil, nil, nil
+	}
+	return &hcloud.SSHKey{ID: id, Name: name}, nil, nil
+}
+
+func TestListHetznerServers(t *testing.T) {
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"}}
+	servers := &fakeHetznerServers{servers: []*hcloud.Server{
+		{ID: 1, Name: "group-0"},
+		{ID: -2, Name: "group-1"},
+		{ID: 3, Name: "group-2"},
+	}}
+	existing, err := listHetznerServers(context.Background(), servers, group)
+	if err != nil {
+		t.Fatalf("list servers: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 servers, got %d", len(existing))
+	}
+	for _, label := range []string{meshv1.NodeGroupNameLabel + "=group", meshv1.NodeGroupNamespaceLabel + "=default"} {
+		if !strings.Contains(servers.selector, label) {
+			t.Errorf("expected selector %q to match %s", servers.selector, label)
+		}
+	}
+
+	// Servers that are already gone are skipped
+	if err := deleteHetznerServers(context.Background(), servers, existing); err != nil {
+		t.Fatalf("delete servers: %v", err)
+	}
+	if want := []int64{1, 3}; !reflect.DeepEqual(servers.deleted, want) {
+		t.Errorf("expected servers %v to be deleted, got %v", want, servers.deleted)
+	}
+}
+
+func TestGetHetznerSSHKeys(t *testing.T) {
+	keys := fakeHetznerSSHKeys{"admin": 1, "ci": 2}
+	got, err := getHetznerSSHKeys(context.Background(), keys, []string{"ci", "admin"})
+	if err != nil {
+		t.Fatalf("get ssh keys: %v", err)
+	}
+	var ids []int64
+	for _, key := range got {
+		ids = append(ids, key.ID)
+	}
+	if want := []int64{2, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected keys %v, got %v", want, ids)
+	}
+	if _, err := getHetznerSSHKeys(context.Background(), keys, []string{"admin", "missing"}); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestHetznerChecksum(t *testing.T) {
+	spec := &meshv1.NodeGroupHetznerConfig{}
+	checksum := strings.Repeat("a", 64)
+	if got := hetznerChecksum(spec, checksum); len(got) != 63 {
+		t.Errorf("expected the checksum to be truncated to a label value, got %d characters", len(got))
+	}
+	if got := hetznerChecksum(spec, "abc"); got != "abc" {
+		t.Errorf("expected a short checksum to be kept, got %s", got)
+	}
+	spec.EnablePublicIPv4 = pointer(false)
+	if got := hetznerChecksum(spec, "abc"); got == "abc" || len(got) != 63 {
+		t.Errorf("expected servers without a public IPv4 address to be recreated, got checksum %s", got)
+	}
+}
+
+func TestHetznerServerRunning(t *testing.T) {
+	if hetznerServerRunning(&hcloud.Server{Status: hcloud.ServerStatusInitializing}) {
+		t.Error("expected an initializing server not to be running")
+	}
+	if !hetznerServerRunning(&hcloud.Server{Status: hcloud.ServerStatusRunning}) {
+		t.Error("expected a running server to be running")
+	}
+}
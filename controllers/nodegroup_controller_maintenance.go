@@ -0,0 +1,303 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// maintenanceACLPrefix is the prefix of the network ACLs the operator manages
+// for cordoned nodes.
+const maintenanceACLPrefix = "operator-maintenance-"
+
+// reconcileMaintenance cordons the replicas listed in the maintenance
+// annotation of the group from the mesh and restores the ones that were
+// removed from it. A cordoned node is denied all traffic to and from the other
+// nodes through network ACLs, so peers drop it and stop routing through it.
+// Its routes are left in the mesh to be restored with it. The admin API does
+// not change Raft membership, so cordoned voters keep their vote. The admin
+// API is not contacted for groups without cordoned replicas.
+func (r *NodeGroupReconciler) reconcileMaintenance(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) error {
+	ordinals, err := group.MaintenanceOrdinals()
+	if err != nil {
+		return fmt.Errorf("parse maintenance annotation: %w", err)
+	}
+	if len(ordinals) == 0 && len(group.Status.Maintenance) == 0 {
+		return r.reconcileMaintenanceReadiness(ctx, cli, mesh, group, nil)
+	}
+	conn, err := dialManagerConfig(ctx, r.Client, mesh)
+	if err != nil {
+		return fmt.Errorf("dial admin API: %w", err)
+	}
+	defer conn.Close()
+	if err := applyMaintenanceACLs(ctx, v1.NewAdminClient(conn), mesh, group, ordinals); err != nil {
+		return err
+	}
+	status := maintenanceStatus(mesh, group, ordinals, time.Now())
+	for _, node := range status {
+		if !cordoned(group.Status.Maintenance, node.Ordinal) {
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "Cordoned",
+				"Cordoned replica %d (%s) from the mesh", node.Ordinal, node.NodeID)
+		}
+	}
+	for _, node := range group.Status.Maintenance {
+		if !cordoned(status, node.Ordinal) {
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "Uncordoned",
+				"Restored replica %d (%s) to the mesh", node.Ordinal, node.NodeID)
+		}
+	}
+	if !equality.Semantic.DeepEqual(group.Status.Maintenance, status) {
+		group.Status.Maintenance = status
+		if err := r.Status().Update(ctx, group); err != nil {
+			return fmt.Errorf("update maintenance status: %w", err)
+		}
+	}
+	return r.reconcileMaintenanceReadiness(ctx, cli, mesh, group, ordinals)
+}
+
+// releaseMaintenance restores the cordoned replicas of a group that is being
+// deleted. Failures are only logged, the nodes are going away with the group.
+func (r *NodeGroupReconciler) releaseMaintenance(ctx context.Context, group *meshv1.NodeGroup) {
+	if len(group.Status.Maintenance) == 0 {
+		return
+	}
+	log := log.FromContext(ctx)
+	var mesh meshv1.Mesh
+	if err := r.Get(ctx, group.MeshKey(), &mesh); err != nil {
+		log.Info("Unable to fetch Mesh, leaving maintenance ACLs in place", "error", err.Error())
+		return
+	}
+	conn, err := dialManagerConfig(ctx, r.Client, &mesh)
+	if err != nil {
+		log.Info("Unable to dial admin API, leaving maintenance ACLs in place", "error", err.Error())
+		return
+	}
+	defer conn.Close()
+	if err := applyMaintenanceACLs(ctx, v1.NewAdminClient(conn), &mesh, group, nil); err != nil {
+		log.Info("Unable to remove maintenance ACLs", "error", err.Error())
+	}
+}
+
+// applyMaintenanceACLs puts the ACLs that cordon the nodes of the given
+// ordinals and deletes those of the group's nodes that are no longer cordoned.
+func applyMaintenanceACLs(ctx context.Context, cli v1.AdminClient, mesh *meshv1.Mesh, group *meshv1.NodeGroup, ordinals []int32) error {
+	log := log.FromContext(ctx)
+	acls, err := cli.ListNetworkACLs(ctx, &emptypb.Empty{})
+	if err != nil {
+		return fmt.Errorf("list network ACLs: %w", err)
+	}
+	wanted := make(map[string]struct{})
+	for _, ordinal := range ordinals {
+		for _, acl := range maintenanceACLs(meshv1.MeshNodeGroupPodName(mesh, group, int(ordinal))) {
+			wanted[acl.GetName()] = struct{}{}
+			if simulate(ctx, "put network ACL", "acl", acl.GetName()) {
+				continue
+			}
+			if _, err := cli.PutNetworkACL(ctx, acl); err != nil {
+				return fmt.Errorf("put network ACL %s: %w", acl.GetName(), err)
+			}
+		}
+	}
+	for _, acl := range acls.GetItems() {
+		if _, ok := wanted[acl.GetName()]; ok {
+			continue
+		}
+		if _, ok := maintenanceACLOrdinal(mesh, group, acl.GetName()); !ok {
+			continue
+		}
+		log.Info("Deleting network ACL of restored node", "acl", acl.GetName())
+		if simulate(ctx, "delete network ACL", "acl", acl.GetName()) {
+			continue
+		}
+		if _, err := cli.DeleteNetworkACL(ctx, acl); err != nil {
+			return fmt.Errorf("delete network ACL %s: %w", acl.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// maintenanceACLs returns the network ACLs that deny all traffic to and from
+// the given node. They take precedence over every other ACL of the mesh.
+func maintenanceACLs(nodeID string) []*v1.NetworkACL {
+	return []*v1.NetworkACL{
+		{
+			Name:             maintenanceACLPrefix + nodeID + "-ingress",
+			Priority:         math.MaxInt32,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{nodeID},
+		},
+		{
+			Name:             maintenanceACLPrefix + nodeID + "-egress",
+			Priority:         math.MaxInt32,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{nodeID},
+			DestinationNodes: []string{"*"},
+		},
+	}
+}
+
+// maintenanceACLOrdinal returns the ordinal of the group's node cordoned by
+// the network ACL with the given name, and false if the ACL does not cordon
+// a node of the group.
+func maintenanceACLOrdinal(mesh *meshv1.Mesh, group *meshv1.NodeGroup, name string) (int32, bool) {
+	nodeID, ok := strings.CutPrefix(name, maintenanceACLPrefix)
+	if !ok {
+		return 0, false
+	}
+	if id, ok := strings.CutSuffix(nodeID, "-ingress"); ok {
+		nodeID = id
+	} else if id, ok := strings.CutSuffix(nodeID, "-egress"); ok {
+		nodeID = id
+	} else {
+		return 0, false
+	}
+	return podOrdinal(mesh, group, nodeID)
+}
+
+// podOrdinal returns the ordinal of the group's node pod with the given name,
+// and false if the pod does not belong to the group.
+func podOrdinal(mesh *meshv1.Mesh, group *meshv1.NodeGroup, name string) (int32, bool) {
+	suffix, ok := strings.CutPrefix(name, meshv1.MeshNodeGroupStatefulSetName(mesh, group)+"-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.ParseInt(suffix, 10, 32)
+	if err != nil || ordinal < 0 || strconv.FormatInt(ordinal, 10) != suffix {
+		return 0, false
+	}
+	return int32(ordinal), true
+}
+
+// maintenanceStatus returns the maintenance status of the group for the
+// given cordoned ordinals. Replicas that were already cordoned keep the time
+// they were cordoned at.
+func maintenanceStatus(mesh *meshv1.Mesh, group *meshv1.NodeGroup, ordinals []int32, now time.Time) []meshv1.NodeMaintenanceStatus {
+	if len(ordinals) == 0 {
+		return nil
+	}
+	status := make([]meshv1.NodeMaintenanceStatus, 0, len(ordinals))
+	for _, ordinal := range ordinals {
+		since := metav1.NewTime(now.Truncate(time.Second))
+		for _, node := range group.Status.Maintenance {
+			if node.Ordinal == ordinal {
+				since = node.Since
+				break
+			}
+		}
+		status = append(status, meshv1.NodeMaintenanceStatus{
+			Ordinal: ordinal,
+			NodeID:  meshv1.MeshNodeGroupPodName(mesh, group, int(ordinal)),
+			Since:   since,
+		})
+	}
+	return status
+}
+
+// cordoned returns true if the replica with the given ordinal is in the
+// maintenance status.
+func cordoned(status []meshv1.NodeMaintenanceStatus, ordinal int32) bool {
+	for _, node := range status {
+		if node.Ordinal == ordinal {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileMaintenanceReadiness sets the in-service condition of the node pods
+// of groups with a maintenance readiness gate. It is false for the pods of the
+// given cordoned ordinals and true for all others.
+func (r *NodeGroupReconciler) reconcileMaintenanceReadiness(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, ordinals []int32) error {
+	if !group.Spec.Cluster.MaintenanceReadinessGate {
+		return nil
+	}
+	var pods corev1.PodList
+	err := cli.List(ctx, &pods,
+		client.InNamespace(group.GetNamespace()),
+		client.MatchingLabels(meshv1.NodeGroupSelector(mesh, group)))
+	if err != nil {
+		return fmt.Errorf("list node pods: %w", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal, ok := podOrdinal(mesh, group, pod.GetName())
+		if !ok {
+			continue
+		}
+		condition := corev1.PodCondition{
+			Type:   meshv1.NodeInServiceCondition,
+			Status: corev1.ConditionTrue,
+			Reason: "InService",
+		}
+		for _, o := range ordinals {
+			if o == ordinal {
+				condition.Status = corev1.ConditionFalse
+				condition.Reason = "Cordoned"
+				condition.Message = "The node is cordoned from the mesh for maintenance"
+				break
+			}
+		}
+		if !setPodCondition(pod, condition) {
+			continue
+		}
+		if simulate(ctx, "update pod readiness", "pod", pod.GetName(), "inService", condition.Status) {
+			continue
+		}
+		if err := cli.Status().Update(ctx, pod); err != nil {
+			return fmt.Errorf("update in-service condition of pod %s: %w", pod.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// setPodCondition sets the condition on the pod and returns true if it
+// changed.
+func setPodCondition(pod *corev1.Pod, condition corev1.PodCondition) bool {
+	for i, current := range pod.Status.Conditions {
+		if current.Type != condition.Type {
+			continue
+		}
+		if current.Status == condition.Status && current.Reason == condition.Reason && current.Message == condition.Message {
+			return false
+		}
+		condition.LastTransitionTime = current.LastTransitionTime
+		if current.Status != condition.Status {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		pod.Status.Conditions[i] = condition
+		return true
+	}
+	condition.LastTransitionTime = metav1.Now()
+	pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	return true
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// fakeACLAdmin is an admin client that only manages network ACLs.
+type fakeACLAdmin struct {
+	v1.AdminClient
+	acls map[string]*v1.NetworkACL
+}
+
+func (f *fakeACLAdmin) ListNetworkACLs(ctx context.Context, _ *emptypb.Empty, _ ...grpc.CallOption) (*v1.NetworkACLs, error) {
+	var out v1.NetworkACLs
+	for _, acl := range f.acls {
+		out.Items = append(out.Items, acl)
+	}
+	return &out, nil
+}
+
+func (f *fakeACLAdmin) PutNetworkACL(ctx context.Context, acl *v1.NetworkACL, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	f.acls[acl.GetName()] = acl
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeACLAdmin) DeleteNetworkACL(ctx context.Context, acl *v1.NetworkACL, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	delete(f.acls, acl.GetName())
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeACLAdmin) names() []string {
+	var names []string
+	for name := range f.acls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestApplyMaintenanceACLs(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "nodes", Namespace: "default"}}
+	// A group whose StatefulSet name extends the one of the group above
+	other := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "nodes-1", Namespace: "default"}}
+	admin := &fakeACLAdmin{acls: map[string]*v1.NetworkACL{
+		"user-acl": {Name: "user-acl"},
+	}}
+	ctx := context.Background()
+
+	if err := applyMaintenanceACLs(ctx, admin, mesh, other, []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyMaintenanceACLs(ctx, admin, mesh, group, []int32{2, 4}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"operator-maintenance-mesh-nodes-1-0-egress",
+		"operator-maintenance-mesh-nodes-1-0-ingress",
+		"operator-maintenance-mesh-nodes-2-egress",
+		"operator-maintenance-mesh-nodes-2-ingress",
+		"operator-maintenance-mesh-nodes-4-egress",
+		"operator-maintenance-mesh-nodes-4-ingress",
+		"user-acl",
+	}
+	if got := admin.names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected ACLs %v, got %v", want, got)
+	}
+	ingress := admin.acls["operator-maintenance-mesh-nodes-2-ingress"]
+	if ingress.GetAction() != v1.ACLAction_ACTION_DENY || !reflect.DeepEqual(ingress.GetDestinationNodes(), []string{"mesh-nodes-2"}) {
+		t.Errorf("expected ingress ACL to deny traffic to the node, got %v", ingress)
+	}
+
+	// Restoring a replica only removes its own ACLs
+	if err := applyMaintenanceACLs(ctx, admin, mesh, group, []int32{4}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"operator-maintenance-mesh-nodes-1-0-egress",
+		"operator-maintenance-mesh-nodes-1-0-ingress",
+		"operator-maintenance-mesh-nodes-4-egress",
+		"operator-maintenance-mesh-nodes-4-ingress",
+		"user-acl",
+	}
+	if got := admin.names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected ACLs %v, got %v", want, got)
+	}
+	if err := applyMaintenanceACLs(ctx, admin, mesh, group, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"operator-maintenance-mesh-nodes-1-0-egress",
+		"operator-maintenance-mesh-nodes-1-0-ingress",
+		"user-acl",
+	}
+	if got := admin.names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected ACLs %v, got %v", want, got)
+	}
+}
+
+func TestMaintenanceStatus(t *testing.T) {
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: "nodes", Namespace: "default"}}
+	earlier := metav1.NewTime(time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC))
+	now := time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)
+	group.Status.Maintenance = []meshv1.NodeMaintenanceStatus{
+		{Ordinal: 2, NodeID: "mesh-nodes-2", Since: earlier},
+		{Ordinal: 3, NodeID: "mesh-nodes-3", Since: earlier},
+	}
+	got := maintenanceStatus(mesh, group, []int32{2, 4}, now)
+	want := []meshv1.NodeMaintenanceStatus{
+		{Ordinal: 2, NodeID: "mesh-nodes-2", Since: earlier},
+		{Ordinal: 4, NodeID: "mesh-nodes-4", Since: metav1.NewTime(now)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected status %v, got %v", want, got)
+	}
+	if got := maintenanceStatus(mesh, group, nil, now); got != nil {
+		t.Errorf("expected no status without cordoned replicas, got %v", got)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// podSecurityRetryInterval is how long to wait before checking the namespace
+// of a group whose pods would be rejected by pod security admission again.
+const podSecurityRetryInterval = time.Minute
+
+// reconcilePodSecurity makes sure the pod security level enforced on the
+// namespace of a cluster node group admits its pods. The StatefulSet of a
+// group is admitted either way and silently creates no pods, so the mismatch
+// is reported in the PSARestricted condition instead of applying the group.
+// When the operator labels privileged namespaces, the namespace is labeled to
+// enforce the level the pods require. Namespaces the operator cannot read are
+// not checked, and neither are the defaults of the admission configuration of
+// the cluster, which apply to namespaces without the label. This runs before
+// the pre-flight, which would report the same rejection of every pod. It
+// returns how long to wait before the group can be applied, or zero if it can
+// be applied now.
+func (r *NodeGroupReconciler) reconcilePodSecurity(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (time.Duration, error) {
+	cli, err := r.getClusterClient(ctx, group)
+	if err != nil {
+		return 0, fmt.Errorf("create cluster client: %w", err)
+	}
+	var ns corev1.Namespace
+	err = cli.Get(ctx, client.ObjectKey{Name: group.GetNamespace()}, &ns)
+	if apierrors.IsForbidden(err) {
+		// Namespace-scoped roles may not read namespaces
+		return 0, r.setPodSecurityCondition(ctx, group, "")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get namespace: %w", err)
+	}
+	enforced := ns.GetLabels()[inspect.PodSecurityEnforceLabel]
+	required, reasons := nodePodSecurityLevel(mesh, group)
+	if inspect.PodSecurityAdmits(enforced, required) {
+		return 0, r.setPodSecurityCondition(ctx, group, "")
+	}
+
+	if r.LabelPrivilegedNamespaces {
+		log.FromContext(ctx).Info("Labeling namespace to admit the node pods", "namespace", ns.GetName(), "level", required)
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "LabelingNamespace",
+			"Labeling namespace %s with %s=%s to admit the node pods", ns.GetName(), inspect.PodSecurityEnforceLabel, required)
+		if simulate(ctx, "label namespace", "name", ns.GetName(), "level", required) {
+			return 0, nil
+		}
+		patch := client.MergeFrom(ns.DeepCopy())
+		if ns.Labels == nil {
+			ns.Labels = make(map[string]string)
+		}
+		ns.Labels[inspect.PodSecurityEnforceLabel] = required
+		if err := cli.Patch(ctx, &ns, patch); err != nil {
+			return 0, fmt.Errorf("label namespace: %w", err)
+		}
+		return 0, r.setPodSecurityCondition(ctx, group, "")
+	}
+
+	remedies := []string{
+		"run the group in a namespace enforcing the " + required + " level",
+		fmt.Sprintf("label namespace %s with %s=%s", ns.GetName(), inspect.PodSecurityEnforceLabel, required),
+		"start the operator with --label-privileged-namespaces to label it automatically",
+	}
+	if !group.Spec.Cluster.WireGuardMode.Unprivileged() {
+		userspace := group.DeepCopy()
+		userspace.Spec.Cluster.WireGuardMode = meshv1.WireGuardModeUserspace
+		if level, _ := nodePodSecurityLevel(mesh, userspace); inspect.PodSecurityAdmits(enforced, level) {
+			remedies = append([]string{"set spec.cluster.wireguardMode to userspace"}, remedies...)
+		}
+	}
+	message := fmt.Sprintf("Namespace %s enforces the %s pod security level, but the node pods require the %s level: %s. "+
+		"To deploy the group, %s",
+		ns.GetName(), enforced, required, strings.Join(reasons, ", "), strings.Join(remedies, ", or "))
+	if err := r.setPodSecurityCondition(ctx, group, message); err != nil {
+		return 0, err
+	}
+	return podSecurityRetryInterval, nil
+}
+
+// nodePodSecurityLevel returns the pod security level the node pods of a
+// cluster node group require, along with the reasons they require it.
+func nodePodSecurityLevel(mesh *meshv1.Mesh, group *meshv1.NodeGroup) (string, []string) {
+	// Builders may modify the annotations of the group, render from a copy.
+	// Images do not change the level, leave them empty.
+	sts := resources.NewNodeGroupStatefulSet(mesh, group.DeepCopy(), "", "")
+	return inspect.PodSecurityLevel(&sts.Spec.Template.Spec)
+}
+
+// setPodSecurityCondition records why the node pods of the group would be
+// rejected by pod security admission in the PSARestricted condition and
+// raises a warning event when it changes. An empty message clears the
+// condition.
+func (r *NodeGroupReconciler) setPodSecurityCondition(ctx context.Context, group *meshv1.NodeGroup, message string) error {
+	condition := metav1.Condition{
+		Type:               meshv1.PodSecurityRestrictedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             "Admitted",
+		Message:            "The namespace admits the node pods",
+	}
+	if message != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "LevelTooRestrictive"
+		condition.Message = message
+		if len(condition.Message) > maxConditionMessageLength {
+			condition.Message = condition.Message[:maxConditionMessageLength]
+		}
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once pods would have been rejected
+		return nil
+	}
+	if current != nil && current.Status == condition.Status &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	if condition.Status == metav1.ConditionTrue && (current == nil || current.Message != condition.Message) {
+		r.Recorder.Event(group, corev1.EventTypeWarning, "PSARestricted", message)
+	}
+	meta.SetStatusCondition(&group.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, group); err != nil {
+		return fmt.Errorf("update pod security condition: %w", err)
+	}
+	return nil
+}
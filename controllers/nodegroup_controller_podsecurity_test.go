@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
+)
+
+func TestReconcilePodSecurity(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "tenant"}}
+	objects := func(level string) (*meshv1.NodeGroup, *corev1.Namespace) {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "tenant", Generation: 1},
+			Spec:       meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{}},
+		}
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+		if level != "" {
+			ns.Labels = map[string]string{inspect.PodSecurityEnforceLabel: level}
+		}
+		return group, ns
+	}
+
+	t.Run("admitted", func(t *testing.T) {
+		for _, level := range []string{"", inspect.PodSecurityPrivileged} {
+			group, ns := objects(level)
+			cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(group, ns).WithStatusSubresource(group).Build()
+			r := &NodeGroupReconciler{Client: cli, Recorder: record.NewFakeRecorder(10)}
+			wait, err := r.reconcilePodSecurity(ctx, mesh, group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != 0 || meta.FindStatusCondition(group.Status.Conditions, meshv1.PodSecurityRestrictedCondition) != nil {
+				t.Errorf("expected namespaces enforcing %q to admit the node pods", level)
+			}
+		}
+	})
+
+	t.Run("restricted", func(t *testing.T) {
+		group, ns := objects(inspect.PodSecurityRestricted)
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(group, ns).WithStatusSubresource(group).Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+		for i := 0; i < 2; i++ {
+			wait, err := r.reconcilePodSecurity(ctx, mesh, group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != podSecurityRetryInterval {
+				t.Fatalf("expected to wait for the namespace to admit the node pods, got %v", wait)
+			}
+		}
+		cond := meta.FindStatusCondition(group.Status.Conditions, meshv1.PodSecurityRestrictedCondition)
+		if cond == nil || cond.Status != metav1.ConditionTrue {
+			t.Fatalf("expected the PSARestricted condition, got %v", group.Status.Conditions)
+		}
+		for _, want := range []string{"enforces the restricted pod security level", "require the privileged level", `container "node" is privileged`, "--label-privileged-namespaces"} {
+			if !strings.Contains(cond.Message, want) {
+				t.Errorf("expected the condition to mention %q, got %q", want, cond.Message)
+			}
+		}
+		if len(recorder.Events) != 1 {
+			t.Errorf("expected a single warning event, got %d", len(recorder.Events))
+		}
+		var got corev1.Namespace
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(ns), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Labels[inspect.PodSecurityEnforceLabel] != inspect.PodSecurityRestricted {
+			t.Error("expected the namespace to be left alone without the opt-in")
+		}
+
+		// The condition is cleared once the namespace admits the pods
+		got.Labels[inspect.PodSecurityEnforceLabel] = inspect.PodSecurityPrivileged
+		if err := cli.Update(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		if wait, err := r.reconcilePodSecurity(ctx, mesh, group); err != nil || wait != 0 {
+			t.Fatalf("expected the group to be applied, got %v, %v", wait, err)
+		}
+		if meta.IsStatusConditionTrue(group.Status.Conditions, meshv1.PodSecurityRestrictedCondition) {
+			t.Error("expected the PSARestricted condition to be cleared")
+		}
+	})
+
+	t.Run("label", func(t *testing.T) {
+		group, ns := objects(inspect.PodSecurityBaseline)
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(group, ns).WithStatusSubresource(group).Build()
+		r := &NodeGroupReconciler{Client: cli, Recorder: record.NewFakeRecorder(10), LabelPrivilegedNamespaces: true}
+		wait, err := r.reconcilePodSecurity(ctx, mesh, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Errorf("expected the group to be applied once the namespace is labeled, got %v", wait)
+		}
+		var got corev1.Namespace
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(ns), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Labels[inspect.PodSecurityEnforceLabel] != inspect.PodSecurityPrivileged {
+			t.Errorf("expected the namespace to enforce the privileged level, got %v", got.Labels)
+		}
+	})
+}
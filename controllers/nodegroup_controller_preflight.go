@@ -0,0 +1,242 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// preflightRetryInterval is how long to wait before checking a group that
+// failed its pre-flight again. Quotas are not watched.
+const preflightRetryInterval = 30 * time.Second
+
+// preflightClusterNodeGroup checks that every object of a cluster node group
+// would be admitted before any of them are applied, so a group that does not
+// fit in its namespace is not left half created. The objects are applied with
+// a server-side dry run, and the pods and claims of replicas that do not exist
+// yet are created with one. As each of those is only checked against the
+// current usage, their total is also checked against the resource quotas of
+// the namespace. Failures are recorded in the PreflightFailed condition and
+// false is returned. Groups with the skip-preflight annotation are not checked.
+// Once the objects pass, the generation of the group and the checksum of its
+// rendered objects are recorded in its status, and the dry runs are skipped
+// until either changes. Quotas are not watched, so a passing group is not
+// checked again when they shrink.
+func (r *NodeGroupReconciler) preflightClusterNodeGroup(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (bool, error) {
+	if group.GetAnnotations()[meshv1.SkipPreflightAnnotation] == "true" {
+		return true, nil
+	}
+	cli, err := r.getClusterClient(ctx, group)
+	if err != nil {
+		return false, fmt.Errorf("create cluster client: %w", err)
+	}
+	settings := operatorconfig.FromContext(ctx)
+	families, err := r.getServiceIPFamilies(ctx, cli, mesh, group)
+	if err != nil {
+		return false, fmt.Errorf("determine service IP families: %w", err)
+	}
+
+	adopted, err := getAdoptedStatefulSet(ctx, cli, mesh, group)
+	if err != nil {
+		return false, err
+	}
+
+	// The rendered config does not change what is admitted, leave it empty.
+	// Builders may modify the annotations of the group, render from a copy.
+	certs := resources.RenderNodeCertificates(mesh, group, settings.ClusterDomain)
+	rendered := group.DeepCopy()
+	toApply := resources.RenderClusterNodeGroup(mesh, rendered, &nodeconfig.Config{}, families, settings.Images.MetricsProxy)
+	if group.Spec.Cluster.Service != nil {
+		for _, svc := range resources.NewNodeGroupLBServices(mesh, rendered, families) {
+			if err := setLoadBalancerClass(ctx, cli, svc); err != nil {
+				return false, err
+			}
+			toApply = append(toApply, svc)
+		}
+	}
+	resources.AdoptStatefulSet(adopted, toApply)
+	sts := resources.NewNodeGroupStatefulSet(mesh, rendered, settings.Images.MetricsProxy, "")
+	resources.AdoptStatefulSet(adopted, []client.Object{sts})
+	replicas := resources.StatefulSetReplicas(sts)
+	checksum, err := preflightChecksum(certs, toApply, replicas)
+	if err != nil {
+		return false, err
+	}
+	if group.Status.PreflightGeneration == group.GetGeneration() && group.Status.PreflightChecksum == checksum &&
+		!meta.IsStatusConditionTrue(group.Status.Conditions, meshv1.PreflightFailedCondition) {
+		return true, nil
+	}
+
+	// Certificates are always created in the local cluster
+	failures, err := dryRunApply(ctx, r.Client, certs)
+	if err != nil {
+		return false, err
+	}
+	objFailures, err := dryRunApply(ctx, cli, toApply)
+	if err != nil {
+		return false, err
+	}
+	failures = append(failures, objFailures...)
+
+	var pods []corev1.Pod
+	var claims []corev1.PersistentVolumeClaim
+	for _, obj := range replicas {
+		err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj.DeepCopyObject().(client.Object))
+		if err == nil {
+			// Already counted in the usage of the quotas
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("get %s: %w", obj.GetName(), err)
+		}
+		failure, err := admissionFailure(obj, cli.Create(ctx, obj, client.DryRunAll))
+		if err != nil {
+			return false, err
+		}
+		if failure != "" {
+			failures = append(failures, failure)
+			continue
+		}
+		// The dry run returns the object with limit range defaults applied
+		switch obj := obj.(type) {
+		case *corev1.Pod:
+			pods = append(pods, *obj)
+		case *corev1.PersistentVolumeClaim:
+			claims = append(claims, *obj)
+		}
+	}
+	var quotas corev1.ResourceQuotaList
+	err = cli.List(ctx, &quotas, client.InNamespace(group.GetNamespace()))
+	switch {
+	case err == nil:
+		failures = append(failures, inspect.ExceededQuotas(quotas.Items, inspect.QuotaUsage(pods, claims))...)
+	case apierrors.IsForbidden(err):
+		// Restricted roles may not read quotas, rely on the dry runs alone
+	default:
+		return false, fmt.Errorf("list resource quotas: %w", err)
+	}
+
+	if err := r.setPreflightCondition(ctx, group, failures); err != nil {
+		return false, err
+	}
+	if len(failures) > 0 {
+		return false, nil
+	}
+	group.Status.PreflightGeneration = group.GetGeneration()
+	group.Status.PreflightChecksum = checksum
+	if err := r.Status().Update(ctx, group); err != nil {
+		return false, fmt.Errorf("record preflight: %w", err)
+	}
+	return true, nil
+}
+
+// preflightChecksum returns the checksum of the objects checked by the
+// pre-flight of a group.
+func preflightChecksum(objs ...[]client.Object) (string, error) {
+	data, err := json.Marshal(objs)
+	if err != nil {
+		return "", fmt.Errorf("marshal preflight objects: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// setPreflightCondition records the given pre-flight failures in the
+// PreflightFailed condition of the group and raises a warning event when
+// they change.
+func (r *NodeGroupReconciler) setPreflightCondition(ctx context.Context, group *meshv1.NodeGroup, failures []string) error {
+	condition := metav1.Condition{
+		Type:               meshv1.PreflightFailedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             "Admitted",
+		Message:            "All objects of the node group would be admitted",
+	}
+	if len(failures) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "AdmissionRejected"
+		condition.Message = strings.Join(failures, "\n")
+		if len(condition.Message) > maxConditionMessageLength {
+			condition.Message = condition.Message[:maxConditionMessageLength]
+		}
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once a pre-flight has failed
+		return nil
+	}
+	if current != nil && current.Status == condition.Status &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	if condition.Status == metav1.ConditionTrue && (current == nil || current.Message != condition.Message) {
+		r.Recorder.Eventf(group, corev1.EventTypeWarning, "PreflightFailed",
+			"Node group objects would not be admitted, nothing was applied: %s", strings.Join(failures, "; "))
+	}
+	meta.SetStatusCondition(&group.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, group); err != nil {
+		return fmt.Errorf("update preflight failed condition: %w", err)
+	}
+	return nil
+}
+
+// dryRunApply applies the given objects with a server-side dry run and returns
+// the reasons of those that were rejected.
+func dryRunApply(ctx context.Context, cli client.Client, objs []client.Object) ([]string, error) {
+	var failures []string
+	for _, obj := range objs {
+		err := cli.Patch(ctx, obj, client.Apply, client.DryRunAll, client.ForceOwnership, client.FieldOwner(meshv1.FieldOwner))
+		failure, err := admissionFailure(obj, err)
+		if err != nil {
+			return nil, err
+		}
+		if failure != "" {
+			failures = append(failures, failure)
+		}
+	}
+	return failures, nil
+}
+
+// admissionFailure returns the reason the API server rejected the given
+// object. Errors that did not come from the API server are returned as is.
+func admissionFailure(obj client.Object, err error) (string, error) {
+	if err == nil {
+		return "", nil
+	}
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return "", fmt.Errorf("dry run %s: %w", obj.GetName(), err)
+	}
+	return fmt.Sprintf("%s %s: %s", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), status.Status().Message), nil
+}
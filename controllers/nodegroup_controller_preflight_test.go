@@ -0,0 +1,125 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+func TestPreflightClusterNodeGroupOnChange(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, certv1.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", Generation: 1},
+		Spec: meshv1.NodeGroupSpec{
+			Image:    meshv1.DefaultNodeImage,
+			Replicas: pointer(int32(1)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	// dryRuns counts the dry-run applies, which the quota rejects while set
+	var dryRuns int
+	var quotaExceeded bool
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(group).
+		WithStatusSubresource(group).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, cli client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if patch.Type() != types.ApplyPatchType {
+					return cli.Patch(ctx, obj, patch, opts...)
+				}
+				dryRuns++
+				if quotaExceeded {
+					return apierrors.NewForbidden(schema.GroupResource{Resource: "statefulsets"}, obj.GetName(), nil)
+				}
+				return nil
+			},
+		}).
+		Build()
+	r := &NodeGroupReconciler{Client: cli, Recorder: record.NewFakeRecorder(10), Namespaced: true}
+	// preflight returns whether the group passed and whether it was checked
+	preflight := func(ctx context.Context) (bool, bool) {
+		t.Helper()
+		before := dryRuns
+		ok, err := r.preflightClusterNodeGroup(ctx, mesh, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, dryRuns > before
+	}
+	ctx := context.Background()
+
+	if ok, checked := preflight(ctx); !ok || !checked {
+		t.Fatalf("expected the first pre-flight to dry run the objects and pass, got checked %v passed %v", checked, ok)
+	}
+	if group.Status.PreflightGeneration != 1 || group.Status.PreflightChecksum == "" {
+		t.Fatalf("expected the passed pre-flight to be recorded, got generation %d checksum %q",
+			group.Status.PreflightGeneration, group.Status.PreflightChecksum)
+	}
+	if ok, checked := preflight(ctx); !ok || checked {
+		t.Errorf("expected an unchanged group to pass without being checked again, got checked %v passed %v", checked, ok)
+	}
+
+	// Settings change the rendered objects without changing the group
+	settings := operatorconfig.Defaults()
+	settings.ClusterDomain = "cluster.example"
+	if _, checked := preflight(operatorconfig.WithSettings(ctx, settings)); !checked {
+		t.Error("expected changed objects to be checked again")
+	}
+
+	group.SetGeneration(2)
+	if err := cli.Update(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	quotaExceeded = true
+	if ok, checked := preflight(ctx); ok || !checked {
+		t.Fatalf("expected a new generation to be checked again and fail, got checked %v passed %v", checked, ok)
+	}
+	if group.Status.PreflightGeneration != 1 {
+		t.Errorf("expected a failed pre-flight not to be recorded, got generation %d", group.Status.PreflightGeneration)
+	}
+	// Failures are retried until the objects are admitted
+	quotaExceeded = false
+	if ok, checked := preflight(ctx); !ok || !checked {
+		t.Fatalf("expected a failed pre-flight to be checked again and pass, got checked %v passed %v", checked, ok)
+	}
+	if group.Status.PreflightGeneration != 2 {
+		t.Errorf("expected the passed pre-flight to be recorded, got generation %d", group.Status.PreflightGeneration)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+const (
+	// preRotationRetryInterval is how often the renewal of certificates is
+	// checked while they are pre-rotated.
+	preRotationRetryInterval = 10 * time.Second
+	// preRotationIssuedSlack is how long before the start of a pre-rotation
+	// a certificate may have been issued and still count as renewed, as
+	// issuers may backdate certificates.
+	preRotationIssuedSlack = 5 * time.Minute
+)
+
+// reconcileCertificatePreRotation renews the node certificates of a group with
+// the pre-rotation annotation that expire within its threshold. Nodes replaced
+// by a planned upgrade then start with fresh certificates instead of being
+// restarted again for renewals during the rollout. It returns how long to wait
+// before the changes to the group are applied.
+func (r *NodeGroupReconciler) reconcileCertificatePreRotation(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (time.Duration, error) {
+	log := log.FromContext(ctx)
+	threshold, ok, err := group.PreRotationThreshold()
+	if err != nil {
+		// Rejected by the webhook, the annotation was set without it
+		log.Info("Ignoring invalid pre-rotation annotation", "error", err.Error())
+		ok = false
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, meshv1.CertificatesPreRotatingCondition)
+	if !ok {
+		if current != nil && current.Status == metav1.ConditionTrue {
+			return 0, r.setCertificatesPreRotatingCondition(ctx, group, metav1.ConditionFalse,
+				"Cancelled", "The pre-rotation annotation was removed")
+		}
+		return 0, nil
+	}
+	if current == nil || current.Status != metav1.ConditionTrue {
+		err := r.setCertificatesPreRotatingCondition(ctx, group, metav1.ConditionTrue,
+			"Renewing", "Checking node certificates for renewal")
+		if err != nil {
+			return 0, err
+		}
+		current = meta.FindStatusCondition(group.Status.Conditions, meshv1.CertificatesPreRotatingCondition)
+	}
+	// Certificates issued since the pre-rotation started are not renewed
+	// again, even if their lifetime is shorter than the threshold
+	started := current.LastTransitionTime.Add(-preRotationIssuedSlack)
+	var renewing, failed []string
+	for i := 0; i < int(group.Replicas()); i++ {
+		var cert certv1.Certificate
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      meshv1.MeshNodeCertName(mesh, group, i),
+			Namespace: group.GetNamespace(),
+		}, &cert)
+		if err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return 0, fmt.Errorf("get node certificate: %w", err)
+			}
+			continue
+		}
+		switch {
+		case cert.Status.NotAfter == nil:
+			// Not issued yet, its first certificate is fresh
+		case certificateIssuing(&cert):
+			renewing = append(renewing, cert.GetName())
+		case cert.Status.NotBefore != nil && cert.Status.NotBefore.After(started):
+		case cert.Status.LastFailureTime != nil && cert.Status.LastFailureTime.After(started):
+			// Left to the backoff of cert-manager, the pre-rotation waits
+			// until the annotation is removed
+			failed = append(failed, cert.GetName())
+		case time.Until(cert.Status.NotAfter.Time) < threshold:
+			log.Info("Renewing node certificate before applying the node group", "certificate", cert.GetName())
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "RenewingCertificate",
+				"Renewing certificate %s expiring at %s before applying the node group",
+				cert.GetName(), cert.Status.NotAfter.Format(time.RFC3339))
+			if err := renewCertificate(ctx, r.Client, &cert); err != nil {
+				return 0, err
+			}
+			renewing = append(renewing, cert.GetName())
+		}
+	}
+	if len(renewing) > 0 || len(failed) > 0 {
+		var msgs []string
+		if len(renewing) > 0 {
+			msgs = append(msgs, fmt.Sprintf("Renewing certificates %s", strings.Join(renewing, ", ")))
+		}
+		if len(failed) > 0 {
+			msgs = append(msgs, fmt.Sprintf("Failed to renew certificates %s", strings.Join(failed, ", ")))
+		}
+		err := r.setCertificatesPreRotatingCondition(ctx, group, metav1.ConditionTrue,
+			"Renewing", strings.Join(msgs, "; "))
+		return preRotationRetryInterval, err
+	}
+	return 0, r.setCertificatesPreRotatingCondition(ctx, group, metav1.ConditionFalse,
+		"Renewed", "Node certificates expiring within the threshold were renewed")
+}
+
+// preRotatesCertificates returns true if the group has the pre-rotation
+// annotation.
+func preRotatesCertificates(group *meshv1.NodeGroup) bool {
+	_, ok := group.GetAnnotations()[meshv1.PreRotateCertificatesAnnotation]
+	return ok
+}
+
+// certificateIssuing returns true if cert-manager is issuing the certificate.
+func certificateIssuing(cert *certv1.Certificate) bool {
+	for _, cond := range cert.Status.Conditions {
+		if cond.Type == certv1.CertificateConditionIssuing {
+			return cond.Status == cmmeta.ConditionTrue
+		}
+	}
+	return false
+}
+
+// renewCertificate triggers the reissuance of a certificate the way cmctl
+// renew does, by setting its Issuing condition.
+func renewCertificate(ctx context.Context, cli client.Client, cert *certv1.Certificate) error {
+	now := metav1.Now()
+	condition := certv1.CertificateCondition{
+		Type:               certv1.CertificateConditionIssuing,
+		Status:             cmmeta.ConditionTrue,
+		Reason:             "ManuallyTriggered",
+		Message:            "Certificate re-issuance triggered before a planned upgrade of its node group",
+		LastTransitionTime: &now,
+		ObservedGeneration: cert.GetGeneration(),
+	}
+	var found bool
+	for i := range cert.Status.Conditions {
+		if cert.Status.Conditions[i].Type == condition.Type {
+			cert.Status.Conditions[i] = condition
+			found = true
+		}
+	}
+	if !found {
+		cert.Status.Conditions = append(cert.Status.Conditions, condition)
+	}
+	if err := cli.Status().Update(ctx, cert); err != nil {
+		return fmt.Errorf("renew certificate %s: %w", cert.GetName(), err)
+	}
+	return nil
+}
+
+// setCertificatesPreRotatingCondition records the pre-rotation phase of the
+// node certificates of a group.
+func (r *NodeGroupReconciler) setCertificatesPreRotatingCondition(ctx context.Context, group *meshv1.NodeGroup, status metav1.ConditionStatus, reason, message string) error {
+	if len(message) > maxConditionMessageLength {
+		message = message[:maxConditionMessageLength]
+	}
+	condition := metav1.Condition{
+		Type:               meshv1.CertificatesPreRotatingCondition,
+		Status:             status,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             reason,
+		Message:            message,
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	meta.SetStatusCondition(&group.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, group); err != nil {
+		return fmt.Errorf("update certificates pre-rotating condition: %w", err)
+	}
+	return nil
+}
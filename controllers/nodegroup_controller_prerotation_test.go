@@ -0,0 +1,132 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestCertificatePreRotation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "group",
+			Namespace:   "default",
+			Annotations: map[string]string{meshv1.PreRotateCertificatesAnnotation: "true"},
+		},
+		Spec: meshv1.NodeGroupSpec{Replicas: pointer(int32(2))},
+	}
+	issued := func(name string, remaining time.Duration) *certv1.Certificate {
+		return &certv1.Certificate{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status: certv1.CertificateStatus{
+				NotBefore: pointer(metav1.NewTime(time.Now().Add(-60 * 24 * time.Hour))),
+				NotAfter:  pointer(metav1.NewTime(time.Now().Add(remaining))),
+			},
+		}
+	}
+	expiring := issued(meshv1.MeshNodeCertName(mesh, group, 0), 5*24*time.Hour)
+	fresh := issued(meshv1.MeshNodeCertName(mesh, group, 1), 60*24*time.Hour)
+	cli := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(group, expiring, fresh).
+		WithStatusSubresource(group, expiring, fresh).
+		Build()
+	r := &NodeGroupReconciler{Client: cli, Recorder: record.NewFakeRecorder(10)}
+
+	wait, err := r.reconcileCertificatePreRotation(ctx, mesh, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait == 0 {
+		t.Fatal("expected to wait for the expiring certificate to be renewed")
+	}
+	if !meta.IsStatusConditionTrue(group.Status.Conditions, meshv1.CertificatesPreRotatingCondition) {
+		t.Errorf("expected the pre-rotating condition, got %v", group.Status.Conditions)
+	}
+	var cert certv1.Certificate
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(expiring), &cert); err != nil {
+		t.Fatal(err)
+	}
+	if !certificateIssuing(&cert) {
+		t.Fatal("expected the expiring certificate to be renewed")
+	}
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(fresh), &cert); err != nil {
+		t.Fatal(err)
+	}
+	if certificateIssuing(&cert) {
+		t.Error("expected the certificate outside the threshold to be kept")
+	}
+
+	// Still waiting while the certificate is issued
+	wait, err = r.reconcileCertificatePreRotation(ctx, mesh, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait == 0 {
+		t.Fatal("expected to wait while the certificate is issued")
+	}
+
+	// Renewed by cert-manager, with a lifetime shorter than the threshold
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(expiring), &cert); err != nil {
+		t.Fatal(err)
+	}
+	cert.Status.NotBefore = pointer(metav1.Now())
+	cert.Status.NotAfter = pointer(metav1.NewTime(time.Now().Add(24 * time.Hour)))
+	cert.Status.Conditions = []certv1.CertificateCondition{{
+		Type:   certv1.CertificateConditionIssuing,
+		Status: cmmeta.ConditionFalse,
+	}}
+	if err := cli.Status().Update(ctx, &cert); err != nil {
+		t.Fatal(err)
+	}
+	wait, err = r.reconcileCertificatePreRotation(ctx, mesh, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("expected the group to be applied once renewed, got wait %v", wait)
+	}
+	cond := meta.FindStatusCondition(group.Status.Conditions, meshv1.CertificatesPreRotatingCondition)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "Renewed" {
+		t.Errorf("expected the pre-rotation to be complete, got %v", cond)
+	}
+}
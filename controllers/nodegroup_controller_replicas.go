@@ -0,0 +1,142 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// reconcileReplicaServices applies the LoadBalancer service of each replica
+// of a group exposed per replica and returns the external addresses of each,
+// indexed by ordinal. The readiness of every service is recorded in the status
+// of the group, ready is false while any of them waits for its addresses.
+func (r *NodeGroupReconciler) reconcileReplicaServices(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup, families meshv1.ServiceIPFamilies) (replicaURLs [][]string, ready bool, err error) {
+	svcs := resources.NewNodeGroupLBServices(mesh, group, families)
+	toApply := make([]client.Object, 0, len(svcs))
+	for _, svc := range svcs {
+		if err := setLoadBalancerClass(ctx, cli, svc); err != nil {
+			return nil, false, fmt.Errorf("determine load balancer class: %w", err)
+		}
+		toApply = append(toApply, svc)
+	}
+	if err := resources.Apply(ctx, cli, toApply); err != nil {
+		return nil, false, fmt.Errorf("apply replica services: %w", err)
+	}
+	ready = true
+	replicaURLs = make([][]string, len(svcs))
+	statuses := make([]meshv1.NodeReplicaAddresses, 0, len(svcs))
+	for i, svc := range svcs {
+		var lb corev1.Service
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(svc), &lb); err != nil {
+			return nil, false, fmt.Errorf("get replica service: %w", err)
+		}
+		addrs, err := inspect.LBExternalIPs(&lb)
+		if err != nil && !errors.Is(err, ErrLBNotReady) {
+			return nil, false, fmt.Errorf("get replica service addresses: %w", err)
+		}
+		replicaURLs[i] = addrs
+		statuses = append(statuses, meshv1.NodeReplicaAddresses{
+			Ordinal:   int32(i),
+			Addresses: addrs,
+			Ready:     err == nil,
+		})
+		if err != nil {
+			ready = false
+		}
+	}
+	if !equality.Semantic.DeepEqual(group.Status.ReplicaAddresses, statuses) {
+		group.Status.ReplicaAddresses = statuses
+		if err := r.Status().Update(ctx, group); err != nil {
+			return nil, false, fmt.Errorf("update replica addresses: %w", err)
+		}
+	}
+	return replicaURLs, ready, nil
+}
+
+// replicaExternalURLs returns the addresses of every replica service, without
+// duplicates, in the order of the replicas.
+func replicaExternalURLs(replicaURLs [][]string) []string {
+	var out []string
+	seen := make(map[string]struct{})
+	for _, urls := range replicaURLs {
+		for _, url := range urls {
+			if _, ok := seen[url]; ok {
+				continue
+			}
+			seen[url] = struct{}{}
+			out = append(out, url)
+		}
+	}
+	return out
+}
+
+// deleteStaleLBServices deletes the load balancer services of a group that
+// are no longer used: the services of replicas removed by a scale down, and
+// the services of the other mode when the group switches between a single
+// service and a service per replica.
+func (r *NodeGroupReconciler) deleteStaleLBServices(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) error {
+	var svcs corev1.ServiceList
+	err := cli.List(ctx, &svcs,
+		client.InNamespace(group.GetNamespace()),
+		client.MatchingLabels(meshv1.NodeGroupSelector(mesh, group)))
+	if err != nil {
+		return fmt.Errorf("list services: %w", err)
+	}
+	perReplica := group.ExposedPerReplica()
+	lbName := meshv1.MeshNodeGroupLBName(mesh, group)
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		if svc.GetName() == lbName {
+			if !perReplica {
+				continue
+			}
+		} else {
+			ordinal, err := strconv.Atoi(strings.TrimPrefix(svc.GetName(), lbName+"-"))
+			if err != nil || !strings.HasPrefix(svc.GetName(), lbName+"-") {
+				// Not a replica service
+				continue
+			}
+			if perReplica && ordinal < int(group.Replicas()) {
+				continue
+			}
+		}
+		log.FromContext(ctx).Info("Deleting stale load balancer service", "service", svc.GetName())
+		if err := cli.Delete(ctx, svc); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("delete stale load balancer service: %w", err)
+		}
+	}
+	if !perReplica && len(group.Status.ReplicaAddresses) > 0 {
+		group.Status.ReplicaAddresses = nil
+		if err := r.Status().Update(ctx, group); err != nil {
+			return fmt.Errorf("clear replica addresses: %w", err)
+		}
+	}
+	return nil
+}
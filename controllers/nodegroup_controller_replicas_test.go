@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestReplicaServices(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: pointer(int32(2)),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{
+					Type:          corev1.ServiceTypeLoadBalancer,
+					GRPCPort:      8443,
+					WireGuardPort: 51820,
+					PerReplica:    true,
+				},
+			},
+		},
+	}
+	service := func(name string, ingress ...string) *corev1.Service {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    meshv1.NodeGroupLabels(mesh, group),
+			},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		}
+		for _, ip := range ingress {
+			svc.Status.LoadBalancer.Ingress = append(svc.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+		}
+		return svc
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(
+			group,
+			// The service of the group before it was exposed per replica
+			service("mesh-group-public", "203.0.113.1"),
+			service("mesh-group-public-0", "203.0.113.10"),
+			// Still waiting for an address
+			service("mesh-group-public-1"),
+			// A replica removed by a scale down
+			service("mesh-group-public-2", "203.0.113.12"),
+		).
+		WithStatusSubresource(group).
+		Build()
+	r := &NodeGroupReconciler{Client: cli}
+
+	if err := r.deleteStaleLBServices(ctx, cli, mesh, group); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"mesh-group-public", "mesh-group-public-2"} {
+		err := cli.Get(ctx, client.ObjectKey{Name: name, Namespace: "default"}, &corev1.Service{})
+		if !apierrors.IsNotFound(err) {
+			t.Errorf("expected service %s to be deleted, got %v", name, err)
+		}
+	}
+
+	replicaURLs, ready, err := r.reconcileReplicaServices(ctx, cli, mesh, group, meshv1.ServiceIPFamilies{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready {
+		t.Error("expected to wait for the service of the second replica")
+	}
+	if want := [][]string{{"203.0.113.10"}, nil}; !reflect.DeepEqual(replicaURLs, want) {
+		t.Errorf("expected replica addresses %v, got %v", want, replicaURLs)
+	}
+	var svc corev1.Service
+	if err := cli.Get(ctx, client.ObjectKey{Name: "mesh-group-public-1", Namespace: "default"}, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.Spec.Selector["statefulset.kubernetes.io/pod-name"]; got != "mesh-group-1" {
+		t.Errorf("expected the service to select the pod of its replica, got %q", got)
+	}
+	want := []meshv1.NodeReplicaAddresses{
+		{Ordinal: 0, Addresses: []string{"203.0.113.10"}, Ready: true},
+		{Ordinal: 1},
+	}
+	if !reflect.DeepEqual(group.Status.ReplicaAddresses, want) {
+		t.Errorf("expected replica status %v, got %v", want, group.Status.ReplicaAddresses)
+	}
+
+	// Switching back to a single service removes the replica services
+	group.Spec.Cluster.Service.PerReplica = false
+	if err := r.deleteStaleLBServices(ctx, cli, mesh, group); err != nil {
+		t.Fatal(err)
+	}
+	var svcs corev1.ServiceList
+	if err := cli.List(ctx, &svcs, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	if len(svcs.Items) != 0 {
+		t.Errorf("expected the replica services to be deleted, got %d services", len(svcs.Items))
+	}
+	if group.Status.ReplicaAddresses != nil {
+		t.Errorf("expected the replica status to be cleared, got %v", group.Status.ReplicaAddresses)
+	}
+}
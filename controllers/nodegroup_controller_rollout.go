@@ -0,0 +1,69 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// instanceRolloutInterval is how often a group is requeued while the
+// instances of its replicas are replaced.
+const instanceRolloutInterval = 15 * time.Second
+
+// instanceRollout replaces the instances of a group whose config changed one
+// replica at a time. Running instances are only replaced while every other
+// replica is running, so a config change never takes down more than one
+// replica of the group. Instances that are not running are replaced right
+// away, since replacing them takes down nothing that was serving.
+type instanceRollout struct {
+	// unavailable is the number of replicas without a running instance.
+	unavailable int
+	// pending is true once an instance was found to need replacing.
+	pending bool
+}
+
+// newInstanceRollout returns a rollout for a group with the given number of
+// replicas, of which the given number have a running instance.
+func newInstanceRollout(replicas, running int) *instanceRollout {
+	return &instanceRollout{unavailable: replicas - running}
+}
+
+// Replace returns true if an instance whose config changed may be replaced
+// now. Otherwise it is left running until the replicas replaced before it
+// are running again.
+func (r *instanceRollout) Replace(running bool) bool {
+	r.pending = true
+	if !running {
+		return true
+	}
+	if r.unavailable > 0 {
+		return false
+	}
+	r.unavailable++
+	return true
+}
+
+// Result returns the result of reconciling the group. It is requeued until
+// no more instances need replacing.
+func (r *instanceRollout) Result() ctrl.Result {
+	if r.pending {
+		return ctrl.Result{RequeueAfter: instanceRolloutInterval}
+	}
+	return ctrl.Result{}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import "testing"
+
+func TestInstanceRollout(t *testing.T) {
+	rollout := newInstanceRollout(3, 3)
+	if res := rollout.Result(); res.RequeueAfter != 0 {
+		t.Errorf("expected no requeue without replaced instances, got %v", res.RequeueAfter)
+	}
+	if !rollout.Replace(true) {
+		t.Fatal("expected the first running instance to be replaced")
+	}
+	if rollout.Replace(true) {
+		t.Error("expected a second running instance to wait for the first")
+	}
+	if !rollout.Replace(false) {
+		t.Error("expected an instance that is not running to be replaced right away")
+	}
+	if res := rollout.Result(); res.RequeueAfter != instanceRolloutInterval {
+		t.Errorf("expected a requeue while instances are replaced, got %v", res.RequeueAfter)
+	}
+
+	// Replicas still starting hold back every running instance
+	rollout = newInstanceRollout(3, 2)
+	if rollout.Replace(true) {
+		t.Error("expected running instances to wait for the replica that is not running")
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/cloudconfig"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+// getConfigSecrets returns the values of the options the node config reads
+// from secrets, keyed by the environment variables passing them to the node.
+// The resource versions of the secrets are recorded in the config, so nodes
+// are restarted when a secret is rotated. Secrets are read through the client
+// of the cluster the nodes run in.
+func getConfigSecrets(ctx context.Context, cli client.Client, namespace string, conf *nodeconfig.Config) (map[string]string, error) {
+	env := make(map[string]string, len(conf.Secrets))
+	for _, opt := range conf.Secrets {
+		ref := opt.SecretKeyRef
+		var secret corev1.Secret
+		err := cli.Get(ctx, client.ObjectKey{
+			Name:      ref.Name,
+			Namespace: namespace,
+		}, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("get config secret %s: %w", ref.Name, err)
+		}
+		data, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("no key %s in secret %s/%s", ref.Key, namespace, ref.Name)
+		}
+		conf.SetSecretVersion(secret.GetName(), secret.GetResourceVersion())
+		env[opt.EnvName()] = strings.TrimSpace(string(data))
+	}
+	return env, nil
+}
+
+// getSSHUsers returns the SSH users created on the instances of the group.
+// Keys read from secrets are appended to the authorized keys in the spec, one
+// per line. Empty lines and comments are skipped.
+func getSSHUsers(ctx context.Context, cli client.Client, group *meshv1.NodeGroup) ([]cloudconfig.User, error) {
+	spec := group.Spec.SSHUsers()
+	if spec == nil {
+		return nil, nil
+	}
+	users := make([]cloudconfig.User, 0, len(spec.Users))
+	for _, u := range spec.Users {
+		user := cloudconfig.User{
+			Name:           u.Name,
+			AuthorizedKeys: append([]string(nil), u.AuthorizedKeys...),
+			Sudo:           u.Sudo,
+		}
+		if ref := u.AuthorizedKeysSecretRef; ref != nil {
+			var secret corev1.Secret
+			err := cli.Get(ctx, client.ObjectKey{
+				Name:      ref.Name,
+				Namespace: group.GetNamespace(),
+			}, &secret)
+			if err != nil {
+				return nil, fmt.Errorf("get authorized keys secret %s: %w", ref.Name, err)
+			}
+			data, ok := secret.Data[ref.Key]
+			if !ok {
+				return nil, fmt.Errorf("no key %s in secret %s/%s", ref.Key, group.GetNamespace(), ref.Name)
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				user.AuthorizedKeys = append(user.AuthorizedKeys, line)
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// secretToNodeGroups maps a secret to the node groups in its namespace that
+// read it, either as a file written to Google Cloud instances, as authorized
+// SSH keys or as a node config option. Secrets in remote clusters are not
+// watched, their rotations are picked up on the next reconcile of the group.
+func (r *NodeGroupReconciler) secretToNodeGroups(ctx context.Context, obj client.Object) []reconcile.Request {
+	var groups meshv1.NodeGroupList
+	if err := r.List(ctx, &groups, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list node groups")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, group := range groups.Items {
+		if r.readsSecret(ctx, &group, obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&group)})
+		}
+	}
+	return requests
+}
+
+// readsSecret returns true if the group reads the secret with the given name.
+func (r *NodeGroupReconciler) readsSecret(ctx context.Context, group *meshv1.NodeGroup, name string) bool {
+	if group.Spec.GoogleCloud != nil {
+		for _, file := range group.Spec.GoogleCloud.FileSecrets {
+			if file.SecretRef.Name == name {
+				return true
+			}
+		}
+	}
+	if ssh := group.Spec.SSHUsers(); ssh != nil {
+		for _, user := range ssh.Users {
+			if ref := user.AuthorizedKeysSecretRef; ref != nil && ref.Name == name {
+				return true
+			}
+		}
+	}
+	var mesh meshv1.Mesh
+	if group.Spec.ConfigGroup != "" {
+		// Config groups are defined on the mesh
+		if err := r.Get(ctx, group.MeshKey(), &mesh); err != nil {
+			return false
+		}
+	}
+	groupcfg, err := group.MergedConfig(&mesh)
+	if err != nil {
+		return false
+	}
+	for _, ref := range groupcfg.SecretKeyRefs() {
+		if ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
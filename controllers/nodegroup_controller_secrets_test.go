@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestSecretToNodeGroups(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	fileSecret := func(name string) []meshv1.FileSecret {
+		return []meshv1.FileSecret{{SecretRef: corev1.LocalObjectReference{Name: name}, Key: "key", Path: "/etc/file"}}
+	}
+	newGroup := func(name, namespace string, spec meshv1.NodeGroupSpec) *meshv1.NodeGroup {
+		spec.Mesh = corev1.ObjectReference{Name: "mesh"}
+		return &meshv1.NodeGroup{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			&meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}},
+			newGroup("files", "default", meshv1.NodeGroupSpec{
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{FileSecrets: fileSecret("shared")},
+			}),
+			newGroup("ssh", "default", meshv1.NodeGroupSpec{
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{
+					FileSecrets: fileSecret("unrelated"),
+					SSH: &meshv1.CloudConfigSSH{Users: []meshv1.CloudConfigSSHUser{{
+						Name:                    "admin",
+						AuthorizedKeysSecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "shared"}, Key: "keys"},
+					}}},
+				},
+			}),
+			newGroup("unrelated", "default", meshv1.NodeGroupSpec{
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{FileSecrets: fileSecret("unrelated")},
+			}),
+			newGroup("other-namespace", "team", meshv1.NodeGroupSpec{
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{FileSecrets: fileSecret("shared")},
+			}),
+		).
+		Build()
+	r := &NodeGroupReconciler{Client: cli}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"}}
+	var got []string
+	for _, req := range r.secretToNodeGroups(context.Background(), secret) {
+		got = append(got, req.String())
+	}
+	sort.Strings(got)
+	want := []string{"default/files", "default/ssh"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected requests %v, got %v", want, got)
+	}
+}
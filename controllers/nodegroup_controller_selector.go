@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// selectorImmutableRetryInterval is how long to wait before checking the
+// StatefulSet of a group whose selector could not be applied again.
+const selectorImmutableRetryInterval = time.Minute
+
+// reconcileStatefulSetSelector makes sure the StatefulSet of a cluster node
+// group can be applied. StatefulSets labeled by previous versions of the
+// operator are migrated first. A StatefulSet that still selects its pods any
+// other way is recreated with orphaned pods under the Recreate policy, and
+// otherwise reported in the SelectorImmutable condition, as applying it would
+// only be rejected. This runs before the pre-flight, whose dry run would be
+// rejected as well. It returns how long to wait before the group can be
+// applied, or zero if it can be applied now.
+func (r *NodeGroupReconciler) reconcileStatefulSetSelector(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (time.Duration, error) {
+	cli, err := r.getClusterClient(ctx, group)
+	if err != nil {
+		return 0, fmt.Errorf("create cluster client: %w", err)
+	}
+	recreating, err := migrateNodeGroupLabels(ctx, cli, mesh, group)
+	if err != nil {
+		return 0, fmt.Errorf("migrate legacy labels: %w", err)
+	}
+	if recreating {
+		return labelMigrationRetryInterval, nil
+	}
+	if group.Spec.Cluster.AdoptExisting != nil {
+		// Adopted StatefulSets keep their selector
+		return 0, nil
+	}
+	var sts appsv1.StatefulSet
+	err = cli.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshNodeGroupStatefulSetName(mesh, group),
+		Namespace: group.GetNamespace(),
+	}, &sts)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return 0, fmt.Errorf("get statefulset: %w", err)
+	}
+	selector := meshv1.NodeGroupSelector(mesh, group)
+	if apierrors.IsNotFound(err) || selectorMatches(sts.Spec.Selector, selector) {
+		return 0, r.setSelectorImmutableCondition(ctx, group, "")
+	}
+	if sts.GetDeletionTimestamp() != nil {
+		return labelMigrationRetryInterval, nil
+	}
+	want := metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: selector})
+	// Only pods selected by labels alone can be relabeled
+	current, err := metav1.LabelSelectorAsMap(sts.Spec.Selector)
+	if err != nil || len(current) == 0 {
+		message := fmt.Sprintf("StatefulSet %s selects its pods by %s but the node group selects them by %s. "+
+			"It cannot be recreated by the operator, delete it with --cascade=orphan and relabel its pods to keep them",
+			sts.GetName(), metav1.FormatLabelSelector(sts.Spec.Selector), want)
+		if err := r.setSelectorImmutableCondition(ctx, group, message); err != nil {
+			return 0, err
+		}
+		return selectorImmutableRetryInterval, nil
+	}
+	if group.Spec.Cluster.SelectorChangePolicy != meshv1.SelectorChangeRecreate {
+		message := fmt.Sprintf("StatefulSet %s selects its pods by %s but the node group selects them by %s. "+
+			"The selector of a StatefulSet cannot be changed, set spec.cluster.selectorChangePolicy to Recreate "+
+			"to recreate it while keeping its pods", sts.GetName(), metav1.FormatLabelSelector(sts.Spec.Selector), want)
+		if err := r.setSelectorImmutableCondition(ctx, group, message); err != nil {
+			return 0, err
+		}
+		return selectorImmutableRetryInterval, nil
+	}
+
+	// Relabel the pods first, so they are adopted once the StatefulSet is
+	// created again with the new selector
+	var pods corev1.PodList
+	err = cli.List(ctx, &pods, client.InNamespace(group.GetNamespace()), client.MatchingLabels(current))
+	if err != nil {
+		return 0, fmt.Errorf("list statefulset pods: %w", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if selectorMatches(&metav1.LabelSelector{MatchLabels: pod.GetLabels()}, selector) {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		for k, v := range selector {
+			pod.Labels[k] = v
+		}
+		if err := cli.Patch(ctx, pod, patch); err != nil {
+			return 0, fmt.Errorf("relabel pod %s: %w", pod.GetName(), err)
+		}
+	}
+	log.FromContext(ctx).Info("Recreating statefulset with a changed selector", "statefulset", sts.GetName())
+	r.Recorder.Eventf(group, corev1.EventTypeNormal, "RecreatingStatefulSet",
+		"Recreating StatefulSet %s to select its pods by %s", sts.GetName(), want)
+	if simulate(ctx, "recreate statefulset", "name", sts.GetName()) {
+		return 0, nil
+	}
+	err = cli.Delete(ctx, &sts, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	if client.IgnoreNotFound(err) != nil {
+		return 0, fmt.Errorf("delete statefulset: %w", err)
+	}
+	return labelMigrationRetryInterval, nil
+}
+
+// selectorMatches returns true if the given label selector selects pods by
+// exactly the given labels.
+func selectorMatches(selector *metav1.LabelSelector, labels map[string]string) bool {
+	if selector == nil || len(selector.MatchExpressions) > 0 || len(selector.MatchLabels) != len(labels) {
+		return false
+	}
+	for k, v := range labels {
+		if selector.MatchLabels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// setSelectorImmutableCondition records why the StatefulSet of the group
+// cannot be applied in the SelectorImmutable condition and raises a warning
+// event when it changes. An empty message clears the condition.
+func (r *NodeGroupReconciler) setSelectorImmutableCondition(ctx context.Context, group *meshv1.NodeGroup, message string) error {
+	condition := metav1.Condition{
+		Type:               meshv1.SelectorImmutableCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: group.GetGeneration(),
+		Reason:             "SelectorMatches",
+		Message:            "The StatefulSet selects the pods of the node group",
+	}
+	if message != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "SelectorChanged"
+		condition.Message = message
+	}
+	current := meta.FindStatusCondition(group.Status.Conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once a selector has changed
+		return nil
+	}
+	if current != nil && current.Status == condition.Status &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	if condition.Status == metav1.ConditionTrue && (current == nil || current.Message != condition.Message) {
+		r.Recorder.Event(group, corev1.EventTypeWarning, "SelectorImmutable", message)
+	}
+	meta.SetStatusCondition(&group.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, group); err != nil {
+		return fmt.Errorf("update selector immutable condition: %w", err)
+	}
+	return nil
+}
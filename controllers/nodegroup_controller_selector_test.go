@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestStatefulSetSelectorChange(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	stale := map[string]string{"app": "renamed-mesh"}
+	objects := func(policy meshv1.SelectorChangePolicy) (*meshv1.NodeGroup, []client.Object) {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", Generation: 1},
+			Spec: meshv1.NodeGroupSpec{
+				Cluster: &meshv1.NodeGroupClusterConfig{SelectorChangePolicy: policy},
+			},
+		}
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh-group", Namespace: "default"},
+			Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: stale}},
+		}
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "mesh-group-0", Namespace: "default", Labels: stale}}
+		return group, []client.Object{group, sts, pod}
+	}
+	stsKey := client.ObjectKey{Name: "mesh-group", Namespace: "default"}
+
+	t.Run("fail", func(t *testing.T) {
+		group, objs := objects(meshv1.SelectorChangeFail)
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(group).Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &NodeGroupReconciler{Client: cli, Recorder: recorder}
+
+		for i := 0; i < 2; i++ {
+			wait, err := r.reconcileStatefulSetSelector(ctx, mesh, group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != selectorImmutableRetryInterval {
+				t.Fatalf("expected to wait for the selector to be fixed, got %v", wait)
+			}
+		}
+		if !meta.IsStatusConditionTrue(group.Status.Conditions, meshv1.SelectorImmutableCondition) {
+			t.Errorf("expected the selector immutable condition, got %v", group.Status.Conditions)
+		}
+		if len(recorder.Events) != 1 {
+			t.Errorf("expected a single warning event, got %d", len(recorder.Events))
+		}
+		if err := cli.Get(ctx, stsKey, &appsv1.StatefulSet{}); err != nil {
+			t.Errorf("expected the statefulset to be kept: %v", err)
+		}
+	})
+
+	t.Run("recreate", func(t *testing.T) {
+		group, objs := objects(meshv1.SelectorChangeRecreate)
+		group.Status.Conditions = []metav1.Condition{{
+			Type:   meshv1.SelectorImmutableCondition,
+			Status: metav1.ConditionTrue,
+			Reason: "SelectorChanged",
+		}}
+		cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(group).Build()
+		r := &NodeGroupReconciler{Client: cli, Recorder: record.NewFakeRecorder(10)}
+
+		wait, err := r.reconcileStatefulSetSelector(ctx, mesh, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait == 0 {
+			t.Fatalf("expected to wait for the statefulset to be recreated")
+		}
+		if err := cli.Get(ctx, stsKey, &appsv1.StatefulSet{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected the statefulset to be deleted, got %v", err)
+		}
+		// The orphaned pod is selected by the recreated StatefulSet
+		var pod corev1.Pod
+		if err := cli.Get(ctx, client.ObjectKey{Name: "mesh-group-0", Namespace: "default"}, &pod); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range meshv1.NodeGroupSelector(mesh, group) {
+			if pod.Labels[k] != v {
+				t.Errorf("expected the pod to be relabeled with %s=%s, got %v", k, v, pod.Labels)
+			}
+		}
+
+		wait, err = r.reconcileStatefulSetSelector(ctx, mesh, group)
+		if err != nil || wait != 0 {
+			t.Fatalf("expected the group to be applied, got %v, %v", wait, err)
+		}
+		if !meta.IsStatusConditionFalse(group.Status.Conditions, meshv1.SelectorImmutableCondition) {
+			t.Errorf("expected the selector immutable condition to be cleared, got %v", group.Status.Conditions)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/providers"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// templateProvider records the changes to the pod template of the StatefulSet
+// rendered for a group, standing in for a provider that rolls out its nodes.
+type templateProvider struct {
+	rollouts int
+	template any
+}
+
+func (p *templateProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	// The log level stands in for the checksum of the rendered config
+	rendered := group.DeepCopy()
+	rendered.Spec.Cluster = &meshv1.NodeGroupClusterConfig{}
+	template := resources.NewNodeGroupStatefulSet(mesh, rendered, meshv1.DefaultMetricsProxyImage, rendered.Spec.Config.LogLevel).Spec.Template
+	if !equality.Semantic.DeepEqual(p.template, template) {
+		p.rollouts++
+		p.template = template
+	}
+	return ctrl.Result{}, nil
+}
+
+func (p *templateProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	return nil
+}
+
+func TestNodeGroupRolloutDebounce(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:        corev1.ObjectReference{Name: "mesh"},
+			Image:       "ghcr.io/webmeshproj/node:v0.1.0",
+			Config:      &meshv1.NodeGroupConfig{LogLevel: "info"},
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(mesh, group).
+		WithStatusSubresource(group).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, cli client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if patch.Type() == types.ApplyPatchType {
+					// Certificates are not issued by the fake client
+					return nil
+				}
+				return cli.Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+	provider := &templateProvider{}
+	window := 100 * time.Millisecond
+	r := &NodeGroupReconciler{
+		Client:    cli,
+		Scheme:    scheme,
+		Recorder:  record.NewFakeRecorder(100),
+		Debounce:  fairness.NewDebounce(window),
+		Providers: providers.Registry{providers.GoogleCloud: provider},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(group)}
+	reconcile := func() ctrl.Result {
+		t.Helper()
+		res, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	edit := func(mutate func(*meshv1.NodeGroup)) {
+		t.Helper()
+		var latest meshv1.NodeGroup
+		if err := cli.Get(ctx, req.NamespacedName, &latest); err != nil {
+			t.Fatal(err)
+		}
+		mutate(&latest)
+		latest.SetGeneration(latest.GetGeneration() + 1)
+		if err := cli.Update(ctx, &latest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcile()
+	if provider.rollouts != 1 {
+		t.Fatalf("expected the group to be deployed, got %d rollouts", provider.rollouts)
+	}
+
+	// The image and the config are changed by separate applies
+	edit(func(group *meshv1.NodeGroup) { group.Spec.Image = "ghcr.io/webmeshproj/node:v0.2.0" })
+	if res := reconcile(); res.RequeueAfter == 0 {
+		t.Fatalf("expected the image change to be held")
+	}
+	edit(func(group *meshv1.NodeGroup) { group.Spec.Config.LogLevel = "debug" })
+	res := reconcile()
+	if res.RequeueAfter == 0 {
+		t.Fatalf("expected the config change to be held")
+	}
+	if provider.rollouts != 1 {
+		t.Fatalf("expected no rollout while changes are held, got %d", provider.rollouts)
+	}
+
+	time.Sleep(res.RequeueAfter)
+	reconcile()
+	if provider.rollouts != 2 {
+		t.Fatalf("expected both changes to be rolled out together, got %d rollouts", provider.rollouts)
+	}
+}
+
+func TestDeleteStaleNodeConfigOwnership(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{
+		TypeMeta:   metav1.TypeMeta{APIVersion: meshv1.GroupVersion.String(), Kind: "Mesh"},
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default", UID: "mesh-uid"},
+	}
+	// The config of a group named admin has the name of the admin config
+	group := &meshv1.NodeGroup{
+		TypeMeta:   metav1.TypeMeta{APIVersion: meshv1.GroupVersion.String(), Kind: "NodeGroup"},
+		ObjectMeta: metav1.ObjectMeta{Name: "admin", Namespace: "default", UID: "group-uid"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:    corev1.ObjectReference{Name: "mesh"},
+			Cluster: &meshv1.NodeGroupClusterConfig{ConfigStorage: meshv1.ConfigStorageConfigMap},
+		},
+	}
+	name := meshv1.MeshNodeGroupConfigMapName(mesh, group)
+	tc := []struct {
+		name    string
+		meta    metav1.ObjectMeta
+		deleted bool
+	}{
+		{
+			name: "controlled by the mesh",
+			meta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: meshv1.OwnerReferences(mesh)},
+		},
+		{
+			name: "unlabeled",
+			meta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		},
+		{
+			name: "controlled by another group",
+			meta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: meshv1.OwnerReferences(&meshv1.NodeGroup{
+				TypeMeta:   group.TypeMeta,
+				ObjectMeta: metav1.ObjectMeta{Name: "admin", Namespace: "default", UID: "previous-uid"},
+			})},
+		},
+		{
+			name:    "controlled by the group",
+			meta:    metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: meshv1.OwnerReferences(group)},
+			deleted: true,
+		},
+		{
+			name:    "labeled for the group",
+			meta:    metav1.ObjectMeta{Name: name, Namespace: "default", Labels: meshv1.NodeGroupLabels(mesh, group)},
+			deleted: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(&corev1.Secret{ObjectMeta: tt.meta}).
+				Build()
+			r := &NodeGroupReconciler{Client: cli, Scheme: scheme}
+			if err := r.deleteStaleNodeConfig(ctx, cli, mesh, group); err != nil {
+				t.Fatal(err)
+			}
+			err := cli.Get(ctx, client.ObjectKey{Name: name, Namespace: "default"}, &corev1.Secret{})
+			if deleted := apierrors.IsNotFound(err); deleted != tt.deleted {
+				t.Errorf("expected deleted %v, got %v (%v)", tt.deleted, deleted, err)
+			}
+		})
+	}
+}
+
+func TestClusterProviderDeleteOwnedInventory(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		TypeMeta:   metav1.TypeMeta{APIVersion: meshv1.GroupVersion.String(), Kind: "NodeGroup"},
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", UID: "group-uid"},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:     corev1.ObjectReference{Name: "mesh"},
+			Replicas: resources.Pointer(int32(2)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "default"}
+	}
+	owned := func(name string) metav1.ObjectMeta {
+		m := meta(name)
+		m.OwnerReferences = meshv1.OwnerReferences(group)
+		return m
+	}
+	labeled := func(name string) metav1.ObjectMeta {
+		m := meta(name)
+		m.Labels = meshv1.NodeGroupSelector(mesh, group)
+		return m
+	}
+	objs := map[client.Object]bool{
+		&appsv1.StatefulSet{ObjectMeta: owned("mesh-group")}:                    true,
+		&corev1.Service{ObjectMeta: owned("mesh-group")}:                        true,
+		&corev1.ConfigMap{ObjectMeta: labeled("mesh-group")}:                    true,
+		&corev1.PersistentVolumeClaim{ObjectMeta: labeled("data-mesh-group-0")}: true,
+		// Objects that only share the names of the group's objects
+		&corev1.Service{ObjectMeta: meta("mesh-group-public")}:               false,
+		&corev1.Secret{ObjectMeta: meta("mesh-group")}:                       false,
+		&corev1.PersistentVolumeClaim{ObjectMeta: meta("data-mesh-group-1")}: false,
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for obj := range objs {
+		builder = builder.WithObjects(obj)
+	}
+	cli := builder.Build()
+	r := &NodeGroupReconciler{Client: cli, Scheme: scheme, Recorder: record.NewFakeRecorder(10)}
+	if err := (clusterProvider{r}).Delete(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	for obj, deleted := range objs {
+		err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		if got := apierrors.IsNotFound(err); got != deleted {
+			t.Errorf("%T %s: expected deleted %v, got %v (%v)", obj, obj.GetName(), deleted, got, err)
+		}
+	}
+}
+
+func TestNodeGroupDefaultsNotPersisted(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	// Created without going through the webhooks
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "group",
+			Namespace:   "default",
+			Annotations: map[string]string{meshv1.AcceptConfigChecksumAnnotation: "true"},
+		},
+		Spec: meshv1.NodeGroupSpec{
+			Mesh:        corev1.ObjectReference{Name: "mesh"},
+			Config:      &meshv1.NodeGroupConfig{LogLevel: "info"},
+			GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+		},
+	}
+	// The metadata changes of the reconcile must not carry the defaults
+	var patches []string
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(mesh, group).
+		WithStatusSubresource(group).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, cli client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if patch.Type() == types.ApplyPatchType {
+					// Certificates are not issued by the fake client
+					return nil
+				}
+				if _, ok := obj.(*meshv1.NodeGroup); ok {
+					data, err := patch.Data(obj)
+					if err != nil {
+						return err
+					}
+					patches = append(patches, string(data))
+				}
+				return cli.Patch(ctx, obj, patch, opts...)
+			},
+			Update: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if _, ok := obj.(*meshv1.NodeGroup); ok {
+					t.Errorf("expected the node group to be patched, not updated")
+				}
+				return cli.Update(ctx, obj, opts...)
+			},
+		}).
+		Build()
+	provider := &templateProvider{}
+	r := &NodeGroupReconciler{
+		Client:    cli,
+		Scheme:    scheme,
+		Recorder:  record.NewFakeRecorder(100),
+		Providers: providers.Registry{providers.GoogleCloud: provider},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(group)}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	template, ok := provider.template.(corev1.PodTemplateSpec)
+	if !ok || template.Spec.Containers[0].Image != meshv1.DefaultNodeImage {
+		t.Fatalf("expected the group to be rendered with the default image, got %+v", provider.template)
+	}
+
+	var stored meshv1.NodeGroup
+	if err := cli.Get(ctx, req.NamespacedName, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(&stored, nodeGroupsForegroundDeletion) {
+		t.Errorf("expected the finalizer to be added")
+	}
+	if _, ok := stored.Annotations[meshv1.AcceptConfigChecksumAnnotation]; ok {
+		t.Errorf("expected the accept config checksum annotation to be removed")
+	}
+	if len(patches) != 2 {
+		t.Fatalf("expected the annotation and finalizer to be patched, got %v", patches)
+	}
+	for _, patch := range patches {
+		if strings.Contains(patch, `"spec"`) {
+			t.Errorf("expected only metadata to be patched, got %s", patch)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// NodePodWebhookPath is the path the node pod webhook is served on.
+const NodePodWebhookPath = "/mutate-v1-pod"
+
+//+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mnodepod.kb.io,admissionReviewVersions=v1
+
+// NewNodePodWebhook returns the webhook projecting the certificate secret of
+// each node pod into its TLS volume when the pod is created. The pods of a
+// StatefulSet share a template, so the template can only name a placeholder
+// secret. The webhook is only called for pods labeled with
+// meshv1.NodeTLSInjectionLabel, which only groups with more than one replica
+// set. When it is not installed, those pods are reported in the
+// NodeTLSInjectionFailed condition of their group.
+func NewNodePodWebhook(scheme *runtime.Scheme) *webhook.Admission {
+	return &webhook.Admission{Handler: &nodePodTLSInjector{decoder: admission.NewDecoder(scheme)}}
+}
+
+type nodePodTLSInjector struct {
+	decoder *admission.Decoder
+}
+
+// Handle implements admission.Handler.
+func (h *nodePodTLSInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var pod corev1.Pod
+	if err := h.decoder.Decode(req, &pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pod.GetName() == "" {
+		pod.SetName(req.Name)
+	}
+	if err := resources.InjectNodeTLSSecret(&pod); err != nil {
+		return admission.Denied(err.Error())
+	}
+	raw, err := json.Marshal(&pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	log.FromContext(ctx).V(1).Info("projected node certificate secret", "pod", pod.GetName())
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+func TestNodePodWebhookProjectsOwnSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Image:    meshv1.DefaultNodeImage,
+			Replicas: resources.Pointer(int32(2)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	sts := resources.NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+	handler := NewNodePodWebhook(scheme).Handler
+
+	review := func(pod *corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Name:      pod.GetName(),
+			Namespace: pod.GetNamespace(),
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+	}
+
+	for i, obj := range resources.StatefulSetReplicas(sts) {
+		pod := obj.(*corev1.Pod)
+		resp := review(pod)
+		if !resp.Allowed {
+			t.Fatalf("ordinal %d: expected the pod to be admitted: %v", i, resp.Result)
+		}
+		var replaced []string
+		for _, patch := range resp.Patches {
+			if name, ok := patch.Value.(string); ok && patch.Operation == "replace" {
+				replaced = append(replaced, name)
+			}
+		}
+		want := meshv1.MeshNodeCertName(mesh, group, i)
+		if len(replaced) != 1 || replaced[0] != want {
+			t.Errorf("ordinal %d: expected the secret to be replaced with %q, got %v", i, want, replaced)
+		}
+	}
+
+	// Pods created without a name cannot be matched to their secret.
+	unnamed := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "mesh-group-", Namespace: "default"},
+		Spec:       *sts.Spec.Template.Spec.DeepCopy(),
+	}
+	if resp := review(unnamed); resp.Allowed {
+		t.Errorf("expected a pod without a name to be denied")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package operatorconfig resolves the operator settings in effect for each
+// namespace from the flags of the operator and its WebmeshOperatorConfigs.
+package operatorconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+)
+
+// DefaultMaxRetryBackoff is the longest failed bare metal hosts are left
+// alone unless configured otherwise.
+const DefaultMaxRetryBackoff = 10 * time.Minute
+
+// Settings are the operator settings in effect for a namespace.
+type Settings struct {
+	// Images are the default images used for objects that do not set their
+	// own. Operator-wide.
+	Images meshv1.Images
+	// ClusterDomain is the DNS domain of the cluster. Operator-wide.
+	ClusterDomain string
+	// ProviderConcurrency bounds the concurrent operations against each
+	// cloud provider. Operator-wide.
+	ProviderConcurrency map[string]int
+	// MaxRetryBackoff caps the backoff of failed bare metal hosts.
+	MaxRetryBackoff time.Duration
+	// LoadBalancerClass is the class of new LoadBalancer services of node
+	// groups. Empty leaves it to the cluster default.
+	LoadBalancerClass string
+	// SkipJoinServerProbe disables the probe of join servers before they
+	// are rendered into node configs.
+	SkipJoinServerProbe bool
+}
+
+// Defaults returns the settings in effect without any configs.
+func Defaults() *Settings {
+	return &Settings{
+		Images:          meshv1.DefaultImages(),
+		ClusterDomain:   meshv1.DefaultClusterDomain,
+		MaxRetryBackoff: DefaultMaxRetryBackoff,
+	}
+}
+
+// merge overrides the settings with the ones set in the config spec. The
+// operator-wide settings are only merged from the operator's own config.
+func (s *Settings) merge(spec *meshv1.WebmeshOperatorConfigSpec, operatorWide bool) {
+	if operatorWide {
+		if spec.Images != nil {
+			if spec.Images.Node != "" {
+				s.Images.Node = spec.Images.Node
+			}
+			if spec.Images.MetricsProxy != "" {
+				s.Images.MetricsProxy = spec.Images.MetricsProxy
+			}
+		}
+		if spec.ClusterDomain != "" {
+			s.ClusterDomain = strings.TrimSuffix(spec.ClusterDomain, ".")
+		}
+		if len(spec.ProviderConcurrency) > 0 {
+			limits := make(map[string]int, len(s.ProviderConcurrency)+len(spec.ProviderConcurrency))
+			for provider, limit := range s.ProviderConcurrency {
+				limits[provider] = limit
+			}
+			for provider, limit := range spec.ProviderConcurrency {
+				limits[provider] = int(limit)
+			}
+			s.ProviderConcurrency = limits
+		}
+	}
+	if spec.MaxRetryBackoff != nil {
+		s.MaxRetryBackoff = spec.MaxRetryBackoff.Duration
+	}
+	if spec.LoadBalancerClass != "" {
+		s.LoadBalancerClass = spec.LoadBalancerClass
+	}
+	if spec.SkipJoinServerProbe != nil {
+		s.SkipJoinServerProbe = *spec.SkipJoinServerProbe
+	}
+}
+
+// operatorWideEqual returns true if the operator-wide settings of a and b are
+// the same.
+func operatorWideEqual(a, b *Settings) bool {
+	return a.Images == b.Images &&
+		a.ClusterDomain == b.ClusterDomain &&
+		equality.Semantic.DeepEqual(a.ProviderConcurrency, b.ProviderConcurrency)
+}
+
+// Store resolves the settings for each reconcile. Settings are only ever
+// passed on through snapshots, so a change never lands in the middle of a
+// reconcile. Changes to the operator-wide settings are applied to the limiter
+// and reported as soon as they are seen. A nil Store resolves the defaults.
+type Store struct {
+	// Reader reads the configs, usually through the cache of the manager.
+	Reader client.Reader
+	// Namespace is the namespace of the operator. Its config applies to
+	// every namespace. When empty, only namespace configs are read.
+	Namespace string
+	// Base are the settings given by the flags of the operator.
+	Base Settings
+	// Limiter is updated with the provider concurrency.
+	Limiter *fairness.Limiter
+	// OnChange is called after the operator-wide settings changed.
+	OnChange func(ctx context.Context, settings *Settings)
+
+	applied *Settings
+	mu      sync.Mutex
+}
+
+// Snapshot returns the settings for the given namespace. Reconciles take a
+// single snapshot, so a change to the configs applies to a reconcile as a
+// whole.
+func (s *Store) Snapshot(ctx context.Context, namespace string) (*Settings, error) {
+	if s == nil {
+		return Defaults(), nil
+	}
+	return s.snapshot(ctx, s.Reader, namespace)
+}
+
+// Images returns the default images in effect for objects admitted by the
+// webhooks. It implements meshv1.ImagesFunc.
+func (s *Store) Images(ctx context.Context) (meshv1.Images, error) {
+	if s == nil {
+		return Defaults().Images, nil
+	}
+	settings, err := s.snapshot(ctx, s.Reader, s.Namespace)
+	if err != nil {
+		return meshv1.Images{}, err
+	}
+	return settings.Images, nil
+}
+
+// Load applies the operator-wide settings of the config in the operator's
+// namespace, read through the given reader. It is meant to be called at
+// startup, before the cache of the manager is running.
+func (s *Store) Load(ctx context.Context, reader client.Reader) (*Settings, error) {
+	return s.snapshot(ctx, reader, s.Namespace)
+}
+
+func (s *Store) snapshot(ctx context.Context, reader client.Reader, namespace string) (*Settings, error) {
+	settings := s.Base
+	global, err := get(ctx, reader, s.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if global != nil {
+		settings.merge(&global.Spec, true)
+	}
+	s.apply(ctx, &settings)
+	if namespace != s.Namespace {
+		local, err := get(ctx, reader, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if local != nil {
+			settings.merge(&local.Spec, false)
+		}
+	}
+	return &settings, nil
+}
+
+// apply applies the operator-wide settings to the limiter and reports them
+// if they changed.
+func (s *Store) apply(ctx context.Context, settings *Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.applied
+	if current == nil {
+		current = &s.Base
+	}
+	if s.applied != nil && operatorWideEqual(current, settings) {
+		return
+	}
+	if s.Limiter != nil {
+		s.Limiter.SetLimits(settings.ProviderConcurrency)
+	}
+	applied := *settings
+	s.applied = &applied
+	if operatorWideEqual(current, settings) {
+		return
+	}
+	log.FromContext(ctx).Info("Applied operator-wide settings",
+		"nodeImage", settings.Images.Node,
+		"metricsProxyImage", settings.Images.MetricsProxy,
+		"clusterDomain", settings.ClusterDomain,
+		"providerConcurrency", settings.ProviderConcurrency)
+	if s.OnChange != nil {
+		s.OnChange(ctx, &applied)
+	}
+}
+
+// get returns the config in the given namespace, or nil if there is none.
+// Invalid configs are ignored, so they cannot stop every reconcile in their
+// namespace.
+func get(ctx context.Context, reader client.Reader, namespace string) (*meshv1.WebmeshOperatorConfig, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+	var config meshv1.WebmeshOperatorConfig
+	err := reader.Get(ctx, client.ObjectKey{Name: meshv1.OperatorConfigName, Namespace: namespace}, &config)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get operator config: %w", err)
+	}
+	if err := config.Spec.Validate(); err != nil {
+		log.FromContext(ctx).V(1).Info("Ignoring invalid operator config", "namespace", namespace, "error", err.Error())
+		return nil, nil
+	}
+	return &config, nil
+}
+
+type settingsKey struct{}
+
+// WithSettings returns a context carrying the settings of a reconcile.
+func WithSettings(ctx context.Context, settings *Settings) context.Context {
+	return context.WithValue(ctx, settingsKey{}, settings)
+}
+
+// FromContext returns the settings of the reconcile, or the defaults if the
+// context carries none.
+func FromContext(ctx context.Context) *Settings {
+	if settings, ok := ctx.Value(settingsKey{}).(*Settings); ok {
+		return settings
+	}
+	return Defaults()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operatorconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/fairness"
+)
+
+func TestSnapshot(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	config := func(namespace string, spec meshv1.WebmeshOperatorConfigSpec) client.Object {
+		return &meshv1.WebmeshOperatorConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: meshv1.OperatorConfigName, Namespace: namespace},
+			Spec:       spec,
+		}
+	}
+	skipProbe := true
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		config("webmesh-system", meshv1.WebmeshOperatorConfigSpec{
+			Images:              &meshv1.Images{Node: "registry.example.com/node:v1"},
+			ClusterDomain:       "example.internal.",
+			ProviderConcurrency: map[string]int32{"google": 2},
+			LoadBalancerClass:   "example.com/lb",
+		}),
+		config("team-a", meshv1.WebmeshOperatorConfigSpec{
+			// Operator-wide settings are ignored outside of the
+			// operator's namespace
+			ClusterDomain:       "team-a.internal",
+			MaxRetryBackoff:     &metav1.Duration{Duration: time.Minute},
+			SkipJoinServerProbe: &skipProbe,
+		}),
+		config("team-b", meshv1.WebmeshOperatorConfigSpec{
+			MaxRetryBackoff: &metav1.Duration{Duration: -time.Minute},
+		}),
+	).Build()
+
+	var changes int
+	store := &Store{
+		Reader:    cli,
+		Namespace: "webmesh-system",
+		Base: Settings{
+			Images:              meshv1.DefaultImages(),
+			ClusterDomain:       meshv1.DefaultClusterDomain,
+			ProviderConcurrency: map[string]int{"aws": 1},
+			MaxRetryBackoff:     DefaultMaxRetryBackoff,
+		},
+		Limiter:  fairness.NewLimiter(nil),
+		OnChange: func(context.Context, *Settings) { changes++ },
+	}
+	ctx := context.Background()
+
+	settings, err := store.Snapshot(ctx, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Images.Node != "registry.example.com/node:v1" {
+		t.Errorf("expected the node image of the operator config, got %s", settings.Images.Node)
+	}
+	if settings.Images.MetricsProxy != meshv1.DefaultMetricsProxyImage {
+		t.Errorf("expected the default metrics proxy image to be kept, got %s", settings.Images.MetricsProxy)
+	}
+	if settings.ClusterDomain != "example.internal" {
+		t.Errorf("expected the cluster domain of the operator config, got %s", settings.ClusterDomain)
+	}
+	if settings.ProviderConcurrency["google"] != 2 || settings.ProviderConcurrency["aws"] != 1 {
+		t.Errorf("expected the provider concurrency to be merged with the flags, got %v", settings.ProviderConcurrency)
+	}
+	if settings.MaxRetryBackoff != time.Minute {
+		t.Errorf("expected the namespace to override the max retry backoff, got %s", settings.MaxRetryBackoff)
+	}
+	if settings.LoadBalancerClass != "example.com/lb" {
+		t.Errorf("expected the load balancer class of the operator config, got %s", settings.LoadBalancerClass)
+	}
+	if !settings.SkipJoinServerProbe {
+		t.Error("expected the namespace to skip join server probes")
+	}
+
+	// The operator-wide settings are only carried by snapshots and leave
+	// the package level defaults alone
+	if got := meshv1.DefaultImages().Node; got != meshv1.DefaultNodeImage {
+		t.Errorf("expected the built-in node image to be kept, got %s", got)
+	}
+	images, err := store.Images(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images != settings.Images {
+		t.Errorf("expected the images of the snapshot for webhooks, got %+v", images)
+	}
+	if changes != 1 {
+		t.Errorf("expected one change to be reported, got %d", changes)
+	}
+
+	// Invalid configs are ignored
+	settings, err = store.Snapshot(ctx, "team-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxRetryBackoff != DefaultMaxRetryBackoff {
+		t.Errorf("expected an invalid config to be ignored, got a max retry backoff of %s", settings.MaxRetryBackoff)
+	}
+	if changes != 1 {
+		t.Errorf("expected unchanged settings not to be reported, got %d changes", changes)
+	}
+
+	// Removing the operator config restores the flags
+	if err := cli.Delete(ctx, config("webmesh-system", meshv1.WebmeshOperatorConfigSpec{})); err != nil {
+		t.Fatal(err)
+	}
+	settings, err = store.Snapshot(ctx, "team-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ClusterDomain != meshv1.DefaultClusterDomain {
+		t.Errorf("expected the cluster domain of the flags to be restored, got %s", settings.ClusterDomain)
+	}
+	if settings.LoadBalancerClass != "" {
+		t.Errorf("expected no load balancer class, got %s", settings.LoadBalancerClass)
+	}
+	if changes != 2 {
+		t.Errorf("expected the restored settings to be reported, got %d changes", changes)
+	}
+}
+
+func TestSnapshotNilStore(t *testing.T) {
+	var store *Store
+	settings, err := store.Snapshot(context.Background(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxRetryBackoff != DefaultMaxRetryBackoff || settings.ClusterDomain != meshv1.DefaultClusterDomain {
+		t.Errorf("expected the defaults, got %+v", settings)
+	}
+	if images, err := store.Images(context.Background()); err != nil || images != meshv1.DefaultImages() {
+		t.Errorf("expected the default images, got %+v (%v)", images, err)
+	}
+	if got := FromContext(WithSettings(context.Background(), &Settings{LoadBalancerClass: "lb"})); got.LoadBalancerClass != "lb" {
+		t.Errorf("expected the settings of the context, got %+v", got)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+// OperatorConfigReconciler applies changes to the operator-wide settings as
+// soon as the config in the operator's namespace changes, even when no objects
+// are reconciled in response. Invalid configs are ignored, and reported
+// through events.
+type OperatorConfigReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Settings resolves the operator settings.
+	Settings *operatorconfig.Store
+}
+
+//+kubebuilder:rbac:groups=mesh.webmesh.io,resources=webmeshoperatorconfigs,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile validates the config and applies the operator-wide settings.
+func (r *OperatorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if req.Name != meshv1.OperatorConfigName {
+		return ctrl.Result{}, nil
+	}
+	var config meshv1.WebmeshOperatorConfig
+	err := r.Get(ctx, req.NamespacedName, &config)
+	if client.IgnoreNotFound(err) != nil {
+		log.Error(err, "unable to fetch WebmeshOperatorConfig")
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		if err := config.Spec.Validate(); err != nil {
+			log.Info("Ignoring invalid operator config", "error", err.Error())
+			r.Recorder.Eventf(&config, corev1.EventTypeWarning, "InvalidConfig", "The config is ignored: %v", err)
+		}
+	}
+	if _, err := r.Settings.Snapshot(ctx, req.Namespace); err != nil {
+		log.Error(err, "unable to apply operator settings")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *OperatorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&meshv1.WebmeshOperatorConfig{}).
+		Complete(r)
+}
+
+// operatorConfigToObjects returns a map function resyncing the objects of the
+// kind of the given list that a changed operator config applies to. A change
+// to the config in the operator's namespace resyncs every object.
+func operatorConfigToObjects(cli client.Client, store *operatorconfig.Store, list client.ObjectList) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		if obj.GetName() != meshv1.OperatorConfigName {
+			return nil
+		}
+		var opts []client.ListOption
+		if store == nil || obj.GetNamespace() != store.Namespace {
+			opts = append(opts, client.InNamespace(obj.GetNamespace()))
+		}
+		objects := list.DeepCopyObject().(client.ObjectList)
+		if err := cli.List(ctx, objects, opts...); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list objects for operator config")
+			return nil
+		}
+		items, err := meta.ExtractList(objects)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to extract objects for operator config")
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(items))
+		for _, item := range items {
+			if o, ok := item.(client.Object); ok {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(o)})
+			}
+		}
+		return requests
+	}
+}
+
+// setLoadBalancerClass sets the load balancer class of the operator settings
+// on a LoadBalancer service that does not exist yet. The class of a service
+// cannot change, so existing services keep theirs.
+func setLoadBalancerClass(ctx context.Context, cli client.Client, svc *corev1.Service) error {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil
+	}
+	var current corev1.Service
+	err := cli.Get(ctx, client.ObjectKeyFromObject(svc), &current)
+	switch {
+	case err == nil:
+		svc.Spec.LoadBalancerClass = current.Spec.LoadBalancerClass
+	case apierrors.IsNotFound(err):
+		if class := operatorconfig.FromContext(ctx).LoadBalancerClass; class != "" {
+			svc.Spec.LoadBalancerClass = &class
+		}
+	default:
+		return fmt.Errorf("get load balancer service: %w", err)
+	}
+	return nil
+}
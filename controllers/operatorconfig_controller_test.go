@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+func TestSetLoadBalancerClass(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	service := func(name string, typ corev1.ServiceType, class *string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Type: typ, LoadBalancerClass: class},
+		}
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		service("existing", corev1.ServiceTypeLoadBalancer, pointer("example.com/old")),
+		service("unclassed", corev1.ServiceTypeLoadBalancer, nil),
+	).Build()
+	ctx := operatorconfig.WithSettings(context.Background(), &operatorconfig.Settings{LoadBalancerClass: "example.com/new"})
+
+	tc := []struct {
+		name string
+		svc  *corev1.Service
+		want *string
+	}{
+		{name: "new service", svc: service("new", corev1.ServiceTypeLoadBalancer, nil), want: pointer("example.com/new")},
+		{name: "existing service", svc: service("existing", corev1.ServiceTypeLoadBalancer, nil), want: pointer("example.com/old")},
+		{name: "existing service without a class", svc: service("unclassed", corev1.ServiceTypeLoadBalancer, nil)},
+		{name: "cluster IP service", svc: service("internal", corev1.ServiceTypeClusterIP, nil)},
+	}
+	for _, c := range tc {
+		t.Run(c.name, func(t *testing.T) {
+			if err := setLoadBalancerClass(ctx, cli, c.svc); err != nil {
+				t.Fatal(err)
+			}
+			got := c.svc.Spec.LoadBalancerClass
+			if (got == nil) != (c.want == nil) || (got != nil && *got != *c.want) {
+				t.Errorf("expected class %v, got %v", c.want, got)
+			}
+		})
+	}
+}
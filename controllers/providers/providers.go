@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package providers contains the interface through which node groups are
+// deployed.
+package providers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// Names of the providers. They are the names of the deployment fields of
+// the NodeGroupSpec.
+const (
+	// Cluster deploys node groups to a Kubernetes cluster.
+	Cluster = "cluster"
+	// GoogleCloud deploys node groups to Google Cloud instances.
+	GoogleCloud = "googleCloud"
+	// AWS deploys node groups to EC2 instances.
+	AWS = "aws"
+	// Azure deploys node groups to Azure virtual machines.
+	Azure = "azure"
+	// DigitalOcean deploys node groups to DigitalOcean droplets.
+	DigitalOcean = "digitalOcean"
+	// Hetzner deploys node groups to Hetzner Cloud servers.
+	Hetzner = "hetzner"
+	// BareMetal provisions node groups on bare metal hosts over SSH.
+	BareMetal = "bareMetal"
+)
+
+// Provider deploys the nodes of a node group.
+type Provider interface {
+	// Reconcile creates or updates the nodes of the group.
+	Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error)
+	// Delete removes the nodes of the group.
+	Delete(ctx context.Context, group *meshv1.NodeGroup) error
+}
+
+// Registry holds the providers by name.
+type Registry map[string]Provider
+
+// Name returns the name of the provider of the group, taken from the
+// populated deployment field of its spec.
+func Name(group *meshv1.NodeGroup) (string, error) {
+	names := group.Spec.Providers()
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no deployment configuration provided")
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("multiple deployment configurations provided: %s", strings.Join(names, ", "))
+	}
+}
+
+// For returns the provider of the group.
+func (r Registry) For(group *meshv1.NodeGroup) (Provider, error) {
+	name, err := Name(group)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("no provider registered for %s", name)
+	}
+	return provider, nil
+}
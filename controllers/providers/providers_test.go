@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"testing"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+type fakeProvider struct {
+	name string
+}
+
+func (f *fakeProvider) Reconcile(ctx context.Context, mesh *meshv1.Mesh, group *meshv1.NodeGroup) (ctrl.Result, error) {
+	return ctrl.Result{}, nil
+}
+
+func (f *fakeProvider) Delete(ctx context.Context, group *meshv1.NodeGroup) error {
+	return nil
+}
+
+func TestRegistryFor(t *testing.T) {
+	registry := Registry{
+		Cluster:     &fakeProvider{name: Cluster},
+		GoogleCloud: &fakeProvider{name: GoogleCloud},
+	}
+	tc := []struct {
+		name string
+		spec meshv1.NodeGroupSpec
+		want string
+		err  bool
+	}{
+		{
+			name: "cluster",
+			spec: meshv1.NodeGroupSpec{Cluster: &meshv1.NodeGroupClusterConfig{}},
+			want: Cluster,
+		},
+		{
+			name: "google cloud",
+			spec: meshv1.NodeGroupSpec{GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{}},
+			want: GoogleCloud,
+		},
+		{
+			name: "not registered",
+			spec: meshv1.NodeGroupSpec{Hetzner: &meshv1.NodeGroupHetznerConfig{}},
+			err:  true,
+		},
+		{
+			name: "no deployment",
+			err:  true,
+		},
+		{
+			name: "multiple deployments",
+			spec: meshv1.NodeGroupSpec{
+				Cluster:     &meshv1.NodeGroupClusterConfig{},
+				GoogleCloud: &meshv1.NodeGroupGoogleCloudConfig{},
+			},
+			err: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := registry.For(&meshv1.NodeGroup{Spec: tt.spec})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := provider.(*fakeProvider).name; got != tt.want {
+				t.Errorf("expected provider %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+// maxLastErrorLength is the length errors are truncated to when they are
+// recorded in the status of an object.
+const maxLastErrorLength = 1024
+
+// reconcileStatusInterval is how often the reconcile time of an object is
+// refreshed while the outcome of its reconciles does not change. Each status
+// write triggers another reconcile, so writing on every one would never settle.
+const reconcileStatusInterval = time.Minute
+
+// recordReconcile records the outcome of a reconcile in the status of the
+// object. While the outcome is unchanged the times are only refreshed every
+// reconcileStatusInterval. Conflicting writes are retried against the latest version of the
+// object. A failure to record the outcome of a failed reconcile is only
+// logged, so the original error is what gets retried.
+func recordReconcile(ctx context.Context, cli client.Client, obj client.Object, status *meshv1.ReconcileStatus, res ctrl.Result, err error) (ctrl.Result, error) {
+	reconciled := metav1.Now()
+	var lastError string
+	if err != nil {
+		lastError = truncateError(err.Error(), maxLastErrorLength)
+	}
+	if status.LastReconcileTime != nil && status.LastError == lastError &&
+		(err != nil || status.LastSuccessfulReconcileTime != nil) &&
+		reconciled.Sub(status.LastReconcileTime.Time) < reconcileStatusInterval {
+		return res, err
+	}
+	first := true
+	werr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !first {
+			// The status pointer refers to a field of obj, so fetching the
+			// latest version refreshes it as well
+			if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+				return err
+			}
+		}
+		first = false
+		status.LastReconcileTime = &reconciled
+		status.LastError = lastError
+		if err == nil {
+			status.LastSuccessfulReconcileTime = &reconciled
+		}
+		return cli.Status().Update(ctx, obj)
+	})
+	if werr == nil {
+		return res, err
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(werr, "unable to record reconcile error in status")
+		return res, err
+	}
+	return res, fmt.Errorf("record reconcile status: %w", werr)
+}
+
+// truncateError truncates the message to at most max bytes without
+// splitting a rune.
+func truncateError(msg string, max int) string {
+	const ellipsis = "..."
+	if len(msg) <= max {
+		return msg
+	}
+	msg = msg[:max-len(ellipsis)]
+	for len(msg) > 0 && !utf8.ValidString(msg) {
+		msg = msg[:len(msg)-1]
+	}
+	return msg + ellipsis
+}
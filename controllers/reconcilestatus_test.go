@@ -0,0 +1,195 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestRecordReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := meshv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	newClient := func() (client.Client, *meshv1.NodeGroup) {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", Generation: 1},
+		}
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(group).
+			WithStatusSubresource(group).
+			Build()
+		var got meshv1.NodeGroup
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(group), &got); err != nil {
+			t.Fatal(err)
+		}
+		return cli, &got
+	}
+	get := func(t *testing.T, cli client.Client) *meshv1.NodeGroup {
+		t.Helper()
+		var got meshv1.NodeGroup
+		if err := cli.Get(ctx, client.ObjectKey{Name: "group", Namespace: "default"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		return &got
+	}
+
+	t.Run("success clears the last error", func(t *testing.T) {
+		cli, group := newClient()
+		group.Status.LastError = "previous failure"
+		group.Status.LastReconcileTime = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+		if _, err := recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := get(t, cli)
+		if got.Status.LastError != "" {
+			t.Errorf("expected the last error to be cleared, got %q", got.Status.LastError)
+		}
+		if got.Status.LastReconcileTime == nil || got.Status.LastSuccessfulReconcileTime == nil {
+			t.Errorf("expected the reconcile times to be set, got %+v", got.Status.ReconcileStatus)
+		}
+	})
+
+	t.Run("transient errors are recorded and returned", func(t *testing.T) {
+		cli, group := newClient()
+		succeeded := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+		group.Status.LastSuccessfulReconcileTime = &succeeded
+		rerr := errors.New("create instance: googleapi: Error 503: backend unavailable")
+		_, err := recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, rerr)
+		if !errors.Is(err, rerr) {
+			t.Fatalf("expected the reconcile error to be returned, got %v", err)
+		}
+		got := get(t, cli)
+		if got.Status.LastError != rerr.Error() {
+			t.Errorf("expected last error %q, got %q", rerr.Error(), got.Status.LastError)
+		}
+		if got.Status.LastReconcileTime == nil {
+			t.Error("expected the last reconcile time to be set")
+		}
+		if got.Status.LastSuccessfulReconcileTime == nil || !got.Status.LastSuccessfulReconcileTime.Equal(&succeeded) {
+			t.Errorf("expected the last successful reconcile time to be kept, got %v", got.Status.LastSuccessfulReconcileTime)
+		}
+	})
+
+	t.Run("permanent errors are recorded before they are surfaced", func(t *testing.T) {
+		cli, group := newClient()
+		rerr := apierrors.NewForbidden(schema.GroupResource{Resource: "clusterissuers"}, "mesh", errors.New("restricted"))
+		res, err := recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, rerr)
+		res, err = reconcileRestricted(ctx, cli, group, &group.Status.Conditions, res, err)
+		if err != nil {
+			t.Fatalf("expected forbidden errors to be surfaced in the status, got %v", err)
+		}
+		if res.RequeueAfter != restrictedRequeueInterval {
+			t.Errorf("expected requeue after %v, got %v", restrictedRequeueInterval, res.RequeueAfter)
+		}
+		got := get(t, cli)
+		if got.Status.LastError != rerr.Error() {
+			t.Errorf("expected last error %q, got %q", rerr.Error(), got.Status.LastError)
+		}
+		if got.Status.LastSuccessfulReconcileTime != nil {
+			t.Errorf("expected no successful reconcile, got %v", got.Status.LastSuccessfulReconcileTime)
+		}
+	})
+
+	t.Run("conflicts are retried", func(t *testing.T) {
+		group := &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default", Generation: 1},
+		}
+		var writes int
+		cli := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(group).
+			WithStatusSubresource(group).
+			WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceUpdate: func(ctx context.Context, cli client.Client, subResource string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+					writes++
+					if writes == 1 {
+						// Another writer updated the status first
+						var latest meshv1.NodeGroup
+						if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), &latest); err != nil {
+							return err
+						}
+						latest.Status.DefaultGateway = true
+						if err := cli.SubResource(subResource).Update(ctx, &latest, opts...); err != nil {
+							return err
+						}
+						return apierrors.NewConflict(schema.GroupResource{Resource: "nodegroups"}, obj.GetName(), errors.New("object was modified"))
+					}
+					return cli.SubResource(subResource).Update(ctx, obj, opts...)
+				},
+			}).
+			Build()
+		if _, err := recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, errors.New("failed")); err == nil {
+			t.Fatal("expected the reconcile error to be returned")
+		}
+		got := get(t, cli)
+		if got.Status.LastError != "failed" {
+			t.Errorf("expected the error to be recorded, got %q", got.Status.LastError)
+		}
+		if !got.Status.DefaultGateway {
+			t.Error("expected the newer status to be kept")
+		}
+	})
+
+	t.Run("unchanged outcomes are not rewritten", func(t *testing.T) {
+		cli, group := newClient()
+		if _, err := recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		version := get(t, cli).GetResourceVersion()
+		if _, err := recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := get(t, cli).GetResourceVersion(); got != version {
+			t.Errorf("expected no status write, resource version changed from %s to %s", version, got)
+		}
+	})
+
+	t.Run("long errors are truncated", func(t *testing.T) {
+		cli, group := newClient()
+		rerr := errors.New(strings.Repeat("é", maxLastErrorLength))
+		_, _ = recordReconcile(ctx, cli, group, &group.Status.ReconcileStatus, ctrl.Result{}, rerr)
+		got := get(t, cli).Status.LastError
+		if len(got) > maxLastErrorLength || !strings.HasSuffix(got, "...") {
+			t.Errorf("expected the error to be truncated to %d bytes, got %d", maxLastErrorLength, len(got))
+		}
+		if !strings.HasPrefix(rerr.Error(), strings.TrimSuffix(got, "...")) {
+			t.Error("expected the truncated error to be a prefix of the error")
+		}
+	})
+}
@@ -19,19 +19,191 @@ package resources
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
 
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	v1 "github.com/webmeshproj/operator/api/v1"
 )
 
-// Apply applies the given resources to the cluster.
+// ErrApplyBudgetExhausted is returned by Apply when the apply budget of the
+// context is used up. Objects after the one that exhausted it are not applied.
+var ErrApplyBudgetExhausted = errors.New("apply budget exhausted")
+
+var appliedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webmesh_apply_objects_total",
+	Help: "The number of objects passed to apply by result: applied, simulated, unchanged or deferred.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(appliedObjects)
+}
+
+// appliedVersions are the versions objects were left at by the last apply of
+// this process, by appliedKey. Objects changed since then by anyone else are
+// applied again even when their checksum is unchanged. Entries are removed
+// when the object or its owner is found to be deleted.
+var appliedVersions sync.Map
+
+// appliedVersion is an entry of appliedVersions.
+type appliedVersion struct {
+	// fingerprint is the objectFingerprint of the object after the apply.
+	fingerprint string
+	// owners are the ownerKeys of the owners of the object.
+	owners []string
+}
+
+// appliedKey returns the key of an object in appliedVersions. Objects of
+// other clusters are keyed by the cluster of their client.
+func appliedKey(cli client.Client, obj client.Object) string {
+	var cluster string
+	if c, ok := cli.(*clusterClient); ok {
+		cluster = c.cluster
+	}
+	return fmt.Sprintf("%s/%T/%s/%s", cluster, obj, obj.GetNamespace(), obj.GetName())
+}
+
+// ownerKey returns the key of an owner of kind in appliedVersion.owners.
+func ownerKey(kind string, key types.NamespacedName) string {
+	return kind + "/" + key.String()
+}
+
+// clusterClient is the client of a cluster other than the one the operator
+// runs in.
+type clusterClient struct {
+	client.Client
+	cluster string
+}
+
+// ForCluster returns a client that applies objects to the given cluster, so
+// that the applied versions of its objects are tracked apart from those of
+// other clusters. The cluster is identified by the address of its API server.
+func ForCluster(cli client.Client, cluster string) client.Client {
+	return &clusterClient{Client: cli, cluster: cluster}
+}
+
+// Forget removes an object from the applied versions. It is called after the
+// object is deleted.
+func Forget(cli client.Client, obj client.Object) {
+	appliedVersions.Delete(appliedKey(cli, obj))
+}
+
+// ForgetOwned removes the objects owned by the object of the given kind from
+// the applied versions. It is called once the owner is deleted, as the owned
+// objects are garbage collected without the operator deleting them.
+func ForgetOwned(kind string, owner types.NamespacedName) {
+	key := ownerKey(kind, owner)
+	appliedVersions.Range(func(k, v any) bool {
+		for _, o := range v.(appliedVersion).owners {
+			if o == key {
+				appliedVersions.Delete(k)
+				break
+			}
+		}
+		return true
+	})
+}
+
+// objectFingerprint returns what identifies the version of an object for
+// drift detection. Writes to the status of an object do not change it, so
+// objects whose status is written by others are still skipped. Changes to
+// the rest of the object show in its generation or in the fields managed by
+// each writer. When the server records neither, the resource version is used.
+func objectFingerprint(obj client.Object) string {
+	var managed []metav1.ManagedFieldsEntry
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Subresource == "" {
+			managed = append(managed, entry)
+		}
+	}
+	if obj.GetGeneration() == 0 && len(managed) == 0 {
+		return "resourceVersion/" + obj.GetResourceVersion()
+	}
+	data, err := json.Marshal(managed)
+	if err != nil {
+		return "resourceVersion/" + obj.GetResourceVersion()
+	}
+	return fmt.Sprintf("generation/%d/%x", obj.GetGeneration(), sha256.Sum256(data))
+}
+
+type applyBudgetKey struct{}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context in which changes are only simulated. Apply
+// patches objects with a server-side dry run, and controllers log the calls
+// they would make to cloud providers instead of making them.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun returns true if changes are only simulated in the given context.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// WithApplyBudget returns a context that limits the number of objects Apply
+// patches to n. Unchanged objects do not count against the budget. A budget
+// of zero or less is unlimited.
+func WithApplyBudget(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	budget := &atomic.Int64{}
+	budget.Store(int64(n))
+	return context.WithValue(ctx, applyBudgetKey{}, budget)
+}
+
+// Apply applies the given resources to the cluster. The checksum of each object
+// is recorded in an annotation, and objects whose checksum matches the one last
+// applied are skipped as long as nobody changed them since. Objects that
+// drifted, and every object after a restart of the operator, are applied in
+// full. When the context is a dry run, objects are patched with a server-side
+// dry run and nothing is persisted.
 func Apply(ctx context.Context, cli client.Client, resources []client.Object) error {
-	for _, obj := range resources {
-		log.FromContext(ctx).Info("Applying object", "kind", obj.GetObjectKind().GroupVersionKind().Kind, "name", obj.GetName())
-		if err := cli.Patch(ctx, obj, client.Apply, client.ForceOwnership, client.FieldOwner(v1.FieldOwner)); err != nil {
+	budget, _ := ctx.Value(applyBudgetKey{}).(*atomic.Int64)
+	opts := []client.PatchOption{client.ForceOwnership, client.FieldOwner(v1.FieldOwner)}
+	result := "applied"
+	if IsDryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+		result = "simulated"
+	}
+	for i, obj := range resources {
+		checksum, err := objectChecksum(obj)
+		if err != nil {
+			return fmt.Errorf("checksum %s/%s: %w", obj.GetNamespace(), obj.GetName(), err)
+		}
+		if appliedUnchanged(ctx, cli, obj, checksum) {
+			appliedObjects.WithLabelValues("unchanged").Inc()
+			continue
+		}
+		if budget != nil && budget.Add(-1) < 0 {
+			appliedObjects.WithLabelValues("deferred").Add(float64(len(resources) - i))
+			return ErrApplyBudgetExhausted
+		}
+		// Builders may share annotation maps with their owners, copy before
+		// recording the checksum
+		annotations := make(map[string]string, len(obj.GetAnnotations())+1)
+		for k, v := range obj.GetAnnotations() {
+			annotations[k] = v
+		}
+		annotations[v1.SpecChecksumAnnotation] = checksum
+		obj.SetAnnotations(annotations)
+		log.FromContext(ctx).Info("Applying object", "kind", obj.GetObjectKind().GroupVersionKind().Kind, "name", obj.GetName(), "dryRun", IsDryRun(ctx))
+		if err := cli.Patch(ctx, obj, client.Apply, opts...); err != nil {
 			return fmt.Errorf("failed to apply %s/%s/%s: %w",
 				obj.GetObjectKind().GroupVersionKind().Kind,
 				obj.GetNamespace(),
@@ -39,10 +211,63 @@ func Apply(ctx context.Context, cli client.Client, resources []client.Object) er
 				err,
 			)
 		}
+		if !IsDryRun(ctx) {
+			owners := make([]string, 0, len(obj.GetOwnerReferences()))
+			for _, ref := range obj.GetOwnerReferences() {
+				owners = append(owners, ownerKey(ref.Kind, types.NamespacedName{Namespace: obj.GetNamespace(), Name: ref.Name}))
+			}
+			appliedVersions.Store(appliedKey(cli, obj), appliedVersion{
+				fingerprint: objectFingerprint(obj),
+				owners:      owners,
+			})
+		}
+		appliedObjects.WithLabelValues(result).Inc()
 	}
 	return nil
 }
 
+// objectChecksum returns the checksum of the desired state of an object,
+// excluding any previously recorded checksum.
+func objectChecksum(obj client.Object) (string, error) {
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[v1.SpecChecksumAnnotation]; ok {
+		copied := make(map[string]string, len(annotations))
+		for k, v := range annotations {
+			copied[k] = v
+		}
+		delete(copied, v1.SpecChecksumAnnotation)
+		obj = obj.DeepCopyObject().(client.Object)
+		obj.SetAnnotations(copied)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// appliedUnchanged returns true if the current version of the object was
+// applied with the given checksum by this process and was not changed since.
+// Objects found to be deleted are forgotten.
+func appliedUnchanged(ctx context.Context, cli client.Client, obj client.Object, checksum string) bool {
+	applied, ok := appliedVersions.Load(appliedKey(cli, obj))
+	if !ok {
+		return false
+	}
+	current, ok := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(client.Object)
+	if !ok {
+		return false
+	}
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		if apierrors.IsNotFound(err) {
+			Forget(cli, obj)
+		}
+		return false
+	}
+	return current.GetAnnotations()[v1.SpecChecksumAnnotation] == checksum &&
+		objectFingerprint(current) == applied.(appliedVersion).fingerprint
+}
+
 // Pointer returns a pointer to the given value.
 func Pointer[T any](v T) *T {
 	return &v
@@ -0,0 +1,225 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestApplySkipsUnchangedObjects(t *testing.T) {
+	newConfigMap := func(name, value string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string]string{"key": value},
+		}
+	}
+	unchanged := newConfigMap("unchanged", "value")
+	checksum, err := objectChecksum(unchanged)
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	existing := newConfigMap("unchanged", "value")
+	existing.SetAnnotations(map[string]string{meshv1.SpecChecksumAnnotation: checksum})
+	stale := newConfigMap("changed", "old")
+	stale.SetAnnotations(map[string]string{meshv1.SpecChecksumAnnotation: "stale"})
+
+	var patched []string
+	cli := fake.NewClientBuilder().
+		WithObjects(existing, stale).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, cli client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patched = append(patched, obj.GetName())
+				return nil
+			},
+		}).
+		Build()
+	// The unchanged object was left as is by an earlier apply
+	if err := cli.Get(context.Background(), client.ObjectKeyFromObject(existing), existing); err != nil {
+		t.Fatalf("get existing: %v", err)
+	}
+	appliedVersions.Store(appliedKey(cli, existing), appliedVersion{fingerprint: objectFingerprint(existing)})
+	defer Forget(cli, existing)
+
+	tc := []struct {
+		name    string
+		budget  int
+		objects []client.Object
+		patched []string
+		err     error
+	}{
+		{
+			name:    "unchanged objects are skipped",
+			objects: []client.Object{newConfigMap("unchanged", "value"), newConfigMap("changed", "new"), newConfigMap("new", "value")},
+			patched: []string{"changed", "new"},
+		},
+		{
+			name:    "budget defers remaining objects",
+			budget:  1,
+			objects: []client.Object{newConfigMap("unchanged", "value"), newConfigMap("changed", "new"), newConfigMap("new", "value")},
+			patched: []string{"changed"},
+			err:     ErrApplyBudgetExhausted,
+		},
+		{
+			name:    "unchanged objects do not use the budget",
+			budget:  1,
+			objects: []client.Object{newConfigMap("unchanged", "value"), newConfigMap("new", "value")},
+			patched: []string{"new"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			patched = nil
+			err := Apply(WithApplyBudget(context.Background(), tt.budget), cli, tt.objects)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if len(patched) != len(tt.patched) {
+				t.Fatalf("expected patched %v, got %v", tt.patched, patched)
+			}
+			for i := range patched {
+				if patched[i] != tt.patched[i] {
+					t.Fatalf("expected patched %v, got %v", tt.patched, patched)
+				}
+			}
+		})
+	}
+
+	// Objects changed by anyone else since are applied again to correct
+	// the drift
+	existing.Data["key"] = "edited"
+	if err := cli.Update(context.Background(), existing); err != nil {
+		t.Fatalf("update existing: %v", err)
+	}
+	patched = nil
+	if err := Apply(context.Background(), cli, []client.Object{newConfigMap("unchanged", "value")}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if len(patched) != 1 || patched[0] != "unchanged" {
+		t.Errorf("expected the drifted object to be applied, got %v", patched)
+	}
+}
+
+func TestApplyTracksVersions(t *testing.T) {
+	ctx := context.Background()
+	newService := func() *corev1.Service {
+		return &corev1.Service{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "service",
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "NodeGroup", Name: "group"}},
+			},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		}
+	}
+	existing := newService()
+	existing.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: meshv1.FieldOwner, Operation: metav1.ManagedFieldsOperationApply}}
+	var patched int
+	base := fake.NewClientBuilder().
+		WithObjects(existing).
+		WithStatusSubresource(existing).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, cli client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patched++
+				return cli.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+			},
+		}).
+		Build()
+	remote := ForCluster(base, "https://remote.example.com")
+	apply := func(cli client.Client) {
+		t.Helper()
+		if err := Apply(ctx, cli, []client.Object{newService()}); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	update := func(mutate func(*corev1.Service), status bool) {
+		t.Helper()
+		var svc corev1.Service
+		if err := base.Get(ctx, client.ObjectKeyFromObject(existing), &svc); err != nil {
+			t.Fatalf("get service: %v", err)
+		}
+		mutate(&svc)
+		var err error
+		if status {
+			err = base.Status().Update(ctx, &svc)
+		} else {
+			err = base.Update(ctx, &svc)
+		}
+		if err != nil {
+			t.Fatalf("update service: %v", err)
+		}
+	}
+	checksum, err := objectChecksum(newService())
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	update(func(svc *corev1.Service) {
+		svc.Annotations = map[string]string{meshv1.SpecChecksumAnnotation: checksum}
+	}, false)
+
+	apply(base)
+	apply(base)
+	if patched != 1 {
+		t.Fatalf("expected the second apply to be skipped, got %d patches", patched)
+	}
+
+	// The same object in another cluster is tracked on its own
+	apply(remote)
+	if patched != 2 {
+		t.Fatalf("expected the object of the other cluster to be applied, got %d patches", patched)
+	}
+	defer Forget(remote, existing)
+
+	// Status written by others is not drift
+	update(func(svc *corev1.Service) {
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	}, true)
+	apply(base)
+	if patched != 2 {
+		t.Errorf("expected a status change to be skipped, got %d patches", patched)
+	}
+
+	// Fields taken over by another writer are
+	update(func(svc *corev1.Service) {
+		svc.ManagedFields = append(svc.ManagedFields, metav1.ManagedFieldsEntry{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate})
+	}, false)
+	apply(base)
+	if patched != 3 {
+		t.Errorf("expected the changed object to be applied, got %d patches", patched)
+	}
+
+	// Objects are forgotten with their owner
+	ForgetOwned("NodeGroup", types.NamespacedName{Name: "group", Namespace: "default"})
+	if _, ok := appliedVersions.Load(appliedKey(base, existing)); ok {
+		t.Error("expected the object to be forgotten with its owner")
+	}
+	if _, ok := appliedVersions.Load(appliedKey(remote, existing)); ok {
+		t.Error("expected the object of the other cluster to be forgotten with its owner")
+	}
+}
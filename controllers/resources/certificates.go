@@ -79,8 +79,128 @@ func NewMeshAdminCertificate(mesh *meshv1.Mesh) *certv1.Certificate {
 	}
 }
 
-// NewNodeCertificate returns a new TLS certificate for a Mesh node.
-func NewNodeCertificate(mesh *meshv1.Mesh, nodeGroup *meshv1.NodeGroup, index int) *certv1.Certificate {
+// NewMeshManagerCertificate returns a new client certificate for the manager
+// config of a Mesh. It is granted the Manager role rather than full admin
+// access.
+func NewMeshManagerCertificate(mesh *meshv1.Mesh) *certv1.Certificate {
+	return &certv1.Certificate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: certv1.SchemeGroupVersion.String(),
+			Kind:       "Certificate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshManagerCertName(mesh),
+			Namespace:       mesh.GetNamespace(),
+			Labels:          meshv1.MeshLabels(mesh),
+			OwnerReferences: meshv1.OwnerReferences(mesh),
+		},
+		Spec: certv1.CertificateSpec{
+			CommonName: meshv1.MeshManagerCertName(mesh),
+			SecretName: meshv1.MeshManagerCertName(mesh),
+			Subject: &certv1.X509Subject{
+				Organizations: []string{string(meshv1.AccessProfileRoleManager)},
+			},
+			Usages: []certv1.KeyUsage{
+				certv1.UsageDigitalSignature,
+				certv1.UsageKeyEncipherment,
+				certv1.UsageClientAuth,
+			},
+			PrivateKey: &meshv1.DefaultTLSKeyConfig,
+			IssuerRef:  mesh.IssuerReference(),
+		},
+	}
+}
+
+// NewMeshAccessProfileCertificate returns a new client certificate for a Mesh
+// access profile. The profile's role is recorded in the organization of the
+// certificate subject.
+func NewMeshAccessProfileCertificate(mesh *meshv1.Mesh, profile *meshv1.AccessProfile) *certv1.Certificate {
+	return &certv1.Certificate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: certv1.SchemeGroupVersion.String(),
+			Kind:       "Certificate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshAccessProfileCertName(mesh, profile),
+			Namespace:       mesh.GetNamespace(),
+			Labels:          meshv1.MeshLabels(mesh),
+			OwnerReferences: meshv1.OwnerReferences(mesh),
+		},
+		Spec: certv1.CertificateSpec{
+			CommonName: meshv1.MeshAccessProfileCertName(mesh, profile),
+			SecretName: meshv1.MeshAccessProfileCertName(mesh, profile),
+			Subject: &certv1.X509Subject{
+				Organizations: []string{string(profile.Role)},
+			},
+			Usages: []certv1.KeyUsage{
+				certv1.UsageDigitalSignature,
+				certv1.UsageKeyEncipherment,
+				certv1.UsageClientAuth,
+			},
+			PrivateKey: &meshv1.DefaultTLSKeyConfig,
+			IssuerRef:  mesh.IssuerReference(),
+		},
+	}
+}
+
+// NewMeshAccessRequestCertificate returns a new client certificate for a
+// MeshAccessRequest. It is issued in the namespace of the Mesh, which owns it,
+// and is valid for the requested duration.
+func NewMeshAccessRequestCertificate(mesh *meshv1.Mesh, req *meshv1.MeshAccessRequest) *certv1.Certificate {
+	labels := meshv1.MeshAccessRequestLabels(req)
+	for k, v := range meshv1.SelectorLabels(meshv1.MeshSelector(mesh)) {
+		labels[k] = v
+	}
+	return &certv1.Certificate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: certv1.SchemeGroupVersion.String(),
+			Kind:       "Certificate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshAccessRequestCertName(mesh, req),
+			Namespace:       mesh.GetNamespace(),
+			Labels:          labels,
+			OwnerReferences: meshv1.OwnerReferences(mesh),
+		},
+		Spec: certv1.CertificateSpec{
+			CommonName: meshv1.MeshAccessRequestCertName(mesh, req),
+			SecretName: meshv1.MeshAccessRequestCertName(mesh, req),
+			Duration:   &metav1.Duration{Duration: req.Spec.Duration.Duration},
+			Subject: &certv1.X509Subject{
+				Organizations: []string{string(req.Spec.Role)},
+			},
+			Usages: []certv1.KeyUsage{
+				certv1.UsageDigitalSignature,
+				certv1.UsageKeyEncipherment,
+				certv1.UsageClientAuth,
+			},
+			PrivateKey: &meshv1.DefaultTLSKeyConfig,
+			IssuerRef:  mesh.IssuerReference(),
+		},
+	}
+}
+
+// NewNodeCertificate returns a new TLS certificate for a Mesh node in a
+// cluster with the given domain. Extra names configured for the group are
+// rendered for the node's index.
+func NewNodeCertificate(mesh *meshv1.Mesh, nodeGroup *meshv1.NodeGroup, index int, clusterDomain string) *certv1.Certificate {
+	dnsNames := meshv1.MeshNodeDNSNames(mesh, nodeGroup, index, clusterDomain)
+	var ipAddresses []string
+	if groupcfg, err := nodeGroup.MergedConfig(mesh); err == nil {
+		// Templates are validated by the webhooks, the extra names are
+		// left out if they fail to render.
+		if extra, err := groupcfg.Certificate.DNSNames(index); err == nil {
+			dnsNames = append(dnsNames, extra...)
+		}
+		if extra, err := groupcfg.Certificate.IPAddresses(index); err == nil && len(extra) > 0 {
+			ipAddresses = extra
+		}
+	}
+	// Clients reaching the nodes through the group's service verify
+	// its external names
+	lbDNSNames, lbIPAddresses := nodeGroup.LoadBalancerSANs()
+	dnsNames = append(dnsNames, lbDNSNames...)
+	ipAddresses = append(ipAddresses, lbIPAddresses...)
 	return &certv1.Certificate{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: certv1.SchemeGroupVersion.String(),
@@ -93,9 +213,47 @@ func NewNodeCertificate(mesh *meshv1.Mesh, nodeGroup *meshv1.NodeGroup, index in
 			OwnerReferences: meshv1.OwnerReferences(nodeGroup),
 		},
 		Spec: certv1.CertificateSpec{
-			CommonName: meshv1.MeshNodeHostname(mesh, nodeGroup, index),
-			SecretName: meshv1.MeshNodeCertName(mesh, nodeGroup, index),
-			DNSNames:   meshv1.MeshNodeDNSNames(mesh, nodeGroup, index),
+			CommonName:  meshv1.MeshNodeHostname(mesh, nodeGroup, index),
+			SecretName:  meshv1.MeshNodeCertName(mesh, nodeGroup, index),
+			DNSNames:    dnsNames,
+			IPAddresses: ipAddresses,
+			Usages: []certv1.KeyUsage{
+				certv1.UsageDigitalSignature,
+				certv1.UsageKeyEncipherment,
+				certv1.UsageServerAuth,
+				certv1.UsageClientAuth,
+			},
+			PrivateKey: &meshv1.DefaultTLSKeyConfig,
+			IssuerRef:  mesh.IssuerReference(),
+			// Label the secrets so the certificates of a group are listed
+			// at once
+			SecretTemplate: &certv1.CertificateSecretTemplate{
+				Labels: meshv1.NodeGroupSelector(mesh, nodeGroup),
+			},
+		},
+	}
+}
+
+// NewMeshPeeringCertificate returns a new TLS certificate for the bridge node of a
+// MeshPeering issued by the given Mesh. The bridge node presents it when joining
+// that Mesh. Its names are those of the bridge in a cluster with the given
+// domain.
+func NewMeshPeeringCertificate(peering *meshv1.MeshPeering, mesh *meshv1.Mesh, clusterDomain string) *certv1.Certificate {
+	return &certv1.Certificate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: certv1.SchemeGroupVersion.String(),
+			Kind:       "Certificate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshPeeringCertName(peering, mesh),
+			Namespace:       peering.GetNamespace(),
+			Labels:          meshv1.MeshPeeringLabels(peering),
+			OwnerReferences: meshv1.OwnerReferences(peering),
+		},
+		Spec: certv1.CertificateSpec{
+			CommonName: meshv1.MeshPeeringBridgeName(peering),
+			SecretName: meshv1.MeshPeeringCertName(peering, mesh),
+			DNSNames:   meshv1.MeshPeeringBridgeDNSNames(peering, clusterDomain),
 			Usages: []certv1.KeyUsage{
 				certv1.UsageDigitalSignature,
 				certv1.UsageKeyEncipherment,
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+func TestNodeCertificateExtraSANs(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: Pointer(int32(2)),
+			Cluster:  &meshv1.NodeGroupClusterConfig{},
+			Config: &meshv1.NodeGroupConfig{
+				Certificate: &meshv1.NodeCertificateConfig{
+					ExtraDNSNames:    []string{"vip.example.com", "node-{{ .Ordinal }}.example.com"},
+					ExtraIPAddresses: []string{"10.0.0.{{ .Ordinal }}"},
+				},
+			},
+		},
+	}
+	cert := NewNodeCertificate(mesh, group, 1, meshv1.DefaultClusterDomain)
+
+	dnsNames := make(map[string]bool)
+	for _, name := range cert.Spec.DNSNames {
+		dnsNames[name] = true
+	}
+	for _, name := range append(meshv1.MeshNodeDNSNames(mesh, group, 1, meshv1.DefaultClusterDomain), "vip.example.com", "node-1.example.com") {
+		if !dnsNames[name] {
+			t.Errorf("expected DNS name %q in certificate, got %v", name, cert.Spec.DNSNames)
+		}
+	}
+	if len(cert.Spec.IPAddresses) != 1 || cert.Spec.IPAddresses[0] != "10.0.0.1" {
+		t.Errorf("expected IP addresses [10.0.0.1], got %v", cert.Spec.IPAddresses)
+	}
+}
+
+func TestNodeCertificateLoadBalancerSANs(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: Pointer(int32(1)),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{
+					ExtraDNSNames:    []string{"mesh.example.com"},
+					ExtraIPAddresses: []string{"203.0.113.10", "198.51.100.1"},
+				},
+			},
+		},
+		Status: meshv1.NodeGroupStatus{
+			LoadBalancerAddresses: []string{"198.51.100.1", "lb.cloud.example.com"},
+		},
+	}
+	cert := NewNodeCertificate(mesh, group, 0, meshv1.DefaultClusterDomain)
+
+	dnsNames := make(map[string]bool)
+	for _, name := range cert.Spec.DNSNames {
+		dnsNames[name] = true
+	}
+	for _, name := range []string{"mesh.example.com", "lb.cloud.example.com"} {
+		if !dnsNames[name] {
+			t.Errorf("expected DNS name %q in certificate, got %v", name, cert.Spec.DNSNames)
+		}
+	}
+	want := []string{"198.51.100.1", "203.0.113.10"}
+	if len(cert.Spec.IPAddresses) != len(want) {
+		t.Fatalf("expected IP addresses %v, got %v", want, cert.Spec.IPAddresses)
+	}
+	for i, addr := range want {
+		if cert.Spec.IPAddresses[i] != addr {
+			t.Errorf("expected IP addresses %v, got %v", want, cert.Spec.IPAddresses)
+		}
+	}
+}
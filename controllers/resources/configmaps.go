@@ -26,10 +26,7 @@ import (
 
 // NewNodeGroupConfigMap returns a new ConfigMap for a NodeGroup.
 func NewNodeGroupConfigMap(mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nodeconfig.Config) (cm *corev1.ConfigMap) {
-	annotations := group.GetAnnotations()
-	if annotations == nil {
-		annotations = make(map[string]string)
-	}
+	annotations := meshv1.NodeGroupAnnotations(mesh, group)
 	annotations[meshv1.ConfigChecksumAnnotation] = conf.Checksum()
 	return &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
@@ -43,8 +40,113 @@ func NewNodeGroupConfigMap(mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nod
 			Annotations:     annotations,
 			OwnerReferences: meshv1.OwnerReferences(group),
 		},
+		Data: nodeGroupConfigData(conf),
+	}
+}
+
+// NewNodeGroupConfigSecret returns a new Secret holding the config for a NodeGroup.
+// It is used in place of the ConfigMap when the group stores its config in a Secret.
+func NewNodeGroupConfigSecret(mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nodeconfig.Config) *corev1.Secret {
+	annotations := meshv1.NodeGroupAnnotations(mesh, group)
+	annotations[meshv1.ConfigChecksumAnnotation] = conf.Checksum()
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshNodeGroupConfigMapName(mesh, group),
+			Namespace:       group.GetNamespace(),
+			Labels:          meshv1.NodeGroupLabels(mesh, group),
+			Annotations:     annotations,
+			OwnerReferences: meshv1.OwnerReferences(group),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: nodeGroupConfigSecretData(conf),
+	}
+}
+
+// nodeGroupConfigData returns the data of the ConfigMap holding the config of
+// a NodeGroup, including the configs rendered for single replicas.
+func nodeGroupConfigData(conf *nodeconfig.Config) map[string]string {
+	data := map[string]string{
+		meshv1.ConfigFileName: string(conf.Raw()),
+	}
+	for file, raw := range conf.ReplicaFiles() {
+		data[file] = string(raw)
+	}
+	return data
+}
+
+// nodeGroupConfigSecretData is the Secret counterpart of nodeGroupConfigData.
+func nodeGroupConfigSecretData(conf *nodeconfig.Config) map[string][]byte {
+	data := map[string][]byte{
+		meshv1.ConfigFileName: conf.Raw(),
+	}
+	for file, raw := range conf.ReplicaFiles() {
+		data[file] = raw
+	}
+	return data
+}
+
+// NewMeshPeeringConfigMap returns a new ConfigMap for the bridge node of a MeshPeering.
+func NewMeshPeeringConfigMap(peering *meshv1.MeshPeering, conf *nodeconfig.Config) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meshv1.MeshPeeringBridgeName(peering),
+			Namespace: peering.GetNamespace(),
+			Labels:    meshv1.MeshPeeringLabels(peering),
+			Annotations: map[string]string{
+				meshv1.ConfigChecksumAnnotation: conf.Checksum(),
+			},
+			OwnerReferences: meshv1.OwnerReferences(peering),
+		},
+		Data: map[string]string{
+			meshv1.ConfigFileName: string(conf.Raw()),
+		},
+	}
+}
+
+// NewMeshAccessRequestConfigSecret returns a new Secret holding the wmctl config
+// issued for a MeshAccessRequest. It is written to the namespace of the request.
+func NewMeshAccessRequestConfigSecret(req *meshv1.MeshAccessRequest, config []byte) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshAccessRequestConfigName(req),
+			Namespace:       req.GetNamespace(),
+			Labels:          meshv1.MeshAccessRequestLabels(req),
+			OwnerReferences: meshv1.OwnerReferences(req),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			meshv1.ConfigFileName: config,
+		},
+	}
+}
+
+// NewOperatorDefaultsConfigMap returns the ConfigMap publishing the effective
+// defaults of the operator in the given namespace.
+func NewOperatorDefaultsConfigMap(namespace string, images meshv1.Images) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meshv1.OperatorDefaultsConfigMapName,
+			Namespace: namespace,
+		},
 		Data: map[string]string{
-			"config.yaml": string(conf.Raw()),
+			"nodeImage":         images.Node,
+			"metricsProxyImage": images.MetricsProxy,
 		},
 	}
 }
@@ -0,0 +1,132 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+)
+
+const (
+	// EditorClusterRoleName is the name of the aggregated ClusterRole granting
+	// write access to the webmesh resources.
+	EditorClusterRoleName = "webmesh-editor"
+	// ViewerClusterRoleName is the name of the aggregated ClusterRole granting
+	// read access to the webmesh resources.
+	ViewerClusterRoleName = "webmesh-viewer"
+	// ApproverClusterRoleName is the name of the aggregated ClusterRole
+	// allowing access requests to be approved.
+	ApproverClusterRoleName = "webmesh-approver"
+)
+
+// meshResources are the resources served by the webmesh API group.
+var meshResources = []string{"meshes", "nodegroups", "meshpeerings", "meshaccessrequests"}
+
+// RenderAggregatedClusterRoles returns the ClusterRoles that aggregate access to
+// the webmesh resources into the built-in admin, edit and view roles. Binding
+// edit in a namespace is then enough for a team to manage its own node groups.
+func RenderAggregatedClusterRoles() []client.Object {
+	return []client.Object{
+		NewEditorClusterRole(),
+		NewViewerClusterRole(),
+		NewApproverClusterRole(),
+	}
+}
+
+// NewEditorClusterRole returns the ClusterRole granting write access to the
+// webmesh resources. It is aggregated into the admin and edit roles.
+func NewEditorClusterRole() *rbacv1.ClusterRole {
+	return newAggregatedClusterRole(EditorClusterRoleName, map[string]string{
+		"rbac.authorization.k8s.io/aggregate-to-admin": "true",
+		"rbac.authorization.k8s.io/aggregate-to-edit":  "true",
+	}, []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{meshv1.GroupVersion.Group},
+			Resources: meshResources,
+			Verbs:     []string{"create", "delete", "get", "list", "patch", "update", "watch"},
+		},
+		{
+			APIGroups: []string{meshv1.GroupVersion.Group},
+			Resources: statusResources(),
+			Verbs:     []string{"get"},
+		},
+	})
+}
+
+// NewViewerClusterRole returns the ClusterRole granting read access to the
+// webmesh resources. It is aggregated into the view role.
+func NewViewerClusterRole() *rbacv1.ClusterRole {
+	return newAggregatedClusterRole(ViewerClusterRoleName, map[string]string{
+		"rbac.authorization.k8s.io/aggregate-to-view": "true",
+	}, []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{meshv1.GroupVersion.Group},
+			Resources: append(append([]string{}, meshResources...), statusResources()...),
+			Verbs:     []string{"get", "list", "watch"},
+		},
+	})
+}
+
+// NewApproverClusterRole returns the ClusterRole allowing access requests for
+// the meshes in a namespace to be approved. It is aggregated into the admin
+// role only, so tenants that can edit a namespace cannot approve their own
+// requests.
+func NewApproverClusterRole() *rbacv1.ClusterRole {
+	return newAggregatedClusterRole(ApproverClusterRoleName, map[string]string{
+		"rbac.authorization.k8s.io/aggregate-to-admin": "true",
+	}, []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{meshv1.GroupVersion.Group},
+			Resources: []string{"meshaccessrequests"},
+			Verbs:     []string{"approve"},
+		},
+	})
+}
+
+func newAggregatedClusterRole(name string, aggregate map[string]string, rules []rbacv1.PolicyRule) *rbacv1.ClusterRole {
+	labels := map[string]string{
+		"app.kubernetes.io/name":       name,
+		"app.kubernetes.io/component":  "rbac",
+		"app.kubernetes.io/part-of":    "webmesh-operator",
+		"app.kubernetes.io/managed-by": meshv1.FieldOwner,
+	}
+	for k, v := range aggregate {
+		labels[k] = v
+	}
+	return &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Rules: rules,
+	}
+}
+
+func statusResources() []string {
+	out := make([]string, len(meshResources))
+	for i, resource := range meshResources {
+		out[i] = resource + "/status"
+	}
+	return out
+}
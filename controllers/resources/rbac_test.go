@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/yaml"
+)
+
+func TestAggregatedClusterRolesMatchConfig(t *testing.T) {
+	data, err := os.ReadFile("../../config/rbac/webmesh_aggregated_roles.yaml")
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	docs := strings.Split(string(data), "\n---\n")
+	roles := RenderAggregatedClusterRoles()
+	if len(docs) != len(roles) {
+		t.Fatalf("expected %d roles in config, got %d", len(roles), len(docs))
+	}
+	for i, doc := range docs {
+		var got rbacv1.ClusterRole
+		if err := yaml.Unmarshal([]byte(doc), &got); err != nil {
+			t.Fatalf("unmarshal role %d: %v", i, err)
+		}
+		want := roles[i].(*rbacv1.ClusterRole)
+		if !equality.Semantic.DeepEqual(&got, want) {
+			t.Errorf("config for %s is out of date, regenerate it with 'kubectl webmesh rbac'", want.GetName())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+// RenderMesh returns the resources the Mesh controller creates for a mesh,
+// excluding generated configs. The mesh is expected to be defaulted.
+func RenderMesh(mesh *meshv1.Mesh) []client.Object {
+	var out []client.Object
+	if mesh.Spec.Issuer.Create {
+		out = append(out,
+			NewMeshSelfSigner(mesh),
+			NewMeshCACertificate(mesh),
+			NewMeshIssuer(mesh),
+		)
+	}
+	out = append(out, NewMeshAdminCertificate(mesh), NewMeshManagerCertificate(mesh))
+	for i := range mesh.Spec.AccessProfiles {
+		out = append(out, NewMeshAccessProfileCertificate(mesh, &mesh.Spec.AccessProfiles[i]))
+	}
+	for _, group := range mesh.BootstrapGroups() {
+		out = append(out, group)
+	}
+	return out
+}
+
+// RenderNodeCertificates returns the certificates for each replica of a
+// node group in a cluster with the given domain.
+func RenderNodeCertificates(mesh *meshv1.Mesh, group *meshv1.NodeGroup, clusterDomain string) []client.Object {
+	var out []client.Object
+	for i := 0; i < int(group.Replicas()); i++ {
+		out = append(out, NewNodeCertificate(mesh, group, i, clusterDomain))
+	}
+	return out
+}
+
+// RenderClusterNodeGroup returns the workload resources for a node group
+// running in a Kubernetes cluster. The load balancer service, if any, is
+// not included.
+func RenderClusterNodeGroup(mesh *meshv1.Mesh, group *meshv1.NodeGroup, conf *nodeconfig.Config, families meshv1.ServiceIPFamilies, metricsProxyImage string) []client.Object {
+	var config client.Object = NewNodeGroupConfigMap(mesh, group, conf)
+	if group.Spec.Cluster.ConfigStorage == meshv1.ConfigStorageSecret {
+		config = NewNodeGroupConfigSecret(mesh, group, conf)
+	}
+	sts := NewNodeGroupStatefulSet(mesh, group, metricsProxyImage, conf.Checksum())
+	// Options read from secrets are passed to the node container through
+	// its environment, the config only holds placeholders for them.
+	node := &sts.Spec.Template.Spec.Containers[0]
+	node.Env = append(node.Env, conf.EnvVars()...)
+	return []client.Object{
+		config,
+		NewNodeGroupHeadlessService(mesh, group, families),
+		sts,
+	}
+}
+
+// OwnedByNodeGroup returns true if the object was created for the given node
+// group. Objects controlled by a NodeGroup must be controlled by this one.
+// Others, such as objects in remote clusters where owner references do not
+// reach and claims created by the StatefulSet, must carry the name and
+// namespace labels of the group.
+func OwnedByNodeGroup(obj client.Object, group *meshv1.NodeGroup) bool {
+	if owner := metav1.GetControllerOf(obj); owner != nil && owner.Kind == "NodeGroup" {
+		return owner.Name == group.GetName() && owner.UID == group.GetUID()
+	}
+	name, _ := meshv1.LabelValue(obj.GetLabels(), meshv1.NodeGroupNameLabel)
+	namespace, _ := meshv1.LabelValue(obj.GetLabels(), meshv1.NodeGroupNamespaceLabel)
+	return name == group.GetName() && namespace == group.GetNamespace()
+}
+
+// ClusterNodeGroupInventory returns every object that may have been created for
+// a node group running in a Kubernetes cluster, with only their type, name and
+// namespace set. The workload is listed first so nodes stop before their config
+// and services are removed. The PVCs of the group are listed last unless the
+// group retains its data on delete. Objects are found by name only, so callers
+// check them with OwnedByNodeGroup before deleting them.
+func ClusterNodeGroupInventory(mesh *meshv1.Mesh, group *meshv1.NodeGroup) []client.Object {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: group.GetNamespace()}
+	}
+	out := []client.Object{
+		&appsv1.StatefulSet{ObjectMeta: meta(meshv1.MeshNodeGroupStatefulSetName(mesh, group))},
+		&corev1.Service{ObjectMeta: meta(meshv1.MeshNodeGroupLBName(mesh, group))},
+		&corev1.Service{ObjectMeta: meta(meshv1.MeshNodeGroupHeadlessServiceName(mesh, group))},
+		&corev1.ConfigMap{ObjectMeta: meta(meshv1.MeshNodeGroupConfigMapName(mesh, group))},
+	}
+	if group.ExposedPerReplica() {
+		for i := 0; i < int(group.Replicas()); i++ {
+			out = append(out, &corev1.Service{ObjectMeta: meta(meshv1.MeshNodeGroupReplicaLBName(mesh, group, i))})
+		}
+	}
+	if group.Spec.Cluster == nil || group.Spec.Cluster.AdoptExisting == nil {
+		// Adopted configs are always ConfigMaps
+		out = append(out, &corev1.Secret{ObjectMeta: meta(meshv1.MeshNodeGroupConfigMapName(mesh, group))})
+	}
+	if group.Spec.Cluster == nil || group.Spec.Cluster.RetainDataOnDelete {
+		return out
+	}
+	for i := 0; i < int(group.Replicas()); i++ {
+		name := fmt.Sprintf("data-%s", meshv1.MeshNodeGroupPodName(mesh, group, i))
+		out = append(out, &corev1.PersistentVolumeClaim{ObjectMeta: meta(name)})
+	}
+	return out
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+func TestClusterNodeGroupInventory(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	tc := []struct {
+		name   string
+		retain bool
+		pvcs   []string
+	}{
+		{name: "deletes data", pvcs: []string{"data-mesh-group-0", "data-mesh-group-1"}},
+		{name: "retains data", retain: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: meshv1.NodeGroupSpec{
+					Replicas: Pointer(int32(2)),
+					Cluster:  &meshv1.NodeGroupClusterConfig{RetainDataOnDelete: tt.retain},
+				},
+			}
+			// Every rendered workload object must be in the inventory
+			sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+			inventory := make(map[string]bool)
+			var pvcs []string
+			for _, obj := range ClusterNodeGroupInventory(mesh, group) {
+				if _, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+					pvcs = append(pvcs, obj.GetName())
+					continue
+				}
+				inventory[obj.GetName()] = true
+			}
+			for _, name := range []string{
+				sts.GetName(),
+				meshv1.MeshNodeGroupLBName(mesh, group),
+				meshv1.MeshNodeGroupHeadlessServiceName(mesh, group),
+				meshv1.MeshNodeGroupConfigMapName(mesh, group),
+			} {
+				if !inventory[name] {
+					t.Errorf("expected %s in inventory", name)
+				}
+			}
+			if len(pvcs) != len(tt.pvcs) {
+				t.Fatalf("expected PVCs %v, got %v", tt.pvcs, pvcs)
+			}
+			for i := range pvcs {
+				if pvcs[i] != tt.pvcs[i] {
+					t.Errorf("expected PVCs %v, got %v", tt.pvcs, pvcs)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderClusterNodeGroupSecretOptions(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Cluster: &meshv1.NodeGroupClusterConfig{},
+			Config: &meshv1.NodeGroupConfig{
+				Services: &meshv1.NodeServicesConfig{
+					WebRTC: &meshv1.NodeWebRTCConfig{
+						STUNServersSecret: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "turn"},
+							Key:                  "servers",
+						},
+					},
+				},
+			},
+		},
+	}
+	conf, err := nodeconfig.New(nodeconfig.Options{Mesh: mesh, Group: group, JoinServer: "join:8443"})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	conf.SetSecretVersion("turn", "1")
+	var cm *corev1.ConfigMap
+	var sts *appsv1.StatefulSet
+	for _, obj := range RenderClusterNodeGroup(mesh, group, conf, meshv1.ServiceIPFamilies{}, meshv1.DefaultMetricsProxyImage) {
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			cm = o
+		case *appsv1.StatefulSet:
+			sts = o
+		}
+	}
+	placeholder := conf.Secrets[0].Placeholder()
+	if !strings.Contains(cm.Data[meshv1.ConfigFileName], placeholder) {
+		t.Errorf("expected placeholder %s in config", placeholder)
+	}
+	var found bool
+	for _, env := range sts.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == conf.Secrets[0].EnvName() {
+			found = env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil &&
+				env.ValueFrom.SecretKeyRef.Name == "turn" && env.ValueFrom.SecretKeyRef.Key == "servers"
+		}
+	}
+	if !found {
+		t.Error("expected the node container to read the option from the secret")
+	}
+	if got := sts.Spec.Template.Annotations[meshv1.ConfigChecksumAnnotation]; got != conf.Checksum() {
+		t.Errorf("expected checksum %s, got %s", conf.Checksum(), got)
+	}
+}
+
+func TestRenderClusterNodeGroupPerReplica(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Replicas: Pointer(int32(2)),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{
+					Type:          corev1.ServiceTypeLoadBalancer,
+					GRPCPort:      8443,
+					WireGuardPort: 51820,
+					PerReplica:    true,
+				},
+			},
+		},
+	}
+	conf, err := nodeconfig.NewForCluster(nodeconfig.ClusterOptions{
+		Mesh:                mesh,
+		Group:               group,
+		ReplicaExternalURLs: [][]string{{"203.0.113.10"}, {"203.0.113.11"}},
+		JoinServer:          "join:8443",
+	})
+	if err != nil {
+		t.Fatalf("build config: %v", err)
+	}
+	var cm *corev1.ConfigMap
+	var sts *appsv1.StatefulSet
+	for _, obj := range RenderClusterNodeGroup(mesh, group, conf, meshv1.ServiceIPFamilies{}, meshv1.DefaultMetricsProxyImage) {
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			cm = o
+		case *appsv1.StatefulSet:
+			sts = o
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := cm.Data[nodeconfig.ReplicaFileName(mesh, group, i)]; !ok {
+			t.Errorf("expected the config of replica %d in the configmap", i)
+		}
+	}
+	args := sts.Spec.Template.Spec.Containers[0].Args
+	if len(args) != 2 || args[1] != meshv1.ReplicaConfigPath {
+		t.Errorf("expected the node to read the config of its replica, got args %v", args)
+	}
+
+	svcs := NewNodeGroupLBServices(mesh, group, meshv1.ServiceIPFamilies{})
+	if len(svcs) != 2 {
+		t.Fatalf("expected a service per replica, got %d", len(svcs))
+	}
+	inventory := make(map[string]bool)
+	for _, obj := range ClusterNodeGroupInventory(mesh, group) {
+		inventory[obj.GetName()] = true
+	}
+	for i, svc := range svcs {
+		if got, want := svc.Spec.Selector[appsv1.StatefulSetPodNameLabel], meshv1.MeshNodeGroupPodName(mesh, group, i); got != want {
+			t.Errorf("expected service %s to select pod %s, got %s", svc.GetName(), want, got)
+		}
+		if !inventory[svc.GetName()] {
+			t.Errorf("expected %s in inventory", svc.GetName())
+		}
+	}
+}
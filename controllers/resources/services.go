@@ -17,6 +17,7 @@ limitations under the License.
 package resources
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -24,9 +25,9 @@ import (
 	meshv1 "github.com/webmeshproj/operator/api/v1"
 )
 
-// NewNodeGroupHeadlessService returns a new headless service for a NodeGroup.
-func NewNodeGroupHeadlessService(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *corev1.Service {
-	policy := corev1.IPFamilyPolicyPreferDualStack
+// NewNodeGroupHeadlessService returns a new headless service for a NodeGroup
+// with the given IP families.
+func NewNodeGroupHeadlessService(mesh *meshv1.Mesh, group *meshv1.NodeGroup, families meshv1.ServiceIPFamilies) *corev1.Service {
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.String(),
@@ -41,7 +42,8 @@ func NewNodeGroupHeadlessService(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *co
 		Spec: corev1.ServiceSpec{
 			ClusterIP:      "None",
 			Type:           corev1.ServiceTypeClusterIP,
-			IPFamilyPolicy: &policy,
+			IPFamilyPolicy: families.IPFamilyPolicy,
+			IPFamilies:     families.IPFamilies,
 			Selector:       meshv1.NodeGroupSelector(mesh, group),
 			Ports: func() []corev1.ServicePort {
 				ports := []corev1.ServicePort{
@@ -51,28 +53,48 @@ func NewNodeGroupHeadlessService(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *co
 						TargetPort: intstr.FromString("grpc"),
 						Protocol:   corev1.ProtocolTCP,
 					},
-					{
+				}
+				// Raft is only served to the other members through the
+				// headless service, never through the load balancer.
+				if group.RaftMember(mesh) {
+					ports = append(ports, corev1.ServicePort{
 						Name:       "raft",
 						Port:       meshv1.DefaultRaftPort,
 						TargetPort: intstr.FromString("raft"),
 						Protocol:   corev1.ProtocolTCP,
-					},
-					{
-						Name:       "wireguard",
-						Port:       meshv1.DefaultWireGuardPort,
-						TargetPort: intstr.FromInt(meshv1.DefaultWireGuardPort),
-						Protocol:   corev1.ProtocolUDP,
-					},
+					})
 				}
-				return ports
+				if group.Spec.Profile == meshv1.NodeGroupProfileDNS {
+					udp, tcp := meshDNSPorts(mesh, group)
+					return append(ports,
+						corev1.ServicePort{
+							Name:       "dns-udp",
+							Port:       udp,
+							TargetPort: intstr.FromString("dns-udp"),
+							Protocol:   corev1.ProtocolUDP,
+						},
+						corev1.ServicePort{
+							Name:       "dns-tcp",
+							Port:       tcp,
+							TargetPort: intstr.FromString("dns-tcp"),
+							Protocol:   corev1.ProtocolTCP,
+						},
+					)
+				}
+				return append(ports, corev1.ServicePort{
+					Name:       "wireguard",
+					Port:       meshv1.DefaultWireGuardPort,
+					TargetPort: intstr.FromInt(meshv1.DefaultWireGuardPort),
+					Protocol:   corev1.ProtocolUDP,
+				})
 			}(),
 		},
 	}
 }
 
-// NewNodeGroupLBService returns a new service for exposing a NodeGroup.
-func NewNodeGroupLBService(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *corev1.Service {
-	ipPolicy := corev1.IPFamilyPolicyPreferDualStack
+// NewNodeGroupLBService returns a new service for exposing a NodeGroup with
+// the given IP families.
+func NewNodeGroupLBService(mesh *meshv1.Mesh, group *meshv1.NodeGroup, families meshv1.ServiceIPFamilies) *corev1.Service {
 	spec := group.Spec.Cluster.Service
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -88,7 +110,8 @@ func NewNodeGroupLBService(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *corev1.S
 		},
 		Spec: corev1.ServiceSpec{
 			Type:           spec.Type,
-			IPFamilyPolicy: &ipPolicy,
+			IPFamilyPolicy: families.IPFamilyPolicy,
+			IPFamilies:     families.IPFamilies,
 			Selector:       meshv1.NodeGroupSelector(mesh, group),
 			Ports: func() []corev1.ServicePort {
 				ports := []corev1.ServicePort{
@@ -110,3 +133,96 @@ func NewNodeGroupLBService(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *corev1.S
 		},
 	}
 }
+
+// NewNodeGroupLBServices returns the services exposing a NodeGroup with the
+// given IP families: one per replica when the group is exposed per replica,
+// otherwise a single service for the group.
+func NewNodeGroupLBServices(mesh *meshv1.Mesh, group *meshv1.NodeGroup, families meshv1.ServiceIPFamilies) []*corev1.Service {
+	if !group.ExposedPerReplica() {
+		return []*corev1.Service{NewNodeGroupLBService(mesh, group, families)}
+	}
+	out := make([]*corev1.Service, 0, group.Replicas())
+	for i := 0; i < int(group.Replicas()); i++ {
+		out = append(out, NewNodeGroupReplicaLBService(mesh, group, families, i))
+	}
+	return out
+}
+
+// NewNodeGroupReplicaLBService returns a new service for exposing a single
+// replica of a NodeGroup with the given IP families. It selects the pod of
+// the replica through the label set by the StatefulSet controller.
+func NewNodeGroupReplicaLBService(mesh *meshv1.Mesh, group *meshv1.NodeGroup, families meshv1.ServiceIPFamilies, index int) *corev1.Service {
+	svc := NewNodeGroupLBService(mesh, group, families)
+	svc.Name = meshv1.MeshNodeGroupReplicaLBName(mesh, group, index)
+	svc.Spec.Selector[appsv1.StatefulSetPodNameLabel] = meshv1.MeshNodeGroupPodName(mesh, group, index)
+	return svc
+}
+
+// NewMeshPeeringService returns a new headless service for the bridge node of a
+// MeshPeering.
+func NewMeshPeeringService(peering *meshv1.MeshPeering, meshes []BridgeMesh) *corev1.Service {
+	policy := corev1.IPFamilyPolicyPreferDualStack
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshPeeringBridgeName(peering),
+			Namespace:       peering.GetNamespace(),
+			Labels:          meshv1.MeshPeeringLabels(peering),
+			OwnerReferences: meshv1.OwnerReferences(peering),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:      "None",
+			Type:           corev1.ServiceTypeClusterIP,
+			IPFamilyPolicy: &policy,
+			Selector:       meshv1.MeshPeeringSelector(peering),
+			Ports:          bridgeServicePorts(meshes, true),
+		},
+	}
+}
+
+// NewMeshPeeringLBService returns a new service exposing the gRPC and WireGuard
+// ports of the bridge node of a MeshPeering through a load balancer.
+func NewMeshPeeringLBService(peering *meshv1.MeshPeering, meshes []BridgeMesh) *corev1.Service {
+	policy := corev1.IPFamilyPolicyPreferDualStack
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshPeeringBridgeLBName(peering),
+			Namespace:       peering.GetNamespace(),
+			Labels:          meshv1.MeshPeeringLabels(peering),
+			OwnerReferences: meshv1.OwnerReferences(peering),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:           corev1.ServiceTypeLoadBalancer,
+			IPFamilyPolicy: &policy,
+			Selector:       meshv1.MeshPeeringSelector(peering),
+			Ports:          bridgeServicePorts(meshes, false),
+		},
+	}
+}
+
+// bridgeServicePorts returns the service ports of the bridged meshes. Raft is
+// only served inside the cluster.
+func bridgeServicePorts(meshes []BridgeMesh, raft bool) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	for _, mesh := range meshes {
+		for _, port := range mesh.containerPorts() {
+			if !raft && port.ContainerPort == int32(mesh.RaftPort()) {
+				continue
+			}
+			ports = append(ports, corev1.ServicePort{
+				Name:       port.Name,
+				Port:       port.ContainerPort,
+				TargetPort: intstr.FromString(port.Name),
+				Protocol:   port.Protocol,
+			})
+		}
+	}
+	return ports
+}
@@ -18,17 +18,30 @@ package resources
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
 )
 
-// NewNodeGroupStatefulSet returns a new statefulset for a NodeGroup.
-func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, configChecksum string) *appsv1.StatefulSet {
+// NodeContainerName is the name of the node container in the pods of a NodeGroup.
+const NodeContainerName = "node"
+
+// safeToEvictAnnotation tells the cluster autoscaler whether it may evict a pod
+// when scaling down its node.
+const safeToEvictAnnotation = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+
+// NewNodeGroupStatefulSet returns a new statefulset for a NodeGroup. The
+// metrics proxy image is used for the sidecar serving metrics over TLS.
+func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, metricsProxyImage, configChecksum string) *appsv1.StatefulSet {
 	groupspec := group.Spec.Cluster
 	return &appsv1.StatefulSet{
 		TypeMeta: metav1.TypeMeta{
@@ -39,30 +52,30 @@ func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, configC
 			Name:            meshv1.MeshNodeGroupStatefulSetName(mesh, group),
 			Namespace:       group.GetNamespace(),
 			Labels:          meshv1.NodeGroupLabels(mesh, group),
-			Annotations:     group.GetAnnotations(),
+			Annotations:     meshv1.NodeGroupAnnotations(mesh, group),
 			OwnerReferences: meshv1.OwnerReferences(group),
 		},
 		Spec: appsv1.StatefulSetSpec{
-			Replicas: group.Spec.Replicas,
+			Replicas: Pointer(group.Replicas()),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: meshv1.NodeGroupSelector(mesh, group),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: meshv1.NodeGroupLabels(mesh, group),
-					Annotations: map[string]string{
-						meshv1.ConfigChecksumAnnotation: configChecksum,
-					},
+					Labels:      newNodePodLabels(mesh, group),
+					Annotations: newNodePodAnnotations(group, configChecksum),
 				},
 				Spec: corev1.PodSpec{
 					ImagePullSecrets: groupspec.ImagePullSecrets,
 					InitContainers:   groupspec.InitContainers,
-					Containers: append([]corev1.Container{
+					Containers: append(append([]corev1.Container{
 						{
-							Name:            "node",
+							Name:            NodeContainerName,
 							Image:           group.Spec.Image,
 							ImagePullPolicy: groupspec.ImagePullPolicy,
-							Args:            []string{"--config", "/etc/webmesh/config.yaml"},
+							Args:            newNodeArgs(group),
+							// Surface startup errors in the container status
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
 							Env: []corev1.EnvVar{
 								{
 									Name: "POD_NAME",
@@ -73,84 +86,34 @@ func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, configC
 									},
 								},
 							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "grpc",
-									ContainerPort: meshv1.DefaultGRPCPort,
-									Protocol:      corev1.ProtocolTCP,
-								},
-								{
-									Name:          "raft",
-									ContainerPort: meshv1.DefaultRaftPort,
-									Protocol:      corev1.ProtocolTCP,
-								},
-								{
-									Name:          "wireguard",
-									ContainerPort: meshv1.DefaultWireGuardPort,
-									Protocol:      corev1.ProtocolUDP,
-								},
-							},
+							Ports: newNodeContainerPorts(mesh, group),
 							VolumeMounts: func() []corev1.VolumeMount {
 								vols := []corev1.VolumeMount{
 									{
 										Name:      "config",
-										MountPath: "/etc/webmesh",
+										MountPath: meshv1.DefaultConfigDirectory,
 									},
 									{
 										Name:      "data",
 										MountPath: meshv1.DefaultDataDirectory,
 									},
+									{
+										Name:      nodeTLSVolume,
+										MountPath: meshv1.DefaultTLSDirectory,
+										ReadOnly:  true,
+									},
 								}
-								for i := 0; i < int(*group.Spec.Replicas); i++ {
-									vols = append(vols, corev1.VolumeMount{
-										Name:      fmt.Sprintf("node-tls-%d", i),
-										MountPath: fmt.Sprintf("%s/%s", meshv1.DefaultTLSDirectory, meshv1.MeshNodeGroupPodName(mesh, group, i)),
-									})
-								}
+								vols = append(vols, newWireGuardVolumeMounts(group)...)
 								return append(vols, groupspec.AdditionalVolumeMounts...)
 							}(),
-							Resources: groupspec.Resources,
-							SecurityContext: &corev1.SecurityContext{
-								Capabilities: &corev1.Capabilities{
-									Add: []corev1.Capability{
-										"NET_ADMIN",
-										"NET_RAW",
-										"SYS_MODULE",
-									},
-								},
-								RunAsUser:    Pointer(int64(0)),
-								RunAsGroup:   Pointer(int64(0)),
-								Privileged:   Pointer(true),
-								RunAsNonRoot: Pointer(false),
-								SeccompProfile: &corev1.SeccompProfile{
-									Type: corev1.SeccompProfileTypeRuntimeDefault,
-								},
-							},
+							Resources:       groupspec.ResourceRequirements(),
+							SecurityContext: newNodeSecurityContext(group),
 						},
-					}, groupspec.AdditionalContainers...),
+					}, newMetricsProxyContainers(mesh, group, metricsProxyImage)...), groupspec.AdditionalContainers...),
 					Volumes: func() []corev1.Volume {
-						vols := []corev1.Volume{
-							{
-								Name: "config",
-								VolumeSource: corev1.VolumeSource{
-									ConfigMap: &corev1.ConfigMapVolumeSource{
-										LocalObjectReference: corev1.LocalObjectReference{
-											Name: meshv1.MeshNodeGroupConfigMapName(mesh, group),
-										},
-									},
-								},
-							},
-						}
-						for i := 0; i < int(*group.Spec.Replicas); i++ {
-							vols = append(vols, corev1.Volume{
-								Name: fmt.Sprintf("node-tls-%d", i),
-								VolumeSource: corev1.VolumeSource{
-									Secret: &corev1.SecretVolumeSource{
-										SecretName: meshv1.MeshNodeCertName(mesh, group, i),
-									},
-								},
-							})
-						}
+						vols := append([]corev1.Volume{
+							newNodeConfigVolume(mesh, group),
+						}, newNodeTLSVolumes(mesh, group)...)
 						if groupspec.PVCSpec == nil {
 							vols = append(vols, corev1.Volume{
 								Name: "data",
@@ -159,10 +122,11 @@ func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, configC
 								},
 							})
 						}
+						vols = append(vols, newWireGuardVolumes(group)...)
 						return append(vols, groupspec.AdditionalVolumes...)
 					}(),
 					TerminationGracePeriodSeconds: Pointer(int64(60)),
-					NodeSelector:                  groupspec.NodeSelector,
+					NodeSelector:                  newNodeSelector(groupspec),
 					HostNetwork:                   groupspec.HostNetwork,
 					// Make sure additional user-defined containers run
 					// with lower privileges unless configured otherwise.
@@ -176,10 +140,11 @@ func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, configC
 						},
 					},
 					Affinity:                  groupspec.Affinity,
-					Tolerations:               groupspec.Tolerations,
+					Tolerations:               newNodeTolerations(groupspec),
 					PreemptionPolicy:          groupspec.PreemptionPolicy,
 					TopologySpreadConstraints: groupspec.TopologySpreadConstraints,
 					ResourceClaims:            groupspec.ResourceClaims,
+					ReadinessGates:            newNodeReadinessGates(groupspec),
 				},
 			},
 			VolumeClaimTemplates: func() []corev1.PersistentVolumeClaim {
@@ -204,9 +169,767 @@ func NewNodeGroupStatefulSet(mesh *meshv1.Mesh, group *meshv1.NodeGroup, configC
 				},
 			},
 			PersistentVolumeClaimRetentionPolicy: &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
-				WhenDeleted: appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
-				WhenScaled:  appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
+				WhenDeleted: func() appsv1.PersistentVolumeClaimRetentionPolicyType {
+					if group.Spec.Cluster.RetainDataOnDelete {
+						return appsv1.RetainPersistentVolumeClaimRetentionPolicyType
+					}
+					return appsv1.DeletePersistentVolumeClaimRetentionPolicyType
+				}(),
+				WhenScaled: appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
+			},
+		},
+	}
+}
+
+// AdoptStatefulSet makes the given objects of a node group compatible with the
+// existing StatefulSet the group adopts. The immutable fields of the
+// StatefulSet are kept, its selector is added to the pod template so existing
+// and replaced pods are both selected, and services select pods the same way.
+// The data volume is mounted from the existing volume claim template. The
+// objects are left unchanged if existing is nil.
+func AdoptStatefulSet(existing *appsv1.StatefulSet, objs []client.Object) {
+	if existing == nil {
+		return
+	}
+	selector := existing.Spec.Selector.MatchLabels
+	for _, obj := range objs {
+		switch obj := obj.(type) {
+		case *appsv1.StatefulSet:
+			obj.Spec.Selector = existing.Spec.Selector.DeepCopy()
+			obj.Spec.ServiceName = existing.Spec.ServiceName
+			obj.Spec.PodManagementPolicy = existing.Spec.PodManagementPolicy
+			obj.Spec.VolumeClaimTemplates = nil
+			for _, template := range existing.Spec.VolumeClaimTemplates {
+				obj.Spec.VolumeClaimTemplates = append(obj.Spec.VolumeClaimTemplates, *template.DeepCopy())
+			}
+			template := &obj.Spec.Template
+			if template.Labels == nil {
+				template.Labels = make(map[string]string, len(selector))
+			}
+			for k, v := range selector {
+				template.Labels[k] = v
+			}
+			if len(existing.Spec.VolumeClaimTemplates) == 1 {
+				claim := existing.Spec.VolumeClaimTemplates[0].GetName()
+				for i := range template.Spec.Containers[0].VolumeMounts {
+					if mount := &template.Spec.Containers[0].VolumeMounts[i]; mount.Name == "data" {
+						mount.Name = claim
+					}
+				}
+			}
+		case *corev1.Service:
+			obj.Spec.Selector = make(map[string]string, len(selector))
+			for k, v := range selector {
+				obj.Spec.Selector[k] = v
+			}
+		}
+	}
+}
+
+// StatefulSetReplicas returns the pods and persistent volume claims the
+// StatefulSet controller creates for each replica of the given StatefulSet.
+// The objects are named and labeled the way the controller would name them.
+func StatefulSetReplicas(sts *appsv1.StatefulSet) []client.Object {
+	replicas := 1
+	if sts.Spec.Replicas != nil {
+		replicas = int(*sts.Spec.Replicas)
+	}
+	out := make([]client.Object, 0, replicas*(len(sts.Spec.VolumeClaimTemplates)+1))
+	for i := 0; i < replicas; i++ {
+		podName := fmt.Sprintf("%s-%d", sts.GetName(), i)
+		for _, template := range sts.Spec.VolumeClaimTemplates {
+			claim := template.DeepCopy()
+			claim.TypeMeta = metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "PersistentVolumeClaim",
+			}
+			claim.Name = fmt.Sprintf("%s-%s", template.GetName(), podName)
+			claim.Namespace = sts.GetNamespace()
+			claim.Labels = make(map[string]string, len(sts.Spec.Selector.MatchLabels))
+			for k, v := range sts.Spec.Selector.MatchLabels {
+				claim.Labels[k] = v
+			}
+			out = append(out, claim)
+		}
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "Pod",
+			},
+			ObjectMeta: *sts.Spec.Template.ObjectMeta.DeepCopy(),
+			Spec:       *sts.Spec.Template.Spec.DeepCopy(),
+		}
+		pod.Name = podName
+		pod.Namespace = sts.GetNamespace()
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+		pod.Labels[appsv1.StatefulSetPodNameLabel] = podName
+		pod.Spec.Hostname = podName
+		pod.Spec.Subdomain = sts.Spec.ServiceName
+		for _, template := range sts.Spec.VolumeClaimTemplates {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name: template.GetName(),
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: fmt.Sprintf("%s-%s", template.GetName(), podName),
+					},
+				},
+			})
+		}
+		out = append(out, pod)
+	}
+	return out
+}
+
+// newNodePodLabels returns the labels of the node pods. Pods of groups with
+// more than one replica are selected by the webhook projecting their own
+// certificate secret into the TLS volume.
+func newNodePodLabels(mesh *meshv1.Mesh, group *meshv1.NodeGroup) map[string]string {
+	labels := meshv1.NodeGroupLabels(mesh, group)
+	if injectsNodeTLSSecret(group) {
+		labels[meshv1.NodeTLSInjectionLabel] = "true"
+	}
+	return labels
+}
+
+// newNodePodAnnotations returns the annotations of the node pods. Bootstrap
+// nodes on dedicated cluster nodes are kept from being evicted by the cluster
+// autoscaler, so that the nodes holding the mesh state are not consolidated away.
+func newNodePodAnnotations(group *meshv1.NodeGroup, configChecksum string) map[string]string {
+	annotations := map[string]string{
+		meshv1.ConfigChecksumAnnotation: configChecksum,
+	}
+	if group.Spec.Cluster.DedicatedNodes != nil && group.GetAnnotations()[meshv1.BootstrapNodeGroupAnnotation] == "true" {
+		annotations[safeToEvictAnnotation] = "false"
+	}
+	return annotations
+}
+
+// newNodeSelector returns the node selector of the node pods, merging in the
+// label of the group's dedicated nodes.
+func newNodeSelector(groupspec *meshv1.NodeGroupClusterConfig) map[string]string {
+	if groupspec.DedicatedNodes == nil || groupspec.DedicatedNodes.Label == "" {
+		return groupspec.NodeSelector
+	}
+	selector := make(map[string]string, len(groupspec.NodeSelector)+1)
+	for key, value := range groupspec.NodeSelector {
+		selector[key] = value
+	}
+	for key, value := range groupspec.DedicatedNodes.NodeSelector() {
+		selector[key] = value
+	}
+	return selector
+}
+
+// newNodeTolerations returns the tolerations of the node pods, adding one for
+// the taint of the group's dedicated nodes.
+func newNodeTolerations(groupspec *meshv1.NodeGroupClusterConfig) []corev1.Toleration {
+	if groupspec.DedicatedNodes == nil {
+		return groupspec.Tolerations
+	}
+	tolerations := append([]corev1.Toleration{}, groupspec.Tolerations...)
+	return append(tolerations, corev1.Toleration{
+		Key:      groupspec.DedicatedNodes.TaintKey,
+		Operator: corev1.TolerationOpExists,
+	})
+}
+
+// newNodeReadinessGates returns the readiness gates of the node pods. Groups
+// with a maintenance readiness gate wait for the operator to mark their pods
+// as in service.
+func newNodeReadinessGates(groupspec *meshv1.NodeGroupClusterConfig) []corev1.PodReadinessGate {
+	if !groupspec.MaintenanceReadinessGate {
+		return nil
+	}
+	return []corev1.PodReadinessGate{{ConditionType: meshv1.NodeInServiceCondition}}
+}
+
+// newNodeConfigVolume returns the volume holding the node config, sourced from
+// either the group's ConfigMap or Secret.
+func newNodeConfigVolume(mesh *meshv1.Mesh, group *meshv1.NodeGroup) corev1.Volume {
+	name := meshv1.MeshNodeGroupConfigMapName(mesh, group)
+	if group.Spec.Cluster.ConfigStorage == meshv1.ConfigStorageSecret {
+		return corev1.Volume{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: name,
+				},
+			},
+		}
+	}
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: name,
+				},
+			},
+		},
+	}
+}
+
+// newNodeContainerPorts returns the ports of the node container. DNS nodes
+// serve MeshDNS instead of accepting WireGuard connections, and only members
+// of the Raft cluster serve Raft.
+func newNodeContainerPorts(mesh *meshv1.Mesh, group *meshv1.NodeGroup) []corev1.ContainerPort {
+	ports := []corev1.ContainerPort{
+		{
+			Name:          "grpc",
+			ContainerPort: meshv1.DefaultGRPCPort,
+			Protocol:      corev1.ProtocolTCP,
+		},
+	}
+	if group.RaftMember(mesh) {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          "raft",
+			ContainerPort: meshv1.DefaultRaftPort,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+	if group.Spec.Profile != meshv1.NodeGroupProfileDNS {
+		return append(ports, corev1.ContainerPort{
+			Name:          "wireguard",
+			ContainerPort: meshv1.DefaultWireGuardPort,
+			Protocol:      corev1.ProtocolUDP,
+		})
+	}
+	udp, tcp := meshDNSPorts(mesh, group)
+	return append(ports,
+		corev1.ContainerPort{
+			Name:          "dns-udp",
+			ContainerPort: udp,
+			Protocol:      corev1.ProtocolUDP,
+		},
+		corev1.ContainerPort{
+			Name:          "dns-tcp",
+			ContainerPort: tcp,
+			Protocol:      corev1.ProtocolTCP,
+		},
+	)
+}
+
+// newNodeSecurityContext returns the security context of the node container.
+// DNS nodes do not route traffic and run unprivileged, only keeping the
+// capability needed to bring up their WireGuard interface.
+func newNodeSecurityContext(group *meshv1.NodeGroup) *corev1.SecurityContext {
+	if group.Spec.Profile == meshv1.NodeGroupProfileDNS {
+		return &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add:  []corev1.Capability{"NET_ADMIN"},
+				Drop: []corev1.Capability{"ALL"},
+			},
+			RunAsUser:                Pointer(int64(0)),
+			RunAsGroup:               Pointer(int64(0)),
+			Privileged:               Pointer(false),
+			AllowPrivilegeEscalation: Pointer(false),
+			RunAsNonRoot:             Pointer(false),
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		}
+	}
+	if group.Spec.Cluster.WireGuardMode.Unprivileged() {
+		return &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add:  []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+				Drop: []corev1.Capability{"SYS_MODULE"},
+			},
+			RunAsUser:    Pointer(int64(0)),
+			RunAsGroup:   Pointer(int64(0)),
+			Privileged:   Pointer(false),
+			RunAsNonRoot: Pointer(false),
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: corev1.SeccompProfileTypeRuntimeDefault,
+			},
+		}
+	}
+	return &corev1.SecurityContext{
+		Capabilities: &corev1.Capabilities{
+			Add: []corev1.Capability{
+				"NET_ADMIN",
+				"NET_RAW",
+				"SYS_MODULE",
+			},
+		},
+		RunAsUser:    Pointer(int64(0)),
+		RunAsGroup:   Pointer(int64(0)),
+		Privileged:   Pointer(true),
+		RunAsNonRoot: Pointer(false),
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+const (
+	// wireGuardTUNVolume is the volume holding the TUN device of the host
+	// for nodes using userspace WireGuard.
+	wireGuardTUNVolume = "dev-net-tun"
+	// wireGuardTUNDevice is the path of the TUN device.
+	wireGuardTUNDevice = "/dev/net/tun"
+)
+
+// newNodeArgs returns the arguments of the node container. Groups exposed per
+// replica read the config rendered for their own pod.
+func newNodeArgs(group *meshv1.NodeGroup) []string {
+	if group.ExposedPerReplica() {
+		return []string{"--config", meshv1.ReplicaConfigPath}
+	}
+	return []string{"--config", meshv1.DefaultConfigPath}
+}
+
+// newWireGuardVolumes returns the volumes of the node pods needed for
+// userspace WireGuard.
+func newWireGuardVolumes(group *meshv1.NodeGroup) []corev1.Volume {
+	if !group.Spec.Cluster.WireGuardMode.Unprivileged() {
+		return nil
+	}
+	return []corev1.Volume{
+		{
+			Name: wireGuardTUNVolume,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: wireGuardTUNDevice,
+					Type: Pointer(corev1.HostPathCharDev),
+				},
+			},
+		},
+	}
+}
+
+// newWireGuardVolumeMounts returns the volume mounts of the node container
+// needed for userspace WireGuard.
+func newWireGuardVolumeMounts(group *meshv1.NodeGroup) []corev1.VolumeMount {
+	if !group.Spec.Cluster.WireGuardMode.Unprivileged() {
+		return nil
+	}
+	return []corev1.VolumeMount{
+		{
+			Name:      wireGuardTUNVolume,
+			MountPath: wireGuardTUNDevice,
+		},
+	}
+}
+
+// meshDNSPorts returns the UDP and TCP ports MeshDNS listens on for the group.
+func meshDNSPorts(mesh *meshv1.Mesh, group *meshv1.NodeGroup) (udp, tcp int32) {
+	udp, tcp = meshv1.DefaultMeshDNSPort, meshv1.DefaultMeshDNSPort
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil || groupcfg.Services == nil || groupcfg.Services.MeshDNS == nil {
+		return
+	}
+	if port, ok := parsePort(groupcfg.Services.MeshDNS.ListenUDP); ok {
+		udp = port
+	}
+	if port, ok := parsePort(groupcfg.Services.MeshDNS.ListenTCP); ok {
+		tcp = port
+	}
+	return
+}
+
+func parsePort(addr string) (int32, bool) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, false
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return int32(p), true
+}
+
+const (
+	// nodeTLSVolume is the volume holding the TLS material for the node
+	// running in a pod. It is the only TLS volume the node container mounts.
+	nodeTLSVolume = "node-tls"
+	// nodeTLSPendingSecret is the secret the TLS volume of node pods projects
+	// until the pod webhook replaces it with the certificate secret of the pod.
+	// It never exists, so a pod the webhook did not mutate cannot start with
+	// the key of another replica.
+	nodeTLSPendingSecret = "webmesh-node-tls-pending"
+	// metricsTLSSubdirectory is the directory a dedicated metrics certificate is
+	// placed in, relative to the TLS directory.
+	metricsTLSSubdirectory = "metrics"
+)
+
+// newNodeTLSVolumes returns the volume holding the TLS material of the node
+// pods. It only ever projects the certificate secret of the pod it is mounted
+// in. The pods of a single replica group project the secret of that replica
+// directly and start without the pod webhook. Groups with more replicas share
+// one template, so they project a pending secret the pod webhook replaces with
+// that of each pod.
+func newNodeTLSVolumes(mesh *meshv1.Mesh, group *meshv1.NodeGroup) []corev1.Volume {
+	secret := meshv1.MeshNodeCertName(mesh, group, 0)
+	if injectsNodeTLSSecret(group) {
+		secret = nodeTLSPendingSecret
+	}
+	sources := []corev1.VolumeProjection{nodeTLSProjection(secret, "")}
+	return []corev1.Volume{
+		{
+			Name: nodeTLSVolume,
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources:     append(sources, newMetricsTLSProjections(mesh, group)...),
+					DefaultMode: Pointer(int32(0440)),
+				},
+			},
+		},
+	}
+}
+
+// nodeTLSProjection returns the projection of a node certificate secret into
+// the given directory of a volume.
+func nodeTLSProjection(secret, dir string) corev1.VolumeProjection {
+	path := func(key string) string {
+		if dir == "" {
+			return key
+		}
+		return fmt.Sprintf("%s/%s", dir, key)
+	}
+	return corev1.VolumeProjection{
+		Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: secret,
+			},
+			Items: []corev1.KeyToPath{
+				{Key: corev1.TLSCertKey, Path: path(corev1.TLSCertKey)},
+				{Key: corev1.TLSPrivateKeyKey, Path: path(corev1.TLSPrivateKeyKey)},
+				{Key: cmmeta.TLSCAKey, Path: path(cmmeta.TLSCAKey)},
+			},
+		},
+	}
+}
+
+// newMetricsTLSProjections returns the projection of a dedicated metrics
+// certificate, if configured.
+func newMetricsTLSProjections(mesh *meshv1.Mesh, group *meshv1.NodeGroup) []corev1.VolumeProjection {
+	metrics := metricsTLSConfig(mesh, group)
+	if metrics == nil || metrics.TLS.CertSecret == "" {
+		return nil
+	}
+	return []corev1.VolumeProjection{
+		{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: metrics.TLS.CertSecret,
+				},
+				Items: []corev1.KeyToPath{
+					{Key: corev1.TLSCertKey, Path: fmt.Sprintf("%s/%s", metricsTLSSubdirectory, corev1.TLSCertKey)},
+					{Key: corev1.TLSPrivateKeyKey, Path: fmt.Sprintf("%s/%s", metricsTLSSubdirectory, corev1.TLSPrivateKeyKey)},
+				},
+			},
+		},
+	}
+}
+
+// InjectNodeTLSSecret projects the certificate secret of the given node pod
+// into its TLS volume in place of the pending secret. The certificate secret
+// of a node is named after its pod. Pods without a pending TLS volume are left
+// unchanged.
+func InjectNodeTLSSecret(pod *corev1.Pod) error {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name != nodeTLSVolume || vol.Projected == nil {
+			continue
+		}
+		for _, source := range vol.Projected.Sources {
+			if source.Secret == nil || source.Secret.Name != nodeTLSPendingSecret {
+				continue
+			}
+			if pod.GetName() == "" {
+				return fmt.Errorf("node pod has no name to select its certificate secret by")
+			}
+			source.Secret.Name = pod.GetName()
+		}
+	}
+	return nil
+}
+
+// injectsNodeTLSSecret returns true if the certificate secret of each node pod
+// of the group is projected by the pod webhook. The webhook does not run in
+// the cluster of a kubeconfig, which is why groups there are limited to a
+// single replica.
+func injectsNodeTLSSecret(group *meshv1.NodeGroup) bool {
+	return group.Replicas() > 1
+}
+
+// PendingNodeTLSSecret returns true if the TLS volume of the given node pod
+// still projects the pending secret, because the pod webhook did not mutate
+// the pod when it was created.
+func PendingNodeTLSSecret(pod *corev1.Pod) bool {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name != nodeTLSVolume || vol.Projected == nil {
+			continue
+		}
+		for _, source := range vol.Projected.Sources {
+			if source.Secret != nil && source.Secret.Name == nodeTLSPendingSecret {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// metricsTLSConfig returns the metrics configuration of the group if metrics
+// are served over TLS, or nil otherwise.
+func metricsTLSConfig(mesh *meshv1.Mesh, group *meshv1.NodeGroup) *meshv1.NodeMetricsConfig {
+	groupcfg, err := group.MergedConfig(mesh)
+	if err != nil || groupcfg.MetricsTLS() == nil {
+		return nil
+	}
+	return groupcfg.Services.Metrics
+}
+
+// newMetricsProxyContainers returns the sidecar serving the node's metrics
+// over TLS, if enabled. The node binds its metrics to the loopback interface
+// and the proxy serves them on the configured listen address using either the
+// node's certificate or a dedicated one, both read from the in-memory TLS volume.
+func newMetricsProxyContainers(mesh *meshv1.Mesh, group *meshv1.NodeGroup, image string) []corev1.Container {
+	metrics := metricsTLSConfig(mesh, group)
+	if metrics == nil {
+		return nil
+	}
+	certDir := meshv1.DefaultTLSDirectory
+	if metrics.TLS.CertSecret != "" {
+		certDir = fmt.Sprintf("%s/%s", meshv1.DefaultTLSDirectory, metricsTLSSubdirectory)
+	}
+	args := []string{
+		"--secure-listen-address=" + metrics.ListenAddress,
+		fmt.Sprintf("--upstream=http://%s/", meshv1.DefaultMetricsUpstreamAddress),
+		fmt.Sprintf("--tls-cert-file=%s/%s", certDir, corev1.TLSCertKey),
+		fmt.Sprintf("--tls-private-key-file=%s/%s", certDir, corev1.TLSPrivateKeyKey),
+		"--logtostderr=true",
+		"--v=0",
+	}
+	switch metrics.TLS.ClientAuth {
+	case meshv1.MetricsClientAuthMTLS:
+		args = append(args, fmt.Sprintf("--client-ca-file=%s/%s", meshv1.DefaultTLSDirectory, cmmeta.TLSCAKey))
+	case meshv1.MetricsClientAuthNone, "":
+		args = append(args, "--ignore-paths="+metrics.Path)
+	}
+	var ports []corev1.ContainerPort
+	if port, ok := parsePort(metrics.ListenAddress); ok {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          "metrics",
+			ContainerPort: port,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+	return []corev1.Container{
+		{
+			Name:            "metrics-proxy",
+			Image:           image,
+			ImagePullPolicy: group.Spec.Cluster.ImagePullPolicy,
+			Args:            args,
+			Ports:           ports,
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      nodeTLSVolume,
+					MountPath: meshv1.DefaultTLSDirectory,
+					ReadOnly:  true,
+				},
+			},
+			SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: Pointer(false),
+				ReadOnlyRootFilesystem:   Pointer(true),
+				Capabilities: &corev1.Capabilities{
+					Drop: []corev1.Capability{"ALL"},
+				},
+			},
+		},
+	}
+}
+
+// BridgeMesh describes the connection of a MeshPeering bridge node to one of
+// the meshes it bridges. Each mesh is served on its own set of ports and
+// WireGuard interface.
+type BridgeMesh struct {
+	// ID is the ID of the mesh in the bridge configuration.
+	ID string
+	// Index is the position of the mesh in the bridge, used to offset ports.
+	Index int
+	// CertSecret is the name of the secret containing the bridge node's
+	// TLS material for the mesh.
+	CertSecret string
+}
+
+// CertDir returns the directory the mesh's TLS material is mounted at.
+func (b BridgeMesh) CertDir() string {
+	return fmt.Sprintf("%s/%s", meshv1.DefaultTLSDirectory, b.ID)
+}
+
+// InterfaceName returns the WireGuard interface name for the mesh.
+func (b BridgeMesh) InterfaceName() string {
+	return fmt.Sprintf("webmesh%d", b.Index)
+}
+
+// GRPCPort returns the gRPC port for the mesh.
+func (b BridgeMesh) GRPCPort() int {
+	return meshv1.DefaultGRPCPort + b.Index
+}
+
+// RaftPort returns the Raft port for the mesh.
+func (b BridgeMesh) RaftPort() int {
+	return meshv1.DefaultRaftPort + b.Index
+}
+
+// WireGuardPort returns the WireGuard port for the mesh.
+func (b BridgeMesh) WireGuardPort() int {
+	return meshv1.DefaultWireGuardPort + b.Index
+}
+
+func (b BridgeMesh) containerPorts() []corev1.ContainerPort {
+	return []corev1.ContainerPort{
+		{
+			Name:          fmt.Sprintf("grpc-%d", b.Index),
+			ContainerPort: int32(b.GRPCPort()),
+			Protocol:      corev1.ProtocolTCP,
+		},
+		{
+			Name:          fmt.Sprintf("raft-%d", b.Index),
+			ContainerPort: int32(b.RaftPort()),
+			Protocol:      corev1.ProtocolTCP,
+		},
+		{
+			Name:          fmt.Sprintf("wireguard-%d", b.Index),
+			ContainerPort: int32(b.WireGuardPort()),
+			Protocol:      corev1.ProtocolUDP,
+		},
+	}
+}
+
+// NewMeshPeeringStatefulSet returns a new statefulset for the bridge node of a
+// MeshPeering.
+func NewMeshPeeringStatefulSet(peering *meshv1.MeshPeering, image string, meshes []BridgeMesh, configChecksum string) *appsv1.StatefulSet {
+	var ports []corev1.ContainerPort
+	var sources []corev1.VolumeProjection
+	var interfaces []string
+	for _, mesh := range meshes {
+		ports = append(ports, mesh.containerPorts()...)
+		interfaces = append(interfaces, fmt.Sprintf("ip link show %s", mesh.InterfaceName()))
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: mesh.CertSecret,
+				},
+				Items: []corev1.KeyToPath{
+					{Key: corev1.TLSCertKey, Path: fmt.Sprintf("%s/%s", mesh.ID, corev1.TLSCertKey)},
+					{Key: corev1.TLSPrivateKeyKey, Path: fmt.Sprintf("%s/%s", mesh.ID, corev1.TLSPrivateKeyKey)},
+					{Key: cmmeta.TLSCAKey, Path: fmt.Sprintf("%s/%s", mesh.ID, cmmeta.TLSCAKey)},
+				},
 			},
+		})
+	}
+	return &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "StatefulSet",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            meshv1.MeshPeeringBridgeName(peering),
+			Namespace:       peering.GetNamespace(),
+			Labels:          meshv1.MeshPeeringLabels(peering),
+			OwnerReferences: meshv1.OwnerReferences(peering),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: Pointer(int32(1)),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: meshv1.MeshPeeringSelector(peering),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: meshv1.MeshPeeringLabels(peering),
+					Annotations: map[string]string{
+						meshv1.ConfigChecksumAnnotation: configChecksum,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "bridge",
+							Image: image,
+							Args:  []string{"--config", meshv1.DefaultConfigPath},
+							// Surface startup errors in the container status
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+							Env: []corev1.EnvVar{
+								{
+									Name: "POD_NAME",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{
+											FieldPath: "metadata.name",
+										},
+									},
+								},
+							},
+							Ports: ports,
+							// The WireGuard interface for a mesh is only created
+							// once the bridge has joined it.
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									Exec: &corev1.ExecAction{
+										Command: []string{"/bin/sh", "-c", strings.Join(interfaces, " && ")},
+									},
+								},
+								PeriodSeconds: 10,
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "config",
+									MountPath: meshv1.DefaultConfigDirectory,
+								},
+								{
+									Name:      nodeTLSVolume,
+									MountPath: meshv1.DefaultTLSDirectory,
+									ReadOnly:  true,
+								},
+							},
+							SecurityContext: &corev1.SecurityContext{
+								Capabilities: &corev1.Capabilities{
+									Add: []corev1.Capability{
+										"NET_ADMIN",
+										"NET_RAW",
+										"SYS_MODULE",
+									},
+								},
+								RunAsUser:    Pointer(int64(0)),
+								RunAsGroup:   Pointer(int64(0)),
+								Privileged:   Pointer(true),
+								RunAsNonRoot: Pointer(false),
+								SeccompProfile: &corev1.SeccompProfile{
+									Type: corev1.SeccompProfileTypeRuntimeDefault,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: meshv1.MeshPeeringBridgeName(peering),
+									},
+								},
+							},
+						},
+						{
+							Name: nodeTLSVolume,
+							VolumeSource: corev1.VolumeSource{
+								Projected: &corev1.ProjectedVolumeSource{
+									Sources:     sources,
+									DefaultMode: Pointer(int32(0400)),
+								},
+							},
+						},
+					},
+					TerminationGracePeriodSeconds: Pointer(int64(60)),
+				},
+			},
+			ServiceName:         meshv1.MeshPeeringBridgeName(peering),
+			PodManagementPolicy: appsv1.ParallelPodManagement,
 		},
 	}
 }
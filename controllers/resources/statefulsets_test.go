@@ -0,0 +1,686 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/nodeconfig"
+)
+
+func TestNodeGroupStatefulSetTLSVolumes(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	newGroup := func(kubeconfig *corev1.SecretKeySelector) *meshv1.NodeGroup {
+		return &meshv1.NodeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+			Spec: meshv1.NodeGroupSpec{
+				Image:    meshv1.DefaultNodeImage,
+				Replicas: Pointer(int32(3)),
+				Cluster:  &meshv1.NodeGroupClusterConfig{Kubeconfig: kubeconfig},
+			},
+		}
+	}
+	volumeSecrets := func(vol corev1.Volume) []string {
+		var names []string
+		for _, source := range vol.Projected.Sources {
+			names = append(names, source.Secret.Name)
+		}
+		return names
+	}
+
+	t.Run("local cluster", func(t *testing.T) {
+		group := newGroup(nil)
+		sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+		if sts.Spec.Template.Labels[meshv1.NodeTLSInjectionLabel] != "true" {
+			t.Fatalf("expected the pods to be labeled for the pod webhook")
+		}
+		if len(sts.Spec.Template.Spec.InitContainers) != 0 {
+			t.Fatalf("expected no init containers, got %d", len(sts.Spec.Template.Spec.InitContainers))
+		}
+		// Each pod must only project its own certificate secret once the webhook ran.
+		for i, obj := range StatefulSetReplicas(sts) {
+			pod := obj.(*corev1.Pod)
+			if err := InjectNodeTLSSecret(pod); err != nil {
+				t.Fatalf("ordinal %d: %v", i, err)
+			}
+			if PendingNodeTLSSecret(pod) {
+				t.Errorf("ordinal %d: expected the pending secret to be replaced", i)
+			}
+			var tlsVolumes int
+			for _, vol := range pod.Spec.Volumes {
+				if vol.Secret != nil {
+					t.Errorf("ordinal %d: unexpected secret volume %q", i, vol.Name)
+				}
+				if vol.Projected == nil {
+					continue
+				}
+				tlsVolumes++
+				want := []string{meshv1.MeshNodeCertName(mesh, group, i)}
+				if got := volumeSecrets(vol); !reflect.DeepEqual(got, want) {
+					t.Errorf("ordinal %d: expected secrets %v, got %v", i, want, got)
+				}
+			}
+			if tlsVolumes != 1 {
+				t.Errorf("ordinal %d: expected one TLS volume, got %d", i, tlsVolumes)
+			}
+		}
+		// Pods the webhook did not mutate must not start with another pod's key.
+		if got := volumeSecrets(sts.Spec.Template.Spec.Volumes[1]); !reflect.DeepEqual(got, []string{nodeTLSPendingSecret}) {
+			t.Errorf("expected the template to project only %q, got %v", nodeTLSPendingSecret, got)
+		}
+		unnamed := &corev1.Pod{Spec: *sts.Spec.Template.Spec.DeepCopy()}
+		if !PendingNodeTLSSecret(unnamed) {
+			t.Errorf("expected a pod the webhook did not mutate to be pending")
+		}
+		if err := InjectNodeTLSSecret(unnamed); err == nil {
+			t.Errorf("expected an error for a pod without a name")
+		}
+	})
+
+	// Single replica groups project their own certificate secret without the
+	// webhook. Groups in a remote cluster, where the webhook does not run, are
+	// limited to a single replica.
+	for name, kubeconfig := range map[string]*corev1.SecretKeySelector{
+		"single replica":                   nil,
+		"single replica in remote cluster": {Key: "kubeconfig"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			group := newGroup(kubeconfig)
+			group.Spec.Replicas = Pointer(int32(1))
+			sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+			if _, ok := sts.Spec.Template.Labels[meshv1.NodeTLSInjectionLabel]; ok {
+				t.Fatalf("expected the pods not to be labeled for the pod webhook")
+			}
+			if len(sts.Spec.Template.Spec.InitContainers) != 0 {
+				t.Fatalf("expected no init containers, got %d", len(sts.Spec.Template.Spec.InitContainers))
+			}
+			var secrets []string
+			for _, vol := range sts.Spec.Template.Spec.Volumes {
+				if vol.Secret != nil {
+					t.Errorf("unexpected secret volume %q", vol.Name)
+				}
+				if vol.Projected != nil {
+					secrets = append(secrets, volumeSecrets(vol)...)
+				}
+			}
+			if want := []string{meshv1.MeshNodeCertName(mesh, group, 0)}; !reflect.DeepEqual(secrets, want) {
+				t.Errorf("expected secrets %v, got %v", want, secrets)
+			}
+			pod := &corev1.Pod{Spec: *sts.Spec.Template.Spec.DeepCopy()}
+			if PendingNodeTLSSecret(pod) {
+				t.Errorf("expected the pod not to wait for the webhook")
+			}
+		})
+	}
+}
+
+func TestNodeGroupStatefulSetNilReplicas(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{
+			Image: meshv1.DefaultNodeImage,
+			Bootstrap: meshv1.NodeGroupSpec{
+				Image:    meshv1.DefaultNodeImage,
+				Replicas: Pointer(int32(3)),
+				Cluster: &meshv1.NodeGroupClusterConfig{
+					Service: &meshv1.NodeGroupLBConfig{},
+				},
+			},
+		},
+	}
+	groups := mesh.BootstrapGroups()
+	if len(groups) != 2 {
+		t.Fatalf("expected a bootstrap and LB group, got %d groups", len(groups))
+	}
+	lb := groups[1]
+	if lb.Spec.Replicas == nil || *lb.Spec.Replicas != 1 {
+		t.Fatalf("expected the LB group to be defaulted to a single replica")
+	}
+	// Groups that have not been defaulted yet must still render
+	lb.Spec.Replicas = nil
+	for i := 0; i < int(lb.Replicas()); i++ {
+		_ = NewNodeCertificate(mesh, lb, i, meshv1.DefaultClusterDomain)
+	}
+	_ = NewNodeGroupHeadlessService(mesh, lb, meshv1.ServiceIPFamilies{})
+	_ = NewNodeGroupLBService(mesh, lb, meshv1.ServiceIPFamilies{})
+	sts := NewNodeGroupStatefulSet(mesh, lb, meshv1.DefaultMetricsProxyImage, "checksum")
+	if got := *sts.Spec.Replicas; got != 1 {
+		t.Fatalf("expected 1 replica, got %d", got)
+	}
+}
+
+func TestNodeGroupStatefulSetDNSProfile(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Image:   meshv1.DefaultNodeImage,
+			Profile: meshv1.NodeGroupProfileDNS,
+			Cluster: &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+	node := sts.Spec.Template.Spec.Containers[0]
+	ports := make(map[string]int32)
+	for _, port := range node.Ports {
+		ports[port.Name] = port.ContainerPort
+	}
+	if _, ok := ports["wireguard"]; ok {
+		t.Errorf("expected no wireguard port for dns profile")
+	}
+	if ports["dns-udp"] != meshv1.DefaultMeshDNSPort || ports["dns-tcp"] != meshv1.DefaultMeshDNSPort {
+		t.Errorf("expected dns ports on %d, got %v", meshv1.DefaultMeshDNSPort, ports)
+	}
+	if ctx := node.SecurityContext; ctx.Privileged == nil || *ctx.Privileged {
+		t.Errorf("expected dns profile to run unprivileged")
+	}
+	for _, cap := range node.SecurityContext.Capabilities.Add {
+		if cap == "SYS_MODULE" || cap == "NET_RAW" {
+			t.Errorf("unexpected capability %q for dns profile", cap)
+		}
+	}
+}
+
+func TestNodeGroupRaftPorts(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	tc := []struct {
+		name  string
+		group meshv1.NodeGroupSpec
+		raft  bool
+	}{
+		{
+			name:  "voter",
+			group: meshv1.NodeGroupSpec{Config: &meshv1.NodeGroupConfig{Voter: true}},
+			raft:  true,
+		},
+		{
+			name:  "observer",
+			group: meshv1.NodeGroupSpec{Profile: meshv1.NodeGroupProfileDNS},
+			raft:  true,
+		},
+		{
+			name:  "non-member",
+			group: meshv1.NodeGroupSpec{},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec:       tt.group,
+			}
+			group.Spec.Image = meshv1.DefaultNodeImage
+			group.Spec.Cluster = &meshv1.NodeGroupClusterConfig{Service: &meshv1.NodeGroupLBConfig{}}
+
+			sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+			var containerPort bool
+			for _, port := range sts.Spec.Template.Spec.Containers[0].Ports {
+				containerPort = containerPort || port.Name == "raft"
+			}
+			if containerPort != tt.raft {
+				t.Errorf("expected raft container port %v, got %v", tt.raft, containerPort)
+			}
+			hasRaft := func(svc *corev1.Service) bool {
+				for _, port := range svc.Spec.Ports {
+					if port.Name == "raft" {
+						return true
+					}
+				}
+				return false
+			}
+			if got := hasRaft(NewNodeGroupHeadlessService(mesh, group, meshv1.ServiceIPFamilies{})); got != tt.raft {
+				t.Errorf("expected raft headless service port %v, got %v", tt.raft, got)
+			}
+			if hasRaft(NewNodeGroupLBService(mesh, group, meshv1.ServiceIPFamilies{})) {
+				t.Errorf("expected no raft port on the load balancer service")
+			}
+		})
+	}
+}
+
+func TestNodeGroupStatefulSetWireGuardMode(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	tc := []struct {
+		mode       meshv1.WireGuardMode
+		privileged bool
+		mountsTUN  bool
+	}{
+		{mode: "", privileged: true},
+		{mode: meshv1.WireGuardModeKernel, privileged: true},
+		{mode: meshv1.WireGuardModeUserspace, mountsTUN: true},
+		{mode: meshv1.WireGuardModeAuto, mountsTUN: true},
+	}
+	for _, c := range tc {
+		t.Run(string(c.mode), func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: meshv1.NodeGroupSpec{
+					Image:   meshv1.DefaultNodeImage,
+					Cluster: &meshv1.NodeGroupClusterConfig{WireGuardMode: c.mode},
+				},
+			}
+			spec := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum").Spec.Template.Spec
+			node := spec.Containers[0]
+			if got := *node.SecurityContext.Privileged; got != c.privileged {
+				t.Errorf("expected privileged %v, got %v", c.privileged, got)
+			}
+			var sysModule bool
+			for _, cap := range node.SecurityContext.Capabilities.Add {
+				sysModule = sysModule || cap == "SYS_MODULE"
+			}
+			if sysModule != c.privileged {
+				t.Errorf("expected SYS_MODULE %v, got %v", c.privileged, node.SecurityContext.Capabilities)
+			}
+			// The node falls back to userspace on its own, so the image's
+			// entrypoint is used without an init container in every mode.
+			if len(spec.InitContainers) != 0 || len(node.Command) != 0 {
+				t.Errorf("expected no init containers or command, got %v and %v", spec.InitContainers, node.Command)
+			}
+			var volumes []string
+			for _, vol := range spec.Volumes {
+				if vol.HostPath != nil {
+					volumes = append(volumes, vol.Name)
+				}
+			}
+			if tun := len(volumes) == 1 && volumes[0] == wireGuardTUNVolume; tun != c.mountsTUN {
+				t.Errorf("expected the TUN volume %v, got %v", c.mountsTUN, volumes)
+			}
+			var mountsTUN bool
+			for _, mount := range node.VolumeMounts {
+				mountsTUN = mountsTUN || mount.MountPath == wireGuardTUNDevice
+			}
+			if mountsTUN != c.mountsTUN {
+				t.Errorf("expected %s mounted %v, got %v", wireGuardTUNDevice, c.mountsTUN, mountsTUN)
+			}
+		})
+	}
+}
+
+func TestNodeGroupStatefulSetConfigStorage(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	tc := []struct {
+		storage   meshv1.ConfigStorageType
+		configMap bool
+	}{
+		{storage: meshv1.ConfigStorageConfigMap, configMap: true},
+		{storage: meshv1.ConfigStorageSecret, configMap: false},
+	}
+	for _, tt := range tc {
+		t.Run(string(tt.storage), func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: meshv1.NodeGroupSpec{
+					Image:   meshv1.DefaultNodeImage,
+					Cluster: &meshv1.NodeGroupClusterConfig{ConfigStorage: tt.storage},
+				},
+			}
+			sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+			var config *corev1.Volume
+			for i, vol := range sts.Spec.Template.Spec.Volumes {
+				if vol.Name == "config" {
+					config = &sts.Spec.Template.Spec.Volumes[i]
+				}
+			}
+			if config == nil {
+				t.Fatalf("expected config volume")
+			}
+			name := meshv1.MeshNodeGroupConfigMapName(mesh, group)
+			if tt.configMap {
+				if config.ConfigMap == nil || config.ConfigMap.Name != name {
+					t.Errorf("expected config volume from configmap %q, got %+v", name, config.VolumeSource)
+				}
+				return
+			}
+			if config.Secret == nil || config.Secret.SecretName != name {
+				t.Errorf("expected config volume from secret %q, got %+v", name, config.VolumeSource)
+			}
+		})
+	}
+}
+
+func TestNodeGroupMetadataPropagation(t *testing.T) {
+	lastApplied := "kubectl.kubernetes.io/last-applied-configuration"
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec: meshv1.MeshSpec{
+			PropagateMetadata: &meshv1.MetadataPropagation{Annotations: []string{"example.com/owner"}},
+		},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "group",
+			Namespace: "default",
+			Annotations: map[string]string{
+				lastApplied:         `{"kind":"NodeGroup"}`,
+				"example.com/owner": "team",
+			},
+		},
+		Spec: meshv1.NodeGroupSpec{
+			Image:   meshv1.DefaultNodeImage,
+			Cluster: &meshv1.NodeGroupClusterConfig{},
+		},
+	}
+	for _, obj := range []client.Object{
+		NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum"),
+		NewNodeGroupConfigMap(mesh, group, &nodeconfig.Config{}),
+		NewNodeGroupConfigSecret(mesh, group, &nodeconfig.Config{}),
+	} {
+		annotations := obj.GetAnnotations()
+		if _, ok := annotations[lastApplied]; ok {
+			t.Errorf("expected the last applied configuration not to be copied onto %T", obj)
+		}
+		if annotations["example.com/owner"] != "team" {
+			t.Errorf("expected the declared annotation to be copied onto %T, got %v", obj, annotations)
+		}
+	}
+	if _, ok := group.GetAnnotations()[meshv1.ConfigChecksumAnnotation]; ok {
+		t.Errorf("expected the annotations of the group to be left unchanged")
+	}
+}
+
+func TestNodeGroupStatefulSetDedicatedNodes(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "group",
+			Namespace:   "default",
+			Annotations: map[string]string{meshv1.BootstrapNodeGroupAnnotation: "true"},
+		},
+		Spec: meshv1.NodeGroupSpec{
+			Image: meshv1.DefaultNodeImage,
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				NodeSelector: map[string]string{"kubernetes.io/arch": "amd64"},
+				Tolerations: []corev1.Toleration{
+					{Key: "example.com/spot", Operator: corev1.TolerationOpExists},
+				},
+				DedicatedNodes: &meshv1.NodeGroupDedicatedNodesConfig{
+					TaintKey: "webmesh.io/dedicated",
+					Label:    "webmesh.io/pool=storage",
+				},
+			},
+		},
+	}
+	sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+	podspec := sts.Spec.Template.Spec
+	wantSelector := map[string]string{
+		"kubernetes.io/arch": "amd64",
+		"webmesh.io/pool":    "storage",
+	}
+	if !reflect.DeepEqual(podspec.NodeSelector, wantSelector) {
+		t.Errorf("expected node selector %v, got %v", wantSelector, podspec.NodeSelector)
+	}
+	if len(podspec.Tolerations) != 2 {
+		t.Fatalf("expected 2 tolerations, got %+v", podspec.Tolerations)
+	}
+	if toleration := podspec.Tolerations[1]; toleration.Key != "webmesh.io/dedicated" ||
+		toleration.Operator != corev1.TolerationOpExists || toleration.Effect != "" {
+		t.Errorf("expected toleration of any dedicated taint, got %+v", toleration)
+	}
+	if val := sts.Spec.Template.Annotations[safeToEvictAnnotation]; val != "false" {
+		t.Errorf("expected bootstrap pods to not be safe to evict, got %q", val)
+	}
+	// The spec of the group is left untouched
+	if len(group.Spec.Cluster.NodeSelector) != 1 || len(group.Spec.Cluster.Tolerations) != 1 {
+		t.Errorf("expected group spec to be unchanged, got %+v", group.Spec.Cluster)
+	}
+
+	// Other groups may be evicted
+	group.Annotations = nil
+	sts = NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+	if _, ok := sts.Spec.Template.Annotations[safeToEvictAnnotation]; ok {
+		t.Errorf("expected no safe-to-evict annotation for non-bootstrap groups")
+	}
+}
+
+func TestNodeGroupStatefulSetMetricsProxy(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	tc := []struct {
+		name    string
+		tls     *meshv1.NodeMetricsTLSConfig
+		proxy   bool
+		certDir string
+		arg     string
+	}{
+		{name: "plaintext", tls: nil, proxy: false},
+		{
+			name:    "node cert",
+			tls:     &meshv1.NodeMetricsTLSConfig{ClientAuth: meshv1.MetricsClientAuthNone},
+			proxy:   true,
+			certDir: meshv1.DefaultTLSDirectory,
+			arg:     "--ignore-paths=/metrics",
+		},
+		{
+			name:    "dedicated cert",
+			tls:     &meshv1.NodeMetricsTLSConfig{CertSecret: "metrics-tls", ClientAuth: meshv1.MetricsClientAuthMTLS},
+			proxy:   true,
+			certDir: meshv1.DefaultTLSDirectory + "/" + metricsTLSSubdirectory,
+			arg:     "--client-ca-file=" + meshv1.DefaultTLSDirectory + "/ca.crt",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &meshv1.NodeGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+				Spec: meshv1.NodeGroupSpec{
+					Image:   meshv1.DefaultNodeImage,
+					Cluster: &meshv1.NodeGroupClusterConfig{},
+					Config: &meshv1.NodeGroupConfig{
+						Services: &meshv1.NodeServicesConfig{
+							Metrics: &meshv1.NodeMetricsConfig{ListenAddress: ":8080", Path: "/metrics", TLS: tt.tls},
+						},
+					},
+				},
+			}
+			mirror := "registry.example.com/kube-rbac-proxy:v0.14.2"
+			podspec := NewNodeGroupStatefulSet(mesh, group, mirror, "checksum").Spec.Template.Spec
+			var proxy *corev1.Container
+			for i, container := range podspec.Containers {
+				if container.Name == "metrics-proxy" {
+					proxy = &podspec.Containers[i]
+				}
+			}
+			if !tt.proxy {
+				if proxy != nil {
+					t.Fatalf("expected no metrics proxy")
+				}
+				return
+			}
+			if proxy == nil {
+				t.Fatalf("expected metrics proxy")
+			}
+			if proxy.Image != mirror {
+				t.Errorf("expected the metrics proxy image %s, got %s", mirror, proxy.Image)
+			}
+			args := strings.Join(proxy.Args, " ")
+			for _, want := range []string{
+				"--secure-listen-address=:8080",
+				"--upstream=http://" + meshv1.DefaultMetricsUpstreamAddress + "/",
+				"--tls-cert-file=" + tt.certDir + "/tls.crt",
+				tt.arg,
+			} {
+				if !strings.Contains(args, want) {
+					t.Errorf("expected arg %q in %q", want, args)
+				}
+			}
+			if len(proxy.Ports) != 1 || proxy.Ports[0].ContainerPort != 8080 {
+				t.Errorf("expected metrics port 8080, got %+v", proxy.Ports)
+			}
+			if tt.tls.CertSecret == "" {
+				return
+			}
+			var projected bool
+			for _, vol := range podspec.Volumes {
+				if vol.Name != nodeTLSVolume {
+					continue
+				}
+				for _, source := range vol.Projected.Sources {
+					projected = projected || source.Secret.Name == tt.tls.CertSecret
+				}
+			}
+			if !projected {
+				t.Errorf("expected the TLS volume to project the metrics certificate")
+			}
+		})
+	}
+}
+
+func TestStatefulSetReplicas(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+		Spec:       meshv1.MeshSpec{Image: meshv1.DefaultNodeImage},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Image:    meshv1.DefaultNodeImage,
+			Replicas: Pointer(int32(2)),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				PVCSpec: &corev1.PersistentVolumeClaimSpec{},
+			},
+		},
+	}
+	sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+	objs := StatefulSetReplicas(sts)
+	if len(objs) != 4 {
+		t.Fatalf("expected a pod and claim for each replica, got %d objects", len(objs))
+	}
+	for i := 0; i < 2; i++ {
+		podName := meshv1.MeshNodeGroupPodName(mesh, group, i)
+		claim, ok := objs[i*2].(*corev1.PersistentVolumeClaim)
+		if !ok {
+			t.Fatalf("expected a claim, got %T", objs[i*2])
+		}
+		if claim.GetName() != "data-"+podName {
+			t.Fatalf("expected claim data-%s, got %s", podName, claim.GetName())
+		}
+		pod, ok := objs[i*2+1].(*corev1.Pod)
+		if !ok {
+			t.Fatalf("expected a pod, got %T", objs[i*2+1])
+		}
+		if pod.GetName() != podName || pod.GetNamespace() != "default" {
+			t.Fatalf("expected pod default/%s, got %s/%s", podName, pod.GetNamespace(), pod.GetName())
+		}
+		if pod.Spec.Subdomain != sts.Spec.ServiceName {
+			t.Fatalf("expected subdomain %s, got %s", sts.Spec.ServiceName, pod.Spec.Subdomain)
+		}
+		var mounted bool
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim.GetName() {
+				mounted = true
+			}
+		}
+		if !mounted {
+			t.Fatalf("expected pod %s to use claim %s", podName, claim.GetName())
+		}
+	}
+	if _, ok := sts.Spec.Template.Labels["statefulset.kubernetes.io/pod-name"]; ok {
+		t.Fatalf("expected the template of the statefulset not to be modified")
+	}
+}
+
+func TestAdoptStatefulSet(t *testing.T) {
+	mesh := &meshv1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "default"},
+		Spec: meshv1.NodeGroupSpec{
+			Image:    meshv1.DefaultNodeImage,
+			Replicas: Pointer(int32(3)),
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				PVCSpec: &corev1.PersistentVolumeClaimSpec{},
+				AdoptExisting: &meshv1.NodeGroupAdoptConfig{
+					StatefulSetName: "webmesh",
+					ConfigMapName:   "webmesh-config",
+				},
+			},
+		},
+	}
+	existing := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "webmesh", Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "webmesh"},
+			},
+			ServiceName:         "webmesh",
+			PodManagementPolicy: appsv1.OrderedReadyPodManagement,
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "raft"}},
+			},
+		},
+	}
+	sts := NewNodeGroupStatefulSet(mesh, group, meshv1.DefaultMetricsProxyImage, "checksum")
+	svc := NewNodeGroupHeadlessService(mesh, group, meshv1.ServiceIPFamilies{})
+	AdoptStatefulSet(existing, []client.Object{sts, svc})
+
+	if sts.GetName() != "webmesh" || svc.GetName() != "webmesh" {
+		t.Fatalf("expected the adopted names, got statefulset %q and service %q", sts.GetName(), svc.GetName())
+	}
+	if !reflect.DeepEqual(sts.Spec.Selector, existing.Spec.Selector) {
+		t.Errorf("expected the existing selector, got %v", sts.Spec.Selector)
+	}
+	if sts.Spec.PodManagementPolicy != appsv1.OrderedReadyPodManagement {
+		t.Errorf("expected the existing pod management policy, got %q", sts.Spec.PodManagementPolicy)
+	}
+	if len(sts.Spec.VolumeClaimTemplates) != 1 || sts.Spec.VolumeClaimTemplates[0].GetName() != "raft" {
+		t.Errorf("expected the existing volume claim templates, got %v", sts.Spec.VolumeClaimTemplates)
+	}
+	// Both existing and replaced pods must be selected
+	if sts.Spec.Template.Labels["app"] != "webmesh" {
+		t.Errorf("expected the template to carry the existing selector, got %v", sts.Spec.Template.Labels)
+	}
+	if sts.Spec.Template.Labels[meshv1.NodeGroupNameLabel] != "group" {
+		t.Errorf("expected the template to keep the group labels, got %v", sts.Spec.Template.Labels)
+	}
+	if !reflect.DeepEqual(svc.Spec.Selector, existing.Spec.Selector.MatchLabels) {
+		t.Errorf("expected the service to use the existing selector, got %v", svc.Spec.Selector)
+	}
+	var mounted bool
+	for _, mount := range sts.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.MountPath == meshv1.DefaultDataDirectory {
+			mounted = mount.Name == "raft"
+		}
+	}
+	if !mounted {
+		t.Errorf("expected the data directory to be mounted from the existing claim")
+	}
+	if existing.Spec.Selector.MatchLabels[meshv1.NodeGroupNameLabel] != "" {
+		t.Errorf("expected the existing statefulset not to be modified")
+	}
+}
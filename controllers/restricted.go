@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/resources"
+)
+
+// restrictedRequeueInterval is how often an object is retried after the
+// operator was forbidden from managing some of its resources.
+const restrictedRequeueInterval = 5 * time.Minute
+
+// reconcileRestricted records the outcome of a reconcile in the Restricted
+// condition of the object. Forbidden errors mean the operator is running with a
+// role that does not cover the object's resources, so instead of being retried
+// with backoff they are surfaced in the condition and retried after a longer
+// interval. Other errors are returned untouched.
+func reconcileRestricted(ctx context.Context, cli client.Client, obj client.Object, conditions *[]metav1.Condition, res ctrl.Result, err error) (ctrl.Result, error) {
+	condition := metav1.Condition{
+		Type:               meshv1.RestrictedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             "Permitted",
+		Message:            "The operator is permitted to manage all resources",
+	}
+	switch {
+	case err == nil:
+	case apierrors.IsForbidden(err):
+		log.FromContext(ctx).Info("Forbidden from managing resources, requeueing", "error", err.Error())
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = string(metav1.StatusReasonForbidden)
+		condition.Message = err.Error()
+		if res.RequeueAfter == 0 || restrictedRequeueInterval < res.RequeueAfter {
+			res.RequeueAfter = restrictedRequeueInterval
+		}
+		err = nil
+	default:
+		return res, err
+	}
+	current := meta.FindStatusCondition(*conditions, condition.Type)
+	if current == nil && condition.Status == metav1.ConditionFalse {
+		// Only record the condition once the object has been restricted
+		return res, nil
+	}
+	if current == nil || current.Status != condition.Status ||
+		current.Message != condition.Message || current.ObservedGeneration != condition.ObservedGeneration {
+		meta.SetStatusCondition(conditions, condition)
+		if err := cli.Status().Update(ctx, obj); err != nil {
+			return res, fmt.Errorf("update restricted condition: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// applyOutsideNamespace applies objects that live outside of the given namespace,
+// such as cluster issuers. When namespaced is true, or the operator is forbidden
+// from managing an object, it is skipped and a forbidden error for it is returned
+// once the others have been applied.
+func applyOutsideNamespace(ctx context.Context, cli client.Client, namespaced bool, objs []client.Object) error {
+	var errs []error
+	for _, obj := range objs {
+		if namespaced {
+			gvk := obj.GetObjectKind().GroupVersionKind()
+			errs = append(errs, apierrors.NewForbidden(
+				schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind},
+				obj.GetName(),
+				errors.New("the operator is restricted to namespaces and does not manage resources outside of them"),
+			))
+			continue
+		}
+		err := resources.Apply(ctx, cli, []client.Object{obj})
+		if err != nil {
+			if !apierrors.IsForbidden(err) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// partitionNamespace splits the given objects into those in the given namespace
+// and those outside of it.
+func partitionNamespace(objs []client.Object, namespace string) (inside, outside []client.Object) {
+	for _, obj := range objs {
+		if obj.GetNamespace() == namespace {
+			inside = append(inside, obj)
+			continue
+		}
+		outside = append(outside, obj)
+	}
+	return inside, outside
+}
@@ -18,116 +18,348 @@ package controllers
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
-	"net/netip"
+	"net"
+	"strconv"
+	"time"
 
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"google.golang.org/api/option"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/inspect"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+	"github.com/webmeshproj/operator/controllers/resources"
 )
 
-var ErrLBNotReady = errors.New("load balancer not ready")
+var ErrLBNotReady = inspect.ErrLBNotReady
 
 func getLBExternalIPs(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, group *meshv1.NodeGroup) ([]string, error) {
+	name := meshv1.MeshNodeGroupLBName(mesh, group)
+	if group.ExposedPerReplica() {
+		// Groups exposed per replica are reached through their first node
+		name = meshv1.MeshNodeGroupReplicaLBName(mesh, group, 0)
+	}
 	var lbService corev1.Service
 	err := cli.Get(ctx, client.ObjectKey{
-		Name:      meshv1.MeshNodeGroupLBName(mesh, group),
+		Name:      name,
 		Namespace: mesh.GetNamespace(),
 	}, &lbService)
 	if err != nil {
 		return nil, fmt.Errorf("fetch load balancer service: %w", err)
 	}
-	var externalIPs []string
-	switch lbService.Spec.Type {
-	case corev1.ServiceTypeLoadBalancer:
-		if len(lbService.Status.LoadBalancer.Ingress) == 0 {
-			return nil, ErrLBNotReady
-		}
-		for _, ingress := range lbService.Status.LoadBalancer.Ingress {
-			if ingress.IP == "" {
-				return nil, ErrLBNotReady
-			}
-			externalIPs = append(externalIPs, ingress.IP)
-		}
-		for _, ip := range lbService.Spec.ClusterIPs {
-			addr, err := netip.ParseAddr(ip)
-			if err != nil {
-				return nil, fmt.Errorf("parse cluster IP: %w", err)
-			}
-			if !addr.IsPrivate() {
-				externalIPs = append(externalIPs, addr.String())
-			}
+	return inspect.LBExternalIPs(&lbService)
+}
+
+// joinServerProbeTimeout bounds each probe of a join server candidate.
+const joinServerProbeTimeout = 2 * time.Second
+
+// getJoinServer returns the address nodes of thisGroup should join the mesh
+// through. Each candidate is probed with a TLS handshake against the mesh CA
+// and the first one that answers is used and recorded in the status of the
+// group, unless probes are disabled in the operator settings.
+func getJoinServer(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, thisGroup *meshv1.NodeGroup) (string, error) {
+	candidates, err := getJoinServerCandidates(ctx, cli, mesh, thisGroup)
+	if err != nil {
+		return "", err
+	}
+	joinServer, err := selectJoinServer(ctx, cli, mesh, candidates)
+	if err != nil {
+		return "", err
+	}
+	if thisGroup.Status.JoinServer != joinServer {
+		thisGroup.Status.JoinServer = joinServer
+		if err := cli.Status().Update(ctx, thisGroup); err != nil {
+			return "", fmt.Errorf("update join server status: %w", err)
 		}
-	case corev1.ServiceTypeNodePort:
-		return nil, fmt.Errorf("node port not supported")
-	case corev1.ServiceTypeClusterIP:
-		for _, ip := range lbService.Spec.ClusterIPs {
-			addr, err := netip.ParseAddr(ip)
-			if err != nil {
-				return nil, fmt.Errorf("parse cluster IP: %w", err)
-			}
-			if !addr.IsPrivate() {
-				externalIPs = append(externalIPs, addr.String())
+	}
+	return joinServer, nil
+}
+
+// getClusterJoinServer returns the address a node running in the cluster, but
+// outside of any node group, should join the mesh through. The load balancers
+// of exposed bootstrap groups are preferred over their headless services.
+func getClusterJoinServer(ctx context.Context, cli client.Client, mesh *meshv1.Mesh) (string, error) {
+	groups, err := listBootstrapGroups(ctx, cli, mesh)
+	if err != nil {
+		return "", err
+	}
+	candidates, _ := getLBJoinServers(ctx, cli, mesh, groups, "")
+	clusterDomain := operatorconfig.FromContext(ctx).ClusterDomain
+	for _, group := range groups {
+		candidates = append(candidates, fmt.Sprintf(`%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, &group, clusterDomain), meshv1.DefaultGRPCPort))
+	}
+	return selectJoinServer(ctx, cli, mesh, candidates)
+}
+
+// selectJoinServer returns the first of the candidates that answers a probe,
+// or the first candidate when probes are disabled in the operator settings.
+func selectJoinServer(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, candidates []string) (string, error) {
+	if operatorconfig.FromContext(ctx).SkipJoinServerProbe {
+		return candidates[0], nil
+	}
+	return probeJoinServers(ctx, cli, mesh, candidates)
+}
+
+// getJoinServerCandidates returns the addresses thisGroup could join the mesh
+// through in order of preference. The load balancers of exposed bootstrap
+// groups come first. Bootstrap groups fall back to the headless services of
+// the other bootstrap groups.
+func getJoinServerCandidates(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, thisGroup *meshv1.NodeGroup) ([]string, error) {
+	bootstrapGroups, err := listBootstrapGroups(ctx, cli, mesh)
+	if err != nil {
+		return nil, err
+	}
+	candidates, lbErr := getLBJoinServers(ctx, cli, mesh, bootstrapGroups, thisGroup.Name)
+	// Fall back to headless service only if this is one of the bootstrap groups
+	if bootstrap, _ := meshv1.LabelValue(thisGroup.GetLabels(), meshv1.BootstrapNodeGroupLabel); bootstrap == "true" {
+		clusterDomain := operatorconfig.FromContext(ctx).ClusterDomain
+		for _, group := range bootstrapGroups {
+			if group.Name == thisGroup.Name {
+				continue
 			}
+			candidates = append(candidates, fmt.Sprintf(`%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, &group, clusterDomain), meshv1.DefaultGRPCPort))
 		}
-		clusterIP, err := netip.ParseAddr(lbService.Spec.ClusterIP)
-		if err != nil {
-			return nil, fmt.Errorf("parse cluster IP: %w", err)
-		}
-		if !clusterIP.IsPrivate() {
-			externalIPs = append(externalIPs, clusterIP.String())
-		}
-	default:
-		return nil, fmt.Errorf("service has unknown type: %s", lbService.Spec.Type)
 	}
-	if len(externalIPs) == 0 {
-		return nil, ErrLBNotReady
+	if len(candidates) == 0 {
+		if lbErr != nil {
+			return nil, lbErr
+		}
+		return nil, errNoJoinServer
 	}
-	return externalIPs, nil
+	return candidates, nil
 }
 
-func getJoinServer(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, thisGroup *meshv1.NodeGroup) (string, error) {
+var (
+	// errNoJoinServer is returned when none of the bootstrap groups of a
+	// mesh can be joined through yet.
+	errNoJoinServer = errors.New("no join server found")
+	// errNoBootstrapGroups is returned before the bootstrap groups of a
+	// mesh are created.
+	errNoBootstrapGroups = errors.New("no bootstrap node group found")
+)
+
+// joinServerPending returns true if the error of getJoinServerCandidates
+// means the mesh cannot be joined yet, rather than that the candidates could
+// not be read.
+func joinServerPending(err error) bool {
+	return errors.Is(err, errNoJoinServer) ||
+		errors.Is(err, errNoBootstrapGroups) ||
+		errors.Is(err, ErrLBNotReady) ||
+		apierrors.IsNotFound(err)
+}
+
+// listBootstrapGroups returns the bootstrap groups of the mesh.
+func listBootstrapGroups(ctx context.Context, cli client.Client, mesh *meshv1.Mesh) ([]meshv1.NodeGroup, error) {
 	// TODO: We should technically list all node groups
-	var bootstrapGroup meshv1.NodeGroupList
-	err := cli.List(ctx, &bootstrapGroup,
+	var bootstrapGroups meshv1.NodeGroupList
+	err := cli.List(ctx, &bootstrapGroups,
 		client.InNamespace(mesh.GetNamespace()),
 		client.MatchingLabels(meshv1.MeshBootstrapGroupSelector(mesh)))
 	if err != nil {
-		return "", fmt.Errorf("list bootstrap node group: %w", err)
+		return nil, fmt.Errorf("list bootstrap node group: %w", err)
 	}
-	if len(bootstrapGroup.Items) == 0 {
-		return "", fmt.Errorf("no bootstrap node group found")
+	if len(bootstrapGroups.Items) == 0 {
+		return nil, errNoBootstrapGroups
 	}
-	for _, group := range bootstrapGroup.Items {
-		if group.Name == thisGroup.Name {
+	return bootstrapGroups.Items, nil
+}
+
+// getLBJoinServers returns the load balancer addresses of the exposed groups,
+// except for the one named exclude. The last error reading a load balancer is
+// returned along with the addresses that could be read.
+func getLBJoinServers(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, groups []meshv1.NodeGroup, exclude string) ([]string, error) {
+	var candidates []string
+	var lbErr error
+	for _, group := range groups {
+		if group.Name == exclude || group.Spec.Cluster == nil || group.Spec.Cluster.Service == nil {
 			continue
 		}
-		if group.Spec.Cluster.Service != nil {
-			externalURLs, err := getLBExternalIPs(ctx, cli, mesh, &group)
-			if err != nil {
-				return "", fmt.Errorf("get load balancer external IP: %w", err)
-			}
-			return fmt.Sprintf(`%s:%d`, externalURLs[0], group.Spec.Cluster.Service.GRPCPort), nil
+		externalURLs, err := getLBExternalIPs(ctx, cli, mesh, &group)
+		if err != nil {
+			lbErr = fmt.Errorf("get load balancer external IP: %w", err)
+			continue
+		}
+		for _, addr := range externalURLs {
+			candidates = append(candidates, net.JoinHostPort(addr, strconv.Itoa(int(group.Spec.Cluster.Service.GRPCPort))))
 		}
 	}
-	// Fall back to headless service only if this is one of the bootstrap groups
-	var joinServer string
-	if labels := thisGroup.GetLabels(); labels != nil && labels[meshv1.BootstrapNodeGroupLabel] == "true" {
-		for _, group := range bootstrapGroup.Items {
-			if group.Name == thisGroup.Name {
-				continue
-			}
-			joinServer = fmt.Sprintf(`%s:%d`, meshv1.MeshNodeGroupHeadlessServiceFQDN(mesh, &group), meshv1.DefaultGRPCPort)
+	return candidates, lbErr
+}
+
+// probeJoinServers returns the first of the candidates that completes a TLS
+// handshake with the admin certificate of the mesh.
+func probeJoinServers(ctx context.Context, cli client.Client, mesh *meshv1.Mesh, candidates []string) (string, error) {
+	var admin corev1.Secret
+	err := cli.Get(ctx, client.ObjectKey{
+		Name:      meshv1.MeshAdminCertName(mesh),
+		Namespace: mesh.GetNamespace(),
+	}, &admin)
+	if err != nil {
+		return "", fmt.Errorf("get admin certificate secret: %w", err)
+	}
+	log := log.FromContext(ctx)
+	var errs []error
+	for _, candidate := range candidates {
+		if err := probeJoinServer(ctx, candidate, &admin); err != nil {
+			log.Info("join server probe failed, trying the next candidate", "address", candidate, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", candidate, err))
+			continue
 		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("no reachable join server: %w", errors.Join(errs...))
+}
+
+// probeJoinServer completes a TLS handshake with the join server at addr.
+// Only the certificate chain is verified against the mesh CA, since the
+// certificates of the nodes may not cover the addresses of load balancers
+// yet.
+func probeJoinServer(ctx context.Context, addr string, admin *corev1.Secret) error {
+	keyPair, err := tls.X509KeyPair(admin.Data[corev1.TLSCertKey], admin.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("load admin key pair: %w", err)
 	}
-	if joinServer == "" {
-		return "", fmt.Errorf("no join server found")
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(admin.Data[cmmeta.TLSCAKey]) {
+		return fmt.Errorf("no CA certificates found in admin certificate secret")
 	}
-	return joinServer, nil
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: joinServerProbeTimeout},
+		Config: &tls.Config{
+			Certificates:       []tls.Certificate{keyPair},
+			NextProtos:         []string{"h2"},
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) == 0 {
+					return fmt.Errorf("no certificate presented")
+				}
+				intermediates := x509.NewCertPool()
+				for _, cert := range state.PeerCertificates[1:] {
+					intermediates.AddCert(cert)
+				}
+				_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+					Roots:         roots,
+					Intermediates: intermediates,
+				})
+				return err
+			},
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, joinServerProbeTimeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// detectServiceIPFamilies determines the IP families supported by the service
+// CIDRs of the cluster. The primary family is taken from the API server's
+// service and dual-stack support is probed with a dry-run service. If the
+// API server's service cannot be read, empty families are returned.
+func detectServiceIPFamilies(ctx context.Context, cli client.Client, namespace string) (meshv1.ServiceIPFamilies, error) {
+	var apiserver corev1.Service
+	err := cli.Get(ctx, client.ObjectKey{Name: "kubernetes", Namespace: metav1.NamespaceDefault}, &apiserver)
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			// Restricted roles cannot read outside of their namespaces,
+			// leave the families to the cluster defaults
+			return meshv1.ServiceIPFamilies{}, nil
+		}
+		return meshv1.ServiceIPFamilies{}, fmt.Errorf("fetch kubernetes service: %w", err)
+	}
+	if len(apiserver.Spec.IPFamilies) == 0 {
+		return meshv1.ServiceIPFamilies{}, fmt.Errorf("kubernetes service has no IP families")
+	}
+	probe := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "webmesh-ipfamily-probe-",
+			Namespace:    namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:           corev1.ServiceTypeClusterIP,
+			IPFamilyPolicy: pointer(corev1.IPFamilyPolicyRequireDualStack),
+			Ports:          []corev1.ServicePort{{Port: meshv1.DefaultGRPCPort}},
+		},
+	}
+	err = cli.Create(ctx, probe, client.DryRunAll)
+	switch {
+	case err == nil:
+		return meshv1.ServiceIPFamilies{
+			IPFamilyPolicy: pointer(corev1.IPFamilyPolicyPreferDualStack),
+		}, nil
+	case apierrors.IsInvalid(err):
+		return meshv1.ServiceIPFamilies{
+			IPFamilyPolicy: pointer(corev1.IPFamilyPolicySingleStack),
+			IPFamilies:     apiserver.Spec.IPFamilies[:1],
+		}, nil
+	default:
+		return meshv1.ServiceIPFamilies{}, fmt.Errorf("probe dual-stack support: %w", err)
+	}
+}
+
+// getGoogleClientOptions returns the client options for the Google Cloud APIs
+// using the credentials in the given secret. If creds is nil, workload identity
+// is assumed.
+func getGoogleClientOptions(ctx context.Context, cli client.Client, namespace string, creds *corev1.SecretKeySelector) ([]option.ClientOption, error) {
+	key, err := getGoogleCredentials(ctx, cli, namespace, creds)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return []option.ClientOption{option.WithCredentialsJSON(key)}, nil
+}
+
+// getGoogleCredentials returns the credentials JSON in the given secret, or
+// nil if creds is nil.
+func getGoogleCredentials(ctx context.Context, cli client.Client, namespace string, creds *corev1.SecretKeySelector) ([]byte, error) {
+	if creds == nil {
+		// We assume workload identity is enabled
+		return nil, nil
+	}
+	var secret corev1.Secret
+	err := cli.Get(ctx, client.ObjectKey{
+		Name:      creds.Name,
+		Namespace: namespace,
+	}, &secret)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := secret.Data[creds.Key]
+	if !ok {
+		return nil, fmt.Errorf("no key %s in secret %s/%s", creds.Key, namespace, creds.Name)
+	}
+	return key, nil
+}
+
+// requeueOnApplyBudget requeues a reconcile through the rate limiter when its
+// apply budget was used up before all of its objects were applied.
+func requeueOnApplyBudget(ctx context.Context, res ctrl.Result, err error) (ctrl.Result, error) {
+	if errors.Is(err, resources.ErrApplyBudgetExhausted) {
+		log.FromContext(ctx).Info("Apply budget exhausted, requeueing")
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return res, err
 }
 
 func pointer[T any](v T) *T {
 	return &v
 }
+
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
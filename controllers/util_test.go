@@ -0,0 +1,238 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	meshv1 "github.com/webmeshproj/operator/api/v1"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
+)
+
+func TestGetJoinServer(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	mesh := &meshv1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Namespace: "default"}}
+	admin := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshAdminCertName(mesh), Namespace: "default"},
+		Data:       ca.issue(t),
+	}
+
+	// The join server answers on 127.0.0.1, an impostor signed by another CA
+	// answers on 127.0.0.3 and nothing listens on 127.0.0.2
+	port := serveTestTLS(t, "127.0.0.1:0", ca, 0)
+	serveTestTLS(t, "127.0.0.3:0", other, port)
+
+	bootstrap := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap",
+			Namespace: "default",
+			Labels:    meshv1.MeshBootstrapGroupSelector(mesh),
+		},
+		Spec: meshv1.NodeGroupSpec{
+			Cluster: &meshv1.NodeGroupClusterConfig{
+				Service: &meshv1.NodeGroupLBConfig{GRPCPort: int32(port)},
+			},
+		},
+	}
+	lb := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: meshv1.MeshNodeGroupLBName(mesh, bootstrap), Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "127.0.0.2"}, {IP: "127.0.0.3"}, {IP: "127.0.0.1"}},
+		}},
+	}
+	group := &meshv1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: "default", UID: "workers"},
+	}
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, meshv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatalf("build scheme: %v", err)
+		}
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(admin, bootstrap, lb, group).
+		WithStatusSubresource(group).
+		Build()
+
+	addr := func(host string) string {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	joinServer, err := getJoinServer(context.Background(), cli, mesh, group)
+	if err != nil {
+		t.Fatalf("get join server: %v", err)
+	}
+	if joinServer != addr("127.0.0.1") {
+		t.Errorf("expected the first candidate answering with the mesh CA, got %s", joinServer)
+	}
+	var got meshv1.NodeGroup
+	if err := cli.Get(context.Background(), client.ObjectKeyFromObject(group), &got); err != nil {
+		t.Fatalf("get node group: %v", err)
+	}
+	if got.Status.JoinServer != addr("127.0.0.1") {
+		t.Errorf("expected the verified join server in the status, got %q", got.Status.JoinServer)
+	}
+
+	// Without probes the first candidate is used as is
+	ctx := operatorconfig.WithSettings(context.Background(), &operatorconfig.Settings{SkipJoinServerProbe: true})
+	joinServer, err = getJoinServer(ctx, cli, mesh, group)
+	if err != nil {
+		t.Fatalf("get join server without probes: %v", err)
+	}
+	if joinServer != addr("127.0.0.2") {
+		t.Errorf("expected the first candidate without probes, got %s", joinServer)
+	}
+
+	// A mesh without any reachable join server is an error
+	lb.Status.LoadBalancer.Ingress = lb.Status.LoadBalancer.Ingress[:2]
+	if err := cli.Status().Update(context.Background(), lb); err != nil {
+		t.Fatalf("update load balancer: %v", err)
+	}
+	if _, err := getJoinServer(context.Background(), cli, mesh, group); err == nil {
+		t.Error("expected an error without a reachable join server")
+	}
+}
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+// newTestCA returns a new self-signed certificate authority.
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "webmesh-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA certificate: %v", err)
+	}
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns the data of a TLS secret with a certificate signed by the CA.
+func (ca *testCA) issue(t *testing.T) map[string][]byte {
+	t.Helper()
+	return ca.issueUntil(t, time.Now().Add(time.Hour))
+}
+
+// issueUntil returns the data of a TLS secret with a certificate signed by
+// the CA that expires at notAfter.
+func (ca *testCA) issueUntil(t *testing.T, notAfter time.Time) map[string][]byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "webmesh-node"},
+		NotBefore:    time.Now(),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return map[string][]byte{
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		cmmeta.TLSCAKey:         ca.certPEM,
+	}
+}
+
+// serveTestTLS serves TLS handshakes at addr with a certificate issued by
+// the CA, on the given port if it is not zero. It returns the port.
+func serveTestTLS(t *testing.T, addr string, ca *testCA, port int) int {
+	t.Helper()
+	data := ca.issue(t)
+	keyPair, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatalf("load key pair: %v", err)
+	}
+	if port != 0 {
+		host, _, _ := net.SplitHostPort(addr)
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	ln, err := tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
@@ -17,23 +17,36 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	//+kubebuilder:scaffold:imports
 
 	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	meshv1 "github.com/webmeshproj/operator/api/v1"
 	"github.com/webmeshproj/operator/controllers"
+	"github.com/webmeshproj/operator/controllers/fairness"
+	"github.com/webmeshproj/operator/controllers/operatorconfig"
 	"github.com/webmeshproj/operator/controllers/version"
 )
 
@@ -55,6 +68,18 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var maxConcurrentReconciles int
+	var watchNamespaces string
+	var applyBudget int
+	var dryRun bool
+	var shardIndex, shardCount int
+	var warmupDuration time.Duration
+	var rolloutDebounce time.Duration
+	var providerConcurrency string
+	var clusterDomain string
+	var groupLabelKeys bool
+	var skipJoinServerProbe bool
+	var labelPrivilegedNamespaces bool
+	var images meshv1.Images
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -62,6 +87,40 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 3,
 		"Max number of concurrent reconciles")
+	flag.IntVar(&applyBudget, "apply-budget", 100,
+		"Max number of objects patched in a single reconcile. Zero is unlimited.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Simulate changes instead of making them. Objects are applied with a server-side dry run, "+
+			"cloud provider changes are logged, and only the status of the operator's objects is written.")
+	flag.IntVar(&shardIndex, "shard-index", 0, "Index of the shard of meshes reconciled by this replica.")
+	flag.IntVar(&shardCount, "shard-count", 1,
+		"Number of shards meshes are split across. Each shard elects its own leader.")
+	flag.DurationVar(&warmupDuration, "warmup-duration", 0,
+		"Duration over which the first reconciles after becoming leader are staggered.")
+	flag.DurationVar(&rolloutDebounce, "rollout-debounce", 2*time.Second,
+		"Duration node group changes are held for further changes, so edits made in quick succession roll the nodes once. "+
+			"Zero reconciles every change immediately.")
+	flag.StringVar(&providerConcurrency, "provider-concurrency", "",
+		"Comma-separated provider=limit pairs bounding concurrent cloud provider operations, e.g. google=2.")
+	flag.StringVar(&images.Node, "default-node-image", meshv1.DefaultNodeImage,
+		"Image used for nodes that do not set their own. May be referenced by tag, digest, or both.")
+	flag.StringVar(&images.MetricsProxy, "default-metrics-proxy-image", meshv1.DefaultMetricsProxyImage,
+		"Image of the sidecar serving node metrics over TLS. May be referenced by tag, digest, or both.")
+	flag.StringVar(&clusterDomain, "cluster-domain", os.Getenv("CLUSTER_DOMAIN"),
+		"DNS domain of the cluster. Defaults to the CLUSTER_DOMAIN environment variable, "+
+			"then to the domain found in /etc/resolv.conf, then to "+meshv1.DefaultClusterDomain+".")
+	flag.BoolVar(&groupLabelKeys, "group-label-keys", false,
+		"Select objects by label keys under the "+meshv1.GroupLabelPrefix+" prefix instead of "+meshv1.LegacyLabelPrefix+". "+
+			"Objects labeled with the legacy keys are migrated, recreating StatefulSets whose selectors use them.")
+	flag.BoolVar(&skipJoinServerProbe, "skip-join-server-probe", false,
+		"Render join servers into node configs without first probing them with a TLS handshake. "+
+			"Set this when the operator cannot reach the mesh.")
+	flag.BoolVar(&labelPrivilegedNamespaces, "label-privileged-namespaces", false,
+		"Label namespaces whose pod security level does not admit the pods of their cluster node groups "+
+			"to enforce the level the pods require. Requires the namespace-labeler role.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated list of namespaces to watch. Defaults to all namespaces. "+
+			"Set this when the controller runs with a namespace-scoped role.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -76,46 +135,212 @@ func main() {
 		"buildDate", version.BuildDate,
 	)
 
+	images.Default()
+	if err := images.Validate(field.NewPath("images")); err != nil {
+		setupLog.Error(err, "invalid default images")
+		os.Exit(1)
+	}
+	if clusterDomain == "" {
+		if data, err := os.ReadFile("/etc/resolv.conf"); err == nil {
+			clusterDomain = meshv1.ClusterDomainFromResolvConf(data)
+		}
+	}
+	clusterDomain, err := meshv1.NormalizeClusterDomain(clusterDomain)
+	if err != nil {
+		setupLog.Error(err, "invalid cluster domain")
+		os.Exit(1)
+	}
+	meshv1.SetGroupLabelKeys(groupLabelKeys)
+	if dryRun {
+		setupLog.Info("running in dry-run mode, changes will be simulated and not made")
+	}
+
+	shard, err := fairness.NewShard(shardIndex, shardCount)
+	if err != nil {
+		setupLog.Error(err, "invalid shard configuration")
+		os.Exit(1)
+	}
+	limits, err := fairness.ParseLimits(providerConcurrency)
+	if err != nil {
+		setupLog.Error(err, "invalid provider concurrency")
+		os.Exit(1)
+	}
+	warmup := fairness.NewWarmup(warmupDuration)
+	debounce := fairness.NewDebounce(rolloutDebounce)
+	limiter := fairness.NewLimiter(limits)
+
+	operatorNamespace := os.Getenv("POD_NAMESPACE")
+	var namespaces []string
+	if watchNamespaces != "" {
+		namespaces = strings.Split(watchNamespaces, ",")
+		// The operator config is read from the operator's namespace
+		if operatorNamespace != "" && !slices.Contains(namespaces, operatorNamespace) {
+			namespaces = append(namespaces, operatorNamespace)
+		}
+	}
+	// Only node pods are watched, don't cache every pod in the cluster. Node
+	// pods keep their legacy labels, so the legacy key selects them either way.
+	nodePods, err := labels.NewRequirement(meshv1.NodeGroupNameLabel, selection.Exists, nil)
+	if err != nil {
+		setupLog.Error(err, "unable to build node pod selector")
+		os.Exit(1)
+	}
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "ee5478b7.webmesh.io",
+		LeaderElectionID:       shard.LeaseName("ee5478b7.webmesh.io"),
 		Controller: config.Controller{
 			MaxConcurrentReconciles: maxConcurrentReconciles,
 		},
+		Cache: cache.Options{
+			Namespaces: namespaces,
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Pod{}: {Label: labels.NewSelector().Add(*nodePods)},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	ctx := ctrl.SetupSignalHandler()
+	settings := &operatorconfig.Store{
+		Reader:    mgr.GetClient(),
+		Namespace: operatorNamespace,
+		Base: operatorconfig.Settings{
+			Images:              images,
+			ClusterDomain:       clusterDomain,
+			ProviderConcurrency: limits,
+			MaxRetryBackoff:     operatorconfig.DefaultMaxRetryBackoff,
+			SkipJoinServerProbe: skipJoinServerProbe,
+		},
+		Limiter: limiter,
+	}
+	// Read the operator config before the webhooks start defaulting objects
+	effective, err := settings.Load(ctx, mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "unable to load operator config, using flags")
+		effective = &settings.Base
+	}
+	setupLog.Info("using default images", "node", effective.Images.Node, "metricsProxy", effective.Images.MetricsProxy)
+	setupLog.Info("using cluster domain", "domain", effective.ClusterDomain)
+
 	if err = (&controllers.MeshReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("mesh-controller"),
+		Namespaced:  len(namespaces) > 0,
+		ApplyBudget: applyBudget,
+		Shard:       shard,
+		Warmup:      warmup,
+		Limiter:     limiter,
+		DryRun:      dryRun,
+		Settings:    settings,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Mesh")
 		os.Exit(1)
 	}
 	if err = (&controllers.NodeGroupReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("nodegroup-controller"),
+		Namespaced:  len(namespaces) > 0,
+		ApplyBudget: applyBudget,
+		Shard:       shard,
+		Warmup:      warmup,
+		Debounce:    debounce,
+		Limiter:     limiter,
+		DryRun:      dryRun,
+		Settings:    settings,
+
+		LabelPrivilegedNamespaces: labelPrivilegedNamespaces,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NodeGroup")
 		os.Exit(1)
 	}
-	if err = (&meshv1.Mesh{}).SetupWebhookWithManager(mgr); err != nil {
+	if err = (&controllers.MeshPeeringReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Shard:    shard,
+		Warmup:   warmup,
+		DryRun:   dryRun,
+		Settings: settings,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MeshPeering")
+		os.Exit(1)
+	}
+	if err = (&controllers.MeshAccessRequestReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("meshaccessrequest-controller"),
+		Shard:    shard,
+		Warmup:   warmup,
+		DryRun:   dryRun,
+		Settings: settings,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MeshAccessRequest")
+		os.Exit(1)
+	}
+	if err = (&controllers.OperatorConfigReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("operatorconfig-controller"),
+		Settings: settings,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WebmeshOperatorConfig")
+		os.Exit(1)
+	}
+	if err = (&meshv1.Mesh{}).SetupWebhookWithManager(mgr, settings.Images); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "Mesh")
 		os.Exit(1)
 	}
-	if err = (&meshv1.NodeGroup{}).SetupWebhookWithManager(mgr); err != nil {
+	if err = (&meshv1.NodeGroup{}).SetupWebhookWithManager(mgr, settings.Images); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "NodeGroup")
 		os.Exit(1)
 	}
+	if err = (&meshv1.MeshPeering{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "MeshPeering")
+		os.Exit(1)
+	}
+	if err = (&meshv1.MeshAccessRequest{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "MeshAccessRequest")
+		os.Exit(1)
+	}
+	mgr.GetWebhookServer().Register(controllers.NodePodWebhookPath, controllers.NewNodePodWebhook(mgr.GetScheme()))
 	//+kubebuilder:scaffold:builder
 
+	if namespace := operatorNamespace; namespace != "" {
+		cli := mgr.GetClient()
+		if dryRun {
+			cli = controllers.NewDryRunClient(cli)
+		}
+		// Republish the defaults when the operator config changes them
+		settings.OnChange = func(ctx context.Context, changed *operatorconfig.Settings) {
+			if err := controllers.PublishDefaults(ctx, cli, namespace, changed.Images); err != nil {
+				setupLog.Error(err, "unable to publish operator defaults")
+			}
+		}
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			images, err := settings.Images(ctx)
+			if err != nil {
+				setupLog.Error(err, "unable to resolve default images")
+				return nil
+			}
+			if err := controllers.PublishDefaults(ctx, cli, namespace, images); err != nil {
+				setupLog.Error(err, "unable to publish operator defaults")
+			}
+			return nil
+		}))
+		if err != nil {
+			setupLog.Error(err, "unable to add defaults publisher")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -126,7 +351,7 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}